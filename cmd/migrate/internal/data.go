@@ -34,6 +34,7 @@ var TableMigrationOrder = []TableInfo{
 	{"tokens", &model.Token{}},
 	{"channels", &model.Channel{}},
 	{"redemptions", &model.Redemption{}},
+	{"channel_keys", &model.ChannelKey{}},
 	{"abilities", &model.Ability{}},
 	{"logs", &model.Log{}},
 	{"user_request_costs", &model.UserRequestCost{}},
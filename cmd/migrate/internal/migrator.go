@@ -227,6 +227,9 @@ func (m *Migrator) runAutoMigration() error {
 	if err := model.DB.AutoMigrate(&model.Redemption{}); err != nil {
 		return fmt.Errorf("failed to migrate Redemption: %w", err)
 	}
+	if err := model.DB.AutoMigrate(&model.ChannelKey{}); err != nil {
+		return fmt.Errorf("failed to migrate ChannelKey: %w", err)
+	}
 	if err := model.DB.AutoMigrate(&model.Ability{}); err != nil {
 		return fmt.Errorf("failed to migrate Ability: %w", err)
 	}
@@ -359,6 +362,7 @@ func (m *Migrator) fixPostgreSQLSequences() error {
 		"channels",
 		"options",
 		"redemptions",
+		"channel_keys",
 		"abilities",
 		"logs",
 		"user_request_costs",
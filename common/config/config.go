@@ -122,6 +122,24 @@ var PreConsumedQuota int64 = 500
 var ApproximateTokenEnabled = false
 var RetryTimes = 0
 
+// CircuitBreakerEnabled stops sending requests of a model to a channel which keeps failing on it
+var CircuitBreakerEnabled = false
+
+// CircuitBreakerFailureThreshold is the number of consecutive failures which open the circuit of a channel and model
+var CircuitBreakerFailureThreshold = 5
+
+// CircuitBreakerOpenSeconds is how long an open circuit rejects requests before letting a probe request through
+var CircuitBreakerOpenSeconds = 60
+
+// ChannelQueueSize is how many requests of each node could wait for a slot of a channel at its concurrency limit
+var ChannelQueueSize = 10
+
+// ChannelQueueTimeoutSeconds is how long a request waits for a slot of a channel at its concurrency limit
+var ChannelQueueTimeoutSeconds = 30
+
+// ResponseCacheQuotaRatio is the share of the normal quota charged for responses served from the response cache
+var ResponseCacheQuotaRatio = 0.1
+
 var RootUserEmail = ""
 
 var IsMasterNode = os.Getenv("NODE_TYPE") != "slave"
@@ -131,6 +149,15 @@ var RequestInterval = time.Duration(requestInterval) * time.Second
 
 var SyncFrequency = env.Int("SYNC_FREQUENCY", 10*60) // unit is second
 
+// PlanRenewFrequency is how often the master node grants the quota of subscription plans whose period is over
+var PlanRenewFrequency = env.Int("PLAN_RENEW_FREQUENCY", 60) // unit is second
+
+// LedgerReconcileFrequency is how often the master node reconciles the quota of users and tokens with the ledger, 0 disables it
+var LedgerReconcileFrequency = env.Int("LEDGER_RECONCILE_FREQUENCY", 60*60) // unit is second
+
+// LedgerReconcileFix is whether the reconciliation corrects the drifts it finds instead of only logging them
+var LedgerReconcileFix = env.Bool("LEDGER_RECONCILE_FIX", false)
+
 // ForceEmailTLSVerify is used to determine whether to force TLS verification for email
 var ForceEmailTLSVerify = env.Bool("FORCE_EMAIL_TLS_VERIFY", false)
 
@@ -205,3 +232,51 @@ var OpenrouterProviderSort = env.String("OPENROUTER_PROVIDER_SORT", "")
 
 // DefaultMaxToken is the default maximum number of tokens for requests
 var DefaultMaxToken = env.Int("DEFAULT_MAX_TOKEN", 2048)
+
+// FileStorageType is the backend of uploaded files, should be one of local/s3
+var FileStorageType = env.String("FILE_STORAGE_TYPE", "local")
+
+// FileStorageLocalDir is the directory of uploaded files when FileStorageType is local
+var FileStorageLocalDir = env.String("FILE_STORAGE_LOCAL_DIR", "./files")
+
+// S3-compatible storage of uploaded files, used when FileStorageType is s3
+var (
+	FileStorageS3Endpoint  = env.String("FILE_STORAGE_S3_ENDPOINT", "")
+	FileStorageS3Region    = env.String("FILE_STORAGE_S3_REGION", "us-east-1")
+	FileStorageS3Bucket    = env.String("FILE_STORAGE_S3_BUCKET", "")
+	FileStorageS3AccessKey = env.String("FILE_STORAGE_S3_ACCESS_KEY", "")
+	FileStorageS3SecretKey = env.String("FILE_STORAGE_S3_SECRET_KEY", "")
+	// FileStorageS3PathStyle addresses the bucket in the path instead of the host, as MinIO does by default
+	FileStorageS3PathStyle = env.Bool("FILE_STORAGE_S3_PATH_STYLE", true)
+)
+
+// MaxFileSizeMB is the size limit of one uploaded file
+var MaxFileSizeMB = env.Int("MAX_FILE_SIZE_MB", 512)
+
+// UserFileStorageLimitMB is the total size limit of files uploaded by one user, 0 means unlimited
+var UserFileStorageLimitMB = env.Int("USER_FILE_STORAGE_LIMIT_MB", 0)
+
+// FileQuotaPerMB is the quota charged for every started megabyte of uploaded files
+var FileQuotaPerMB = env.Int("FILE_QUOTA_PER_MB", 0)
+
+// BatchConcurrency is the maximum number of requests of batches relayed at the same time
+var BatchConcurrency = env.Int("BATCH_CONCURRENCY", 8)
+
+// BatchPollInterval is the interval in seconds to look for batches to run
+var BatchPollInterval = env.Int("BATCH_POLL_INTERVAL", 10)
+
+// BatchMaxRequests is the maximum number of requests in one batch
+var BatchMaxRequests = env.Int("BATCH_MAX_REQUESTS", 50000)
+
+// ResponseCacheTTL is how long in seconds a response stays in the response cache
+var ResponseCacheTTL = env.Int("RESPONSE_CACHE_TTL", 3600)
+
+// ResponseCacheMaxEntries is the number of responses kept by the in-memory response cache, used when Redis is disabled
+var ResponseCacheMaxEntries = env.Int("RESPONSE_CACHE_MAX_ENTRIES", 10000)
+
+// ResponseCacheMaxEntrySizeKB is the size limit of one cached response, larger responses are not cached
+var ResponseCacheMaxEntrySizeKB = env.Int("RESPONSE_CACHE_MAX_ENTRY_SIZE_KB", 512)
+
+// RoutingStatsHalfLife is the time in seconds for the latency and success rate of a channel
+// to lose half of their effect on adaptive routing
+var RoutingStatsHalfLife = env.Int("ROUTING_STATS_HALF_LIFE", 300)
@@ -20,6 +20,8 @@ const (
 	Group               = "group"
 	ModelMapping        = "model_mapping"
 	ChannelName         = "channel_name"
+	ChannelKey          = "channel_key" // fingerprint of the key of a multi-key channel used by the request
+	MaxConcurrency      = "max_concurrency"
 	ContentType         = "content_type"
 	TokenId             = "token_id"
 	TokenName           = "token_name"
@@ -32,4 +34,25 @@ const (
 	SystemPrompt        = "system_prompt"
 	Meta                = "meta"
 	RateLimit           = "rate_limit"
+	// ResponseAPIId is the id of the Response API object returned by upstream
+	ResponseAPIId = "response_api_id"
+	// ResponseCacheMode is the response cache mode set on the token
+	ResponseCacheMode = "response_cache_mode"
+	// ModelFallbacks is the JSON of the model fallback chains set on the token
+	ModelFallbacks = "model_fallbacks"
+	// HedgeDelay is the hedge delay in milliseconds set on the token
+	HedgeDelay = "hedge_delay"
+	// TokenRPMLimit and TokenTPMLimit are the requests and tokens per minute allowed to the token
+	TokenRPMLimit = "token_rpm_limit"
+	TokenTPMLimit = "token_tpm_limit"
+	// TokenSpendLimits is the model.SpendLimits of the token
+	TokenSpendLimits = "token_spend_limits"
+	// UsageTokens is the number of tokens used by the request, reported by the channel
+	UsageTokens = "usage_tokens"
+	// SessionKey is the sticky session key of the request, which routes it to the channel of its session
+	SessionKey = "session_key"
+	// HedgeAttempt is the *hedge.Attempt of a hedged request
+	HedgeAttempt = "hedge_attempt"
+	// ResponseCacheHit marks requests answered by the response cache without calling the channel
+	ResponseCacheHit = "response_cache_hit"
 )
@@ -42,6 +42,10 @@ type MetricsRecorder interface {
 	// Model metrics
 	RecordModelUsage(modelName, channelType string, latency time.Duration)
 
+	// Circuit breaker metrics
+	UpdateCircuitBreakerState(channelId int, model, state string)
+	RecordCircuitBreakerTrip(channelId int, model string)
+
 	// System metrics
 	InitSystemMetrics(version, buildTime, goVersion string, startTime time.Time)
 }
@@ -72,6 +76,8 @@ func (n *NoOpRecorder) RecordTokenAuth(success bool)
 func (n *NoOpRecorder) UpdateActiveTokens(userId, tokenName string, count int)                      {}
 func (n *NoOpRecorder) RecordError(errorType, component string)                                     {}
 func (n *NoOpRecorder) RecordModelUsage(modelName, channelType string, latency time.Duration)       {}
+func (n *NoOpRecorder) UpdateCircuitBreakerState(channelId int, model, state string)                {}
+func (n *NoOpRecorder) RecordCircuitBreakerTrip(channelId int, model string)                        {}
 func (n *NoOpRecorder) InitSystemMetrics(version, buildTime, goVersion string, startTime time.Time) {}
 
 // Initialize with no-op recorder by default
@@ -0,0 +1,197 @@
+// Package schedule evaluates cron-style availability windows.
+//
+// A window is a cron expression of five fields, minute, hour, day of month, month and day of week,
+// and a time is inside the window when its minute matches the expression. For example "* 9-17 * * 1-5"
+// is business hours and "* 0-6,22-23 * * *" is nights. Fields support *, lists, ranges and steps,
+// e.g. "*/15", "1-5" or "1,3,5", and months and days of week also support names, e.g. "jan" or "mon-fri".
+// Windows are evaluated in the local time zone of the server unless the expression is prefixed by
+// CRON_TZ=<zone>, e.g. "CRON_TZ=Asia/Shanghai * 9-17 * * 1-5".
+package schedule
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+)
+
+// Window is a parsed availability window, see the package documentation
+type Window struct {
+	expr     string
+	location *time.Location
+	minute   uint64
+	hour     uint64
+	dom      uint64
+	month    uint64
+	dow      uint64
+	// domAny and dowAny are set when the day fields are *, a day matches when either restricted field matches
+	domAny bool
+	dowAny bool
+}
+
+type field struct {
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minuteField = field{min: 0, max: 59}
+	hourField   = field{min: 0, max: 23}
+	domField    = field{min: 1, max: 31}
+	monthField  = field{min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// dowField accepts 7 for Sunday as well as 0
+	dowField = field{min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+// ParseWindow parses a cron-style availability window
+func ParseWindow(expr string) (*Window, error) {
+	window := &Window{expr: expr, location: time.Local}
+	spec := strings.TrimSpace(expr)
+	if strings.HasPrefix(spec, "CRON_TZ=") {
+		zone, rest, _ := strings.Cut(strings.TrimPrefix(spec, "CRON_TZ="), " ")
+		location, err := time.LoadLocation(zone)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid time zone of window %q", expr)
+		}
+		window.location, spec = location, rest
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, errors.Errorf("window %q must have 5 fields, minute, hour, day of month, month and day of week", expr)
+	}
+
+	var err error
+	if window.minute, err = minuteField.parse(fields[0]); err != nil {
+		return nil, errors.Wrapf(err, "invalid minute of window %q", expr)
+	}
+	if window.hour, err = hourField.parse(fields[1]); err != nil {
+		return nil, errors.Wrapf(err, "invalid hour of window %q", expr)
+	}
+	if window.dom, err = domField.parse(fields[2]); err != nil {
+		return nil, errors.Wrapf(err, "invalid day of month of window %q", expr)
+	}
+	if window.month, err = monthField.parse(fields[3]); err != nil {
+		return nil, errors.Wrapf(err, "invalid month of window %q", expr)
+	}
+	if window.dow, err = dowField.parse(fields[4]); err != nil {
+		return nil, errors.Wrapf(err, "invalid day of week of window %q", expr)
+	}
+	if window.dow&(1<<7) != 0 {
+		window.dow |= 1
+	}
+	window.domAny, window.dowAny = fields[2] == "*", fields[4] == "*"
+	return window, nil
+}
+
+// parse returns the bit set of the values of f matched by expr
+func (f field) parse(expr string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(expr, ",") {
+		rangeExpr, stepExpr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepExpr); err != nil || step <= 0 {
+				return 0, errors.Errorf("invalid step %q", stepExpr)
+			}
+		}
+
+		low, high := f.min, f.max
+		if rangeExpr != "*" {
+			lowExpr, highExpr, isRange := strings.Cut(rangeExpr, "-")
+			var err error
+			if low, err = f.value(lowExpr); err != nil {
+				return 0, err
+			}
+			high = low
+			if isRange {
+				if high, err = f.value(highExpr); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				high = f.max
+			}
+			if low > high {
+				return 0, errors.Errorf("invalid range %q", rangeExpr)
+			}
+		}
+
+		for v := low; v <= high; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// value parses a single value of f, which is a number or a name
+func (f field) value(expr string) (int, error) {
+	if v, ok := f.names[strings.ToLower(expr)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(expr)
+	if err != nil || v < f.min || v > f.max {
+		return 0, errors.Errorf("invalid value %q, expecting %d-%d", expr, f.min, f.max)
+	}
+	return v, nil
+}
+
+// Contains reports whether t is inside the window
+func (w *Window) Contains(t time.Time) bool {
+	t = t.In(w.location)
+	if w.minute&(1<<uint(t.Minute())) == 0 ||
+		w.hour&(1<<uint(t.Hour())) == 0 ||
+		w.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+
+	domMatch := w.dom&(1<<uint(t.Day())) != 0
+	dowMatch := w.dow&(1<<uint(t.Weekday())) != 0
+	if w.domAny || w.dowAny {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+func (w *Window) String() string {
+	return w.expr
+}
+
+// Windows is a set of windows, a time is inside it when it is inside any of them.
+// An empty set has no restriction, every time is inside it.
+type Windows []*Window
+
+// ParseWindows parses each of exprs, see ParseWindow
+func ParseWindows(exprs []string) (Windows, error) {
+	windows := make(Windows, 0, len(exprs))
+	for _, expr := range exprs {
+		window, err := ParseWindow(expr)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
+
+// Contains reports whether t is inside any of the windows, or whether there are no windows
+func (ws Windows) Contains(t time.Time) bool {
+	if len(ws) == 0 {
+		return true
+	}
+	for _, window := range ws {
+		if window.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Clock tells the time windows are evaluated at, it is injected so that tests could control it
+type Clock func() time.Time
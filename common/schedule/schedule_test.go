@@ -0,0 +1,78 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWindow_Contains(t *testing.T) {
+	// 2025-01-06 is a Monday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2025, 1, day, hour, minute, 30, 0, time.UTC)
+	}
+	tests := []struct {
+		expr     string
+		t        time.Time
+		expected bool
+	}{
+		{"* * * * *", at(6, 12, 0), true},
+		{"* 9-17 * * 1-5", at(6, 9, 0), true},
+		{"* 9-17 * * 1-5", at(6, 17, 59), true},
+		{"* 9-17 * * 1-5", at(6, 18, 0), false},
+		{"* 9-17 * * mon-fri", at(5, 12, 0), false},
+		{"* 0-6,22-23 * * *", at(6, 23, 10), true},
+		{"* 0-6,22-23 * * *", at(6, 7, 0), false},
+		{"*/15 * * * *", at(6, 3, 45), true},
+		{"*/15 * * * *", at(6, 3, 46), false},
+		{"10/20 * * * *", at(6, 3, 50), true},
+		{"* * * jan sun", at(5, 1, 0), true},
+		{"* * * * 7", at(5, 1, 0), true},
+		{"* * * feb *", at(5, 1, 0), false},
+		// days of month and of week both restricted match either of them
+		{"* * 1 * 1", at(6, 0, 0), true},
+		{"* * 1 * 1", at(1, 0, 0), true},
+		{"* * 1 * 1", at(7, 0, 0), false},
+		{"CRON_TZ=Asia/Shanghai * 9-17 * * *", at(6, 1, 0), true},
+		{"CRON_TZ=Asia/Shanghai * 9-17 * * *", at(6, 10, 0), false},
+	}
+	for _, tt := range tests {
+		window, err := ParseWindow(tt.expr)
+		require.NoError(t, err, tt.expr)
+		assert.Equal(t, tt.expected, window.Contains(tt.t), "%s at %s", tt.expr, tt.t)
+	}
+}
+
+func TestParseWindow_Invalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"* 17-9 * * *",
+		"*/0 * * * *",
+		"* * * * funday",
+		"CRON_TZ=Mars/Olympus * * * * *",
+	} {
+		_, err := ParseWindow(expr)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestWindows_Contains(t *testing.T) {
+	noon := time.Date(2025, 1, 6, 12, 0, 0, 0, time.UTC)
+	assert.True(t, Windows(nil).Contains(noon))
+
+	windows, err := ParseWindows([]string{"CRON_TZ=UTC * 0-6 * * *", "CRON_TZ=UTC * 12 * * *"})
+	require.NoError(t, err)
+	assert.True(t, windows.Contains(noon))
+	assert.False(t, windows.Contains(noon.Add(time.Hour)))
+
+	_, err = ParseWindows([]string{"* * * * *", "bad"})
+	assert.Error(t, err)
+}
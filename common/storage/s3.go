@@ -0,0 +1,171 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// unsignedPayload skips hashing the body, which would require reading uploads twice
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// S3Config is the configuration of an S3-compatible storage, such as AWS S3, MinIO or R2
+type S3Config struct {
+	// Endpoint is the base url of the service, such as https://s3.us-east-1.amazonaws.com or http://127.0.0.1:9000
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PathStyle puts the bucket in the path (endpoint/bucket/key) instead of the host (bucket.endpoint/key)
+	PathStyle bool
+}
+
+// S3 stores objects in a bucket of an S3-compatible service.
+//
+// Only the object APIs are used, requests are signed with AWS signature version 4.
+type S3 struct {
+	cfg      S3Config
+	endpoint *url.URL
+	signer   *v4.Signer
+	client   *http.Client
+}
+
+// NewS3 creates a S3 storage, the bucket must exist
+func NewS3(cfg S3Config) (*S3, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, errors.New("endpoint and bucket are required")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+
+	endpoint, err := url.Parse(strings.TrimSuffix(cfg.Endpoint, "/"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse endpoint %q", cfg.Endpoint)
+	}
+	if endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, errors.Errorf("endpoint %q should be an absolute url", cfg.Endpoint)
+	}
+
+	return &S3{
+		cfg:      cfg,
+		endpoint: endpoint,
+		signer: v4.NewSigner(func(o *v4.SignerOptions) {
+			// s3 expects the path to be escaped only once
+			o.DisableURIPathEscaping = true
+		}),
+		client: &http.Client{Timeout: 10 * time.Minute},
+	}, nil
+}
+
+func (s *S3) objectURL(key string) (string, error) {
+	if err := validateKey(key); err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	u := *s.endpoint
+	u.RawPath = ""
+	if s.cfg.PathStyle {
+		u.Path = fmt.Sprintf("%s/%s/%s", u.Path, s.cfg.Bucket, key)
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+		u.Path = fmt.Sprintf("%s/%s", u.Path, key)
+	}
+
+	return u.String(), nil
+}
+
+// do signs and sends a request of the object of key
+func (s *S3) do(ctx context.Context, method, key string, body io.Reader, size int64, contentType string) (*http.Response, error) {
+	objectURL, err := s.objectURL(key)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, objectURL, body)
+	if err != nil {
+		return nil, errors.Wrap(err, "create request")
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+
+	credentials := aws.Credentials{AccessKeyID: s.cfg.AccessKey, SecretAccessKey: s.cfg.SecretKey}
+	if err = s.signer.SignHTTP(ctx, credentials, req, unsignedPayload, "s3", s.cfg.Region, time.Now()); err != nil {
+		return nil, errors.Wrap(err, "sign request")
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s %s", method, key)
+	}
+
+	return resp, nil
+}
+
+// responseError reads the error returned by the service
+func responseError(resp *http.Response) error {
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return errors.Errorf("s3 returns status %d: %s", resp.StatusCode, string(body))
+}
+
+func (s *S3) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	if size < 0 {
+		return errors.New("size is required by s3")
+	}
+
+	resp, err := s.do(ctx, http.MethodPut, key, r, size, contentType)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return errors.Wrap(resp.Body.Close(), "close response body")
+}
+
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, 0, "")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		_ = resp.Body.Close()
+		return nil, ErrNotFound
+	default:
+		return nil, responseError(resp)
+	}
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, 0, "")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return errors.Wrap(resp.Body.Close(), "close response body")
+	default:
+		return responseError(resp)
+	}
+}
@@ -0,0 +1,71 @@
+// Package storage keeps the blobs of uploaded files.
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Laisky/errors/v2"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+// ErrNotFound is returned when the object of a key does not exist
+var ErrNotFound = errors.New("object not found")
+
+// Storage is a flat key-value blob store, keys are slash separated paths
+type Storage interface {
+	// Put writes the object of key, size is the exact length of r
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get opens the object of key, ErrNotFound is returned if it does not exist
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the object of key, deleting a missing object is not an error
+	Delete(ctx context.Context, key string) error
+}
+
+// Default is the storage of uploaded files, set by Init
+var Default Storage
+
+// Init creates Default according to the configuration
+func Init() error {
+	switch strings.ToLower(config.FileStorageType) {
+	case "", "local":
+		Default = NewLocal(config.FileStorageLocalDir)
+		logger.SysLog(fmt.Sprintf("uploaded files are stored in %s", config.FileStorageLocalDir))
+	case "s3":
+		s3, err := NewS3(S3Config{
+			Endpoint:  config.FileStorageS3Endpoint,
+			Region:    config.FileStorageS3Region,
+			Bucket:    config.FileStorageS3Bucket,
+			AccessKey: config.FileStorageS3AccessKey,
+			SecretKey: config.FileStorageS3SecretKey,
+			PathStyle: config.FileStorageS3PathStyle,
+		})
+		if err != nil {
+			return errors.Wrap(err, "create s3 storage")
+		}
+		Default = s3
+		logger.SysLog(fmt.Sprintf("uploaded files are stored in s3 bucket %s", config.FileStorageS3Bucket))
+	default:
+		return errors.Errorf("unknown FILE_STORAGE_TYPE %q", config.FileStorageType)
+	}
+
+	return nil
+}
+
+// validateKey rejects keys that could escape the storage root
+func validateKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") {
+		return errors.Errorf("invalid key %q", key)
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return errors.Errorf("invalid key %q", key)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testStorage checks the behaviors shared by all storages
+func testStorage(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	require.NoError(t, s.Put(ctx, "1/file-abc", strings.NewReader("hello"), 5, "text/plain"))
+
+	r, err := s.Get(ctx, "1/file-abc")
+	require.NoError(t, err)
+	content, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	assert.Equal(t, "hello", string(content))
+
+	require.NoError(t, s.Delete(ctx, "1/file-abc"))
+	_, err = s.Get(ctx, "1/file-abc")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// deleting a missing object is not an error
+	require.NoError(t, s.Delete(ctx, "1/file-abc"))
+
+	for _, key := range []string{"", "/etc/passwd", "../secret", "1/../../secret", "1//file"} {
+		assert.Error(t, s.Put(ctx, key, strings.NewReader("x"), 1, ""), key)
+	}
+}
+
+func TestLocal(t *testing.T) {
+	s := NewLocal(t.TempDir())
+	testStorage(t, s)
+
+	// the size mismatch is detected
+	assert.Error(t, s.Put(context.Background(), "1/file-short", strings.NewReader("abc"), 5, ""))
+	_, err := s.Get(context.Background(), "1/file-short")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+// fakeS3 is a minimal in-memory stand-in of MinIO which serves path style requests
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	t       *testing.T
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	assert.True(f.t, strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=ak/"),
+		"unexpected authorization %q", r.Header.Get("Authorization"))
+	assert.Equal(f.t, unsignedPayload, r.Header.Get("X-Amz-Content-Sha256"))
+	assert.NotEmpty(f.t, r.Header.Get("X-Amz-Date"))
+
+	if !strings.HasPrefix(r.URL.Path, "/bucket/") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+
+	switch r.Method {
+	case http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		f.objects[key] = body
+	case http.MethodGet:
+		body, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte("<Error><Code>NoSuchKey</Code></Error>"))
+			return
+		}
+		_, _ = w.Write(body)
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestS3(t *testing.T) {
+	server := httptest.NewServer(&fakeS3{objects: make(map[string][]byte), t: t})
+	defer server.Close()
+
+	s, err := NewS3(S3Config{
+		Endpoint:  server.URL,
+		Bucket:    "bucket",
+		AccessKey: "ak",
+		SecretKey: "sk",
+		PathStyle: true,
+	})
+	require.NoError(t, err)
+	testStorage(t, s)
+
+	// the size is required to set Content-Length
+	assert.Error(t, s.Put(context.Background(), "1/file-abc", strings.NewReader("hello"), -1, ""))
+}
+
+func TestS3ObjectURL(t *testing.T) {
+	s, err := NewS3(S3Config{Endpoint: "https://s3.example.com/", Bucket: "bucket"})
+	require.NoError(t, err)
+
+	u, err := s.objectURL("1/file-abc")
+	require.NoError(t, err)
+	assert.Equal(t, "https://bucket.s3.example.com/1/file-abc", u)
+
+	s.cfg.PathStyle = true
+	u, err = s.objectURL("1/file-abc")
+	require.NoError(t, err)
+	assert.Equal(t, "https://s3.example.com/bucket/1/file-abc", u)
+
+	_, err = NewS3(S3Config{Endpoint: "s3.example.com", Bucket: "bucket"})
+	assert.Error(t, err)
+}
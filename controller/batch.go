@@ -0,0 +1,249 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/model"
+)
+
+// https://platform.openai.com/docs/api-reference/batch
+
+// batchEndpoints are the endpoints that could be requested by batches
+var batchEndpoints = map[string]bool{
+	"/v1/chat/completions": true,
+	"/v1/completions":      true,
+	"/v1/embeddings":       true,
+	"/v1/moderations":      true,
+	"/v1/responses":        true,
+}
+
+// batchCompletionWindows maps the supported completion windows to seconds
+var batchCompletionWindows = map[string]int64{
+	"24h": 24 * 3600,
+}
+
+type CreateBatchRequest struct {
+	InputFileId      string            `json:"input_file_id"`
+	Endpoint         string            `json:"endpoint"`
+	CompletionWindow string            `json:"completion_window"`
+	Metadata         map[string]string `json:"metadata,omitempty"`
+}
+
+type OpenAIBatchRequestCounts struct {
+	Total     int `json:"total"`
+	Completed int `json:"completed"`
+	Failed    int `json:"failed"`
+}
+
+// OpenAIBatch is the batch object of the Batch API
+type OpenAIBatch struct {
+	Id               string                   `json:"id"`
+	Object           string                   `json:"object"`
+	Endpoint         string                   `json:"endpoint"`
+	Errors           json.RawMessage          `json:"errors"`
+	InputFileId      string                   `json:"input_file_id"`
+	CompletionWindow string                   `json:"completion_window"`
+	Status           string                   `json:"status"`
+	OutputFileId     *string                  `json:"output_file_id"`
+	ErrorFileId      *string                  `json:"error_file_id"`
+	CreatedAt        int64                    `json:"created_at"`
+	InProgressAt     *int64                   `json:"in_progress_at"`
+	ExpiresAt        *int64                   `json:"expires_at"`
+	FinalizingAt     *int64                   `json:"finalizing_at"`
+	CompletedAt      *int64                   `json:"completed_at"`
+	FailedAt         *int64                   `json:"failed_at"`
+	ExpiredAt        *int64                   `json:"expired_at"`
+	CancellingAt     *int64                   `json:"cancelling_at"`
+	CancelledAt      *int64                   `json:"cancelled_at"`
+	RequestCounts    OpenAIBatchRequestCounts `json:"request_counts"`
+	Metadata         json.RawMessage          `json:"metadata"`
+}
+
+// nullable converts the zero value to null
+func nullable[T comparable](v T) *T {
+	var zero T
+	if v == zero {
+		return nil
+	}
+	return &v
+}
+
+func rawJSONOrNull(s *string) json.RawMessage {
+	if s == nil || *s == "" {
+		return json.RawMessage("null")
+	}
+	return json.RawMessage(*s)
+}
+
+func toOpenAIBatch(batch *model.Batch) OpenAIBatch {
+	return OpenAIBatch{
+		Id:               batch.Id,
+		Object:           "batch",
+		Endpoint:         batch.Endpoint,
+		Errors:           rawJSONOrNull(batch.Errors),
+		InputFileId:      batch.InputFileId,
+		CompletionWindow: batch.CompletionWindow,
+		Status:           batch.Status,
+		OutputFileId:     nullable(batch.OutputFileId),
+		ErrorFileId:      nullable(batch.ErrorFileId),
+		CreatedAt:        batch.CreatedAt,
+		InProgressAt:     nullable(batch.InProgressAt),
+		ExpiresAt:        nullable(batch.ExpiresAt),
+		FinalizingAt:     nullable(batch.FinalizingAt),
+		CompletedAt:      nullable(batch.CompletedAt),
+		FailedAt:         nullable(batch.FailedAt),
+		ExpiredAt:        nullable(batch.ExpiredAt),
+		CancellingAt:     nullable(batch.CancellingAt),
+		CancelledAt:      nullable(batch.CancelledAt),
+		RequestCounts: OpenAIBatchRequestCounts{
+			Total:     batch.TotalCount,
+			Completed: batch.CompletedCount,
+			Failed:    batch.FailedCount,
+		},
+		Metadata: rawJSONOrNull(batch.Metadata),
+	}
+}
+
+// getUserBatch get the batch in path owned by the token's user
+func getUserBatch(c *gin.Context) (*model.Batch, bool) {
+	batchId := c.Param("id")
+	batch, err := model.GetUserBatchById(batchId, c.GetInt(ctxkey.Id))
+	if err != nil {
+		abortWithOpenAIError(c, http.StatusNotFound, "batch_id", fmt.Sprintf("No such Batch object: %s", batchId))
+		return nil, false
+	}
+
+	return batch, true
+}
+
+// CreateBatch creates a batch from an uploaded file of purpose batch, the batch is run in the background
+func CreateBatch(c *gin.Context) {
+	userId := c.GetInt(ctxkey.Id)
+
+	request := CreateBatchRequest{}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		abortWithOpenAIError(c, http.StatusBadRequest, "", "Invalid request: "+err.Error())
+		return
+	}
+	if !batchEndpoints[request.Endpoint] {
+		abortWithOpenAIError(c, http.StatusBadRequest, "endpoint", fmt.Sprintf("Unsupported endpoint: %q", request.Endpoint))
+		return
+	}
+	completionWindowSeconds, ok := batchCompletionWindows[request.CompletionWindow]
+	if !ok {
+		abortWithOpenAIError(c, http.StatusBadRequest, "completion_window",
+			fmt.Sprintf("Unsupported completion_window: %q", request.CompletionWindow))
+		return
+	}
+	inputFile, err := model.GetUserFileById(request.InputFileId, userId)
+	if err != nil {
+		abortWithOpenAIError(c, http.StatusNotFound, "input_file_id", fmt.Sprintf("No such File object: %s", request.InputFileId))
+		return
+	}
+	if inputFile.Purpose != "batch" {
+		abortWithOpenAIError(c, http.StatusBadRequest, "input_file_id", "The input file should be uploaded with purpose batch")
+		return
+	}
+	if !checkSpendLimits(c, 0) {
+		return
+	}
+
+	var metadata *string
+	if len(request.Metadata) > 0 {
+		data, _ := json.Marshal(request.Metadata)
+		metadataStr := string(data)
+		metadata = &metadataStr
+	}
+	batch := model.NewBatch(userId, c.GetInt(ctxkey.TokenId), request.Endpoint, request.InputFileId,
+		request.CompletionWindow, completionWindowSeconds, metadata)
+	if err = batch.Insert(); err != nil {
+		abortWithOpenAIError(c, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+	wakeUpBatchRunner()
+
+	c.JSON(http.StatusOK, toOpenAIBatch(batch))
+}
+
+// RetrieveBatch returns the batch object, which reports the progress
+func RetrieveBatch(c *gin.Context) {
+	batch, ok := getUserBatch(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, toOpenAIBatch(batch))
+}
+
+// ListBatches lists batches of the token's user
+func ListBatches(c *gin.Context) {
+	limit := 20
+	if rawLimit := c.Query("limit"); rawLimit != "" {
+		var err error
+		if limit, err = strconv.Atoi(rawLimit); err != nil || limit < 1 || limit > 100 {
+			abortWithOpenAIError(c, http.StatusBadRequest, "limit", "limit should be between 1 and 100")
+			return
+		}
+	}
+
+	batches, err := model.GetUserBatches(c.GetInt(ctxkey.Id), c.Query("after"), limit+1)
+	if err != nil {
+		abortWithOpenAIError(c, http.StatusBadRequest, "", err.Error())
+		return
+	}
+
+	hasMore := len(batches) > limit
+	if hasMore {
+		batches = batches[:limit]
+	}
+	data := make([]OpenAIBatch, 0, len(batches))
+	for _, batch := range batches {
+		data = append(data, toOpenAIBatch(batch))
+	}
+	response := gin.H{
+		"object":   "list",
+		"data":     data,
+		"has_more": hasMore,
+	}
+	if len(data) > 0 {
+		response["first_id"] = data[0].Id
+		response["last_id"] = data[len(data)-1].Id
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// CancelBatch asks the runner to stop the batch, requests in flight are still finished and billed
+func CancelBatch(c *gin.Context) {
+	batch, ok := getUserBatch(c)
+	if !ok {
+		return
+	}
+
+	cancelled, err := model.UpdateBatchStatus(batch.Id,
+		[]string{model.BatchStatusValidating, model.BatchStatusInProgress},
+		model.BatchStatusCancelling, map[string]any{"cancelling_at": helper.GetTimestamp()})
+	if err != nil {
+		abortWithOpenAIError(c, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+	if !cancelled {
+		abortWithOpenAIError(c, http.StatusConflict, "batch_id",
+			fmt.Sprintf("Cannot cancel a batch with status %s", batch.Status))
+		return
+	}
+	wakeUpBatchRunner()
+
+	if batch, err = model.GetBatchById(batch.Id); err != nil {
+		abortWithOpenAIError(c, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, toOpenAIBatch(batch))
+}
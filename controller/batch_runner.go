@@ -0,0 +1,594 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/random"
+	"github.com/songquanpeng/one-api/common/storage"
+	"github.com/songquanpeng/one-api/middleware"
+	"github.com/songquanpeng/one-api/model"
+)
+
+// batchRequestLine is a line of the input file of a batch
+type batchRequestLine struct {
+	CustomId string          `json:"custom_id"`
+	Method   string          `json:"method"`
+	Url      string          `json:"url"`
+	Body     json.RawMessage `json:"body"`
+}
+
+// batchOutputLine is a line of the output or the error file of a batch
+type batchOutputLine struct {
+	Id       string               `json:"id"`
+	CustomId string               `json:"custom_id"`
+	Response *batchOutputResponse `json:"response"`
+	Error    *batchOutputError    `json:"error"`
+}
+
+type batchOutputResponse struct {
+	StatusCode int             `json:"status_code"`
+	RequestId  string          `json:"request_id"`
+	Body       json.RawMessage `json:"body"`
+}
+
+type batchOutputError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Line    int    `json:"line,omitempty"`
+}
+
+// batchResponseWriter keeps the response of a request in memory
+type batchResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *batchResponseWriter) Header() http.Header { return w.header }
+
+func (w *batchResponseWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(data)
+}
+
+func (w *batchResponseWriter) WriteHeader(statusCode int) { w.status = statusCode }
+
+func (w *batchResponseWriter) Flush() {}
+
+// BatchRunner runs the requests of batches with a bounded number of workers.
+//
+// The progress of every batch is saved in the database, so that batches are resumed after restart.
+// Requests in flight when the process exits are sent again.
+type BatchRunner struct {
+	// handler serves the request of every line
+	handler http.Handler
+	// slots limits the number of requests in flight of all batches
+	slots  chan struct{}
+	wakeup chan struct{}
+
+	mu      sync.Mutex
+	running map[string]bool
+}
+
+// NewBatchRunner creates a runner which sends requests to handler
+func NewBatchRunner(handler http.Handler, concurrency int) *BatchRunner {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	return &BatchRunner{
+		handler: handler,
+		slots:   make(chan struct{}, concurrency),
+		wakeup:  make(chan struct{}, 1),
+		running: make(map[string]bool),
+	}
+}
+
+var defaultBatchRunner *BatchRunner
+
+// newBatchRelayEngine serves requests of batches like the /v1 routes,
+// the requests are authorized by the token that created the batch, and billed as usual.
+// Rate limits are skipped since batches are already throttled by BATCH_CONCURRENCY.
+func newBatchRelayEngine() *gin.Engine {
+	engine := gin.New()
+	engine.Use(middleware.RequestId(), middleware.RelayPanicRecover(), middleware.TokenAuth(), middleware.Distribute())
+	for endpoint := range batchEndpoints {
+		engine.POST(endpoint, Relay)
+	}
+
+	return engine
+}
+
+// StartBatchRunner runs batches in the background, it should only be started on the master node
+func StartBatchRunner() {
+	defaultBatchRunner = NewBatchRunner(newBatchRelayEngine(), config.BatchConcurrency)
+	go defaultBatchRunner.Run()
+}
+
+// wakeUpBatchRunner lets the runner look for batches immediately
+func wakeUpBatchRunner() {
+	if defaultBatchRunner == nil {
+		return
+	}
+
+	select {
+	case defaultBatchRunner.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// Run looks for active batches periodically and runs each of them in a goroutine
+func (r *BatchRunner) Run() {
+	interval := time.Duration(config.BatchPollInterval) * time.Second
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		ids, err := model.GetActiveBatchIds()
+		if err != nil {
+			logger.SysError("failed to get active batches: " + err.Error())
+		}
+		for _, id := range ids {
+			r.mu.Lock()
+			if r.running[id] {
+				r.mu.Unlock()
+				continue
+			}
+			r.running[id] = true
+			r.mu.Unlock()
+
+			go func(id string) {
+				defer func() {
+					r.mu.Lock()
+					delete(r.running, id)
+					r.mu.Unlock()
+				}()
+				if err := r.RunBatch(context.Background(), id); err != nil {
+					logger.SysError(fmt.Sprintf("failed to run batch %s: %+v", id, err))
+				}
+			}(id)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-r.wakeup:
+		}
+	}
+}
+
+// RunBatch moves the batch forward until it is finished
+func (r *BatchRunner) RunBatch(ctx context.Context, batchId string) error {
+	batch, err := model.GetBatchById(batchId)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if batch.Status == model.BatchStatusValidating {
+		if err = r.validateBatch(ctx, batch); err != nil {
+			return errors.WithStack(err)
+		}
+		if batch, err = model.GetBatchById(batchId); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	status := batch.Status
+	if status == model.BatchStatusInProgress {
+		if status, err = r.processBatch(ctx, batch); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	switch status {
+	case model.BatchStatusFinalizing, model.BatchStatusCancelling, model.BatchStatusExpired:
+		return errors.WithStack(r.finalizeBatch(ctx, batch, status))
+	default:
+		return nil
+	}
+}
+
+// readBatchInput calls fn with every non-empty line of the input file, index starts from 0
+func readBatchInput(ctx context.Context, batch *model.Batch, fn func(index int, line []byte) bool) error {
+	inputFile, err := model.GetUserFileById(batch.InputFileId, batch.UserId)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	content, err := storage.Default.Get(ctx, inputFile.StorageKey)
+	if err != nil {
+		return errors.Wrapf(err, "read input file %s", inputFile.Id)
+	}
+	defer content.Close()
+
+	reader := bufio.NewReader(content)
+	for index := 0; ; {
+		line, err := reader.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			if !fn(index, line) {
+				return nil
+			}
+			index++
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrapf(err, "read input file %s", inputFile.Id)
+		}
+	}
+}
+
+// parseBatchRequestLine parses and checks a line of the input file
+func parseBatchRequestLine(batch *model.Batch, line []byte) (*batchRequestLine, error) {
+	request := &batchRequestLine{}
+	if err := json.Unmarshal(line, request); err != nil {
+		return nil, errors.Wrap(err, "invalid JSON")
+	}
+	if request.CustomId == "" {
+		return nil, errors.New("custom_id is required")
+	}
+	if request.Method != http.MethodPost {
+		return nil, errors.Errorf("method should be POST, got %q", request.Method)
+	}
+	if request.Url != batch.Endpoint {
+		return nil, errors.Errorf("url should be %s, got %q", batch.Endpoint, request.Url)
+	}
+
+	body := struct {
+		Model  string `json:"model"`
+		Stream bool   `json:"stream"`
+	}{}
+	if err := json.Unmarshal(request.Body, &body); err != nil {
+		return nil, errors.Wrap(err, "body should be a JSON object")
+	}
+	if body.Model == "" {
+		return nil, errors.New("model is required in body")
+	}
+	if body.Stream {
+		return nil, errors.New("stream is not supported in batches")
+	}
+
+	return request, nil
+}
+
+// validateBatch checks all lines of the input file before any request is sent
+func (r *BatchRunner) validateBatch(ctx context.Context, batch *model.Batch) error {
+	var lineErrors []batchOutputError
+	customIds := make(map[string]bool)
+	total := 0
+	err := readBatchInput(ctx, batch, func(index int, line []byte) bool {
+		total++
+		request, err := parseBatchRequestLine(batch, line)
+		switch {
+		case err != nil:
+			lineErrors = append(lineErrors, batchOutputError{Code: "invalid_request", Message: err.Error(), Line: index + 1})
+		case customIds[request.CustomId]:
+			lineErrors = append(lineErrors, batchOutputError{Code: "duplicate_custom_id",
+				Message: fmt.Sprintf("custom_id %s is duplicated", request.CustomId), Line: index + 1})
+		default:
+			customIds[request.CustomId] = true
+		}
+
+		// stop early, the batch has failed anyway
+		return len(lineErrors) < 100
+	})
+	if err != nil {
+		lineErrors = append(lineErrors, batchOutputError{Code: "invalid_input_file", Message: err.Error()})
+	}
+	switch {
+	case total == 0 && len(lineErrors) == 0:
+		lineErrors = append(lineErrors, batchOutputError{Code: "empty_file", Message: "The input file is empty"})
+	case total > config.BatchMaxRequests:
+		lineErrors = append(lineErrors, batchOutputError{Code: "too_many_requests",
+			Message: fmt.Sprintf("The batch contains more than %d requests", config.BatchMaxRequests)})
+	}
+
+	now := helper.GetTimestamp()
+	if len(lineErrors) > 0 {
+		data, _ := json.Marshal(map[string]any{"object": "list", "data": lineErrors})
+		_, err = model.UpdateBatchStatus(batch.Id, []string{model.BatchStatusValidating}, model.BatchStatusFailed,
+			map[string]any{"errors": string(data), "failed_at": now})
+		return errors.WithStack(err)
+	}
+
+	_, err = model.UpdateBatchStatus(batch.Id, []string{model.BatchStatusValidating}, model.BatchStatusInProgress,
+		map[string]any{"total_count": total, "in_progress_at": now})
+	return errors.WithStack(err)
+}
+
+// batchLineKey is the storage key of the output of a line
+func batchLineKey(batch *model.Batch, index int) string {
+	return fmt.Sprintf("%d/%s/%d", batch.UserId, batch.Id, index)
+}
+
+// processBatch sends the requests that have not been finished,
+// and returns the status that the batch should be finalized with.
+func (r *BatchRunner) processBatch(ctx context.Context, batch *model.Batch) (string, error) {
+	finished, err := model.GetBatchLines(batch.Id)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	done := make(map[int]bool, len(finished))
+	for _, line := range finished {
+		done[line.LineIndex] = true
+	}
+
+	token, err := model.GetTokenById(batch.TokenId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// the token has been deleted, requests could not be authorized anymore
+		data, _ := json.Marshal(map[string]any{"object": "list", "data": []batchOutputError{
+			{Code: "token_not_found", Message: "The API key that created the batch has been deleted"},
+		}})
+		_, err = model.UpdateBatchStatus(batch.Id, []string{model.BatchStatusInProgress}, model.BatchStatusFailed,
+			map[string]any{"errors": string(data), "failed_at": helper.GetTimestamp()})
+		return model.BatchStatusFailed, errors.WithStack(err)
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "get token of batch")
+	}
+
+	status := model.BatchStatusFinalizing
+	var statusErr error
+	var wg sync.WaitGroup
+	err = readBatchInput(ctx, batch, func(index int, line []byte) bool {
+		if done[index] {
+			return true
+		}
+		if helper.GetTimestamp() > batch.ExpiresAt {
+			status = model.BatchStatusExpired
+			return false
+		}
+		current, err := model.GetBatchStatus(batch.Id)
+		if err != nil {
+			statusErr = err
+			return false
+		}
+		if current != model.BatchStatusInProgress {
+			// cancelled by user
+			status = model.BatchStatusCancelling
+			return false
+		}
+
+		r.slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-r.slots }()
+			r.processLine(ctx, batch, token.Key, index, line)
+		}()
+		return true
+	})
+	wg.Wait()
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	if statusErr != nil {
+		// the batch is resumed next time
+		return "", errors.WithStack(statusErr)
+	}
+
+	if status == model.BatchStatusFinalizing {
+		ok, err := model.UpdateBatchStatus(batch.Id, []string{model.BatchStatusInProgress}, model.BatchStatusFinalizing,
+			map[string]any{"finalizing_at": helper.GetTimestamp()})
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+		if !ok {
+			status = model.BatchStatusCancelling
+		}
+	}
+
+	return status, nil
+}
+
+// processLine relays the request of a line and saves its output
+func (r *BatchRunner) processLine(ctx context.Context, batch *model.Batch, tokenKey string, index int, line []byte) {
+	output := &batchOutputLine{Id: "batch_req_" + random.GetUUID()}
+	request, err := parseBatchRequestLine(batch, line)
+	if err != nil {
+		// the input file has been validated, it should not happen
+		output.Error = &batchOutputError{Code: "invalid_request", Message: err.Error(), Line: index + 1}
+	} else {
+		output.CustomId = request.CustomId
+		output.Response = r.relayLine(ctx, tokenKey, request)
+	}
+	isError := output.Error != nil || output.Response.StatusCode != http.StatusOK
+
+	data, err := json.Marshal(output)
+	if err == nil {
+		err = storage.Default.Put(ctx, batchLineKey(batch, index), bytes.NewReader(data), int64(len(data)), "application/json")
+	}
+	if err == nil {
+		err = model.RecordBatchLine(batch.Id, index, isError)
+	}
+	if err != nil {
+		// the line will be sent again when the batch is resumed
+		logger.SysError(fmt.Sprintf("failed to save line %d of batch %s: %+v", index, batch.Id, err))
+	}
+}
+
+// relayLine sends the request as if it is sent by the token that created the batch
+func (r *BatchRunner) relayLine(ctx context.Context, tokenKey string, request *batchRequestLine) *batchOutputResponse {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, request.Url, bytes.NewReader(request.Body))
+	if err != nil {
+		body, _ := json.Marshal(map[string]any{"error": map[string]any{"message": err.Error(), "type": "one_api_error"}})
+		return &batchOutputResponse{StatusCode: http.StatusInternalServerError, Body: body}
+	}
+	req.Header.Set("Authorization", "Bearer sk-"+tokenKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	w := &batchResponseWriter{header: make(http.Header)}
+	r.handler.ServeHTTP(w, req)
+
+	body := w.body.Bytes()
+	if !json.Valid(body) {
+		body, _ = json.Marshal(string(body))
+	}
+	return &batchOutputResponse{
+		StatusCode: w.status,
+		RequestId:  w.header.Get(helper.RequestIdKey),
+		Body:       body,
+	}
+}
+
+// finalizeBatch writes the output and the error files, and finishes the batch with status.
+// Requests that are not sent before the batch expires are reported in the error file.
+func (r *BatchRunner) finalizeBatch(ctx context.Context, batch *model.Batch, status string) error {
+	lines, err := model.GetBatchLines(batch.Id)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	output, err := os.CreateTemp("", "batch-output-*")
+	if err != nil {
+		return errors.Wrap(err, "create output file")
+	}
+	defer os.Remove(output.Name())
+	defer output.Close()
+	errorOutput, err := os.CreateTemp("", "batch-error-*")
+	if err != nil {
+		return errors.Wrap(err, "create error file")
+	}
+	defer os.Remove(errorOutput.Name())
+	defer errorOutput.Close()
+
+	done := make(map[int]bool, len(lines))
+	completedCount, failedCount := 0, 0
+	for _, line := range lines {
+		done[line.LineIndex] = true
+		w := io.Writer(output)
+		completedCount++
+		if line.IsError {
+			w = errorOutput
+			completedCount--
+			failedCount++
+		}
+		if err = copyBatchLine(ctx, w, batchLineKey(batch, line.LineIndex)); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	if status == model.BatchStatusExpired {
+		err = readBatchInput(ctx, batch, func(index int, line []byte) bool {
+			if done[index] {
+				return true
+			}
+			failedCount++
+			request := &batchRequestLine{}
+			_ = json.Unmarshal(line, request)
+			data, _ := json.Marshal(&batchOutputLine{
+				Id:       "batch_req_" + random.GetUUID(),
+				CustomId: request.CustomId,
+				Error: &batchOutputError{
+					Code:    "batch_expired",
+					Message: "This request could not be executed before the completion window expired.",
+				},
+			})
+			_, err := errorOutput.Write(append(data, '\n'))
+			return err == nil
+		})
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	outputFileId, err := saveBatchOutputFile(ctx, batch, output, "output")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	errorFileId, err := saveBatchOutputFile(ctx, batch, errorOutput, "error")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	now := helper.GetTimestamp()
+	// counters are recalculated in case requests are sent again after restart
+	fields := map[string]any{
+		"output_file_id":  outputFileId,
+		"error_file_id":   errorFileId,
+		"completed_count": completedCount,
+		"failed_count":    failedCount,
+	}
+	switch status {
+	case model.BatchStatusFinalizing:
+		status = model.BatchStatusCompleted
+		fields["completed_at"] = now
+	case model.BatchStatusCancelling:
+		status = model.BatchStatusCancelled
+		fields["cancelled_at"] = now
+	case model.BatchStatusExpired:
+		fields["expired_at"] = now
+	}
+	if _, err = model.UpdateBatchStatus(batch.Id, []string{
+		model.BatchStatusInProgress, model.BatchStatusFinalizing, model.BatchStatusCancelling,
+	}, status, fields); err != nil {
+		return errors.WithStack(err)
+	}
+
+	// the outputs of lines are no longer needed
+	for _, line := range lines {
+		if err = storage.Default.Delete(ctx, batchLineKey(batch, line.LineIndex)); err != nil {
+			logger.SysError(fmt.Sprintf("failed to delete line %d of batch %s: %+v", line.LineIndex, batch.Id, err))
+		}
+	}
+	return errors.WithStack(model.DeleteBatchLines(batch.Id))
+}
+
+func copyBatchLine(ctx context.Context, w io.Writer, key string) error {
+	content, err := storage.Default.Get(ctx, key)
+	if err != nil {
+		return errors.Wrapf(err, "read %s", key)
+	}
+	defer content.Close()
+
+	if _, err = io.Copy(w, content); err != nil {
+		return errors.Wrapf(err, "copy %s", key)
+	}
+	_, err = w.Write([]byte{'\n'})
+	return errors.WithStack(err)
+}
+
+// saveBatchOutputFile saves f as a file of the batch's user, empty files are skipped
+func saveBatchOutputFile(ctx context.Context, batch *model.Batch, f *os.File, kind string) (string, error) {
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return "", errors.Wrap(err, "get size of output")
+	}
+	if size == 0 {
+		return "", nil
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return "", errors.Wrap(err, "rewind output")
+	}
+
+	file := model.NewFile(batch.UserId, batch.TokenId, fmt.Sprintf("%s_%s.jsonl", batch.Id, kind),
+		"batch_output", size, "application/jsonl")
+	if err = storage.Default.Put(ctx, file.StorageKey, f, size, file.ContentType); err != nil {
+		return "", errors.Wrapf(err, "save %s file", kind)
+	}
+	if err = file.Insert(); err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return file.Id, nil
+}
@@ -0,0 +1,216 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/storage"
+	"github.com/songquanpeng/one-api/model"
+)
+
+const testBatchTokenKey = "batchtestkey"
+
+func setupBatchTest(t *testing.T) *model.Token {
+	testDB, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, testDB.AutoMigrate(&model.File{}, &model.Batch{}, &model.BatchLine{}, &model.Token{}))
+	originalDB, originalStorage := model.DB, storage.Default
+	model.DB, storage.Default = testDB, storage.NewLocal(t.TempDir())
+	t.Cleanup(func() { model.DB, storage.Default = originalDB, originalStorage })
+
+	token := &model.Token{UserId: 1, Key: testBatchTokenKey, Name: "batch"}
+	require.NoError(t, model.DB.Create(token).Error)
+	return token
+}
+
+// createTestBatch uploads lines as the input file and creates a batch of /v1/chat/completions
+func createTestBatch(t *testing.T, token *model.Token, lines ...string) *model.Batch {
+	content := strings.Join(lines, "\n") + "\n"
+	file := model.NewFile(token.UserId, token.Id, "input.jsonl", "batch", int64(len(content)), "application/jsonl")
+	require.NoError(t, storage.Default.Put(context.Background(), file.StorageKey, strings.NewReader(content), file.Bytes, file.ContentType))
+	require.NoError(t, file.Insert())
+
+	batch := model.NewBatch(token.UserId, token.Id, "/v1/chat/completions", file.Id, "24h", 3600, nil)
+	require.NoError(t, batch.Insert())
+	return batch
+}
+
+func batchLine(customId, modelName string) string {
+	return `{"custom_id":"` + customId + `","method":"POST","url":"/v1/chat/completions","body":{"model":"` + modelName + `","messages":[{"role":"user","content":"hi"}]}}`
+}
+
+// fakeRelay answers every request with the model, requests of model "bad" fail
+func fakeRelay(t *testing.T, calls *atomic.Int32) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		assert.Equal(t, "Bearer sk-"+testBatchTokenKey, r.Header.Get("Authorization"))
+		assert.Equal(t, "/v1/chat/completions", r.URL.Path)
+
+		body := struct {
+			Model string `json:"model"`
+		}{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		w.Header().Set(helper.RequestIdKey, "req-"+body.Model)
+		if body.Model == "bad" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":{"message":"bad model"}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"model":"` + body.Model + `"}`))
+	})
+}
+
+func readBatchOutputFile(t *testing.T, fileId string) []batchOutputLine {
+	file, err := model.GetFileById(fileId)
+	require.NoError(t, err)
+	assert.Equal(t, "batch_output", file.Purpose)
+
+	content, err := storage.Default.Get(context.Background(), file.StorageKey)
+	require.NoError(t, err)
+	defer content.Close()
+	data, err := io.ReadAll(content)
+	require.NoError(t, err)
+
+	var lines []batchOutputLine
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		output := batchOutputLine{}
+		require.NoError(t, json.Unmarshal([]byte(line), &output))
+		lines = append(lines, output)
+	}
+	return lines
+}
+
+func TestBatchRunner_Completed(t *testing.T) {
+	token := setupBatchTest(t)
+	batch := createTestBatch(t, token, batchLine("a", "gpt-4o"), "", batchLine("b", "bad"), batchLine("c", "gpt-4o-mini"))
+
+	calls := new(atomic.Int32)
+	runner := NewBatchRunner(fakeRelay(t, calls), 2)
+	require.NoError(t, runner.RunBatch(context.Background(), batch.Id))
+	assert.EqualValues(t, 3, calls.Load())
+
+	batch, err := model.GetBatchById(batch.Id)
+	require.NoError(t, err)
+	assert.Equal(t, model.BatchStatusCompleted, batch.Status)
+	assert.Equal(t, 3, batch.TotalCount)
+	assert.Equal(t, 2, batch.CompletedCount)
+	assert.Equal(t, 1, batch.FailedCount)
+	assert.NotZero(t, batch.InProgressAt)
+	assert.NotZero(t, batch.FinalizingAt)
+	assert.NotZero(t, batch.CompletedAt)
+
+	outputs := readBatchOutputFile(t, batch.OutputFileId)
+	require.Len(t, outputs, 2)
+	assert.Equal(t, "a", outputs[0].CustomId)
+	assert.Equal(t, http.StatusOK, outputs[0].Response.StatusCode)
+	assert.Equal(t, "req-gpt-4o", outputs[0].Response.RequestId)
+	assert.JSONEq(t, `{"model":"gpt-4o"}`, string(outputs[0].Response.Body))
+	assert.Equal(t, "c", outputs[1].CustomId)
+
+	errorOutputs := readBatchOutputFile(t, batch.ErrorFileId)
+	require.Len(t, errorOutputs, 1)
+	assert.Equal(t, "b", errorOutputs[0].CustomId)
+	assert.Equal(t, http.StatusBadRequest, errorOutputs[0].Response.StatusCode)
+
+	// the progress is cleaned up
+	lines, err := model.GetBatchLines(batch.Id)
+	require.NoError(t, err)
+	assert.Empty(t, lines)
+}
+
+func TestBatchRunner_Resume(t *testing.T) {
+	token := setupBatchTest(t)
+	batch := createTestBatch(t, token, batchLine("a", "gpt-4o"), batchLine("b", "gpt-4o"))
+
+	// the first line has been finished before restart
+	ok, err := model.UpdateBatchStatus(batch.Id, []string{model.BatchStatusValidating}, model.BatchStatusInProgress,
+		map[string]any{"total_count": 2})
+	require.NoError(t, err)
+	require.True(t, ok)
+	finished := `{"id":"batch_req_1","custom_id":"a","response":{"status_code":200,"request_id":"","body":{}},"error":null}`
+	require.NoError(t, storage.Default.Put(context.Background(), batchLineKey(batch, 0), strings.NewReader(finished), int64(len(finished)), ""))
+	require.NoError(t, model.RecordBatchLine(batch.Id, 0, false))
+
+	calls := new(atomic.Int32)
+	require.NoError(t, NewBatchRunner(fakeRelay(t, calls), 1).RunBatch(context.Background(), batch.Id))
+	assert.EqualValues(t, 1, calls.Load())
+
+	batch, err = model.GetBatchById(batch.Id)
+	require.NoError(t, err)
+	assert.Equal(t, model.BatchStatusCompleted, batch.Status)
+	assert.Equal(t, 2, batch.CompletedCount)
+	outputs := readBatchOutputFile(t, batch.OutputFileId)
+	require.Len(t, outputs, 2)
+	assert.Equal(t, "batch_req_1", outputs[0].Id)
+	assert.Equal(t, "b", outputs[1].CustomId)
+}
+
+func TestBatchRunner_ValidationFailed(t *testing.T) {
+	token := setupBatchTest(t)
+	batch := createTestBatch(t, token,
+		batchLine("a", "gpt-4o"),
+		batchLine("a", "gpt-4o"),
+		`{"custom_id":"c","method":"POST","url":"/v1/embeddings","body":{"model":"m"}}`,
+		`{"custom_id":"d","method":"POST","url":"/v1/chat/completions","body":{"model":"m","stream":true}}`,
+		`not json`,
+	)
+
+	calls := new(atomic.Int32)
+	require.NoError(t, NewBatchRunner(fakeRelay(t, calls), 1).RunBatch(context.Background(), batch.Id))
+	assert.Zero(t, calls.Load())
+
+	batch, err := model.GetBatchById(batch.Id)
+	require.NoError(t, err)
+	assert.Equal(t, model.BatchStatusFailed, batch.Status)
+	assert.NotZero(t, batch.FailedAt)
+
+	batchErrors := struct {
+		Data []batchOutputError `json:"data"`
+	}{}
+	require.NotNil(t, batch.Errors)
+	require.NoError(t, json.Unmarshal([]byte(*batch.Errors), &batchErrors))
+	require.Len(t, batchErrors.Data, 4)
+	assert.Equal(t, "duplicate_custom_id", batchErrors.Data[0].Code)
+	assert.Equal(t, 2, batchErrors.Data[0].Line)
+	assert.Equal(t, 5, batchErrors.Data[3].Line)
+}
+
+func TestBatchRunner_CancelledAndExpired(t *testing.T) {
+	token := setupBatchTest(t)
+	calls := new(atomic.Int32)
+	runner := NewBatchRunner(fakeRelay(t, calls), 1)
+
+	cancelled := createTestBatch(t, token, batchLine("a", "gpt-4o"))
+	_, err := model.UpdateBatchStatus(cancelled.Id, []string{model.BatchStatusValidating}, model.BatchStatusCancelling, nil)
+	require.NoError(t, err)
+	require.NoError(t, runner.RunBatch(context.Background(), cancelled.Id))
+	cancelled, err = model.GetBatchById(cancelled.Id)
+	require.NoError(t, err)
+	assert.Equal(t, model.BatchStatusCancelled, cancelled.Status)
+	assert.NotZero(t, cancelled.CancelledAt)
+	assert.Empty(t, cancelled.OutputFileId)
+
+	expired := createTestBatch(t, token, batchLine("a", "gpt-4o"), batchLine("b", "gpt-4o"))
+	require.NoError(t, model.DB.Model(expired).Update("expires_at", helper.GetTimestamp()-1).Error)
+	require.NoError(t, runner.RunBatch(context.Background(), expired.Id))
+	expired, err = model.GetBatchById(expired.Id)
+	require.NoError(t, err)
+	assert.Equal(t, model.BatchStatusExpired, expired.Status)
+	assert.Equal(t, 2, expired.FailedCount)
+	errorOutputs := readBatchOutputFile(t, expired.ErrorFileId)
+	require.Len(t, errorOutputs, 2)
+	assert.Equal(t, "batch_expired", errorOutputs[1].Error.Code)
+
+	assert.Zero(t, calls.Load())
+}
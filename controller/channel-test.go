@@ -79,17 +79,16 @@ func calculateTestCost(usage *relaymodel.Usage, meta *meta.Meta, request *relaym
 	// Get model ratio and completion ratio using three-layer pricing system
 	pricingAdaptor := relay.GetAdaptor(meta.ChannelType)
 	modelRatio := pricing.GetModelRatioWithThreeLayers(request.Model, nil, pricingAdaptor)
-	completionRatio := pricing.GetCompletionRatioWithThreeLayers(request.Model, nil, pricingAdaptor)
+	tokenRatios := pricing.GetTokenRatiosWithThreeLayers(request.Model, nil, pricingAdaptor)
+	modelRatio, tokenRatios = tokenRatios.ForPromptTokens(modelRatio, usage.PromptTokens)
 
 	// Use the same group ratio as set in the context (typically 1.0 for tests)
 	groupRatio := 1.0 // Default group ratio for tests
 
 	// Calculate cost using the same formula as postConsumeQuota
-	promptTokens := usage.PromptTokens
-	completionTokens := usage.CompletionTokens
 	ratio := modelRatio * groupRatio
 
-	quota := int64(math.Ceil((float64(promptTokens)+float64(completionTokens)*completionRatio)*ratio)) + usage.ToolsCost
+	quota := int64(math.Ceil(tokenRatios.WeightedTokens(usage)*ratio)) + usage.ToolsCost
 	if ratio != 0 && quota <= 0 {
 		quota = 1
 	}
@@ -7,6 +7,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/Laisky/errors/v2"
 	"github.com/gin-gonic/gin"
 
 	"github.com/songquanpeng/one-api/common/config"
@@ -106,8 +107,24 @@ func AddChannel(c *gin.Context) {
 		}
 	}
 
+	cfg, err := channel.LoadConfig()
+	if err == nil {
+		err = validateChannelConfig(cfg)
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "Invalid config: " + err.Error(),
+		})
+		return
+	}
+
 	channel.CreatedTime = helper.GetTimestamp()
+	// one channel is added for each key, unless the channel holds multiple keys
 	keys := strings.Split(channel.Key, "\n")
+	if cfg.MultiKeyMode != "" {
+		keys = []string{channel.Key}
+	}
 	channels := make([]model.Channel, 0, len(keys))
 	for _, key := range keys {
 		if key == "" {
@@ -190,6 +207,18 @@ func UpdateChannel(c *gin.Context) {
 		}
 	}
 
+	cfg, err := channel.LoadConfig()
+	if err == nil {
+		err = validateChannelConfig(cfg)
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "Invalid config: " + err.Error(),
+		})
+		return
+	}
+
 	err = channel.Update()
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -409,10 +438,24 @@ func GetChannelDefaultPricing(c *gin.Context) {
 	modelConfigs := make(map[string]model.ModelConfigLocal)
 	for modelName, price := range defaultPricing {
 		modelConfigs[modelName] = model.ModelConfigLocal{
-			Ratio:           price.Ratio,
-			CompletionRatio: price.CompletionRatio,
-			MaxTokens:       price.MaxTokens,
+			Ratio:            price.Ratio,
+			CompletionRatio:  price.CompletionRatio,
+			MaxTokens:        price.MaxTokens,
+			CachedInputRatio: price.CachedInputRatio,
+			CacheWriteRatio:  price.CacheWriteRatio,
+			AudioInputRatio:  price.AudioInputRatio,
+			AudioOutputRatio: price.AudioOutputRatio,
+			ImageInputRatio:  price.ImageInputRatio,
 		}
+		config := modelConfigs[modelName]
+		for _, tier := range price.Tiers {
+			config.Tiers = append(config.Tiers, model.PriceTierLocal{
+				InputTokenThreshold: tier.InputTokenThreshold,
+				Ratio:               tier.Ratio,
+				CompletionRatio:     tier.CompletionRatio,
+			})
+		}
+		modelConfigs[modelName] = config
 	}
 
 	// Convert to JSON
@@ -453,3 +496,11 @@ func GetChannelDefaultPricing(c *gin.Context) {
 		},
 	})
 }
+
+// validateChannelConfig checks the settings of the channel config cfg
+func validateChannelConfig(cfg model.ChannelConfig) error {
+	if !model.IsValidKeyMode(cfg.MultiKeyMode) {
+		return errors.Errorf("invalid multi_key_mode %q", cfg.MultiKeyMode)
+	}
+	return model.ValidateAvailabilityWindows(cfg)
+}
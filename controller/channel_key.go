@@ -0,0 +1,111 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/monitor"
+)
+
+// GetChannelKeys lists the keys of the multi-key channel with their status, the keys themselves are masked
+func GetChannelKeys(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	channel, err := model.GetChannelById(id, true)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	keys, err := model.GetChannelKeyStatuses(channel)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    keys,
+	})
+}
+
+type updateChannelKeyStatusRequest struct {
+	Fingerprint string `json:"fingerprint"`
+	Status      int    `json:"status"`
+}
+
+// UpdateChannelKeyStatus enables or manually disables a key of the multi-key channel.
+// Enabling a key of a channel which was disabled automatically enables the channel as well.
+func UpdateChannelKeyStatus(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	req := updateChannelKeyStatusRequest{}
+	if err = c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if req.Status != model.ChannelStatusEnabled && req.Status != model.ChannelStatusManuallyDisabled {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "status must be enabled or manually disabled",
+		})
+		return
+	}
+	channel, err := model.GetChannelById(id, true)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if !channel.IsMultiKey() {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "the channel does not hold multiple keys",
+		})
+		return
+	}
+
+	enabledKeys, err := model.UpdateChannelKeyStatus(channel, req.Fingerprint, req.Status, "disabled by admin")
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	switch {
+	case enabledKeys == 0 && channel.Status == model.ChannelStatusEnabled:
+		model.UpdateChannelStatusById(channel.Id, model.ChannelStatusManuallyDisabled)
+	case enabledKeys > 0 && channel.Status == model.ChannelStatusAutoDisabled:
+		monitor.EnableChannel(channel.Id, channel.Name)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
@@ -0,0 +1,64 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/relay/circuitbreaker"
+)
+
+// GetCircuits lists the circuits which are open, half-open or have failures,
+// optionally of the channel given by the channel_id query
+func GetCircuits(c *gin.Context) {
+	channelId, _ := strconv.Atoi(c.Query("channel_id"))
+	circuits, err := circuitbreaker.List(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+
+	if channelId != 0 {
+		filtered := make([]*circuitbreaker.Circuit, 0, len(circuits))
+		for _, circuit := range circuits {
+			if circuit.ChannelId == channelId {
+				filtered = append(filtered, circuit)
+			}
+		}
+		circuits = filtered
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    circuits,
+	})
+}
+
+// ResetCircuit closes the circuit of the channel and model given by the channel_id and model queries
+func ResetCircuit(c *gin.Context) {
+	channelId, err := strconv.Atoi(c.Query("channel_id"))
+	modelName := c.Query("model")
+	if err != nil || modelName == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "channel_id and model are required",
+		})
+		return
+	}
+
+	if err = circuitbreaker.Reset(c.Request.Context(), channelId, modelName); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
@@ -0,0 +1,334 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/storage"
+	"github.com/songquanpeng/one-api/model"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+)
+
+// https://platform.openai.com/docs/api-reference/files
+
+// filePurposes are the purposes accepted by OpenAI
+var filePurposes = map[string]bool{
+	"assistants": true,
+	"batch":      true,
+	"fine-tune":  true,
+	"vision":     true,
+	"user_data":  true,
+	"evals":      true,
+}
+
+// OpenAIFile is the file object of the Files API
+type OpenAIFile struct {
+	Id        string `json:"id"`
+	Object    string `json:"object"`
+	Bytes     int64  `json:"bytes"`
+	CreatedAt int64  `json:"created_at"`
+	Filename  string `json:"filename"`
+	Purpose   string `json:"purpose"`
+	Status    string `json:"status"`
+}
+
+func toOpenAIFile(file *model.File) OpenAIFile {
+	return OpenAIFile{
+		Id:        file.Id,
+		Object:    "file",
+		Bytes:     file.Bytes,
+		CreatedAt: file.CreatedAt,
+		Filename:  file.Filename,
+		Purpose:   file.Purpose,
+		Status:    file.Status,
+	}
+}
+
+func abortWithOpenAIError(c *gin.Context, statusCode int, param, message string) {
+	c.JSON(statusCode, gin.H{
+		"error": relaymodel.Error{
+			Message: message,
+			Type:    "invalid_request_error",
+			Param:   param,
+		},
+	})
+}
+
+// checkSpendLimits aborts with an error if spending quota more is over the spend limits of the token or the user
+func checkSpendLimits(c *gin.Context, quota int64) bool {
+	userId := c.GetInt(ctxkey.Id)
+	tokenLimits, _ := c.Value(ctxkey.TokenSpendLimits).(model.SpendLimits)
+	userLimits, err := model.CacheGetUserSpendLimits(userId)
+	if err == nil {
+		err = model.CheckSpendLimits(c.GetInt(ctxkey.TokenId), tokenLimits, userId, userLimits, quota)
+	}
+	switch {
+	case errors.Is(err, model.ErrSpendLimitExceeded):
+		abortWithOpenAIError(c, http.StatusForbidden, "", err.Error())
+		return false
+	case err != nil:
+		abortWithOpenAIError(c, http.StatusInternalServerError, "", err.Error())
+		return false
+	}
+	return true
+}
+
+// getUserFile get the file in path owned by the token's user,
+// files of other users are reported as not found.
+func getUserFile(c *gin.Context) (*model.File, bool) {
+	fileId := c.Param("id")
+	file, err := model.GetUserFileById(fileId, c.GetInt(ctxkey.Id))
+	if err != nil {
+		abortWithOpenAIError(c, http.StatusNotFound, "id", fmt.Sprintf("No such File object: %s", fileId))
+		return nil, false
+	}
+
+	return file, true
+}
+
+// fileQuota is the quota charged for keeping a file of bytes
+func fileQuota(bytes int64) int64 {
+	if config.FileQuotaPerMB <= 0 {
+		return 0
+	}
+
+	return int64(math.Ceil(float64(bytes)/(1<<20))) * int64(config.FileQuotaPerMB)
+}
+
+func fileContentType(filename string, header string, head []byte) string {
+	if contentType := mime.TypeByExtension(filepath.Ext(filename)); contentType != "" {
+		return contentType
+	}
+	if header != "" && header != "application/octet-stream" {
+		return header
+	}
+
+	return http.DetectContentType(head)
+}
+
+const (
+	// multipartSlackBytes is allowed on top of MaxFileSizeMB for the other form fields and the multipart boundaries
+	multipartSlackBytes = 1 << 20
+	// multipartMemoryBytes of an upload are kept in memory, the rest goes to temporary files
+	multipartMemoryBytes = 32 << 20
+)
+
+// UploadFile saves a file of the token's user, the storage is charged by FILE_QUOTA_PER_MB
+func UploadFile(c *gin.Context) {
+	ctx := c.Request.Context()
+	userId := c.GetInt(ctxkey.Id)
+	tokenId := c.GetInt(ctxkey.TokenId)
+
+	if config.MaxFileSizeMB > 0 {
+		// reject oversized uploads while they are received, the slack covers the other fields and the multipart overhead
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, int64(config.MaxFileSizeMB)<<20+multipartSlackBytes)
+	}
+	if err := c.Request.ParseMultipartForm(multipartMemoryBytes); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			abortWithOpenAIError(c, http.StatusRequestEntityTooLarge, "file",
+				fmt.Sprintf("File is larger than the limit of %d MB", config.MaxFileSizeMB))
+			return
+		}
+		abortWithOpenAIError(c, http.StatusBadRequest, "", "Invalid multipart form: "+err.Error())
+		return
+	}
+
+	purpose := c.PostForm("purpose")
+	if !filePurposes[purpose] {
+		abortWithOpenAIError(c, http.StatusBadRequest, "purpose", fmt.Sprintf("Invalid purpose: %q", purpose))
+		return
+	}
+	header, err := c.FormFile("file")
+	if err != nil {
+		abortWithOpenAIError(c, http.StatusBadRequest, "file", "Missing file: "+err.Error())
+		return
+	}
+	if config.MaxFileSizeMB > 0 && header.Size > int64(config.MaxFileSizeMB)<<20 {
+		abortWithOpenAIError(c, http.StatusRequestEntityTooLarge, "file",
+			fmt.Sprintf("File is larger than the limit of %d MB", config.MaxFileSizeMB))
+		return
+	}
+	if config.UserFileStorageLimitMB > 0 {
+		usedBytes, err := model.GetUserFileBytes(userId)
+		if err != nil {
+			abortWithOpenAIError(c, http.StatusInternalServerError, "", err.Error())
+			return
+		}
+		if usedBytes+header.Size > int64(config.UserFileStorageLimitMB)<<20 {
+			abortWithOpenAIError(c, http.StatusForbidden, "file",
+				fmt.Sprintf("File storage limit of %d MB exceeded", config.UserFileStorageLimitMB))
+			return
+		}
+	}
+
+	f, err := header.Open()
+	if err != nil {
+		abortWithOpenAIError(c, http.StatusBadRequest, "file", "Failed to read file: "+err.Error())
+		return
+	}
+	defer f.Close()
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	head = head[:n]
+
+	file := model.NewFile(userId, tokenId, filepath.Base(header.Filename), purpose, header.Size,
+		fileContentType(header.Filename, header.Header.Get("Content-Type"), head))
+
+	quota := fileQuota(file.Bytes)
+	if quota > 0 {
+		if !checkSpendLimits(c, quota) {
+			return
+		}
+		if err = model.PreConsumeTokenQuota(ctx, tokenId, quota); err != nil {
+			abortWithOpenAIError(c, http.StatusForbidden, "", err.Error())
+			return
+		}
+	}
+	refund := func() {
+		if quota > 0 {
+			if err := model.PostConsumeTokenQuota(ctx, tokenId, -quota); err != nil {
+				logger.Errorf(ctx, "failed to refund quota of file %s: %+v", file.Id, err)
+			}
+		}
+	}
+
+	if err = storage.Default.Put(ctx, file.StorageKey, io.MultiReader(bytes.NewReader(head), f), file.Bytes, file.ContentType); err != nil {
+		refund()
+		logger.Errorf(ctx, "failed to store file %s: %+v", file.Id, err)
+		abortWithOpenAIError(c, http.StatusInternalServerError, "", "Failed to store file")
+		return
+	}
+	if err = file.Insert(); err != nil {
+		refund()
+		if err := storage.Default.Delete(ctx, file.StorageKey); err != nil {
+			logger.Errorf(ctx, "failed to delete orphan file %s: %+v", file.Id, err)
+		}
+		abortWithOpenAIError(c, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+
+	if quota > 0 {
+		model.UpdateUserUsedQuotaAndRequestCount(userId, quota)
+		model.RecordSpend(ctx, userId, tokenId, quota)
+		if err = model.CacheUpdateUserQuota(ctx, userId); err != nil {
+			logger.Errorf(ctx, "failed to update user quota cache: %+v", err)
+		}
+		model.RecordConsumeLog(ctx, &model.Log{
+			UserId:    userId,
+			TokenName: c.GetString(ctxkey.TokenName),
+			Quota:     int(quota),
+			Content:   fmt.Sprintf("file storage %s, %d bytes", file.Id, file.Bytes),
+		})
+	}
+
+	c.JSON(http.StatusOK, toOpenAIFile(file))
+}
+
+// ListFiles lists files of the token's user
+func ListFiles(c *gin.Context) {
+	limit := 10000
+	if rawLimit := c.Query("limit"); rawLimit != "" {
+		var err error
+		if limit, err = strconv.Atoi(rawLimit); err != nil || limit < 1 || limit > 10000 {
+			abortWithOpenAIError(c, http.StatusBadRequest, "limit", "limit should be between 1 and 10000")
+			return
+		}
+	}
+	ascending := c.Query("order") == "asc"
+
+	files, err := model.GetUserFiles(c.GetInt(ctxkey.Id), c.Query("purpose"), c.Query("after"), limit+1, ascending)
+	if err != nil {
+		abortWithOpenAIError(c, http.StatusBadRequest, "", err.Error())
+		return
+	}
+
+	hasMore := len(files) > limit
+	if hasMore {
+		files = files[:limit]
+	}
+	data := make([]OpenAIFile, 0, len(files))
+	for _, file := range files {
+		data = append(data, toOpenAIFile(file))
+	}
+	response := gin.H{
+		"object":   "list",
+		"data":     data,
+		"has_more": hasMore,
+	}
+	if len(data) > 0 {
+		response["first_id"] = data[0].Id
+		response["last_id"] = data[len(data)-1].Id
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// RetrieveFile returns the file object
+func RetrieveFile(c *gin.Context) {
+	file, ok := getUserFile(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, toOpenAIFile(file))
+}
+
+// DeleteFile deletes the file and its content, the storage quota is not refunded
+func DeleteFile(c *gin.Context) {
+	ctx := c.Request.Context()
+	file, ok := getUserFile(c)
+	if !ok {
+		return
+	}
+
+	if err := storage.Default.Delete(ctx, file.StorageKey); err != nil {
+		logger.Errorf(ctx, "failed to delete content of file %s: %+v", file.Id, err)
+		abortWithOpenAIError(c, http.StatusInternalServerError, "", "Failed to delete file")
+		return
+	}
+	if err := file.Delete(); err != nil {
+		abortWithOpenAIError(c, http.StatusInternalServerError, "", err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":      file.Id,
+		"object":  "file",
+		"deleted": true,
+	})
+}
+
+// RetrieveFileContent downloads the content of the file
+func RetrieveFileContent(c *gin.Context) {
+	ctx := c.Request.Context()
+	file, ok := getUserFile(c)
+	if !ok {
+		return
+	}
+
+	content, err := storage.Default.Get(ctx, file.StorageKey)
+	if err != nil {
+		logger.Errorf(ctx, "failed to get content of file %s: %+v", file.Id, err)
+		abortWithOpenAIError(c, http.StatusInternalServerError, "", "Failed to read file")
+		return
+	}
+	defer content.Close()
+
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": file.Filename}))
+	c.Header("Last-Modified", time.Unix(file.CreatedAt, 0).UTC().Format(http.TimeFormat))
+	c.DataFromReader(http.StatusOK, file.Bytes, file.ContentType, content, nil)
+}
@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/model"
+)
+
+func TestUploadFile_TooLarge(t *testing.T) {
+	originalMaxFileSizeMB := config.MaxFileSizeMB
+	config.MaxFileSizeMB = 1
+	defer func() { config.MaxFileSizeMB = originalMaxFileSizeMB }()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	require.NoError(t, writer.WriteField("purpose", "batch"))
+	part, err := writer.CreateFormFile("file", "large.jsonl")
+	require.NoError(t, err)
+	_, err = part.Write(bytes.Repeat([]byte("a"), 3<<20))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/files", body)
+	c.Request.Header.Set("Content-Type", writer.FormDataContentType())
+	UploadFile(c)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "larger than the limit of 1 MB")
+}
+
+func TestUploadFile_SpendLimit(t *testing.T) {
+	testDB, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, testDB.AutoMigrate(&model.User{}, &model.Spend{}))
+	originalDB, originalRedisEnabled, originalFileQuotaPerMB := model.DB, common.RedisEnabled, config.FileQuotaPerMB
+	model.DB, common.RedisEnabled, config.FileQuotaPerMB = testDB, false, 100
+	defer func() {
+		model.DB, common.RedisEnabled, config.FileQuotaPerMB = originalDB, originalRedisEnabled, originalFileQuotaPerMB
+	}()
+	require.NoError(t, testDB.Create(&model.User{Id: 1, Username: "file-spend", DailySpendLimit: 50}).Error)
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	require.NoError(t, writer.WriteField("purpose", "batch"))
+	part, err := writer.CreateFormFile("file", "small.jsonl")
+	require.NoError(t, err)
+	_, err = part.Write([]byte(`{"custom_id":"1"}`))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/files", body)
+	c.Request.Header.Set("Content-Type", writer.FormDataContentType())
+	c.Set(ctxkey.Id, 1)
+	UploadFile(c)
+
+	// the file costs 100, over the daily limit of the user
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "spend limit exceeded")
+}
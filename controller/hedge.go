@@ -0,0 +1,158 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/middleware"
+	dbmodel "github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/hedge"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+// hedgeDelay returns how long to wait for the first byte of the response before hedging the request,
+// 0 if the request is not hedged
+func hedgeDelay(c *gin.Context, relayMode int) time.Duration {
+	delayMs := c.GetInt(ctxkey.HedgeDelay)
+	if delayMs <= 0 || !hedge.IsSupported(relayMode) || c.GetInt(ctxkey.SpecificChannelId) != 0 {
+		return 0
+	}
+	return time.Duration(delayMs) * time.Millisecond
+}
+
+// hedgeResult is the outcome of an attempt of a hedged request
+type hedgeResult struct {
+	bizErr *model.ErrorWithStatusCode
+	// cancelled is whether the attempt was still running when the other one won
+	cancelled bool
+}
+
+// relayWithHedge relays the request on the channel selected for c, and sends it to another channel as well
+// when no byte of the response has been written after delay. Whichever answers first is sent to the client
+// and billed, the other one is cancelled.
+//
+// When the hedge request wins, its context is copied into c so that the caller sees the channel which answered.
+// It returns the start time of the attempt returned and its error, and the id of the channel of the other attempt
+// if it failed too, 0 otherwise, so that retries skip both channels.
+func relayWithHedge(c *gin.Context, relayMode int, delay time.Duration, startTime time.Time) (time.Time, int, *model.ErrorWithStatusCode) {
+	ctx := c.Request.Context()
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil {
+		logger.Errorf(ctx, "failed to read request body, the request is not hedged: %+v", err)
+		return startTime, 0, relayHelper(c, relayMode)
+	}
+
+	userId := c.GetInt(ctxkey.Id)
+	group := c.GetString(ctxkey.Group)
+	originalModel := c.GetString(ctxkey.OriginalModel)
+	primaryChannelId := c.GetInt(ctxkey.ChannelId)
+	sessionKey := c.GetString(ctxkey.SessionKey)
+	originalWriter, originalRequest := c.Writer, c.Request
+	race := hedge.NewRace(originalWriter)
+
+	// the hedge request runs on a copy of c taken before the primary one starts changing c
+	hedgeCtx, cancelHedge := context.WithCancel(ctx)
+	defer cancelHedge()
+	hc := c.Copy()
+	delete(hc.Keys, ctxkey.Meta)
+	hc.Request = originalRequest.Clone(hedgeCtx)
+	hc.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+	hedgeAttempt := race.NewAttempt(cancelHedge)
+	hc.Writer = hedgeAttempt.Writer()
+	hc.Set(ctxkey.HedgeAttempt, hedgeAttempt)
+
+	primaryCtx, cancelPrimary := context.WithCancel(ctx)
+	defer cancelPrimary()
+	primaryAttempt := race.NewAttempt(cancelPrimary)
+	c.Request = originalRequest.WithContext(primaryCtx)
+	c.Writer = primaryAttempt.Writer()
+	c.Set(ctxkey.HedgeAttempt, primaryAttempt)
+	defer func() {
+		// retries after the race write to the client directly
+		c.Writer, c.Request = originalWriter, originalRequest
+		c.Set(ctxkey.HedgeAttempt, (*hedge.Attempt)(nil))
+	}()
+
+	run := func(c *gin.Context, attempt *hedge.Attempt, done chan<- hedgeResult) {
+		bizErr := relayHelper(c, relayMode)
+		select {
+		case <-race.Decided():
+			done <- hedgeResult{bizErr: bizErr, cancelled: !attempt.Won()}
+		default:
+			done <- hedgeResult{bizErr: bizErr}
+		}
+	}
+	primaryDone := make(chan hedgeResult, 1)
+	go run(c, primaryAttempt, primaryDone)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case result := <-primaryDone:
+		return startTime, 0, result.bizErr
+	case <-race.Decided():
+		return startTime, 0, (<-primaryDone).bizErr
+	case <-timer.C:
+	}
+
+	excludedChannels := map[int]bool{primaryChannelId: true}
+	channel, err := dbmodel.CacheGetRandomSatisfiedChannelExcluding(group, originalModel, false, excludedChannels, false, sessionKey)
+	if err != nil {
+		channel, err = dbmodel.CacheGetRandomSatisfiedChannelExcluding(group, originalModel, true, excludedChannels, false, sessionKey)
+	}
+	if err != nil {
+		logger.Infof(ctx, "channel #%d has not answered in %s, but no other channel could hedge the request: %v",
+			primaryChannelId, delay, err)
+		return startTime, 0, (<-primaryDone).bizErr
+	}
+
+	logger.Infof(ctx, "channel #%d has not answered in %s, hedging the request on channel #%d", primaryChannelId, delay, channel.Id)
+	middleware.SetupContextForSelectedChannel(hc, channel, originalModel)
+	hedgeStartTime := time.Now()
+	hedgeMeta := meta.GetByContext(hc)
+	PrometheusMonitor.RecordChannelRequest(hedgeMeta, hedgeStartTime)
+	hedgeDone := make(chan hedgeResult, 1)
+	go run(hc, hedgeAttempt, hedgeDone)
+
+	primaryResult, hedgedResult := <-primaryDone, <-hedgeDone
+	primaryMeta := meta.GetByContext(c)
+	// settle records the outcome of the attempt which is not returned to the caller
+	// and returns its channel id if it failed
+	settle := func(c *gin.Context, attemptMeta *meta.Meta, startTime time.Time, result hedgeResult) int {
+		switch {
+		case result.cancelled:
+			PrometheusMonitor.RecordCancelledRequest(attemptMeta)
+		case result.bizErr != nil:
+			PrometheusMonitor.RecordRelayRequest(c, attemptMeta, startTime, false, 0, 0, 0)
+			go processChannelRelayError(ctx, userId, attemptMeta.ChannelId, c.GetString(ctxkey.ChannelName),
+				attemptMeta.ChannelKey, group, originalModel, *result.bizErr)
+			return attemptMeta.ChannelId
+		}
+		return 0
+	}
+
+	if hedgeAttempt.Won() {
+		logger.Infof(ctx, "hedge request on channel #%d answered before channel #%d", channel.Id, primaryChannelId)
+		failedChannelId := settle(c, primaryMeta, startTime, primaryResult)
+		for key, value := range hc.Keys {
+			c.Set(key, value)
+		}
+		return hedgeStartTime, failedChannelId, hedgedResult.bizErr
+	}
+
+	if primaryAttempt.Won() {
+		logger.Infof(ctx, "channel #%d answered before the hedge request on channel #%d", primaryChannelId, channel.Id)
+	} else {
+		logger.Warnf(ctx, "both channel #%d and the hedge request on channel #%d failed", primaryChannelId, channel.Id)
+	}
+	failedChannelId := settle(hc, hedgeMeta, hedgeStartTime, hedgedResult)
+	return startTime, failedChannelId, primaryResult.bizErr
+}
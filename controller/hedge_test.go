@@ -0,0 +1,293 @@
+package controller
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/client"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/middleware"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/channeltype"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+// fakeChatUpstream answers chat completions after delay, unless the request is cancelled before
+func fakeChatUpstream(t *testing.T, delay time.Duration, content string, cancelled *atomic.Bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the cancellation of the request is only noticed once its body is read
+		_, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			cancelled.Store(true)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write([]byte(`{"id":"chatcmpl-1","object":"chat.completion","model":"gpt-4o","choices":[{"index":0,` +
+			`"message":{"role":"assistant","content":"` + content + `"},"finish_reason":"stop"}],` +
+			`"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`))
+		assert.NoError(t, err)
+	}))
+}
+
+func TestRelayWithHedge(t *testing.T) {
+	testDB := setupTestDB(t)
+	originalDB, originalLogDB := model.DB, model.LOG_DB
+	originalRedisEnabled, originalMemoryCacheEnabled := common.RedisEnabled, config.MemoryCacheEnabled
+	originalApproximateTokenEnabled := config.ApproximateTokenEnabled
+	model.DB, model.LOG_DB = testDB, testDB
+	common.RedisEnabled, config.MemoryCacheEnabled = false, false
+	// token encoders are not loaded in tests
+	config.ApproximateTokenEnabled = true
+	t.Cleanup(func() {
+		model.DB, model.LOG_DB = originalDB, originalLogDB
+		common.RedisEnabled, config.MemoryCacheEnabled = originalRedisEnabled, originalMemoryCacheEnabled
+		config.ApproximateTokenEnabled = originalApproximateTokenEnabled
+	})
+
+	client.Init()
+	var slowCancelled, fastCancelled atomic.Bool
+	slowUpstream := fakeChatUpstream(t, 5*time.Second, "slow", &slowCancelled)
+	defer slowUpstream.Close()
+	fastUpstream := fakeChatUpstream(t, 0, "fast", &fastCancelled)
+	defer fastUpstream.Close()
+
+	require.NoError(t, testDB.Create(&model.User{Id: 1, Username: "hedge", Quota: 1000000, Status: model.UserStatusEnabled}).Error)
+	token := &model.Token{Id: 1, UserId: 1, Key: "hedge", Name: "hedge", RemainQuota: 1000000, HedgeDelayMs: 100}
+	require.NoError(t, testDB.Create(token).Error)
+	priority := int64(0)
+	for id, baseURL := range map[int]string{1: slowUpstream.URL, 2: fastUpstream.URL} {
+		channel := &model.Channel{Id: id, Type: channeltype.OpenAICompatible, Key: "sk-channel", Status: model.ChannelStatusEnabled,
+			Name: "hedge", Group: "default", Models: "gpt-4o", BaseURL: &baseURL, Priority: &priority}
+		require.NoError(t, testDB.Create(channel).Error)
+		require.NoError(t, channel.AddAbilities())
+	}
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.POST("/v1/chat/completions", func(c *gin.Context) {
+		c.Set(ctxkey.Id, 1)
+		c.Set(ctxkey.Group, "default")
+		c.Set(ctxkey.TokenId, token.Id)
+		c.Set(ctxkey.TokenName, token.Name)
+		c.Set(ctxkey.TokenQuota, token.RemainQuota)
+		c.Set(ctxkey.HedgeDelay, token.HedgeDelayMs)
+		c.Set(ctxkey.RequestModel, "gpt-4o")
+		channel, err := model.GetChannelById(1, true)
+		require.NoError(t, err)
+		middleware.SetupContextForSelectedChannel(c, channel, "gpt-4o")
+		Relay(c)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions",
+		strings.NewReader(`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`))
+	req.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	start := time.Now()
+	engine.ServeHTTP(recorder, req)
+
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	assert.Contains(t, recorder.Body.String(), `"content":"fast"`)
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.Eventually(t, slowCancelled.Load, time.Second, 10*time.Millisecond)
+	assert.False(t, fastCancelled.Load())
+
+	// only the hedge request which answered is billed
+	var logs []model.Log
+	assert.Eventually(t, func() bool {
+		require.NoError(t, testDB.Where("type = ?", model.LogTypeConsume).Find(&logs).Error)
+		return len(logs) > 0
+	}, 2*time.Second, 10*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	require.NoError(t, testDB.Where("type = ?", model.LogTypeConsume).Find(&logs).Error)
+	require.Len(t, logs, 1)
+	assert.Equal(t, 2, logs[0].ChannelId)
+}
+
+func TestRelayWithHedge_LoserRefunded(t *testing.T) {
+	testDB := setupTestDB(t)
+	originalDB, originalLogDB := model.DB, model.LOG_DB
+	originalRedisEnabled, originalMemoryCacheEnabled := common.RedisEnabled, config.MemoryCacheEnabled
+	originalApproximateTokenEnabled := config.ApproximateTokenEnabled
+	model.DB, model.LOG_DB = testDB, testDB
+	common.RedisEnabled, config.MemoryCacheEnabled = false, false
+	config.ApproximateTokenEnabled = true
+	t.Cleanup(func() {
+		model.DB, model.LOG_DB = originalDB, originalLogDB
+		common.RedisEnabled, config.MemoryCacheEnabled = originalRedisEnabled, originalMemoryCacheEnabled
+		config.ApproximateTokenEnabled = originalApproximateTokenEnabled
+	})
+
+	client.Init()
+	var slowCancelled, fastCancelled atomic.Bool
+	// the slow channel never sends its headers, so its attempt is cancelled inside DoRequest
+	slowUpstream := fakeChatUpstream(t, 5*time.Second, "slow", &slowCancelled)
+	defer slowUpstream.Close()
+	fastUpstream := fakeChatUpstream(t, 0, "fast", &fastCancelled)
+	defer fastUpstream.Close()
+
+	// quota low enough for both attempts to pre-consume
+	const quota = 20000
+	require.NoError(t, testDB.Create(&model.User{Id: 1, Username: "hedge", Quota: quota, Status: model.UserStatusEnabled}).Error)
+	token := &model.Token{Id: 1, UserId: 1, Key: "hedge", Name: "hedge", RemainQuota: quota, HedgeDelayMs: 100}
+	require.NoError(t, testDB.Create(token).Error)
+	priority, modelConfigs := int64(0), `{"gpt-4o":{"ratio":1}}`
+	for id, baseURL := range map[int]string{1: slowUpstream.URL, 2: fastUpstream.URL} {
+		channel := &model.Channel{Id: id, Type: channeltype.OpenAICompatible, Key: "sk-channel", Status: model.ChannelStatusEnabled,
+			Name: "hedge", Group: "default", Models: "gpt-4o", BaseURL: &baseURL, Priority: &priority, ModelConfigs: &modelConfigs}
+		require.NoError(t, testDB.Create(channel).Error)
+		require.NoError(t, channel.AddAbilities())
+	}
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.POST("/v1/chat/completions", func(c *gin.Context) {
+		c.Set(ctxkey.Id, 1)
+		c.Set(ctxkey.Group, "default")
+		c.Set(ctxkey.TokenId, token.Id)
+		c.Set(ctxkey.TokenName, token.Name)
+		c.Set(ctxkey.TokenQuota, token.RemainQuota)
+		c.Set(ctxkey.HedgeDelay, token.HedgeDelayMs)
+		c.Set(ctxkey.RequestModel, "gpt-4o")
+		channel, err := model.GetChannelById(1, true)
+		require.NoError(t, err)
+		middleware.SetupContextForSelectedChannel(c, channel, "gpt-4o")
+		Relay(c)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions",
+		strings.NewReader(`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`))
+	req.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, req)
+
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	assert.Contains(t, recorder.Body.String(), `"content":"fast"`)
+	assert.Eventually(t, slowCancelled.Load, time.Second, 10*time.Millisecond)
+
+	// the token pays for the winner only, the quota pre-consumed by the cancelled attempt is returned
+	var logs []model.Log
+	assert.Eventually(t, func() bool {
+		require.NoError(t, testDB.Where("type = ?", model.LogTypeConsume).Find(&logs).Error)
+		return len(logs) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	require.Len(t, logs, 1)
+	assert.Eventually(t, func() bool {
+		require.NoError(t, testDB.First(token, token.Id).Error)
+		return token.RemainQuota == int64(quota-logs[0].Quota)
+	}, 2*time.Second, 10*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+}
+
+// failingChatUpstream answers chat completions with an error after delay, counting the requests it receives
+func failingChatUpstream(t *testing.T, delay time.Duration, requests *atomic.Int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		_, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		time.Sleep(delay)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, err = w.Write([]byte(`{"error":{"message":"upstream failed","type":"server_error"}}`))
+		assert.NoError(t, err)
+	}))
+}
+
+func TestRelayWithHedge_BothFail(t *testing.T) {
+	testDB := setupTestDB(t)
+	originalDB, originalLogDB := model.DB, model.LOG_DB
+	originalRedisEnabled, originalMemoryCacheEnabled := common.RedisEnabled, config.MemoryCacheEnabled
+	originalApproximateTokenEnabled, originalRetryTimes := config.ApproximateTokenEnabled, config.RetryTimes
+	model.DB, model.LOG_DB = testDB, testDB
+	common.RedisEnabled, config.MemoryCacheEnabled = false, false
+	config.ApproximateTokenEnabled, config.RetryTimes = true, 1
+	t.Cleanup(func() {
+		model.DB, model.LOG_DB = originalDB, originalLogDB
+		common.RedisEnabled, config.MemoryCacheEnabled = originalRedisEnabled, originalMemoryCacheEnabled
+		config.ApproximateTokenEnabled, config.RetryTimes = originalApproximateTokenEnabled, originalRetryTimes
+	})
+
+	client.Init()
+	var primaryRequests, hedgeRequests atomic.Int32
+	var cancelled atomic.Bool
+	primaryUpstream := failingChatUpstream(t, 300*time.Millisecond, &primaryRequests)
+	defer primaryUpstream.Close()
+	hedgeUpstream := failingChatUpstream(t, 0, &hedgeRequests)
+	defer hedgeUpstream.Close()
+	retryUpstream := fakeChatUpstream(t, 0, "retried", &cancelled)
+	defer retryUpstream.Close()
+
+	require.NoError(t, testDB.Create(&model.User{Id: 1, Username: "hedge", Quota: 1000000, Status: model.UserStatusEnabled}).Error)
+	token := &model.Token{Id: 1, UserId: 1, Key: "hedge", Name: "hedge", RemainQuota: 1000000, HedgeDelayMs: 100}
+	require.NoError(t, testDB.Create(token).Error)
+	// the hedge request goes to channel 2 of the highest priority, retries have only channel 3 left
+	for id, upstream := range map[int]struct {
+		baseURL  string
+		priority int64
+	}{1: {primaryUpstream.URL, 10}, 2: {hedgeUpstream.URL, 10}, 3: {retryUpstream.URL, 0}} {
+		channel := &model.Channel{Id: id, Type: channeltype.OpenAICompatible, Key: "sk-channel", Status: model.ChannelStatusEnabled,
+			Name: "hedge", Group: "default", Models: "gpt-4o", BaseURL: &upstream.baseURL, Priority: &upstream.priority}
+		require.NoError(t, testDB.Create(channel).Error)
+		require.NoError(t, channel.AddAbilities())
+	}
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.POST("/v1/chat/completions", func(c *gin.Context) {
+		c.Set(ctxkey.Id, 1)
+		c.Set(ctxkey.Group, "default")
+		c.Set(ctxkey.TokenId, token.Id)
+		c.Set(ctxkey.TokenName, token.Name)
+		c.Set(ctxkey.TokenQuota, token.RemainQuota)
+		c.Set(ctxkey.HedgeDelay, token.HedgeDelayMs)
+		c.Set(ctxkey.RequestModel, "gpt-4o")
+		channel, err := model.GetChannelById(1, true)
+		require.NoError(t, err)
+		middleware.SetupContextForSelectedChannel(c, channel, "gpt-4o")
+		Relay(c)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions",
+		strings.NewReader(`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`))
+	req.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, req)
+
+	require.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	assert.Contains(t, recorder.Body.String(), `"content":"retried"`)
+	assert.Equal(t, int32(1), primaryRequests.Load())
+	assert.Equal(t, int32(1), hedgeRequests.Load(), "the failed hedge channel is not retried")
+
+	// the retry is billed in the background
+	assert.Eventually(t, func() bool {
+		var logs []model.Log
+		require.NoError(t, testDB.Where("type = ?", model.LogTypeConsume).Find(&logs).Error)
+		return len(logs) == 1 && logs[0].ChannelId == 3
+	}, 2*time.Second, 10*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+}
+
+func TestRelayWithHedge_NotHedged(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+	assert.Zero(t, hedgeDelay(c, relaymode.ChatCompletions))
+	c.Set(ctxkey.HedgeDelay, 200)
+	assert.Zero(t, hedgeDelay(c, relaymode.Embeddings))
+	assert.Equal(t, 200*time.Millisecond, hedgeDelay(c, relaymode.ChatCompletions))
+	c.Set(ctxkey.SpecificChannelId, 3)
+	assert.Zero(t, hedgeDelay(c, relaymode.ChatCompletions))
+}
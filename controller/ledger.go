@@ -0,0 +1,52 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/model"
+)
+
+// GetLedgerEntries returns the quota ledger, filtered by the account and the request id given in the query
+func GetLedgerEntries(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	entries, err := model.GetLedgerEntries(c.Query("account"), c.Query("request_id"),
+		p*config.MaxItemsPerPage, config.MaxItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    entries,
+	})
+}
+
+// ReconcileLedger compares the quota of users and tokens with the ledger and reports the drifts,
+// they are fixed if the query has fix=true
+func ReconcileLedger(c *gin.Context) {
+	fix, _ := strconv.ParseBool(c.Query("fix"))
+	report, err := model.ReconcileLedger(c.Request.Context(), fix)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    report,
+	})
+}
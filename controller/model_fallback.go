@@ -0,0 +1,118 @@
+package controller
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/middleware"
+	dbmodel "github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/circuitbreaker"
+	"github.com/songquanpeng/one-api/relay/fallback"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+// fallbackModelHeader reports the model which served the request after falling back
+const fallbackModelHeader = "X-Oneapi-Model"
+
+// shouldFallback reports whether bizErr means the model is unavailable rather than the request is invalid
+func shouldFallback(bizErr *model.ErrorWithStatusCode) bool {
+	return bizErr.StatusCode == http.StatusTooManyRequests || bizErr.StatusCode >= http.StatusInternalServerError
+}
+
+// relayFallbackModels relays the request with the models of the fallback chain in order,
+// after every channel of the requested model has failed with bizErr.
+// The request is rewritten to each fallback model, so it is billed and logged as that model.
+func relayFallbackModels(c *gin.Context, relayMode int, bizErr *model.ErrorWithStatusCode) *model.ErrorWithStatusCode {
+	ctx := c.Request.Context()
+	group := c.GetString(ctxkey.Group)
+	requestedModel := c.GetString(ctxkey.OriginalModel)
+	sessionKey := c.GetString(ctxkey.SessionKey)
+	if !fallback.IsSupported(relayMode) ||
+		!strings.HasPrefix(c.Request.Header.Get("Content-Type"), "application/json") {
+		return bizErr
+	}
+
+	for _, fallbackModel := range fallback.GetChain(c.GetString(ctxkey.ModelFallbacks), group, requestedModel) {
+		if !shouldFallback(bizErr) {
+			break
+		}
+		if availableModels := c.GetString(ctxkey.AvailableModels); availableModels != "" &&
+			!middleware.IsModelInList(fallbackModel, availableModels) {
+			logger.Infof(ctx, "skip fallback model %s, which is not allowed by the token", fallbackModel)
+			continue
+		}
+		if allowed, err := dbmodel.UserPlanAllowsModel(c.GetInt(ctxkey.Id), fallbackModel); err != nil || !allowed {
+			logger.Infof(ctx, "skip fallback model %s, which is not included in the plan of the user: %v", fallbackModel, err)
+			continue
+		}
+
+		channel, err := dbmodel.CacheGetRandomSatisfiedChannel(group, fallbackModel, false, sessionKey)
+		if err != nil {
+			channel, err = dbmodel.CacheGetRandomSatisfiedChannel(group, fallbackModel, true, sessionKey)
+		}
+		if err != nil {
+			logger.Infof(ctx, "skip fallback model %s, no available channels in group %s: %v", fallbackModel, group, err)
+			continue
+		}
+
+		if err = setRequestModel(c, fallbackModel); err != nil {
+			logger.Errorf(ctx, "failed to fall back to model %s: %+v", fallbackModel, err)
+			return bizErr
+		}
+		logger.Warnf(ctx, "all channels of model %s failed, falling back to model %s on channel #%d",
+			c.GetString(ctxkey.OriginalModel), fallbackModel, channel.Id)
+		middleware.SetupContextForSelectedChannel(c, channel, fallbackModel)
+		relayMeta := meta.GetByContext(c)
+		relayMeta.OriginModelName = fallbackModel
+		relayMeta.ActualModelName = meta.GetMappedModelName(fallbackModel, relayMeta.ModelMapping)
+		c.Header(fallbackModelHeader, fallbackModel)
+
+		startTime := time.Now()
+		PrometheusMonitor.RecordChannelRequest(relayMeta, startTime)
+		bizErr = relayHelper(c, relayMode)
+		if bizErr == nil {
+			if !c.GetBool(ctxkey.ResponseCacheHit) {
+				circuitbreaker.RecordSuccess(ctx, channel.Id, fallbackModel)
+			}
+			PrometheusMonitor.RecordRelayRequest(c, relayMeta, startTime, true, 0, 0, 0)
+			return nil
+		}
+		PrometheusMonitor.RecordRelayRequest(c, relayMeta, startTime, false, 0, 0, 0)
+		go processChannelRelayError(ctx, c.GetInt(ctxkey.Id), channel.Id, channel.Name, c.GetString(ctxkey.ChannelKey), group, fallbackModel, *bizErr)
+
+		bizErr = retryOtherChannels(c, relayMode, bizErr, config.RetryTimes, map[int]bool{channel.Id: true})
+		if bizErr == nil {
+			return nil
+		}
+	}
+
+	return bizErr
+}
+
+// setRequestModel replaces the model of the JSON request body and of the context with modelName
+func setRequestModel(c *gin.Context, modelName string) error {
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil {
+		return err
+	}
+	requestBody, err = fallback.SetRequestModel(requestBody, modelName)
+	if err != nil {
+		return err
+	}
+
+	c.Set(ctxkey.KeyRequestBody, requestBody)
+	c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+	c.Request.ContentLength = int64(len(requestBody))
+	c.Set(ctxkey.RequestModel, modelName)
+	return nil
+}
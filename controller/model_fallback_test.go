@@ -0,0 +1,149 @@
+package controller
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/client"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	dbmodel "github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/channeltype"
+	"github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+func TestShouldFallback(t *testing.T) {
+	assert.True(t, shouldFallback(&model.ErrorWithStatusCode{StatusCode: http.StatusTooManyRequests}))
+	assert.True(t, shouldFallback(&model.ErrorWithStatusCode{StatusCode: http.StatusBadGateway}))
+	assert.False(t, shouldFallback(&model.ErrorWithStatusCode{StatusCode: http.StatusBadRequest}))
+	assert.False(t, shouldFallback(&model.ErrorWithStatusCode{StatusCode: http.StatusUnauthorized}))
+}
+
+func TestSetRequestModel(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions",
+		strings.NewReader(`{"model":"gpt-4o","messages":[]}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+
+	require.NoError(t, setRequestModel(c, "gpt-4o-mini"))
+	assert.Equal(t, "gpt-4o-mini", c.GetString(ctxkey.RequestModel))
+
+	body, err := io.ReadAll(c.Request.Body)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"model":"gpt-4o-mini","messages":[]}`, string(body))
+	assert.Equal(t, int64(len(body)), c.Request.ContentLength)
+
+	cachedBody, err := common.GetRequestBody(c)
+	require.NoError(t, err)
+	assert.Equal(t, body, cachedBody)
+}
+
+func TestRelayFallbackModels_NotApplicable(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	bizErr := &model.ErrorWithStatusCode{StatusCode: http.StatusServiceUnavailable}
+
+	// requests without a model in their JSON body never fall back
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/audio/transcriptions", nil)
+	c.Request.Header.Set("Content-Type", "multipart/form-data")
+	c.Set(ctxkey.ModelFallbacks, `{"whisper-1": ["gpt-4o-transcribe"]}`)
+	c.Set(ctxkey.OriginalModel, "whisper-1")
+	assert.Same(t, bizErr, relayFallbackModels(c, relaymode.AudioTranscription, bizErr))
+
+	// nor do requests of models without a fallback chain
+	c, _ = gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o"}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Set(ctxkey.OriginalModel, "gpt-4o")
+	assert.Same(t, bizErr, relayFallbackModels(c, relaymode.ChatCompletions, bizErr))
+}
+
+func TestRelayFallbackModels_PlanModels(t *testing.T) {
+	testDB := setupTestDB(t)
+	require.NoError(t, testDB.AutoMigrate(&dbmodel.Plan{}, &dbmodel.UserPlan{}))
+	originalDB, originalLogDB := dbmodel.DB, dbmodel.LOG_DB
+	originalRedisEnabled, originalMemoryCacheEnabled := common.RedisEnabled, config.MemoryCacheEnabled
+	originalApproximateTokenEnabled := config.ApproximateTokenEnabled
+	dbmodel.DB, dbmodel.LOG_DB = testDB, testDB
+	common.RedisEnabled, config.MemoryCacheEnabled = false, false
+	config.ApproximateTokenEnabled = true
+	t.Cleanup(func() {
+		dbmodel.DB, dbmodel.LOG_DB = originalDB, originalLogDB
+		common.RedisEnabled, config.MemoryCacheEnabled = originalRedisEnabled, originalMemoryCacheEnabled
+		config.ApproximateTokenEnabled = originalApproximateTokenEnabled
+	})
+
+	client.Init()
+	var requests atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_, err := w.Write([]byte(`{"id":"chatcmpl-1","object":"chat.completion","model":"gpt-4o-mini","choices":[{"index":0,` +
+			`"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}],` +
+			`"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`))
+		assert.NoError(t, err)
+	}))
+	defer upstream.Close()
+
+	require.NoError(t, testDB.Create(&dbmodel.User{Id: 1, Username: "fallback", Quota: 1000000, Status: dbmodel.UserStatusEnabled}).Error)
+	token := &dbmodel.Token{Id: 1, UserId: 1, Key: "fallback", Name: "fallback", RemainQuota: 1000000}
+	require.NoError(t, testDB.Create(token).Error)
+	plan := &dbmodel.Plan{Id: 1, Name: "basic", Models: "gpt-4o", Status: dbmodel.PlanStatusEnabled}
+	require.NoError(t, testDB.Create(plan).Error)
+	require.NoError(t, testDB.Create(&dbmodel.UserPlan{UserId: 1, PlanId: plan.Id}).Error)
+	baseURL := upstream.URL
+	priority := int64(0)
+	channel := &dbmodel.Channel{Id: 1, Type: channeltype.OpenAICompatible, Key: "sk-channel", Status: dbmodel.ChannelStatusEnabled,
+		Name: "fallback", Group: "default", Models: "gpt-4o-mini", BaseURL: &baseURL, Priority: &priority}
+	require.NoError(t, testDB.Create(channel).Error)
+	require.NoError(t, channel.AddAbilities())
+
+	gin.SetMode(gin.TestMode)
+	relayFallback := func() *model.ErrorWithStatusCode {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions",
+			strings.NewReader(`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`))
+		c.Request.Header.Set("Content-Type", "application/json")
+		c.Set(ctxkey.Id, 1)
+		c.Set(ctxkey.Group, "default")
+		c.Set(ctxkey.TokenId, token.Id)
+		c.Set(ctxkey.TokenName, token.Name)
+		c.Set(ctxkey.TokenQuota, token.RemainQuota)
+		c.Set(ctxkey.RequestModel, "gpt-4o")
+		c.Set(ctxkey.OriginalModel, "gpt-4o")
+		c.Set(ctxkey.ModelFallbacks, `{"gpt-4o": ["gpt-4o-mini"]}`)
+		return relayFallbackModels(c, relaymode.ChatCompletions,
+			&model.ErrorWithStatusCode{StatusCode: http.StatusServiceUnavailable})
+	}
+
+	// the fallback model is skipped when the plan of the user doesn't include it
+	bizErr := relayFallback()
+	require.NotNil(t, bizErr)
+	assert.Equal(t, http.StatusServiceUnavailable, bizErr.StatusCode)
+	assert.Zero(t, requests.Load())
+
+	require.NoError(t, testDB.Model(plan).Update("models", "gpt-4o,gpt-4o-mini").Error)
+	assert.Nil(t, relayFallback())
+	assert.Equal(t, int32(1), requests.Load())
+
+	// the request is billed in the background, as the fallback model
+	var logs []dbmodel.Log
+	assert.Eventually(t, func() bool {
+		require.NoError(t, testDB.Where("type = ?", dbmodel.LogTypeConsume).Find(&logs).Error)
+		return len(logs) > 0
+	}, 2*time.Second, 10*time.Millisecond)
+	require.Len(t, logs, 1)
+	assert.Equal(t, "gpt-4o-mini", logs[0].ModelName)
+}
@@ -0,0 +1,272 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/model"
+)
+
+func GetAllPlans(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	plans, err := model.GetAllPlans(p*config.MaxItemsPerPage, config.MaxItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    plans,
+	})
+}
+
+func GetPlan(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	plan, err := model.GetPlanById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    plan,
+	})
+}
+
+func AddPlan(c *gin.Context) {
+	plan := model.Plan{}
+	if err := c.ShouldBindJSON(&plan); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	cleanPlan := model.Plan{
+		Name:        plan.Name,
+		PriceLabel:  plan.PriceLabel,
+		Quota:       plan.Quota,
+		Period:      plan.Period,
+		Rollover:    plan.Rollover,
+		RolloverCap: plan.RolloverCap,
+		Groups:      plan.Groups,
+		Models:      plan.Models,
+		Status:      model.PlanStatusEnabled,
+		CreatedTime: helper.GetTimestamp(),
+	}
+	if err := cleanPlan.Validate(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err := cleanPlan.Insert(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    cleanPlan,
+	})
+}
+
+func UpdatePlan(c *gin.Context) {
+	statusOnly := c.Query("status_only")
+	plan := model.Plan{}
+	if err := c.ShouldBindJSON(&plan); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	cleanPlan, err := model.GetPlanById(plan.Id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if statusOnly != "" {
+		cleanPlan.Status = plan.Status
+	} else {
+		// If you add more fields, please also update plan.Update()
+		cleanPlan.Name = plan.Name
+		cleanPlan.PriceLabel = plan.PriceLabel
+		cleanPlan.Quota = plan.Quota
+		cleanPlan.Period = plan.Period
+		cleanPlan.Rollover = plan.Rollover
+		cleanPlan.RolloverCap = plan.RolloverCap
+		cleanPlan.Groups = plan.Groups
+		cleanPlan.Models = plan.Models
+	}
+	if err = cleanPlan.Validate(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = cleanPlan.Update(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    cleanPlan,
+	})
+}
+
+func DeletePlan(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if err := model.DeletePlanById(id); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+type assignPlanRequest struct {
+	UserId int `json:"user_id"`
+	PlanId int `json:"plan_id"`
+	// ExpireTime is when the subscription ends, 0 means never
+	ExpireTime int64 `json:"expire_time"`
+}
+
+// AssignPlan subscribes a user to a plan, replacing the plan the user had
+func AssignPlan(c *gin.Context) {
+	req := assignPlanRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	userPlan, err := model.AssignPlan(c.Request.Context(), req.UserId, req.PlanId, req.ExpireTime)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    userPlan,
+	})
+}
+
+// CancelUserPlan ends the subscription of a user
+func CancelUserPlan(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Param("user_id"))
+	if err := model.CancelUserPlan(c.Request.Context(), userId); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// GetUserPlan returns the plan of a user and the allocation left in the current period
+func GetUserPlan(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Param("user_id"))
+	respondUserPlan(c, userId)
+}
+
+// GetSelfPlan returns the plan of the current user and the allocation left in the current period
+func GetSelfPlan(c *gin.Context) {
+	respondUserPlan(c, c.GetInt(ctxkey.Id))
+}
+
+func respondUserPlan(c *gin.Context, userId int) {
+	status, err := model.GetUserPlanStatus(userId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    status,
+	})
+}
+
+// GetUserPlanHistory returns the plan history of a user
+func GetUserPlanHistory(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Param("user_id"))
+	respondPlanHistory(c, userId)
+}
+
+// GetSelfPlanHistory returns the plan history of the current user
+func GetSelfPlanHistory(c *gin.Context) {
+	respondPlanHistory(c, c.GetInt(ctxkey.Id))
+}
+
+func respondPlanHistory(c *gin.Context, userId int) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	histories, err := model.GetPlanHistories(userId, p*config.MaxItemsPerPage, config.MaxItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    histories,
+	})
+}
@@ -10,6 +10,8 @@ import (
 	"github.com/songquanpeng/one-api/common/metrics"
 	"github.com/songquanpeng/one-api/relay/channeltype"
 	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+	"github.com/songquanpeng/one-api/relay/routing"
 )
 
 // PrometheusRelayMonitor provides Prometheus monitoring for relay operations
@@ -38,6 +40,13 @@ func (p *PrometheusRelayMonitor) RecordRelayRequest(c *gin.Context, meta *meta.M
 	userBalance := float64(c.GetInt64(ctxkey.UserQuota)) // Assuming we can get user balance from context
 	metrics.GlobalRecorder.RecordUserMetrics(userId, username, group, quotaUsed, promptTokens, completionTokens, userBalance)
 
+	// Feed adaptive routing, the channel is not called when the response cache answers,
+	// and realtime sessions last as long as the client wants so their latency tells nothing
+	routing.DefaultTracker.Done(meta.ChannelId)
+	if !c.GetBool(ctxkey.ResponseCacheHit) && meta.Mode != relaymode.Realtime {
+		routing.DefaultTracker.Observe(meta.ChannelId, meta.OriginModelName, success, time.Since(startTime))
+	}
+
 	// Record model usage
 	if success {
 		latency := time.Since(startTime)
@@ -45,6 +54,12 @@ func (p *PrometheusRelayMonitor) RecordRelayRequest(c *gin.Context, meta *meta.M
 	}
 }
 
+// RecordCancelledRequest records a request cancelled by the relay itself, such as the slower attempt of a hedged request,
+// which tells nothing about the channel
+func (p *PrometheusRelayMonitor) RecordCancelledRequest(meta *meta.Meta) {
+	routing.DefaultTracker.Done(meta.ChannelId)
+}
+
 // RecordChannelRequest tracks channel-specific request metrics
 func (p *PrometheusRelayMonitor) RecordChannelRequest(meta *meta.Meta, startTime time.Time) {
 	channelIdStr := strconv.Itoa(meta.ChannelId)
@@ -53,6 +68,7 @@ func (p *PrometheusRelayMonitor) RecordChannelRequest(meta *meta.Meta, startTime
 
 	// Track requests in flight
 	metrics.GlobalRecorder.UpdateChannelRequestsInFlight(meta.ChannelId, channelName, channelType, 1)
+	routing.DefaultTracker.Start(meta.ChannelId)
 
 	// We'll update this when the request completes
 	go func() {
@@ -19,6 +19,11 @@ import (
 	"github.com/songquanpeng/one-api/middleware"
 	dbmodel "github.com/songquanpeng/one-api/model"
 	"github.com/songquanpeng/one-api/monitor"
+	"github.com/songquanpeng/one-api/relay/adaptor/anthropic"
+	"github.com/songquanpeng/one-api/relay/adaptor/gemini"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/circuitbreaker"
+	"github.com/songquanpeng/one-api/relay/concurrency"
 	"github.com/songquanpeng/one-api/relay/controller"
 	"github.com/songquanpeng/one-api/relay/meta"
 	"github.com/songquanpeng/one-api/relay/model"
@@ -28,6 +33,13 @@ import (
 // https://platform.openai.com/docs/api-reference/chat
 
 func relayHelper(c *gin.Context, relayMode int) *model.ErrorWithStatusCode {
+	// the request waits here while its channel is at its concurrency limit
+	slot, slotErr := concurrency.Acquire(c.Request.Context(), c.GetInt(ctxkey.ChannelId), c.GetInt(ctxkey.MaxConcurrency))
+	if slotErr != nil {
+		return openai.ErrorWrapper(slotErr, concurrency.ErrorCode, http.StatusTooManyRequests)
+	}
+	defer slot.Release()
+
 	var err *model.ErrorWithStatusCode
 	switch relayMode {
 	case relaymode.ImagesGenerations,
@@ -43,6 +55,16 @@ func relayHelper(c *gin.Context, relayMode int) *model.ErrorWithStatusCode {
 		err = controller.RelayProxyHelper(c, relayMode)
 	case relaymode.ResponseAPI:
 		err = controller.RelayResponseAPIHelper(c)
+	case relaymode.ClaudeMessages:
+		err = controller.RelayClaudeMessagesHelper(c)
+	case relaymode.GeminiGenerateContent:
+		err = controller.RelayGeminiGenerateContentHelper(c)
+	case relaymode.Assistants:
+		err = controller.RelayAssistantsHelper(c)
+	case relaymode.FineTuning:
+		err = controller.RelayFineTuningHelper(c)
+	case relaymode.Realtime:
+		err = controller.RelayRealtimeHelper(c)
 	default:
 		err = controller.RelayTextHelper(c)
 	}
@@ -64,9 +86,23 @@ func Relay(c *gin.Context) {
 	// Track channel request in flight
 	PrometheusMonitor.RecordChannelRequest(relayMeta, startTime)
 
-	bizErr := relayHelper(c, relayMode)
+	var bizErr *model.ErrorWithStatusCode
+	// hedgeFailedChannelId is the channel of the hedged attempt not returned, if it failed as well
+	var hedgeFailedChannelId int
+	if delay := hedgeDelay(c, relayMode); delay > 0 {
+		// the hedge request may answer on another channel, which is then the channel of c
+		startTime, hedgeFailedChannelId, bizErr = relayWithHedge(c, relayMode, delay, startTime)
+		channelId = c.GetInt(ctxkey.ChannelId)
+		relayMeta = meta.GetByContext(c)
+	} else {
+		bizErr = relayHelper(c, relayMode)
+	}
 	if bizErr == nil {
-		monitor.Emit(channelId, true)
+		// the channel is not called when the response cache answers
+		if !c.GetBool(ctxkey.ResponseCacheHit) {
+			monitor.Emit(channelId, true)
+			circuitbreaker.RecordSuccess(ctx, channelId, c.GetString(ctxkey.OriginalModel))
+		}
 
 		// Record successful relay request metrics
 		PrometheusMonitor.RecordRelayRequest(c, relayMeta, startTime, true, 0, 0, 0)
@@ -76,15 +112,16 @@ func Relay(c *gin.Context) {
 	channelName := c.GetString(ctxkey.ChannelName)
 	group := c.GetString(ctxkey.Group)
 	originalModel := c.GetString(ctxkey.OriginalModel)
-	go processChannelRelayError(ctx, userId, channelId, channelName, group, originalModel, *bizErr)
+	go processChannelRelayError(ctx, userId, channelId, channelName, c.GetString(ctxkey.ChannelKey), group, originalModel, *bizErr)
 
 	// Record failed relay request metrics
 	PrometheusMonitor.RecordRelayRequest(c, relayMeta, startTime, false, 0, 0, 0)
 
 	requestId := c.GetString(helper.RequestIdKey)
 	retryTimes := config.RetryTimes
-	if err := shouldRetry(c, bizErr.StatusCode); err != nil {
-		logger.Errorf(ctx, "relay error happen, won't retry since of %v", err.Error())
+	retryErr := shouldRetry(c, bizErr.StatusCode)
+	if retryErr != nil {
+		logger.Errorf(ctx, "relay error happen, won't retry since of %v", retryErr.Error())
 		retryTimes = 0
 	}
 
@@ -116,11 +153,57 @@ func Relay(c *gin.Context) {
 	// Track failed channels to avoid retrying them, especially for 429 errors
 	failedChannels := make(map[int]bool)
 	failedChannels[lastFailedChannelId] = true
+	if hedgeFailedChannelId != 0 {
+		failedChannels[hedgeFailedChannelId] = true
+	}
+	bizErr = retryOtherChannels(c, relayMode, bizErr, retryTimes, failedChannels)
+	if bizErr != nil && retryErr == nil {
+		bizErr = relayFallbackModels(c, relayMode, bizErr)
+	}
+
+	if bizErr != nil {
+		if bizErr.StatusCode == http.StatusTooManyRequests {
+			// Provide more specific messaging for 429 errors after exhausting retries
+			if len(failedChannels) > 1 {
+				bizErr.Error.Message = fmt.Sprintf("All available channels (%d) for this model are currently rate limited, please try again later", len(failedChannels))
+			} else {
+				bizErr.Error.Message = "The current group load is saturated, please try again later"
+			}
+		}
+
+		// BUG: bizErr is in race condition
+		bizErr.Error.Message = helper.MessageWithRequestId(bizErr.Error.Message, requestId)
+		if relayMode == relaymode.ClaudeMessages {
+			// native Anthropic clients only understand their own error format
+			c.JSON(bizErr.StatusCode, anthropic.ConvertErrorToMessages(bizErr))
+			return
+		}
+		if relayMode == relaymode.GeminiGenerateContent {
+			c.JSON(bizErr.StatusCode, gemini.ConvertErrorToGemini(bizErr))
+			return
+		}
+		c.JSON(bizErr.StatusCode, gin.H{
+			"error": bizErr.Error,
+		})
+	}
+}
+
+// retryOtherChannels retries the request up to retryTimes on channels of the same model,
+// excluding failedChannels which is updated with the channels failing again.
+// It returns nil once a channel succeeds, otherwise the last error.
+func retryOtherChannels(c *gin.Context, relayMode int, bizErr *model.ErrorWithStatusCode, retryTimes int, failedChannels map[int]bool) *model.ErrorWithStatusCode {
+	ctx := c.Request.Context()
+	userId := c.GetInt(ctxkey.Id)
+	requestId := c.GetString(helper.RequestIdKey)
+	group := c.GetString(ctxkey.Group)
+	originalModel := c.GetString(ctxkey.OriginalModel)
+	// requests of a session fail over to the same next channel
+	sessionKey := c.GetString(ctxkey.SessionKey)
 
 	// Debug logging to track channel exclusions (only when debug is enabled)
 	if config.DebugEnabled {
-		logger.Infof(ctx, "Debug: Starting retry logic - Initial failed channel: %d, Error: %d, Request ID: %s",
-			lastFailedChannelId, bizErr.StatusCode, requestId)
+		logger.Infof(ctx, "Debug: Starting retry logic - Initial failed channels: %v, Error: %d, Request ID: %s",
+			getChannelIds(failedChannels), bizErr.StatusCode, requestId)
 	}
 
 	// For 429 errors, we should try lower priority channels first
@@ -142,21 +225,21 @@ func Relay(c *gin.Context) {
 
 		if shouldTryLargerMaxTokensFirst {
 			// For 413 errors, try larger max_tokens channels
-			channel, err = dbmodel.CacheGetRandomSatisfiedChannelExcluding(group, originalModel, false, failedChannels, true)
+			channel, err = dbmodel.CacheGetRandomSatisfiedChannelExcluding(group, originalModel, false, failedChannels, true, sessionKey)
 		} else if shouldTryLowerPriorityFirst {
 			// For 429 errors, first try lower priority channels while excluding failed ones
-			channel, err = dbmodel.CacheGetRandomSatisfiedChannelExcluding(group, originalModel, true, failedChannels, false)
+			channel, err = dbmodel.CacheGetRandomSatisfiedChannelExcluding(group, originalModel, true, failedChannels, false, sessionKey)
 			if err != nil {
 				// If no lower priority channels available, try highest priority channels (excluding failed ones)
 				logger.Infof(ctx, "No lower priority channels available, trying highest priority channels, excluding: %v", getChannelIds(failedChannels))
-				channel, err = dbmodel.CacheGetRandomSatisfiedChannelExcluding(group, originalModel, false, failedChannels, false)
+				channel, err = dbmodel.CacheGetRandomSatisfiedChannelExcluding(group, originalModel, false, failedChannels, false, sessionKey)
 			}
 		} else {
 			// For non-429 errors, try highest priority first, then lower priority (excluding failed ones)
-			channel, err = dbmodel.CacheGetRandomSatisfiedChannelExcluding(group, originalModel, false, failedChannels, false)
+			channel, err = dbmodel.CacheGetRandomSatisfiedChannelExcluding(group, originalModel, false, failedChannels, false, sessionKey)
 			if err != nil {
 				logger.Infof(ctx, "No highest priority channels available, trying lower priority channels, excluding: %v", getChannelIds(failedChannels))
-				channel, err = dbmodel.CacheGetRandomSatisfiedChannelExcluding(group, originalModel, true, failedChannels, false)
+				channel, err = dbmodel.CacheGetRandomSatisfiedChannelExcluding(group, originalModel, true, failedChannels, false, sessionKey)
 			}
 		}
 
@@ -178,12 +261,16 @@ func Relay(c *gin.Context) {
 		// Record retry attempt
 		retryStartTime := time.Now()
 		retryMeta := meta.GetByContext(c)
+		PrometheusMonitor.RecordChannelRequest(retryMeta, retryStartTime)
 
 		bizErr = relayHelper(c, relayMode)
 		if bizErr == nil {
+			if !c.GetBool(ctxkey.ResponseCacheHit) {
+				circuitbreaker.RecordSuccess(ctx, channel.Id, originalModel)
+			}
 			// Record successful retry
 			PrometheusMonitor.RecordRelayRequest(c, retryMeta, retryStartTime, true, 0, 0, 0)
-			return
+			return nil
 		}
 
 		// Record failed retry
@@ -191,7 +278,6 @@ func Relay(c *gin.Context) {
 
 		channelId := c.GetInt(ctxkey.ChannelId)
 		failedChannels[channelId] = true // Track this failed channel
-		lastFailedChannelId = channelId
 
 		// Debug logging to track which channels are being added to failed list (only when debug is enabled)
 		if config.DebugEnabled {
@@ -202,25 +288,10 @@ func Relay(c *gin.Context) {
 		// Update group and originalModel potentially if changed by middleware, though unlikely for these.
 		group = c.GetString(ctxkey.Group)
 		originalModel = c.GetString(ctxkey.OriginalModel)
-		go processChannelRelayError(ctx, userId, channelId, channelName, group, originalModel, *bizErr)
+		go processChannelRelayError(ctx, userId, channelId, channelName, c.GetString(ctxkey.ChannelKey), group, originalModel, *bizErr)
 	}
 
-	if bizErr != nil {
-		if bizErr.StatusCode == http.StatusTooManyRequests {
-			// Provide more specific messaging for 429 errors after exhausting retries
-			if len(failedChannels) > 1 {
-				bizErr.Error.Message = fmt.Sprintf("All available channels (%d) for this model are currently rate limited, please try again later", len(failedChannels))
-			} else {
-				bizErr.Error.Message = "The current group load is saturated, please try again later"
-			}
-		}
-
-		// BUG: bizErr is in race condition
-		bizErr.Error.Message = helper.MessageWithRequestId(bizErr.Error.Message, requestId)
-		c.JSON(bizErr.StatusCode, gin.H{
-			"error": bizErr.Error,
-		})
-	}
+	return bizErr
 }
 
 // shouldRetry returns nil if should retry, otherwise returns error
@@ -290,9 +361,14 @@ func logChannelSuspensionStatus(ctx context.Context, group, model string, failed
 	}
 }
 
-func processChannelRelayError(ctx context.Context, userId int, channelId int, channelName string, group string, originalModel string, err model.ErrorWithStatusCode) {
+func processChannelRelayError(ctx context.Context, userId int, channelId int, channelName string, channelKey string, group string, originalModel string, err model.ErrorWithStatusCode) {
 	logger.Errorf(ctx, "relay error (channel id %d, name %s, user_id %d, group: %s, model: %s): %s", channelId, channelName, userId, group, originalModel, err.Message)
 
+	// the request was not sent to the channel, which is only busy with others
+	if err.Code == concurrency.ErrorCode {
+		return
+	}
+
 	// Handle 400 errors differently - they are client request issues, not channel problems
 	if err.StatusCode == http.StatusBadRequest {
 		// For 400 errors, log but don't disable channel or suspend abilities
@@ -300,23 +376,37 @@ func processChannelRelayError(ctx context.Context, userId int, channelId int, ch
 		logger.Infof(ctx, "client request error (400) for channel %d (%s) - not disabling channel as this is not a channel issue", channelId, channelName)
 		// Still emit failure for monitoring purposes, but don't disable the channel
 		monitor.Emit(channelId, false)
+		// the channel has answered, so a probe of a half-open circuit ends here
+		circuitbreaker.RecordSuccess(ctx, channelId, originalModel)
 		return
 	}
 
 	if err.StatusCode == http.StatusTooManyRequests {
-		// For 429, we will suspend the specific model for a while
-		logger.Infof(ctx, "suspending model %s in group %s on channel %d (%s) due to rate limit", originalModel, group, channelId, channelName)
-		if suspendErr := dbmodel.SuspendAbility(ctx,
-			group, originalModel, channelId,
-			config.ChannelSuspendSecondsFor429); suspendErr != nil {
-			logger.Errorf(ctx, "failed to suspend ability for channel %d, model %s, group %s: %v", channelId, originalModel, group, errors.Wrap(suspendErr, "suspend ability failed"))
+		if config.CircuitBreakerEnabled {
+			// For 429, the circuit breaker opens the circuit of the model on this channel in all groups
+			circuitbreaker.Trip(ctx, channelId, originalModel, config.ChannelSuspendSecondsFor429)
+		} else {
+			// For 429, we will suspend the specific model for a while
+			logger.Infof(ctx, "suspending model %s in group %s on channel %d (%s) due to rate limit", originalModel, group, channelId, channelName)
+			if suspendErr := dbmodel.SuspendAbility(ctx,
+				group, originalModel, channelId,
+				config.ChannelSuspendSecondsFor429); suspendErr != nil {
+				logger.Errorf(ctx, "failed to suspend ability for channel %d, model %s, group %s: %v", channelId, originalModel, group, errors.Wrap(suspendErr, "suspend ability failed"))
+			}
 		}
+	} else {
+		circuitbreaker.RecordFailure(ctx, channelId, originalModel)
 	}
 
 	// Only disable channel for server errors (5xx) or specific client errors that indicate channel issues
 	// 400 errors are client request problems and should not disable channels
 	if monitor.ShouldDisableChannel(&err.Error, err.StatusCode) {
-		monitor.DisableChannel(channelId, channelName, err.Message)
+		if channelKey != "" {
+			// only the key used is disabled for channels holding multiple keys
+			monitor.DisableChannelKey(channelId, channelName, channelKey, err.Message)
+		} else {
+			monitor.DisableChannel(channelId, channelName, err.Message)
+		}
 	} else {
 		monitor.Emit(channelId, false)
 	}
@@ -16,6 +16,9 @@ import (
 	"github.com/songquanpeng/one-api/common/network"
 	"github.com/songquanpeng/one-api/common/random"
 	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/fallback"
+	"github.com/songquanpeng/one-api/relay/ratelimit"
+	"github.com/songquanpeng/one-api/relay/responsecache"
 )
 
 func GetRequestCost(c *gin.Context) {
@@ -95,6 +98,30 @@ func GetToken(c *gin.Context) {
 	return
 }
 
+// GetTokenSpend returns the spend and the spend limits of a token of the user in the current periods
+func GetTokenSpend(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		helper.RespondError(c, err)
+		return
+	}
+	token, err := model.GetTokenByIds(id, c.GetInt(ctxkey.Id))
+	if err != nil {
+		helper.RespondError(c, err)
+		return
+	}
+	spends, err := model.GetTokenPeriodSpends(token)
+	if err != nil {
+		helper.RespondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    spends,
+	})
+}
+
 func GetTokenStatus(c *gin.Context) {
 	tokenId := c.GetInt(ctxkey.TokenId)
 	userId := c.GetInt(ctxkey.Id)
@@ -128,6 +155,28 @@ func validateToken(_ *gin.Context, token *model.Token) error {
 		}
 	}
 
+	if !responsecache.IsValidMode(token.ResponseCacheMode) {
+		return fmt.Errorf("invalid response cache mode: %s", token.ResponseCacheMode)
+	}
+
+	if token.ModelFallbacks != nil {
+		if _, err := fallback.ParseChains(*token.ModelFallbacks); err != nil {
+			return fmt.Errorf("invalid model fallbacks: %s", err.Error())
+		}
+	}
+
+	if token.HedgeDelayMs < 0 {
+		return fmt.Errorf("invalid hedge delay: %d", token.HedgeDelayMs)
+	}
+
+	if err := (ratelimit.Limits{RPM: token.RPMLimit, TPM: token.TPMLimit}).Validate(); err != nil {
+		return err
+	}
+
+	if err := token.SpendLimits().Validate(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -159,6 +208,15 @@ func AddToken(c *gin.Context) {
 		UnlimitedQuota: token.UnlimitedQuota,
 		Models:         token.Models,
 		Subnet:         token.Subnet,
+
+		ResponseCacheMode: token.ResponseCacheMode,
+		ModelFallbacks:    token.ModelFallbacks,
+		HedgeDelayMs:      token.HedgeDelayMs,
+		RPMLimit:          token.RPMLimit,
+		TPMLimit:          token.TPMLimit,
+		DailySpendLimit:   token.DailySpendLimit,
+		WeeklySpendLimit:  token.WeeklySpendLimit,
+		MonthlySpendLimit: token.MonthlySpendLimit,
 	}
 	err = cleanToken.Insert()
 	if err != nil {
@@ -239,7 +297,7 @@ func ConsumeToken(c *gin.Context) {
 	}
 
 	// Transaction: decrease quota and record log
-	if err = model.DecreaseTokenQuota(cleanToken.Id, int64(tokenPatch.AddUsedQuota)); err != nil {
+	if err = model.DecreaseTokenQuota(ctx, cleanToken.Id, int64(tokenPatch.AddUsedQuota), model.LedgerReasonConsume); err != nil {
 		helper.RespondError(c, err)
 		return
 	}
@@ -256,7 +314,7 @@ func ConsumeToken(c *gin.Context) {
 
 	// Update token data
 	cleanToken.RemainQuota -= int64(tokenPatch.AddUsedQuota)
-	if err = cleanToken.Update(); err != nil {
+	if err = cleanToken.Update(ctx); err != nil {
 		helper.RespondError(c, err)
 		return
 	}
@@ -357,11 +415,19 @@ func UpdateToken(c *gin.Context) {
 		cleanToken.UnlimitedQuota = token.UnlimitedQuota
 		cleanToken.Models = token.Models
 		cleanToken.Subnet = token.Subnet
+		cleanToken.ResponseCacheMode = token.ResponseCacheMode
+		cleanToken.ModelFallbacks = token.ModelFallbacks
+		cleanToken.HedgeDelayMs = token.HedgeDelayMs
+		cleanToken.RPMLimit = token.RPMLimit
+		cleanToken.TPMLimit = token.TPMLimit
+		cleanToken.DailySpendLimit = token.DailySpendLimit
+		cleanToken.WeeklySpendLimit = token.WeeklySpendLimit
+		cleanToken.MonthlySpendLimit = token.MonthlySpendLimit
 		cleanToken.RemainQuota = token.RemainQuota
 		cleanToken.Status = token.Status
 	}
 
-	err = cleanToken.Update()
+	err = cleanToken.Update(c.Request.Context())
 	if err != nil {
 		helper.RespondError(c, err)
 		return
@@ -20,6 +20,7 @@ import (
 	"github.com/songquanpeng/one-api/common/random"
 	"github.com/songquanpeng/one-api/middleware"
 	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/ratelimit"
 )
 
 type LoginRequest struct {
@@ -578,6 +579,23 @@ func GetSelfByToken(c *gin.Context) {
 	return
 }
 
+// GetSelfSpend returns the spend and the spend limits of the current user in the current periods
+func GetSelfSpend(c *gin.Context) {
+	spends, err := model.GetUserPeriodSpends(c.GetInt(ctxkey.Id))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    spends,
+	})
+}
+
 func GetSelf(c *gin.Context) {
 	id := c.GetInt(ctxkey.Id)
 	user, err := model.GetUserById(id, false)
@@ -617,6 +635,20 @@ func UpdateUser(c *gin.Context) {
 		})
 		return
 	}
+	if err := (ratelimit.Limits{RPM: updatedUser.RPMLimit, TPM: updatedUser.TPMLimit}).Validate(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err := updatedUser.SpendLimits().Validate(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
 	originUser, err := model.GetUserById(updatedUser.Id, false)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -644,6 +676,9 @@ func UpdateUser(c *gin.Context) {
 		updatedUser.Password = "" // rollback to what it should be
 	}
 	updatePassword := updatedUser.Password != ""
+	// the quota is set on its own with its ledger entry, Update skips it as a zero value
+	quota := updatedUser.Quota
+	updatedUser.Quota = 0
 	if err := updatedUser.Update(updatePassword); err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -651,8 +686,29 @@ func UpdateUser(c *gin.Context) {
 		})
 		return
 	}
-	if originUser.Quota != updatedUser.Quota {
-		model.RecordLog(ctx, originUser.Id, model.LogTypeManage, fmt.Sprintf("Admin changed user quota from %s to %s", common.LogQuota(originUser.Quota), common.LogQuota(updatedUser.Quota)))
+	if err := updatedUser.UpdateRateLimits(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err := updatedUser.UpdateSpendLimits(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if originUser.Quota != quota {
+		if err := model.SetUserQuota(ctx, originUser.Id, quota); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		model.RecordLog(ctx, originUser.Id, model.LogTypeManage, fmt.Sprintf("Admin changed user quota from %s to %s", common.LogQuota(originUser.Quota), common.LogQuota(quota)))
 	}
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -1028,7 +1084,7 @@ func AdminTopUp(c *gin.Context) {
 		})
 		return
 	}
-	err = model.IncreaseUserQuota(req.UserId, int64(req.Quota))
+	err = model.IncreaseUserQuota(ctx, req.UserId, int64(req.Quota), model.LedgerReasonTopup)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
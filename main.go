@@ -23,6 +23,7 @@ import (
 	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/i18n"
 	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/storage"
 	"github.com/songquanpeng/one-api/controller"
 	"github.com/songquanpeng/one-api/middleware"
 	"github.com/songquanpeng/one-api/model"
@@ -124,6 +125,18 @@ func main() {
 	openai.InitTokenEncoders()
 	client.Init()
 
+	// Initialize storage of uploaded files
+	if err := storage.Init(); err != nil {
+		logger.FatalLog("failed to initialize file storage: " + err.Error())
+	}
+	if config.IsMasterNode {
+		controller.StartBatchRunner()
+		go model.SyncUserPlans(config.PlanRenewFrequency)
+		if config.LedgerReconcileFrequency > 0 {
+			go model.SyncLedgerReconciliation(config.LedgerReconcileFrequency, config.LedgerReconcileFix)
+		}
+	}
+
 	// Initialize global pricing manager
 	relay.InitializeGlobalPricing()
 
@@ -0,0 +1,147 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/songquanpeng/one-api/model"
+)
+
+func TestGetAssistantChannelId(t *testing.T) {
+	testDB, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, testDB.AutoMigrate(&model.AssistantObject{}))
+	originalDB := model.DB
+	model.DB = testDB
+	defer func() { model.DB = originalDB }()
+
+	older := model.NewAssistantObject("asst_1", model.AssistantObjectAssistant, 1, 1, 7)
+	older.CreatedTime = 100
+	require.NoError(t, older.Insert())
+	latest := model.NewAssistantObject("asst_2", model.AssistantObjectAssistant, 1, 1, 8)
+	latest.CreatedTime = 200
+	require.NoError(t, latest.Insert())
+	require.NoError(t, model.NewAssistantObject("thread_1", model.AssistantObjectThread, 1, 1, 7).Insert())
+
+	newContext := func(method, path, id, body string) *gin.Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(method, path, strings.NewReader(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		if id != "" {
+			c.Params = gin.Params{{Key: "id", Value: id}}
+		}
+		return c
+	}
+
+	tests := []struct {
+		name        string
+		c           *gin.Context
+		userId      int
+		expectedId  int
+		expectedErr error
+		expectErr   bool
+	}{
+		{
+			name:       "create_assistant",
+			c:          newContext(http.MethodPost, "/v1/assistants", "", `{"model":"gpt-4o"}`),
+			userId:     1,
+			expectedId: 0,
+		},
+		{
+			name:       "retrieve_own_assistant",
+			c:          newContext(http.MethodGet, "/v1/assistants/asst_1", "asst_1", ""),
+			userId:     1,
+			expectedId: 7,
+		},
+		{
+			name:      "retrieve_assistant_of_other_user",
+			c:         newContext(http.MethodGet, "/v1/assistants/asst_1", "asst_1", ""),
+			userId:    2,
+			expectErr: true,
+		},
+		{
+			name:      "retrieve_thread_as_assistant",
+			c:         newContext(http.MethodGet, "/v1/assistants/thread_1", "thread_1", ""),
+			userId:    1,
+			expectErr: true,
+		},
+		{
+			name:       "list_assistants",
+			c:          newContext(http.MethodGet, "/v1/assistants", "", ""),
+			userId:     1,
+			expectedId: 8,
+		},
+		{
+			name:        "list_assistants_without_any",
+			c:           newContext(http.MethodGet, "/v1/assistants", "", ""),
+			userId:      2,
+			expectedErr: errNoAssistant,
+		},
+		{
+			name:       "create_thread",
+			c:          newContext(http.MethodPost, "/v1/threads", "", `{}`),
+			userId:     1,
+			expectedId: 8,
+		},
+		{
+			name:        "create_thread_without_assistant",
+			c:           newContext(http.MethodPost, "/v1/threads", "", `{}`),
+			userId:      2,
+			expectedErr: errNoAssistant,
+		},
+		{
+			name:       "create_thread_and_run",
+			c:          newContext(http.MethodPost, "/v1/threads/runs", "", `{"assistant_id":"asst_1"}`),
+			userId:     1,
+			expectedId: 7,
+		},
+		{
+			name:       "create_run",
+			c:          newContext(http.MethodPost, "/v1/threads/thread_1/runs", "thread_1", `{"assistant_id":"asst_1"}`),
+			userId:     1,
+			expectedId: 7,
+		},
+		{
+			name:      "create_run_with_assistant_of_another_channel",
+			c:         newContext(http.MethodPost, "/v1/threads/thread_1/runs", "thread_1", `{"assistant_id":"asst_2"}`),
+			userId:    1,
+			expectErr: true,
+		},
+		{
+			name:      "list_messages_of_other_user",
+			c:         newContext(http.MethodGet, "/v1/threads/thread_1/messages", "thread_1", ""),
+			userId:    2,
+			expectErr: true,
+		},
+		{
+			name:       "not_assistants_api",
+			c:          newContext(http.MethodPost, "/v1/chat/completions", "", `{"assistant_id":"asst_1"}`),
+			userId:     1,
+			expectedId: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			channelId, err := getAssistantChannelId(tt.c, tt.userId)
+			if tt.expectedErr != nil {
+				require.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+			if tt.expectErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedId, channelId)
+		})
+	}
+}
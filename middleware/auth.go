@@ -196,18 +196,39 @@ func TokenAuth() func(c *gin.Context) {
 		// Check if token has model restrictions and validate access
 		if token.Models != nil && *token.Models != "" {
 			c.Set(ctxkey.AvailableModels, *token.Models)
-			if requestModel != "" && !isModelInList(requestModel, *token.Models) {
+			if requestModel != "" && !IsModelInList(requestModel, *token.Models) {
 				AbortWithError(c, http.StatusForbidden, errors.Errorf("This API key does not have permission to use the model: %s", requestModel))
 				return
 			}
 		}
 
+		// Check if the plan of the token owner restricts the models
+		if requestModel != "" {
+			allowed, err := model.UserPlanAllowsModel(token.UserId, requestModel)
+			if err != nil {
+				AbortWithError(c, http.StatusInternalServerError, err)
+				return
+			}
+			if !allowed {
+				AbortWithError(c, http.StatusForbidden, errors.Errorf("The plan of this user does not include the model: %s", requestModel))
+				return
+			}
+		}
+
 		// Set token-related context for downstream handlers
 		c.Set(ctxkey.Id, token.UserId)
 		c.Set(ctxkey.TokenId, token.Id)
 		c.Set(ctxkey.TokenName, token.Name)
 		c.Set(ctxkey.TokenQuota, token.RemainQuota)
 		c.Set(ctxkey.TokenQuotaUnlimited, token.UnlimitedQuota)
+		c.Set(ctxkey.ResponseCacheMode, token.ResponseCacheMode)
+		if token.ModelFallbacks != nil {
+			c.Set(ctxkey.ModelFallbacks, *token.ModelFallbacks)
+		}
+		c.Set(ctxkey.HedgeDelay, token.HedgeDelayMs)
+		c.Set(ctxkey.TokenRPMLimit, token.RPMLimit)
+		c.Set(ctxkey.TokenTPMLimit, token.TPMLimit)
+		c.Set(ctxkey.TokenSpendLimits, token.SpendLimits())
 
 		// Handle channel-specific routing (admin feature)
 		// Format: token_key:channel_id allows admins to specify which channel to use
@@ -252,6 +273,12 @@ func shouldCheckModel(c *gin.Context) bool {
 	if strings.HasPrefix(c.Request.URL.Path, "/v1/chat/completions") {
 		return true
 	}
+	if strings.HasPrefix(c.Request.URL.Path, "/v1/messages") {
+		return true
+	}
+	if strings.HasPrefix(c.Request.URL.Path, "/v1beta/models/") {
+		return true
+	}
 	if strings.HasPrefix(c.Request.URL.Path, "/v1/images") {
 		return true
 	}
@@ -14,6 +14,7 @@ import (
 	"github.com/songquanpeng/one-api/model"
 	"github.com/songquanpeng/one-api/relay/billing/ratio"
 	"github.com/songquanpeng/one-api/relay/channeltype"
+	"github.com/songquanpeng/one-api/relay/relaymode"
 )
 
 type ModelRequest struct {
@@ -29,6 +30,44 @@ func Distribute() func(c *gin.Context) {
 		var requestModel string
 		var channel *model.Channel
 		channelId := c.GetInt(ctxkey.SpecificChannelId)
+		if channelId == 0 {
+			// follow-up calls of the Response API must reach the channel that produced the response
+			var err error
+			if channelId, err = getResponseChannelId(c, userId); err != nil {
+				AbortWithError(c, http.StatusNotFound, err)
+				return
+			}
+			if channelId != 0 {
+				// other channels know nothing about the response, so retry is pointless
+				c.Set(ctxkey.SpecificChannelId, channelId)
+			}
+		}
+		if channelId == 0 {
+			// assistants, threads, runs and fine-tuning jobs only exist in the channel that created them
+			var err error
+			switch relaymode.GetByPath(c.Request.URL.Path) {
+			case relaymode.Assistants:
+				channelId, err = getAssistantChannelId(c, userId)
+			case relaymode.FineTuning:
+				channelId, err = getFineTuningChannelId(c, userId)
+			}
+			if err != nil {
+				switch {
+				case (errors.Is(err, errNoAssistant) || errors.Is(err, errNoFineTuningJob)) && c.Request.Method == http.MethodGet:
+					// nothing to list before the first object
+					c.JSON(http.StatusOK, gin.H{"object": "list", "data": []any{}, "has_more": false})
+					c.Abort()
+				case errors.Is(err, errNoAssistant):
+					AbortWithError(c, http.StatusBadRequest, err)
+				default:
+					AbortWithError(c, http.StatusNotFound, err)
+				}
+				return
+			}
+			if channelId != 0 {
+				c.Set(ctxkey.SpecificChannelId, channelId)
+			}
+		}
 		if channelId != 0 {
 			var err error
 			channel, err = model.GetChannelById(channelId, true)
@@ -42,13 +81,18 @@ func Distribute() func(c *gin.Context) {
 			}
 		} else {
 			requestModel = c.GetString(ctxkey.RequestModel)
+			sessionKey := getSessionKey(c, userGroup)
+			if sessionKey != "" {
+				c.Set(ctxkey.SessionKey, sessionKey)
+				logger.Debugf(ctx, "route request by sticky session %s", sessionKey)
+			}
 			var err error
 			// First try to get highest priority channels
-			channel, err = model.CacheGetRandomSatisfiedChannel(userGroup, requestModel, false)
+			channel, err = model.CacheGetRandomSatisfiedChannel(userGroup, requestModel, false, sessionKey)
 			if err != nil {
 				// If no highest priority channels available, try lower priority channels as fallback
 				logger.Infof(ctx, "No highest priority channels available for model %s in group %s, trying lower priority channels", requestModel, userGroup)
-				channel, err = model.CacheGetRandomSatisfiedChannel(userGroup, requestModel, true)
+				channel, err = model.CacheGetRandomSatisfiedChannel(userGroup, requestModel, true, sessionKey)
 				if err != nil {
 					message := fmt.Sprintf("No available channels for Model %s under Group %s", requestModel, userGroup)
 					if channel != nil {
@@ -95,8 +139,14 @@ func SetupContextForSelectedChannel(c *gin.Context, channel *model.Channel, mode
 	}
 	c.Set(ctxkey.ModelMapping, channel.GetModelMapping())
 	c.Set(ctxkey.OriginalModel, modelName) // for retry
-	c.Request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", channel.Key))
+	key, keyFingerprint := channel.SelectKey()
+	c.Set(ctxkey.ChannelKey, keyFingerprint)
+	c.Request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", key))
+	// native Anthropic and Gemini clients authenticate by their own headers, which must not be forwarded to upstream
+	c.Request.Header.Del("x-api-key")
+	c.Request.Header.Del("x-goog-api-key")
 	c.Set(ctxkey.BaseURL, channel.GetBaseURL())
+	c.Set(ctxkey.MaxConcurrency, channel.GetMaxConcurrency())
 	if channel.RateLimit != nil {
 		c.Set(ctxkey.RateLimit, *channel.RateLimit)
 	} else {
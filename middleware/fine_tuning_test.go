@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/songquanpeng/one-api/model"
+)
+
+func TestGetFineTuningChannelId(t *testing.T) {
+	testDB, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, testDB.AutoMigrate(&model.FineTuningJob{}))
+	originalDB := model.DB
+	model.DB = testDB
+	defer func() { model.DB = originalDB }()
+
+	older := model.NewFineTuningJob("ftjob-1", 1, 1, 7, "gpt-4o-mini-2024-07-18")
+	older.CreatedTime = 100
+	require.NoError(t, older.Insert())
+	latest := model.NewFineTuningJob("ftjob-2", 1, 1, 8, "gpt-4o-mini-2024-07-18")
+	latest.CreatedTime = 200
+	require.NoError(t, latest.Insert())
+
+	newContext := func(method, path, id string) *gin.Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(method, path, nil)
+		if id != "" {
+			c.Params = gin.Params{{Key: "id", Value: id}}
+		}
+		return c
+	}
+
+	tests := []struct {
+		name        string
+		c           *gin.Context
+		userId      int
+		expectedId  int
+		expectedErr error
+		expectErr   bool
+	}{
+		{
+			name:       "create_job",
+			c:          newContext(http.MethodPost, "/v1/fine_tuning/jobs", ""),
+			userId:     1,
+			expectedId: 0,
+		},
+		{
+			name:       "retrieve_own_job",
+			c:          newContext(http.MethodGet, "/v1/fine_tuning/jobs/ftjob-1", "ftjob-1"),
+			userId:     1,
+			expectedId: 7,
+		},
+		{
+			name:       "cancel_own_job",
+			c:          newContext(http.MethodPost, "/v1/fine_tuning/jobs/ftjob-2/cancel", "ftjob-2"),
+			userId:     1,
+			expectedId: 8,
+		},
+		{
+			name:      "list_events_of_other_user",
+			c:         newContext(http.MethodGet, "/v1/fine_tuning/jobs/ftjob-1/events", "ftjob-1"),
+			userId:    2,
+			expectErr: true,
+		},
+		{
+			name:       "list_jobs",
+			c:          newContext(http.MethodGet, "/v1/fine_tuning/jobs?limit=10", ""),
+			userId:     1,
+			expectedId: 8,
+		},
+		{
+			name:        "list_jobs_without_any",
+			c:           newContext(http.MethodGet, "/v1/fine_tuning/jobs", ""),
+			userId:      2,
+			expectedErr: errNoFineTuningJob,
+		},
+		{
+			name:       "not_fine_tuning_api",
+			c:          newContext(http.MethodGet, "/v1/models/ftjob-1", "ftjob-1"),
+			userId:     2,
+			expectedId: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			channelId, err := getFineTuningChannelId(tt.c, tt.userId)
+			if tt.expectedErr != nil {
+				require.ErrorIs(t, err, tt.expectedErr)
+				return
+			}
+			if tt.expectErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedId, channelId)
+		})
+	}
+}
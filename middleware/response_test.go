@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/songquanpeng/one-api/model"
+)
+
+func TestGetResponseChannelId(t *testing.T) {
+	testDB, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, testDB.AutoMigrate(&model.ResponseRecord{}))
+	originalDB := model.DB
+	model.DB = testDB
+	defer func() { model.DB = originalDB }()
+
+	require.NoError(t, model.NewResponseRecord("resp_1", 1, 1, 7, "gpt-4o").Insert())
+
+	newContext := func(method, path, responseId, body string) *gin.Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(method, path, strings.NewReader(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		if responseId != "" {
+			c.Params = gin.Params{{Key: "response_id", Value: responseId}}
+		}
+		return c
+	}
+
+	tests := []struct {
+		name       string
+		c          *gin.Context
+		userId     int
+		expectedId int
+		expectErr  bool
+	}{
+		{
+			name:       "retrieve_own_response",
+			c:          newContext(http.MethodGet, "/v1/responses/resp_1", "resp_1", ""),
+			userId:     1,
+			expectedId: 7,
+		},
+		{
+			name:      "retrieve_response_of_other_user",
+			c:         newContext(http.MethodGet, "/v1/responses/resp_1", "resp_1", ""),
+			userId:    2,
+			expectErr: true,
+		},
+		{
+			name:      "retrieve_unknown_response",
+			c:         newContext(http.MethodDelete, "/v1/responses/resp_2", "resp_2", ""),
+			userId:    1,
+			expectErr: true,
+		},
+		{
+			name:       "continue_own_response",
+			c:          newContext(http.MethodPost, "/v1/responses", "", `{"model":"gpt-4o","previous_response_id":"resp_1"}`),
+			userId:     1,
+			expectedId: 7,
+		},
+		{
+			name:       "continue_unknown_response",
+			c:          newContext(http.MethodPost, "/v1/responses", "", `{"model":"gpt-4o","previous_response_id":"resp_2"}`),
+			userId:     1,
+			expectedId: 0,
+		},
+		{
+			name:       "not_response_api",
+			c:          newContext(http.MethodPost, "/v1/chat/completions", "", `{"previous_response_id":"resp_1"}`),
+			userId:     1,
+			expectedId: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			channelId, err := getResponseChannelId(tt.c, tt.userId)
+			if tt.expectErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedId, channelId)
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	gmw "github.com/Laisky/gin-middlewares/v6"
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/ratelimit"
+)
+
+// UsageRateLimit limits the requests and tokens per minute of the token, the user and the group of the request.
+// The tokens estimated from the request are reserved before it is relayed, and replaced by the tokens it used
+// once it is done. The most restrictive limits are reported in OpenAI style x-ratelimit-* headers.
+func UsageRateLimit() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		ctx := gmw.Ctx(c)
+		scopes := []ratelimit.Scope{
+			ratelimit.TokenScope(c.GetInt(ctxkey.TokenId), ratelimit.Limits{
+				RPM: c.GetInt64(ctxkey.TokenRPMLimit),
+				TPM: c.GetInt64(ctxkey.TokenTPMLimit),
+			}),
+			ratelimit.GroupScope(c.GetString(ctxkey.Group), ratelimit.GetGroupLimits(c.GetString(ctxkey.Group))),
+		}
+		userId := c.GetInt(ctxkey.Id)
+		rpm, tpm, err := model.CacheGetUserRateLimits(userId)
+		if err != nil {
+			logger.Errorf(ctx, "failed to get rate limits of user %d: %+v", userId, err)
+		} else {
+			scopes = append(scopes, ratelimit.UserScope(userId, ratelimit.Limits{RPM: rpm, TPM: tpm}))
+		}
+
+		limited, tokensLimited := false, false
+		for _, scope := range scopes {
+			limited = limited || scope.Limits.Enabled()
+			tokensLimited = tokensLimited || scope.Limits.TPM > 0
+		}
+		if !limited {
+			c.Next()
+			return
+		}
+
+		var estimated int64
+		if tokensLimited {
+			estimated = estimateRequestTokens(c)
+		}
+		reservation, status, err := ratelimit.Reserve(ctx, scopes, estimated)
+		setRateLimitHeaders(c, status)
+		if err != nil {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(status.Reset.Seconds()))))
+			AbortWithError(c, http.StatusTooManyRequests, err)
+			return
+		}
+
+		c.Next()
+
+		used, reported := c.Get(ctxkey.UsageTokens)
+		if !reported {
+			// keep the estimate of requests served without reporting usage, failed ones used nothing
+			if c.Writer.Status() < http.StatusBadRequest {
+				return
+			}
+			used = int64(0)
+		}
+		usedTokens, _ := used.(int64)
+		reservation.Settle(context.WithoutCancel(ctx), usedTokens)
+	}
+}
+
+// setRateLimitHeaders reports the limits of status the way OpenAI does
+func setRateLimitHeaders(c *gin.Context, status ratelimit.Status) {
+	reset := status.Reset.Round(time.Millisecond).String()
+	if status.LimitRequests > 0 {
+		c.Header("x-ratelimit-limit-requests", strconv.FormatInt(status.LimitRequests, 10))
+		c.Header("x-ratelimit-remaining-requests", strconv.FormatInt(status.RemainingRequests, 10))
+		c.Header("x-ratelimit-reset-requests", reset)
+	}
+	if status.LimitTokens > 0 {
+		c.Header("x-ratelimit-limit-tokens", strconv.FormatInt(status.LimitTokens, 10))
+		c.Header("x-ratelimit-remaining-tokens", strconv.FormatInt(status.RemainingTokens, 10))
+		c.Header("x-ratelimit-reset-tokens", reset)
+	}
+}
+
+// estimateRequestTokens estimates the tokens the request would use, which are about 4 bytes of its body per token
+// plus the output tokens it asks for at most
+func estimateRequestTokens(c *gin.Context) int64 {
+	if !strings.HasPrefix(c.Request.Header.Get("Content-Type"), "application/json") {
+		return 0
+	}
+	body, err := common.GetRequestBody(c)
+	if err != nil {
+		return 0
+	}
+
+	var request struct {
+		MaxTokens           int64 `json:"max_tokens"`
+		MaxCompletionTokens int64 `json:"max_completion_tokens"`
+		MaxOutputTokens     int64 `json:"max_output_tokens"`
+		GenerationConfig    struct {
+			MaxOutputTokens int64 `json:"maxOutputTokens"`
+		} `json:"generationConfig"`
+	}
+	_ = json.Unmarshal(body, &request)
+	maxOutputTokens := max(request.MaxTokens, request.MaxCompletionTokens,
+		request.MaxOutputTokens, request.GenerationConfig.MaxOutputTokens)
+
+	return int64(textLength(body))/4 + maxOutputTokens
+}
+
+// textLength returns the length of body without its data URLs, e.g. base64 images, which are not text
+func textLength(body []byte) int {
+	dataURL := []byte(`"data:`)
+	length := 0
+	for {
+		start := bytes.Index(body, dataURL)
+		if start < 0 {
+			return length + len(body)
+		}
+		length += start
+		end := bytes.IndexByte(body[start+1:], '"')
+		if end < 0 {
+			return length
+		}
+		body = body[start+1+end+1:]
+	}
+}
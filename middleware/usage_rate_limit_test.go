@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/model"
+)
+
+func TestUsageRateLimit(t *testing.T) {
+	testDB, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, testDB.AutoMigrate(&model.User{}))
+	originalDB, originalRedisEnabled := model.DB, common.RedisEnabled
+	model.DB, common.RedisEnabled = testDB, false
+	defer func() { model.DB, common.RedisEnabled = originalDB, originalRedisEnabled }()
+
+	user := &model.User{Id: 1901, Username: "ratelimit", AccessToken: "ratelimit", AffCode: "ratelimit", TPMLimit: 1000}
+	require.NoError(t, testDB.Create(user).Error)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set(ctxkey.Id, user.Id)
+		c.Set(ctxkey.TokenId, 1901)
+		c.Set(ctxkey.TokenRPMLimit, int64(2))
+		c.Set(ctxkey.Group, "default")
+	}, UsageRateLimit())
+	router.POST("/v1/chat/completions", func(c *gin.Context) {
+		c.Set(ctxkey.UsageTokens, int64(300))
+		c.Status(http.StatusOK)
+	})
+
+	send := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions",
+			strings.NewReader(`{"model":"gpt-4o","max_tokens":100,"messages":[{"role":"user","content":"hi"}]}`))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send()
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "2", w.Header().Get("x-ratelimit-limit-requests"))
+	assert.Equal(t, "1", w.Header().Get("x-ratelimit-remaining-requests"))
+	assert.NotEmpty(t, w.Header().Get("x-ratelimit-reset-requests"))
+	assert.Equal(t, "1000", w.Header().Get("x-ratelimit-limit-tokens"))
+	remaining := w.Header().Get("x-ratelimit-remaining-tokens")
+	assert.NotEqual(t, "1000", remaining, "the estimated tokens are reserved")
+
+	// the second request sees the tokens used by the first one instead of its estimate
+	w = send()
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "0", w.Header().Get("x-ratelimit-remaining-requests"))
+	assert.NotEqual(t, remaining, w.Header().Get("x-ratelimit-remaining-tokens"))
+
+	w = send()
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+}
+
+func TestEstimateRequestTokens(t *testing.T) {
+	assert.Equal(t, 10, textLength([]byte(`0123456789`)))
+	assert.Equal(t, len(`{"url":}`), textLength([]byte(`{"url":"data:image/png;base64,AAAA"}`)))
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	body := `{"max_completion_tokens":50,"messages":[{"role":"user","content":"hello"}]}`
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	assert.Equal(t, int64(len(body)/4+50), estimateRequestTokens(c))
+}
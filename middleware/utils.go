@@ -1,16 +1,20 @@
 package middleware
 
 import (
+	"net/http"
 	"strings"
 
 	"github.com/Laisky/errors/v2"
 	gmw "github.com/Laisky/gin-middlewares/v6"
 	"github.com/Laisky/zap"
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
 
 	"github.com/songquanpeng/one-api/common"
 	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/routing"
 )
 
 func abortWithMessage(c *gin.Context, statusCode int, message string) {
@@ -37,6 +41,15 @@ func AbortWithError(c *gin.Context, statusCode int, err error) {
 	c.Abort()
 }
 
+// getSessionKey returns the sticky session key of the request of group, see routing.SessionKey
+func getSessionKey(c *gin.Context, group string) string {
+	var body []byte
+	if strings.HasPrefix(c.Request.Header.Get("Content-Type"), "application/json") {
+		body, _ = common.GetRequestBody(c)
+	}
+	return routing.SessionKey(group, c.Request.Header, body)
+}
+
 func getRequestModel(c *gin.Context) (string, error) {
 	var modelRequest ModelRequest
 	err := common.UnmarshalBodyReusable(c, &modelRequest)
@@ -45,6 +58,13 @@ func getRequestModel(c *gin.Context) (string, error) {
 	}
 
 	switch {
+	case strings.HasPrefix(c.Request.URL.Path, "/v1beta/models/"):
+		// native Gemini requests carry the model in the path, e.g. /v1beta/models/gemini-2.0-flash:generateContent
+		modelAction := strings.TrimPrefix(c.Request.URL.Path, "/v1beta/models/")
+		if idx := strings.LastIndex(modelAction, ":"); idx >= 0 {
+			modelAction = modelAction[:idx]
+		}
+		modelRequest.Model = modelAction
 	case strings.HasPrefix(c.Request.URL.Path, "/v1/moderations"):
 		if modelRequest.Model == "" {
 			modelRequest.Model = "text-moderation-stable"
@@ -68,7 +88,8 @@ func getRequestModel(c *gin.Context) (string, error) {
 	return modelRequest.Model, nil
 }
 
-func isModelInList(modelName string, models string) bool {
+// IsModelInList reports whether modelName is one of the comma separated models
+func IsModelInList(modelName string, models string) bool {
 	modelList := strings.Split(models, ",")
 	for _, model := range modelList {
 		if modelName == model {
@@ -78,12 +99,195 @@ func isModelInList(modelName string, models string) bool {
 	return false
 }
 
-// GetTokenKeyParts extracts the token key parts from the Authorization header
+// getResponseChannelId returns the channel that produced the response referred by a Response API request,
+// either by the response_id path parameter or by previous_response_id in the body.
+// Zero means the request is not bound to any channel.
+//
+// Responses of other users are reported as not found. Unknown previous responses are left to upstream,
+// since they may be created before one-api recorded response ids.
+func getResponseChannelId(c *gin.Context, userId int) (int, error) {
+	if !strings.HasPrefix(c.Request.URL.Path, "/v1/responses") {
+		return 0, nil
+	}
+
+	responseId := c.Param("response_id")
+	isFollowUp := responseId != ""
+	if !isFollowUp {
+		var request struct {
+			PreviousResponseId string `json:"previous_response_id"`
+		}
+		// malformed body is reported by the relay handler
+		if err := common.UnmarshalBodyReusable(c, &request); err != nil {
+			return 0, nil
+		}
+		responseId = request.PreviousResponseId
+	}
+	if responseId == "" {
+		return 0, nil
+	}
+
+	record, err := model.GetResponseRecord(responseId)
+	switch {
+	case err != nil && isFollowUp:
+		return 0, errors.Errorf("response %s not found", responseId)
+	case err != nil:
+		return 0, nil
+	case record.UserId != userId:
+		return 0, errors.Errorf("response %s not found", responseId)
+	}
+
+	return record.ChannelId, nil
+}
+
+// errNoAssistant means the user has no assistant to decide the channel of the request
+var errNoAssistant = errors.New("no assistant found, please create an assistant first")
+
+// getUserAssistantObject get the record of an Assistants API object owned by user,
+// objects of other users are reported as not found.
+func getUserAssistantObject(objectId, object string, userId int) (*model.AssistantObject, error) {
+	record, err := model.GetAssistantObject(objectId)
+	if err != nil || record.Object != object || record.UserId != userId {
+		return nil, errors.Errorf("No %s found with id '%s'.", object, objectId)
+	}
+
+	return record, nil
+}
+
+// getAssistantChannelId returns the channel owning the objects referred by an Assistants API request.
+// Zero means the request creates an assistant, which is distributed by the model as usual.
+//
+//   - calls on an assistant or a thread go to the channel that created it
+//   - runs go to the channel of the thread, the assistant of the run must live in the same channel
+//   - new threads go to the channel of the assistant in the body,
+//     or the channel of the latest assistant of the user if there is none
+//   - assistants are listed from the channel of the latest assistant of the user
+func getAssistantChannelId(c *gin.Context, userId int) (int, error) {
+	path := c.Request.URL.Path
+	objectId := c.Param("id")
+	switch {
+	case strings.HasPrefix(path, "/v1/assistants") && objectId != "":
+		record, err := getUserAssistantObject(objectId, model.AssistantObjectAssistant, userId)
+		if err != nil {
+			return 0, err
+		}
+		return record.ChannelId, nil
+	case strings.HasPrefix(path, "/v1/assistants") && c.Request.Method != http.MethodGet:
+		return 0, nil
+	case strings.HasPrefix(path, "/v1/assistants"):
+		record, err := model.GetLatestUserAssistantObject(userId, model.AssistantObjectAssistant)
+		if err != nil {
+			return 0, errNoAssistant
+		}
+		return record.ChannelId, nil
+	case !strings.HasPrefix(path, "/v1/threads"):
+		return 0, nil
+	}
+
+	channelId := 0
+	if objectId != "" {
+		record, err := getUserAssistantObject(objectId, model.AssistantObjectThread, userId)
+		if err != nil {
+			return 0, err
+		}
+		channelId = record.ChannelId
+	}
+
+	var request struct {
+		AssistantId string `json:"assistant_id"`
+	}
+	if c.Request.Method == http.MethodPost {
+		// malformed body is reported by upstream
+		_ = common.UnmarshalBodyReusable(c, &request)
+	}
+	if request.AssistantId != "" {
+		record, err := getUserAssistantObject(request.AssistantId, model.AssistantObjectAssistant, userId)
+		if err != nil {
+			return 0, err
+		}
+		if channelId != 0 && record.ChannelId != channelId {
+			// a run needs the assistant and the thread in the same upstream
+			return 0, errors.Errorf("No assistant found with id '%s' in the channel of thread %s.", request.AssistantId, objectId)
+		}
+		channelId = record.ChannelId
+	}
+	if channelId == 0 {
+		record, err := model.GetLatestUserAssistantObject(userId, model.AssistantObjectAssistant)
+		if err != nil {
+			return 0, errNoAssistant
+		}
+		channelId = record.ChannelId
+	}
+
+	return channelId, nil
+}
+
+// errNoFineTuningJob means the user has no fine-tuning job to decide the channel of the request
+var errNoFineTuningJob = errors.New("no fine-tuning job found")
+
+// getFineTuningChannelId returns the channel owning the fine-tuning job referred by the request.
+// Zero means the request creates a job, which is distributed by the model as usual.
+// Jobs are listed from the channel of the latest job of the user.
+func getFineTuningChannelId(c *gin.Context, userId int) (int, error) {
+	if !strings.HasPrefix(c.Request.URL.Path, "/v1/fine_tuning") {
+		return 0, nil
+	}
+
+	if jobId := c.Param("id"); jobId != "" {
+		job, err := model.GetUserFineTuningJob(jobId, userId)
+		if err != nil {
+			return 0, errors.Errorf("Could not find fine-tune: %s", jobId)
+		}
+		return job.ChannelId, nil
+	}
+	if c.Request.Method != http.MethodGet {
+		return 0, nil
+	}
+
+	job, err := model.GetLatestUserFineTuningJob(userId)
+	if err != nil {
+		return 0, errNoFineTuningJob
+	}
+	return job.ChannelId, nil
+}
+
+// GetTokenKeyParts extracts the token key parts from the Authorization header,
+// or from the x-api-key header used by native Anthropic clients,
+// or from the x-goog-api-key header used by native Gemini clients, or their key query parameter on /v1beta routes,
+// or from the WebSocket subprotocols used by browser clients of the Realtime API.
 //
 // key like `sk-{token}[-{channelid}]`
 func GetTokenKeyParts(c *gin.Context) []string {
 	key := c.Request.Header.Get("Authorization")
+	if key == "" {
+		key = c.Request.Header.Get("x-api-key")
+	}
+	if key == "" {
+		// native Gemini clients authenticate by x-goog-api-key or the key query parameter
+		key = c.Request.Header.Get("x-goog-api-key")
+	}
+	if key == "" && strings.HasPrefix(c.Request.URL.Path, "/v1beta") {
+		// only Gemini routes take the key from the query, so keys of other routes do not end up in access logs
+		key = c.Query("key")
+	}
+	if key == "" {
+		key = getWebSocketProtocolKey(c)
+	}
 	key = strings.TrimPrefix(key, "Bearer ")
 	key = strings.TrimPrefix(strings.TrimPrefix(key, "sk-"), "laisky-")
 	return strings.Split(key, "-")
 }
+
+// realtimeKeyProtocolPrefix prefixes the key in the subprotocols of browser clients of the Realtime API,
+// since browsers could not set headers on WebSocket connections.
+// e.g. `Sec-WebSocket-Protocol: realtime, openai-insecure-api-key.sk-xxx, openai-beta.realtime-v1`
+const realtimeKeyProtocolPrefix = "openai-insecure-api-key."
+
+// getWebSocketProtocolKey returns the key carried in the WebSocket subprotocols
+func getWebSocketProtocolKey(c *gin.Context) string {
+	for _, protocol := range websocket.Subprotocols(c.Request) {
+		if key, ok := strings.CutPrefix(protocol, realtimeKeyProtocolPrefix); ok {
+			return key
+		}
+	}
+	return ""
+}
@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetTokenKeyParts_QueryKey(t *testing.T) {
+	newContext := func(target string) *gin.Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("POST", target, nil)
+		return c
+	}
+
+	assert.Equal(t, []string{"abc", "3"},
+		GetTokenKeyParts(newContext("/v1beta/models/gemini-2.0-flash:generateContent?key=sk-abc-3")))
+	assert.Equal(t, []string{""}, GetTokenKeyParts(newContext("/v1/chat/completions?key=sk-abc")),
+		"keys in the query are only accepted on Gemini routes")
+
+	c := newContext("/v1/chat/completions?key=sk-query")
+	c.Request.Header.Set("Authorization", "Bearer sk-header")
+	assert.Equal(t, []string{"header"}, GetTokenKeyParts(c))
+}
@@ -24,8 +24,7 @@ type Ability struct {
 	SuspendUntil *time.Time `json:"suspend_until,omitempty" gorm:"index"`
 }
 
-func GetRandomSatisfiedChannel(group string, model string, ignoreFirstPriority bool) (*Channel, error) {
-	ability := Ability{}
+func GetRandomSatisfiedChannel(group string, model string, ignoreFirstPriority bool, sessionKey string) (*Channel, error) {
 	groupCol := "`group`"
 	trueVal := "1"
 	if common.UsingPostgreSQL {
@@ -34,7 +33,6 @@ func GetRandomSatisfiedChannel(group string, model string, ignoreFirstPriority b
 	}
 	now := time.Now()
 
-	var err error = nil
 	var channelQuery *gorm.DB
 	if ignoreFirstPriority {
 		channelQuery = DB.Where(groupCol+" = ? AND model = ? AND enabled = "+trueVal+" AND (suspend_until IS NULL OR suspend_until < ?)", group, model, now)
@@ -42,19 +40,34 @@ func GetRandomSatisfiedChannel(group string, model string, ignoreFirstPriority b
 		maxPrioritySubQuery := DB.Model(&Ability{}).Select("MAX(priority)").Where(groupCol+" = ? AND model = ? AND enabled = "+trueVal+" AND (suspend_until IS NULL OR suspend_until < ?)", group, model, now)
 		channelQuery = DB.Where(groupCol+" = ? AND model = ? AND enabled = "+trueVal+" AND priority = (?) AND (suspend_until IS NULL OR suspend_until < ?)", group, model, maxPrioritySubQuery, now)
 	}
-	if common.UsingSQLite || common.UsingPostgreSQL {
-		err = channelQuery.Order("RANDOM()").First(&ability).Error
-	} else {
-		err = channelQuery.Order("RAND()").First(&ability).Error
-	}
+	channel, err := getSatisfiedChannel(group, model, sessionKey, channelQuery)
 	if err != nil {
 		return nil, errors.Wrap(err, "get random satisfied channel")
 	}
 
-	channel := Channel{}
-	channel.Id = ability.ChannelId
-	err = DB.First(&channel, "id = ?", ability.ChannelId).Error
-	return &channel, err
+	return channel, nil
+}
+
+// getSatisfiedChannel picks one of the channels of the abilities matched by abilityQuery,
+// by the routing strategy of model requested by group, or by sessionKey if not empty.
+func getSatisfiedChannel(group string, model string, sessionKey string, abilityQuery *gorm.DB) (*Channel, error) {
+	var channelIds []int
+	if err := abilityQuery.Model(&Ability{}).Pluck("channel_id", &channelIds).Error; err != nil {
+		return nil, errors.Wrap(err, "find satisfied abilities")
+	}
+	if len(channelIds) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	var channels []*Channel
+	if err := DB.Where("id IN (?)", channelIds).Find(&channels).Error; err != nil {
+		return nil, errors.Wrap(err, "find satisfied channels")
+	}
+	if channels = filterAvailableChannels(model, channels); len(channels) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	return pickChannel(group, model, sessionKey, channels)
 }
 
 func (channel *Channel) AddAbilities() error {
@@ -184,8 +197,7 @@ func SuspendAbility(ctx context.Context, group string, modelName string, channel
 		Update("suspend_until", suspendTime).Error
 }
 
-func GetRandomSatisfiedChannelExcluding(group string, model string, ignoreFirstPriority bool, excludeChannelIds map[int]bool) (*Channel, error) {
-	ability := Ability{}
+func GetRandomSatisfiedChannelExcluding(group string, model string, ignoreFirstPriority bool, excludeChannelIds map[int]bool, sessionKey string) (*Channel, error) {
 	groupCol := "`group`"
 	trueVal := "1"
 	if common.UsingPostgreSQL {
@@ -194,7 +206,6 @@ func GetRandomSatisfiedChannelExcluding(group string, model string, ignoreFirstP
 	}
 	now := time.Now()
 
-	var err error = nil
 	var channelQuery *gorm.DB
 
 	// Build base query with exclusions
@@ -271,17 +282,10 @@ func GetRandomSatisfiedChannelExcluding(group string, model string, ignoreFirstP
 		}
 	}
 
-	if common.UsingSQLite || common.UsingPostgreSQL {
-		err = channelQuery.Order("RANDOM()").First(&ability).Error
-	} else {
-		err = channelQuery.Order("RAND()").First(&ability).Error
-	}
+	channel, err := getSatisfiedChannel(group, model, sessionKey, channelQuery)
 	if err != nil {
 		return nil, errors.Wrap(err, "get random satisfied channel excluding failed ones")
 	}
 
-	channel := Channel{}
-	channel.Id = ability.ChannelId
-	err = DB.First(&channel, "id = ?", ability.ChannelId).Error
-	return &channel, err
+	return channel, nil
 }
@@ -133,7 +133,7 @@ func TestGetRandomSatisfiedChannelExcluding_PriorityLogic(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			channel, err := GetRandomSatisfiedChannelExcluding(testGroup, testModel, tt.ignoreFirstPriority, tt.excludeChannelIds)
+			channel, err := GetRandomSatisfiedChannelExcluding(testGroup, testModel, tt.ignoreFirstPriority, tt.excludeChannelIds, "")
 
 			if tt.shouldError {
 				assert.Error(t, err, tt.description)
@@ -189,8 +189,43 @@ func TestGetRandomSatisfiedChannelExcluding_SuspendedChannels(t *testing.T) {
 	require.NoError(t, err)
 
 	// Test that suspended channels are not selected
-	channel, err := GetRandomSatisfiedChannelExcluding("default", "gpt-3.5-turbo", false, map[int]bool{})
+	channel, err := GetRandomSatisfiedChannelExcluding("default", "gpt-3.5-turbo", false, map[int]bool{}, "")
 	assert.NoError(t, err)
 	assert.NotNil(t, channel)
 	assert.Equal(t, 1, channel.Id, "Should only return the non-suspended channel")
 }
+
+func TestGetRandomSatisfiedChannel_Weight(t *testing.T) {
+	testDB := setupTestDB(t)
+	originalDB := DB
+	DB = testDB
+	defer func() { DB = originalDB }()
+
+	originalUsingSQLite := common.UsingSQLite
+	common.UsingSQLite = true
+	defer func() { common.UsingSQLite = originalUsingSQLite }()
+
+	channels := []Channel{
+		{Id: 1, Name: "weight-1", Status: ChannelStatusEnabled, Models: "gpt-4o", Group: "default", Priority: &[]int64{100}[0], Weight: &[]uint{1}[0]},
+		{Id: 2, Name: "weight-3", Status: ChannelStatusEnabled, Models: "gpt-4o", Group: "default", Priority: &[]int64{100}[0], Weight: &[]uint{3}[0]},
+		{Id: 3, Name: "weight-unset", Status: ChannelStatusEnabled, Models: "gpt-4o", Group: "default", Priority: &[]int64{100}[0]},
+		{Id: 4, Name: "low-priority", Status: ChannelStatusEnabled, Models: "gpt-4o", Group: "default", Priority: &[]int64{10}[0], Weight: &[]uint{100}[0]},
+	}
+	for _, channel := range channels {
+		require.NoError(t, DB.Create(&channel).Error)
+		require.NoError(t, channel.AddAbilities())
+	}
+
+	t.Run("highest priority", func(t *testing.T) {
+		// the channel without a weight counts as 1
+		assertWeightedSplit(t, 5000, map[int]uint{1: 1, 2: 3, 3: 1}, func() (*Channel, error) {
+			return GetRandomSatisfiedChannel("default", "gpt-4o", false, "")
+		})
+	})
+
+	t.Run("excluding a channel", func(t *testing.T) {
+		assertWeightedSplit(t, 5000, map[int]uint{2: 3, 3: 1}, func() (*Channel, error) {
+			return GetRandomSatisfiedChannelExcluding("default", "gpt-4o", false, map[int]bool{1: true}, "")
+		})
+	})
+}
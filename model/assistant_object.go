@@ -0,0 +1,110 @@
+package model
+
+import (
+	"github.com/Laisky/errors/v2"
+
+	"github.com/songquanpeng/one-api/common/helper"
+)
+
+// Objects of the Assistants API that are bound to a channel
+const (
+	AssistantObjectAssistant = "assistant"
+	AssistantObjectThread    = "thread"
+	AssistantObjectRun       = "thread.run"
+)
+
+// AssistantObject remembers which channel created an assistant, thread or run of the Assistants API,
+// so that later calls on the object reach the same upstream key and only its owner could access it.
+type AssistantObject struct {
+	ObjectId    string `json:"object_id" gorm:"primaryKey;type:varchar(191)"`
+	Object      string `json:"object" gorm:"type:varchar(32)"`
+	UserId      int    `json:"user_id" gorm:"index"`
+	TokenId     int    `json:"token_id"`
+	ChannelId   int    `json:"channel_id"`
+	Billed      bool   `json:"billed"` // only for runs, whether the usage of the run has been charged
+	CreatedTime int64  `json:"created_time" gorm:"bigint;index"`
+}
+
+// NewAssistantObject create a new AssistantObject
+func NewAssistantObject(objectId, object string, userId, tokenId, channelId int) *AssistantObject {
+	return &AssistantObject{
+		ObjectId:    objectId,
+		Object:      object,
+		UserId:      userId,
+		TokenId:     tokenId,
+		ChannelId:   channelId,
+		CreatedTime: helper.GetTimestamp(),
+	}
+}
+
+// Insert saves the record if the object is not recorded yet, the existing record is kept as is
+func (record *AssistantObject) Insert() error {
+	err := DB.Where("object_id = ?", record.ObjectId).FirstOrCreate(record).Error
+	return errors.Wrapf(err, "failed to insert record of assistant object %s", record.ObjectId)
+}
+
+// GetAssistantObject get the record of an object by its id
+func GetAssistantObject(objectId string) (*AssistantObject, error) {
+	if objectId == "" {
+		return nil, errors.New("object id is empty")
+	}
+
+	record := &AssistantObject{}
+	if err := DB.First(record, "object_id = ?", objectId).Error; err != nil {
+		return nil, errors.Wrapf(err, "failed to get record of assistant object %s", objectId)
+	}
+
+	return record, nil
+}
+
+// GetLatestUserAssistantObject get the latest created object of the type owned by user
+func GetLatestUserAssistantObject(userId int, object string) (*AssistantObject, error) {
+	record := &AssistantObject{}
+	err := DB.Where("user_id = ? AND object = ?", userId, object).
+		Order("created_time desc").First(record).Error
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get latest %s of user %d", object, userId)
+	}
+
+	return record, nil
+}
+
+// GetUserAssistantObjectIds returns which of objectIds are owned by user
+func GetUserAssistantObjectIds(userId int, objectIds []string) (map[string]bool, error) {
+	owned := make(map[string]bool, len(objectIds))
+	if len(objectIds) == 0 {
+		return owned, nil
+	}
+
+	var ids []string
+	err := DB.Model(&AssistantObject{}).
+		Where("user_id = ? AND object_id IN ?", userId, objectIds).
+		Pluck("object_id", &ids).Error
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get assistant objects of user %d", userId)
+	}
+	for _, id := range ids {
+		owned[id] = true
+	}
+
+	return owned, nil
+}
+
+// MarkAssistantRunBilled marks the run as billed,
+// it returns false if the run is unknown or has been billed before.
+func MarkAssistantRunBilled(runId string) (bool, error) {
+	result := DB.Model(&AssistantObject{}).
+		Where("object_id = ? AND object = ? AND billed = ?", runId, AssistantObjectRun, false).
+		Update("billed", true)
+	if result.Error != nil {
+		return false, errors.Wrapf(result.Error, "failed to mark run %s as billed", runId)
+	}
+
+	return result.RowsAffected > 0, nil
+}
+
+// DeleteAssistantObject deletes the record of an object
+func DeleteAssistantObject(objectId string) error {
+	err := DB.Delete(&AssistantObject{}, "object_id = ?", objectId).Error
+	return errors.Wrapf(err, "failed to delete record of assistant object %s", objectId)
+}
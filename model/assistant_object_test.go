@@ -0,0 +1,62 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestAssistantObject(t *testing.T) {
+	testDB, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, testDB.AutoMigrate(&AssistantObject{}))
+	originalDB := DB
+	DB = testDB
+	defer func() { DB = originalDB }()
+
+	assistant := NewAssistantObject("asst_1", AssistantObjectAssistant, 1, 2, 3)
+	assistant.CreatedTime = 100
+	require.NoError(t, assistant.Insert())
+	latest := NewAssistantObject("asst_2", AssistantObjectAssistant, 1, 2, 4)
+	latest.CreatedTime = 200
+	require.NoError(t, latest.Insert())
+	require.NoError(t, NewAssistantObject("thread_1", AssistantObjectThread, 1, 2, 3).Insert())
+
+	// inserting a known object keeps the original record
+	require.NoError(t, NewAssistantObject("asst_1", AssistantObjectAssistant, 2, 2, 5).Insert())
+	record, err := GetAssistantObject("asst_1")
+	require.NoError(t, err)
+	assert.Equal(t, 1, record.UserId)
+	assert.Equal(t, 3, record.ChannelId)
+
+	record, err = GetLatestUserAssistantObject(1, AssistantObjectAssistant)
+	require.NoError(t, err)
+	assert.Equal(t, "asst_2", record.ObjectId)
+	_, err = GetLatestUserAssistantObject(2, AssistantObjectAssistant)
+	require.Error(t, err)
+
+	owned, err := GetUserAssistantObjectIds(1, []string{"asst_1", "asst_3", "thread_1"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"asst_1": true, "thread_1": true}, owned)
+
+	// a run is billed only once
+	require.NoError(t, NewAssistantObject("run_1", AssistantObjectRun, 1, 2, 3).Insert())
+	billed, err := MarkAssistantRunBilled("run_1")
+	require.NoError(t, err)
+	assert.True(t, billed)
+	billed, err = MarkAssistantRunBilled("run_1")
+	require.NoError(t, err)
+	assert.False(t, billed)
+	billed, err = MarkAssistantRunBilled("asst_1")
+	require.NoError(t, err)
+	assert.False(t, billed)
+
+	require.NoError(t, DeleteAssistantObject("asst_1"))
+	_, err = GetAssistantObject("asst_1")
+	require.Error(t, err)
+	_, err = GetAssistantObject("")
+	require.Error(t, err)
+}
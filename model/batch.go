@@ -0,0 +1,185 @@
+package model
+
+import (
+	"github.com/Laisky/errors/v2"
+	"gorm.io/gorm"
+
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/random"
+)
+
+// https://platform.openai.com/docs/api-reference/batch/object
+const (
+	BatchStatusValidating = "validating"
+	BatchStatusFailed     = "failed"
+	BatchStatusInProgress = "in_progress"
+	BatchStatusFinalizing = "finalizing"
+	BatchStatusCompleted  = "completed"
+	BatchStatusExpired    = "expired"
+	BatchStatusCancelling = "cancelling"
+	BatchStatusCancelled  = "cancelled"
+)
+
+// batchActiveStatuses are the statuses of batches that still need the runner
+var batchActiveStatuses = []string{
+	BatchStatusValidating,
+	BatchStatusInProgress,
+	BatchStatusFinalizing,
+	BatchStatusCancelling,
+}
+
+// Batch is a job of the Batch API, the requests in the input file are relayed in the background
+type Batch struct {
+	Id               string  `json:"id" gorm:"primaryKey;type:varchar(64)"`
+	UserId           int     `json:"user_id" gorm:"index"`
+	TokenId          int     `json:"token_id"`
+	Endpoint         string  `json:"endpoint"`
+	InputFileId      string  `json:"input_file_id" gorm:"type:varchar(64)"`
+	CompletionWindow string  `json:"completion_window"`
+	Status           string  `json:"status" gorm:"type:varchar(32);index"`
+	OutputFileId     string  `json:"output_file_id" gorm:"type:varchar(64)"`
+	ErrorFileId      string  `json:"error_file_id" gorm:"type:varchar(64)"`
+	Errors           *string `json:"errors" gorm:"type:text"`   // JSON of the validation errors
+	Metadata         *string `json:"metadata" gorm:"type:text"` // JSON of the metadata set by user
+	TotalCount       int     `json:"total_count"`
+	CompletedCount   int     `json:"completed_count"`
+	FailedCount      int     `json:"failed_count"`
+	CreatedAt        int64   `json:"created_at" gorm:"bigint;index"`
+	InProgressAt     int64   `json:"in_progress_at" gorm:"bigint"`
+	ExpiresAt        int64   `json:"expires_at" gorm:"bigint"`
+	FinalizingAt     int64   `json:"finalizing_at" gorm:"bigint"`
+	CompletedAt      int64   `json:"completed_at" gorm:"bigint"`
+	FailedAt         int64   `json:"failed_at" gorm:"bigint"`
+	ExpiredAt        int64   `json:"expired_at" gorm:"bigint"`
+	CancellingAt     int64   `json:"cancelling_at" gorm:"bigint"`
+	CancelledAt      int64   `json:"cancelled_at" gorm:"bigint"`
+}
+
+// BatchLine records a finished request of a batch, so that the runner could resume after restart.
+// The output of the request is kept in common/storage.
+type BatchLine struct {
+	BatchId   string `json:"batch_id" gorm:"primaryKey;type:varchar(64)"`
+	LineIndex int    `json:"line_index" gorm:"primaryKey;autoIncrement:false"`
+	IsError   bool   `json:"is_error"`
+}
+
+// NewBatch creates a batch in validating status, which expires after completionWindowSeconds
+func NewBatch(userId, tokenId int, endpoint, inputFileId, completionWindow string, completionWindowSeconds int64, metadata *string) *Batch {
+	now := helper.GetTimestamp()
+	return &Batch{
+		Id:               "batch_" + random.GetUUID(),
+		UserId:           userId,
+		TokenId:          tokenId,
+		Endpoint:         endpoint,
+		InputFileId:      inputFileId,
+		CompletionWindow: completionWindow,
+		Status:           BatchStatusValidating,
+		Metadata:         metadata,
+		CreatedAt:        now,
+		ExpiresAt:        now + completionWindowSeconds,
+	}
+}
+
+func (batch *Batch) Insert() error {
+	err := DB.Create(batch).Error
+	return errors.Wrap(err, "failed to insert batch")
+}
+
+// GetBatchById get a batch by its id, the batch is not checked against any user
+func GetBatchById(id string) (*Batch, error) {
+	batch := &Batch{}
+	if err := DB.First(batch, "id = ?", id).Error; err != nil {
+		return nil, errors.Wrapf(err, "failed to get batch %s", id)
+	}
+
+	return batch, nil
+}
+
+// GetUserBatchById get a batch owned by user
+func GetUserBatchById(id string, userId int) (*Batch, error) {
+	if id == "" {
+		return nil, errors.New("batch id is empty")
+	}
+
+	batch := &Batch{}
+	if err := DB.First(batch, "id = ? AND user_id = ?", id, userId).Error; err != nil {
+		return nil, errors.Wrapf(err, "failed to get batch %s", id)
+	}
+
+	return batch, nil
+}
+
+// GetUserBatches lists batches of user, newest first.
+//
+// after is the id of a batch in the previous page.
+func GetUserBatches(userId int, after string, limit int) (batches []*Batch, err error) {
+	tx := DB.Where("user_id = ?", userId)
+	if after != "" {
+		cursor, err := GetUserBatchById(after, userId)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		tx = tx.Where("created_at < ? OR (created_at = ? AND id < ?)", cursor.CreatedAt, cursor.CreatedAt, cursor.Id)
+	}
+
+	err = tx.Order("created_at desc, id desc").Limit(limit).Find(&batches).Error
+	return batches, errors.Wrap(err, "failed to list batches")
+}
+
+// GetActiveBatchIds returns the ids of batches that are not finished yet
+func GetActiveBatchIds() (ids []string, err error) {
+	err = DB.Model(&Batch{}).Where("status IN ?", batchActiveStatuses).
+		Order("created_at asc").Pluck("id", &ids).Error
+	return ids, errors.Wrap(err, "failed to get active batches")
+}
+
+// UpdateBatchStatus moves the batch from one of fromStatuses to status, along with other fields.
+// It returns false if the batch is not in any of fromStatuses, such as being cancelled meanwhile.
+func UpdateBatchStatus(id string, fromStatuses []string, status string, fields map[string]any) (bool, error) {
+	updates := map[string]any{"status": status}
+	for k, v := range fields {
+		updates[k] = v
+	}
+
+	result := DB.Model(&Batch{}).Where("id = ? AND status IN ?", id, fromStatuses).Updates(updates)
+	if result.Error != nil {
+		return false, errors.Wrapf(result.Error, "failed to update status of batch %s", id)
+	}
+
+	return result.RowsAffected > 0, nil
+}
+
+// GetBatchStatus returns the current status of the batch
+func GetBatchStatus(id string) (status string, err error) {
+	err = DB.Model(&Batch{}).Where("id = ?", id).Pluck("status", &status).Error
+	return status, errors.Wrapf(err, "failed to get status of batch %s", id)
+}
+
+// RecordBatchLine marks the request of lineIndex as finished and counts it
+func RecordBatchLine(batchId string, lineIndex int, isError bool) error {
+	counter := "completed_count"
+	if isError {
+		counter = "failed_count"
+	}
+
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&BatchLine{BatchId: batchId, LineIndex: lineIndex, IsError: isError}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&Batch{}).Where("id = ?", batchId).
+			Update(counter, gorm.Expr(counter+" + 1")).Error
+	})
+	return errors.Wrapf(err, "failed to record line %d of batch %s", lineIndex, batchId)
+}
+
+// GetBatchLines returns the finished requests of the batch
+func GetBatchLines(batchId string) (lines []*BatchLine, err error) {
+	err = DB.Where("batch_id = ?", batchId).Order("line_index asc").Find(&lines).Error
+	return lines, errors.Wrapf(err, "failed to get lines of batch %s", batchId)
+}
+
+// DeleteBatchLines removes the progress of the batch once its output is written
+func DeleteBatchLines(batchId string) error {
+	err := DB.Where("batch_id = ?", batchId).Delete(&BatchLine{}).Error
+	return errors.Wrapf(err, "failed to delete lines of batch %s", batchId)
+}
@@ -16,7 +16,9 @@ import (
 	"github.com/songquanpeng/one-api/common"
 	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/logger"
-	"github.com/songquanpeng/one-api/common/random"
+	"github.com/songquanpeng/one-api/relay/circuitbreaker"
+	"github.com/songquanpeng/one-api/relay/concurrency"
+	"github.com/songquanpeng/one-api/relay/routing"
 )
 
 var (
@@ -76,6 +78,102 @@ func CacheGetUserGroup(id int) (group string, err error) {
 	return group, err
 }
 
+// CacheGetUserRateLimits returns the requests and tokens allowed per minute to the user
+func CacheGetUserRateLimits(id int) (rpm int64, tpm int64, err error) {
+	if !common.RedisEnabled {
+		return GetUserRateLimits(id)
+	}
+	key := fmt.Sprintf("user_rate_limits:%d", id)
+	limits, err := common.RedisGet(key)
+	if err == nil {
+		if _, err = fmt.Sscanf(limits, "%d,%d", &rpm, &tpm); err == nil {
+			return rpm, tpm, nil
+		}
+	}
+
+	rpm, tpm, err = GetUserRateLimits(id)
+	if err != nil {
+		return 0, 0, err
+	}
+	err = common.RedisSet(key, fmt.Sprintf("%d,%d", rpm, tpm), time.Duration(UserId2GroupCacheSeconds)*time.Second)
+	if err != nil {
+		logger.SysError("Redis set user rate limits error: " + err.Error())
+	}
+	return rpm, tpm, nil
+}
+
+// CacheGetUserSpendLimits returns the quota allowed to be spent per period by the user
+func CacheGetUserSpendLimits(id int) (limits SpendLimits, err error) {
+	if !common.RedisEnabled {
+		return GetUserSpendLimits(id)
+	}
+	key := fmt.Sprintf("user_spend_limits:%d", id)
+	cached, err := common.RedisGet(key)
+	if err == nil {
+		if _, err = fmt.Sscanf(cached, "%d,%d,%d", &limits.Daily, &limits.Weekly, &limits.Monthly); err == nil {
+			return limits, nil
+		}
+	}
+
+	limits, err = GetUserSpendLimits(id)
+	if err != nil {
+		return limits, err
+	}
+	err = common.RedisSet(key, fmt.Sprintf("%d,%d,%d", limits.Daily, limits.Weekly, limits.Monthly),
+		time.Duration(UserId2GroupCacheSeconds)*time.Second)
+	if err != nil {
+		logger.SysError("Redis set user spend limits error: " + err.Error())
+	}
+	return limits, nil
+}
+
+// CacheGetTokenSpendLimits returns the quota allowed to be spent per period by the token
+func CacheGetTokenSpendLimits(id int) (limits SpendLimits, err error) {
+	if !common.RedisEnabled {
+		return GetTokenSpendLimits(id)
+	}
+	key := fmt.Sprintf("token_spend_limits:%d", id)
+	cached, err := common.RedisGet(key)
+	if err == nil {
+		if _, err = fmt.Sscanf(cached, "%d,%d,%d", &limits.Daily, &limits.Weekly, &limits.Monthly); err == nil {
+			return limits, nil
+		}
+	}
+
+	limits, err = GetTokenSpendLimits(id)
+	if err != nil {
+		return limits, err
+	}
+	err = common.RedisSet(key, fmt.Sprintf("%d,%d,%d", limits.Daily, limits.Weekly, limits.Monthly),
+		time.Duration(TokenCacheSeconds)*time.Second)
+	if err != nil {
+		logger.SysError("Redis set token spend limits error: " + err.Error())
+	}
+	return limits, nil
+}
+
+// CacheGetUserPlanModels returns the comma separated models allowed by the plan of the user, empty means any model
+func CacheGetUserPlanModels(id int) (models string, err error) {
+	if !common.RedisEnabled {
+		return GetUserPlanModels(id)
+	}
+	key := fmt.Sprintf("user_plan_models:%d", id)
+	models, err = common.RedisGet(key)
+	if err == nil {
+		return models, nil
+	}
+
+	models, err = GetUserPlanModels(id)
+	if err != nil {
+		return "", err
+	}
+	err = common.RedisSet(key, models, time.Duration(UserId2GroupCacheSeconds)*time.Second)
+	if err != nil {
+		logger.SysError("Redis set user plan models error: " + err.Error())
+	}
+	return models, nil
+}
+
 func fetchAndUpdateUserQuota(ctx context.Context, id int) (quota int64, err error) {
 	quota, err = GetUserQuota(id)
 	if err != nil {
@@ -279,6 +377,7 @@ func InitChannelCache() {
 	channelSyncLock.Lock()
 	group2model2channels = newGroup2model2channels
 	channelSyncLock.Unlock()
+	loadDisabledChannelKeys()
 	logger.SysLog("channels synced from database, considering suspensions")
 }
 
@@ -308,9 +407,11 @@ func GetChannelsFromCache(group string, model string) ([]*Channel, error) {
 	return candidateChannels, nil
 }
 
-func CacheGetRandomSatisfiedChannel(group string, model string, ignoreFirstPriority bool) (*Channel, error) {
+// CacheGetRandomSatisfiedChannel gets a satisfied channel of the highest priority tier, or of the lower tiers if ignoreFirstPriority.
+// Requests with the same non-empty sessionKey get the same channel of the tier as long as it is available, see routing.SessionKey.
+func CacheGetRandomSatisfiedChannel(group string, model string, ignoreFirstPriority bool, sessionKey string) (*Channel, error) {
 	if !config.MemoryCacheEnabled {
-		return GetRandomSatisfiedChannel(group, model, ignoreFirstPriority)
+		return GetRandomSatisfiedChannel(group, model, ignoreFirstPriority, sessionKey)
 	}
 	channelSyncLock.RLock()
 	// It's important to make a copy if we're going to modify or iterate outside lock,
@@ -336,10 +437,12 @@ func CacheGetRandomSatisfiedChannel(group string, model string, ignoreFirstPrior
 	copy(candidateChannels, channelsFromCache)
 	channelSyncLock.RUnlock()
 
+	// channels outside their availability windows leave the pool before priorities are compared
+	candidateChannels = filterAvailableChannels(model, candidateChannels)
 	endIdx := len(candidateChannels)
 	// choose by priority
-	if endIdx == 0 { // Should be caught by earlier check, but as a safeguard
-		return nil, errors.New("no channels available after cache check")
+	if endIdx == 0 {
+		return nil, errors.Errorf("no channel of model %s in group %s is within its availability windows", model, group)
 	}
 	firstChannel := candidateChannels[0]
 	if firstChannel.GetPriority() > 0 {
@@ -378,10 +481,10 @@ func CacheGetRandomSatisfiedChannel(group string, model string, ignoreFirstPrior
 		}
 	}
 
-	idx := rand.Intn(endIdx)
+	selected := candidateChannels[:endIdx]
 	if ignoreFirstPriority {
 		if endIdx < len(candidateChannels) { // which means there are more than one priority
-			idx = random.RandRange(endIdx, len(candidateChannels))
+			selected = candidateChannels[endIdx:]
 		} else {
 			// All channels have the same highest priority, or only one priority level exists.
 			// If ignoreFirstPriority is true, and we only have one priority level,
@@ -398,13 +501,13 @@ func CacheGetRandomSatisfiedChannel(group string, model string, ignoreFirstPrior
 			// This seems okay.
 		}
 	}
-	return candidateChannels[idx], nil
+	return pickChannel(group, model, sessionKey, selected)
 }
 
 // CacheGetRandomSatisfiedChannelExcluding gets a random satisfied channel while excluding specified channel IDs
-func CacheGetRandomSatisfiedChannelExcluding(group string, model string, ignoreFirstPriority bool, excludeChannelIds map[int]bool, tryLargerMaxTokens bool) (*Channel, error) {
+func CacheGetRandomSatisfiedChannelExcluding(group string, model string, ignoreFirstPriority bool, excludeChannelIds map[int]bool, tryLargerMaxTokens bool, sessionKey string) (*Channel, error) {
 	if !config.MemoryCacheEnabled {
-		return GetRandomSatisfiedChannelExcluding(group, model, ignoreFirstPriority, excludeChannelIds)
+		return GetRandomSatisfiedChannelExcluding(group, model, ignoreFirstPriority, excludeChannelIds, sessionKey)
 	}
 	channelSyncLock.RLock()
 	channelsFromCache := group2model2channels[group][model]
@@ -414,13 +517,14 @@ func CacheGetRandomSatisfiedChannelExcluding(group string, model string, ignoreF
 		return nil, errors.New("channel not found in memory cache")
 	}
 
-	// Filter out excluded channels
+	// Filter out excluded channels and the ones outside their availability windows
 	var candidateChannels []*Channel
 	for _, channel := range channelsFromCache {
 		if !excludeChannelIds[channel.Id] {
 			candidateChannels = append(candidateChannels, channel)
 		}
 	}
+	candidateChannels = filterAvailableChannels(model, candidateChannels)
 
 	// For HTTP Code 413
 	// Filter out small max_tokens channels
@@ -471,8 +575,7 @@ func CacheGetRandomSatisfiedChannelExcluding(group string, model string, ignoreF
 
 		// If there are lower priority channels available, select from them
 		if endIdx < len(candidateChannels) {
-			idx := random.RandRange(endIdx, len(candidateChannels))
-			return candidateChannels[idx], nil
+			return pickChannel(group, model, sessionKey, candidateChannels[endIdx:])
 		} else {
 			// No lower priority channels available, return error to indicate we should try a different approach
 			return nil, errors.New("no lower priority channels available after excluding failed channels")
@@ -505,7 +608,81 @@ func CacheGetRandomSatisfiedChannelExcluding(group string, model string, ignoreF
 			return nil, errors.New("no channels with maximum priority available")
 		}
 
-		idx := rand.Intn(len(maxPriorityChannels))
-		return maxPriorityChannels[idx], nil
+		return pickChannel(group, model, sessionKey, maxPriorityChannels)
+	}
+}
+
+// pickChannel picks one of channels by the routing strategy of modelName requested by group, or by sessionKey if not empty,
+// skipping the channels whose circuit of modelName is open and those at their concurrency limit.
+// When every channel left is at its limit, one of them is picked all the same and the request waits for a slot of it.
+func pickChannel(group string, modelName string, sessionKey string, channels []*Channel) (*Channel, error) {
+	ctx := context.Background()
+	remaining := channels
+	var busy []*Channel
+	for len(remaining) > 0 {
+		channel := pickChannelByStrategy(group, modelName, sessionKey, remaining)
+		if !concurrency.Available(ctx, channel.Id, channel.GetMaxConcurrency()) {
+			busy = append(busy, channel)
+		} else if circuitbreaker.Allow(ctx, channel.Id, modelName) {
+			return channel, nil
+		}
+		remaining = withoutChannel(remaining, channel)
 	}
+	for len(busy) > 0 {
+		channel := pickChannelByStrategy(group, modelName, sessionKey, busy)
+		if circuitbreaker.Allow(ctx, channel.Id, modelName) {
+			return channel, nil
+		}
+		busy = withoutChannel(busy, channel)
+	}
+
+	return nil, errors.Errorf("circuits of all %d channels for model %s in group %s are open", len(channels), modelName, group)
+}
+
+// withoutChannel returns channels except channel
+func withoutChannel(channels []*Channel, channel *Channel) []*Channel {
+	remaining := make([]*Channel, 0, len(channels)-1)
+	for _, candidate := range channels {
+		if candidate != channel {
+			remaining = append(remaining, candidate)
+		}
+	}
+	return remaining
+}
+
+// pickChannelByStrategy picks one of channels by the routing strategy of modelName requested by group,
+// requests of a session stick to the channel of their sessionKey instead. channels must not be empty.
+func pickChannelByStrategy(group string, modelName string, sessionKey string, channels []*Channel) *Channel {
+	strategy := routing.GetStrategy(group, modelName)
+	if len(channels) == 1 || (strategy == routing.StrategyRandom && sessionKey == "") {
+		return pickWeightedRandomChannel(channels)
+	}
+
+	candidates := make([]routing.Candidate, len(channels))
+	for i, channel := range channels {
+		candidates[i] = routing.Candidate{ChannelId: channel.Id, Weight: channel.GetWeight()}
+	}
+	if sessionKey != "" {
+		return channels[routing.PickBySession(sessionKey, candidates)]
+	}
+	return channels[routing.DefaultTracker.Select(strategy, modelName, candidates)]
+}
+
+// pickWeightedRandomChannel picks one of channels with a probability proportional to its weight,
+// channels must not be empty.
+func pickWeightedRandomChannel(channels []*Channel) *Channel {
+	var totalWeight int64
+	for _, channel := range channels {
+		totalWeight += channel.GetWeight()
+	}
+
+	n := rand.Int63n(totalWeight)
+	for _, channel := range channels {
+		n -= channel.GetWeight()
+		if n < 0 {
+			return channel
+		}
+	}
+
+	return channels[len(channels)-1]
 }
@@ -1,11 +1,19 @@
 package model
 
 import (
+	"context"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
+	"github.com/songquanpeng/one-api/common"
 	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/relay/circuitbreaker"
+	"github.com/songquanpeng/one-api/relay/concurrency"
+	"github.com/songquanpeng/one-api/relay/routing"
 )
 
 func TestCacheGetRandomSatisfiedChannelExcluding(t *testing.T) {
@@ -95,7 +103,7 @@ func TestCacheGetRandomSatisfiedChannelExcluding(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			channel, err := CacheGetRandomSatisfiedChannelExcluding(testGroup, testModel, tt.ignoreFirstPriority, tt.excludeChannelIds, tt.tryLargerMaxTokens)
+			channel, err := CacheGetRandomSatisfiedChannelExcluding(testGroup, testModel, tt.ignoreFirstPriority, tt.excludeChannelIds, tt.tryLargerMaxTokens, "")
 
 			if tt.shouldError {
 				assert.Error(t, err)
@@ -154,3 +162,349 @@ func TestChannel_GetPriority(t *testing.T) {
 		})
 	}
 }
+
+func TestChannel_GetWeight(t *testing.T) {
+	tests := []struct {
+		name     string
+		weight   *uint
+		expected int64
+	}{
+		{
+			name:     "Nil weight should return 1",
+			weight:   nil,
+			expected: 1,
+		},
+		{
+			name:     "Zero weight should return 1",
+			weight:   &[]uint{0}[0],
+			expected: 1,
+		},
+		{
+			name:     "Non-zero weight should return value",
+			weight:   &[]uint{5}[0],
+			expected: 5,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			channel := &Channel{Weight: tt.weight}
+			assert.Equal(t, tt.expected, channel.GetWeight())
+		})
+	}
+}
+
+// assertWeightedSplit draws n channels by pick and checks that the traffic split converges to weights
+func assertWeightedSplit(t *testing.T, n int, weights map[int]uint, pick func() (*Channel, error)) {
+	var totalWeight uint
+	for _, weight := range weights {
+		totalWeight += weight
+	}
+
+	counts := make(map[int]int)
+	for i := 0; i < n; i++ {
+		channel, err := pick()
+		if !assert.NoError(t, err) {
+			return
+		}
+		counts[channel.Id]++
+	}
+
+	for id, weight := range weights {
+		expected := float64(weight) / float64(totalWeight)
+		actual := float64(counts[id]) / float64(n)
+		assert.InDelta(t, expected, actual, 0.03, "traffic share of channel %d", id)
+	}
+}
+
+func TestCacheGetRandomSatisfiedChannel_Weight(t *testing.T) {
+	originalMemoryCacheEnabled := config.MemoryCacheEnabled
+	config.MemoryCacheEnabled = true
+	defer func() { config.MemoryCacheEnabled = originalMemoryCacheEnabled }()
+
+	testGroup := "weight-group"
+	testModel := "gpt-4o"
+	weights := map[int]uint{1: 1, 2: 3, 3: 6}
+	channels := []*Channel{
+		{Id: 1, Priority: &[]int64{100}[0], Weight: &[]uint{1}[0]},
+		{Id: 2, Priority: &[]int64{100}[0], Weight: &[]uint{3}[0]},
+		{Id: 3, Priority: &[]int64{100}[0], Weight: &[]uint{6}[0]},
+		{Id: 4, Priority: &[]int64{50}[0], Weight: &[]uint{1}[0]},
+		{Id: 5, Priority: &[]int64{50}[0], Weight: &[]uint{4}[0]},
+	}
+
+	channelSyncLock.Lock()
+	if group2model2channels == nil {
+		group2model2channels = make(map[string]map[string][]*Channel)
+	}
+	group2model2channels[testGroup] = map[string][]*Channel{testModel: channels}
+	channelSyncLock.Unlock()
+	defer func() {
+		channelSyncLock.Lock()
+		delete(group2model2channels, testGroup)
+		channelSyncLock.Unlock()
+	}()
+
+	t.Run("highest priority", func(t *testing.T) {
+		assertWeightedSplit(t, 20000, weights, func() (*Channel, error) {
+			return CacheGetRandomSatisfiedChannel(testGroup, testModel, false, "")
+		})
+	})
+
+	t.Run("excluding a channel", func(t *testing.T) {
+		assertWeightedSplit(t, 20000, map[int]uint{1: 1, 3: 6}, func() (*Channel, error) {
+			return CacheGetRandomSatisfiedChannelExcluding(testGroup, testModel, false, map[int]bool{2: true}, false, "")
+		})
+	})
+
+	t.Run("lower priority", func(t *testing.T) {
+		assertWeightedSplit(t, 20000, map[int]uint{4: 1, 5: 4}, func() (*Channel, error) {
+			return CacheGetRandomSatisfiedChannelExcluding(testGroup, testModel, true, map[int]bool{}, false, "")
+		})
+	})
+}
+
+func TestCacheGetRandomSatisfiedChannel_RoutingStrategy(t *testing.T) {
+	originalMemoryCacheEnabled := config.MemoryCacheEnabled
+	config.MemoryCacheEnabled = true
+	defer func() { config.MemoryCacheEnabled = originalMemoryCacheEnabled }()
+
+	testGroup := "routing-group"
+	testModel := "routing-model"
+	channelSyncLock.Lock()
+	if group2model2channels == nil {
+		group2model2channels = make(map[string]map[string][]*Channel)
+	}
+	group2model2channels[testGroup] = map[string][]*Channel{testModel: {
+		{Id: 101, Priority: &[]int64{0}[0]},
+		{Id: 102, Priority: &[]int64{0}[0]},
+	}}
+	channelSyncLock.Unlock()
+	defer func() {
+		channelSyncLock.Lock()
+		delete(group2model2channels, testGroup)
+		channelSyncLock.Unlock()
+	}()
+
+	require.NoError(t, routing.UpdateModelStrategiesByJSONString(`{"routing-model":"least_in_flight"}`))
+	defer func() { require.NoError(t, routing.UpdateModelStrategiesByJSONString(`{}`)) }()
+
+	routing.DefaultTracker.Start(101)
+	defer routing.DefaultTracker.Done(101)
+	for i := 0; i < 20; i++ {
+		channel, err := CacheGetRandomSatisfiedChannel(testGroup, testModel, false, "")
+		require.NoError(t, err)
+		assert.Equal(t, 102, channel.Id)
+	}
+}
+
+func TestCacheGetRandomSatisfiedChannel_CircuitBreaker(t *testing.T) {
+	originalMemoryCacheEnabled := config.MemoryCacheEnabled
+	originalCircuitBreakerEnabled := config.CircuitBreakerEnabled
+	originalRedisEnabled := common.RedisEnabled
+	config.MemoryCacheEnabled = true
+	config.CircuitBreakerEnabled = true
+	common.RedisEnabled = false
+	defer func() {
+		config.MemoryCacheEnabled = originalMemoryCacheEnabled
+		config.CircuitBreakerEnabled = originalCircuitBreakerEnabled
+		common.RedisEnabled = originalRedisEnabled
+	}()
+
+	testGroup := "circuit-group"
+	testModel := "circuit-model"
+	channelSyncLock.Lock()
+	if group2model2channels == nil {
+		group2model2channels = make(map[string]map[string][]*Channel)
+	}
+	group2model2channels[testGroup] = map[string][]*Channel{testModel: {
+		{Id: 201, Priority: &[]int64{0}[0]},
+		{Id: 202, Priority: &[]int64{0}[0]},
+	}}
+	channelSyncLock.Unlock()
+	defer func() {
+		channelSyncLock.Lock()
+		delete(group2model2channels, testGroup)
+		channelSyncLock.Unlock()
+	}()
+
+	ctx := context.Background()
+	circuitbreaker.Trip(ctx, 201, testModel, time.Minute)
+	defer func() { require.NoError(t, circuitbreaker.Reset(ctx, 201, testModel)) }()
+	for i := 0; i < 20; i++ {
+		channel, err := CacheGetRandomSatisfiedChannelExcluding(testGroup, testModel, false, map[int]bool{}, false, "")
+		require.NoError(t, err)
+		assert.Equal(t, 202, channel.Id)
+	}
+
+	circuitbreaker.Trip(ctx, 202, testModel, time.Minute)
+	defer func() { require.NoError(t, circuitbreaker.Reset(ctx, 202, testModel)) }()
+	_, err := CacheGetRandomSatisfiedChannel(testGroup, testModel, false, "")
+	assert.Error(t, err)
+}
+
+func TestCacheGetRandomSatisfiedChannel_StickySession(t *testing.T) {
+	originalMemoryCacheEnabled := config.MemoryCacheEnabled
+	config.MemoryCacheEnabled = true
+	defer func() { config.MemoryCacheEnabled = originalMemoryCacheEnabled }()
+
+	testGroup := "sticky-group"
+	testModel := "sticky-model"
+	channelSyncLock.Lock()
+	if group2model2channels == nil {
+		group2model2channels = make(map[string]map[string][]*Channel)
+	}
+	group2model2channels[testGroup] = map[string][]*Channel{testModel: {
+		{Id: 301, Priority: &[]int64{10}[0]},
+		{Id: 302, Priority: &[]int64{10}[0]},
+		{Id: 303, Priority: &[]int64{10}[0]},
+		{Id: 304, Priority: &[]int64{0}[0]},
+	}}
+	channelSyncLock.Unlock()
+	defer func() {
+		channelSyncLock.Lock()
+		delete(group2model2channels, testGroup)
+		channelSyncLock.Unlock()
+	}()
+
+	// the session sticks to one channel of the highest tier, even with another routing strategy
+	require.NoError(t, routing.UpdateModelStrategiesByJSONString(`{"sticky-model":"least_in_flight"}`))
+	defer func() { require.NoError(t, routing.UpdateModelStrategiesByJSONString(`{}`)) }()
+	sticky, err := CacheGetRandomSatisfiedChannel(testGroup, testModel, false, "user:alice")
+	require.NoError(t, err)
+	assert.NotEqual(t, 304, sticky.Id)
+	routing.DefaultTracker.Start(sticky.Id)
+	defer routing.DefaultTracker.Done(sticky.Id)
+	for i := 0; i < 20; i++ {
+		channel, err := CacheGetRandomSatisfiedChannel(testGroup, testModel, false, "user:alice")
+		require.NoError(t, err)
+		assert.Equal(t, sticky.Id, channel.Id)
+	}
+
+	// it fails over to the same channel while its channel is excluded
+	failover, err := CacheGetRandomSatisfiedChannelExcluding(testGroup, testModel, false, map[int]bool{sticky.Id: true}, false, "user:alice")
+	require.NoError(t, err)
+	assert.NotEqual(t, sticky.Id, failover.Id)
+	assert.NotEqual(t, 304, failover.Id)
+	for i := 0; i < 20; i++ {
+		channel, err := CacheGetRandomSatisfiedChannelExcluding(testGroup, testModel, false, map[int]bool{sticky.Id: true}, false, "user:alice")
+		require.NoError(t, err)
+		assert.Equal(t, failover.Id, channel.Id)
+	}
+
+	// sessions are spread over the channels of the tier
+	seen := make(map[int]bool)
+	for i := 0; i < 100; i++ {
+		channel, err := CacheGetRandomSatisfiedChannel(testGroup, testModel, false, fmt.Sprintf("user:%d", i))
+		require.NoError(t, err)
+		seen[channel.Id] = true
+	}
+	assert.Equal(t, map[int]bool{301: true, 302: true, 303: true}, seen)
+}
+
+func TestCacheGetRandomSatisfiedChannel_Concurrency(t *testing.T) {
+	originalMemoryCacheEnabled := config.MemoryCacheEnabled
+	originalRedisEnabled := common.RedisEnabled
+	config.MemoryCacheEnabled = true
+	common.RedisEnabled = false
+	defer func() {
+		config.MemoryCacheEnabled = originalMemoryCacheEnabled
+		common.RedisEnabled = originalRedisEnabled
+	}()
+
+	testGroup := "concurrency-group"
+	testModel := "concurrency-model"
+	channelSyncLock.Lock()
+	if group2model2channels == nil {
+		group2model2channels = make(map[string]map[string][]*Channel)
+	}
+	group2model2channels[testGroup] = map[string][]*Channel{testModel: {
+		{Id: 401, Priority: &[]int64{0}[0], MaxConcurrency: &[]int{1}[0]},
+		{Id: 402, Priority: &[]int64{0}[0], MaxConcurrency: &[]int{1}[0]},
+	}}
+	channelSyncLock.Unlock()
+	defer func() {
+		channelSyncLock.Lock()
+		delete(group2model2channels, testGroup)
+		channelSyncLock.Unlock()
+	}()
+
+	ctx := context.Background()
+	slot, err := concurrency.Acquire(ctx, 401, 1)
+	require.NoError(t, err)
+	defer slot.Release()
+	for i := 0; i < 20; i++ {
+		channel, err := CacheGetRandomSatisfiedChannel(testGroup, testModel, false, "")
+		require.NoError(t, err)
+		assert.Equal(t, 402, channel.Id)
+	}
+
+	// the request waits for a channel at its limit when all of them are
+	otherSlot, err := concurrency.Acquire(ctx, 402, 1)
+	require.NoError(t, err)
+	defer otherSlot.Release()
+	channel, err := CacheGetRandomSatisfiedChannel(testGroup, testModel, false, "")
+	require.NoError(t, err)
+	assert.Contains(t, []int{401, 402}, channel.Id)
+}
+
+func TestCacheGetRandomSatisfiedChannel_AvailabilityWindows(t *testing.T) {
+	originalMemoryCacheEnabled := config.MemoryCacheEnabled
+	originalRedisEnabled := common.RedisEnabled
+	originalClock := availabilityClock
+	config.MemoryCacheEnabled = true
+	common.RedisEnabled = false
+	current := time.Date(2025, 1, 6, 12, 0, 0, 0, time.UTC) // a Monday
+	availabilityClock = func() time.Time { return current }
+	defer func() {
+		config.MemoryCacheEnabled = originalMemoryCacheEnabled
+		common.RedisEnabled = originalRedisEnabled
+		availabilityClock = originalClock
+	}()
+
+	testGroup := "schedule-group"
+	testModel := "schedule-model"
+	// the off-peak channel has the highest priority, and serves the model at night only
+	offPeak := &Channel{Id: 501, Priority: &[]int64{10}[0],
+		Config: `{"availability_windows":["CRON_TZ=UTC * 0-6,22-23 * * *"]}`}
+	// the business hours channel serves the model on weekdays only
+	businessHours := &Channel{Id: 502, Priority: &[]int64{5}[0],
+		Config: `{"model_availability_windows":{"schedule-model":["CRON_TZ=UTC * 9-17 * * mon-fri"]}}`}
+	always := &Channel{Id: 503, Priority: &[]int64{0}[0]}
+	channelSyncLock.Lock()
+	if group2model2channels == nil {
+		group2model2channels = make(map[string]map[string][]*Channel)
+	}
+	group2model2channels[testGroup] = map[string][]*Channel{testModel: {offPeak, businessHours, always}}
+	channelSyncLock.Unlock()
+	defer func() {
+		channelSyncLock.Lock()
+		delete(group2model2channels, testGroup)
+		channelSyncLock.Unlock()
+	}()
+
+	pick := func() int {
+		channel, err := CacheGetRandomSatisfiedChannel(testGroup, testModel, false, "")
+		require.NoError(t, err)
+		return channel.Id
+	}
+	assert.Equal(t, 502, pick(), "channels outside their windows do not take the highest priority")
+	assert.True(t, businessHours.IsAvailableAt("other-model", current.Add(12*time.Hour)))
+
+	current = current.Add(11 * time.Hour) // 23:00
+	assert.Equal(t, 501, pick(), "off-peak channels join the pool in their windows")
+
+	current = current.Add(10 * time.Hour) // 09:00 on Tuesday, after the off-peak window
+	assert.Equal(t, 502, pick())
+	channel, err := CacheGetRandomSatisfiedChannelExcluding(testGroup, testModel, false, map[int]bool{502: true}, false, "")
+	require.NoError(t, err)
+	assert.Equal(t, 503, channel.Id)
+
+	current = time.Date(2025, 1, 11, 12, 0, 0, 0, time.UTC) // a Saturday
+	assert.Equal(t, 503, pick())
+
+	// the windows are parsed again once the config changes
+	always.Config = `{"availability_windows":["CRON_TZ=UTC * * * * mon"]}`
+	_, err = CacheGetRandomSatisfiedChannel(testGroup, testModel, false, "")
+	assert.Error(t, err)
+}
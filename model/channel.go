@@ -42,6 +42,7 @@ type Channel struct {
 	Config             string  `json:"config"`
 	SystemPrompt       *string `json:"system_prompt" gorm:"type:text"`
 	RateLimit          *int    `json:"ratelimit" gorm:"column:ratelimit;default:0"`
+	MaxConcurrency     *int    `json:"max_concurrency" gorm:"default:0"` // requests in flight, 0 for unlimited
 	// Channel-specific pricing tables
 	// DEPRECATED: Use ModelConfigs instead. These fields are kept for backward compatibility and migration.
 	ModelRatio      *string `json:"model_ratio" gorm:"type:text"`      // DEPRECATED: JSON string of model pricing ratios
@@ -61,6 +62,12 @@ type ChannelConfig struct {
 	VertexAIProjectID string `json:"vertex_ai_project_id,omitempty"`
 	VertexAIADC       string `json:"vertex_ai_adc,omitempty"`
 	AuthType          string `json:"auth_type,omitempty"`
+	// MultiKeyMode is how keys are picked when the channel holds several keys, empty for a single key
+	MultiKeyMode string `json:"multi_key_mode,omitempty"`
+	// AvailabilityWindows is the cron-style windows the channel is available in, empty for always, see package schedule
+	AvailabilityWindows []string `json:"availability_windows,omitempty"`
+	// ModelAvailabilityWindows is the windows each model is available in on the channel, on top of AvailabilityWindows
+	ModelAvailabilityWindows map[string][]string `json:"model_availability_windows,omitempty"`
 }
 
 type ModelConfig struct {
@@ -73,6 +80,21 @@ type ModelConfigLocal struct {
 	Ratio           float64 `json:"ratio"`
 	CompletionRatio float64 `json:"completion_ratio,omitempty"`
 	MaxTokens       int32   `json:"max_tokens,omitempty"`
+	// the ratios below are relative to the input rate, see adaptor.ModelConfig
+	CachedInputRatio float64 `json:"cached_input_ratio,omitempty"`
+	CacheWriteRatio  float64 `json:"cache_write_ratio,omitempty"`
+	AudioInputRatio  float64 `json:"audio_input_ratio,omitempty"`
+	AudioOutputRatio float64 `json:"audio_output_ratio,omitempty"`
+	ImageInputRatio  float64 `json:"image_input_ratio,omitempty"`
+	// Tiers override the price tiers of the model, see adaptor.PriceTier
+	Tiers []PriceTierLocal `json:"tiers,omitempty"`
+}
+
+// PriceTierLocal represents the local definition of adaptor.PriceTier to avoid import cycles
+type PriceTierLocal struct {
+	InputTokenThreshold int     `json:"input_token_threshold"`
+	Ratio               float64 `json:"ratio,omitempty"`
+	CompletionRatio     float64 `json:"completion_ratio,omitempty"`
 }
 
 func GetAllChannels(startIdx int, num int, scope string) ([]*Channel, error) {
@@ -128,6 +150,23 @@ func (channel *Channel) GetPriority() int64 {
 	return *channel.Priority
 }
 
+// GetWeight returns the weight of channel in random selection within its priority tier,
+// channels without a weight count as 1 so that they still receive traffic.
+func (channel *Channel) GetWeight() int64 {
+	if channel.Weight == nil || *channel.Weight == 0 {
+		return 1
+	}
+	return int64(*channel.Weight)
+}
+
+// GetMaxConcurrency returns how many requests could be in flight on the channel at once, 0 for unlimited
+func (channel *Channel) GetMaxConcurrency() int {
+	if channel.MaxConcurrency == nil {
+		return 0
+	}
+	return *channel.MaxConcurrency
+}
+
 func (channel *Channel) GetBaseURL() string {
 	if channel.BaseURL == nil {
 		return ""
@@ -341,13 +380,26 @@ func (channel *Channel) validateModelPriceConfigs(configs map[string]ModelConfig
 			return fmt.Errorf("negative completion ratio for model %s: %f", modelName, config.CompletionRatio)
 		}
 
+		if config.CachedInputRatio < 0 || config.CacheWriteRatio < 0 ||
+			config.AudioInputRatio < 0 || config.AudioOutputRatio < 0 || config.ImageInputRatio < 0 {
+			return fmt.Errorf("negative token ratio for model %s", modelName)
+		}
+		for i, tier := range config.Tiers {
+			if tier.InputTokenThreshold <= 0 || (i > 0 && tier.InputTokenThreshold <= config.Tiers[i-1].InputTokenThreshold) {
+				return fmt.Errorf("tiers of model %s must have positive thresholds in ascending order", modelName)
+			}
+			if tier.Ratio < 0 || tier.CompletionRatio < 0 {
+				return fmt.Errorf("negative tier ratio for model %s above %d tokens", modelName, tier.InputTokenThreshold)
+			}
+		}
+
 		// Validate MaxTokens
 		if config.MaxTokens < 0 {
 			return fmt.Errorf("negative MaxTokens for model %s: %d", modelName, config.MaxTokens)
 		}
 
 		// Validate that at least one field has meaningful data
-		if config.Ratio == 0 && config.CompletionRatio == 0 && config.MaxTokens == 0 {
+		if config.Ratio == 0 && config.CompletionRatio == 0 && config.MaxTokens == 0 && !config.hasTokenRatios() {
 			return fmt.Errorf("model %s has no meaningful configuration data", modelName)
 		}
 	}
@@ -355,6 +407,13 @@ func (channel *Channel) validateModelPriceConfigs(configs map[string]ModelConfig
 	return nil
 }
 
+// hasTokenRatios reports whether any ratio of cached, audio or image tokens or any price tier is set
+func (config ModelConfigLocal) hasTokenRatios() bool {
+	return config.CachedInputRatio != 0 || config.CacheWriteRatio != 0 ||
+		config.AudioInputRatio != 0 || config.AudioOutputRatio != 0 || config.ImageInputRatio != 0 ||
+		len(config.Tiers) != 0
+}
+
 // GetModelPriceConfigs returns the channel-specific model price configurations in the new unified format
 func (channel *Channel) GetModelPriceConfigs() map[string]ModelConfigLocal {
 	if channel.ModelConfigs == nil || *channel.ModelConfigs == "" || *channel.ModelConfigs == "{}" {
@@ -563,6 +622,10 @@ func (channel *Channel) Delete() error {
 		return err
 	}
 	err = channel.DeleteAbilities()
+	if err != nil {
+		return err
+	}
+	err = DB.Where("channel_id = ?", channel.Id).Delete(&ChannelKey{}).Error
 	if err == nil {
 		InitChannelCache()
 	}
@@ -817,7 +880,7 @@ func (channel *Channel) MigrateHistoricalPricingToModelConfigs() error {
 		}
 
 		// Add if we have any data (pricing or MaxTokens)
-		if config.Ratio != 0 || config.CompletionRatio != 0 || config.MaxTokens != 0 {
+		if config.Ratio != 0 || config.CompletionRatio != 0 || config.MaxTokens != 0 || config.hasTokenRatios() {
 			modelConfigs[modelName] = config
 		}
 	}
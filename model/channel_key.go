@@ -0,0 +1,250 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Laisky/errors/v2"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+const (
+	// KeyModeRoundRobin picks the enabled keys of a multi-key channel in turn
+	KeyModeRoundRobin = "round_robin"
+	// KeyModeRandom picks an enabled key of a multi-key channel at random
+	KeyModeRandom = "random"
+)
+
+// ChannelKey is the status of a key of a multi-key channel.
+// Keys are identified by their fingerprint, so that editing the keys of a channel keeps the status of those kept.
+// Keys without a record are enabled.
+type ChannelKey struct {
+	Id             int    `json:"id"`
+	ChannelId      int    `json:"channel_id" gorm:"uniqueIndex:idx_channel_key"`
+	Fingerprint    string `json:"fingerprint" gorm:"type:varchar(16);uniqueIndex:idx_channel_key"`
+	Status         int    `json:"status" gorm:"default:1"`
+	DisabledReason string `json:"disabled_reason" gorm:"type:text"`
+	UpdatedTime    int64  `json:"updated_time" gorm:"bigint"`
+}
+
+// ChannelKeyStatus is a key of a multi-key channel as shown to admins, the key itself is masked
+type ChannelKeyStatus struct {
+	Index          int    `json:"index"`
+	Key            string `json:"key"`
+	Fingerprint    string `json:"fingerprint"`
+	Status         int    `json:"status"`
+	DisabledReason string `json:"disabled_reason"`
+	UpdatedTime    int64  `json:"updated_time"`
+}
+
+// KeyFingerprint identifies a key in logs and admin APIs without revealing it
+func KeyFingerprint(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+func maskKey(key string) string {
+	if len(key) <= 8 {
+		return strings.Repeat("*", len(key))
+	}
+	return key[:4] + strings.Repeat("*", len(key)-8) + key[len(key)-4:]
+}
+
+// IsValidKeyMode reports whether mode could be set as the multi_key_mode of channels
+func IsValidKeyMode(mode string) bool {
+	return mode == "" || mode == KeyModeRoundRobin || mode == KeyModeRandom
+}
+
+// IsMultiKey reports whether the Key of the channel holds several keys
+func (channel *Channel) IsMultiKey() bool {
+	cfg, err := channel.LoadConfig()
+	return err == nil && cfg.MultiKeyMode != ""
+}
+
+// GetKeys returns the keys of the channel. The Key of a multi-key channel is a JSON array of keys,
+// or keys separated by newlines.
+func (channel *Channel) GetKeys() []string {
+	if !channel.IsMultiKey() {
+		return []string{channel.Key}
+	}
+
+	trimmed := strings.TrimSpace(channel.Key)
+	var keys []string
+	if strings.HasPrefix(trimmed, "[") {
+		if err := json.Unmarshal([]byte(trimmed), &keys); err != nil {
+			logger.SysError(fmt.Sprintf("failed to parse keys of channel #%d: %s", channel.Id, err.Error()))
+			return nil
+		}
+	} else {
+		keys = strings.Split(trimmed, "\n")
+	}
+
+	validKeys := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if key = strings.TrimSpace(key); key != "" {
+			validKeys = append(validKeys, key)
+		}
+	}
+	return validKeys
+}
+
+// channelKeyCursors is the round-robin position of each multi-key channel
+var channelKeyCursors sync.Map // channel id -> *atomic.Uint64
+
+// SelectKey returns the key to send the next request of the channel with and its fingerprint,
+// the fingerprint is empty for channels holding a single key.
+// When every key of a multi-key channel is disabled, which lasts until the channel itself is disabled,
+// the keys are used regardless.
+func (channel *Channel) SelectKey() (key string, fingerprint string) {
+	cfg, err := channel.LoadConfig()
+	if err != nil || cfg.MultiKeyMode == "" {
+		return channel.Key, ""
+	}
+	keys := channel.GetKeys()
+	if len(keys) == 0 {
+		return channel.Key, ""
+	}
+
+	disabledKeys := getDisabledChannelKeys(channel.Id)
+	enabledKeys := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if !disabledKeys[KeyFingerprint(key)] {
+			enabledKeys = append(enabledKeys, key)
+		}
+	}
+	if len(enabledKeys) == 0 {
+		logger.SysWarnf("every key of channel #%d is disabled", channel.Id)
+		enabledKeys = keys
+	}
+
+	var i int
+	switch cfg.MultiKeyMode {
+	case KeyModeRandom:
+		i = rand.Intn(len(enabledKeys))
+	default:
+		cursor, _ := channelKeyCursors.LoadOrStore(channel.Id, new(atomic.Uint64))
+		i = int((cursor.(*atomic.Uint64).Add(1) - 1) % uint64(len(enabledKeys)))
+	}
+	return enabledKeys[i], KeyFingerprint(enabledKeys[i])
+}
+
+var channelId2disabledKeys map[int]map[string]bool
+var channelKeySyncLock sync.RWMutex
+
+// loadDisabledChannelKeys caches the disabled keys of all channels
+func loadDisabledChannelKeys() {
+	var channelKeys []*ChannelKey
+	if err := DB.Where("status <> ?", ChannelStatusEnabled).Find(&channelKeys).Error; err != nil {
+		logger.SysError("failed to load disabled channel keys: " + err.Error())
+		return
+	}
+	newChannelId2disabledKeys := make(map[int]map[string]bool)
+	for _, channelKey := range channelKeys {
+		if _, ok := newChannelId2disabledKeys[channelKey.ChannelId]; !ok {
+			newChannelId2disabledKeys[channelKey.ChannelId] = make(map[string]bool)
+		}
+		newChannelId2disabledKeys[channelKey.ChannelId][channelKey.Fingerprint] = true
+	}
+
+	channelKeySyncLock.Lock()
+	channelId2disabledKeys = newChannelId2disabledKeys
+	channelKeySyncLock.Unlock()
+}
+
+// getDisabledChannelKeys returns the fingerprints of the disabled keys of the channel
+func getDisabledChannelKeys(channelId int) map[string]bool {
+	if config.MemoryCacheEnabled {
+		channelKeySyncLock.RLock()
+		defer channelKeySyncLock.RUnlock()
+		return channelId2disabledKeys[channelId]
+	}
+
+	var fingerprints []string
+	if err := DB.Model(&ChannelKey{}).Where("channel_id = ? and status <> ?", channelId, ChannelStatusEnabled).
+		Pluck("fingerprint", &fingerprints).Error; err != nil {
+		logger.SysError("failed to get disabled keys of channel: " + err.Error())
+		return nil
+	}
+	disabledKeys := make(map[string]bool, len(fingerprints))
+	for _, fingerprint := range fingerprints {
+		disabledKeys[fingerprint] = true
+	}
+	return disabledKeys
+}
+
+// GetChannelKeyStatuses returns the keys of the multi-key channel with their status
+func GetChannelKeyStatuses(channel *Channel) ([]ChannelKeyStatus, error) {
+	if !channel.IsMultiKey() {
+		return nil, errors.Errorf("channel #%d does not hold multiple keys", channel.Id)
+	}
+	var channelKeys []*ChannelKey
+	if err := DB.Where("channel_id = ?", channel.Id).Find(&channelKeys).Error; err != nil {
+		return nil, errors.Wrap(err, "get channel keys")
+	}
+	fingerprint2key := make(map[string]*ChannelKey, len(channelKeys))
+	for _, channelKey := range channelKeys {
+		fingerprint2key[channelKey.Fingerprint] = channelKey
+	}
+
+	keys := channel.GetKeys()
+	statuses := make([]ChannelKeyStatus, 0, len(keys))
+	for i, key := range keys {
+		status := ChannelKeyStatus{
+			Index:       i,
+			Key:         maskKey(key),
+			Fingerprint: KeyFingerprint(key),
+			Status:      ChannelStatusEnabled,
+		}
+		if channelKey, ok := fingerprint2key[status.Fingerprint]; ok {
+			status.Status = channelKey.Status
+			status.DisabledReason = channelKey.DisabledReason
+			status.UpdatedTime = channelKey.UpdatedTime
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// UpdateChannelKeyStatus sets the status of the key of the channel with fingerprint,
+// and returns how many keys of the channel are enabled afterwards
+func UpdateChannelKeyStatus(channel *Channel, fingerprint string, status int, reason string) (enabledKeys int, err error) {
+	keys := channel.GetKeys()
+	found := false
+	for _, key := range keys {
+		if KeyFingerprint(key) == fingerprint {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return 0, errors.Errorf("channel #%d has no key %s", channel.Id, fingerprint)
+	}
+	if status == ChannelStatusEnabled {
+		reason = ""
+	}
+
+	channelKey := &ChannelKey{}
+	if err = DB.Where(ChannelKey{ChannelId: channel.Id, Fingerprint: fingerprint}).
+		Assign(map[string]any{"status": status, "disabled_reason": reason, "updated_time": helper.GetTimestamp()}).
+		FirstOrCreate(channelKey).Error; err != nil {
+		return 0, errors.Wrap(err, "update channel key status")
+	}
+	loadDisabledChannelKeys()
+
+	disabledKeys := getDisabledChannelKeys(channel.Id)
+	for _, key := range keys {
+		if !disabledKeys[KeyFingerprint(key)] {
+			enabledKeys++
+		}
+	}
+	return enabledKeys, nil
+}
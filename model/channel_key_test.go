@@ -0,0 +1,108 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/songquanpeng/one-api/common/config"
+)
+
+func TestChannel_GetKeys(t *testing.T) {
+	single := &Channel{Key: "sk-a\nsk-b"}
+	assert.False(t, single.IsMultiKey())
+	assert.Equal(t, []string{"sk-a\nsk-b"}, single.GetKeys())
+
+	lines := &Channel{Key: "sk-a\n  sk-b \n\nsk-c\n", Config: `{"multi_key_mode":"round_robin"}`}
+	assert.True(t, lines.IsMultiKey())
+	assert.Equal(t, []string{"sk-a", "sk-b", "sk-c"}, lines.GetKeys())
+
+	list := &Channel{Key: ` ["sk-a", "sk-b", ""]`, Config: `{"multi_key_mode":"random"}`}
+	assert.Equal(t, []string{"sk-a", "sk-b"}, list.GetKeys())
+
+	assert.True(t, IsValidKeyMode(""))
+	assert.True(t, IsValidKeyMode(KeyModeRoundRobin))
+	assert.False(t, IsValidKeyMode("sequential"))
+}
+
+func TestChannel_SelectKey(t *testing.T) {
+	testDB := setupTestDB(t)
+	require.NoError(t, testDB.AutoMigrate(&ChannelKey{}))
+	originalDB := DB
+	DB = testDB
+	defer func() { DB = originalDB }()
+	originalMemoryCacheEnabled := config.MemoryCacheEnabled
+	defer func() { config.MemoryCacheEnabled = originalMemoryCacheEnabled }()
+
+	channel := &Channel{Id: 1, Key: "sk-single"}
+	key, fingerprint := channel.SelectKey()
+	assert.Equal(t, "sk-single", key)
+	assert.Empty(t, fingerprint)
+
+	channel = &Channel{Id: 2, Key: "sk-a\nsk-b\nsk-c", Config: `{"multi_key_mode":"round_robin"}`}
+	for _, memoryCacheEnabled := range []bool{false, true} {
+		config.MemoryCacheEnabled = memoryCacheEnabled
+		loadDisabledChannelKeys()
+
+		var picked []string
+		for i := 0; i < 6; i++ {
+			key, fingerprint = channel.SelectKey()
+			assert.Equal(t, KeyFingerprint(key), fingerprint)
+			picked = append(picked, key)
+		}
+		assert.ElementsMatch(t, []string{"sk-a", "sk-b", "sk-c", "sk-a", "sk-b", "sk-c"}, picked)
+		assert.Equal(t, picked[:3], picked[3:])
+
+		// disabled keys are skipped
+		enabledKeys, err := UpdateChannelKeyStatus(channel, KeyFingerprint("sk-b"), ChannelStatusAutoDisabled, "invalid api key")
+		require.NoError(t, err)
+		assert.Equal(t, 2, enabledKeys)
+		for i := 0; i < 6; i++ {
+			key, _ = channel.SelectKey()
+			assert.NotEqual(t, "sk-b", key)
+		}
+
+		enabledKeys, err = UpdateChannelKeyStatus(channel, KeyFingerprint("sk-b"), ChannelStatusEnabled, "")
+		require.NoError(t, err)
+		assert.Equal(t, 3, enabledKeys)
+	}
+
+	// every key is used while all of them are disabled
+	config.MemoryCacheEnabled = false
+	for _, key := range channel.GetKeys() {
+		_, err := UpdateChannelKeyStatus(channel, KeyFingerprint(key), ChannelStatusAutoDisabled, "insufficient quota")
+		require.NoError(t, err)
+	}
+	key, _ = channel.SelectKey()
+	assert.Contains(t, channel.GetKeys(), key)
+
+	_, err := UpdateChannelKeyStatus(channel, KeyFingerprint("sk-unknown"), ChannelStatusEnabled, "")
+	assert.Error(t, err)
+}
+
+func TestGetChannelKeyStatuses(t *testing.T) {
+	testDB := setupTestDB(t)
+	require.NoError(t, testDB.AutoMigrate(&ChannelKey{}))
+	originalDB := DB
+	DB = testDB
+	defer func() { DB = originalDB }()
+
+	_, err := GetChannelKeyStatuses(&Channel{Id: 1, Key: "sk-single"})
+	assert.Error(t, err)
+
+	channel := &Channel{Id: 2, Key: "sk-aaaa1111\nsk-bbbb2222", Config: `{"multi_key_mode":"random"}`}
+	_, err = UpdateChannelKeyStatus(channel, KeyFingerprint("sk-bbbb2222"), ChannelStatusAutoDisabled, "invalid api key")
+	require.NoError(t, err)
+
+	statuses, err := GetChannelKeyStatuses(channel)
+	require.NoError(t, err)
+	require.Len(t, statuses, 2)
+	assert.Equal(t, "sk-a***1111", statuses[0].Key)
+	assert.Equal(t, ChannelStatusEnabled, statuses[0].Status)
+	assert.Equal(t, 1, statuses[1].Index)
+	assert.Equal(t, KeyFingerprint("sk-bbbb2222"), statuses[1].Fingerprint)
+	assert.Equal(t, ChannelStatusAutoDisabled, statuses[1].Status)
+	assert.Equal(t, "invalid api key", statuses[1].DisabledReason)
+	assert.NotZero(t, statuses[1].UpdatedTime)
+}
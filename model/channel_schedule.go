@@ -0,0 +1,85 @@
+package model
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/schedule"
+)
+
+// availabilityClock tells the time availability windows of channels are evaluated at, it is replaced by tests
+var availabilityClock schedule.Clock = time.Now
+
+// channelSchedule is the parsed availability windows of a channel
+type channelSchedule struct {
+	// config is the config of the channel the windows are parsed from
+	config  string
+	windows schedule.Windows
+	models  map[string]schedule.Windows
+}
+
+// channelId2schedule caches the parsed windows of each channel, which are parsed again when its config changes
+var channelId2schedule sync.Map
+
+// parseChannelSchedule parses the availability windows of the channel config cfg
+func parseChannelSchedule(cfg ChannelConfig) (*channelSchedule, error) {
+	windows, err := schedule.ParseWindows(cfg.AvailabilityWindows)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid availability_windows")
+	}
+	s := &channelSchedule{windows: windows, models: make(map[string]schedule.Windows, len(cfg.ModelAvailabilityWindows))}
+	for modelName, exprs := range cfg.ModelAvailabilityWindows {
+		if s.models[modelName], err = schedule.ParseWindows(exprs); err != nil {
+			return nil, errors.Wrapf(err, "invalid model_availability_windows of model %s", modelName)
+		}
+	}
+	return s, nil
+}
+
+// ValidateAvailabilityWindows checks the availability windows of the channel config cfg
+func ValidateAvailabilityWindows(cfg ChannelConfig) error {
+	_, err := parseChannelSchedule(cfg)
+	return err
+}
+
+// getSchedule returns the parsed availability windows of the channel.
+// Channels whose windows could not be parsed are always available.
+func (channel *Channel) getSchedule() *channelSchedule {
+	if cached, ok := channelId2schedule.Load(channel.Id); ok && cached.(*channelSchedule).config == channel.Config {
+		return cached.(*channelSchedule)
+	}
+
+	s := &channelSchedule{}
+	if cfg, err := channel.LoadConfig(); err != nil {
+		logger.SysError(fmt.Sprintf("failed to load config of channel %d: %s", channel.Id, err.Error()))
+	} else if s, err = parseChannelSchedule(cfg); err != nil {
+		logger.SysError(fmt.Sprintf("failed to parse availability windows of channel %d: %s", channel.Id, err.Error()))
+		s = &channelSchedule{}
+	}
+	s.config = channel.Config
+	channelId2schedule.Store(channel.Id, s)
+	return s
+}
+
+// IsAvailableAt reports whether the channel serves modelName at t, which is when t is inside both
+// the availability windows of the channel and the ones of the model on the channel
+func (channel *Channel) IsAvailableAt(modelName string, t time.Time) bool {
+	s := channel.getSchedule()
+	return s.windows.Contains(t) && s.models[modelName].Contains(t)
+}
+
+// filterAvailableChannels returns the channels serving modelName now, see Channel.IsAvailableAt
+func filterAvailableChannels(modelName string, channels []*Channel) []*Channel {
+	current := availabilityClock()
+	available := make([]*Channel, 0, len(channels))
+	for _, channel := range channels {
+		if channel.IsAvailableAt(modelName, current) {
+			available = append(available, channel)
+		}
+	}
+	return available
+}
@@ -681,6 +681,35 @@ func TestChannel_validateModelPriceConfigs(t *testing.T) {
 			expectError:   true,
 			errorContains: "negative MaxTokens",
 		},
+		{
+			name: "valid tiers",
+			configs: map[string]ModelConfigLocal{
+				"gemini-2.5-pro": {
+					Tiers: []PriceTierLocal{{InputTokenThreshold: 200000, Ratio: 0.0025, CompletionRatio: 6}},
+				},
+			},
+			expectError: false,
+		},
+		{
+			name: "tiers out of order",
+			configs: map[string]ModelConfigLocal{
+				"gemini-2.5-pro": {
+					Tiers: []PriceTierLocal{{InputTokenThreshold: 200000, Ratio: 0.0025}, {InputTokenThreshold: 128000, Ratio: 0.002}},
+				},
+			},
+			expectError:   true,
+			errorContains: "ascending order",
+		},
+		{
+			name: "negative tier ratio",
+			configs: map[string]ModelConfigLocal{
+				"gemini-2.5-pro": {
+					Tiers: []PriceTierLocal{{InputTokenThreshold: 200000, CompletionRatio: -1}},
+				},
+			},
+			expectError:   true,
+			errorContains: "negative tier ratio",
+		},
 		{
 			name: "no meaningful data",
 			configs: map[string]ModelConfigLocal{
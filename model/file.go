@@ -0,0 +1,121 @@
+package model
+
+import (
+	"fmt"
+
+	"github.com/Laisky/errors/v2"
+
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/random"
+)
+
+const (
+	FileStatusUploaded  = "uploaded"
+	FileStatusProcessed = "processed"
+	FileStatusError     = "error"
+)
+
+// File is a file uploaded through the Files API, the content is kept in common/storage
+type File struct {
+	Id          string `json:"id" gorm:"primaryKey;type:varchar(64)"`
+	UserId      int    `json:"user_id" gorm:"index"`
+	TokenId     int    `json:"token_id"`
+	Filename    string `json:"filename"`
+	Purpose     string `json:"purpose" gorm:"type:varchar(32);index"`
+	Bytes       int64  `json:"bytes"`
+	ContentType string `json:"content_type"`
+	StorageKey  string `json:"-"`
+	Status      string `json:"status" gorm:"type:varchar(32)"`
+	CreatedAt   int64  `json:"created_at" gorm:"bigint;index"`
+}
+
+// NewFile creates a file record of user, the id and the storage key are generated
+func NewFile(userId, tokenId int, filename, purpose string, bytes int64, contentType string) *File {
+	id := "file-" + random.GetUUID()
+	return &File{
+		Id:          id,
+		UserId:      userId,
+		TokenId:     tokenId,
+		Filename:    filename,
+		Purpose:     purpose,
+		Bytes:       bytes,
+		ContentType: contentType,
+		StorageKey:  fmt.Sprintf("%d/%s", userId, id),
+		Status:      FileStatusProcessed,
+		CreatedAt:   helper.GetTimestamp(),
+	}
+}
+
+func (file *File) Insert() error {
+	err := DB.Create(file).Error
+	return errors.Wrap(err, "failed to insert file")
+}
+
+func (file *File) Delete() error {
+	err := DB.Delete(file).Error
+	return errors.Wrapf(err, "failed to delete file %s", file.Id)
+}
+
+// GetFileById get a file by its id, the file is not checked against any user
+func GetFileById(id string) (*File, error) {
+	if id == "" {
+		return nil, errors.New("file id is empty")
+	}
+
+	file := &File{}
+	if err := DB.First(file, "id = ?", id).Error; err != nil {
+		return nil, errors.Wrapf(err, "failed to get file %s", id)
+	}
+
+	return file, nil
+}
+
+// GetUserFileById get a file owned by user
+func GetUserFileById(id string, userId int) (*File, error) {
+	if id == "" {
+		return nil, errors.New("file id is empty")
+	}
+
+	file := &File{}
+	if err := DB.First(file, "id = ? AND user_id = ?", id, userId).Error; err != nil {
+		return nil, errors.Wrapf(err, "failed to get file %s", id)
+	}
+
+	return file, nil
+}
+
+// GetUserFiles lists files of user, newest first unless ascending is set.
+//
+// after is the id of a file in the previous page, purpose is ignored if empty.
+func GetUserFiles(userId int, purpose string, after string, limit int, ascending bool) (files []*File, err error) {
+	tx := DB.Where("user_id = ?", userId)
+	if purpose != "" {
+		tx = tx.Where("purpose = ?", purpose)
+	}
+
+	order := "created_at desc, id desc"
+	if ascending {
+		order = "created_at asc, id asc"
+	}
+	if after != "" {
+		cursor, err := GetUserFileById(after, userId)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if ascending {
+			tx = tx.Where("created_at > ? OR (created_at = ? AND id > ?)", cursor.CreatedAt, cursor.CreatedAt, cursor.Id)
+		} else {
+			tx = tx.Where("created_at < ? OR (created_at = ? AND id < ?)", cursor.CreatedAt, cursor.CreatedAt, cursor.Id)
+		}
+	}
+
+	err = tx.Order(order).Limit(limit).Find(&files).Error
+	return files, errors.Wrap(err, "failed to list files")
+}
+
+// GetUserFileBytes returns the total size of files kept for user
+func GetUserFileBytes(userId int) (bytes int64, err error) {
+	err = DB.Model(&File{}).Where("user_id = ?", userId).
+		Select("COALESCE(SUM(bytes), 0)").Scan(&bytes).Error
+	return bytes, errors.Wrap(err, "failed to sum file bytes")
+}
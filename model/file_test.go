@@ -0,0 +1,66 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestFile(t *testing.T) {
+	testDB, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, testDB.AutoMigrate(&File{}))
+	originalDB := DB
+	DB = testDB
+	defer func() { DB = originalDB }()
+
+	var files []*File
+	for i, purpose := range []string{"batch", "assistants", "batch"} {
+		file := NewFile(1, 2, "a.jsonl", purpose, int64(100*(i+1)), "application/jsonl")
+		file.CreatedAt = int64(1000 + i)
+		require.NoError(t, file.Insert())
+		files = append(files, file)
+	}
+	require.NoError(t, NewFile(9, 10, "b.pdf", "user_data", 1000, "application/pdf").Insert())
+
+	assert.Equal(t, "1/"+files[0].Id, files[0].StorageKey)
+
+	// files of other users are invisible
+	file, err := GetUserFileById(files[0].Id, 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), file.Bytes)
+	_, err = GetUserFileById(files[0].Id, 9)
+	require.Error(t, err)
+
+	listed, err := GetUserFiles(1, "", "", 2, false)
+	require.NoError(t, err)
+	require.Len(t, listed, 2)
+	assert.Equal(t, files[2].Id, listed[0].Id)
+	assert.Equal(t, files[1].Id, listed[1].Id)
+
+	listed, err = GetUserFiles(1, "", files[1].Id, 10, false)
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	assert.Equal(t, files[0].Id, listed[0].Id)
+
+	listed, err = GetUserFiles(1, "batch", "", 10, true)
+	require.NoError(t, err)
+	require.Len(t, listed, 2)
+	assert.Equal(t, files[0].Id, listed[0].Id)
+
+	bytes, err := GetUserFileBytes(1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(600), bytes)
+
+	require.NoError(t, files[0].Delete())
+	bytes, err = GetUserFileBytes(1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(500), bytes)
+
+	bytes, err = GetUserFileBytes(42)
+	require.NoError(t, err)
+	assert.Zero(t, bytes)
+}
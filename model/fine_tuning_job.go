@@ -0,0 +1,95 @@
+package model
+
+import (
+	"github.com/Laisky/errors/v2"
+
+	"github.com/songquanpeng/one-api/common/helper"
+)
+
+// FineTuningJob remembers which user created a fine-tuning job in which channel,
+// so that later calls on the job reach the same upstream key and only its owner could access it.
+type FineTuningJob struct {
+	JobId       string `json:"job_id" gorm:"primaryKey;type:varchar(191)"`
+	UserId      int    `json:"user_id" gorm:"index"`
+	TokenId     int    `json:"token_id"`
+	ChannelId   int    `json:"channel_id"`
+	Model       string `json:"model"`
+	Billed      bool   `json:"billed"` // whether the trained tokens have been charged
+	CreatedTime int64  `json:"created_time" gorm:"bigint;index"`
+}
+
+// NewFineTuningJob create a new FineTuningJob
+func NewFineTuningJob(jobId string, userId, tokenId, channelId int, modelName string) *FineTuningJob {
+	return &FineTuningJob{
+		JobId:       jobId,
+		UserId:      userId,
+		TokenId:     tokenId,
+		ChannelId:   channelId,
+		Model:       modelName,
+		CreatedTime: helper.GetTimestamp(),
+	}
+}
+
+// Insert saves the record if the job is not recorded yet, the existing record is kept as is
+func (job *FineTuningJob) Insert() error {
+	err := DB.Where("job_id = ?", job.JobId).FirstOrCreate(job).Error
+	return errors.Wrapf(err, "failed to insert record of fine-tuning job %s", job.JobId)
+}
+
+// GetUserFineTuningJob get the record of a job owned by user
+func GetUserFineTuningJob(jobId string, userId int) (*FineTuningJob, error) {
+	if jobId == "" {
+		return nil, errors.New("job id is empty")
+	}
+
+	job := &FineTuningJob{}
+	if err := DB.First(job, "job_id = ? AND user_id = ?", jobId, userId).Error; err != nil {
+		return nil, errors.Wrapf(err, "failed to get fine-tuning job %s", jobId)
+	}
+
+	return job, nil
+}
+
+// GetLatestUserFineTuningJob get the latest created job of user
+func GetLatestUserFineTuningJob(userId int) (*FineTuningJob, error) {
+	job := &FineTuningJob{}
+	if err := DB.Where("user_id = ?", userId).Order("created_time desc").First(job).Error; err != nil {
+		return nil, errors.Wrapf(err, "failed to get latest fine-tuning job of user %d", userId)
+	}
+
+	return job, nil
+}
+
+// GetUserFineTuningJobIds returns which of jobIds are owned by user
+func GetUserFineTuningJobIds(userId int, jobIds []string) (map[string]bool, error) {
+	owned := make(map[string]bool, len(jobIds))
+	if len(jobIds) == 0 {
+		return owned, nil
+	}
+
+	var ids []string
+	err := DB.Model(&FineTuningJob{}).
+		Where("user_id = ? AND job_id IN ?", userId, jobIds).
+		Pluck("job_id", &ids).Error
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get fine-tuning jobs of user %d", userId)
+	}
+	for _, id := range ids {
+		owned[id] = true
+	}
+
+	return owned, nil
+}
+
+// MarkFineTuningJobBilled marks the job as billed,
+// it returns false if the job is unknown or has been billed before.
+func MarkFineTuningJobBilled(jobId string) (bool, error) {
+	result := DB.Model(&FineTuningJob{}).
+		Where("job_id = ? AND billed = ?", jobId, false).
+		Update("billed", true)
+	if result.Error != nil {
+		return false, errors.Wrapf(result.Error, "failed to mark fine-tuning job %s as billed", jobId)
+	}
+
+	return result.RowsAffected > 0, nil
+}
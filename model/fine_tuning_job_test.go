@@ -0,0 +1,50 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestFineTuningJob(t *testing.T) {
+	testDB, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, testDB.AutoMigrate(&FineTuningJob{}))
+	originalDB := DB
+	DB = testDB
+	defer func() { DB = originalDB }()
+
+	older := NewFineTuningJob("ftjob-1", 1, 2, 3, "gpt-4o-mini-2024-07-18")
+	older.CreatedTime = 100
+	require.NoError(t, older.Insert())
+	latest := NewFineTuningJob("ftjob-2", 1, 2, 4, "gpt-4o-mini-2024-07-18")
+	latest.CreatedTime = 200
+	require.NoError(t, latest.Insert())
+	require.NoError(t, NewFineTuningJob("ftjob-3", 2, 5, 3, "gpt-4o-mini-2024-07-18").Insert())
+
+	job, err := GetUserFineTuningJob("ftjob-1", 1)
+	require.NoError(t, err)
+	assert.Equal(t, 3, job.ChannelId)
+	_, err = GetUserFineTuningJob("ftjob-3", 1)
+	require.Error(t, err)
+
+	job, err = GetLatestUserFineTuningJob(1)
+	require.NoError(t, err)
+	assert.Equal(t, "ftjob-2", job.JobId)
+	_, err = GetLatestUserFineTuningJob(3)
+	require.Error(t, err)
+
+	owned, err := GetUserFineTuningJobIds(1, []string{"ftjob-1", "ftjob-3", "ftjob-4"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"ftjob-1": true}, owned)
+
+	billed, err := MarkFineTuningJobBilled("ftjob-1")
+	require.NoError(t, err)
+	assert.True(t, billed)
+	billed, err = MarkFineTuningJobBilled("ftjob-1")
+	require.NoError(t, err)
+	assert.False(t, billed)
+}
@@ -0,0 +1,366 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+// System accounts of the ledger, the balances of all accounts always add up to zero
+const (
+	// LedgerAccountIssuance is where quota granted to users comes from and quota taken back from them goes to
+	LedgerAccountIssuance = "system:issuance"
+	// LedgerAccountConsumption is where quota consumed by requests of users goes to and refunds come from
+	LedgerAccountConsumption = "system:consumption"
+	// LedgerAccountTokenAllowance is the counterpart of the remaining quota of tokens
+	LedgerAccountTokenAllowance = "system:token_allowance"
+)
+
+// Reasons of quota movements
+const (
+	LedgerReasonOpening    = "opening"
+	LedgerReasonSignup     = "signup"
+	LedgerReasonInvite     = "invite"
+	LedgerReasonTopup      = "topup"
+	LedgerReasonRedeem     = "redeem"
+	LedgerReasonPlanGrant  = "plan_grant"
+	LedgerReasonPlanExpire = "plan_expire"
+	LedgerReasonAdjust     = "adjust"
+	LedgerReasonPreConsume = "pre_consume"
+	LedgerReasonConsume    = "consume"
+	LedgerReasonRefund     = "refund"
+)
+
+// Sources of the balances compared with the ledger by ReconcileLedger
+const (
+	LedgerSourceDB    = "db"
+	LedgerSourceRedis = "redis"
+)
+
+// LedgerEntry is an append-only record of quota moving from the debit account to the credit account
+type LedgerEntry struct {
+	Id            int64  `json:"id"`
+	DebitAccount  string `json:"debit_account" gorm:"type:varchar(32);index"`
+	CreditAccount string `json:"credit_account" gorm:"type:varchar(32);index"`
+	Amount        int64  `json:"amount" gorm:"bigint"`
+	Reason        string `json:"reason" gorm:"type:varchar(32);index"`
+	RequestId     string `json:"request_id" gorm:"type:varchar(64);index"`
+	CreatedAt     int64  `json:"created_at" gorm:"bigint;index"`
+}
+
+// LedgerDrift is a balance differing from the one computed from the ledger
+type LedgerDrift struct {
+	Account string `json:"account"`
+	Source  string `json:"source"`
+	Ledger  int64  `json:"ledger"`
+	Stored  int64  `json:"stored"`
+	Fixed   bool   `json:"fixed"`
+}
+
+// LedgerReport is the result of a reconciliation
+type LedgerReport struct {
+	Users       int           `json:"users"`
+	Tokens      int           `json:"tokens"`
+	Drifts      []LedgerDrift `json:"drifts"`
+	CreatedTime int64         `json:"created_time"`
+}
+
+func UserLedgerAccount(userId int) string {
+	return fmt.Sprintf("user:%d", userId)
+}
+
+func TokenLedgerAccount(tokenId int) string {
+	return fmt.Sprintf("token:%d", tokenId)
+}
+
+// newLedgerEntry returns the entry adding delta to the account from counter, negative deltas go back to counter
+func newLedgerEntry(ctx context.Context, account string, counter string, delta int64, reason string) *LedgerEntry {
+	entry := &LedgerEntry{
+		DebitAccount:  counter,
+		CreditAccount: account,
+		Amount:        delta,
+		Reason:        reason,
+		RequestId:     helper.GetRequestID(ctx),
+		CreatedAt:     helper.GetTimestamp(),
+	}
+	if delta < 0 {
+		entry.DebitAccount, entry.CreditAccount, entry.Amount = account, counter, -delta
+	}
+	return entry
+}
+
+func userLedgerEntry(ctx context.Context, userId int, delta int64, reason string) *LedgerEntry {
+	counter := LedgerAccountIssuance
+	switch reason {
+	case LedgerReasonPreConsume, LedgerReasonConsume, LedgerReasonRefund:
+		counter = LedgerAccountConsumption
+	}
+	return newLedgerEntry(ctx, UserLedgerAccount(userId), counter, delta, reason)
+}
+
+func tokenLedgerEntry(ctx context.Context, tokenId int, delta int64, reason string) *LedgerEntry {
+	return newLedgerEntry(ctx, TokenLedgerAccount(tokenId), LedgerAccountTokenAllowance, delta, reason)
+}
+
+// recordLedgerEntries appends the entries to the ledger, entries moving nothing are skipped
+func recordLedgerEntries(tx *gorm.DB, entries ...*LedgerEntry) error {
+	moving := make([]*LedgerEntry, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Amount != 0 {
+			moving = append(moving, entry)
+		}
+	}
+	if len(moving) == 0 {
+		return nil
+	}
+	if err := tx.Create(moving).Error; err != nil {
+		return errors.Wrap(err, "failed to record ledger entries")
+	}
+	return nil
+}
+
+// openLedger records the current quota of all users and tokens as their opening balances,
+// it is run once when the ledger is created on an existing database
+func openLedger() error {
+	ctx := context.Background()
+	var users []*User
+	err := DB.Select("id", "quota").FindInBatches(&users, 500, func(tx *gorm.DB, batch int) error {
+		entries := make([]*LedgerEntry, 0, len(users))
+		for _, user := range users {
+			entries = append(entries, userLedgerEntry(ctx, user.Id, user.Quota, LedgerReasonOpening))
+		}
+		return recordLedgerEntries(DB, entries...)
+	}).Error
+	if err != nil {
+		return errors.Wrap(err, "failed to open ledger of users")
+	}
+
+	var tokens []*Token
+	err = DB.Select("id", "remain_quota").FindInBatches(&tokens, 500, func(tx *gorm.DB, batch int) error {
+		entries := make([]*LedgerEntry, 0, len(tokens))
+		for _, token := range tokens {
+			entries = append(entries, tokenLedgerEntry(ctx, token.Id, token.RemainQuota, LedgerReasonOpening))
+		}
+		return recordLedgerEntries(DB, entries...)
+	}).Error
+	if err != nil {
+		return errors.Wrap(err, "failed to open ledger of tokens")
+	}
+	return nil
+}
+
+// GetLedgerEntries returns the entries moving quota of the account, or of all accounts if empty, newest first
+func GetLedgerEntries(account string, requestId string, startIdx int, num int) ([]*LedgerEntry, error) {
+	var entries []*LedgerEntry
+	query := DB.Model(&LedgerEntry{})
+	if account != "" {
+		query = query.Where("debit_account = ? OR credit_account = ?", account, account)
+	}
+	if requestId != "" {
+		query = query.Where("request_id = ?", requestId)
+	}
+	err := query.Order("id desc").Limit(num).Offset(startIdx).Find(&entries).Error
+	return entries, err
+}
+
+// getLedgerBalances returns the balances of the accounts starting with prefix computed from the ledger
+func getLedgerBalances(prefix string) (map[string]int64, error) {
+	type sum struct {
+		Account string
+		Amount  int64
+	}
+	balances := make(map[string]int64)
+	for _, side := range []string{"credit_account", "debit_account"} {
+		var sums []sum
+		err := DB.Model(&LedgerEntry{}).
+			Select(side+" AS account, SUM(amount) AS amount").
+			Where(side+" LIKE ?", prefix+"%").
+			Group(side).
+			Scan(&sums).Error
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to sum ledger by %s", side)
+		}
+		for _, s := range sums {
+			if side == "credit_account" {
+				balances[s.Account] += s.Amount
+			} else {
+				balances[s.Account] -= s.Amount
+			}
+		}
+	}
+	return balances, nil
+}
+
+// ReconcileLedger recomputes the quota of users and the remaining quota of tokens from the ledger,
+// and reports the ones differing in the DB or in the Redis cache. If fix is set, the DB is corrected
+// to the ledger and the drifted cache is dropped.
+//
+// Quota batched on other nodes is not in the DB yet, so fixing should only be done with batch updates disabled.
+func ReconcileLedger(ctx context.Context, fix bool) (*LedgerReport, error) {
+	if config.BatchUpdateEnabled {
+		batchUpdate()
+	}
+	report := &LedgerReport{Drifts: []LedgerDrift{}, CreatedTime: helper.GetTimestamp()}
+
+	userBalances, err := getLedgerBalances("user:")
+	if err != nil {
+		return nil, err
+	}
+	var users []*User
+	if err = DB.Select("id", "quota").Find(&users).Error; err != nil {
+		return nil, errors.Wrap(err, "failed to get quota of users")
+	}
+	report.Users = len(users)
+	for _, user := range users {
+		account := UserLedgerAccount(user.Id)
+		balance := userBalances[account]
+		if balance != user.Quota {
+			drift := &LedgerDrift{Account: account, Source: LedgerSourceDB, Ledger: balance, Stored: user.Quota}
+			if fix {
+				if drift, err = fixStoredBalance(&User{}, "quota", user.Id, account); err != nil {
+					return nil, errors.Wrapf(err, "failed to fix quota of user %d", user.Id)
+				}
+			}
+			if drift != nil {
+				balance = drift.Ledger
+				report.Drifts = append(report.Drifts, *drift)
+			}
+		}
+		if drift := reconcileCachedUserQuota(ctx, user.Id, balance, fix); drift != nil {
+			report.Drifts = append(report.Drifts, *drift)
+		}
+	}
+
+	tokenBalances, err := getLedgerBalances("token:")
+	if err != nil {
+		return nil, err
+	}
+	var tokens []*Token
+	if err = DB.Select("id", "key", "remain_quota").Find(&tokens).Error; err != nil {
+		return nil, errors.Wrap(err, "failed to get remaining quota of tokens")
+	}
+	report.Tokens = len(tokens)
+	for _, token := range tokens {
+		account := TokenLedgerAccount(token.Id)
+		balance := tokenBalances[account]
+		if balance == token.RemainQuota {
+			continue
+		}
+		drift := &LedgerDrift{Account: account, Source: LedgerSourceDB, Ledger: balance, Stored: token.RemainQuota}
+		if fix {
+			if drift, err = fixStoredBalance(&Token{}, "remain_quota", token.Id, account); err != nil {
+				return nil, errors.Wrapf(err, "failed to fix remaining quota of token %d", token.Id)
+			}
+			clearTokenCache(token.Key)
+		}
+		if drift != nil {
+			report.Drifts = append(report.Drifts, *drift)
+		}
+	}
+	return report, nil
+}
+
+// getAccountBalance returns the balance of the account computed from the ledger
+func getAccountBalance(tx *gorm.DB, account string) (int64, error) {
+	var credit, debit int64
+	if err := tx.Model(&LedgerEntry{}).Select("COALESCE(SUM(amount), 0)").
+		Where("credit_account = ?", account).Scan(&credit).Error; err != nil {
+		return 0, err
+	}
+	if err := tx.Model(&LedgerEntry{}).Select("COALESCE(SUM(amount), 0)").
+		Where("debit_account = ?", account).Scan(&debit).Error; err != nil {
+		return 0, err
+	}
+	return credit - debit, nil
+}
+
+// fixStoredBalance sets the column of the row id of the users or tokens table to the balance of the account
+// in the ledger. The row is locked before the ledger is summed again, so quota moving since the drift was found
+// is not overwritten, and nil is returned if the drift is gone. The row is only set if it still holds the value
+// read, for databases without row locks like SQLite.
+func fixStoredBalance(table any, column string, id int, account string) (*LedgerDrift, error) {
+	var drift *LedgerDrift
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var stored int64
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Model(table).Select(column).
+			Where("id = ?", id).Scan(&stored).Error; err != nil {
+			return err
+		}
+		balance, err := getAccountBalance(tx, account)
+		if err != nil {
+			return err
+		}
+		if balance == stored {
+			return nil
+		}
+		result := tx.Model(table).Where("id = ? AND "+column+" = ?", id, stored).Update(column, balance)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			// the row has changed since it was read, the next reconciliation looks at it again
+			return nil
+		}
+		drift = &LedgerDrift{Account: account, Source: LedgerSourceDB, Ledger: balance, Stored: stored, Fixed: true}
+		return nil
+	})
+	return drift, err
+}
+
+// reconcileCachedUserQuota compares the quota of the user cached in Redis with the balance of the ledger.
+// Requests in flight have their pre-consumed quota taken from the cache ahead of the DB, so small transient
+// drifts are expected.
+func reconcileCachedUserQuota(ctx context.Context, userId int, balance int64, fix bool) *LedgerDrift {
+	if !common.RedisEnabled {
+		return nil
+	}
+	key := fmt.Sprintf("user_quota:%d", userId)
+	cached, err := common.RedisGet(key)
+	if err != nil {
+		// not cached
+		return nil
+	}
+	quota, err := strconv.ParseInt(strings.TrimSpace(cached), 10, 64)
+	if err == nil && quota == balance {
+		return nil
+	}
+	drift := &LedgerDrift{Account: UserLedgerAccount(userId), Source: LedgerSourceRedis, Ledger: balance, Stored: quota}
+	if fix {
+		if err = common.RedisDel(key); err != nil {
+			logger.Errorf(ctx, "failed to drop cached quota of user %d: %+v", userId, err)
+		} else {
+			drift.Fixed = true
+		}
+	}
+	return drift
+}
+
+// SyncLedgerReconciliation reconciles the ledger every frequency seconds and logs the drifts found
+func SyncLedgerReconciliation(frequency int, fix bool) {
+	ctx := context.Background()
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		report, err := ReconcileLedger(ctx, fix)
+		if err != nil {
+			logger.SysError("failed to reconcile ledger: " + err.Error())
+			continue
+		}
+		for _, drift := range report.Drifts {
+			logger.SysWarnf("ledger drift of %s in %s: ledger %d, stored %d, fixed %t",
+				drift.Account, drift.Source, drift.Ledger, drift.Stored, drift.Fixed)
+		}
+		logger.SysLog(fmt.Sprintf("reconciled ledger of %d users and %d tokens, %d drifts found",
+			report.Users, report.Tokens, len(report.Drifts)))
+	}
+}
@@ -0,0 +1,90 @@
+package model
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+)
+
+func TestLedger(t *testing.T) {
+	testDB, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, testDB.AutoMigrate(&User{}, &Token{}, &Redemption{}, &Log{}, &LedgerEntry{}))
+	originalDB, originalLogDB, originalRedisEnabled, originalBatchUpdateEnabled := DB, LOG_DB, common.RedisEnabled, config.BatchUpdateEnabled
+	DB, LOG_DB, common.RedisEnabled, config.BatchUpdateEnabled = testDB, testDB, false, false
+	defer func() {
+		DB, LOG_DB, common.RedisEnabled, config.BatchUpdateEnabled = originalDB, originalLogDB, originalRedisEnabled, originalBatchUpdateEnabled
+	}()
+
+	// balances existing before the ledger are opened
+	user := &User{Id: 2501, Username: "ledger", AccessToken: "ledger", AffCode: "ledger", Quota: 5000}
+	require.NoError(t, testDB.Create(user).Error)
+	token := &Token{Id: 2501, UserId: user.Id, Key: "ledger", RemainQuota: 1000}
+	require.NoError(t, testDB.Create(token).Error)
+	require.NoError(t, openLedger())
+
+	ctx := helper.SetRequestID(context.Background(), "ledger-request")
+	require.NoError(t, PreConsumeTokenQuota(ctx, token.Id, 300))
+	require.NoError(t, PostConsumeTokenQuota(ctx, token.Id, -100))
+	require.NoError(t, IncreaseUserQuota(context.Background(), user.Id, 500, LedgerReasonTopup))
+
+	redemption := &Redemption{Key: "ledger-redemption", Status: RedemptionCodeStatusEnabled, Quota: 200}
+	require.NoError(t, testDB.Create(redemption).Error)
+	_, err = Redeem(context.Background(), redemption.Key, user.Id)
+	require.NoError(t, err)
+
+	quota, err := GetUserQuota(user.Id)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5500), quota)
+
+	entries, err := GetLedgerEntries(UserLedgerAccount(user.Id), "ledger-request", 0, 10)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, LedgerReasonRefund, entries[0].Reason)
+	assert.Equal(t, LedgerAccountConsumption, entries[0].DebitAccount)
+	assert.Equal(t, int64(100), entries[0].Amount)
+	assert.Equal(t, LedgerReasonPreConsume, entries[1].Reason)
+	assert.Equal(t, UserLedgerAccount(user.Id), entries[1].DebitAccount)
+
+	balances, err := getLedgerBalances("")
+	require.NoError(t, err)
+	var total int64
+	for _, balance := range balances {
+		total += balance
+	}
+	assert.Zero(t, total, "every movement has two sides")
+	assert.Equal(t, int64(800), balances[TokenLedgerAccount(token.Id)])
+
+	report, err := ReconcileLedger(context.Background(), false)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Users)
+	assert.Equal(t, 1, report.Tokens)
+	assert.Empty(t, report.Drifts)
+
+	// quota changed behind the back of the ledger drifts until it is fixed
+	require.NoError(t, testDB.Model(&User{}).Where("id = ?", user.Id).Update("quota", 42).Error)
+	report, err = ReconcileLedger(context.Background(), false)
+	require.NoError(t, err)
+	require.Len(t, report.Drifts, 1)
+	assert.Equal(t, LedgerDrift{Account: UserLedgerAccount(user.Id), Source: LedgerSourceDB, Ledger: 5500, Stored: 42}, report.Drifts[0])
+
+	report, err = ReconcileLedger(context.Background(), true)
+	require.NoError(t, err)
+	require.Len(t, report.Drifts, 1)
+	assert.True(t, report.Drifts[0].Fixed)
+	quota, err = GetUserQuota(user.Id)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5500), quota)
+
+	report, err = ReconcileLedger(context.Background(), false)
+	require.NoError(t, err)
+	assert.Empty(t, report.Drifts)
+}
@@ -29,6 +29,12 @@ type Log struct {
 	ElapsedTime       int64  `json:"elapsed_time" gorm:"default:0"` // unit is ms
 	IsStream          bool   `json:"is_stream" gorm:"default:false"`
 	SystemPromptReset bool   `json:"system_prompt_reset" gorm:"default:false"`
+	CacheHit          bool   `json:"cache_hit" gorm:"default:false"` // served from the response cache
+	// ChannelKey is the fingerprint of the key used when the channel holds multiple keys
+	ChannelKey string `json:"channel_key" gorm:"type:varchar(16);default:''"`
+	// CachedTokens and CacheWriteTokens are the prompt tokens read from and written to the prompt cache
+	CachedTokens     int `json:"cached_tokens" gorm:"default:0"`
+	CacheWriteTokens int `json:"cache_write_tokens" gorm:"default:0"`
 }
 
 const (
@@ -49,6 +49,9 @@ func CreateRootAccountIfNeed() error {
 			Quota:       500000000000000,
 		}
 		DB.Create(&rootUser)
+		if err := recordLedgerEntries(DB, userLedgerEntry(context.Background(), rootUser.Id, rootUser.Quota, LedgerReasonOpening)); err != nil {
+			logger.SysError("failed to record opening balance of root user: " + err.Error())
+		}
 		if config.InitialRootToken != "" {
 			logger.SysLog("creating initial root token as requested")
 			token := Token{
@@ -64,6 +67,9 @@ func CreateRootAccountIfNeed() error {
 				UnlimitedQuota: true,
 			}
 			DB.Create(&token)
+			if err := recordLedgerEntries(DB, tokenLedgerEntry(context.Background(), token.Id, token.RemainQuota, LedgerReasonOpening)); err != nil {
+				logger.SysError("failed to record opening balance of initial root token: " + err.Error())
+			}
 		}
 	}
 	return nil
@@ -174,6 +180,9 @@ func migrateDB() error {
 	if err = DB.AutoMigrate(&Redemption{}); err != nil {
 		return err
 	}
+	if err = DB.AutoMigrate(&ChannelKey{}); err != nil {
+		return err
+	}
 	if err = DB.AutoMigrate(&Ability{}); err != nil {
 		return err
 	}
@@ -183,6 +192,39 @@ func migrateDB() error {
 	if err = DB.AutoMigrate(&UserRequestCost{}); err != nil {
 		return err
 	}
+	if err = DB.AutoMigrate(&ResponseRecord{}); err != nil {
+		return err
+	}
+	if err = DB.AutoMigrate(&File{}); err != nil {
+		return err
+	}
+	if err = DB.AutoMigrate(&Batch{}); err != nil {
+		return err
+	}
+	if err = DB.AutoMigrate(&BatchLine{}); err != nil {
+		return err
+	}
+	if err = DB.AutoMigrate(&AssistantObject{}); err != nil {
+		return err
+	}
+	if err = DB.AutoMigrate(&FineTuningJob{}); err != nil {
+		return err
+	}
+	if err = DB.AutoMigrate(&Plan{}, &UserPlan{}, &PlanHistory{}); err != nil {
+		return err
+	}
+	if err = DB.AutoMigrate(&Spend{}); err != nil {
+		return err
+	}
+	ledgerExisted := DB.Migrator().HasTable(&LedgerEntry{})
+	if err = DB.AutoMigrate(&LedgerEntry{}); err != nil {
+		return err
+	}
+	if !ledgerExisted {
+		if err = openLedger(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -8,6 +8,10 @@ import (
 	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/logger"
 	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
+	"github.com/songquanpeng/one-api/relay/fallback"
+	"github.com/songquanpeng/one-api/relay/ratelimit"
+	"github.com/songquanpeng/one-api/relay/responsecache"
+	"github.com/songquanpeng/one-api/relay/routing"
 )
 
 type Option struct {
@@ -69,10 +73,23 @@ func InitOptionMap() {
 	config.OptionMap["QuotaRemindThreshold"] = strconv.FormatInt(config.QuotaRemindThreshold, 10)
 	config.OptionMap["PreConsumedQuota"] = strconv.FormatInt(config.PreConsumedQuota, 10)
 	config.OptionMap["GroupRatio"] = billingratio.GroupRatio2JSONString()
+	config.OptionMap["TrainingRatio"] = billingratio.TrainingRatio2JSONString()
 	config.OptionMap["TopUpLink"] = config.TopUpLink
 	config.OptionMap["ChatLink"] = config.ChatLink
 	config.OptionMap["QuotaPerUnit"] = strconv.FormatFloat(config.QuotaPerUnit, 'f', -1, 64)
 	config.OptionMap["RetryTimes"] = strconv.Itoa(config.RetryTimes)
+	config.OptionMap["CircuitBreakerEnabled"] = strconv.FormatBool(config.CircuitBreakerEnabled)
+	config.OptionMap["CircuitBreakerFailureThreshold"] = strconv.Itoa(config.CircuitBreakerFailureThreshold)
+	config.OptionMap["CircuitBreakerOpenSeconds"] = strconv.Itoa(config.CircuitBreakerOpenSeconds)
+	config.OptionMap["ChannelQueueSize"] = strconv.Itoa(config.ChannelQueueSize)
+	config.OptionMap["ChannelQueueTimeoutSeconds"] = strconv.Itoa(config.ChannelQueueTimeoutSeconds)
+	config.OptionMap["ModelFallbackGroups"] = fallback.GroupChains2JSONString()
+	config.OptionMap["ResponseCacheGroups"] = responsecache.GroupModes2JSONString()
+	config.OptionMap["RoutingStrategyGroups"] = routing.GroupStrategies2JSONString()
+	config.OptionMap["RoutingStrategyModels"] = routing.ModelStrategies2JSONString()
+	config.OptionMap["StickySessionGroups"] = routing.GroupSessionSources2JSONString()
+	config.OptionMap["RateLimitGroups"] = ratelimit.GroupLimits2JSONString()
+	config.OptionMap["ResponseCacheQuotaRatio"] = strconv.FormatFloat(config.ResponseCacheQuotaRatio, 'f', -1, 64)
 	config.OptionMap["Theme"] = config.Theme
 	config.OptionMapRWMutex.Unlock()
 	loadOptionsFromDatabase()
@@ -153,6 +170,8 @@ func updateOptionMap(key string, value string) (err error) {
 			config.DisplayInCurrencyEnabled = boolValue
 		case "DisplayTokenStatEnabled":
 			config.DisplayTokenStatEnabled = boolValue
+		case "CircuitBreakerEnabled":
+			config.CircuitBreakerEnabled = boolValue
 		}
 	}
 	switch key {
@@ -228,6 +247,30 @@ func updateOptionMap(key string, value string) (err error) {
 		return nil
 	case "GroupRatio":
 		err = billingratio.UpdateGroupRatioByJSONString(value)
+	case "ModelFallbackGroups":
+		err = fallback.UpdateGroupChainsByJSONString(value)
+	case "ResponseCacheGroups":
+		err = responsecache.UpdateGroupModesByJSONString(value)
+	case "CircuitBreakerFailureThreshold":
+		config.CircuitBreakerFailureThreshold, _ = strconv.Atoi(value)
+	case "CircuitBreakerOpenSeconds":
+		config.CircuitBreakerOpenSeconds, _ = strconv.Atoi(value)
+	case "ChannelQueueSize":
+		config.ChannelQueueSize, _ = strconv.Atoi(value)
+	case "ChannelQueueTimeoutSeconds":
+		config.ChannelQueueTimeoutSeconds, _ = strconv.Atoi(value)
+	case "RoutingStrategyGroups":
+		err = routing.UpdateGroupStrategiesByJSONString(value)
+	case "RoutingStrategyModels":
+		err = routing.UpdateModelStrategiesByJSONString(value)
+	case "StickySessionGroups":
+		err = routing.UpdateGroupSessionSourcesByJSONString(value)
+	case "RateLimitGroups":
+		err = ratelimit.UpdateGroupLimitsByJSONString(value)
+	case "ResponseCacheQuotaRatio":
+		config.ResponseCacheQuotaRatio, _ = strconv.ParseFloat(value, 64)
+	case "TrainingRatio":
+		err = billingratio.UpdateTrainingRatioByJSONString(value)
 	case "TopUpLink":
 		config.TopUpLink = value
 	case "ChatLink":
@@ -0,0 +1,539 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+const (
+	PlanStatusEnabled  = 1 // don't use 0, 0 is the default value!
+	PlanStatusDisabled = 2 // also don't use 0
+)
+
+// Periods of plans, a plan grants its quota once per period
+const (
+	PlanPeriodDay   = "day"
+	PlanPeriodWeek  = "week"
+	PlanPeriodMonth = "month"
+)
+
+// Rollover rules of plans, deciding what becomes of the allocation left unused at the end of a period
+const (
+	// PlanRolloverExpire takes back the allocation left unused
+	PlanRolloverExpire = "expire"
+	// PlanRolloverCarry carries the allocation left unused over to the next period, up to the rollover cap of the plan
+	PlanRolloverCarry = "carry"
+)
+
+// Types of plan history records
+const (
+	PlanHistoryTypeUnknown = iota
+	PlanHistoryTypeAssign
+	PlanHistoryTypeGrant
+	PlanHistoryTypeRollover
+	PlanHistoryTypeExpire
+	PlanHistoryTypeCancel
+)
+
+// Plan is a subscription plan granting its users quota every period
+type Plan struct {
+	Id         int    `json:"id"`
+	Name       string `json:"name" gorm:"type:varchar(64);uniqueIndex"`
+	PriceLabel string `json:"price_label" gorm:"type:varchar(64)"`
+	// Quota is the quota granted every period
+	Quota  int64  `json:"quota" gorm:"bigint;default:0"`
+	Period string `json:"period" gorm:"type:varchar(16);default:'month'"`
+	// Rollover is what becomes of the allocation left unused at the end of a period, see PlanRolloverExpire
+	Rollover string `json:"rollover" gorm:"type:varchar(16);default:'expire'"`
+	// RolloverCap is the most quota carried over to the next period, 0 means unlimited
+	RolloverCap int64 `json:"rollover_cap" gorm:"bigint;default:0"`
+	// Groups are the comma separated groups users of the plan may be in, empty means any group
+	Groups string `json:"groups" gorm:"type:varchar(255)"`
+	// Models are the comma separated models users of the plan may use, empty means any model
+	Models      string `json:"models" gorm:"type:text"`
+	Status      int    `json:"status" gorm:"default:1"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+}
+
+// UserPlan is the subscription of a user to a plan, a user has at most one plan
+type UserPlan struct {
+	Id        int   `json:"id"`
+	UserId    int   `json:"user_id" gorm:"uniqueIndex"`
+	PlanId    int   `json:"plan_id" gorm:"index"`
+	StartTime int64 `json:"start_time" gorm:"bigint"`
+	// PeriodStart and PeriodEnd bound the current period, the next allocation is granted at PeriodEnd
+	PeriodStart int64 `json:"period_start" gorm:"bigint"`
+	PeriodEnd   int64 `json:"period_end" gorm:"bigint;index"`
+	// ExpireTime is when the subscription ends, 0 means never
+	ExpireTime int64 `json:"expire_time" gorm:"bigint;default:0"`
+	// Allocation is the quota granted for the current period, including the quota carried over
+	Allocation int64 `json:"allocation" gorm:"bigint;default:0"`
+	// UsedQuotaAtPeriodStart is the used quota of the user when the period started,
+	// quota used since then is taken from the allocation first
+	UsedQuotaAtPeriodStart int64 `json:"-" gorm:"bigint;default:0"`
+}
+
+// PlanHistory records the allocation changes of the plans of users
+type PlanHistory struct {
+	Id        int    `json:"id"`
+	UserId    int    `json:"user_id" gorm:"index"`
+	PlanId    int    `json:"plan_id"`
+	PlanName  string `json:"plan_name"`
+	Type      int    `json:"type"`
+	Quota     int64  `json:"quota" gorm:"bigint;default:0"`
+	Content   string `json:"content"`
+	CreatedAt int64  `json:"created_at" gorm:"bigint;index"`
+}
+
+// UserPlanStatus is the plan of a user and the allocation left in the current period
+type UserPlanStatus struct {
+	UserPlan
+	Plan      *Plan `json:"plan"`
+	Remaining int64 `json:"remaining"`
+}
+
+// Validate checks the plan and fills the defaults of its period and rollover rule
+func (plan *Plan) Validate() error {
+	if plan.Name == "" {
+		return errors.New("plan name is empty")
+	}
+	if plan.Quota < 0 || plan.RolloverCap < 0 {
+		return errors.New("quota and rollover cap of plan cannot be negative")
+	}
+	switch plan.Period {
+	case "":
+		plan.Period = PlanPeriodMonth
+	case PlanPeriodDay, PlanPeriodWeek, PlanPeriodMonth:
+	default:
+		return errors.Errorf("invalid period %q, expecting day, week or month", plan.Period)
+	}
+	switch plan.Rollover {
+	case "":
+		plan.Rollover = PlanRolloverExpire
+	case PlanRolloverExpire, PlanRolloverCarry:
+	default:
+		return errors.Errorf("invalid rollover %q, expecting expire or carry", plan.Rollover)
+	}
+	return nil
+}
+
+// nextPeriodEnd returns the end of the period of the plan starting at start
+func (plan *Plan) nextPeriodEnd(start int64) int64 {
+	t := time.Unix(start, 0)
+	switch plan.Period {
+	case PlanPeriodDay:
+		t = t.AddDate(0, 0, 1)
+	case PlanPeriodWeek:
+		t = t.AddDate(0, 0, 7)
+	default:
+		t = t.AddDate(0, 1, 0)
+	}
+	return t.Unix()
+}
+
+// AllowsModel reports whether users of the plan may use modelName
+func (plan *Plan) AllowsModel(modelName string) bool {
+	return plan.Models == "" || isInCommaList(modelName, plan.Models)
+}
+
+// AllowsGroup reports whether users of the plan may be in group
+func (plan *Plan) AllowsGroup(group string) bool {
+	return plan.Groups == "" || isInCommaList(group, plan.Groups)
+}
+
+func isInCommaList(value string, list string) bool {
+	for _, item := range strings.Split(list, ",") {
+		if strings.TrimSpace(item) == value {
+			return true
+		}
+	}
+	return false
+}
+
+func GetAllPlans(startIdx int, num int) (plans []*Plan, err error) {
+	err = DB.Order("id desc").Limit(num).Offset(startIdx).Find(&plans).Error
+	return plans, err
+}
+
+func GetPlanById(id int) (*Plan, error) {
+	return getPlanById(DB, id)
+}
+
+func getPlanById(tx *gorm.DB, id int) (*Plan, error) {
+	if id == 0 {
+		return nil, errors.New("id is empty!")
+	}
+	plan := Plan{Id: id}
+	err := tx.First(&plan, "id = ?", id).Error
+	return &plan, err
+}
+
+func (plan *Plan) Insert() error {
+	return DB.Create(plan).Error
+}
+
+// Update Make sure your plan's fields is completed, because this will update non-zero values
+func (plan *Plan) Update() error {
+	if err := DB.Model(plan).Select("name", "price_label", "quota", "period", "rollover", "rollover_cap",
+		"groups", "models", "status").Updates(plan).Error; err != nil {
+		return err
+	}
+	if common.RedisEnabled {
+		var userIds []int
+		if err := DB.Model(&UserPlan{}).Where("plan_id = ?", plan.Id).Pluck("user_id", &userIds).Error; err != nil {
+			return errors.Wrap(err, "failed to get users of plan")
+		}
+		for _, userId := range userIds {
+			if err := common.RedisDel(fmt.Sprintf("user_plan_models:%d", userId)); err != nil {
+				logger.SysError("Redis delete user plan models error: " + err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// DeletePlanById deletes the plan, which fails while users are subscribed to it
+func DeletePlanById(id int) error {
+	if id == 0 {
+		return errors.New("id is empty!")
+	}
+	var subscribers int64
+	if err := DB.Model(&UserPlan{}).Where("plan_id = ?", id).Count(&subscribers).Error; err != nil {
+		return err
+	}
+	if subscribers > 0 {
+		return errors.Errorf("plan is still assigned to %d users", subscribers)
+	}
+	return DB.Delete(&Plan{Id: id}).Error
+}
+
+// remaining returns the allocation left in the current period of the user plan,
+// given the quota and the used quota of the user
+func (userPlan *UserPlan) remaining(quota int64, usedQuota int64) int64 {
+	left := userPlan.Allocation - (usedQuota - userPlan.UsedQuotaAtPeriodStart)
+	return max(min(left, quota), 0)
+}
+
+// GetUserPlanStatus returns the plan of the user, or nil if the user has no plan
+func GetUserPlanStatus(userId int) (*UserPlanStatus, error) {
+	var userPlan UserPlan
+	if err := DB.Where("user_id = ?", userId).Limit(1).Find(&userPlan).Error; err != nil {
+		return nil, err
+	}
+	if userPlan.Id == 0 {
+		return nil, nil
+	}
+	plan, err := GetPlanById(userPlan.PlanId)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get plan %d", userPlan.PlanId)
+	}
+	user := User{}
+	if err = DB.Select("quota", "used_quota").Where("id = ?", userId).First(&user).Error; err != nil {
+		return nil, err
+	}
+	return &UserPlanStatus{
+		UserPlan:  userPlan,
+		Plan:      plan,
+		Remaining: userPlan.remaining(user.Quota, user.UsedQuota),
+	}, nil
+}
+
+// GetUserPlanModels returns the comma separated models allowed by the plan of the user, empty means any model
+func GetUserPlanModels(userId int) (models string, err error) {
+	err = DB.Model(&Plan{}).
+		Joins("JOIN user_plans ON user_plans.plan_id = plans.id").
+		Where("user_plans.user_id = ?", userId).
+		Select("plans.models").Limit(1).Find(&models).Error
+	return models, err
+}
+
+// UserPlanAllowsModel reports whether the plan of the user includes modelName,
+// users without a plan or with a plan not restricting the models may use any model
+func UserPlanAllowsModel(userId int, modelName string) (bool, error) {
+	models, err := CacheGetUserPlanModels(userId)
+	if err != nil {
+		return false, err
+	}
+	return models == "" || isInCommaList(modelName, models), nil
+}
+
+func GetPlanHistories(userId int, startIdx int, num int) (histories []*PlanHistory, err error) {
+	err = DB.Where("user_id = ?", userId).Order("id desc").Limit(num).Offset(startIdx).Find(&histories).Error
+	return histories, err
+}
+
+func recordPlanHistory(tx *gorm.DB, userId int, plan *Plan, historyType int, quota int64, content string) error {
+	return tx.Create(&PlanHistory{
+		UserId:    userId,
+		PlanId:    plan.Id,
+		PlanName:  plan.Name,
+		Type:      historyType,
+		Quota:     quota,
+		Content:   content,
+		CreatedAt: helper.GetTimestamp(),
+	}).Error
+}
+
+// settleAllocation applies the rollover rule of the plan to the allocation left unused in the current period
+// of the user plan, and returns the quota carried over. The quota taken back is removed from the user.
+func settleAllocation(ctx context.Context, tx *gorm.DB, userPlan *UserPlan, plan *Plan, carry bool) (carried int64, err error) {
+	if !carry && plan.Rollover == PlanRolloverCarry {
+		// users of plans carrying their allocation over keep it when the subscription ends
+		return 0, nil
+	}
+
+	user := User{}
+	if err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("quota", "used_quota").
+		Where("id = ?", userPlan.UserId).First(&user).Error; err != nil {
+		return 0, err
+	}
+
+	left := userPlan.remaining(user.Quota, user.UsedQuota)
+	if carry && plan.Rollover == PlanRolloverCarry {
+		carried = left
+		if plan.RolloverCap > 0 {
+			carried = min(carried, plan.RolloverCap)
+		}
+		if carried > 0 {
+			if err = recordPlanHistory(tx, userPlan.UserId, plan, PlanHistoryTypeRollover, carried,
+				fmt.Sprintf("Carried %s over to the next period", common.LogQuota(carried))); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	expired := left - carried
+	if expired <= 0 {
+		return carried, nil
+	}
+	if err = tx.Model(&User{}).Where("id = ?", userPlan.UserId).
+		Update("quota", gorm.Expr("quota - ?", expired)).Error; err != nil {
+		return 0, err
+	}
+	if err = recordLedgerEntries(tx, userLedgerEntry(ctx, userPlan.UserId, -expired, LedgerReasonPlanExpire)); err != nil {
+		return 0, err
+	}
+	return carried, recordPlanHistory(tx, userPlan.UserId, plan, PlanHistoryTypeExpire, -expired,
+		fmt.Sprintf("%s left unused expired", common.LogQuota(expired)))
+}
+
+// grantAllocation starts a new period of the user plan at start, granting the quota of the plan plus carried
+func grantAllocation(ctx context.Context, tx *gorm.DB, userPlan *UserPlan, plan *Plan, start int64, carried int64) error {
+	if err := tx.Model(&User{}).Where("id = ?", userPlan.UserId).
+		Update("quota", gorm.Expr("quota + ?", plan.Quota)).Error; err != nil {
+		return err
+	}
+	if err := recordLedgerEntries(tx, userLedgerEntry(ctx, userPlan.UserId, plan.Quota, LedgerReasonPlanGrant)); err != nil {
+		return err
+	}
+	user := User{}
+	if err := tx.Select("used_quota").Where("id = ?", userPlan.UserId).First(&user).Error; err != nil {
+		return err
+	}
+
+	userPlan.PeriodStart = start
+	userPlan.PeriodEnd = plan.nextPeriodEnd(start)
+	userPlan.Allocation = plan.Quota + carried
+	userPlan.UsedQuotaAtPeriodStart = user.UsedQuota
+	if err := tx.Save(userPlan).Error; err != nil {
+		return err
+	}
+	return recordPlanHistory(tx, userPlan.UserId, plan, PlanHistoryTypeGrant, plan.Quota,
+		fmt.Sprintf("Granted %s by plan %s", common.LogQuota(plan.Quota), plan.Name))
+}
+
+// refreshUserPlanCache reloads the cached quota of the user, and drops the cached group and plan models,
+// which are changed by subscriptions and their periods outside of the cache
+func refreshUserPlanCache(ctx context.Context, userId int) {
+	if !common.RedisEnabled {
+		return
+	}
+	if _, err := fetchAndUpdateUserQuota(ctx, userId); err != nil {
+		logger.Errorf(ctx, "failed to refresh cached quota of user %d: %+v", userId, err)
+	}
+	for _, key := range []string{fmt.Sprintf("user_group:%d", userId), fmt.Sprintf("user_plan_models:%d", userId)} {
+		if err := common.RedisDel(key); err != nil {
+			logger.Errorf(ctx, "failed to drop cache %s: %+v", key, err)
+		}
+	}
+}
+
+// AssignPlan subscribes the user to the plan until expireTime, 0 means never, and grants the quota of its
+// first period right away. The allocation left by a previous plan is settled by the rules of that plan.
+// Users not in a group allowed by the plan are moved to the first of them.
+func AssignPlan(ctx context.Context, userId int, planId int, expireTime int64) (*UserPlan, error) {
+	plan, err := GetPlanById(planId)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get plan %d", planId)
+	}
+	if plan.Status != PlanStatusEnabled {
+		return nil, errors.Errorf("plan %s is disabled", plan.Name)
+	}
+	now := helper.GetTimestamp()
+	if expireTime != 0 && expireTime <= now {
+		return nil, errors.New("expire time of plan must be in the future")
+	}
+
+	userPlan := &UserPlan{}
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		groupCol := "`group`"
+		if common.UsingPostgreSQL {
+			groupCol = `"group"`
+		}
+		user := User{}
+		if err := tx.Select("id", groupCol).Where("id = ?", userId).First(&user).Error; err != nil {
+			return errors.Wrapf(err, "failed to get user %d", userId)
+		}
+		if err := tx.Where("user_id = ?", userId).Limit(1).Find(userPlan).Error; err != nil {
+			return err
+		}
+		if userPlan.Id != 0 {
+			if previous, err := getPlanById(tx, userPlan.PlanId); err == nil {
+				if _, err = settleAllocation(ctx, tx, userPlan, previous, false); err != nil {
+					return err
+				}
+			}
+		}
+
+		if !plan.AllowsGroup(user.Group) {
+			group := strings.TrimSpace(strings.Split(plan.Groups, ",")[0])
+			if err := tx.Model(&User{}).Where("id = ?", userId).Update("group", group).Error; err != nil {
+				return err
+			}
+		}
+
+		userPlan.UserId = userId
+		userPlan.PlanId = plan.Id
+		userPlan.StartTime = now
+		userPlan.ExpireTime = expireTime
+		if err := recordPlanHistory(tx, userId, plan, PlanHistoryTypeAssign, 0,
+			fmt.Sprintf("Subscribed to plan %s", plan.Name)); err != nil {
+			return err
+		}
+		return grantAllocation(ctx, tx, userPlan, plan, now, 0)
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to assign plan")
+	}
+	refreshUserPlanCache(ctx, userId)
+	RecordTopupLog(ctx, userId, fmt.Sprintf("Subscribed to plan %s, granted %s", plan.Name, common.LogQuota(plan.Quota)), int(plan.Quota))
+	return userPlan, nil
+}
+
+// CancelUserPlan ends the subscription of the user, the allocation left is settled by the rules of the plan
+func CancelUserPlan(ctx context.Context, userId int) error {
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		userPlan := &UserPlan{}
+		if err := tx.Where("user_id = ?", userId).First(userPlan).Error; err != nil {
+			return errors.Wrap(err, "user has no plan")
+		}
+		return endUserPlan(ctx, tx, userPlan, PlanHistoryTypeCancel)
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to cancel plan")
+	}
+	refreshUserPlanCache(ctx, userId)
+	RecordLog(ctx, userId, LogTypeManage, "Plan cancelled")
+	return nil
+}
+
+func endUserPlan(ctx context.Context, tx *gorm.DB, userPlan *UserPlan, historyType int) error {
+	plan, err := getPlanById(tx, userPlan.PlanId)
+	if err != nil {
+		plan = &Plan{Id: userPlan.PlanId}
+	} else if _, err = settleAllocation(ctx, tx, userPlan, plan, false); err != nil {
+		return err
+	}
+	if err = tx.Delete(userPlan).Error; err != nil {
+		return err
+	}
+	return recordPlanHistory(tx, userPlan.UserId, plan, historyType, 0, "Plan ended")
+}
+
+// RenewUserPlans starts the next period of every user plan whose period is over at now.
+// Subscriptions past their expire time end instead, and plans missing several periods are granted once.
+func RenewUserPlans(ctx context.Context, now int64) (renewed int, err error) {
+	var due []*UserPlan
+	if err = DB.Where("period_end <= ?", now).Find(&due).Error; err != nil {
+		return 0, errors.Wrap(err, "failed to get user plans to renew")
+	}
+
+	for _, userPlan := range due {
+		plan, err := GetPlanById(userPlan.PlanId)
+		if err != nil {
+			logger.Errorf(ctx, "failed to get plan %d of user %d: %+v", userPlan.PlanId, userPlan.UserId, err)
+			continue
+		}
+
+		granted, skipped := false, false
+		err = DB.Transaction(func(tx *gorm.DB) error {
+			// the plan is read again under lock, another pass may have renewed it since it was listed
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("id = ? AND period_end <= ?", userPlan.Id, now).Take(userPlan).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					skipped = true
+					return nil
+				}
+				return err
+			}
+			if userPlan.ExpireTime != 0 && userPlan.ExpireTime <= now {
+				return endUserPlan(ctx, tx, userPlan, PlanHistoryTypeExpire)
+			}
+			if plan.Status != PlanStatusEnabled {
+				// disabled plans keep their users without granting them quota until enabled again
+				userPlan.PeriodEnd = plan.nextPeriodEnd(now)
+				return tx.Model(userPlan).Update("period_end", userPlan.PeriodEnd).Error
+			}
+
+			carried, err := settleAllocation(ctx, tx, userPlan, plan, true)
+			if err != nil {
+				return err
+			}
+			start := userPlan.PeriodEnd
+			for end := plan.nextPeriodEnd(start); end <= now; end = plan.nextPeriodEnd(start) {
+				start = end
+			}
+			granted = true
+			return grantAllocation(ctx, tx, userPlan, plan, start, carried)
+		})
+		if err != nil {
+			logger.Errorf(ctx, "failed to renew plan of user %d: %+v", userPlan.UserId, err)
+			continue
+		}
+		if skipped {
+			continue
+		}
+		refreshUserPlanCache(ctx, userPlan.UserId)
+		if granted {
+			RecordTopupLog(ctx, userPlan.UserId, fmt.Sprintf("Granted %s by plan %s", common.LogQuota(plan.Quota), plan.Name), int(plan.Quota))
+		}
+		renewed++
+	}
+	return renewed, nil
+}
+
+// SyncUserPlans renews the plans of users every frequency seconds
+func SyncUserPlans(frequency int) {
+	ctx := context.Background()
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		renewed, err := RenewUserPlans(ctx, helper.GetTimestamp())
+		if err != nil {
+			logger.SysError("failed to renew user plans: " + err.Error())
+			continue
+		}
+		if renewed > 0 {
+			logger.SysLog(fmt.Sprintf("renewed %d user plans", renewed))
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package model
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/songquanpeng/one-api/common"
+)
+
+func setupPlanTestDB(t *testing.T) {
+	testDB, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, testDB.AutoMigrate(&User{}, &Log{}, &Plan{}, &UserPlan{}, &PlanHistory{}, &LedgerEntry{}))
+	originalDB, originalLogDB, originalRedisEnabled := DB, LOG_DB, common.RedisEnabled
+	DB, LOG_DB, common.RedisEnabled = testDB, testDB, false
+	t.Cleanup(func() { DB, LOG_DB, common.RedisEnabled = originalDB, originalLogDB, originalRedisEnabled })
+}
+
+func TestPlan_Validate(t *testing.T) {
+	plan := &Plan{Name: "basic", Quota: 1000}
+	require.NoError(t, plan.Validate())
+	assert.Equal(t, PlanPeriodMonth, plan.Period)
+	assert.Equal(t, PlanRolloverExpire, plan.Rollover)
+
+	assert.Error(t, (&Plan{Quota: 1000}).Validate())
+	assert.Error(t, (&Plan{Name: "basic", Quota: -1}).Validate())
+	assert.Error(t, (&Plan{Name: "basic", Period: "year"}).Validate())
+	assert.Error(t, (&Plan{Name: "basic", Rollover: "keep"}).Validate())
+}
+
+func TestAssignPlan(t *testing.T) {
+	setupPlanTestDB(t)
+	ctx := context.Background()
+
+	user := &User{Id: 2301, Username: "plan", AccessToken: "plan", AffCode: "plan", Quota: 50, Group: "default"}
+	require.NoError(t, DB.Create(user).Error)
+	plan := &Plan{Name: "pro", Quota: 1000, Groups: "vip,svip", Models: "gpt-4o", Status: PlanStatusEnabled}
+	require.NoError(t, plan.Validate())
+	require.NoError(t, plan.Insert())
+
+	userPlan, err := AssignPlan(ctx, user.Id, plan.Id, 0)
+	require.NoError(t, err)
+	assert.Equal(t, plan.nextPeriodEnd(userPlan.PeriodStart), userPlan.PeriodEnd)
+
+	quota, err := GetUserQuota(user.Id)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1050), quota)
+	group, err := GetUserGroup(user.Id)
+	require.NoError(t, err)
+	assert.Equal(t, "vip", group, "users are moved to a group allowed by the plan")
+	models, err := GetUserPlanModels(user.Id)
+	require.NoError(t, err)
+	assert.Equal(t, "gpt-4o", models)
+
+	status, err := GetUserPlanStatus(user.Id)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1000), status.Remaining)
+	assert.Equal(t, "pro", status.Plan.Name)
+
+	// plans in use cannot be deleted
+	assert.Error(t, DeletePlanById(plan.Id))
+
+	require.NoError(t, CancelUserPlan(ctx, user.Id))
+	quota, err = GetUserQuota(user.Id)
+	require.NoError(t, err)
+	assert.Equal(t, int64(50), quota, "the allocation left expires with the plan")
+	status, err = GetUserPlanStatus(user.Id)
+	require.NoError(t, err)
+	assert.Nil(t, status)
+
+	histories, err := GetPlanHistories(user.Id, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, histories, 4)
+	assert.Equal(t, PlanHistoryTypeCancel, histories[0].Type)
+	assert.Equal(t, PlanHistoryTypeExpire, histories[1].Type)
+	assert.Equal(t, int64(-1000), histories[1].Quota)
+	require.NoError(t, DeletePlanById(plan.Id))
+}
+
+func TestRenewUserPlans(t *testing.T) {
+	setupPlanTestDB(t)
+	ctx := context.Background()
+
+	expiring := &Plan{Name: "expiring", Quota: 1000, Period: PlanPeriodDay, Rollover: PlanRolloverExpire, Status: PlanStatusEnabled}
+	carrying := &Plan{Name: "carrying", Quota: 1000, Period: PlanPeriodWeek, Rollover: PlanRolloverCarry, RolloverCap: 500, Status: PlanStatusEnabled}
+	for _, plan := range []*Plan{expiring, carrying} {
+		require.NoError(t, plan.Insert())
+	}
+	users := []*User{
+		{Id: 2311, Username: "expiring", AccessToken: "expiring", AffCode: "expiring"},
+		{Id: 2312, Username: "carrying", AccessToken: "carrying", AffCode: "carrying"},
+	}
+	for i, plan := range []*Plan{expiring, carrying} {
+		require.NoError(t, DB.Create(users[i]).Error)
+		_, err := AssignPlan(ctx, users[i].Id, plan.Id, 0)
+		require.NoError(t, err)
+		// each user consumes 300 of the allocation
+		require.NoError(t, DB.Model(&User{}).Where("id = ?", users[i].Id).
+			Updates(map[string]any{"quota": gorm.Expr("quota - 300"), "used_quota": gorm.Expr("used_quota + 300")}).Error)
+	}
+
+	status, err := GetUserPlanStatus(users[0].Id)
+	require.NoError(t, err)
+	assert.Equal(t, int64(700), status.Remaining)
+
+	// nothing is due before the periods end
+	renewed, err := RenewUserPlans(ctx, status.PeriodEnd-1)
+	require.NoError(t, err)
+	assert.Zero(t, renewed)
+
+	// three days later the daily plan is granted once and the weekly plan is not due yet
+	renewed, err = RenewUserPlans(ctx, status.PeriodStart+3*24*3600)
+	require.NoError(t, err)
+	assert.Equal(t, 1, renewed)
+	quota, err := GetUserQuota(users[0].Id)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1000), quota, "the 700 left expire before 1000 are granted")
+	status, err = GetUserPlanStatus(users[0].Id)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1000), status.Remaining)
+	assert.Equal(t, status.PeriodStart+24*3600, status.PeriodEnd)
+
+	// the weekly plan carries 500 of the 700 left over
+	status, err = GetUserPlanStatus(users[1].Id)
+	require.NoError(t, err)
+	renewed, err = RenewUserPlans(ctx, status.PeriodEnd)
+	require.NoError(t, err)
+	assert.Equal(t, 2, renewed)
+	quota, err = GetUserQuota(users[1].Id)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1500), quota)
+	status, err = GetUserPlanStatus(users[1].Id)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1500), status.Allocation)
+	assert.Equal(t, int64(1500), status.Remaining)
+
+	// subscriptions past their expire time end at the end of the period
+	require.NoError(t, DB.Model(&UserPlan{}).Where("user_id = ?", users[1].Id).Update("expire_time", status.PeriodEnd).Error)
+	_, err = RenewUserPlans(ctx, status.PeriodEnd)
+	require.NoError(t, err)
+	status, err = GetUserPlanStatus(users[1].Id)
+	require.NoError(t, err)
+	assert.Nil(t, status)
+	quota, err = GetUserQuota(users[1].Id)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1500), quota, "plans carrying their allocation over leave it to their users")
+}
@@ -0,0 +1,88 @@
+package model
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+
+	"github.com/Laisky/errors/v2"
+
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+// responseRecordRetentionSeconds follows the retention of stored responses in OpenAI
+const responseRecordRetentionSeconds = 3600 * 24 * 30
+
+// ResponseRecord remembers which channel produced a Response API object,
+// so that follow-up calls referring to the response reach the same upstream.
+type ResponseRecord struct {
+	ResponseId  string `json:"response_id" gorm:"primaryKey;type:varchar(191)"`
+	UserId      int    `json:"user_id" gorm:"index"`
+	TokenId     int    `json:"token_id"`
+	ChannelId   int    `json:"channel_id"`
+	Model       string `json:"model"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+}
+
+// NewResponseRecord create a new ResponseRecord
+func NewResponseRecord(responseId string, userId, tokenId, channelId int, modelName string) *ResponseRecord {
+	return &ResponseRecord{
+		ResponseId:  responseId,
+		UserId:      userId,
+		TokenId:     tokenId,
+		ChannelId:   channelId,
+		Model:       modelName,
+		CreatedTime: helper.GetTimestamp(),
+	}
+}
+
+// Insert saves the record, an existing record of the same response is overwritten
+func (record *ResponseRecord) Insert() error {
+	go removeOldResponseRecords()
+
+	err := DB.Save(record).Error
+	return errors.Wrap(err, "failed to insert ResponseRecord")
+}
+
+// GetResponseRecord get the record of a response by its id
+func GetResponseRecord(responseId string) (*ResponseRecord, error) {
+	if responseId == "" {
+		return nil, errors.New("response id is empty")
+	}
+
+	record := &ResponseRecord{}
+	if err := DB.First(record, "response_id = ?", responseId).Error; err != nil {
+		return nil, errors.Wrapf(err, "failed to get record of response %s", responseId)
+	}
+
+	return record, nil
+}
+
+// DeleteResponseRecord deletes the record of a response
+func DeleteResponseRecord(responseId string) error {
+	err := DB.Delete(&ResponseRecord{}, "response_id = ?", responseId).Error
+	return errors.Wrapf(err, "failed to delete record of response %s", responseId)
+}
+
+var muRemoveOldResponseRecords sync.Mutex
+
+// removeOldResponseRecords removes records of responses that upstream no longer keeps,
+// this function will be executed every 1/1000 times.
+func removeOldResponseRecords() {
+	if rand.Float32() > 0.001 {
+		return
+	}
+
+	if ok := muRemoveOldResponseRecords.TryLock(); !ok {
+		return
+	}
+	defer muRemoveOldResponseRecords.Unlock()
+
+	err := DB.
+		Where("created_time < ?", helper.GetTimestamp()-responseRecordRetentionSeconds).
+		Delete(&ResponseRecord{}).Error
+	if err != nil {
+		logger.SysError(fmt.Sprintf("failed to remove old response records: %s", err.Error()))
+	}
+}
@@ -0,0 +1,39 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestResponseRecord(t *testing.T) {
+	testDB, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, testDB.AutoMigrate(&ResponseRecord{}))
+	originalDB := DB
+	DB = testDB
+	defer func() { DB = originalDB }()
+
+	require.NoError(t, NewResponseRecord("resp_1", 1, 2, 3, "gpt-4o").Insert())
+
+	record, err := GetResponseRecord("resp_1")
+	require.NoError(t, err)
+	assert.Equal(t, 1, record.UserId)
+	assert.Equal(t, 3, record.ChannelId)
+
+	// saving the same response again overwrites the record
+	require.NoError(t, NewResponseRecord("resp_1", 1, 2, 4, "gpt-4o").Insert())
+	record, err = GetResponseRecord("resp_1")
+	require.NoError(t, err)
+	assert.Equal(t, 4, record.ChannelId)
+
+	require.NoError(t, DeleteResponseRecord("resp_1"))
+	_, err = GetResponseRecord("resp_1")
+	require.Error(t, err)
+
+	_, err = GetResponseRecord("")
+	require.Error(t, err)
+}
@@ -0,0 +1,333 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	"gorm.io/gorm"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/message"
+)
+
+// Periods of spend limits, periods start at midnight, on Mondays and on the first day of months in local time
+const (
+	SpendPeriodDay   = "day"
+	SpendPeriodWeek  = "week"
+	SpendPeriodMonth = "month"
+)
+
+// SpendPeriods are all periods of spend limits
+var SpendPeriods = []string{SpendPeriodDay, SpendPeriodWeek, SpendPeriodMonth}
+
+// SpendAlertThresholds are the percentages of spend limits at which the owners of tokens and users are alerted
+var SpendAlertThresholds = []int{50, 80, 100}
+
+// ErrSpendLimitExceeded is returned when a token or a user has spent its limit of a period
+var ErrSpendLimitExceeded = errors.New("spend limit exceeded")
+
+// spendClock tells the time periods of spend are evaluated at, it is replaced by tests
+var spendClock = time.Now
+
+// SpendLimits is the quota allowed to be spent per day, week and month, 0 means unlimited
+type SpendLimits struct {
+	Daily   int64 `json:"daily"`
+	Weekly  int64 `json:"weekly"`
+	Monthly int64 `json:"monthly"`
+}
+
+// Enabled reports whether any limit is set
+func (l SpendLimits) Enabled() bool {
+	return l.Daily > 0 || l.Weekly > 0 || l.Monthly > 0
+}
+
+// Validate checks that the limits are not negative
+func (l SpendLimits) Validate() error {
+	if l.Daily < 0 || l.Weekly < 0 || l.Monthly < 0 {
+		return errors.Errorf("invalid spend limits: daily %d, weekly %d, monthly %d", l.Daily, l.Weekly, l.Monthly)
+	}
+	return nil
+}
+
+// Of returns the limit of period
+func (l SpendLimits) Of(period string) int64 {
+	switch period {
+	case SpendPeriodDay:
+		return l.Daily
+	case SpendPeriodWeek:
+		return l.Weekly
+	case SpendPeriodMonth:
+		return l.Monthly
+	default:
+		return 0
+	}
+}
+
+// Spend is the quota spent by a token or a user in a period
+type Spend struct {
+	Id          int    `json:"-"`
+	Subject     string `json:"-" gorm:"type:varchar(32);uniqueIndex:idx_spend_subject_period"`
+	Period      string `json:"period" gorm:"type:varchar(8);uniqueIndex:idx_spend_subject_period"`
+	PeriodStart int64  `json:"period_start" gorm:"bigint;uniqueIndex:idx_spend_subject_period"`
+	Quota       int64  `json:"quota" gorm:"bigint;default:0"`
+	// AlertedPercent is the highest alert threshold of the period the owner has been alerted of
+	AlertedPercent int `json:"-" gorm:"default:0"`
+}
+
+// PeriodSpend is the spend and the limit of a period
+type PeriodSpend struct {
+	Period      string `json:"period"`
+	PeriodStart int64  `json:"period_start"`
+	Spent       int64  `json:"spent"`
+	// Limit is the limit of the period, 0 means unlimited
+	Limit int64 `json:"limit"`
+}
+
+func tokenSpendSubject(tokenId int) string {
+	return fmt.Sprintf("token:%d", tokenId)
+}
+
+func userSpendSubject(userId int) string {
+	return fmt.Sprintf("user:%d", userId)
+}
+
+// spendPeriodStart returns the start of the period containing t
+func spendPeriodStart(period string, t time.Time) int64 {
+	year, month, day := t.Date()
+	switch period {
+	case SpendPeriodWeek:
+		// weeks start on Mondays
+		day -= (int(t.Weekday()) + 6) % 7
+	case SpendPeriodMonth:
+		day = 1
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location()).Unix()
+}
+
+// getPeriodSpends returns the spend of the subject in the current periods
+func getPeriodSpends(subject string, limits SpendLimits) ([]PeriodSpend, error) {
+	current := spendClock()
+	spends := make([]PeriodSpend, 0, len(SpendPeriods))
+	earliest := current.Unix()
+	for _, period := range SpendPeriods {
+		start := spendPeriodStart(period, current)
+		earliest = min(earliest, start)
+		spends = append(spends, PeriodSpend{Period: period, PeriodStart: start, Limit: limits.Of(period)})
+	}
+
+	var records []*Spend
+	if err := DB.Where("subject = ? AND period_start >= ?", subject, earliest).Find(&records).Error; err != nil {
+		return nil, errors.Wrapf(err, "failed to get spend of %s", subject)
+	}
+	for _, record := range records {
+		for i := range spends {
+			if spends[i].Period == record.Period && spends[i].PeriodStart == record.PeriodStart {
+				spends[i].Spent = record.Quota
+			}
+		}
+	}
+	return spends, nil
+}
+
+// GetTokenPeriodSpends returns the spend of the token in the current periods
+func GetTokenPeriodSpends(token *Token) ([]PeriodSpend, error) {
+	return getPeriodSpends(tokenSpendSubject(token.Id), token.SpendLimits())
+}
+
+// GetUserPeriodSpends returns the spend of the user in the current periods
+func GetUserPeriodSpends(userId int) ([]PeriodSpend, error) {
+	limits, err := GetUserSpendLimits(userId)
+	if err != nil {
+		return nil, err
+	}
+	return getPeriodSpends(userSpendSubject(userId), limits)
+}
+
+// checkSubjectSpend returns an error wrapping ErrSpendLimitExceeded if spending quota more is over the limits
+func checkSubjectSpend(subject string, limits SpendLimits, quota int64) error {
+	if !limits.Enabled() {
+		return nil
+	}
+	spends, err := getPeriodSpends(subject, limits)
+	if err != nil {
+		return err
+	}
+	for _, spend := range spends {
+		if spend.Limit > 0 && (spend.Spent >= spend.Limit || spend.Spent+quota > spend.Limit) {
+			return errors.Wrapf(ErrSpendLimitExceeded, "%s has spent %s of its %s limit %s",
+				subject, common.LogQuota(spend.Spent), spend.Period, common.LogQuota(spend.Limit))
+		}
+	}
+	return nil
+}
+
+// CheckSpendLimits returns an error wrapping ErrSpendLimitExceeded if spending quota more is over the limits
+// of the token or the ones of the user
+func CheckSpendLimits(tokenId int, tokenLimits SpendLimits, userId int, userLimits SpendLimits, quota int64) error {
+	if err := checkSubjectSpend(tokenSpendSubject(tokenId), tokenLimits, quota); err != nil {
+		return err
+	}
+	return checkSubjectSpend(userSpendSubject(userId), userLimits, quota)
+}
+
+// addSpend adds quota to the spend of the subject in period starting at start and returns the record
+func addSpend(subject string, period string, start int64, quota int64) (*Spend, error) {
+	const query = "subject = ? AND period = ? AND period_start = ?"
+	increase := func() *gorm.DB {
+		return DB.Model(&Spend{}).Where(query, subject, period, start).Update("quota", gorm.Expr("quota + ?", quota))
+	}
+	result := increase()
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		if err := DB.Create(&Spend{Subject: subject, Period: period, PeriodStart: start, Quota: quota}).Error; err != nil {
+			// another node has inserted the spend of the period in the meantime
+			if err = increase().Error; err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	record := &Spend{}
+	err := DB.Where(query, subject, period, start).First(record).Error
+	return record, err
+}
+
+// recordSubjectSpend adds quota to the spend of the subject in the current periods,
+// alert is called with the spend of the periods crossing an alert threshold of limits
+func recordSubjectSpend(subject string, limits SpendLimits, quota int64, alert func(spend PeriodSpend, percent int)) error {
+	current := spendClock()
+	for _, period := range SpendPeriods {
+		start := spendPeriodStart(period, current)
+		record, err := addSpend(subject, period, start, quota)
+		if err != nil {
+			return errors.Wrapf(err, "failed to record %s spend of %s", period, subject)
+		}
+
+		limit := limits.Of(period)
+		if limit <= 0 {
+			continue
+		}
+		percent := 0
+		for _, threshold := range SpendAlertThresholds {
+			if record.Quota*100 >= limit*int64(threshold) {
+				percent = threshold
+			}
+		}
+		if percent <= record.AlertedPercent {
+			continue
+		}
+		// only the node raising the alerted threshold alerts the owner
+		result := DB.Model(&Spend{}).Where("id = ? AND alerted_percent < ?", record.Id, percent).
+			Update("alerted_percent", percent)
+		if result.Error != nil {
+			return errors.Wrapf(result.Error, "failed to update alerted threshold of %s", subject)
+		}
+		if result.RowsAffected > 0 {
+			alert(PeriodSpend{Period: period, PeriodStart: start, Spent: record.Quota, Limit: limit}, percent)
+		}
+	}
+	return nil
+}
+
+// spendKey is the token and the user whose spend is batched
+type spendKey struct {
+	userId  int
+	tokenId int
+}
+
+var batchSpendStore = make(map[spendKey]int64)
+var batchSpendLock sync.Mutex
+
+// RecordSpend adds quota spent by a request to the spend of the token and of the user in the current periods,
+// and alerts the user when the spend of a period crosses 50%, 80% or 100% of its limit.
+// The spend is kept whether limits are set or not, so a limit set during a period counts what was spent before,
+// and it is batched when batch update is enabled.
+func RecordSpend(ctx context.Context, userId int, tokenId int, quota int64) {
+	if quota <= 0 {
+		return
+	}
+	if config.BatchUpdateEnabled {
+		batchSpendLock.Lock()
+		batchSpendStore[spendKey{userId: userId, tokenId: tokenId}] += quota
+		batchSpendLock.Unlock()
+		return
+	}
+	recordSpend(ctx, userId, tokenId, quota)
+}
+
+// recordSpend adds quota to the spend of the token and of the user, the limits are only read for the alerts
+func recordSpend(ctx context.Context, userId int, tokenId int, quota int64) {
+	if tokenId > 0 {
+		tokenLimits, err := CacheGetTokenSpendLimits(tokenId)
+		if err != nil {
+			logger.Errorf(ctx, "failed to get spend limits of token %d: %+v", tokenId, err)
+		}
+		err = recordSubjectSpend(tokenSpendSubject(tokenId), tokenLimits, quota, func(spend PeriodSpend, percent int) {
+			go func() {
+				tokenName := fmt.Sprintf("Token %d", tokenId)
+				if token, err := GetTokenById(tokenId); err == nil {
+					tokenName = fmt.Sprintf("Token %s", token.Name)
+				}
+				notifySpendAlert(ctx, userId, tokenName, spend, percent)
+			}()
+		})
+		if err != nil {
+			logger.Errorf(ctx, "%+v", err)
+		}
+	}
+
+	userLimits, err := CacheGetUserSpendLimits(userId)
+	if err != nil {
+		logger.Errorf(ctx, "failed to get spend limits of user %d: %+v", userId, err)
+	}
+	err = recordSubjectSpend(userSpendSubject(userId), userLimits, quota, func(spend PeriodSpend, percent int) {
+		go notifySpendAlert(ctx, userId, "Your account", spend, percent)
+	})
+	if err != nil {
+		logger.Errorf(ctx, "%+v", err)
+	}
+}
+
+// batchUpdateSpends records the spend batched since the last batch update
+func batchUpdateSpends() {
+	batchSpendLock.Lock()
+	store := batchSpendStore
+	batchSpendStore = make(map[spendKey]int64)
+	batchSpendLock.Unlock()
+
+	ctx := context.Background()
+	for key, quota := range store {
+		recordSpend(ctx, key.userId, key.tokenId, quota)
+	}
+}
+
+// notifySpendAlert emails the user that the spend of subject reached percent of its limit.
+// Alerts are not sent through the message pusher, whose target is the one of the administrator.
+func notifySpendAlert(ctx context.Context, userId int, subject string, spend PeriodSpend, percent int) {
+	email, err := GetUserEmail(userId)
+	if err != nil {
+		logger.Errorf(ctx, "failed to fetch email of user %d: %+v", userId, err)
+		return
+	}
+	if email == "" {
+		return
+	}
+
+	title := fmt.Sprintf("Spend Alert: %d%% of %s limit reached", percent, spend.Period)
+	content := fmt.Sprintf("%s has spent %s of its %s limit %s.",
+		subject, common.LogQuota(spend.Spent), spend.Period, common.LogQuota(spend.Limit))
+	if percent >= 100 {
+		content += " Requests are rejected until the period is over or the limit is raised."
+	}
+	content = message.EmailTemplate(title, fmt.Sprintf("<p>Hello!</p><p>%s</p>", content))
+	if err = message.SendEmail(title, email, content); err != nil {
+		logger.Errorf(ctx, "failed to send spend alert to user %d: %+v", userId, err)
+	}
+}
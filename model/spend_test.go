@@ -0,0 +1,131 @@
+package model
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+)
+
+func TestSpendPeriodStart(t *testing.T) {
+	// 2025-01-08 is a Wednesday
+	at := time.Date(2025, 1, 8, 15, 4, 5, 0, time.UTC)
+	assert.Equal(t, time.Date(2025, 1, 8, 0, 0, 0, 0, time.UTC).Unix(), spendPeriodStart(SpendPeriodDay, at))
+	assert.Equal(t, time.Date(2025, 1, 6, 0, 0, 0, 0, time.UTC).Unix(), spendPeriodStart(SpendPeriodWeek, at))
+	assert.Equal(t, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC).Unix(), spendPeriodStart(SpendPeriodMonth, at))
+
+	// weeks started on Monday of the previous month
+	sunday := time.Date(2025, 2, 2, 23, 0, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2025, 1, 27, 0, 0, 0, 0, time.UTC).Unix(), spendPeriodStart(SpendPeriodWeek, sunday))
+}
+
+func TestSpendLimits(t *testing.T) {
+	testDB, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, testDB.AutoMigrate(&User{}, &Token{}, &Spend{}))
+	originalDB, originalRedisEnabled, originalClock := DB, common.RedisEnabled, spendClock
+	DB, common.RedisEnabled = testDB, false
+	current := time.Date(2025, 1, 8, 12, 0, 0, 0, time.Local)
+	spendClock = func() time.Time { return current }
+	defer func() { DB, common.RedisEnabled, spendClock = originalDB, originalRedisEnabled, originalClock }()
+
+	user := &User{Id: 2401, Username: "spend", AccessToken: "spend", AffCode: "spend", MonthlySpendLimit: 1000}
+	require.NoError(t, testDB.Create(user).Error)
+	token := &Token{Id: 2401, UserId: user.Id, Key: "spend", DailySpendLimit: 100}
+	require.NoError(t, testDB.Create(token).Error)
+
+	userLimits, err := GetUserSpendLimits(user.Id)
+	require.NoError(t, err)
+	assert.Equal(t, SpendLimits{Monthly: 1000}, userLimits)
+
+	var alerts []int
+	alert := func(spend PeriodSpend, percent int) {
+		assert.Equal(t, SpendPeriodDay, spend.Period)
+		alerts = append(alerts, percent)
+	}
+	subject := tokenSpendSubject(token.Id)
+	for _, quota := range []int64{40, 20, 5, 30, 10} {
+		require.NoError(t, recordSubjectSpend(subject, token.SpendLimits(), quota, alert))
+	}
+	assert.Equal(t, []int{50, 80, 100}, alerts, "every threshold is alerted once")
+
+	err = CheckSpendLimits(token.Id, token.SpendLimits(), user.Id, userLimits, 1)
+	assert.True(t, errors.Is(err, ErrSpendLimitExceeded))
+
+	// the next day starts a new daily period, the month goes on
+	current = current.AddDate(0, 0, 1)
+	assert.NoError(t, CheckSpendLimits(token.Id, token.SpendLimits(), user.Id, userLimits, 100))
+	assert.Error(t, CheckSpendLimits(token.Id, token.SpendLimits(), user.Id, userLimits, 101),
+		"requests estimated to go over the limit are rejected")
+
+	require.NoError(t, recordSubjectSpend(userSpendSubject(user.Id), userLimits, 900, func(PeriodSpend, int) {}))
+	spends, err := GetUserPeriodSpends(user.Id)
+	require.NoError(t, err)
+	require.Len(t, spends, 3)
+	assert.Equal(t, PeriodSpend{Period: SpendPeriodMonth, PeriodStart: spendPeriodStart(SpendPeriodMonth, current), Spent: 900, Limit: 1000}, spends[2])
+	assert.Zero(t, spends[0].Limit)
+	assert.NoError(t, CheckSpendLimits(0, SpendLimits{}, user.Id, userLimits, 100))
+	assert.Error(t, CheckSpendLimits(0, SpendLimits{}, user.Id, userLimits, 101))
+
+	tokenSpends, err := GetTokenPeriodSpends(token)
+	require.NoError(t, err)
+	assert.Zero(t, tokenSpends[0].Spent)
+	assert.Equal(t, int64(105), tokenSpends[1].Spent)
+}
+
+func TestRecordSpend(t *testing.T) {
+	testDB, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, testDB.AutoMigrate(&User{}, &Token{}, &Spend{}))
+	originalDB, originalRedisEnabled, originalBatchUpdateEnabled := DB, common.RedisEnabled, config.BatchUpdateEnabled
+	DB, common.RedisEnabled, config.BatchUpdateEnabled = testDB, false, false
+	defer func() {
+		DB, common.RedisEnabled, config.BatchUpdateEnabled = originalDB, originalRedisEnabled, originalBatchUpdateEnabled
+	}()
+
+	user := &User{Id: 2402, Username: "record-spend", AccessToken: "record-spend", AffCode: "record-spend"}
+	require.NoError(t, testDB.Create(user).Error)
+	token := &Token{Id: 2402, UserId: user.Id, Key: "record-spend", WeeklySpendLimit: 1000}
+	require.NoError(t, testDB.Create(token).Error)
+	unlimited := &Token{Id: 2403, UserId: user.Id, Key: "record-spend-unlimited"}
+	require.NoError(t, testDB.Create(unlimited).Error)
+
+	ctx := context.Background()
+	RecordSpend(ctx, user.Id, unlimited.Id, 100)
+	var count int64
+	require.NoError(t, testDB.Model(&Spend{}).Count(&count).Error)
+	assert.Equal(t, int64(2*len(SpendPeriods)), count, "spend without limits is kept for the token and the user")
+
+	// a limit set during a period counts the spend before it
+	unlimited.WeeklySpendLimit = 1000
+	spends, err := GetTokenPeriodSpends(unlimited)
+	require.NoError(t, err)
+	assert.Equal(t, PeriodSpend{Period: SpendPeriodWeek, PeriodStart: spends[1].PeriodStart, Spent: 100, Limit: 1000}, spends[1])
+
+	RecordSpend(ctx, user.Id, token.Id, 100)
+	require.NoError(t, testDB.Model(&Spend{}).Count(&count).Error)
+	assert.Equal(t, int64(3*len(SpendPeriods)), count)
+	userSpends, err := GetUserPeriodSpends(user.Id)
+	require.NoError(t, err)
+	assert.Equal(t, int64(200), userSpends[1].Spent)
+
+	config.BatchUpdateEnabled = true
+	RecordSpend(ctx, user.Id, token.Id, 100)
+	RecordSpend(ctx, user.Id, token.Id, 50)
+	spends, err = GetTokenPeriodSpends(token)
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), spends[1].Spent, "batched spend waits for the batch update")
+
+	batchUpdateSpends()
+	spends, err = GetTokenPeriodSpends(token)
+	require.NoError(t, err)
+	assert.Equal(t, int64(250), spends[1].Spent)
+}
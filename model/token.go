@@ -1,10 +1,12 @@
 package model
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/Laisky/errors/v2"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"github.com/songquanpeng/one-api/common"
 	"github.com/songquanpeng/one-api/common/config"
@@ -34,6 +36,43 @@ type Token struct {
 	UsedQuota      int64   `json:"used_quota" gorm:"bigint;default:0"` // used quota
 	Models         *string `json:"models" gorm:"type:text"`            // allowed models
 	Subnet         *string `json:"subnet" gorm:"default:''"`           // allowed subnet
+	// ResponseCacheMode overrides the response cache mode of the group, empty means following the group
+	ResponseCacheMode string `json:"response_cache_mode" gorm:"type:varchar(32);default:''"`
+	// ModelFallbacks is the JSON of the model fallback chains, which override the ones of the group
+	ModelFallbacks *string `json:"model_fallbacks" gorm:"type:text"`
+	// HedgeDelayMs is how long to wait for the first byte before hedging the request on another channel, 0 disables hedging
+	HedgeDelayMs int `json:"hedge_delay_ms" gorm:"default:0"`
+	// RPMLimit and TPMLimit are the requests and tokens allowed per minute, 0 means unlimited
+	RPMLimit int64 `json:"rpm_limit" gorm:"default:0"`
+	TPMLimit int64 `json:"tpm_limit" gorm:"default:0"`
+	// DailySpendLimit, WeeklySpendLimit and MonthlySpendLimit are the quota allowed to be spent per period, 0 means unlimited
+	DailySpendLimit   int64 `json:"daily_spend_limit" gorm:"bigint;default:0"`
+	WeeklySpendLimit  int64 `json:"weekly_spend_limit" gorm:"bigint;default:0"`
+	MonthlySpendLimit int64 `json:"monthly_spend_limit" gorm:"bigint;default:0"`
+}
+
+// SpendLimits returns the spend limits of the token
+func (t *Token) SpendLimits() SpendLimits {
+	return SpendLimits{Daily: t.DailySpendLimit, Weekly: t.WeeklySpendLimit, Monthly: t.MonthlySpendLimit}
+}
+
+// GetTokenSpendLimits returns the quota allowed to be spent per period by the token
+func GetTokenSpendLimits(id int) (limits SpendLimits, err error) {
+	token := &Token{}
+	if err = DB.Model(&Token{}).Where("id = ?", id).
+		Select("daily_spend_limit", "weekly_spend_limit", "monthly_spend_limit").Take(token).Error; err != nil {
+		return limits, errors.Wrap(err, "failed to get spend limits of token")
+	}
+	return token.SpendLimits(), nil
+}
+
+// clearTokenSpendLimitsCache clears the cached spend limits of the token, which is keyed by the token id
+func clearTokenSpendLimitsCache(id int) {
+	if common.RedisEnabled {
+		if err := common.RedisDel(fmt.Sprintf("token_spend_limits:%d", id)); err != nil {
+			logger.SysError("failed to clear token spend limits cache: " + err.Error())
+		}
+	}
 }
 
 func clearTokenCache(key string) {
@@ -145,19 +184,39 @@ func GetTokenById(id int) (*Token, error) {
 
 func (t *Token) Insert() error {
 	var err error
-	err = DB.Create(t).Error
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(t).Error; err != nil {
+			return err
+		}
+		return recordLedgerEntries(tx, tokenLedgerEntry(context.Background(), t.Id, t.RemainQuota, LedgerReasonOpening))
+	})
 	if err == nil {
 		clearTokenCache(t.Key)
 	}
 	return err
 }
 
-// Update Make sure your token's fields is completed, because this will update non-zero values
-func (t *Token) Update() error {
-	var err error
-	err = DB.Model(t).Select("name", "status", "expired_time", "remain_quota", "unlimited_quota", "models", "subnet").Updates(t).Error
+// Update Make sure your token's fields is completed, because this will update non-zero values.
+// The change of the remaining quota is recorded in the ledger in the same transaction.
+func (t *Token) Update(ctx context.Context) error {
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var remainQuota int64
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Model(&Token{}).Select("remain_quota").
+			Where("id = ?", t.Id).Scan(&remainQuota).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(t).Select("name", "status", "expired_time", "remain_quota", "unlimited_quota", "models", "subnet", "response_cache_mode", "model_fallbacks", "hedge_delay_ms", "rpm_limit", "tpm_limit",
+			"daily_spend_limit", "weekly_spend_limit", "monthly_spend_limit").Updates(t).Error; err != nil {
+			return err
+		}
+		if delta := t.RemainQuota - remainQuota; delta != 0 {
+			return recordLedgerEntries(tx, tokenLedgerEntry(ctx, t.Id, delta, LedgerReasonAdjust))
+		}
+		return nil
+	})
 	if err == nil {
 		clearTokenCache(t.Key)
+		clearTokenSpendLimitsCache(t.Id)
 	}
 	return err
 }
@@ -205,73 +264,68 @@ func DeleteTokenById(id int, userId int) (err error) {
 	return token.Delete()
 }
 
-func IncreaseTokenQuota(id int, quota int64) (err error) {
+func IncreaseTokenQuota(ctx context.Context, id int, quota int64, reason string) (err error) {
 	if quota < 0 {
 		return errors.New("quota cannot be negative!")
 	}
-	if config.BatchUpdateEnabled {
-		addNewRecord(BatchUpdateTypeTokenQuota, id, quota)
-		return nil
+	return changeTokenQuota(ctx, id, quota, reason)
+}
+
+func DecreaseTokenQuota(ctx context.Context, id int, quota int64, reason string) (err error) {
+	if quota < 0 {
+		return errors.New("quota cannot be negative!")
 	}
-	return increaseTokenQuota(id, quota)
+	return changeTokenQuota(ctx, id, -quota, reason)
 }
 
-func increaseTokenQuota(id int, quota int64) (err error) {
-	err = DB.Model(&Token{}).Where("id = ?", id).Updates(
-		map[string]interface{}{
-			"remain_quota":  gorm.Expr("remain_quota + ?", quota),
-			"used_quota":    gorm.Expr("used_quota - ?", quota),
-			"accessed_time": helper.GetTimestamp(),
-		},
-	).Error
-	if err == nil {
-		// We need the token key to clear the cache.
-		// Fetch the token first.
-		// This might be inefficient if BatchUpdateEnabled is false and this is called frequently.
-		// Consider if this function needs to clear cache directly or rely on eventual consistency.
-		// For now, let's fetch and clear.
-		token, fetchErr := GetTokenById(id)
-		if fetchErr == nil && token != nil {
-			clearTokenCache(token.Key)
-		} else if fetchErr != nil {
-			logger.SysError(fmt.Sprintf("failed to fetch token %d for cache clearing after quota increase: %s", id, fetchErr.Error()))
+// changeTokenQuota adds delta to the remaining quota of the token and records the movement in the ledger
+func changeTokenQuota(ctx context.Context, id int, delta int64, reason string) error {
+	entry := tokenLedgerEntry(ctx, id, delta, reason)
+	if config.BatchUpdateEnabled {
+		addNewRecord(BatchUpdateTypeTokenQuota, id, delta)
+		return recordLedgerEntries(DB, entry)
+	}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&Token{}).Where("id = ?", id).Updates(tokenQuotaUpdates(delta)).Error; err != nil {
+			return err
 		}
+		return recordLedgerEntries(tx, entry)
+	})
+	if err != nil {
+		return err
 	}
-	return err
+	clearTokenCacheById(id)
+	return nil
 }
 
-func DecreaseTokenQuota(id int, quota int64) (err error) {
-	if quota < 0 {
-		return errors.New("quota cannot be negative!")
-	}
-	if config.BatchUpdateEnabled {
-		addNewRecord(BatchUpdateTypeTokenQuota, id, -quota)
-		return nil
+// tokenQuotaUpdates returns the columns to update for adding quota to the remaining quota of a token
+func tokenQuotaUpdates(quota int64) map[string]interface{} {
+	return map[string]interface{}{
+		"remain_quota":  gorm.Expr("remain_quota + ?", quota),
+		"used_quota":    gorm.Expr("used_quota - ?", quota),
+		"accessed_time": helper.GetTimestamp(),
 	}
-	return decreaseTokenQuota(id, quota)
 }
 
-func decreaseTokenQuota(id int, quota int64) (err error) {
-	err = DB.Model(&Token{}).Where("id = ?", id).Updates(
-		map[string]interface{}{
-			"remain_quota":  gorm.Expr("remain_quota - ?", quota),
-			"used_quota":    gorm.Expr("used_quota + ?", quota),
-			"accessed_time": helper.GetTimestamp(),
-		},
-	).Error
+func increaseTokenQuota(id int, quota int64) (err error) {
+	err = DB.Model(&Token{}).Where("id = ?", id).Updates(tokenQuotaUpdates(quota)).Error
 	if err == nil {
-		// Similar to increaseTokenQuota, fetch the token to get its key for cache clearing.
-		token, fetchErr := GetTokenById(id)
-		if fetchErr == nil && token != nil {
-			clearTokenCache(token.Key)
-		} else if fetchErr != nil {
-			logger.SysError(fmt.Sprintf("failed to fetch token %d for cache clearing after quota decrease: %s", id, fetchErr.Error()))
-		}
+		clearTokenCacheById(id)
 	}
 	return err
 }
 
-func PreConsumeTokenQuota(tokenId int, quota int64) (err error) {
+// clearTokenCacheById clears the cache of the token, which is keyed by the token key
+func clearTokenCacheById(id int) {
+	token, err := GetTokenById(id)
+	if err != nil {
+		logger.SysError(fmt.Sprintf("failed to fetch token %d for cache clearing after quota change: %s", id, err.Error()))
+		return
+	}
+	clearTokenCache(token.Key)
+}
+
+func PreConsumeTokenQuota(ctx context.Context, tokenId int, quota int64) (err error) {
 	if quota < 0 {
 		return errors.New("quota cannot be negative!")
 	}
@@ -327,30 +381,30 @@ func PreConsumeTokenQuota(tokenId int, quota int64) (err error) {
 		}()
 	}
 	if !token.UnlimitedQuota {
-		err = DecreaseTokenQuota(tokenId, quota)
+		err = DecreaseTokenQuota(ctx, tokenId, quota, LedgerReasonPreConsume)
 		if err != nil {
 			return err
 		}
 	}
-	err = DecreaseUserQuota(token.UserId, quota)
+	err = DecreaseUserQuota(ctx, token.UserId, quota, LedgerReasonPreConsume)
 	return err
 }
 
-func PostConsumeTokenQuota(tokenId int, quota int64) (err error) {
+func PostConsumeTokenQuota(ctx context.Context, tokenId int, quota int64) (err error) {
 	token, err := GetTokenById(tokenId)
 	if err != nil {
 		return err
 	}
 	if quota > 0 {
-		err = DecreaseUserQuota(token.UserId, quota)
+		err = DecreaseUserQuota(ctx, token.UserId, quota, LedgerReasonConsume)
 	} else {
-		err = IncreaseUserQuota(token.UserId, -quota)
+		err = IncreaseUserQuota(ctx, token.UserId, -quota, LedgerReasonRefund)
 	}
 	if !token.UnlimitedQuota {
 		if quota > 0 {
-			err = DecreaseTokenQuota(tokenId, quota)
+			err = DecreaseTokenQuota(ctx, tokenId, quota, LedgerReasonConsume)
 		} else {
-			err = IncreaseTokenQuota(tokenId, -quota)
+			err = IncreaseTokenQuota(ctx, tokenId, -quota, LedgerReasonRefund)
 		}
 		if err != nil {
 			return err
@@ -7,6 +7,7 @@ import (
 
 	"github.com/Laisky/errors/v2"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"github.com/songquanpeng/one-api/common"
 	"github.com/songquanpeng/one-api/common/blacklist"
@@ -52,6 +53,19 @@ type User struct {
 	Group            string `json:"group" gorm:"type:varchar(32);default:'default'"`
 	AffCode          string `json:"aff_code" gorm:"type:varchar(32);column:aff_code;uniqueIndex"`
 	InviterId        int    `json:"inviter_id" gorm:"type:int;column:inviter_id;index"`
+	// RPMLimit and TPMLimit are the requests and tokens allowed per minute to all tokens of the user, 0 means unlimited
+	RPMLimit int64 `json:"rpm_limit" gorm:"default:0"`
+	TPMLimit int64 `json:"tpm_limit" gorm:"default:0"`
+	// DailySpendLimit, WeeklySpendLimit and MonthlySpendLimit are the quota allowed to be spent per period
+	// by all tokens of the user, 0 means unlimited
+	DailySpendLimit   int64 `json:"daily_spend_limit" gorm:"bigint;default:0"`
+	WeeklySpendLimit  int64 `json:"weekly_spend_limit" gorm:"bigint;default:0"`
+	MonthlySpendLimit int64 `json:"monthly_spend_limit" gorm:"bigint;default:0"`
+}
+
+// SpendLimits returns the spend limits of the user
+func (user *User) SpendLimits() SpendLimits {
+	return SpendLimits{Daily: user.DailySpendLimit, Weekly: user.WeeklySpendLimit, Monthly: user.MonthlySpendLimit}
 }
 
 func GetMaxUserId() int {
@@ -133,16 +147,19 @@ func (user *User) Insert(ctx context.Context, inviterId int) error {
 	if result.Error != nil {
 		return result.Error
 	}
+	if err = recordLedgerEntries(DB, userLedgerEntry(ctx, user.Id, user.Quota, LedgerReasonSignup)); err != nil {
+		logger.Errorf(ctx, "%+v", err)
+	}
 	if config.QuotaForNewUser > 0 {
 		RecordLog(ctx, user.Id, LogTypeSystem, fmt.Sprintf("New user registration gift %s", common.LogQuota(config.QuotaForNewUser)))
 	}
 	if inviterId != 0 {
 		if config.QuotaForInvitee > 0 {
-			_ = IncreaseUserQuota(user.Id, config.QuotaForInvitee)
+			_ = IncreaseUserQuota(ctx, user.Id, config.QuotaForInvitee, LedgerReasonInvite)
 			RecordLog(ctx, user.Id, LogTypeSystem, fmt.Sprintf("Gifted %s for using invitation code", common.LogQuota(config.QuotaForInvitee)))
 		}
 		if config.QuotaForInviter > 0 {
-			_ = IncreaseUserQuota(inviterId, config.QuotaForInviter)
+			_ = IncreaseUserQuota(ctx, inviterId, config.QuotaForInviter, LedgerReasonInvite)
 			RecordLog(ctx, inviterId, LogTypeSystem, fmt.Sprintf("Gifted %s for inviting user", common.LogQuota(config.QuotaForInviter)))
 		}
 	}
@@ -369,6 +386,51 @@ func GetUserEmail(id int) (email string, err error) {
 	return email, err
 }
 
+// UpdateRateLimits saves the rate limits of the user, which Update skips when they are reset to 0
+func (user *User) UpdateRateLimits() error {
+	if err := DB.Model(user).Select("rpm_limit", "tpm_limit").Updates(user).Error; err != nil {
+		return errors.Wrap(err, "failed to update rate limits of user")
+	}
+	if common.RedisEnabled {
+		if err := common.RedisDel(fmt.Sprintf("user_rate_limits:%d", user.Id)); err != nil {
+			logger.SysError("Redis delete user rate limits error: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// UpdateSpendLimits saves the spend limits of the user, which Update skips when they are reset to 0
+func (user *User) UpdateSpendLimits() error {
+	if err := DB.Model(user).Select("daily_spend_limit", "weekly_spend_limit", "monthly_spend_limit").Updates(user).Error; err != nil {
+		return errors.Wrap(err, "failed to update spend limits of user")
+	}
+	if common.RedisEnabled {
+		if err := common.RedisDel(fmt.Sprintf("user_spend_limits:%d", user.Id)); err != nil {
+			logger.SysError("Redis delete user spend limits error: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// GetUserSpendLimits returns the quota allowed to be spent per period by the user
+func GetUserSpendLimits(id int) (limits SpendLimits, err error) {
+	user := &User{}
+	if err = DB.Model(&User{}).Where("id = ?", id).
+		Select("daily_spend_limit", "weekly_spend_limit", "monthly_spend_limit").Take(user).Error; err != nil {
+		return limits, errors.Wrap(err, "failed to get spend limits of user")
+	}
+	return user.SpendLimits(), nil
+}
+
+// GetUserRateLimits returns the requests and tokens allowed per minute to the user
+func GetUserRateLimits(id int) (rpm int64, tpm int64, err error) {
+	user := &User{}
+	if err = DB.Model(&User{}).Where("id = ?", id).Select("rpm_limit", "tpm_limit").Take(user).Error; err != nil {
+		return 0, 0, errors.Wrap(err, "failed to get rate limits of user")
+	}
+	return user.RPMLimit, user.TPMLimit, nil
+}
+
 func GetUserGroup(id int) (group string, err error) {
 	groupCol := "`group`"
 	if common.UsingPostgreSQL {
@@ -379,15 +441,11 @@ func GetUserGroup(id int) (group string, err error) {
 	return group, err
 }
 
-func IncreaseUserQuota(id int, quota int64) (err error) {
+func IncreaseUserQuota(ctx context.Context, id int, quota int64, reason string) (err error) {
 	if quota < 0 {
 		return errors.New("quota cannot be negative!")
 	}
-	if config.BatchUpdateEnabled {
-		addNewRecord(BatchUpdateTypeUserQuota, id, quota)
-		return nil
-	}
-	return increaseUserQuota(id, quota)
+	return changeUserQuota(ctx, id, quota, reason)
 }
 
 func increaseUserQuota(id int, quota int64) (err error) {
@@ -395,20 +453,46 @@ func increaseUserQuota(id int, quota int64) (err error) {
 	return err
 }
 
-func DecreaseUserQuota(id int, quota int64) (err error) {
+func DecreaseUserQuota(ctx context.Context, id int, quota int64, reason string) (err error) {
 	if quota < 0 {
 		return errors.New("quota cannot be negative!")
 	}
-	if config.BatchUpdateEnabled {
-		addNewRecord(BatchUpdateTypeUserQuota, id, -quota)
-		return nil
-	}
-	return decreaseUserQuota(id, quota)
+	return changeUserQuota(ctx, id, -quota, reason)
 }
 
-func decreaseUserQuota(id int, quota int64) (err error) {
-	err = DB.Model(&User{}).Where("id = ?", id).Update("quota", gorm.Expr("quota - ?", quota)).Error
-	return err
+// SetUserQuota sets the quota of the user, the difference to the quota it had is recorded in the ledger
+// in the same transaction
+func SetUserQuota(ctx context.Context, id int, quota int64) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		var stored int64
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Model(&User{}).Select("quota").
+			Where("id = ?", id).Scan(&stored).Error; err != nil {
+			return errors.Wrap(err, "failed to get quota of user")
+		}
+		if stored == quota {
+			return nil
+		}
+		if err := tx.Model(&User{}).Where("id = ?", id).Update("quota", quota).Error; err != nil {
+			return errors.Wrap(err, "failed to set quota of user")
+		}
+		return recordLedgerEntries(tx, userLedgerEntry(ctx, id, quota-stored, LedgerReasonAdjust))
+	})
+}
+
+// changeUserQuota adds delta to the quota of the user and records the movement in the ledger
+func changeUserQuota(ctx context.Context, id int, delta int64, reason string) error {
+	entry := userLedgerEntry(ctx, id, delta, reason)
+	if config.BatchUpdateEnabled {
+		addNewRecord(BatchUpdateTypeUserQuota, id, delta)
+		return recordLedgerEntries(DB, entry)
+	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&User{}).Where("id = ?", id).Update("quota", gorm.Expr("quota + ?", delta)).Error
+		if err != nil {
+			return err
+		}
+		return recordLedgerEntries(tx, entry)
+	})
 }
 
 func GetRootUserEmail() (email string) {
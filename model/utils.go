@@ -75,5 +75,6 @@ func batchUpdate() {
 			}
 		}
 	}
+	batchUpdateSpends()
 	logger.SysLog("batch update finished")
 }
@@ -44,6 +44,38 @@ func DisableChannel(channelId int, channelName string, reason string) {
 	notifyRootUser(subject, content)
 }
 
+// DisableChannelKey disables the key of a multi-key channel & notify,
+// the channel is disabled once none of its keys is enabled
+func DisableChannelKey(channelId int, channelName string, fingerprint string, reason string) {
+	channel, err := model.GetChannelById(channelId, true)
+	if err != nil {
+		logger.SysError(fmt.Sprintf("failed to get channel #%d: %s", channelId, err.Error()))
+		return
+	}
+	enabledKeys, err := model.UpdateChannelKeyStatus(channel, fingerprint, model.ChannelStatusAutoDisabled, reason)
+	if err != nil {
+		logger.SysError(fmt.Sprintf("failed to disable key %s of channel #%d: %s", fingerprint, channelId, err.Error()))
+		return
+	}
+	logger.SysLog(fmt.Sprintf("key %s of channel #%d has been disabled: %s", fingerprint, channelId, reason))
+	if enabledKeys == 0 {
+		DisableChannel(channelId, channelName, "all keys have been disabled, the last one for: "+reason)
+		return
+	}
+
+	subject := "Channel Key Status Change Reminder"
+	content := message.EmailTemplate(
+		subject,
+		fmt.Sprintf(`
+            <p>Hello!</p>
+            <p>Key <strong>%s</strong> of channel “<strong>%s</strong>” (#%d) has been disabled, %d keys remain enabled.</p>
+            <p>Reason for disabling:</p>
+            <p style="background-color: #f8f8f8; padding: 10px; border-radius: 4px;">%s</p>
+        `, fingerprint, channelName, channelId, enabledKeys, reason),
+	)
+	notifyRootUser(subject, content)
+}
+
 func MetricDisableChannel(channelId int, successRate float64) {
 	model.UpdateChannelStatusById(channelId, model.ChannelStatusAutoDisabled)
 	logger.SysLog(fmt.Sprintf("channel #%d has been disabled due to low success rate: %.2f", channelId, successRate*100))
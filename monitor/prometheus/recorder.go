@@ -190,6 +190,17 @@ var (
 		Help:    "Model response latency in seconds",
 		Buckets: []float64{.1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120},
 	}, []string{"model_name", "channel_type"})
+
+	// Circuit breaker metrics
+	circuitBreakerState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "one_api_circuit_breaker_state",
+		Help: "Circuit breaker state of a channel serving a model (0=closed, 1=half_open, 2=open)",
+	}, []string{"channel_id", "model"})
+
+	circuitBreakerTrips = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "one_api_circuit_breaker_trips_total",
+		Help: "Total number of times the circuit breaker of a channel serving a model opened",
+	}, []string{"channel_id", "model"})
 )
 
 // RecordHTTPRequest records HTTP request metrics
@@ -325,6 +336,23 @@ func (p *PrometheusRecorder) RecordModelUsage(modelName, channelType string, lat
 	modelLatency.WithLabelValues(modelName, channelType).Observe(latency.Seconds())
 }
 
+// UpdateCircuitBreakerState updates the circuit breaker state of a channel serving a model
+func (p *PrometheusRecorder) UpdateCircuitBreakerState(channelId int, model, state string) {
+	var stateValue float64
+	switch state {
+	case "half_open":
+		stateValue = 1
+	case "open":
+		stateValue = 2
+	}
+	circuitBreakerState.WithLabelValues(strconv.Itoa(channelId), model).Set(stateValue)
+}
+
+// RecordCircuitBreakerTrip records that the circuit breaker of a channel serving a model opened
+func (p *PrometheusRecorder) RecordCircuitBreakerTrip(channelId int, model string) {
+	circuitBreakerTrips.WithLabelValues(strconv.Itoa(channelId), model).Inc()
+}
+
 // InitSystemMetrics initializes system-wide metrics
 func (p *PrometheusRecorder) InitSystemMetrics(version, buildTime, goVersion string, startTime time.Time) {
 	systemInfo.WithLabelValues(version, buildTime, goVersion).Set(1)
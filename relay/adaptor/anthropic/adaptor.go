@@ -12,6 +12,7 @@ import (
 	"github.com/songquanpeng/one-api/relay/adaptor"
 	"github.com/songquanpeng/one-api/relay/meta"
 	"github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/relaymode"
 )
 
 type Adaptor struct {
@@ -54,6 +55,13 @@ func (a *Adaptor) SetupRequestHeader(c *gin.Context, req *http.Request, meta *me
 		req.Header.Set("anthropic-beta", strings.Join(betaHeaders, ","))
 	}
 
+	// native clients know which beta features they rely on
+	if meta.Mode == relaymode.ClaudeMessages {
+		if clientBeta := c.Request.Header.Get("anthropic-beta"); clientBeta != "" {
+			req.Header.Set("anthropic-beta", clientBeta)
+		}
+	}
+
 	return nil
 }
 
@@ -78,6 +86,15 @@ func (a *Adaptor) DoRequest(c *gin.Context, meta *meta.Meta, requestBody io.Read
 }
 
 func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, meta *meta.Meta) (usage *model.Usage, err *model.ErrorWithStatusCode) {
+	if meta.Mode == relaymode.ClaudeMessages {
+		if meta.IsStream {
+			err, usage = MessagesStreamHandler(c, resp)
+		} else {
+			err, usage = MessagesHandler(c, resp)
+		}
+		return
+	}
+
 	if meta.IsStream {
 		err, usage = StreamHandler(c, resp)
 	} else {
@@ -5,6 +5,9 @@ import (
 	"github.com/songquanpeng/one-api/relay/billing/ratio"
 )
 
+// SonnetLongContextTiers is the long context pricing of Claude Sonnet 4, for prompts over 200K tokens
+var SonnetLongContextTiers = []adaptor.PriceTier{{InputTokenThreshold: 200000, Ratio: 6 * ratio.MilliTokensUsd, CompletionRatio: 3.75}}
+
 // ModelRatios contains all supported models and their pricing ratios
 // Model list is derived from the keys of this map, eliminating redundancy
 //
@@ -18,22 +21,22 @@ var ModelRatios = map[string]adaptor.ModelConfig{
 	"claude-2.1": {Ratio: 8 * ratio.MilliTokensUsd, CompletionRatio: 3.0},
 
 	// Claude 3 Haiku Models
-	"claude-3-haiku-20240307":   {Ratio: 0.25 * ratio.MilliTokensUsd, CompletionRatio: 5.0},
-	"claude-3-5-haiku-latest":   {Ratio: 0.8 * ratio.MilliTokensUsd, CompletionRatio: 5.0},
-	"claude-3-5-haiku-20241022": {Ratio: 0.8 * ratio.MilliTokensUsd, CompletionRatio: 5.0},
+	"claude-3-haiku-20240307":   {Ratio: 0.25 * ratio.MilliTokensUsd, CompletionRatio: 5.0, CachedInputRatio: 0.1, CacheWriteRatio: 1.25},
+	"claude-3-5-haiku-latest":   {Ratio: 0.8 * ratio.MilliTokensUsd, CompletionRatio: 5.0, CachedInputRatio: 0.1, CacheWriteRatio: 1.25},
+	"claude-3-5-haiku-20241022": {Ratio: 0.8 * ratio.MilliTokensUsd, CompletionRatio: 5.0, CachedInputRatio: 0.1, CacheWriteRatio: 1.25},
 
 	// Claude 3 Sonnet Models
-	"claude-3-sonnet-20240229":   {Ratio: 3 * ratio.MilliTokensUsd, CompletionRatio: 5.0},
-	"claude-3-5-sonnet-latest":   {Ratio: 3 * ratio.MilliTokensUsd, CompletionRatio: 5.0},
-	"claude-3-5-sonnet-20240620": {Ratio: 3 * ratio.MilliTokensUsd, CompletionRatio: 5.0},
-	"claude-3-5-sonnet-20241022": {Ratio: 3 * ratio.MilliTokensUsd, CompletionRatio: 5.0},
-	"claude-3-7-sonnet-latest":   {Ratio: 15 * ratio.MilliTokensUsd, CompletionRatio: 5.0},
-	"claude-3-7-sonnet-20250219": {Ratio: 15 * ratio.MilliTokensUsd, CompletionRatio: 5.0},
+	"claude-3-sonnet-20240229":   {Ratio: 3 * ratio.MilliTokensUsd, CompletionRatio: 5.0, CachedInputRatio: 0.1, CacheWriteRatio: 1.25},
+	"claude-3-5-sonnet-latest":   {Ratio: 3 * ratio.MilliTokensUsd, CompletionRatio: 5.0, CachedInputRatio: 0.1, CacheWriteRatio: 1.25},
+	"claude-3-5-sonnet-20240620": {Ratio: 3 * ratio.MilliTokensUsd, CompletionRatio: 5.0, CachedInputRatio: 0.1, CacheWriteRatio: 1.25},
+	"claude-3-5-sonnet-20241022": {Ratio: 3 * ratio.MilliTokensUsd, CompletionRatio: 5.0, CachedInputRatio: 0.1, CacheWriteRatio: 1.25},
+	"claude-3-7-sonnet-latest":   {Ratio: 15 * ratio.MilliTokensUsd, CompletionRatio: 5.0, CachedInputRatio: 0.1, CacheWriteRatio: 1.25},
+	"claude-3-7-sonnet-20250219": {Ratio: 15 * ratio.MilliTokensUsd, CompletionRatio: 5.0, CachedInputRatio: 0.1, CacheWriteRatio: 1.25},
 
 	// Claude 3 Opus Models
-	"claude-3-opus-20240229": {Ratio: 15 * ratio.MilliTokensUsd, CompletionRatio: 5.0},
-	"claude-opus-4-20250514": {Ratio: 15 * ratio.MilliTokensUsd, CompletionRatio: 5.0},
+	"claude-3-opus-20240229": {Ratio: 15 * ratio.MilliTokensUsd, CompletionRatio: 5.0, CachedInputRatio: 0.1, CacheWriteRatio: 1.25},
+	"claude-opus-4-20250514": {Ratio: 15 * ratio.MilliTokensUsd, CompletionRatio: 5.0, CachedInputRatio: 0.1, CacheWriteRatio: 1.25},
 
 	// Claude 4 Sonnet Models
-	"claude-sonnet-4-20250514": {Ratio: 3 * ratio.MilliTokensUsd, CompletionRatio: 5.0},
+	"claude-sonnet-4-20250514": {Ratio: 3 * ratio.MilliTokensUsd, CompletionRatio: 5.0, CachedInputRatio: 0.1, CacheWriteRatio: 1.25, Tiers: SonnetLongContextTiers},
 }
@@ -281,6 +281,26 @@ func ConvertRequest(c *gin.Context, textRequest model.GeneralOpenAIRequest) (*Re
 				content.Source.MediaType = mimeType
 				content.Source.Data = data
 				contents = append(contents, content)
+			} else if part.Type == model.ContentTypeFile && part.File != nil {
+				// https://docs.anthropic.com/en/docs/build-with-claude/pdf-support
+				mimeType, data, err := part.File.ParseFileData()
+				if err != nil {
+					return nil, errors.Wrap(err, "only files with file_data are supported")
+				}
+				switch {
+				case mimeType == "application/pdf":
+					content.Type = "document"
+				case strings.HasPrefix(mimeType, "image/"):
+					content.Type = "image"
+				default:
+					return nil, errors.Errorf("unsupported file type %s", mimeType)
+				}
+				content.Source = &ImageSource{
+					Type:      "base64",
+					MediaType: mimeType,
+					Data:      data,
+				}
+				contents = append(contents, content)
 			}
 		}
 
@@ -614,6 +634,7 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 	})
 	common.SetEventStreamHeaders(c)
 
+	var claudeUsage Usage
 	var usage model.Usage
 	var modelName string
 	var id string
@@ -638,8 +659,11 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 
 		response, meta := StreamResponseClaude2OpenAI(c, &claudeResponse)
 		if meta != nil {
-			usage.PromptTokens += meta.Usage.InputTokens
-			usage.CompletionTokens += meta.Usage.OutputTokens
+			claudeUsage.InputTokens += meta.Usage.InputTokens
+			claudeUsage.OutputTokens += meta.Usage.OutputTokens
+			claudeUsage.CacheCreationInputTokens += meta.Usage.CacheCreationInputTokens
+			claudeUsage.CacheReadInputTokens += meta.Usage.CacheReadInputTokens
+			usage = claudeUsage.ToOpenAIUsage()
 			if len(meta.Id) > 0 { // only message_start has an id, otherwise it's a finish_reason event.
 				modelName = meta.Model
 				id = fmt.Sprintf("chatcmpl-%s", meta.Id)
@@ -655,7 +679,6 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 				}
 				// Add usage information to the final chunk
 				if usage.PromptTokens > 0 || usage.CompletionTokens > 0 {
-					usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
 					if response != nil {
 						response.Usage = &usage
 					}
@@ -724,11 +747,7 @@ func Handler(c *gin.Context, resp *http.Response, promptTokens int, modelName st
 	}
 	fullTextResponse := ResponseClaude2OpenAI(c, &claudeResponse)
 	fullTextResponse.Model = modelName
-	usage := model.Usage{
-		PromptTokens:     claudeResponse.Usage.InputTokens,
-		CompletionTokens: claudeResponse.Usage.OutputTokens,
-		TotalTokens:      claudeResponse.Usage.InputTokens + claudeResponse.Usage.OutputTokens,
-	}
+	usage := claudeResponse.Usage.ToOpenAIUsage()
 	fullTextResponse.Usage = usage
 	jsonResponse, err := json.Marshal(fullTextResponse)
 	if err != nil {
@@ -918,3 +918,51 @@ func TestConvertRequest_BugScenario_FullWorkflow(t *testing.T) {
 		}
 	}
 }
+
+func TestConvertRequest_FileContent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+
+	openaiRequest := model.GeneralOpenAIRequest{
+		Model:     "claude-sonnet-4",
+		MaxTokens: 1024,
+		Messages: []model.Message{{
+			Role: "user",
+			Content: []model.MessageContent{
+				{Type: model.ContentTypeFile, File: &model.MessageFile{FileData: "data:application/pdf;base64,cGRm", Filename: "a.pdf"}},
+			},
+		}},
+	}
+
+	claudeRequest, err := ConvertRequest(c, openaiRequest)
+	require.NoError(t, err)
+	require.Len(t, claudeRequest.Messages[0].Content, 1)
+	document := claudeRequest.Messages[0].Content[0]
+	assert.Equal(t, "document", document.Type)
+	assert.Equal(t, "application/pdf", document.Source.MediaType)
+	assert.Equal(t, "cGRm", document.Source.Data)
+
+	// files referred by id are unknown to anthropic
+	openaiRequest.Messages[0].Content = []model.MessageContent{
+		{Type: model.ContentTypeFile, File: &model.MessageFile{FileId: "file-abc"}},
+	}
+	_, err = ConvertRequest(c, openaiRequest)
+	require.Error(t, err)
+}
+
+func TestUsage_ToOpenAIUsage(t *testing.T) {
+	var claudeUsage Usage
+	require.NoError(t, json.Unmarshal([]byte(`{"input_tokens":20,"output_tokens":10,"cache_creation_input_tokens":30,"cache_read_input_tokens":50}`), &claudeUsage))
+
+	usage := claudeUsage.ToOpenAIUsage()
+	assert.Equal(t, 100, usage.PromptTokens)
+	assert.Equal(t, 10, usage.CompletionTokens)
+	assert.Equal(t, 110, usage.TotalTokens)
+	require.NotNil(t, usage.PromptTokensDetails)
+	assert.Equal(t, 50, usage.PromptTokensDetails.CachedTokens)
+	assert.Equal(t, 30, usage.PromptTokensDetails.CacheWriteTokens)
+
+	assert.Equal(t, claudeUsage, UsageFromOpenAI(&usage))
+	assert.Nil(t, Usage{InputTokens: 5}.ToOpenAIUsage().PromptTokensDetails)
+}
@@ -0,0 +1,756 @@
+package anthropic
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/Laisky/errors/v2"
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/random"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+// This file implements the inbound side of the native Messages API (/v1/messages).
+//
+// Requests served by Anthropic channels are passed through as is,
+// requests served by any other channel are converted to GeneralOpenAIRequest,
+// and the OpenAI-format output of that channel is converted back.
+
+// ParseMessagesContent parses a content field of the Messages API,
+// which could be a plain string or a list of content blocks.
+func ParseMessagesContent(content any) ([]MessagesContent, error) {
+	switch v := content.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []MessagesContent{{Type: "text", Text: v}}, nil
+	case []MessagesContent:
+		return v, nil
+	}
+
+	raw, err := json.Marshal(content)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal content")
+	}
+
+	var blocks []MessagesContent
+	if err = json.Unmarshal(raw, &blocks); err != nil {
+		return nil, errors.Wrap(err, "unmarshal content blocks")
+	}
+
+	return blocks, nil
+}
+
+// messagesContentText joins all text blocks of the content
+func messagesContentText(content any) (string, error) {
+	blocks, err := ParseMessagesContent(content)
+	if err != nil {
+		return "", errors.Wrap(err, "parse content")
+	}
+
+	var texts []string
+	for _, block := range blocks {
+		if block.Type == "text" && block.Text != "" {
+			texts = append(texts, block.Text)
+		}
+	}
+
+	return strings.Join(texts, "\n"), nil
+}
+
+// imageSourceToURL converts an image source to an url accepted by OpenAI image_url
+func imageSourceToURL(source *ImageSource) (string, error) {
+	if source == nil {
+		return "", errors.New("image source is empty")
+	}
+
+	switch source.Type {
+	case "base64":
+		return fmt.Sprintf("data:%s;base64,%s", source.MediaType, source.Data), nil
+	case "url":
+		return source.Url, nil
+	default:
+		return "", errors.Errorf("unsupported image source type %q", source.Type)
+	}
+}
+
+// ConvertMessagesRequest converts a native Messages API request to GeneralOpenAIRequest,
+// so that it could be served by channels that do not speak the Messages API.
+//
+// Thinking blocks in the conversation history are dropped,
+// since their signatures are only meaningful to Anthropic.
+func ConvertMessagesRequest(request *MessagesRequest) (*model.GeneralOpenAIRequest, error) {
+	if request == nil {
+		return nil, errors.New("request is nil")
+	}
+
+	textRequest := &model.GeneralOpenAIRequest{
+		Model:       request.Model,
+		MaxTokens:   request.MaxTokens,
+		Temperature: request.Temperature,
+		TopP:        request.TopP,
+		TopK:        request.TopK,
+		Stream:      request.Stream,
+		Thinking:    request.Thinking,
+	}
+	if request.Stream {
+		textRequest.StreamOptions = &model.StreamOptions{IncludeUsage: true}
+	}
+	if len(request.StopSequences) > 0 {
+		textRequest.Stop = request.StopSequences
+	}
+	if request.Metadata != nil {
+		textRequest.User = request.Metadata.UserId
+	}
+
+	// system prompt
+	systemPrompt, err := messagesContentText(request.System)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse system prompt")
+	}
+	if systemPrompt != "" {
+		textRequest.Messages = append(textRequest.Messages, model.Message{
+			Role:    "system",
+			Content: systemPrompt,
+		})
+	}
+
+	// messages
+	for i, message := range request.Messages {
+		messages, err := convertMessagesMessage(message)
+		if err != nil {
+			return nil, errors.Wrapf(err, "convert message %d", i)
+		}
+		textRequest.Messages = append(textRequest.Messages, messages...)
+	}
+
+	// tools
+	for _, tool := range request.Tools {
+		if tool.Type != "" && tool.Type != "custom" {
+			logger.Warnf(context.TODO(), "server tool %q of type %q is not supported by conversion, skip it", tool.Name, tool.Type)
+			continue
+		}
+
+		parameters := tool.InputSchema
+		if parameters == nil {
+			parameters = map[string]any{
+				"type":       "object",
+				"properties": map[string]any{},
+			}
+		}
+		textRequest.Tools = append(textRequest.Tools, model.Tool{
+			Type: "function",
+			Function: model.Function{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  parameters,
+			},
+		})
+	}
+
+	if request.ToolChoice != nil {
+		switch request.ToolChoice.Type {
+		case "auto", "":
+			textRequest.ToolChoice = "auto"
+		case "any":
+			textRequest.ToolChoice = "required"
+		case "none":
+			textRequest.ToolChoice = "none"
+		case "tool":
+			textRequest.ToolChoice = map[string]any{
+				"type": "function",
+				"function": map[string]any{
+					"name": request.ToolChoice.Name,
+				},
+			}
+		default:
+			return nil, errors.Errorf("unsupported tool_choice type %q", request.ToolChoice.Type)
+		}
+
+		if request.ToolChoice.DisableParallelToolUse {
+			parallel := false
+			textRequest.ParallelTooCalls = &parallel
+		}
+	}
+
+	return textRequest, nil
+}
+
+// convertMessagesMessage converts one Messages API message to one or more OpenAI messages,
+// tool results are split out as individual tool messages.
+func convertMessagesMessage(message MessagesMessage) ([]model.Message, error) {
+	if content, ok := message.Content.(string); ok {
+		return []model.Message{{Role: message.Role, Content: content}}, nil
+	}
+
+	blocks, err := ParseMessagesContent(message.Content)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse content")
+	}
+
+	var (
+		messages  []model.Message
+		parts     []any
+		texts     []string
+		toolCalls []model.Tool
+	)
+	for _, block := range blocks {
+		switch block.Type {
+		case "text":
+			texts = append(texts, block.Text)
+			parts = append(parts, map[string]any{
+				"type": model.ContentTypeText,
+				"text": block.Text,
+			})
+		case "image":
+			url, err := imageSourceToURL(block.Source)
+			if err != nil {
+				return nil, errors.Wrap(err, "convert image")
+			}
+			parts = append(parts, map[string]any{
+				"type": model.ContentTypeImageURL,
+				"image_url": map[string]any{
+					"url": url,
+				},
+			})
+		case "tool_use":
+			input := block.Input
+			if input == nil {
+				input = map[string]any{}
+			}
+			args, err := json.Marshal(input)
+			if err != nil {
+				return nil, errors.Wrapf(err, "marshal input of tool %s", block.Name)
+			}
+			toolCalls = append(toolCalls, model.Tool{
+				Id:   block.Id,
+				Type: "function",
+				Function: model.Function{
+					Name:      block.Name,
+					Arguments: string(args),
+				},
+			})
+		case "tool_result":
+			result, err := messagesContentText(block.Content)
+			if err != nil {
+				return nil, errors.Wrapf(err, "parse result of tool %s", block.ToolUseId)
+			}
+			messages = append(messages, model.Message{
+				Role:       "tool",
+				Content:    result,
+				ToolCallId: block.ToolUseId,
+			})
+		case "thinking", "redacted_thinking":
+			// signatures could not be verified by other providers
+		default:
+			return nil, errors.Errorf("content block type %q is not supported by the selected channel", block.Type)
+		}
+	}
+
+	if message.Role == "assistant" {
+		msg := model.Message{
+			Role:      message.Role,
+			ToolCalls: toolCalls,
+		}
+		if text := strings.Join(texts, ""); text != "" {
+			msg.Content = text
+		}
+		if msg.Content != nil || len(msg.ToolCalls) > 0 {
+			messages = append(messages, msg)
+		}
+		return messages, nil
+	}
+
+	switch {
+	case len(parts) == 0:
+	case len(parts) == len(texts) && len(texts) == 1:
+		messages = append(messages, model.Message{Role: message.Role, Content: texts[0]})
+	default:
+		messages = append(messages, model.Message{Role: message.Role, Content: parts})
+	}
+
+	return messages, nil
+}
+
+// stopReasonOpenAI2Claude converts an OpenAI finish_reason to a Messages API stop_reason
+func stopReasonOpenAI2Claude(reason string) string {
+	switch reason {
+	case "length":
+		return "max_tokens"
+	case "tool_calls", "function_call":
+		return "tool_use"
+	case "content_filter":
+		return "refusal"
+	default:
+		return "end_turn"
+	}
+}
+
+// reasoningOfMessage returns the reasoning content of an OpenAI message, whatever format it uses
+func reasoningOfMessage(message *model.Message) string {
+	switch {
+	case message.Reasoning != nil && *message.Reasoning != "":
+		return *message.Reasoning
+	case message.ReasoningContent != nil && *message.ReasoningContent != "":
+		return *message.ReasoningContent
+	case message.Thinking != nil && *message.Thinking != "":
+		return *message.Thinking
+	}
+
+	return ""
+}
+
+// messagesId converts an OpenAI response id to a Messages API message id
+func messagesId(id string) string {
+	id = strings.TrimPrefix(id, "chatcmpl-")
+	if id == "" {
+		id = random.GetUUID()
+	}
+	if strings.HasPrefix(id, "msg_") {
+		return id
+	}
+
+	return "msg_" + id
+}
+
+// ConvertOpenAIResponse converts an OpenAI chat completion to a Messages API response
+func ConvertOpenAIResponse(response *openai.TextResponse) *MessagesResponse {
+	claudeResponse := &MessagesResponse{
+		Id:      messagesId(response.Id),
+		Type:    "message",
+		Role:    "assistant",
+		Model:   response.Model,
+		Content: []Content{},
+		Usage:   UsageFromOpenAI(&response.Usage),
+	}
+
+	stopReason := "end_turn"
+	if len(response.Choices) > 0 {
+		choice := response.Choices[0]
+		if reasoning := reasoningOfMessage(&choice.Message); reasoning != "" {
+			signature := ""
+			claudeResponse.Content = append(claudeResponse.Content, Content{
+				Type:      "thinking",
+				Thinking:  &reasoning,
+				Signature: &signature,
+			})
+		}
+		if text := choice.Message.StringContent(); text != "" {
+			claudeResponse.Content = append(claudeResponse.Content, Content{
+				Type: "text",
+				Text: text,
+			})
+		}
+		for _, tool := range choice.Message.ToolCalls {
+			input := map[string]any{}
+			if args, ok := tool.Function.Arguments.(string); ok && args != "" {
+				if err := json.Unmarshal([]byte(args), &input); err != nil {
+					logger.Warnf(context.TODO(), "unmarshal arguments of tool %s: %+v", tool.Function.Name, err)
+				}
+			}
+			claudeResponse.Content = append(claudeResponse.Content, Content{
+				Type:  "tool_use",
+				Id:    tool.Id,
+				Name:  tool.Function.Name,
+				Input: input,
+			})
+		}
+		stopReason = stopReasonOpenAI2Claude(choice.FinishReason)
+	}
+	claudeResponse.StopReason = &stopReason
+
+	return claudeResponse
+}
+
+// ConvertErrorToMessages converts a relay error to the error body of the Messages API
+func ConvertErrorToMessages(err *model.ErrorWithStatusCode) *MessagesErrorResponse {
+	errType := err.Type
+	switch errType {
+	case "invalid_request_error", "authentication_error", "permission_error",
+		"not_found_error", "request_too_large", "rate_limit_error",
+		"api_error", "overloaded_error":
+	default:
+		switch err.StatusCode {
+		case http.StatusBadRequest:
+			errType = "invalid_request_error"
+		case http.StatusUnauthorized:
+			errType = "authentication_error"
+		case http.StatusForbidden:
+			errType = "permission_error"
+		case http.StatusNotFound:
+			errType = "not_found_error"
+		case http.StatusRequestEntityTooLarge:
+			errType = "request_too_large"
+		case http.StatusTooManyRequests:
+			errType = "rate_limit_error"
+		case 529:
+			errType = "overloaded_error"
+		default:
+			errType = "api_error"
+		}
+	}
+
+	return &MessagesErrorResponse{
+		Type: "error",
+		Error: Error{
+			Type:    errType,
+			Message: err.Message,
+		},
+	}
+}
+
+// OpenAIStreamConverter converts OpenAI chat completion chunks to Messages API stream events.
+//
+// Content blocks are emitted strictly one after another:
+// a block is closed as soon as a chunk of another kind arrives.
+type OpenAIStreamConverter struct {
+	id           string
+	model        string
+	promptTokens int
+
+	started bool
+	// blockIndex is the index of the currently open block, -1 means no block is open
+	blockIndex int
+	blockType  string
+	nextIndex  int
+	// toolBlocks maps the OpenAI tool call index to the index of its content block
+	toolBlocks  map[int]int
+	currentTool int
+
+	stopReason string
+	usage      *model.Usage
+}
+
+// NewOpenAIStreamConverter creates a converter, promptTokens is reported in message_start
+// before the upstream usage is known.
+func NewOpenAIStreamConverter(modelName string, promptTokens int) *OpenAIStreamConverter {
+	return &OpenAIStreamConverter{
+		model:        modelName,
+		promptTokens: promptTokens,
+		blockIndex:   -1,
+		toolBlocks:   make(map[int]int),
+		currentTool:  -1,
+	}
+}
+
+func (s *OpenAIStreamConverter) start(id string) []MessagesStreamEvent {
+	if s.started {
+		return nil
+	}
+	s.started = true
+	s.id = messagesId(id)
+
+	return []MessagesStreamEvent{{
+		Event: "message_start",
+		Data: map[string]any{
+			"type": "message_start",
+			"message": map[string]any{
+				"id":            s.id,
+				"type":          "message",
+				"role":          "assistant",
+				"content":       []any{},
+				"model":         s.model,
+				"stop_reason":   nil,
+				"stop_sequence": nil,
+				"usage": map[string]any{
+					"input_tokens":  s.promptTokens,
+					"output_tokens": 0,
+				},
+			},
+		},
+	}}
+}
+
+func (s *OpenAIStreamConverter) closeBlock() []MessagesStreamEvent {
+	if s.blockIndex < 0 {
+		return nil
+	}
+
+	event := MessagesStreamEvent{
+		Event: "content_block_stop",
+		Data: map[string]any{
+			"type":  "content_block_stop",
+			"index": s.blockIndex,
+		},
+	}
+	s.blockIndex = -1
+	s.blockType = ""
+	s.currentTool = -1
+
+	return []MessagesStreamEvent{event}
+}
+
+func (s *OpenAIStreamConverter) openBlock(blockType string, block map[string]any) []MessagesStreamEvent {
+	events := s.closeBlock()
+	s.blockIndex = s.nextIndex
+	s.blockType = blockType
+	s.nextIndex++
+
+	return append(events, MessagesStreamEvent{
+		Event: "content_block_start",
+		Data: map[string]any{
+			"type":          "content_block_start",
+			"index":         s.blockIndex,
+			"content_block": block,
+		},
+	})
+}
+
+func (s *OpenAIStreamConverter) delta(delta map[string]any) MessagesStreamEvent {
+	return MessagesStreamEvent{
+		Event: "content_block_delta",
+		Data: map[string]any{
+			"type":  "content_block_delta",
+			"index": s.blockIndex,
+			"delta": delta,
+		},
+	}
+}
+
+// Convert converts one OpenAI chunk to zero or more Messages API events
+func (s *OpenAIStreamConverter) Convert(chunk *openai.ChatCompletionsStreamResponse) []MessagesStreamEvent {
+	events := s.start(chunk.Id)
+	if chunk.Usage != nil {
+		s.usage = chunk.Usage
+	}
+
+	for _, choice := range chunk.Choices {
+		if reasoning := reasoningOfMessage(&choice.Delta); reasoning != "" {
+			if s.blockType != "thinking" {
+				events = append(events, s.openBlock("thinking", map[string]any{
+					"type":     "thinking",
+					"thinking": "",
+				})...)
+			}
+			events = append(events, s.delta(map[string]any{
+				"type":     "thinking_delta",
+				"thinking": reasoning,
+			}))
+		}
+
+		if text, ok := choice.Delta.Content.(string); ok && text != "" {
+			if s.blockType != "text" {
+				events = append(events, s.openBlock("text", map[string]any{
+					"type": "text",
+					"text": "",
+				})...)
+			}
+			events = append(events, s.delta(map[string]any{
+				"type": "text_delta",
+				"text": text,
+			}))
+		}
+
+		for i, tool := range choice.Delta.ToolCalls {
+			toolIndex := i
+			if tool.Index != nil {
+				toolIndex = *tool.Index
+			}
+
+			if s.blockType != "tool_use" || s.currentTool != toolIndex {
+				if _, seen := s.toolBlocks[toolIndex]; seen {
+					logger.Warnf(context.TODO(), "arguments of closed tool call %d arrived late, drop them", toolIndex)
+					continue
+				}
+				events = append(events, s.openBlock("tool_use", map[string]any{
+					"type":  "tool_use",
+					"id":    tool.Id,
+					"name":  tool.Function.Name,
+					"input": map[string]any{},
+				})...)
+				s.toolBlocks[toolIndex] = s.blockIndex
+				s.currentTool = toolIndex
+			}
+
+			if args, ok := tool.Function.Arguments.(string); ok && args != "" {
+				events = append(events, s.delta(map[string]any{
+					"type":         "input_json_delta",
+					"partial_json": args,
+				}))
+			}
+		}
+
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			s.stopReason = stopReasonOpenAI2Claude(*choice.FinishReason)
+		}
+	}
+
+	return events
+}
+
+// Finish closes the stream, usage is the billed usage of the request,
+// the last usage reported by upstream is used if it is nil.
+func (s *OpenAIStreamConverter) Finish(usage *model.Usage) []MessagesStreamEvent {
+	events := s.start("")
+	events = append(events, s.closeBlock()...)
+
+	stopReason := s.stopReason
+	if stopReason == "" {
+		stopReason = "end_turn"
+	}
+	if usage == nil {
+		usage = s.usage
+	}
+	claudeUsage := Usage{InputTokens: s.promptTokens}
+	if usage != nil {
+		claudeUsage = UsageFromOpenAI(usage)
+	}
+	deltaUsage := map[string]any{
+		"input_tokens":  claudeUsage.InputTokens,
+		"output_tokens": claudeUsage.OutputTokens,
+	}
+	if claudeUsage.CacheCreationInputTokens > 0 {
+		deltaUsage["cache_creation_input_tokens"] = claudeUsage.CacheCreationInputTokens
+	}
+	if claudeUsage.CacheReadInputTokens > 0 {
+		deltaUsage["cache_read_input_tokens"] = claudeUsage.CacheReadInputTokens
+	}
+
+	return append(events,
+		MessagesStreamEvent{
+			Event: "message_delta",
+			Data: map[string]any{
+				"type": "message_delta",
+				"delta": map[string]any{
+					"stop_reason":   stopReason,
+					"stop_sequence": nil,
+				},
+				"usage": deltaUsage,
+			},
+		},
+		MessagesStreamEvent{
+			Event: "message_stop",
+			Data: map[string]any{
+				"type": "message_stop",
+			},
+		},
+	)
+}
+
+// RenderMessagesStreamEvent writes one server-sent event in the Messages API format
+func RenderMessagesStreamEvent(w gin.ResponseWriter, event MessagesStreamEvent) error {
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		return errors.Wrap(err, "marshal stream event")
+	}
+
+	if _, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Event, data); err != nil {
+		return errors.Wrap(err, "write stream event")
+	}
+	w.Flush()
+	return nil
+}
+
+// MessagesHandler passes a native Messages API response through to the client
+// and extracts the usage from it.
+func MessagesHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusCode, *model.Usage) {
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return openai.ErrorWrapper(err, "read_response_body_failed", http.StatusInternalServerError), nil
+	}
+	if err = resp.Body.Close(); err != nil {
+		return openai.ErrorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), nil
+	}
+
+	logger.Debugf(c.Request.Context(), "messages response <- %s\n", string(responseBody))
+
+	var claudeResponse Response
+	if err = json.Unmarshal(responseBody, &claudeResponse); err != nil {
+		return openai.ErrorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError), nil
+	}
+	if claudeResponse.Error.Type != "" {
+		return &model.ErrorWithStatusCode{
+			Error: model.Error{
+				Message: claudeResponse.Error.Message,
+				Type:    claudeResponse.Error.Type,
+				Code:    claudeResponse.Error.Type,
+			},
+			StatusCode: resp.StatusCode,
+		}, nil
+	}
+
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.WriteHeader(resp.StatusCode)
+	if _, err = c.Writer.Write(responseBody); err != nil {
+		return openai.ErrorWrapper(err, "write_response_body_failed", http.StatusInternalServerError), nil
+	}
+
+	usage := claudeResponse.Usage.ToOpenAIUsage()
+	return nil, &usage
+}
+
+// MessagesStreamHandler passes a native Messages API stream through to the client
+// and extracts the usage from message_start and message_delta events.
+func MessagesStreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusCode, *model.Usage) {
+	scanner := bufio.NewScanner(resp.Body)
+	buffer := make([]byte, 1024*1024)
+	scanner.Buffer(buffer, len(buffer))
+	scanner.Split(bufio.ScanLines)
+
+	common.SetEventStreamHeaders(c)
+
+	var claudeUsage Usage
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if _, err := c.Writer.Write(append(line, '\n')); err != nil {
+			logger.Errorf(c.Request.Context(), "write stream line: %+v", err)
+			break
+		}
+		if len(line) == 0 {
+			c.Writer.Flush()
+			continue
+		}
+		if !bytes.HasPrefix(line, []byte("data:")) {
+			continue
+		}
+
+		var event StreamResponse
+		if err := json.Unmarshal(bytes.TrimSpace(line[len("data:"):]), &event); err != nil {
+			logger.Warnf(c.Request.Context(), "unmarshal stream event %q: %+v", line, err)
+			continue
+		}
+		switch event.Type {
+		case string(TypeStart):
+			if event.Message != nil {
+				claudeUsage = event.Message.Usage
+			}
+		case string(TypeMessageDelta):
+			// usage of message_delta is cumulative, fields not reported are left as they were in message_start
+			if event.Usage != nil {
+				if event.Usage.OutputTokens > 0 {
+					claudeUsage.OutputTokens = event.Usage.OutputTokens
+				}
+				if event.Usage.InputTokens > 0 {
+					claudeUsage.InputTokens = event.Usage.InputTokens
+				}
+				if event.Usage.CacheCreationInputTokens > 0 {
+					claudeUsage.CacheCreationInputTokens = event.Usage.CacheCreationInputTokens
+				}
+				if event.Usage.CacheReadInputTokens > 0 {
+					claudeUsage.CacheReadInputTokens = event.Usage.CacheReadInputTokens
+				}
+			}
+		}
+	}
+	c.Writer.Flush()
+
+	if err := scanner.Err(); err != nil {
+		logger.Errorf(c.Request.Context(), "read stream: %+v", err)
+	}
+	if err := resp.Body.Close(); err != nil {
+		return openai.ErrorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), nil
+	}
+
+	usage := claudeUsage.ToOpenAIUsage()
+	return nil, &usage
+}
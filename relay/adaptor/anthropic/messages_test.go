@@ -0,0 +1,247 @@
+package anthropic
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+func TestConvertMessagesRequest(t *testing.T) {
+	raw := `{
+		"model": "claude-sonnet-4-20250514",
+		"max_tokens": 2048,
+		"stream": true,
+		"system": [{"type": "text", "text": "be brief", "cache_control": {"type": "ephemeral"}}],
+		"metadata": {"user_id": "u-1"},
+		"stop_sequences": ["END"],
+		"tools": [
+			{"name": "get_weather", "description": "weather", "input_schema": {"type": "object", "properties": {"city": {"type": "string"}}, "required": ["city"], "additionalProperties": false}},
+			{"type": "web_search_20250305", "name": "web_search"}
+		],
+		"tool_choice": {"type": "any", "disable_parallel_tool_use": true},
+		"messages": [
+			{"role": "user", "content": "weather in Paris?"},
+			{"role": "assistant", "content": [
+				{"type": "thinking", "thinking": "need tool", "signature": "sig"},
+				{"type": "text", "text": "Let me check."},
+				{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {"city": "Paris"}}
+			]},
+			{"role": "user", "content": [
+				{"type": "tool_result", "tool_use_id": "toolu_1", "content": [{"type": "text", "text": "sunny"}]},
+				{"type": "text", "text": "and tomorrow?"},
+				{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "AAAA"}}
+			]}
+		]
+	}`
+
+	request := new(MessagesRequest)
+	require.NoError(t, json.Unmarshal([]byte(raw), request))
+
+	textRequest, err := ConvertMessagesRequest(request)
+	require.NoError(t, err)
+
+	assert.Equal(t, "claude-sonnet-4-20250514", textRequest.Model)
+	assert.Equal(t, 2048, textRequest.MaxTokens)
+	assert.True(t, textRequest.Stream)
+	require.NotNil(t, textRequest.StreamOptions)
+	assert.True(t, textRequest.StreamOptions.IncludeUsage)
+	assert.Equal(t, "u-1", textRequest.User)
+	assert.Equal(t, []string{"END"}, textRequest.Stop)
+	assert.Equal(t, "required", textRequest.ToolChoice)
+	require.NotNil(t, textRequest.ParallelTooCalls)
+	assert.False(t, *textRequest.ParallelTooCalls)
+
+	// server tools are skipped, client tools keep their whole schema
+	require.Len(t, textRequest.Tools, 1)
+	assert.Equal(t, "get_weather", textRequest.Tools[0].Function.Name)
+	assert.Equal(t, false, textRequest.Tools[0].Function.Parameters["additionalProperties"])
+
+	require.Len(t, textRequest.Messages, 5)
+	assert.Equal(t, "system", textRequest.Messages[0].Role)
+	assert.Equal(t, "be brief", textRequest.Messages[0].Content)
+
+	assert.Equal(t, "user", textRequest.Messages[1].Role)
+	assert.Equal(t, "weather in Paris?", textRequest.Messages[1].Content)
+
+	assistant := textRequest.Messages[2]
+	assert.Equal(t, "assistant", assistant.Role)
+	assert.Equal(t, "Let me check.", assistant.Content)
+	require.Len(t, assistant.ToolCalls, 1)
+	assert.Equal(t, "toolu_1", assistant.ToolCalls[0].Id)
+	assert.JSONEq(t, `{"city":"Paris"}`, assistant.ToolCalls[0].Function.Arguments.(string))
+
+	// tool results come before the rest of the user turn
+	assert.Equal(t, "tool", textRequest.Messages[3].Role)
+	assert.Equal(t, "toolu_1", textRequest.Messages[3].ToolCallId)
+	assert.Equal(t, "sunny", textRequest.Messages[3].Content)
+
+	user := textRequest.Messages[4]
+	assert.Equal(t, "user", user.Role)
+	parts := user.ParseContent()
+	require.Len(t, parts, 2)
+	assert.Equal(t, "and tomorrow?", *parts[0].Text)
+	assert.Equal(t, "data:image/png;base64,AAAA", parts[1].ImageURL.Url)
+}
+
+func TestConvertMessagesRequest_ToolChoice(t *testing.T) {
+	for _, tc := range []struct {
+		choice   MessagesToolChoice
+		expected any
+	}{
+		{MessagesToolChoice{Type: "auto"}, "auto"},
+		{MessagesToolChoice{Type: "none"}, "none"},
+		{MessagesToolChoice{Type: "tool", Name: "f"}, map[string]any{
+			"type":     "function",
+			"function": map[string]any{"name": "f"},
+		}},
+	} {
+		choice := tc.choice
+		textRequest, err := ConvertMessagesRequest(&MessagesRequest{
+			Model:      "m",
+			MaxTokens:  1,
+			ToolChoice: &choice,
+			Messages:   []MessagesMessage{{Role: "user", Content: "hi"}},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, tc.expected, textRequest.ToolChoice)
+	}
+
+	_, err := ConvertMessagesRequest(&MessagesRequest{
+		Model:    "m",
+		Messages: []MessagesMessage{{Role: "user", Content: []any{map[string]any{"type": "document"}}}},
+	})
+	require.Error(t, err)
+}
+
+func TestConvertOpenAIResponse(t *testing.T) {
+	reasoning := "thinking hard"
+	response := &openai.TextResponse{
+		Id:    "chatcmpl-abc",
+		Model: "gpt-4o",
+		Choices: []openai.TextResponseChoice{{
+			Message: model.Message{
+				Role:      "assistant",
+				Content:   "hello",
+				Reasoning: &reasoning,
+				ToolCalls: []model.Tool{{
+					Id:       "call_1",
+					Type:     "function",
+					Function: model.Function{Name: "f", Arguments: `{"a":1}`},
+				}},
+			},
+			FinishReason: "tool_calls",
+		}},
+		Usage: model.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15},
+	}
+
+	claudeResponse := ConvertOpenAIResponse(response)
+	assert.Equal(t, "msg_abc", claudeResponse.Id)
+	assert.Equal(t, "message", claudeResponse.Type)
+	assert.Equal(t, "tool_use", *claudeResponse.StopReason)
+	assert.Equal(t, 10, claudeResponse.Usage.InputTokens)
+	assert.Equal(t, 5, claudeResponse.Usage.OutputTokens)
+
+	require.Len(t, claudeResponse.Content, 3)
+	assert.Equal(t, "thinking", claudeResponse.Content[0].Type)
+	assert.Equal(t, reasoning, *claudeResponse.Content[0].Thinking)
+	assert.Equal(t, "text", claudeResponse.Content[1].Type)
+	assert.Equal(t, "hello", claudeResponse.Content[1].Text)
+	assert.Equal(t, "tool_use", claudeResponse.Content[2].Type)
+	assert.Equal(t, map[string]any{"a": float64(1)}, claudeResponse.Content[2].Input)
+}
+
+func TestOpenAIStreamConverter(t *testing.T) {
+	chunk := func(raw string) *openai.ChatCompletionsStreamResponse {
+		resp := new(openai.ChatCompletionsStreamResponse)
+		require.NoError(t, json.Unmarshal([]byte(raw), resp))
+		return resp
+	}
+
+	converter := NewOpenAIStreamConverter("claude-x", 42)
+	var events []MessagesStreamEvent
+	events = append(events, converter.Convert(chunk(`{"id":"chatcmpl-1","choices":[{"index":0,"delta":{"role":"assistant","reasoning":"hmm"}}]}`))...)
+	events = append(events, converter.Convert(chunk(`{"id":"chatcmpl-1","choices":[{"index":0,"delta":{"content":"Hi"}}]}`))...)
+	events = append(events, converter.Convert(chunk(`{"id":"chatcmpl-1","choices":[{"index":0,"delta":{"content":" there"}}]}`))...)
+	events = append(events, converter.Convert(chunk(`{"id":"chatcmpl-1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"f","arguments":""}}]}}]}`))...)
+	events = append(events, converter.Convert(chunk(`{"id":"chatcmpl-1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"a\":1}"}}]}}]}`))...)
+	events = append(events, converter.Convert(chunk(`{"id":"chatcmpl-1","choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`))...)
+	events = append(events, converter.Finish(&model.Usage{PromptTokens: 40, CompletionTokens: 7, TotalTokens: 47})...)
+
+	var types []string
+	for _, event := range events {
+		types = append(types, event.Event)
+	}
+	assert.Equal(t, []string{
+		"message_start",
+		"content_block_start", "content_block_delta", // thinking
+		"content_block_stop", "content_block_start", "content_block_delta", "content_block_delta", // text
+		"content_block_stop", "content_block_start", "content_block_delta", // tool_use
+		"content_block_stop",
+		"message_delta",
+		"message_stop",
+	}, types)
+
+	start := events[0].Data.(map[string]any)["message"].(map[string]any)
+	assert.Equal(t, "msg_1", start["id"])
+	assert.Equal(t, 42, start["usage"].(map[string]any)["input_tokens"])
+
+	toolStart := events[8].Data.(map[string]any)
+	assert.Equal(t, 2, toolStart["index"])
+	assert.Equal(t, "call_1", toolStart["content_block"].(map[string]any)["id"])
+
+	messageDelta := events[11].Data.(map[string]any)
+	assert.Equal(t, "tool_use", messageDelta["delta"].(map[string]any)["stop_reason"])
+	assert.Equal(t, 7, messageDelta["usage"].(map[string]any)["output_tokens"])
+}
+
+func TestMessagesStreamHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+
+	upstream := strings.Join([]string{
+		`event: message_start`,
+		`data: {"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","content":[],"model":"claude","usage":{"input_tokens":25,"output_tokens":1}}}`,
+		``,
+		`event: content_block_delta`,
+		`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hi"}}`,
+		``,
+		`event: message_delta`,
+		`data: {"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":15}}`,
+		``,
+		`event: message_stop`,
+		`data: {"type":"message_stop"}`,
+		``,
+	}, "\n")
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(upstream)),
+	}
+
+	errResp, usage := MessagesStreamHandler(c, resp)
+	require.Nil(t, errResp)
+	assert.Equal(t, 25, usage.PromptTokens)
+	assert.Equal(t, 15, usage.CompletionTokens)
+	assert.Equal(t, upstream, w.Body.String())
+}
+
+func TestConvertErrorToMessages(t *testing.T) {
+	resp := ConvertErrorToMessages(&model.ErrorWithStatusCode{
+		Error:      model.Error{Message: "slow down", Type: "one_api_error"},
+		StatusCode: http.StatusTooManyRequests,
+	})
+	assert.Equal(t, "error", resp.Type)
+	assert.Equal(t, "rate_limit_error", resp.Error.Type)
+	assert.Equal(t, "slow down", resp.Error.Message)
+}
@@ -12,6 +12,8 @@ type ImageSource struct {
 	Type      string `json:"type"`
 	MediaType string `json:"media_type"`
 	Data      string `json:"data"`
+	// Url is only used by sources of type "url"
+	Url string `json:"url,omitempty"`
 }
 
 type Content struct {
@@ -66,6 +68,41 @@ type Request struct {
 type Usage struct {
 	InputTokens  int `json:"input_tokens"`
 	OutputTokens int `json:"output_tokens"`
+	// https://docs.anthropic.com/en/docs/build-with-claude/prompt-caching
+	// the input tokens written to and read from the prompt cache are not counted in InputTokens
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens,omitempty"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens,omitempty"`
+}
+
+// ToOpenAIUsage converts the usage to the OpenAI format,
+// whose prompt tokens include the ones written to and read from the prompt cache
+func (u Usage) ToOpenAIUsage() model.Usage {
+	usage := model.Usage{
+		PromptTokens:     u.InputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens,
+		CompletionTokens: u.OutputTokens,
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	if u.CacheCreationInputTokens > 0 || u.CacheReadInputTokens > 0 {
+		usage.PromptTokensDetails = &model.UsagePromptTokensDetails{
+			CachedTokens:     u.CacheReadInputTokens,
+			CacheWriteTokens: u.CacheCreationInputTokens,
+		}
+	}
+	return usage
+}
+
+// UsageFromOpenAI converts the usage of the OpenAI format, see Usage.ToOpenAIUsage
+func UsageFromOpenAI(usage *model.Usage) Usage {
+	if usage == nil {
+		return Usage{}
+	}
+	u := Usage{InputTokens: usage.PromptTokens, OutputTokens: usage.CompletionTokens}
+	if d := usage.PromptTokensDetails; d != nil && d.CachedTokens+d.CacheWriteTokens <= usage.PromptTokens {
+		u.InputTokens -= d.CachedTokens + d.CacheWriteTokens
+		u.CacheReadInputTokens = d.CachedTokens
+		u.CacheCreationInputTokens = d.CacheWriteTokens
+	}
+	return u
 }
 
 type Error struct {
@@ -117,3 +154,92 @@ type StreamResponse struct {
 	Delta        *Delta    `json:"delta"`
 	Usage        *Usage    `json:"usage"`
 }
+
+// MessagesRequest is a request received on the native /v1/messages endpoint.
+//
+// Unlike Request, which is only sent to upstream, the system prompt and
+// message contents may be either plain strings or lists of content blocks.
+//
+// https://docs.anthropic.com/en/api/messages
+type MessagesRequest struct {
+	Model         string              `json:"model"`
+	Messages      []MessagesMessage   `json:"messages"`
+	System        any                 `json:"system,omitempty"`
+	MaxTokens     int                 `json:"max_tokens,omitempty"`
+	Metadata      *Metadata           `json:"metadata,omitempty"`
+	StopSequences []string            `json:"stop_sequences,omitempty"`
+	Stream        bool                `json:"stream,omitempty"`
+	Temperature   *float64            `json:"temperature,omitempty"`
+	TopP          *float64            `json:"top_p,omitempty"`
+	TopK          int                 `json:"top_k,omitempty"`
+	Tools         []MessagesTool      `json:"tools,omitempty"`
+	ToolChoice    *MessagesToolChoice `json:"tool_choice,omitempty"`
+	Thinking      *model.Thinking     `json:"thinking,omitempty"`
+}
+
+// MessagesMessage is a message of MessagesRequest, content is a string or a list of MessagesContent
+type MessagesMessage struct {
+	Role    string `json:"role"`
+	Content any    `json:"content"`
+}
+
+// MessagesContent is a content block of MessagesRequest
+type MessagesContent struct {
+	Type   string       `json:"type"`
+	Text   string       `json:"text,omitempty"`
+	Source *ImageSource `json:"source,omitempty"`
+	// tool_use
+	Id    string `json:"id,omitempty"`
+	Name  string `json:"name,omitempty"`
+	Input any    `json:"input,omitempty"`
+	// tool_result, content is a string or a list of MessagesContent
+	ToolUseId string `json:"tool_use_id,omitempty"`
+	Content   any    `json:"content,omitempty"`
+	IsError   bool   `json:"is_error,omitempty"`
+	// thinking
+	Thinking  *string `json:"thinking,omitempty"`
+	Signature *string `json:"signature,omitempty"`
+}
+
+// MessagesTool is a tool definition of MessagesRequest.
+// InputSchema is kept as is, so that no JSON schema keyword is lost during conversion.
+type MessagesTool struct {
+	// Type is empty or "custom" for client tools, server tools like web_search carry their own type
+	Type        string         `json:"type,omitempty"`
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	InputSchema map[string]any `json:"input_schema,omitempty"`
+}
+
+// MessagesToolChoice is the tool_choice of MessagesRequest
+type MessagesToolChoice struct {
+	// Type should be one of auto/any/tool/none
+	Type                   string `json:"type"`
+	Name                   string `json:"name,omitempty"`
+	DisableParallelToolUse bool   `json:"disable_parallel_tool_use,omitempty"`
+}
+
+// MessagesResponse is a non-stream response of the native /v1/messages endpoint
+type MessagesResponse struct {
+	Id           string    `json:"id"`
+	Type         string    `json:"type"`
+	Role         string    `json:"role"`
+	Content      []Content `json:"content"`
+	Model        string    `json:"model"`
+	StopReason   *string   `json:"stop_reason"`
+	StopSequence *string   `json:"stop_sequence"`
+	Usage        Usage     `json:"usage"`
+}
+
+// MessagesStreamEvent is a server-sent event of the native /v1/messages stream,
+// Data will be marshalled as the event payload.
+type MessagesStreamEvent struct {
+	Event string
+	Data  any
+}
+
+// MessagesErrorResponse is the error body of the native /v1/messages endpoint
+type MessagesErrorResponse struct {
+	Type  string `json:"type"`
+	Error Error  `json:"error"`
+}
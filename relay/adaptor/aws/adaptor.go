@@ -13,6 +13,7 @@ import (
 	"github.com/gin-gonic/gin"
 
 	"github.com/songquanpeng/one-api/relay/adaptor"
+	"github.com/songquanpeng/one-api/relay/adaptor/anthropic"
 	"github.com/songquanpeng/one-api/relay/adaptor/aws/utils"
 	"github.com/songquanpeng/one-api/relay/meta"
 	"github.com/songquanpeng/one-api/relay/model"
@@ -99,20 +100,20 @@ func (a *Adaptor) GetDefaultModelPricing() map[string]adaptor.ModelConfig {
 	// Pricing from https://aws.amazon.com/bedrock/pricing/
 	return map[string]adaptor.ModelConfig{
 		// Claude Models on AWS Bedrock
-		"claude-instant-1.2":         {Ratio: 0.8 * MilliTokensUsd, CompletionRatio: 3.125}, // $0.8/$2.5 per 1K tokens
-		"claude-2.0":                 {Ratio: 8 * MilliTokensUsd, CompletionRatio: 3.125},   // $8/$25 per 1K tokens
-		"claude-2.1":                 {Ratio: 8 * MilliTokensUsd, CompletionRatio: 3.125},   // $8/$25 per 1K tokens
-		"claude-3-haiku-20240307":    {Ratio: 0.25 * MilliTokensUsd, CompletionRatio: 5},    // $0.25/$1.25 per 1K tokens
-		"claude-3-sonnet-20240229":   {Ratio: 3 * MilliTokensUsd, CompletionRatio: 5},       // $3/$15 per 1K tokens
-		"claude-3-opus-20240229":     {Ratio: 15 * MilliTokensUsd, CompletionRatio: 5},      // $15/$75 per 1K tokens
-		"claude-opus-4-20250514":     {Ratio: 15 * MilliTokensUsd, CompletionRatio: 5},      // $15/$75 per 1K tokens
-		"claude-3-5-sonnet-20240620": {Ratio: 3 * MilliTokensUsd, CompletionRatio: 5},       // $3/$15 per 1K tokens
-		"claude-3-5-sonnet-20241022": {Ratio: 3 * MilliTokensUsd, CompletionRatio: 5},       // $3/$15 per 1K tokens
-		"claude-3-5-sonnet-latest":   {Ratio: 3 * MilliTokensUsd, CompletionRatio: 5},       // $3/$15 per 1K tokens
-		"claude-3-5-haiku-20241022":  {Ratio: 1 * MilliTokensUsd, CompletionRatio: 5},       // $1/$5 per 1K tokens
-		"claude-3-7-sonnet-latest":   {Ratio: 3 * MilliTokensUsd, CompletionRatio: 5},       // $3/$15 per 1K tokens
-		"claude-3-7-sonnet-20250219": {Ratio: 3 * MilliTokensUsd, CompletionRatio: 5},       // $3/$15 per 1K tokens
-		"claude-sonnet-4-20250514":   {Ratio: 3 * MilliTokensUsd, CompletionRatio: 5},       // $3/$15 per 1K tokens
+		"claude-instant-1.2":         {Ratio: 0.8 * MilliTokensUsd, CompletionRatio: 3.125},                                                                                  // $0.8/$2.5 per 1K tokens
+		"claude-2.0":                 {Ratio: 8 * MilliTokensUsd, CompletionRatio: 3.125},                                                                                    // $8/$25 per 1K tokens
+		"claude-2.1":                 {Ratio: 8 * MilliTokensUsd, CompletionRatio: 3.125},                                                                                    // $8/$25 per 1K tokens
+		"claude-3-haiku-20240307":    {Ratio: 0.25 * MilliTokensUsd, CompletionRatio: 5, CachedInputRatio: 0.1, CacheWriteRatio: 1.25},                                       // $0.25/$1.25 per 1K tokens
+		"claude-3-sonnet-20240229":   {Ratio: 3 * MilliTokensUsd, CompletionRatio: 5, CachedInputRatio: 0.1, CacheWriteRatio: 1.25},                                          // $3/$15 per 1K tokens
+		"claude-3-opus-20240229":     {Ratio: 15 * MilliTokensUsd, CompletionRatio: 5, CachedInputRatio: 0.1, CacheWriteRatio: 1.25},                                         // $15/$75 per 1K tokens
+		"claude-opus-4-20250514":     {Ratio: 15 * MilliTokensUsd, CompletionRatio: 5, CachedInputRatio: 0.1, CacheWriteRatio: 1.25},                                         // $15/$75 per 1K tokens
+		"claude-3-5-sonnet-20240620": {Ratio: 3 * MilliTokensUsd, CompletionRatio: 5, CachedInputRatio: 0.1, CacheWriteRatio: 1.25},                                          // $3/$15 per 1K tokens
+		"claude-3-5-sonnet-20241022": {Ratio: 3 * MilliTokensUsd, CompletionRatio: 5, CachedInputRatio: 0.1, CacheWriteRatio: 1.25},                                          // $3/$15 per 1K tokens
+		"claude-3-5-sonnet-latest":   {Ratio: 3 * MilliTokensUsd, CompletionRatio: 5, CachedInputRatio: 0.1, CacheWriteRatio: 1.25},                                          // $3/$15 per 1K tokens
+		"claude-3-5-haiku-20241022":  {Ratio: 1 * MilliTokensUsd, CompletionRatio: 5, CachedInputRatio: 0.1, CacheWriteRatio: 1.25},                                          // $1/$5 per 1K tokens
+		"claude-3-7-sonnet-latest":   {Ratio: 3 * MilliTokensUsd, CompletionRatio: 5, CachedInputRatio: 0.1, CacheWriteRatio: 1.25},                                          // $3/$15 per 1K tokens
+		"claude-3-7-sonnet-20250219": {Ratio: 3 * MilliTokensUsd, CompletionRatio: 5, CachedInputRatio: 0.1, CacheWriteRatio: 1.25},                                          // $3/$15 per 1K tokens
+		"claude-sonnet-4-20250514":   {Ratio: 3 * MilliTokensUsd, CompletionRatio: 5, CachedInputRatio: 0.1, CacheWriteRatio: 1.25, Tiers: anthropic.SonnetLongContextTiers}, // $3/$15 per 1K tokens
 
 		// Llama Models on AWS Bedrock
 		"llama3-8b-8192":  {Ratio: 0.3 * MilliTokensUsd, CompletionRatio: 2},  // $0.3/$0.6 per 1K tokens
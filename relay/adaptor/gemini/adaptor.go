@@ -12,7 +12,6 @@ import (
 	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/helper"
 	channelhelper "github.com/songquanpeng/one-api/relay/adaptor"
-	"github.com/songquanpeng/one-api/relay/adaptor/openai"
 	"github.com/songquanpeng/one-api/relay/meta"
 	"github.com/songquanpeng/one-api/relay/model"
 	"github.com/songquanpeng/one-api/relay/relaymode"
@@ -81,10 +80,17 @@ func (a *Adaptor) DoRequest(c *gin.Context, meta *meta.Meta, requestBody io.Read
 }
 
 func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, meta *meta.Meta) (usage *model.Usage, err *model.ErrorWithStatusCode) {
+	if meta.Mode == relaymode.GeminiGenerateContent {
+		if meta.IsStream {
+			err, usage = GenerateContentStreamHandler(c, resp, meta.PromptTokens, meta.ActualModelName)
+		} else {
+			err, usage = GenerateContentHandler(c, resp, meta.PromptTokens, meta.ActualModelName)
+		}
+		return
+	}
+
 	if meta.IsStream {
-		var responseText string
-		err, responseText = StreamHandler(c, resp)
-		usage = openai.ResponseText2Usage(responseText, meta.ActualModelName, meta.PromptTokens)
+		err, usage = StreamHandler(c, resp, meta.PromptTokens, meta.ActualModelName)
 	} else {
 		switch meta.Mode {
 		case relaymode.Embeddings:
@@ -0,0 +1,750 @@
+package gemini
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/Laisky/errors/v2"
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+// This file implements the inbound side of the native Gemini API
+// (/v1beta/models/{model}:generateContent and :streamGenerateContent).
+//
+// Requests served by Gemini channels are passed through as is,
+// requests served by any other channel are converted to GeneralOpenAIRequest,
+// and the OpenAI-format output of that channel is converted back.
+
+// functionCallIds pairs functionResponse parts with the functionCall parts they answer,
+// since Gemini matches them by name while OpenAI requires an id.
+type functionCallIds struct {
+	next    int
+	pending map[string][]string
+}
+
+func (f *functionCallIds) call(fc *FunctionCall) string {
+	id := fc.Id
+	if id == "" {
+		f.next++
+		id = fmt.Sprintf("call_%d", f.next)
+	}
+	f.pending[fc.FunctionName] = append(f.pending[fc.FunctionName], id)
+	return id
+}
+
+func (f *functionCallIds) response(fr *FunctionResponse) string {
+	ids := f.pending[fr.Name]
+	if fr.Id != "" {
+		for i, id := range ids {
+			if id == fr.Id {
+				f.pending[fr.Name] = append(ids[:i:i], ids[i+1:]...)
+				break
+			}
+		}
+		return fr.Id
+	}
+	if len(ids) == 0 {
+		return "call_" + fr.Name
+	}
+
+	f.pending[fr.Name] = ids[1:]
+	return ids[0]
+}
+
+// schemaGemini2OpenAI lowercases the OpenAPI types (OBJECT, STRING, ...) used by Gemini schemas
+func schemaGemini2OpenAI(schema any) any {
+	switch v := schema.(type) {
+	case map[string]any:
+		converted := make(map[string]any, len(v))
+		for key, value := range v {
+			if typ, ok := value.(string); ok && key == "type" {
+				converted[key] = strings.ToLower(typ)
+				continue
+			}
+			converted[key] = schemaGemini2OpenAI(value)
+		}
+		return converted
+	case []any:
+		converted := make([]any, len(v))
+		for i, item := range v {
+			converted[i] = schemaGemini2OpenAI(item)
+		}
+		return converted
+	default:
+		return v
+	}
+}
+
+// ConvertGenerateContentRequest converts a native generateContent request to GeneralOpenAIRequest,
+// so that it could be served by channels that do not speak the Gemini API.
+//
+// Thought parts in the conversation history are dropped,
+// and built-in tools such as googleSearch are skipped.
+func ConvertGenerateContentRequest(modelName string, request *GenerateContentRequest, isStream bool) (*model.GeneralOpenAIRequest, error) {
+	if request == nil {
+		return nil, errors.New("request is nil")
+	}
+
+	textRequest := &model.GeneralOpenAIRequest{
+		Model:  modelName,
+		Stream: isStream,
+	}
+	if isStream {
+		textRequest.StreamOptions = &model.StreamOptions{IncludeUsage: true}
+	}
+
+	if cfg := request.GenerationConfig; cfg != nil {
+		textRequest.Temperature = cfg.Temperature
+		textRequest.TopP = cfg.TopP
+		textRequest.TopK = int(cfg.TopK)
+		textRequest.MaxTokens = cfg.MaxOutputTokens
+		textRequest.PresencePenalty = cfg.PresencePenalty
+		textRequest.FrequencyPenalty = cfg.FrequencyPenalty
+		if cfg.Seed != nil {
+			textRequest.Seed = float64(*cfg.Seed)
+		}
+		if len(cfg.StopSequences) > 0 {
+			textRequest.Stop = cfg.StopSequences
+		}
+		if cfg.CandidateCount > 1 {
+			n := cfg.CandidateCount
+			textRequest.N = &n
+		}
+		if cfg.ResponseMimeType == "application/json" {
+			textRequest.ResponseFormat = &model.ResponseFormat{Type: "json_object"}
+			if schema, ok := schemaGemini2OpenAI(cfg.ResponseSchema).(map[string]any); ok {
+				textRequest.ResponseFormat = &model.ResponseFormat{
+					Type: "json_schema",
+					JsonSchema: &model.JSONSchema{
+						Name:   "response",
+						Schema: schema,
+					},
+				}
+			}
+		}
+	}
+
+	// system instruction
+	if request.SystemInstruction != nil {
+		var texts []string
+		for _, part := range request.SystemInstruction.Parts {
+			if part.Text != "" {
+				texts = append(texts, part.Text)
+			}
+		}
+		if len(texts) > 0 {
+			textRequest.Messages = append(textRequest.Messages, model.Message{
+				Role:    "system",
+				Content: strings.Join(texts, "\n"),
+			})
+		}
+	}
+
+	// contents
+	ids := &functionCallIds{pending: make(map[string][]string)}
+	for i, content := range request.Contents {
+		messages, err := convertGenerateContentContent(content, ids)
+		if err != nil {
+			return nil, errors.Wrapf(err, "convert content %d", i)
+		}
+		textRequest.Messages = append(textRequest.Messages, messages...)
+	}
+
+	// tools
+	for _, tool := range request.Tools {
+		if tool.GoogleSearch != nil || tool.CodeExecution != nil {
+			logger.Warnf(context.TODO(), "built-in gemini tools are not supported by conversion, skip them")
+		}
+		for _, function := range tool.FunctionDeclarations {
+			parameters := function.ParametersJsonSchema
+			if parameters == nil {
+				parameters, _ = schemaGemini2OpenAI(function.Parameters).(map[string]any)
+			}
+			if parameters == nil {
+				parameters = map[string]any{
+					"type":       "object",
+					"properties": map[string]any{},
+				}
+			}
+			textRequest.Tools = append(textRequest.Tools, model.Tool{
+				Type: "function",
+				Function: model.Function{
+					Name:        function.Name,
+					Description: function.Description,
+					Parameters:  parameters,
+				},
+			})
+		}
+	}
+
+	if request.ToolConfig != nil && request.ToolConfig.FunctionCallingConfig != nil {
+		cfg := request.ToolConfig.FunctionCallingConfig
+		switch strings.ToUpper(cfg.Mode) {
+		case "", "AUTO", "VALIDATED":
+			textRequest.ToolChoice = "auto"
+		case "ANY":
+			textRequest.ToolChoice = "required"
+			if len(cfg.AllowedFunctionNames) == 1 {
+				textRequest.ToolChoice = map[string]any{
+					"type": "function",
+					"function": map[string]any{
+						"name": cfg.AllowedFunctionNames[0],
+					},
+				}
+			}
+		case "NONE":
+			textRequest.ToolChoice = "none"
+		default:
+			return nil, errors.Errorf("unsupported function calling mode %q", cfg.Mode)
+		}
+	}
+
+	return textRequest, nil
+}
+
+// convertGenerateContentContent converts one Gemini content to one or more OpenAI messages,
+// function responses are split out as individual tool messages.
+func convertGenerateContentContent(content ChatContent, ids *functionCallIds) ([]model.Message, error) {
+	role := "user"
+	if content.Role == "model" {
+		role = "assistant"
+	}
+
+	var (
+		messages  []model.Message
+		parts     []any
+		texts     []string
+		toolCalls []model.Tool
+	)
+	for _, part := range content.Parts {
+		switch {
+		case part.Thought:
+			// thoughts are only meaningful to the model that produced them
+		case part.FunctionCall != nil:
+			args := part.FunctionCall.Arguments
+			if args == nil {
+				args = map[string]any{}
+			}
+			argsBytes, err := json.Marshal(args)
+			if err != nil {
+				return nil, errors.Wrapf(err, "marshal args of function %s", part.FunctionCall.FunctionName)
+			}
+			toolCalls = append(toolCalls, model.Tool{
+				Id:   ids.call(part.FunctionCall),
+				Type: "function",
+				Function: model.Function{
+					Name:      part.FunctionCall.FunctionName,
+					Arguments: string(argsBytes),
+				},
+			})
+		case part.FunctionResponse != nil:
+			result, err := json.Marshal(part.FunctionResponse.Response)
+			if err != nil {
+				return nil, errors.Wrapf(err, "marshal response of function %s", part.FunctionResponse.Name)
+			}
+			messages = append(messages, model.Message{
+				Role:       "tool",
+				Content:    string(result),
+				ToolCallId: ids.response(part.FunctionResponse),
+			})
+		case part.InlineData != nil:
+			if !strings.HasPrefix(part.InlineData.MimeType, "image/") {
+				return nil, errors.Errorf("inline data of type %q is not supported by the selected channel", part.InlineData.MimeType)
+			}
+			parts = append(parts, map[string]any{
+				"type": model.ContentTypeImageURL,
+				"image_url": map[string]any{
+					"url": fmt.Sprintf("data:%s;base64,%s", part.InlineData.MimeType, part.InlineData.Data),
+				},
+			})
+		case part.FileData != nil:
+			if !strings.HasPrefix(part.FileData.MimeType, "image/") {
+				return nil, errors.Errorf("file data of type %q is not supported by the selected channel", part.FileData.MimeType)
+			}
+			parts = append(parts, map[string]any{
+				"type": model.ContentTypeImageURL,
+				"image_url": map[string]any{
+					"url": part.FileData.FileUri,
+				},
+			})
+		case part.Text != "":
+			texts = append(texts, part.Text)
+			parts = append(parts, map[string]any{
+				"type": model.ContentTypeText,
+				"text": part.Text,
+			})
+		}
+	}
+
+	if role == "assistant" {
+		msg := model.Message{
+			Role:      role,
+			ToolCalls: toolCalls,
+		}
+		if text := strings.Join(texts, ""); text != "" {
+			msg.Content = text
+		}
+		if msg.Content != nil || len(msg.ToolCalls) > 0 {
+			messages = append(messages, msg)
+		}
+		return messages, nil
+	}
+
+	switch {
+	case len(parts) == 0:
+	case len(parts) == len(texts) && len(texts) == 1:
+		messages = append(messages, model.Message{Role: role, Content: texts[0]})
+	default:
+		messages = append(messages, model.Message{Role: role, Content: parts})
+	}
+
+	return messages, nil
+}
+
+// finishReasonOpenAI2Gemini converts an OpenAI finish_reason to a Gemini finishReason
+func finishReasonOpenAI2Gemini(reason string) string {
+	switch reason {
+	case "length":
+		return "MAX_TOKENS"
+	case "content_filter":
+		return "SAFETY"
+	default:
+		return "STOP"
+	}
+}
+
+// reasoningOfMessage returns the reasoning content of an OpenAI message, whatever format it uses
+func reasoningOfMessage(message *model.Message) string {
+	switch {
+	case message.Reasoning != nil && *message.Reasoning != "":
+		return *message.Reasoning
+	case message.ReasoningContent != nil && *message.ReasoningContent != "":
+		return *message.ReasoningContent
+	case message.Thinking != nil && *message.Thinking != "":
+		return *message.Thinking
+	}
+
+	return ""
+}
+
+// functionCallPart converts an OpenAI tool call to a functionCall part
+func functionCallPart(id, name string, arguments any) Part {
+	args := map[string]any{}
+	if argsText, ok := arguments.(string); ok && argsText != "" {
+		if err := json.Unmarshal([]byte(argsText), &args); err != nil {
+			logger.Warnf(context.TODO(), "unmarshal arguments of tool %s: %+v", name, err)
+		}
+	}
+
+	return Part{
+		FunctionCall: &FunctionCall{
+			Id:           id,
+			FunctionName: name,
+			Arguments:    args,
+		},
+	}
+}
+
+// usageOpenAI2Gemini converts an OpenAI usage to Gemini usage metadata
+func usageOpenAI2Gemini(usage *model.Usage) *UsageMetadata {
+	if usage == nil {
+		return nil
+	}
+
+	metadata := &UsageMetadata{
+		PromptTokenCount:     usage.PromptTokens,
+		CandidatesTokenCount: usage.CompletionTokens,
+		TotalTokenCount:      usage.PromptTokens + usage.CompletionTokens,
+	}
+	if usage.PromptTokensDetails != nil {
+		metadata.CachedContentTokenCount = usage.PromptTokensDetails.CachedTokens
+	}
+	if usage.CompletionTokensDetails != nil && usage.CompletionTokensDetails.ReasoningTokens > 0 &&
+		usage.CompletionTokensDetails.ReasoningTokens <= usage.CompletionTokens {
+		metadata.ThoughtsTokenCount = usage.CompletionTokensDetails.ReasoningTokens
+		metadata.CandidatesTokenCount -= metadata.ThoughtsTokenCount
+	}
+
+	return metadata
+}
+
+// ConvertOpenAIResponse converts an OpenAI chat completion to a generateContent response
+func ConvertOpenAIResponse(response *openai.TextResponse) *GenerateContentResponse {
+	geminiResponse := &GenerateContentResponse{
+		Candidates:    make([]ChatCandidate, 0, len(response.Choices)),
+		UsageMetadata: usageOpenAI2Gemini(&response.Usage),
+		ModelVersion:  response.Model,
+		ResponseId:    response.Id,
+	}
+
+	for _, choice := range response.Choices {
+		parts := []Part{}
+		if reasoning := reasoningOfMessage(&choice.Message); reasoning != "" {
+			parts = append(parts, Part{Text: reasoning, Thought: true})
+		}
+		if text := choice.Message.StringContent(); text != "" {
+			parts = append(parts, Part{Text: text})
+		}
+		for _, tool := range choice.Message.ToolCalls {
+			parts = append(parts, functionCallPart(tool.Id, tool.Function.Name, tool.Function.Arguments))
+		}
+
+		geminiResponse.Candidates = append(geminiResponse.Candidates, ChatCandidate{
+			Content: ChatContent{
+				Role:  "model",
+				Parts: parts,
+			},
+			FinishReason: finishReasonOpenAI2Gemini(choice.FinishReason),
+			Index:        int64(choice.Index),
+		})
+	}
+
+	return geminiResponse
+}
+
+// ConvertErrorToGemini converts a relay error to the error body of the Gemini API
+func ConvertErrorToGemini(err *model.ErrorWithStatusCode) *ErrorResponse {
+	status := "INTERNAL"
+	switch err.StatusCode {
+	case http.StatusBadRequest:
+		status = "INVALID_ARGUMENT"
+	case http.StatusUnauthorized:
+		status = "UNAUTHENTICATED"
+	case http.StatusForbidden:
+		status = "PERMISSION_DENIED"
+	case http.StatusNotFound:
+		status = "NOT_FOUND"
+	case http.StatusTooManyRequests:
+		status = "RESOURCE_EXHAUSTED"
+	case http.StatusNotImplemented:
+		status = "UNIMPLEMENTED"
+	case http.StatusServiceUnavailable:
+		status = "UNAVAILABLE"
+	case http.StatusGatewayTimeout:
+		status = "DEADLINE_EXCEEDED"
+	}
+
+	return &ErrorResponse{
+		Error: Error{
+			Code:    err.StatusCode,
+			Message: err.Message,
+			Status:  status,
+		},
+	}
+}
+
+// streamToolCall accumulates the fragments of a streamed OpenAI tool call
+type streamToolCall struct {
+	id        string
+	name      string
+	arguments strings.Builder
+}
+
+// streamCandidate is the converter state of one OpenAI choice
+type streamCandidate struct {
+	finishReason string
+	toolCalls    map[int]*streamToolCall
+}
+
+// OpenAIStreamConverter converts OpenAI chat completion chunks to streamGenerateContent events.
+//
+// Text and thoughts are forwarded as they arrive, while function calls are buffered
+// until the end of the stream, since Gemini never splits the args of a function call.
+type OpenAIStreamConverter struct {
+	id         string
+	model      string
+	candidates map[int]*streamCandidate
+	usage      *model.Usage
+}
+
+// NewOpenAIStreamConverter creates a converter, modelName is reported as the modelVersion
+func NewOpenAIStreamConverter(modelName string) *OpenAIStreamConverter {
+	return &OpenAIStreamConverter{
+		model:      modelName,
+		candidates: make(map[int]*streamCandidate),
+	}
+}
+
+func (s *OpenAIStreamConverter) candidate(index int) *streamCandidate {
+	candidate, ok := s.candidates[index]
+	if !ok {
+		candidate = &streamCandidate{toolCalls: make(map[int]*streamToolCall)}
+		s.candidates[index] = candidate
+	}
+	return candidate
+}
+
+// Convert converts one OpenAI chunk to a streamGenerateContent event, nil means nothing to send yet
+func (s *OpenAIStreamConverter) Convert(chunk *openai.ChatCompletionsStreamResponse) *GenerateContentResponse {
+	if s.id == "" {
+		s.id = chunk.Id
+	}
+	if chunk.Usage != nil {
+		s.usage = chunk.Usage
+	}
+
+	response := &GenerateContentResponse{
+		ModelVersion: s.model,
+		ResponseId:   s.id,
+	}
+	for _, choice := range chunk.Choices {
+		candidate := s.candidate(choice.Index)
+
+		var parts []Part
+		if reasoning := reasoningOfMessage(&choice.Delta); reasoning != "" {
+			parts = append(parts, Part{Text: reasoning, Thought: true})
+		}
+		if text, ok := choice.Delta.Content.(string); ok && text != "" {
+			parts = append(parts, Part{Text: text})
+		}
+		for i, tool := range choice.Delta.ToolCalls {
+			toolIndex := i
+			if tool.Index != nil {
+				toolIndex = *tool.Index
+			}
+			call, ok := candidate.toolCalls[toolIndex]
+			if !ok {
+				call = &streamToolCall{}
+				candidate.toolCalls[toolIndex] = call
+			}
+			if tool.Id != "" {
+				call.id = tool.Id
+			}
+			if tool.Function.Name != "" {
+				call.name = tool.Function.Name
+			}
+			if args, ok := tool.Function.Arguments.(string); ok {
+				call.arguments.WriteString(args)
+			}
+		}
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			candidate.finishReason = *choice.FinishReason
+		}
+
+		if len(parts) > 0 {
+			response.Candidates = append(response.Candidates, ChatCandidate{
+				Content: ChatContent{
+					Role:  "model",
+					Parts: parts,
+				},
+				Index: int64(choice.Index),
+			})
+		}
+	}
+
+	if len(response.Candidates) == 0 {
+		return nil
+	}
+	return response
+}
+
+// Finish builds the last event carrying the buffered function calls, finish reasons and usage,
+// usage is the billed usage of the request, the last usage reported by upstream is used if it is nil.
+func (s *OpenAIStreamConverter) Finish(usage *model.Usage) *GenerateContentResponse {
+	if usage == nil {
+		usage = s.usage
+	}
+	if len(s.candidates) == 0 {
+		s.candidate(0)
+	}
+
+	indexes := make([]int, 0, len(s.candidates))
+	for index := range s.candidates {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	response := &GenerateContentResponse{
+		UsageMetadata: usageOpenAI2Gemini(usage),
+		ModelVersion:  s.model,
+		ResponseId:    s.id,
+	}
+	for _, index := range indexes {
+		candidate := s.candidates[index]
+
+		toolIndexes := make([]int, 0, len(candidate.toolCalls))
+		for toolIndex := range candidate.toolCalls {
+			toolIndexes = append(toolIndexes, toolIndex)
+		}
+		sort.Ints(toolIndexes)
+
+		parts := []Part{}
+		for _, toolIndex := range toolIndexes {
+			call := candidate.toolCalls[toolIndex]
+			parts = append(parts, functionCallPart(call.id, call.name, call.arguments.String()))
+		}
+
+		response.Candidates = append(response.Candidates, ChatCandidate{
+			Content: ChatContent{
+				Role:  "model",
+				Parts: parts,
+			},
+			FinishReason: finishReasonOpenAI2Gemini(candidate.finishReason),
+			Index:        int64(index),
+		})
+	}
+
+	return response
+}
+
+// RenderGenerateContentStreamEvent writes one server-sent event of streamGenerateContent
+func RenderGenerateContentStreamEvent(w gin.ResponseWriter, response *GenerateContentResponse) error {
+	data, err := json.Marshal(response)
+	if err != nil {
+		return errors.Wrap(err, "marshal stream event")
+	}
+
+	if _, err = fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+		return errors.Wrap(err, "write stream event")
+	}
+	w.Flush()
+	return nil
+}
+
+// usageFromMetadata converts Gemini usage metadata to usage,
+// thoughts are billed as completion tokens.
+func usageFromMetadata(metadata *UsageMetadata) *model.Usage {
+	if metadata == nil || metadata.TotalTokenCount == 0 {
+		return nil
+	}
+
+	completionTokens := metadata.TotalTokenCount - metadata.PromptTokenCount
+	usage := &model.Usage{
+		PromptTokens:     metadata.PromptTokenCount,
+		CompletionTokens: completionTokens,
+		TotalTokens:      metadata.TotalTokenCount,
+	}
+
+	// modalities of the prompt include the cached content, which is billed as cached tokens
+	audioTokens := tokensOfModality(metadata.PromptTokensDetails, "AUDIO") -
+		tokensOfModality(metadata.CacheTokensDetails, "AUDIO")
+	imageTokens := tokensOfModality(metadata.PromptTokensDetails, "IMAGE") -
+		tokensOfModality(metadata.CacheTokensDetails, "IMAGE")
+	if metadata.CachedContentTokenCount > 0 || audioTokens > 0 || imageTokens > 0 {
+		usage.PromptTokensDetails = &model.UsagePromptTokensDetails{
+			CachedTokens: metadata.CachedContentTokenCount,
+			AudioTokens:  max(audioTokens, 0),
+			ImageTokens:  max(imageTokens, 0),
+		}
+	}
+	outputAudioTokens := tokensOfModality(metadata.CandidatesTokensDetails, "AUDIO")
+	if metadata.ThoughtsTokenCount > 0 || outputAudioTokens > 0 {
+		usage.CompletionTokensDetails = &model.UsageCompletionTokensDetails{
+			ReasoningTokens: metadata.ThoughtsTokenCount,
+			AudioTokens:     outputAudioTokens,
+		}
+	}
+
+	return usage
+}
+
+// textOfResponse joins the non-thought text of the first candidate
+func textOfResponse(response *GenerateContentResponse) string {
+	if len(response.Candidates) == 0 {
+		return ""
+	}
+
+	var builder strings.Builder
+	for _, part := range response.Candidates[0].Content.Parts {
+		if !part.Thought {
+			builder.WriteString(part.Text)
+		}
+	}
+	return builder.String()
+}
+
+// GenerateContentHandler passes a native generateContent response through to the client
+// and extracts the usage from it, the usage is estimated if upstream does not report it.
+func GenerateContentHandler(c *gin.Context, resp *http.Response, promptTokens int, modelName string) (*model.ErrorWithStatusCode, *model.Usage) {
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return openai.ErrorWrapper(err, "read_response_body_failed", http.StatusInternalServerError), nil
+	}
+	if err = resp.Body.Close(); err != nil {
+		return openai.ErrorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), nil
+	}
+
+	logger.Debugf(c.Request.Context(), "generateContent response <- %s\n", string(responseBody))
+
+	var geminiResponse GenerateContentResponse
+	if err = json.Unmarshal(responseBody, &geminiResponse); err != nil {
+		return openai.ErrorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError), nil
+	}
+
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.WriteHeader(resp.StatusCode)
+	if _, err = c.Writer.Write(responseBody); err != nil {
+		return openai.ErrorWrapper(err, "write_response_body_failed", http.StatusInternalServerError), nil
+	}
+
+	usage := usageFromMetadata(geminiResponse.UsageMetadata)
+	if usage == nil {
+		usage = openai.ResponseText2Usage(textOfResponse(&geminiResponse), modelName, promptTokens)
+	}
+	return nil, usage
+}
+
+// GenerateContentStreamHandler passes a native streamGenerateContent stream through to the client
+// and extracts the usage from the last usageMetadata, the usage is estimated if upstream does not report it.
+func GenerateContentStreamHandler(c *gin.Context, resp *http.Response, promptTokens int, modelName string) (*model.ErrorWithStatusCode, *model.Usage) {
+	scanner := bufio.NewScanner(resp.Body)
+	buffer := make([]byte, 10*1024*1024) // 10MB buffer
+	scanner.Buffer(buffer, len(buffer))
+	scanner.Split(bufio.ScanLines)
+
+	common.SetEventStreamHeaders(c)
+
+	var (
+		usage        *model.Usage
+		responseText strings.Builder
+	)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if _, err := c.Writer.Write([]byte(line + "\n")); err != nil {
+			return openai.ErrorWrapper(err, "write_stream_failed", http.StatusInternalServerError), nil
+		}
+		if line == "" {
+			c.Writer.Flush()
+			continue
+		}
+
+		data, ok := strings.CutPrefix(line, "data:")
+		if !ok {
+			continue
+		}
+
+		var geminiResponse GenerateContentResponse
+		if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &geminiResponse); err != nil {
+			logger.Warnf(c.Request.Context(), "unmarshal stream event %q failed: %+v", data, err)
+			continue
+		}
+		responseText.WriteString(textOfResponse(&geminiResponse))
+		if chunkUsage := usageFromMetadata(geminiResponse.UsageMetadata); chunkUsage != nil {
+			usage = chunkUsage
+		}
+	}
+	c.Writer.Flush()
+	if err := scanner.Err(); err != nil {
+		logger.Errorf(c.Request.Context(), "error reading stream: %+v", errors.Wrap(err, "scan stream"))
+	}
+
+	if err := resp.Body.Close(); err != nil {
+		return openai.ErrorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), nil
+	}
+
+	if usage == nil {
+		usage = openai.ResponseText2Usage(responseText.String(), modelName, promptTokens)
+	}
+	return nil, usage
+}
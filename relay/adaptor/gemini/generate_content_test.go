@@ -0,0 +1,237 @@
+package gemini
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+func TestConvertGenerateContentRequest(t *testing.T) {
+	raw := `{
+		"systemInstruction": {"parts": [{"text": "be brief"}]},
+		"generationConfig": {
+			"temperature": 0.2,
+			"maxOutputTokens": 256,
+			"stopSequences": ["END"],
+			"responseMimeType": "application/json",
+			"responseSchema": {"type": "OBJECT", "properties": {"city": {"type": "STRING"}}}
+		},
+		"tools": [
+			{"functionDeclarations": [{"name": "get_weather", "description": "weather", "parameters": {"type": "OBJECT", "properties": {"city": {"type": "STRING"}}}}]},
+			{"googleSearch": {}}
+		],
+		"toolConfig": {"functionCallingConfig": {"mode": "ANY", "allowedFunctionNames": ["get_weather"]}},
+		"contents": [
+			{"role": "user", "parts": [{"text": "weather in Paris?"}]},
+			{"role": "model", "parts": [
+				{"text": "planning", "thought": true},
+				{"text": "Let me check."},
+				{"functionCall": {"name": "get_weather", "args": {"city": "Paris"}}}
+			]},
+			{"role": "user", "parts": [
+				{"functionResponse": {"name": "get_weather", "response": {"result": "sunny"}}},
+				{"text": "and tomorrow?"},
+				{"inlineData": {"mimeType": "image/png", "data": "AAAA"}}
+			]}
+		]
+	}`
+
+	request := new(GenerateContentRequest)
+	require.NoError(t, json.Unmarshal([]byte(raw), request))
+
+	textRequest, err := ConvertGenerateContentRequest("gemini-2.0-flash", request, true)
+	require.NoError(t, err)
+
+	assert.Equal(t, "gemini-2.0-flash", textRequest.Model)
+	assert.True(t, textRequest.Stream)
+	require.NotNil(t, textRequest.StreamOptions)
+	assert.True(t, textRequest.StreamOptions.IncludeUsage)
+	assert.Equal(t, 256, textRequest.MaxTokens)
+	assert.Equal(t, 0.2, *textRequest.Temperature)
+	assert.Equal(t, []string{"END"}, textRequest.Stop)
+
+	require.NotNil(t, textRequest.ResponseFormat)
+	assert.Equal(t, "json_schema", textRequest.ResponseFormat.Type)
+	assert.Equal(t, "object", textRequest.ResponseFormat.JsonSchema.Schema["type"])
+
+	// built-in tools are skipped, schema types are lowercased
+	require.Len(t, textRequest.Tools, 1)
+	assert.Equal(t, "get_weather", textRequest.Tools[0].Function.Name)
+	assert.Equal(t, "string", textRequest.Tools[0].Function.Parameters["properties"].(map[string]any)["city"].(map[string]any)["type"])
+	assert.Equal(t, map[string]any{
+		"type":     "function",
+		"function": map[string]any{"name": "get_weather"},
+	}, textRequest.ToolChoice)
+
+	require.Len(t, textRequest.Messages, 5)
+	assert.Equal(t, "system", textRequest.Messages[0].Role)
+	assert.Equal(t, "be brief", textRequest.Messages[0].Content)
+	assert.Equal(t, "user", textRequest.Messages[1].Role)
+	assert.Equal(t, "weather in Paris?", textRequest.Messages[1].Content)
+
+	assistant := textRequest.Messages[2]
+	assert.Equal(t, "assistant", assistant.Role)
+	assert.Equal(t, "Let me check.", assistant.Content)
+	require.Len(t, assistant.ToolCalls, 1)
+	assert.JSONEq(t, `{"city":"Paris"}`, assistant.ToolCalls[0].Function.Arguments.(string))
+
+	// function responses are paired with their calls by name
+	tool := textRequest.Messages[3]
+	assert.Equal(t, "tool", tool.Role)
+	assert.Equal(t, assistant.ToolCalls[0].Id, tool.ToolCallId)
+	assert.JSONEq(t, `{"result":"sunny"}`, tool.Content.(string))
+
+	user := textRequest.Messages[4]
+	parts := user.ParseContent()
+	require.Len(t, parts, 2)
+	assert.Equal(t, "and tomorrow?", *parts[0].Text)
+	assert.Equal(t, "data:image/png;base64,AAAA", parts[1].ImageURL.Url)
+}
+
+func TestConvertGenerateContentRequest_Unsupported(t *testing.T) {
+	_, err := ConvertGenerateContentRequest("m", &GenerateContentRequest{
+		Contents: []ChatContent{{
+			Role:  "user",
+			Parts: []Part{{InlineData: &InlineData{MimeType: "audio/wav", Data: "AAAA"}}},
+		}},
+	}, false)
+	require.Error(t, err)
+}
+
+func TestConvertOpenAIResponse(t *testing.T) {
+	reasoning := "thinking hard"
+	response := &openai.TextResponse{
+		Id:    "chatcmpl-abc",
+		Model: "gemini-2.0-flash",
+		Choices: []openai.TextResponseChoice{{
+			Message: model.Message{
+				Role:      "assistant",
+				Content:   "hello",
+				Reasoning: &reasoning,
+				ToolCalls: []model.Tool{{
+					Id:       "call_1",
+					Type:     "function",
+					Function: model.Function{Name: "f", Arguments: `{"a":1}`},
+				}},
+			},
+			FinishReason: "length",
+		}},
+		Usage: model.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15},
+	}
+
+	geminiResponse := ConvertOpenAIResponse(response)
+	assert.Equal(t, "chatcmpl-abc", geminiResponse.ResponseId)
+	require.NotNil(t, geminiResponse.UsageMetadata)
+	assert.Equal(t, 10, geminiResponse.UsageMetadata.PromptTokenCount)
+	assert.Equal(t, 5, geminiResponse.UsageMetadata.CandidatesTokenCount)
+	assert.Equal(t, 15, geminiResponse.UsageMetadata.TotalTokenCount)
+
+	require.Len(t, geminiResponse.Candidates, 1)
+	candidate := geminiResponse.Candidates[0]
+	assert.Equal(t, "MAX_TOKENS", candidate.FinishReason)
+	assert.Equal(t, "model", candidate.Content.Role)
+	require.Len(t, candidate.Content.Parts, 3)
+	assert.True(t, candidate.Content.Parts[0].Thought)
+	assert.Equal(t, reasoning, candidate.Content.Parts[0].Text)
+	assert.Equal(t, "hello", candidate.Content.Parts[1].Text)
+	assert.Equal(t, "f", candidate.Content.Parts[2].FunctionCall.FunctionName)
+	assert.Equal(t, map[string]any{"a": float64(1)}, candidate.Content.Parts[2].FunctionCall.Arguments)
+}
+
+func TestOpenAIStreamConverter(t *testing.T) {
+	chunk := func(raw string) *openai.ChatCompletionsStreamResponse {
+		resp := new(openai.ChatCompletionsStreamResponse)
+		require.NoError(t, json.Unmarshal([]byte(raw), resp))
+		return resp
+	}
+
+	converter := NewOpenAIStreamConverter("gemini-x")
+
+	event := converter.Convert(chunk(`{"id":"chatcmpl-1","choices":[{"index":0,"delta":{"role":"assistant","content":"Hi"}}]}`))
+	require.NotNil(t, event)
+	assert.Equal(t, "Hi", event.Candidates[0].Content.Parts[0].Text)
+	assert.Empty(t, event.Candidates[0].FinishReason)
+
+	// function call fragments are buffered until the end
+	assert.Nil(t, converter.Convert(chunk(`{"id":"chatcmpl-1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"f","arguments":"{\"a\""}}]}}]}`)))
+	assert.Nil(t, converter.Convert(chunk(`{"id":"chatcmpl-1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":":1}"}}]}}]}`)))
+	assert.Nil(t, converter.Convert(chunk(`{"id":"chatcmpl-1","choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`)))
+
+	last := converter.Finish(&model.Usage{PromptTokens: 4, CompletionTokens: 6, TotalTokens: 10})
+	assert.Equal(t, "chatcmpl-1", last.ResponseId)
+	assert.Equal(t, 10, last.UsageMetadata.TotalTokenCount)
+	require.Len(t, last.Candidates, 1)
+	assert.Equal(t, "STOP", last.Candidates[0].FinishReason)
+	require.Len(t, last.Candidates[0].Content.Parts, 1)
+	call := last.Candidates[0].Content.Parts[0].FunctionCall
+	assert.Equal(t, "f", call.FunctionName)
+	assert.Equal(t, map[string]any{"a": float64(1)}, call.Arguments)
+}
+
+func TestGenerateContentStreamHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1beta/models/gemini-2.0-flash:streamGenerateContent?alt=sse", nil)
+
+	upstream := strings.Join([]string{
+		`data: {"candidates":[{"content":{"role":"model","parts":[{"text":"Hel"}]}}],"usageMetadata":{"promptTokenCount":8,"totalTokenCount":8}}`,
+		``,
+		`data: {"candidates":[{"content":{"role":"model","parts":[{"text":"lo"}]},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":8,"candidatesTokenCount":2,"thoughtsTokenCount":3,"totalTokenCount":13}}`,
+		``,
+	}, "\n")
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(upstream)),
+	}
+
+	errResp, usage := GenerateContentStreamHandler(c, resp, 1, "gemini-2.0-flash")
+	require.Nil(t, errResp)
+	assert.Equal(t, 8, usage.PromptTokens)
+	// thoughts are billed as completion
+	assert.Equal(t, 5, usage.CompletionTokens)
+	assert.Equal(t, upstream, w.Body.String())
+}
+
+func TestUsageFromMetadata(t *testing.T) {
+	assert.Nil(t, usageFromMetadata(nil))
+
+	usage := usageFromMetadata(&UsageMetadata{
+		PromptTokenCount:        100,
+		CandidatesTokenCount:    10,
+		ThoughtsTokenCount:      5,
+		TotalTokenCount:         115,
+		CachedContentTokenCount: 60,
+		PromptTokensDetails:     []PromptTokensDetails{{Modality: "TEXT", TokenCount: 50}, {Modality: "AUDIO", TokenCount: 50}},
+		CacheTokensDetails:      []PromptTokensDetails{{Modality: "TEXT", TokenCount: 30}, {Modality: "AUDIO", TokenCount: 30}},
+	})
+	require.NotNil(t, usage)
+	assert.Equal(t, 100, usage.PromptTokens)
+	assert.Equal(t, 15, usage.CompletionTokens)
+	require.NotNil(t, usage.PromptTokensDetails)
+	assert.Equal(t, 60, usage.PromptTokensDetails.CachedTokens)
+	// audio tokens read from the cache are billed as cached tokens
+	assert.Equal(t, 20, usage.PromptTokensDetails.AudioTokens)
+	require.NotNil(t, usage.CompletionTokensDetails)
+	assert.Equal(t, 5, usage.CompletionTokensDetails.ReasoningTokens)
+}
+
+func TestConvertErrorToGemini(t *testing.T) {
+	resp := ConvertErrorToGemini(&model.ErrorWithStatusCode{
+		Error:      model.Error{Message: "slow down"},
+		StatusCode: http.StatusTooManyRequests,
+	})
+	assert.Equal(t, http.StatusTooManyRequests, resp.Error.Code)
+	assert.Equal(t, "RESOURCE_EXHAUSTED", resp.Error.Status)
+	assert.Equal(t, "slow down", resp.Error.Message)
+}
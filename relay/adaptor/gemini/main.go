@@ -356,6 +356,18 @@ func ConvertRequest(textRequest model.GeneralOpenAIRequest) *ChatRequest {
 						Data:     data,
 					},
 				})
+			} else if part.Type == model.ContentTypeFile && part.File != nil {
+				mimeType, data, err := part.File.ParseFileData()
+				if err != nil {
+					logger.SysWarn("skip file without valid file_data: " + err.Error())
+					continue
+				}
+				parts = append(parts, Part{
+					InlineData: &InlineData{
+						MimeType: mimeType,
+						Data:     data,
+					},
+				})
 			}
 		}
 
@@ -441,6 +453,7 @@ func ConvertEmbeddingRequest(request model.GeneralOpenAIRequest) *BatchEmbedding
 type ChatResponse struct {
 	Candidates     []ChatCandidate    `json:"candidates"`
 	PromptFeedback ChatPromptFeedback `json:"promptFeedback"`
+	UsageMetadata  *UsageMetadata     `json:"usageMetadata,omitempty"`
 }
 
 func (g *ChatResponse) GetResponseText() string {
@@ -455,9 +468,9 @@ func (g *ChatResponse) GetResponseText() string {
 
 type ChatCandidate struct {
 	Content       ChatContent        `json:"content"`
-	FinishReason  string             `json:"finishReason"`
+	FinishReason  string             `json:"finishReason,omitempty"`
 	Index         int64              `json:"index"`
-	SafetyRatings []ChatSafetyRating `json:"safetyRatings"`
+	SafetyRatings []ChatSafetyRating `json:"safetyRatings,omitempty"`
 }
 
 type ChatSafetyRating struct {
@@ -688,8 +701,11 @@ func embeddingResponseGemini2OpenAI(response *EmbeddingResponse) *openai.Embeddi
 	return &openAIEmbeddingResponse
 }
 
-func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusCode, string) {
+// StreamHandler converts a streamGenerateContent stream to the OpenAI format,
+// the usage is counted from the response text if upstream does not report it.
+func StreamHandler(c *gin.Context, resp *http.Response, promptTokens int, modelName string) (*model.ErrorWithStatusCode, *model.Usage) {
 	responseText := ""
+	var usage *model.Usage
 	scanner := bufio.NewScanner(resp.Body)
 	scanner.Split(bufio.ScanLines)
 
@@ -714,6 +730,10 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 			logger.SysError("error unmarshalling stream response: " + errors.Wrap(err, "unmarshal stream").Error())
 			continue
 		}
+		// usage metadata of each chunk is cumulative
+		if chunkUsage := usageFromMetadata(geminiResponse.UsageMetadata); chunkUsage != nil {
+			usage = chunkUsage
+		}
 
 		response := streamResponseGeminiChat2OpenAI(&geminiResponse)
 		if response == nil {
@@ -735,10 +755,13 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 
 	err := resp.Body.Close()
 	if err != nil {
-		return openai.ErrorWrapper(errors.Wrap(err, "close_response_body_failed"), "close_response_body_failed", http.StatusInternalServerError), ""
+		return openai.ErrorWrapper(errors.Wrap(err, "close_response_body_failed"), "close_response_body_failed", http.StatusInternalServerError), nil
 	}
 
-	return nil, responseText
+	if usage == nil {
+		usage = openai.ResponseText2Usage(responseText, modelName, promptTokens)
+	}
+	return nil, usage
 }
 
 func Handler(c *gin.Context, resp *http.Response, promptTokens int, modelName string) (*model.ErrorWithStatusCode, *model.Usage) {
@@ -768,11 +791,16 @@ func Handler(c *gin.Context, resp *http.Response, promptTokens int, modelName st
 	}
 	fullTextResponse := responseGeminiChat2OpenAI(&geminiResponse)
 	fullTextResponse.Model = modelName
-	completionTokens := openai.CountTokenText(geminiResponse.GetResponseText(), modelName)
-	usage := model.Usage{
-		PromptTokens:     promptTokens,
-		CompletionTokens: completionTokens,
-		TotalTokens:      promptTokens + completionTokens,
+	var usage model.Usage
+	if metadataUsage := usageFromMetadata(geminiResponse.UsageMetadata); metadataUsage != nil {
+		usage = *metadataUsage
+	} else {
+		completionTokens := openai.CountTokenText(geminiResponse.GetResponseText(), modelName)
+		usage = model.Usage{
+			PromptTokens:     promptTokens,
+			CompletionTokens: completionTokens,
+			TotalTokens:      promptTokens + completionTokens,
+		}
 	}
 	fullTextResponse.Usage = usage
 	jsonResponse, err := json.Marshal(fullTextResponse)
@@ -11,9 +11,26 @@ type ChatRequest struct {
 }
 
 type UsageMetadata struct {
-	PromptTokenCount    int                   `json:"promptTokenCount,omitempty"`
-	TotalTokenCount     int                   `json:"totalTokenCount,omitempty"`
-	PromptTokensDetails []PromptTokensDetails `json:"promptTokensDetails,omitempty"`
+	PromptTokenCount     int                   `json:"promptTokenCount,omitempty"`
+	CandidatesTokenCount int                   `json:"candidatesTokenCount,omitempty"`
+	ThoughtsTokenCount   int                   `json:"thoughtsTokenCount,omitempty"`
+	TotalTokenCount      int                   `json:"totalTokenCount,omitempty"`
+	PromptTokensDetails  []PromptTokensDetails `json:"promptTokensDetails,omitempty"`
+	// CachedContentTokenCount is the part of the prompt read from the context cache
+	CachedContentTokenCount int                   `json:"cachedContentTokenCount,omitempty"`
+	CacheTokensDetails      []PromptTokensDetails `json:"cacheTokensDetails,omitempty"`
+	CandidatesTokensDetails []PromptTokensDetails `json:"candidatesTokensDetails,omitempty"`
+}
+
+// tokensOfModality returns the token count of modality in details, e.g. "AUDIO"
+func tokensOfModality(details []PromptTokensDetails, modality string) int {
+	count := 0
+	for _, detail := range details {
+		if detail.Modality == modality {
+			count += detail.TokenCount
+		}
+	}
+	return count
 }
 
 type PromptTokensDetails struct {
@@ -53,15 +70,30 @@ type InlineData struct {
 	Data     string `json:"data"`
 }
 
+type FileData struct {
+	MimeType string `json:"mimeType,omitempty"`
+	FileUri  string `json:"fileUri"`
+}
+
 type FunctionCall struct {
+	Id           string `json:"id,omitempty"`
 	FunctionName string `json:"name"`
 	Arguments    any    `json:"args"`
 }
 
+type FunctionResponse struct {
+	Id       string `json:"id,omitempty"`
+	Name     string `json:"name"`
+	Response any    `json:"response"`
+}
+
 type Part struct {
-	Text         string        `json:"text,omitempty"`
-	InlineData   *InlineData   `json:"inlineData,omitempty"`
-	FunctionCall *FunctionCall `json:"functionCall,omitempty"`
+	Text             string            `json:"text,omitempty"`
+	Thought          bool              `json:"thought,omitempty"`
+	InlineData       *InlineData       `json:"inlineData,omitempty"`
+	FileData         *FileData         `json:"fileData,omitempty"`
+	FunctionCall     *FunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *FunctionResponse `json:"functionResponse,omitempty"`
 }
 
 type ChatContent struct {
@@ -88,6 +120,9 @@ type ChatGenerationConfig struct {
 	CandidateCount     int      `json:"candidateCount,omitempty"`
 	StopSequences      []string `json:"stopSequences,omitempty"`
 	ResponseModalities []string `json:"responseModalities,omitempty"`
+	PresencePenalty    *float64 `json:"presencePenalty,omitempty"`
+	FrequencyPenalty   *float64 `json:"frequencyPenalty,omitempty"`
+	Seed               *int     `json:"seed,omitempty"`
 }
 
 type FunctionCallingConfig struct {
@@ -98,3 +133,49 @@ type FunctionCallingConfig struct {
 type ToolConfig struct {
 	FunctionCallingConfig FunctionCallingConfig `json:"function_calling_config"`
 }
+
+// GenerateContentRequest is the body of an inbound generateContent request,
+// field names follow the camelCase used by the Google GenAI SDKs.
+type GenerateContentRequest struct {
+	Contents          []ChatContent              `json:"contents"`
+	SystemInstruction *ChatContent               `json:"systemInstruction,omitempty"`
+	GenerationConfig  *ChatGenerationConfig      `json:"generationConfig,omitempty"`
+	SafetySettings    []ChatSafetySettings       `json:"safetySettings,omitempty"`
+	Tools             []GenerateContentTool      `json:"tools,omitempty"`
+	ToolConfig        *GenerateContentToolConfig `json:"toolConfig,omitempty"`
+}
+
+type GenerateContentTool struct {
+	FunctionDeclarations []FunctionDeclaration `json:"functionDeclarations,omitempty"`
+	GoogleSearch         any                   `json:"googleSearch,omitempty"`
+	CodeExecution        any                   `json:"codeExecution,omitempty"`
+}
+
+type FunctionDeclaration struct {
+	Name                 string         `json:"name"`
+	Description          string         `json:"description,omitempty"`
+	Parameters           map[string]any `json:"parameters,omitempty"`
+	ParametersJsonSchema map[string]any `json:"parametersJsonSchema,omitempty"`
+}
+
+type GenerateContentToolConfig struct {
+	FunctionCallingConfig *GenerateContentFunctionCallingConfig `json:"functionCallingConfig,omitempty"`
+}
+
+type GenerateContentFunctionCallingConfig struct {
+	Mode                 string   `json:"mode,omitempty"`
+	AllowedFunctionNames []string `json:"allowedFunctionNames,omitempty"`
+}
+
+// GenerateContentResponse is the body of a generateContent response,
+// and also the payload of every streamGenerateContent event.
+type GenerateContentResponse struct {
+	Candidates    []ChatCandidate `json:"candidates"`
+	UsageMetadata *UsageMetadata  `json:"usageMetadata,omitempty"`
+	ModelVersion  string          `json:"modelVersion,omitempty"`
+	ResponseId    string          `json:"responseId,omitempty"`
+}
+
+type ErrorResponse struct {
+	Error Error `json:"error"`
+}
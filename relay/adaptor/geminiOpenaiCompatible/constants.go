@@ -7,6 +7,13 @@ import (
 	"github.com/songquanpeng/one-api/relay/billing/ratio"
 )
 
+// Prompts longer than the thresholds of these tiers are billed at higher prices
+var (
+	gemini15FlashTiers = []adaptor.PriceTier{{InputTokenThreshold: 128000, Ratio: 0.15 * ratio.MilliTokensUsd, CompletionRatio: 4}}
+	gemini15ProTiers   = []adaptor.PriceTier{{InputTokenThreshold: 128000, Ratio: 2.5 * ratio.MilliTokensUsd, CompletionRatio: 4}}
+	gemini25ProTiers   = []adaptor.PriceTier{{InputTokenThreshold: 200000, Ratio: 2.5 * ratio.MilliTokensUsd, CompletionRatio: 6}}
+)
+
 // ModelRatios contains all supported models and their pricing ratios
 // Model list is derived from the keys of this map, eliminating redundancy
 // Based on Google AI pricing: https://ai.google.dev/pricing
@@ -22,38 +29,38 @@ var ModelRatios = map[string]adaptor.ModelConfig{
 	"gemma-3-27b-it": {Ratio: 0.35 * ratio.MilliTokensUsd, CompletionRatio: 1.4},
 
 	// Gemini 1.5 Flash Models
-	"gemini-1.5-flash":    {Ratio: 0.075 * ratio.MilliTokensUsd, CompletionRatio: 4},
-	"gemini-1.5-flash-8b": {Ratio: 0.0375 * ratio.MilliTokensUsd, CompletionRatio: 4},
+	"gemini-1.5-flash":    {Ratio: 0.075 * ratio.MilliTokensUsd, CompletionRatio: 4, CachedInputRatio: 0.25, Tiers: gemini15FlashTiers},
+	"gemini-1.5-flash-8b": {Ratio: 0.0375 * ratio.MilliTokensUsd, CompletionRatio: 4, CachedInputRatio: 0.25},
 
 	// Gemini 1.5 Pro Models
-	"gemini-1.5-pro":              {Ratio: 1.25 * ratio.MilliTokensUsd, CompletionRatio: 4},
-	"gemini-1.5-pro-experimental": {Ratio: 1.25 * ratio.MilliTokensUsd, CompletionRatio: 4},
+	"gemini-1.5-pro":              {Ratio: 1.25 * ratio.MilliTokensUsd, CompletionRatio: 4, CachedInputRatio: 0.25, Tiers: gemini15ProTiers},
+	"gemini-1.5-pro-experimental": {Ratio: 1.25 * ratio.MilliTokensUsd, CompletionRatio: 4, CachedInputRatio: 0.25, Tiers: gemini15ProTiers},
 
 	// Embedding Models
 	"text-embedding-004": {Ratio: 0.00001 * ratio.MilliTokensUsd, CompletionRatio: 1},
 	"aqa":                {Ratio: 1, CompletionRatio: 1},
 
 	// Gemini 2.0 Flash Models
-	"gemini-2.0-flash":                      {Ratio: 0.075 * ratio.MilliTokensUsd, CompletionRatio: 4},
-	"gemini-2.0-flash-exp":                  {Ratio: 0.075 * ratio.MilliTokensUsd, CompletionRatio: 4},
-	"gemini-2.0-flash-lite":                 {Ratio: 0.0375 * ratio.MilliTokensUsd, CompletionRatio: 4},
-	"gemini-2.0-flash-thinking-exp-01-21":   {Ratio: 0.075 * ratio.MilliTokensUsd, CompletionRatio: 4},
-	"gemini-2.0-flash-exp-image-generation": {Ratio: 0.075 * ratio.MilliTokensUsd, CompletionRatio: 4},
+	"gemini-2.0-flash":                      {Ratio: 0.075 * ratio.MilliTokensUsd, CompletionRatio: 4, CachedInputRatio: 0.25},
+	"gemini-2.0-flash-exp":                  {Ratio: 0.075 * ratio.MilliTokensUsd, CompletionRatio: 4, CachedInputRatio: 0.25},
+	"gemini-2.0-flash-lite":                 {Ratio: 0.0375 * ratio.MilliTokensUsd, CompletionRatio: 4, CachedInputRatio: 0.25},
+	"gemini-2.0-flash-thinking-exp-01-21":   {Ratio: 0.075 * ratio.MilliTokensUsd, CompletionRatio: 4, CachedInputRatio: 0.25},
+	"gemini-2.0-flash-exp-image-generation": {Ratio: 0.075 * ratio.MilliTokensUsd, CompletionRatio: 4, CachedInputRatio: 0.25},
 
 	// Gemini 2.0 Pro Models
-	"gemini-2.0-pro-exp-02-05": {Ratio: 1.25 * ratio.MilliTokensUsd, CompletionRatio: 4},
+	"gemini-2.0-pro-exp-02-05": {Ratio: 1.25 * ratio.MilliTokensUsd, CompletionRatio: 4, CachedInputRatio: 0.25},
 
 	// Gemini 2.5 Flash Models
-	"gemini-2.5-flash-lite-preview-06-17": {Ratio: 0.0375 * ratio.MilliTokensUsd, CompletionRatio: 4},
-	"gemini-2.5-flash":                    {Ratio: 0.075 * ratio.MilliTokensUsd, CompletionRatio: 4},
-	"gemini-2.5-flash-preview-04-17":      {Ratio: 0.075 * ratio.MilliTokensUsd, CompletionRatio: 4},
-	"gemini-2.5-flash-preview-05-20":      {Ratio: 0.075 * ratio.MilliTokensUsd, CompletionRatio: 4},
+	"gemini-2.5-flash-lite-preview-06-17": {Ratio: 0.0375 * ratio.MilliTokensUsd, CompletionRatio: 4, CachedInputRatio: 0.25},
+	"gemini-2.5-flash":                    {Ratio: 0.075 * ratio.MilliTokensUsd, CompletionRatio: 4, CachedInputRatio: 0.25},
+	"gemini-2.5-flash-preview-04-17":      {Ratio: 0.075 * ratio.MilliTokensUsd, CompletionRatio: 4, CachedInputRatio: 0.25},
+	"gemini-2.5-flash-preview-05-20":      {Ratio: 0.075 * ratio.MilliTokensUsd, CompletionRatio: 4, CachedInputRatio: 0.25},
 
 	// Gemini 2.5 Pro Models
-	"gemini-2.5-pro":               {Ratio: 1.25 * ratio.MilliTokensUsd, CompletionRatio: 4},
-	"gemini-2.5-pro-exp-03-25":     {Ratio: 1.25 * ratio.MilliTokensUsd, CompletionRatio: 4},
-	"gemini-2.5-pro-preview-05-06": {Ratio: 1.25 * ratio.MilliTokensUsd, CompletionRatio: 4},
-	"gemini-2.5-pro-preview-06-05": {Ratio: 1.25 * ratio.MilliTokensUsd, CompletionRatio: 4},
+	"gemini-2.5-pro":               {Ratio: 1.25 * ratio.MilliTokensUsd, CompletionRatio: 4, CachedInputRatio: 0.25, Tiers: gemini25ProTiers},
+	"gemini-2.5-pro-exp-03-25":     {Ratio: 1.25 * ratio.MilliTokensUsd, CompletionRatio: 4, CachedInputRatio: 0.25, Tiers: gemini25ProTiers},
+	"gemini-2.5-pro-preview-05-06": {Ratio: 1.25 * ratio.MilliTokensUsd, CompletionRatio: 4, CachedInputRatio: 0.25, Tiers: gemini25ProTiers},
+	"gemini-2.5-pro-preview-06-05": {Ratio: 1.25 * ratio.MilliTokensUsd, CompletionRatio: 4, CachedInputRatio: 0.25, Tiers: gemini25ProTiers},
 }
 
 // ModelList derived from ModelRatios for backward compatibility
@@ -20,6 +20,28 @@ type ModelConfig struct {
 	// MaxTokens represents the maximum token limit for this model on this channel
 	// 0 means no limit (infinity)
 	MaxTokens int32 `json:"max_tokens,omitempty"`
+	// CachedInputRatio represents the rate of input tokens read from the prompt cache / input rate
+	CachedInputRatio float64 `json:"cached_input_ratio,omitempty"`
+	// CacheWriteRatio represents the rate of input tokens written to the prompt cache / input rate
+	CacheWriteRatio float64 `json:"cache_write_ratio,omitempty"`
+	// AudioInputRatio represents the rate of audio input tokens / input rate
+	AudioInputRatio float64 `json:"audio_input_ratio,omitempty"`
+	// AudioOutputRatio represents the rate of audio output tokens / input rate
+	AudioOutputRatio float64 `json:"audio_output_ratio,omitempty"`
+	// ImageInputRatio represents the rate of image input tokens / input rate
+	ImageInputRatio float64 `json:"image_input_ratio,omitempty"`
+	// Tiers represents the prices of requests with long prompts, in ascending order of thresholds
+	Tiers []PriceTier `json:"tiers,omitempty"`
+}
+
+// PriceTier is the price of requests whose prompt tokens are more than InputTokenThreshold.
+// The other ratios of the model are relative to the input rate, so they apply to every tier.
+type PriceTier struct {
+	InputTokenThreshold int `json:"input_token_threshold"`
+	// Ratio replaces the Ratio of the tier below, 0 means unchanged
+	Ratio float64 `json:"ratio,omitempty"`
+	// CompletionRatio replaces the CompletionRatio of the tier below, 0 means unchanged
+	CompletionRatio float64 `json:"completion_ratio,omitempty"`
 }
 
 type Adaptor interface {
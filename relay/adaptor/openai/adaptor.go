@@ -55,6 +55,26 @@ func (a *Adaptor) GetRequestURL(meta *meta.Meta) (string, error) {
 			return fullRequestURL, nil
 		}
 
+		if meta.Mode == relaymode.Assistants || meta.Mode == relaymode.FineTuning {
+			// https://learn.microsoft.com/en-us/azure/ai-services/openai/assistants-reference
+			// https://learn.microsoft.com/en-us/azure/ai-services/openai/reference-preview#fine-tuning---create
+			// assistants, threads, fine-tuning jobs and files are not bound to any deployment,
+			// e.g. {endpoint}/openai/threads/{thread_id}/runs?api-version=...
+			path, query, _ := strings.Cut(meta.RequestURLPath, "?")
+			requestURL := fmt.Sprintf("/openai/%s?api-version=%s", strings.TrimPrefix(path, "/v1/"), defaultVersion)
+			if query != "" {
+				requestURL += "&" + query
+			}
+			return GetFullRequestURL(meta.BaseURL, requestURL, meta.ChannelType), nil
+		}
+
+		if meta.Mode == relaymode.Realtime {
+			// https://learn.microsoft.com/en-us/azure/ai-services/openai/how-to/realtime-audio-websockets
+			// wss://{resource_name}.openai.azure.com/openai/realtime?api-version=...&deployment=gpt-4o-realtime-preview
+			requestURL := fmt.Sprintf("/openai/realtime?api-version=%s&deployment=%s", defaultVersion, meta.ActualModelName)
+			return GetFullRequestURL(meta.BaseURL, requestURL, meta.ChannelType), nil
+		}
+
 		// https://learn.microsoft.com/en-us/azure/cognitive-services/openai/chatgpt-quickstart?pivots=rest-api&tabs=command-line#rest-api
 		requestURL := strings.Split(meta.RequestURLPath, "?")[0]
 		requestURL = fmt.Sprintf("%s?api-version=%s", requestURL, defaultVersion)
@@ -93,6 +113,14 @@ func (a *Adaptor) GetRequestURL(meta *meta.Meta) (string, error) {
 
 func (a *Adaptor) SetupRequestHeader(c *gin.Context, req *http.Request, meta *meta.Meta) error {
 	adaptor.SetupCommonRequestHeader(c, req, meta)
+	if meta.Mode == relaymode.Assistants {
+		// https://platform.openai.com/docs/assistants/migration
+		req.Header.Set("OpenAI-Beta", "assistants=v2")
+	}
+	if meta.Mode == relaymode.Realtime {
+		// https://platform.openai.com/docs/guides/realtime-websocket
+		req.Header.Set("OpenAI-Beta", "realtime=v1")
+	}
 	if meta.ChannelType == channeltype.Azure {
 		req.Header.Set("api-key", meta.APIKey)
 		return nil
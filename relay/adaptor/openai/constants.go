@@ -34,12 +34,12 @@ var ModelRatios = map[string]adaptor.ModelConfig{
 	"gpt-4-turbo-2024-04-09": {Ratio: 10.0 * ratio.MilliTokensUsd, CompletionRatio: 3.0},
 
 	// GPT-4o Models
-	"gpt-4o":                               {Ratio: 2.5 * ratio.MilliTokensUsd, CompletionRatio: 4.0},
+	"gpt-4o":                               {Ratio: 2.5 * ratio.MilliTokensUsd, CompletionRatio: 4.0, CachedInputRatio: 0.5},
 	"gpt-4o-2024-05-13":                    {Ratio: 5.0 * ratio.MilliTokensUsd, CompletionRatio: 3.0},
-	"gpt-4o-2024-08-06":                    {Ratio: 2.5 * ratio.MilliTokensUsd, CompletionRatio: 4.0},
-	"gpt-4o-2024-11-20":                    {Ratio: 2.5 * ratio.MilliTokensUsd, CompletionRatio: 4.0},
-	"gpt-4o-mini":                          {Ratio: 0.15 * ratio.MilliTokensUsd, CompletionRatio: 4.0},
-	"gpt-4o-mini-2024-07-18":               {Ratio: 0.15 * ratio.MilliTokensUsd, CompletionRatio: 4.0},
+	"gpt-4o-2024-08-06":                    {Ratio: 2.5 * ratio.MilliTokensUsd, CompletionRatio: 4.0, CachedInputRatio: 0.5},
+	"gpt-4o-2024-11-20":                    {Ratio: 2.5 * ratio.MilliTokensUsd, CompletionRatio: 4.0, CachedInputRatio: 0.5},
+	"gpt-4o-mini":                          {Ratio: 0.15 * ratio.MilliTokensUsd, CompletionRatio: 4.0, CachedInputRatio: 0.5},
+	"gpt-4o-mini-2024-07-18":               {Ratio: 0.15 * ratio.MilliTokensUsd, CompletionRatio: 4.0, CachedInputRatio: 0.5},
 	"gpt-4o-mini-audio-preview":            {Ratio: 0.15 * ratio.MilliTokensUsd, CompletionRatio: 4.0},
 	"gpt-4o-mini-audio-preview-2024-12-17": {Ratio: 0.15 * ratio.MilliTokensUsd, CompletionRatio: 4.0},
 	"gpt-4o-audio-preview":                 {Ratio: 2.5 * ratio.MilliTokensUsd, CompletionRatio: 4.0},
@@ -48,53 +48,53 @@ var ModelRatios = map[string]adaptor.ModelConfig{
 	"gpt-4o-audio-preview-2025-06-03":      {Ratio: 2.5 * ratio.MilliTokensUsd, CompletionRatio: 4.0},
 
 	// Realtime Models
-	"gpt-4o-realtime-preview":                 {Ratio: 5.0 * ratio.MilliTokensUsd, CompletionRatio: 4.0},
-	"gpt-4o-realtime-preview-2025-06-03":      {Ratio: 5.0 * ratio.MilliTokensUsd, CompletionRatio: 4.0},
-	"gpt-4o-mini-realtime-preview":            {Ratio: 0.6 * ratio.MilliTokensUsd, CompletionRatio: 4.0},
-	"gpt-4o-mini-realtime-preview-2024-12-17": {Ratio: 0.6 * ratio.MilliTokensUsd, CompletionRatio: 4.0},
+	"gpt-4o-realtime-preview":                 {Ratio: 5.0 * ratio.MilliTokensUsd, CompletionRatio: 4.0, CachedInputRatio: 0.5},
+	"gpt-4o-realtime-preview-2025-06-03":      {Ratio: 5.0 * ratio.MilliTokensUsd, CompletionRatio: 4.0, CachedInputRatio: 0.5},
+	"gpt-4o-mini-realtime-preview":            {Ratio: 0.6 * ratio.MilliTokensUsd, CompletionRatio: 4.0, CachedInputRatio: 0.5},
+	"gpt-4o-mini-realtime-preview-2024-12-17": {Ratio: 0.6 * ratio.MilliTokensUsd, CompletionRatio: 4.0, CachedInputRatio: 0.5},
 
 	// GPT-4.5 Models
-	"gpt-4.5-preview":            {Ratio: 75.0 * ratio.MilliTokensUsd, CompletionRatio: 2.0},
-	"gpt-4.5-preview-2025-02-27": {Ratio: 75.0 * ratio.MilliTokensUsd, CompletionRatio: 2.0},
+	"gpt-4.5-preview":            {Ratio: 75.0 * ratio.MilliTokensUsd, CompletionRatio: 2.0, CachedInputRatio: 0.5},
+	"gpt-4.5-preview-2025-02-27": {Ratio: 75.0 * ratio.MilliTokensUsd, CompletionRatio: 2.0, CachedInputRatio: 0.5},
 
 	// GPT-4.1 Models
-	"gpt-4.1":                 {Ratio: 2.0 * ratio.MilliTokensUsd, CompletionRatio: 4.0},
-	"gpt-4.1-2025-04-14":      {Ratio: 2.0 * ratio.MilliTokensUsd, CompletionRatio: 4.0},
-	"gpt-4.1-mini":            {Ratio: 0.4 * ratio.MilliTokensUsd, CompletionRatio: 4.0},
-	"gpt-4.1-mini-2025-04-14": {Ratio: 0.4 * ratio.MilliTokensUsd, CompletionRatio: 4.0},
-	"gpt-4.1-nano":            {Ratio: 0.1 * ratio.MilliTokensUsd, CompletionRatio: 4.0},
-	"gpt-4.1-nano-2025-04-14": {Ratio: 0.1 * ratio.MilliTokensUsd, CompletionRatio: 4.0},
+	"gpt-4.1":                 {Ratio: 2.0 * ratio.MilliTokensUsd, CompletionRatio: 4.0, CachedInputRatio: 0.25},
+	"gpt-4.1-2025-04-14":      {Ratio: 2.0 * ratio.MilliTokensUsd, CompletionRatio: 4.0, CachedInputRatio: 0.25},
+	"gpt-4.1-mini":            {Ratio: 0.4 * ratio.MilliTokensUsd, CompletionRatio: 4.0, CachedInputRatio: 0.25},
+	"gpt-4.1-mini-2025-04-14": {Ratio: 0.4 * ratio.MilliTokensUsd, CompletionRatio: 4.0, CachedInputRatio: 0.25},
+	"gpt-4.1-nano":            {Ratio: 0.1 * ratio.MilliTokensUsd, CompletionRatio: 4.0, CachedInputRatio: 0.25},
+	"gpt-4.1-nano-2025-04-14": {Ratio: 0.1 * ratio.MilliTokensUsd, CompletionRatio: 4.0, CachedInputRatio: 0.25},
 
 	// o1 Models
-	"o1":                    {Ratio: 15.0 * ratio.MilliTokensUsd, CompletionRatio: 4.0},
-	"o1-2024-12-17":         {Ratio: 15.0 * ratio.MilliTokensUsd, CompletionRatio: 4.0},
+	"o1":                    {Ratio: 15.0 * ratio.MilliTokensUsd, CompletionRatio: 4.0, CachedInputRatio: 0.5},
+	"o1-2024-12-17":         {Ratio: 15.0 * ratio.MilliTokensUsd, CompletionRatio: 4.0, CachedInputRatio: 0.5},
 	"o1-pro":                {Ratio: 150.0 * ratio.MilliTokensUsd, CompletionRatio: 4.0},
 	"o1-pro-2025-03-19":     {Ratio: 150.0 * ratio.MilliTokensUsd, CompletionRatio: 4.0},
-	"o1-preview":            {Ratio: 15.0 * ratio.MilliTokensUsd, CompletionRatio: 4.0},
-	"o1-preview-2024-09-12": {Ratio: 15.0 * ratio.MilliTokensUsd, CompletionRatio: 4.0},
-	"o1-mini":               {Ratio: 1.1 * ratio.MilliTokensUsd, CompletionRatio: 4.0},
-	"o1-mini-2024-09-12":    {Ratio: 1.1 * ratio.MilliTokensUsd, CompletionRatio: 4.0},
+	"o1-preview":            {Ratio: 15.0 * ratio.MilliTokensUsd, CompletionRatio: 4.0, CachedInputRatio: 0.5},
+	"o1-preview-2024-09-12": {Ratio: 15.0 * ratio.MilliTokensUsd, CompletionRatio: 4.0, CachedInputRatio: 0.5},
+	"o1-mini":               {Ratio: 1.1 * ratio.MilliTokensUsd, CompletionRatio: 4.0, CachedInputRatio: 0.5},
+	"o1-mini-2024-09-12":    {Ratio: 1.1 * ratio.MilliTokensUsd, CompletionRatio: 4.0, CachedInputRatio: 0.5},
 
 	// o3 Models
-	"o3":                 {Ratio: 2.0 * ratio.MilliTokensUsd, CompletionRatio: 4.0},
-	"o3-2025-04-16":      {Ratio: 2.0 * ratio.MilliTokensUsd, CompletionRatio: 4.0},
-	"o3-mini":            {Ratio: 1.1 * ratio.MilliTokensUsd, CompletionRatio: 4.0},
-	"o3-mini-2025-01-31": {Ratio: 1.1 * ratio.MilliTokensUsd, CompletionRatio: 4.0},
+	"o3":                 {Ratio: 2.0 * ratio.MilliTokensUsd, CompletionRatio: 4.0, CachedInputRatio: 0.25},
+	"o3-2025-04-16":      {Ratio: 2.0 * ratio.MilliTokensUsd, CompletionRatio: 4.0, CachedInputRatio: 0.25},
+	"o3-mini":            {Ratio: 1.1 * ratio.MilliTokensUsd, CompletionRatio: 4.0, CachedInputRatio: 0.5},
+	"o3-mini-2025-01-31": {Ratio: 1.1 * ratio.MilliTokensUsd, CompletionRatio: 4.0, CachedInputRatio: 0.5},
 	"o3-pro":             {Ratio: 20.0 * ratio.MilliTokensUsd, CompletionRatio: 4.0},
 	"o3-pro-2025-06-10":  {Ratio: 20.0 * ratio.MilliTokensUsd, CompletionRatio: 4.0},
 
 	// o3 Deep Research Models
-	"o3-deep-research":            {Ratio: 10.0 * ratio.MilliTokensUsd, CompletionRatio: 4.0},
-	"o3-deep-research-2025-06-26": {Ratio: 10.0 * ratio.MilliTokensUsd, CompletionRatio: 4.0},
+	"o3-deep-research":            {Ratio: 10.0 * ratio.MilliTokensUsd, CompletionRatio: 4.0, CachedInputRatio: 0.25},
+	"o3-deep-research-2025-06-26": {Ratio: 10.0 * ratio.MilliTokensUsd, CompletionRatio: 4.0, CachedInputRatio: 0.25},
 
 	// o4 Models
-	"o4-mini":                          {Ratio: 1.1 * ratio.MilliTokensUsd, CompletionRatio: 4.0},
-	"o4-mini-2025-04-16":               {Ratio: 1.1 * ratio.MilliTokensUsd, CompletionRatio: 4.0},
-	"o4-mini-deep-research":            {Ratio: 2.0 * ratio.MilliTokensUsd, CompletionRatio: 4.0},
-	"o4-mini-deep-research-2025-06-26": {Ratio: 2.0 * ratio.MilliTokensUsd, CompletionRatio: 4.0},
+	"o4-mini":                          {Ratio: 1.1 * ratio.MilliTokensUsd, CompletionRatio: 4.0, CachedInputRatio: 0.25},
+	"o4-mini-2025-04-16":               {Ratio: 1.1 * ratio.MilliTokensUsd, CompletionRatio: 4.0, CachedInputRatio: 0.25},
+	"o4-mini-deep-research":            {Ratio: 2.0 * ratio.MilliTokensUsd, CompletionRatio: 4.0, CachedInputRatio: 0.25},
+	"o4-mini-deep-research-2025-06-26": {Ratio: 2.0 * ratio.MilliTokensUsd, CompletionRatio: 4.0, CachedInputRatio: 0.25},
 
 	// Codex Models
-	"codex-mini-latest": {Ratio: 1.5 * ratio.MilliTokensUsd, CompletionRatio: 4.0},
+	"codex-mini-latest": {Ratio: 1.5 * ratio.MilliTokensUsd, CompletionRatio: 4.0, CachedInputRatio: 0.25},
 
 	// Search Models
 	"gpt-4o-mini-search-preview":            {Ratio: 0.15 * ratio.MilliTokensUsd, CompletionRatio: 4.0},
@@ -142,9 +142,9 @@ var ModelRatios = map[string]adaptor.ModelConfig{
 	"gpt-4o-mini-tts":        {Ratio: 0.6 * ratio.MilliTokensUsd, CompletionRatio: 20.0}, // $0.60 input, $12.00 output per 1M tokens
 
 	// Image Generation Models
-	"dall-e-2":    {Ratio: 20.0 * ratio.MilliTokensUsd, CompletionRatio: 1.0}, // $0.020 per image
-	"dall-e-3":    {Ratio: 40.0 * ratio.MilliTokensUsd, CompletionRatio: 1.0}, // $0.040 per image
-	"gpt-image-1": {Ratio: 5.0 * ratio.MilliTokensUsd, CompletionRatio: 0.0},  // $5.00 per 1M input tokens, no output tokens
+	"dall-e-2":    {Ratio: 20.0 * ratio.MilliTokensUsd, CompletionRatio: 1.0},                                              // $0.020 per image
+	"dall-e-3":    {Ratio: 40.0 * ratio.MilliTokensUsd, CompletionRatio: 1.0},                                              // $0.040 per image
+	"gpt-image-1": {Ratio: 5.0 * ratio.MilliTokensUsd, CompletionRatio: 0.0, CachedInputRatio: 0.25, ImageInputRatio: 2.0}, // $5.00 per 1M text input tokens, $10.00 per 1M image input tokens, no output tokens
 }
 
 // ModelList derived from ModelRatios for backward compatibility
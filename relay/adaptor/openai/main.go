@@ -5,7 +5,6 @@ import (
 	"bytes"
 	"encoding/json"
 	"io"
-	"math"
 	"net/http"
 	"strings"
 
@@ -13,10 +12,10 @@ import (
 
 	"github.com/songquanpeng/one-api/common"
 	"github.com/songquanpeng/one-api/common/conv"
+	"github.com/songquanpeng/one-api/common/ctxkey"
 	"github.com/songquanpeng/one-api/common/logger"
 	"github.com/songquanpeng/one-api/common/render"
 	"github.com/songquanpeng/one-api/relay/adaptor/openai_compatible"
-	"github.com/songquanpeng/one-api/relay/billing/ratio"
 	"github.com/songquanpeng/one-api/relay/model"
 	"github.com/songquanpeng/one-api/relay/relaymode"
 )
@@ -290,42 +289,9 @@ func calculateTokenUsage(response *SlimTextResponse, promptTokens int, modelName
 			CompletionTokens: completionTokens,
 			TotalTokens:      promptTokens + completionTokens,
 		}
-	} else if hasAudioTokens(response) {
-		// Handle audio tokens conversion
-		calculateAudioTokens(response, modelName)
 	}
 }
 
-// Helper function to check if response has audio tokens
-func hasAudioTokens(response *SlimTextResponse) bool {
-	return (response.PromptTokensDetails != nil && response.PromptTokensDetails.AudioTokens > 0) ||
-		(response.CompletionTokensDetails != nil && response.CompletionTokensDetails.AudioTokens > 0)
-}
-
-// Helper function to calculate audio token usage
-func calculateAudioTokens(response *SlimTextResponse, modelName string) {
-	// Convert audio tokens for prompt
-	if response.PromptTokensDetails != nil {
-		response.Usage.PromptTokens = response.PromptTokensDetails.TextTokens +
-			int(math.Ceil(
-				float64(response.PromptTokensDetails.AudioTokens)*
-					ratio.GetAudioPromptRatio(modelName),
-			))
-	}
-
-	// Convert audio tokens for completion
-	if response.CompletionTokensDetails != nil {
-		response.Usage.CompletionTokens = response.CompletionTokensDetails.TextTokens +
-			int(math.Ceil(
-				float64(response.CompletionTokensDetails.AudioTokens)*
-					ratio.GetAudioPromptRatio(modelName)*ratio.GetAudioCompletionRatio(modelName),
-			))
-	}
-
-	// Calculate total tokens
-	response.Usage.TotalTokens = response.Usage.PromptTokens + response.Usage.CompletionTokens
-}
-
 // ResponseAPIHandler processes non-streaming responses from Response API format and converts them back to ChatCompletion format
 // This function follows the same pattern as Handler but converts Response API responses to ChatCompletion format
 // Returns error (if any) and token usage information
@@ -617,6 +583,11 @@ func ResponseAPIDirectHandler(c *gin.Context, resp *http.Response, promptTokens
 		}, nil
 	}
 
+	// Remember the response id, so that follow-up calls could be routed to this channel
+	if responseAPIResp.Id != "" {
+		c.Set(ctxkey.ResponseAPIId, responseAPIResp.Id)
+	}
+
 	// Extract usage information for billing
 	var finalUsage *model.Usage
 	if responseAPIResp.Usage != nil {
@@ -718,6 +689,15 @@ func ResponseAPIDirectStreamHandler(c *gin.Context, resp *http.Response, relayMo
 			continue
 		}
 
+		// Remember the response id, so that follow-up calls could be routed to this channel
+		if c.GetString(ctxkey.ResponseAPIId) == "" {
+			if fullResponse != nil {
+				c.Set(ctxkey.ResponseAPIId, fullResponse.Id)
+			} else if streamEvent.Response != nil && streamEvent.Response.Id != "" {
+				c.Set(ctxkey.ResponseAPIId, streamEvent.Response.Id)
+			}
+		}
+
 		// Accumulate response text for token counting - only from delta events to avoid duplicates
 		if streamEvent != nil && strings.Contains(streamEvent.Type, "delta") {
 			// Only accumulate content from delta events to prevent duplication
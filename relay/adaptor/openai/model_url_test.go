@@ -149,3 +149,48 @@ func TestModelSupportConsistencyBetweenURLAndConversion(t *testing.T) {
 		})
 	}
 }
+
+func TestGetRequestURLForAssistants(t *testing.T) {
+	adaptor := &Adaptor{}
+
+	testCases := []struct {
+		name        string
+		channelType int
+		baseURL     string
+		expectedURL string
+	}{
+		{
+			name:        "OpenAI keeps the path",
+			channelType: channeltype.OpenAI,
+			baseURL:     "https://api.openai.com",
+			expectedURL: "https://api.openai.com/v1/threads/thread_1/runs?limit=10",
+		},
+		{
+			name:        "Azure is not bound to deployments",
+			channelType: channeltype.Azure,
+			baseURL:     "https://example.openai.azure.com",
+			expectedURL: "https://example.openai.azure.com/openai/threads/thread_1/runs?api-version=2024-05-01-preview&limit=10",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			relayMeta := &meta.Meta{
+				Mode:            relaymode.Assistants,
+				ChannelType:     tc.channelType,
+				BaseURL:         tc.baseURL,
+				RequestURLPath:  "/v1/threads/thread_1/runs?limit=10",
+				ActualModelName: "gpt-4o",
+			}
+			relayMeta.Config.APIVersion = "2024-05-01-preview"
+
+			requestURL, err := adaptor.GetRequestURL(relayMeta)
+			if err != nil {
+				t.Fatalf("GetRequestURL failed: %v", err)
+			}
+			if requestURL != tc.expectedURL {
+				t.Errorf("Expected URL %s, got %s", tc.expectedURL, requestURL)
+			}
+		})
+	}
+}
@@ -0,0 +1,666 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Laisky/errors/v2"
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/random"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+// This file implements the Response API (/v1/responses) on top of chat completions.
+//
+// Requests served by OpenAI channels are passed through as is,
+// requests served by any other channel are converted to GeneralOpenAIRequest,
+// and the chat completion output of that channel is converted back to Response API objects.
+// Such responses are not stored anywhere, so they could not be referred by later requests.
+
+// responseAPIInputItem is an item of the input list of a Response API request
+type responseAPIInputItem struct {
+	Type    string `json:"type,omitempty"`
+	Role    string `json:"role,omitempty"`
+	Content any    `json:"content,omitempty"`
+	// function_call and function_call_output items
+	CallId    string `json:"call_id,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments,omitempty"`
+	Output    any    `json:"output,omitempty"`
+}
+
+// responseAPIInputContent is a content part of an input message
+type responseAPIInputContent struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	ImageURL string `json:"image_url,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+	FileData string `json:"file_data,omitempty"`
+	Filename string `json:"filename,omitempty"`
+	Refusal  string `json:"refusal,omitempty"`
+}
+
+// ConvertResponseAPIRequest converts a Response API request to GeneralOpenAIRequest,
+// so that it could be served by channels that do not speak the Response API.
+//
+// Features that rely on responses stored upstream, such as previous_response_id,
+// prompt templates and background mode, are rejected.
+// Reasoning items in the input are dropped, and built-in tools are skipped.
+func ConvertResponseAPIRequest(request *ResponseAPIRequest) (*model.GeneralOpenAIRequest, error) {
+	switch {
+	case request.PreviousResponseId != nil && *request.PreviousResponseId != "":
+		return nil, errors.New("previous_response_id is only supported by OpenAI channels")
+	case request.Prompt != nil:
+		return nil, errors.New("prompt templates are only supported by OpenAI channels")
+	case request.Background != nil && *request.Background:
+		return nil, errors.New("background mode is only supported by OpenAI channels")
+	}
+
+	textRequest := &model.GeneralOpenAIRequest{
+		Model:            request.Model,
+		Temperature:      request.Temperature,
+		TopP:             request.TopP,
+		ParallelTooCalls: request.ParallelToolCalls,
+	}
+	if request.MaxOutputTokens != nil {
+		textRequest.MaxTokens = *request.MaxOutputTokens
+	}
+	if request.User != nil {
+		textRequest.User = *request.User
+	}
+	if request.Stream != nil && *request.Stream {
+		textRequest.Stream = true
+		textRequest.StreamOptions = &model.StreamOptions{IncludeUsage: true}
+	}
+	if request.Reasoning != nil && request.Reasoning.Effort != nil {
+		textRequest.ReasoningEffort = request.Reasoning.Effort
+	}
+
+	if request.Instructions != nil && *request.Instructions != "" {
+		textRequest.Messages = append(textRequest.Messages, model.Message{
+			Role:    "system",
+			Content: *request.Instructions,
+		})
+	}
+
+	for _, input := range request.Input {
+		if text, ok := input.(string); ok {
+			textRequest.Messages = append(textRequest.Messages, model.Message{
+				Role:    "user",
+				Content: text,
+			})
+			continue
+		}
+
+		item := new(responseAPIInputItem)
+		if err := remarshal(input, item); err != nil {
+			return nil, errors.Wrap(err, "parse input item")
+		}
+
+		switch item.Type {
+		case "", "message":
+			message, err := convertResponseAPIInputMessage(item)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			textRequest.Messages = append(textRequest.Messages, *message)
+		case "function_call":
+			toolCall := model.Tool{
+				Id:   item.CallId,
+				Type: "function",
+				Function: model.Function{
+					Name:      item.Name,
+					Arguments: item.Arguments,
+				},
+			}
+			// parallel function calls are merged into one assistant message
+			last := len(textRequest.Messages) - 1
+			if last >= 0 && textRequest.Messages[last].Role == "assistant" {
+				textRequest.Messages[last].ToolCalls = append(textRequest.Messages[last].ToolCalls, toolCall)
+			} else {
+				textRequest.Messages = append(textRequest.Messages, model.Message{
+					Role:      "assistant",
+					ToolCalls: []model.Tool{toolCall},
+				})
+			}
+		case "function_call_output":
+			output, ok := item.Output.(string)
+			if !ok {
+				data, err := json.Marshal(item.Output)
+				if err != nil {
+					return nil, errors.Wrap(err, "marshal function call output")
+				}
+				output = string(data)
+			}
+			textRequest.Messages = append(textRequest.Messages, model.Message{
+				Role:       "tool",
+				ToolCallId: item.CallId,
+				Content:    output,
+			})
+		case "reasoning":
+			// reasoning items are only meaningful to the model that produced them
+		default:
+			return nil, errors.Errorf("input item of type %q is only supported by OpenAI channels", item.Type)
+		}
+	}
+	if len(textRequest.Messages) == 0 {
+		return nil, errors.New("input is empty")
+	}
+
+	for _, tool := range request.Tools {
+		if tool.Type != "function" {
+			logger.Warnf(context.TODO(), "built-in tool %q is not supported by chat completions, skip it", tool.Type)
+			continue
+		}
+		textRequest.Tools = append(textRequest.Tools, model.Tool{
+			Type: "function",
+			Function: model.Function{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.Parameters,
+			},
+		})
+	}
+	if len(textRequest.Tools) > 0 {
+		textRequest.ToolChoice = convertResponseAPIToolChoice(request.ToolChoice)
+	}
+
+	if request.Text != nil && request.Text.Format != nil {
+		switch format := request.Text.Format; format.Type {
+		case "json_object":
+			textRequest.ResponseFormat = &model.ResponseFormat{Type: "json_object"}
+		case "json_schema":
+			textRequest.ResponseFormat = &model.ResponseFormat{
+				Type: "json_schema",
+				JsonSchema: &model.JSONSchema{
+					Name:        format.Name,
+					Description: format.Description,
+					Schema:      format.Schema,
+					Strict:      format.Strict,
+				},
+			}
+		}
+	}
+
+	return textRequest, nil
+}
+
+// remarshal converts a decoded json value to another type
+func remarshal(from any, to any) error {
+	data, err := json.Marshal(from)
+	if err != nil {
+		return errors.Wrap(err, "marshal")
+	}
+
+	return errors.Wrap(json.Unmarshal(data, to), "unmarshal")
+}
+
+// convertResponseAPIInputMessage converts an input message to an OpenAI chat message
+func convertResponseAPIInputMessage(item *responseAPIInputItem) (*model.Message, error) {
+	role := item.Role
+	if role == "developer" {
+		role = "system"
+	}
+	message := &model.Message{Role: role}
+
+	if text, ok := item.Content.(string); ok {
+		message.Content = text
+		return message, nil
+	}
+
+	var contents []responseAPIInputContent
+	if err := remarshal(item.Content, &contents); err != nil {
+		return nil, errors.Wrap(err, "parse message content")
+	}
+
+	var parts []model.MessageContent
+	for _, content := range contents {
+		switch content.Type {
+		case "input_text", "output_text", "text", "refusal":
+			text := content.Text + content.Refusal
+			parts = append(parts, model.MessageContent{
+				Type: model.ContentTypeText,
+				Text: &text,
+			})
+		case "input_image":
+			if content.ImageURL == "" {
+				return nil, errors.New("images referred by file_id are only supported by OpenAI channels")
+			}
+			parts = append(parts, model.MessageContent{
+				Type: model.ContentTypeImageURL,
+				ImageURL: &model.ImageURL{
+					Url:    content.ImageURL,
+					Detail: content.Detail,
+				},
+			})
+		case "input_file":
+			if content.FileData == "" {
+				return nil, errors.New("input_file without file_data is only supported by OpenAI channels")
+			}
+			parts = append(parts, model.MessageContent{
+				Type: model.ContentTypeFile,
+				File: &model.MessageFile{
+					FileData: content.FileData,
+					Filename: content.Filename,
+				},
+			})
+		default:
+			return nil, errors.Errorf("content of type %q is not supported", content.Type)
+		}
+	}
+
+	// plain text is the most widely supported format
+	if len(parts) == 1 && parts[0].Type == model.ContentTypeText {
+		message.Content = *parts[0].Text
+	} else {
+		message.Content = parts
+	}
+
+	return message, nil
+}
+
+// convertResponseAPIToolChoice converts the tool_choice of the Response API to the chat completions format
+func convertResponseAPIToolChoice(toolChoice any) any {
+	switch choice := toolChoice.(type) {
+	case nil:
+		return nil
+	case string:
+		return choice
+	case map[string]any:
+		if choice["type"] == "function" {
+			return map[string]any{
+				"type":     "function",
+				"function": map[string]any{"name": choice["name"]},
+			}
+		}
+	}
+
+	// built-in tools are not forwarded
+	return "auto"
+}
+
+// reasoningOfMessage returns the reasoning content of an OpenAI message, whatever format it uses
+func reasoningOfMessage(message *model.Message) string {
+	switch {
+	case message.Reasoning != nil && *message.Reasoning != "":
+		return *message.Reasoning
+	case message.ReasoningContent != nil && *message.ReasoningContent != "":
+		return *message.ReasoningContent
+	case message.Thinking != nil && *message.Thinking != "":
+		return *message.Thinking
+	}
+
+	return ""
+}
+
+// responseAPIId converts a chat completion id to a Response API id
+func responseAPIId(id string) string {
+	id = strings.TrimPrefix(id, "chatcmpl-")
+	if id == "" {
+		id = random.GetUUID()
+	}
+	if strings.HasPrefix(id, "resp_") {
+		return id
+	}
+
+	return "resp_" + id
+}
+
+// toolCallId makes up an id for tool calls of upstreams that do not return one
+func toolCallId(id string) string {
+	if id == "" {
+		return random.GetUUID()
+	}
+
+	return id
+}
+
+// responseAPIStatus converts an OpenAI finish_reason to the status of a response
+func responseAPIStatus(finishReason string) (string, *IncompleteDetails) {
+	switch finishReason {
+	case "length":
+		return "incomplete", &IncompleteDetails{Reason: "max_output_tokens"}
+	case "content_filter":
+		return "incomplete", &IncompleteDetails{Reason: "content_filter"}
+	}
+
+	return "completed", nil
+}
+
+// newResponseAPIResponse creates a response object which echoes the parameters of the request
+func newResponseAPIResponse(id string, createdAt int64, modelName string, request *ResponseAPIRequest) *ResponseAPIResponse {
+	response := &ResponseAPIResponse{
+		Id:                id,
+		Object:            "response",
+		CreatedAt:         createdAt,
+		Status:            "in_progress",
+		Model:             modelName,
+		Output:            []OutputItem{},
+		ParallelToolCalls: true,
+	}
+	if request == nil {
+		return response
+	}
+
+	response.Instructions = request.Instructions
+	response.MaxOutputTokens = request.MaxOutputTokens
+	response.Metadata = request.Metadata
+	if request.ParallelToolCalls != nil {
+		response.ParallelToolCalls = *request.ParallelToolCalls
+	}
+	response.Reasoning = request.Reasoning
+	response.Temperature = request.Temperature
+	response.Text = request.Text
+	response.ToolChoice = request.ToolChoice
+	response.TopP = request.TopP
+	response.User = request.User
+
+	return response
+}
+
+// ConvertTextResponseToResponseAPI converts an OpenAI chat completion to a Response API response,
+// request is echoed in the response as OpenAI does.
+func ConvertTextResponseToResponseAPI(response *TextResponse, request *ResponseAPIRequest) *ResponseAPIResponse {
+	createdAt := response.Created
+	if createdAt == 0 {
+		createdAt = helper.GetTimestamp()
+	}
+	responseAPIResp := newResponseAPIResponse(responseAPIId(response.Id), createdAt, response.Model, request)
+
+	finishReason := ""
+	for _, choice := range response.Choices {
+		if reasoning := reasoningOfMessage(&choice.Message); reasoning != "" {
+			responseAPIResp.Output = append(responseAPIResp.Output, OutputItem{
+				Type:    "reasoning",
+				Id:      "rs_" + random.GetUUID(),
+				Summary: []OutputContent{{Type: "summary_text", Text: reasoning}},
+			})
+		}
+
+		if text := choice.Message.StringContent(); text != "" {
+			responseAPIResp.Output = append(responseAPIResp.Output, OutputItem{
+				Type:    "message",
+				Id:      "msg_" + random.GetUUID(),
+				Status:  "completed",
+				Role:    "assistant",
+				Content: []OutputContent{{Type: "output_text", Text: text}},
+			})
+		}
+
+		for _, toolCall := range choice.Message.ToolCalls {
+			arguments, ok := toolCall.Function.Arguments.(string)
+			if !ok {
+				data, _ := json.Marshal(toolCall.Function.Arguments)
+				arguments = string(data)
+			}
+			fcId, callId := convertToolCallIDToResponseAPI(toolCallId(toolCall.Id))
+			responseAPIResp.Output = append(responseAPIResp.Output, OutputItem{
+				Type:      "function_call",
+				Id:        fcId,
+				Status:    "completed",
+				CallId:    callId,
+				Name:      toolCall.Function.Name,
+				Arguments: arguments,
+			})
+		}
+
+		if finishReason == "" {
+			finishReason = choice.FinishReason
+		}
+	}
+
+	responseAPIResp.Status, responseAPIResp.IncompleteDetails = responseAPIStatus(finishReason)
+	responseAPIResp.Usage = (&ResponseAPIUsage{}).FromModelUsage(&response.Usage)
+
+	return responseAPIResp
+}
+
+// ResponseAPIStreamConverter converts OpenAI chat completion chunks to Response API stream events.
+//
+// Output items are emitted strictly one after another:
+// an item is closed as soon as a chunk of another kind arrives.
+// Events are plain maps, since zero values such as output_index 0 must be kept.
+type ResponseAPIStreamConverter struct {
+	request  *ResponseAPIRequest
+	model    string
+	response *ResponseAPIResponse
+
+	started        bool
+	sequenceNumber int
+
+	// item is the currently open output item, nil means no item is open
+	item *OutputItem
+	text strings.Builder
+	// toolItems remembers the OpenAI tool call indexes that already have their items
+	toolItems   map[int]bool
+	currentTool int
+
+	finishReason string
+	usage        *model.Usage
+}
+
+// NewResponseAPIStreamConverter creates a converter, request is echoed in the response as OpenAI does
+func NewResponseAPIStreamConverter(modelName string, request *ResponseAPIRequest) *ResponseAPIStreamConverter {
+	return &ResponseAPIStreamConverter{
+		request:     request,
+		model:       modelName,
+		toolItems:   make(map[int]bool),
+		currentTool: -1,
+	}
+}
+
+func (s *ResponseAPIStreamConverter) event(eventType string, fields map[string]any) map[string]any {
+	fields["type"] = eventType
+	fields["sequence_number"] = s.sequenceNumber
+	s.sequenceNumber++
+	return fields
+}
+
+func (s *ResponseAPIStreamConverter) start(id string, createdAt int64) []map[string]any {
+	if s.started {
+		return nil
+	}
+	s.started = true
+	if createdAt == 0 {
+		createdAt = helper.GetTimestamp()
+	}
+	s.response = newResponseAPIResponse(responseAPIId(id), createdAt, s.model, s.request)
+
+	// the snapshot must not change when output is appended later
+	snapshot := *s.response
+	return []map[string]any{
+		s.event("response.created", map[string]any{"response": &snapshot}),
+		s.event("response.in_progress", map[string]any{"response": &snapshot}),
+	}
+}
+
+func (s *ResponseAPIStreamConverter) outputIndex() int {
+	return len(s.response.Output)
+}
+
+func (s *ResponseAPIStreamConverter) closeItem() []map[string]any {
+	if s.item == nil {
+		return nil
+	}
+
+	item := s.item
+	index := s.outputIndex()
+	text := s.text.String()
+	var events []map[string]any
+	switch item.Type {
+	case "reasoning":
+		part := OutputContent{Type: "summary_text", Text: text}
+		item.Summary = []OutputContent{part}
+		events = append(events,
+			s.event("response.reasoning_summary_text.done", map[string]any{
+				"item_id": item.Id, "output_index": index, "summary_index": 0, "text": text,
+			}),
+			s.event("response.reasoning_summary_part.done", map[string]any{
+				"item_id": item.Id, "output_index": index, "summary_index": 0, "part": part,
+			}),
+		)
+	case "message":
+		part := OutputContent{Type: "output_text", Text: text, Annotations: []any{}}
+		item.Content = []OutputContent{part}
+		events = append(events,
+			s.event("response.output_text.done", map[string]any{
+				"item_id": item.Id, "output_index": index, "content_index": 0, "text": text,
+			}),
+			s.event("response.content_part.done", map[string]any{
+				"item_id": item.Id, "output_index": index, "content_index": 0, "part": part,
+			}),
+		)
+	case "function_call":
+		item.Arguments = text
+		events = append(events, s.event("response.function_call_arguments.done", map[string]any{
+			"item_id": item.Id, "output_index": index, "arguments": text,
+		}))
+	}
+	item.Status = "completed"
+	events = append(events, s.event("response.output_item.done", map[string]any{
+		"output_index": index, "item": *item,
+	}))
+
+	s.response.Output = append(s.response.Output, *item)
+	s.item = nil
+	s.text.Reset()
+	s.currentTool = -1
+
+	return events
+}
+
+func (s *ResponseAPIStreamConverter) openItem(item OutputItem) []map[string]any {
+	events := s.closeItem()
+	item.Status = "in_progress"
+	s.item = &item
+
+	index := s.outputIndex()
+	events = append(events, s.event("response.output_item.added", map[string]any{
+		"output_index": index, "item": item,
+	}))
+	switch item.Type {
+	case "reasoning":
+		events = append(events, s.event("response.reasoning_summary_part.added", map[string]any{
+			"item_id": item.Id, "output_index": index, "summary_index": 0,
+			"part": OutputContent{Type: "summary_text"},
+		}))
+	case "message":
+		events = append(events, s.event("response.content_part.added", map[string]any{
+			"item_id": item.Id, "output_index": index, "content_index": 0,
+			"part": OutputContent{Type: "output_text", Annotations: []any{}},
+		}))
+	}
+
+	return events
+}
+
+// Convert converts one OpenAI chunk to zero or more Response API events
+func (s *ResponseAPIStreamConverter) Convert(chunk *ChatCompletionsStreamResponse) []map[string]any {
+	events := s.start(chunk.Id, chunk.Created)
+	if chunk.Usage != nil {
+		s.usage = chunk.Usage
+	}
+
+	for _, choice := range chunk.Choices {
+		if reasoning := reasoningOfMessage(&choice.Delta); reasoning != "" {
+			if s.item == nil || s.item.Type != "reasoning" {
+				events = append(events, s.openItem(OutputItem{
+					Type:    "reasoning",
+					Id:      "rs_" + random.GetUUID(),
+					Summary: []OutputContent{},
+				})...)
+			}
+			s.text.WriteString(reasoning)
+			events = append(events, s.event("response.reasoning_summary_text.delta", map[string]any{
+				"item_id": s.item.Id, "output_index": s.outputIndex(), "summary_index": 0, "delta": reasoning,
+			}))
+		}
+
+		if text, ok := choice.Delta.Content.(string); ok && text != "" {
+			if s.item == nil || s.item.Type != "message" {
+				events = append(events, s.openItem(OutputItem{
+					Type:    "message",
+					Id:      "msg_" + random.GetUUID(),
+					Role:    "assistant",
+					Content: []OutputContent{},
+				})...)
+			}
+			s.text.WriteString(text)
+			events = append(events, s.event("response.output_text.delta", map[string]any{
+				"item_id": s.item.Id, "output_index": s.outputIndex(), "content_index": 0, "delta": text,
+			}))
+		}
+
+		for i, tool := range choice.Delta.ToolCalls {
+			toolIndex := i
+			if tool.Index != nil {
+				toolIndex = *tool.Index
+			}
+
+			if s.item == nil || s.item.Type != "function_call" || s.currentTool != toolIndex {
+				if s.toolItems[toolIndex] {
+					logger.Warnf(context.TODO(), "arguments of closed tool call %d arrived late, drop them", toolIndex)
+					continue
+				}
+				fcId, callId := convertToolCallIDToResponseAPI(toolCallId(tool.Id))
+				events = append(events, s.openItem(OutputItem{
+					Type:   "function_call",
+					Id:     fcId,
+					CallId: callId,
+					Name:   tool.Function.Name,
+				})...)
+				s.toolItems[toolIndex] = true
+				s.currentTool = toolIndex
+			}
+
+			if args, ok := tool.Function.Arguments.(string); ok && args != "" {
+				s.text.WriteString(args)
+				events = append(events, s.event("response.function_call_arguments.delta", map[string]any{
+					"item_id": s.item.Id, "output_index": s.outputIndex(), "delta": args,
+				}))
+			}
+		}
+
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			s.finishReason = *choice.FinishReason
+		}
+	}
+
+	return events
+}
+
+// Finish closes the stream with response.completed or response.incomplete,
+// usage is the billed usage of the request, the last usage reported by upstream is used if it is nil.
+func (s *ResponseAPIStreamConverter) Finish(usage *model.Usage) []map[string]any {
+	events := s.start("", 0)
+	events = append(events, s.closeItem()...)
+
+	if usage == nil {
+		usage = s.usage
+	}
+	s.response.Usage = (&ResponseAPIUsage{}).FromModelUsage(usage)
+	s.response.Status, s.response.IncompleteDetails = responseAPIStatus(s.finishReason)
+
+	return append(events, s.event("response."+s.response.Status, map[string]any{
+		"response": s.response,
+	}))
+}
+
+// RenderResponseAPIStreamEvent writes one server-sent event in the Response API format
+func RenderResponseAPIStreamEvent(w gin.ResponseWriter, event map[string]any) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return errors.Wrap(err, "marshal stream event")
+	}
+
+	if _, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event["type"], data); err != nil {
+		return errors.Wrap(err, "write stream event")
+	}
+	w.Flush()
+	return nil
+}
@@ -0,0 +1,259 @@
+package openai
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+func TestConvertResponseAPIRequest(t *testing.T) {
+	raw := `{
+		"model": "claude-sonnet-4",
+		"instructions": "be brief",
+		"max_output_tokens": 256,
+		"stream": true,
+		"reasoning": {"effort": "low"},
+		"text": {"format": {"type": "json_schema", "name": "weather", "schema": {"type": "object"}}},
+		"tools": [
+			{"type": "function", "name": "get_weather", "parameters": {"type": "object"}},
+			{"type": "web_search_preview"}
+		],
+		"tool_choice": {"type": "function", "name": "get_weather"},
+		"input": [
+			{"role": "developer", "content": "answer in English"},
+			{"role": "user", "content": [
+				{"type": "input_text", "text": "weather in Paris?"},
+				{"type": "input_image", "image_url": "https://example.com/a.png"}
+			]},
+			{"type": "reasoning", "summary": [{"type": "summary_text", "text": "planning"}]},
+			{"type": "function_call", "call_id": "call_1", "name": "get_weather", "arguments": "{\"city\":\"Paris\"}"},
+			{"type": "function_call", "call_id": "call_2", "name": "get_weather", "arguments": "{\"city\":\"Lyon\"}"},
+			{"type": "function_call_output", "call_id": "call_1", "output": "sunny"},
+			{"type": "function_call_output", "call_id": "call_2", "output": {"result": "rainy"}},
+			{"type": "message", "role": "assistant", "content": [{"type": "output_text", "text": "Sunny in Paris."}]}
+		]
+	}`
+
+	request := new(ResponseAPIRequest)
+	if err := json.Unmarshal([]byte(raw), request); err != nil {
+		t.Fatalf("unmarshal request: %v", err)
+	}
+
+	textRequest, err := ConvertResponseAPIRequest(request)
+	if err != nil {
+		t.Fatalf("ConvertResponseAPIRequest failed: %v", err)
+	}
+
+	if !textRequest.Stream || textRequest.StreamOptions == nil || !textRequest.StreamOptions.IncludeUsage {
+		t.Error("expected stream with usage")
+	}
+	if textRequest.MaxTokens != 256 {
+		t.Errorf("expected max_tokens 256, got %d", textRequest.MaxTokens)
+	}
+	if textRequest.ReasoningEffort == nil || *textRequest.ReasoningEffort != "low" {
+		t.Error("expected reasoning_effort low")
+	}
+	if textRequest.ResponseFormat == nil || textRequest.ResponseFormat.JsonSchema == nil ||
+		textRequest.ResponseFormat.JsonSchema.Name != "weather" {
+		t.Errorf("unexpected response_format: %+v", textRequest.ResponseFormat)
+	}
+
+	// built-in tools are skipped
+	if len(textRequest.Tools) != 1 || textRequest.Tools[0].Function.Name != "get_weather" {
+		t.Errorf("unexpected tools: %+v", textRequest.Tools)
+	}
+	toolChoice, _ := json.Marshal(textRequest.ToolChoice)
+	if string(toolChoice) != `{"function":{"name":"get_weather"},"type":"function"}` {
+		t.Errorf("unexpected tool_choice: %s", toolChoice)
+	}
+
+	roles := make([]string, 0, len(textRequest.Messages))
+	for _, message := range textRequest.Messages {
+		roles = append(roles, message.Role)
+	}
+	expectedRoles := []string{"system", "system", "user", "assistant", "tool", "tool", "assistant"}
+	if len(roles) != len(expectedRoles) {
+		t.Fatalf("expected roles %v, got %v", expectedRoles, roles)
+	}
+	for i := range roles {
+		if roles[i] != expectedRoles[i] {
+			t.Fatalf("expected roles %v, got %v", expectedRoles, roles)
+		}
+	}
+
+	if parts := textRequest.Messages[2].ParseContent(); len(parts) != 2 || parts[1].ImageURL.Url != "https://example.com/a.png" {
+		t.Errorf("unexpected user content: %+v", parts)
+	}
+	// parallel function calls are merged into one assistant message
+	if calls := textRequest.Messages[3].ToolCalls; len(calls) != 2 || calls[1].Id != "call_2" {
+		t.Errorf("unexpected tool calls: %+v", calls)
+	}
+	if textRequest.Messages[5].ToolCallId != "call_2" || textRequest.Messages[5].Content != `{"result":"rainy"}` {
+		t.Errorf("unexpected tool message: %+v", textRequest.Messages[5])
+	}
+	if textRequest.Messages[6].Content != "Sunny in Paris." {
+		t.Errorf("unexpected assistant content: %v", textRequest.Messages[6].Content)
+	}
+}
+
+func TestConvertResponseAPIRequest_Unsupported(t *testing.T) {
+	previousResponseId := "resp_1"
+	requests := map[string]*ResponseAPIRequest{
+		"previous_response_id": {Model: "m", Input: ResponseAPIInput{"hi"}, PreviousResponseId: &previousResponseId},
+		"prompt":               {Model: "m", Prompt: &ResponseAPIPrompt{Id: "pmpt_1"}},
+		"input_file": {Model: "m", Input: ResponseAPIInput{map[string]any{
+			"role":    "user",
+			"content": []any{map[string]any{"type": "input_file", "file_id": "file-1"}},
+		}}},
+		"item_reference": {Model: "m", Input: ResponseAPIInput{map[string]any{"type": "item_reference", "id": "msg_1"}}},
+	}
+
+	for name, request := range requests {
+		if _, err := ConvertResponseAPIRequest(request); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestConvertTextResponseToResponseAPI(t *testing.T) {
+	reasoning := "thinking"
+	maxOutputTokens := 10
+	response := &TextResponse{
+		Id:      "chatcmpl-abc",
+		Model:   "claude-sonnet-4",
+		Created: 1700000000,
+		Choices: []TextResponseChoice{{
+			Message: model.Message{
+				Role:      "assistant",
+				Content:   "hello",
+				Reasoning: &reasoning,
+				ToolCalls: []model.Tool{{
+					Id:       "toolu_1",
+					Type:     "function",
+					Function: model.Function{Name: "f", Arguments: `{"a":1}`},
+				}},
+			},
+			FinishReason: "length",
+		}},
+		Usage: model.Usage{PromptTokens: 3, CompletionTokens: 10, TotalTokens: 13},
+	}
+
+	responseAPIResp := ConvertTextResponseToResponseAPI(response, &ResponseAPIRequest{MaxOutputTokens: &maxOutputTokens})
+	if responseAPIResp.Id != "resp_abc" || responseAPIResp.Object != "response" {
+		t.Errorf("unexpected id or object: %s %s", responseAPIResp.Id, responseAPIResp.Object)
+	}
+	if responseAPIResp.Status != "incomplete" || responseAPIResp.IncompleteDetails.Reason != "max_output_tokens" {
+		t.Errorf("unexpected status: %s %+v", responseAPIResp.Status, responseAPIResp.IncompleteDetails)
+	}
+	if responseAPIResp.MaxOutputTokens == nil || *responseAPIResp.MaxOutputTokens != 10 {
+		t.Error("expected max_output_tokens to be echoed")
+	}
+	if responseAPIResp.Usage.InputTokens != 3 || responseAPIResp.Usage.OutputTokens != 10 {
+		t.Errorf("unexpected usage: %+v", responseAPIResp.Usage)
+	}
+
+	if len(responseAPIResp.Output) != 3 {
+		t.Fatalf("expected 3 output items, got %d", len(responseAPIResp.Output))
+	}
+	if item := responseAPIResp.Output[0]; item.Type != "reasoning" || item.Summary[0].Text != reasoning {
+		t.Errorf("unexpected reasoning item: %+v", item)
+	}
+	if item := responseAPIResp.Output[1]; item.Type != "message" || item.Content[0].Text != "hello" {
+		t.Errorf("unexpected message item: %+v", item)
+	}
+	if item := responseAPIResp.Output[2]; item.Type != "function_call" || item.CallId != "call_toolu_1" || item.Arguments != `{"a":1}` {
+		t.Errorf("unexpected function call item: %+v", item)
+	}
+
+	// the converted response could be read back by the existing conversion
+	textResponse := ConvertResponseAPIToChatCompletion(responseAPIResp)
+	if textResponse.Choices[0].Message.StringContent() != "hello" {
+		t.Errorf("unexpected round trip content: %v", textResponse.Choices[0].Message.Content)
+	}
+}
+
+func TestResponseAPIStreamConverter(t *testing.T) {
+	chunk := func(raw string) *ChatCompletionsStreamResponse {
+		resp := new(ChatCompletionsStreamResponse)
+		if err := json.Unmarshal([]byte(raw), resp); err != nil {
+			t.Fatalf("unmarshal chunk: %v", err)
+		}
+		return resp
+	}
+
+	converter := NewResponseAPIStreamConverter("claude-sonnet-4", nil)
+	var events []map[string]any
+	for _, raw := range []string{
+		`{"id":"chatcmpl-1","choices":[{"index":0,"delta":{"role":"assistant","reasoning_content":"hmm"}}]}`,
+		`{"id":"chatcmpl-1","choices":[{"index":0,"delta":{"content":"Hi"}}]}`,
+		`{"id":"chatcmpl-1","choices":[{"index":0,"delta":{"content":"!"}}]}`,
+		`{"id":"chatcmpl-1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_9","type":"function","function":{"name":"f","arguments":"{\"a\""}}]}}]}`,
+		`{"id":"chatcmpl-1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":":1}"}}]}}]}`,
+		`{"id":"chatcmpl-1","choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`,
+	} {
+		events = append(events, converter.Convert(chunk(raw))...)
+	}
+	events = append(events, converter.Finish(&model.Usage{PromptTokens: 5, CompletionTokens: 7, TotalTokens: 12})...)
+
+	expectedTypes := []string{
+		"response.created",
+		"response.in_progress",
+		"response.output_item.added",
+		"response.reasoning_summary_part.added",
+		"response.reasoning_summary_text.delta",
+		"response.reasoning_summary_text.done",
+		"response.reasoning_summary_part.done",
+		"response.output_item.done",
+		"response.output_item.added",
+		"response.content_part.added",
+		"response.output_text.delta",
+		"response.output_text.delta",
+		"response.output_text.done",
+		"response.content_part.done",
+		"response.output_item.done",
+		"response.output_item.added",
+		"response.function_call_arguments.delta",
+		"response.function_call_arguments.delta",
+		"response.function_call_arguments.done",
+		"response.output_item.done",
+		"response.completed",
+	}
+	if len(events) != len(expectedTypes) {
+		t.Fatalf("expected %d events, got %d", len(expectedTypes), len(events))
+	}
+	for i, event := range events {
+		if event["type"] != expectedTypes[i] {
+			t.Errorf("event %d: expected %s, got %v", i, expectedTypes[i], event["type"])
+		}
+		if event["sequence_number"] != i {
+			t.Errorf("event %d: unexpected sequence_number %v", i, event["sequence_number"])
+		}
+	}
+
+	if events[12]["text"] != "Hi!" || events[12]["output_index"] != 1 {
+		t.Errorf("unexpected output_text.done: %v", events[12])
+	}
+	if events[18]["arguments"] != `{"a":1}` || events[18]["output_index"] != 2 {
+		t.Errorf("unexpected function_call_arguments.done: %v", events[18])
+	}
+
+	// every event could be parsed by the existing stream parser
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			t.Fatalf("marshal event: %v", err)
+		}
+		if _, _, err = ParseResponseAPIStreamEvent(data); err != nil {
+			t.Errorf("parse event %s: %v", data, err)
+		}
+	}
+
+	completed := events[len(events)-1]["response"].(*ResponseAPIResponse)
+	if completed.Id != "resp_1" || completed.Status != "completed" || completed.Usage.TotalTokens != 12 {
+		t.Errorf("unexpected completed response: %+v", completed)
+	}
+	if len(completed.Output) != 3 || completed.Output[2].CallId != "call_9" || completed.Output[2].Id != "fc_9" {
+		t.Errorf("unexpected output: %+v", completed.Output)
+	}
+}
@@ -159,6 +159,47 @@ func findToolCallName(toolCalls []model.Tool, toolCallId string) string {
 	return "unknown_function"
 }
 
+// convertMessageContentToResponseAPI converts content parts of a chat message to
+// the input_text/input_image/input_file parts of the Response API, string content is kept as is.
+func convertMessageContentToResponseAPI(message model.Message) model.Message {
+	if message.Content == nil || message.IsStringContent() {
+		return message
+	}
+
+	textType := "input_text"
+	if message.Role == "assistant" {
+		textType = "output_text"
+	}
+	contents := make([]map[string]any, 0)
+	for _, part := range message.ParseContent() {
+		switch {
+		case part.Type == model.ContentTypeText && part.Text != nil:
+			contents = append(contents, map[string]any{"type": textType, "text": *part.Text})
+		case part.Type == model.ContentTypeImageURL && part.ImageURL != nil:
+			content := map[string]any{"type": "input_image", "image_url": part.ImageURL.Url}
+			if part.ImageURL.Detail != "" {
+				content["detail"] = part.ImageURL.Detail
+			}
+			contents = append(contents, content)
+		case part.Type == model.ContentTypeFile && part.File != nil:
+			content := map[string]any{"type": "input_file"}
+			if part.File.FileId != "" {
+				content["file_id"] = part.File.FileId
+			}
+			if part.File.FileData != "" {
+				content["file_data"] = part.File.FileData
+			}
+			if part.File.Filename != "" {
+				content["filename"] = part.File.Filename
+			}
+			contents = append(contents, content)
+		}
+	}
+
+	message.Content = contents
+	return message
+}
+
 // ConvertChatCompletionToResponseAPI converts a ChatCompletion request to Response API format
 func ConvertChatCompletionToResponseAPI(request *model.GeneralOpenAIRequest) *ResponseAPIRequest {
 	responseReq := &ResponseAPIRequest{
@@ -218,7 +259,7 @@ func ConvertChatCompletionToResponseAPI(request *model.GeneralOpenAIRequest) *Re
 			}
 
 			// Add the regular message
-			responseReq.Input = append(responseReq.Input, message)
+			responseReq.Input = append(responseReq.Input, convertMessageContentToResponseAPI(message))
 		}
 	}
 
@@ -1686,3 +1686,28 @@ func TestResponseAPIUsageWithFallback(t *testing.T) {
 			chatCompletion.Usage.PromptTokens, chatCompletion.Usage.CompletionTokens)
 	}
 }
+
+func TestConvertChatCompletionToResponseAPIWithContentParts(t *testing.T) {
+	chatRequest := &model.GeneralOpenAIRequest{
+		Model: "gpt-4o",
+		Messages: []model.Message{{
+			Role: "user",
+			Content: []any{
+				map[string]any{"type": "text", "text": "summarize"},
+				map[string]any{"type": "image_url", "image_url": map[string]any{"url": "https://example.com/a.png"}},
+				map[string]any{"type": "file", "file": map[string]any{"file_data": "data:application/pdf;base64,cGRm", "filename": "a.pdf"}},
+			},
+		}},
+	}
+
+	responseAPI := ConvertChatCompletionToResponseAPI(chatRequest)
+	data, err := json.Marshal(responseAPI.Input[0].(model.Message).Content)
+	if err != nil {
+		t.Fatalf("marshal content: %v", err)
+	}
+
+	expected := `[{"text":"summarize","type":"input_text"},{"image_url":"https://example.com/a.png","type":"input_image"},{"file_data":"data:application/pdf;base64,cGRm","filename":"a.pdf","type":"input_file"}]`
+	if string(data) != expected {
+		t.Errorf("expected content %s, got %s", expected, data)
+	}
+}
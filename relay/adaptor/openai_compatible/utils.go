@@ -160,6 +160,7 @@ func StreamHandler(c *gin.Context, resp *http.Response, promptTokens int, modelN
 		}
 		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
 	}
+	usage.FillCachedTokens()
 
 	return nil, usage
 }
@@ -206,6 +207,7 @@ func Handler(c *gin.Context, resp *http.Response, promptTokens int, modelName st
 	if usage.TotalTokens == 0 {
 		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
 	}
+	usage.FillCachedTokens()
 
 	return nil, &usage
 }
@@ -14,7 +14,7 @@ func ReturnPreConsumedQuota(ctx context.Context, preConsumedQuota int64, tokenId
 	if preConsumedQuota != 0 {
 		go func(ctx context.Context) {
 			// return pre-consumed quota
-			err := model.PostConsumeTokenQuota(tokenId, -preConsumedQuota)
+			err := model.PostConsumeTokenQuota(ctx, tokenId, -preConsumedQuota)
 			if err != nil {
 				logger.Error(ctx, "error return pre-consumed quota: "+err.Error())
 			}
@@ -25,7 +25,7 @@ func ReturnPreConsumedQuota(ctx context.Context, preConsumedQuota int64, tokenId
 // PostConsumeQuota handles simple billing for Audio API (legacy compatibility)
 // SAFETY: This function is preserved for backward compatibility with Audio API
 // WARNING: This function logs totalQuota as promptTokens and sets completionTokens to 0
-func PostConsumeQuota(ctx context.Context, tokenId int, quotaDelta int64, totalQuota int64, userId int, channelId int, modelRatio float64, groupRatio float64, modelName string, tokenName string) {
+func PostConsumeQuota(ctx context.Context, tokenId int, quotaDelta int64, totalQuota int64, userId int, channelId int, channelKey string, modelRatio float64, groupRatio float64, modelName string, tokenName string) {
 	// Input validation for safety
 	if ctx == nil {
 		logger.SysError("PostConsumeQuota: context is nil")
@@ -49,7 +49,7 @@ func PostConsumeQuota(ctx context.Context, tokenId int, quotaDelta int64, totalQ
 	}
 
 	// quotaDelta is remaining quota to be consumed
-	err := model.PostConsumeTokenQuota(tokenId, quotaDelta)
+	err := model.PostConsumeTokenQuota(ctx, tokenId, quotaDelta)
 	if err != nil {
 		logger.SysError("error consuming token remain quota: " + err.Error())
 	}
@@ -63,6 +63,7 @@ func PostConsumeQuota(ctx context.Context, tokenId int, quotaDelta int64, totalQ
 	model.RecordConsumeLog(ctx, &model.Log{
 		UserId:           userId,
 		ChannelId:        channelId,
+		ChannelKey:       channelKey,
 		PromptTokens:     int(totalQuota), // NOTE: For Audio API, total quota is logged as prompt tokens
 		CompletionTokens: 0,               // NOTE: Audio API doesn't have separate completion tokens
 		ModelName:        modelName,
@@ -75,6 +76,7 @@ func PostConsumeQuota(ctx context.Context, tokenId int, quotaDelta int64, totalQ
 	if totalQuota > 0 {
 		model.UpdateUserUsedQuotaAndRequestCount(userId, totalQuota)
 		model.UpdateChannelUsedQuota(channelId, totalQuota)
+		model.RecordSpend(ctx, userId, tokenId, totalQuota)
 	}
 	if totalQuota <= 0 {
 		logger.Error(ctx, fmt.Sprintf("totalQuota consumed is %d, something is wrong", totalQuota))
@@ -83,12 +85,13 @@ func PostConsumeQuota(ctx context.Context, tokenId int, quotaDelta int64, totalQ
 
 // PostConsumeQuotaDetailed handles detailed billing for ChatCompletion and Response API requests
 // This function properly logs individual prompt and completion tokens with additional metadata
+// cachedTokens and cacheWriteTokens are the parts of promptTokens read from and written to the prompt cache
 // SAFETY: This function validates all inputs to prevent billing errors
 func PostConsumeQuotaDetailed(ctx context.Context, tokenId int, quotaDelta int64, totalQuota int64,
-	userId int, channelId int, promptTokens int, completionTokens int,
+	userId int, channelId int, channelKey string, promptTokens int, completionTokens int,
 	modelRatio float64, groupRatio float64, modelName string, tokenName string,
 	isStream bool, startTime time.Time, systemPromptReset bool,
-	completionRatio float64, toolsCost int64) {
+	completionRatio float64, toolsCost int64, cachedTokens int, cacheWriteTokens int) {
 	// Input validation for safety
 	if ctx == nil {
 		logger.SysError("PostConsumeQuotaDetailed: context is nil")
@@ -106,8 +109,9 @@ func PostConsumeQuotaDetailed(ctx context.Context, tokenId int, quotaDelta int64
 		logger.Error(ctx, fmt.Sprintf("PostConsumeQuotaDetailed: invalid channelId %d", channelId))
 		return
 	}
-	if promptTokens < 0 || completionTokens < 0 {
-		logger.Error(ctx, fmt.Sprintf("PostConsumeQuotaDetailed: negative token counts - prompt: %d, completion: %d", promptTokens, completionTokens))
+	if promptTokens < 0 || completionTokens < 0 || cachedTokens < 0 || cacheWriteTokens < 0 {
+		logger.Error(ctx, fmt.Sprintf("PostConsumeQuotaDetailed: negative token counts - prompt: %d, completion: %d, cached: %d, cache write: %d",
+			promptTokens, completionTokens, cachedTokens, cacheWriteTokens))
 		return
 	}
 	if modelName == "" {
@@ -116,7 +120,7 @@ func PostConsumeQuotaDetailed(ctx context.Context, tokenId int, quotaDelta int64
 	}
 
 	// quotaDelta is remaining quota to be consumed
-	err := model.PostConsumeTokenQuota(tokenId, quotaDelta)
+	err := model.PostConsumeTokenQuota(ctx, tokenId, quotaDelta)
 	if err != nil {
 		logger.SysError("error consuming token remain quota: " + err.Error())
 	}
@@ -133,9 +137,13 @@ func PostConsumeQuotaDetailed(ctx context.Context, tokenId int, quotaDelta int64
 	} else {
 		logContent = fmt.Sprintf("model rate %.2f, group rate %.2f, completion rate %.2f, tools cost %d", modelRatio, groupRatio, completionRatio, toolsCost)
 	}
+	if cachedTokens != 0 || cacheWriteTokens != 0 {
+		logContent += fmt.Sprintf(", cached tokens %d, cache write tokens %d", cachedTokens, cacheWriteTokens)
+	}
 	model.RecordConsumeLog(ctx, &model.Log{
 		UserId:            userId,
 		ChannelId:         channelId,
+		ChannelKey:        channelKey,
 		PromptTokens:      promptTokens,
 		CompletionTokens:  completionTokens,
 		ModelName:         modelName,
@@ -145,12 +153,15 @@ func PostConsumeQuotaDetailed(ctx context.Context, tokenId int, quotaDelta int64
 		IsStream:          isStream,
 		ElapsedTime:       helper.CalcElapsedTime(startTime),
 		SystemPromptReset: systemPromptReset,
+		CachedTokens:      cachedTokens,
+		CacheWriteTokens:  cacheWriteTokens,
 	})
 
 	// Only update quotas when totalQuota > 0
 	if totalQuota > 0 {
 		model.UpdateUserUsedQuotaAndRequestCount(userId, totalQuota)
 		model.UpdateChannelUsedQuota(channelId, totalQuota)
+		model.RecordSpend(ctx, userId, tokenId, totalQuota)
 	}
 	if totalQuota <= 0 {
 		logger.Error(ctx, fmt.Sprintf("totalQuota consumed is %d, something is wrong", totalQuota))
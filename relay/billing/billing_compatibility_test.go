@@ -63,7 +63,7 @@ func TestInputValidation(t *testing.T) {
 			name: "PostConsumeQuota - Invalid TokenId",
 			testFunc: func() bool {
 				defer func() { recover() }() // Catch any panics
-				PostConsumeQuota(ctx, -1, 10, 50, 1, 5, 1.0, 1.0, "test-model", "test-token")
+				PostConsumeQuota(ctx, -1, 10, 50, 1, 5, "", 1.0, 1.0, "test-model", "test-token")
 				return true
 			},
 			shouldFail:  true,
@@ -73,7 +73,7 @@ func TestInputValidation(t *testing.T) {
 			name: "PostConsumeQuota - Invalid UserId",
 			testFunc: func() bool {
 				defer func() { recover() }()
-				PostConsumeQuota(ctx, 123, 10, 50, -1, 5, 1.0, 1.0, "test-model", "test-token")
+				PostConsumeQuota(ctx, 123, 10, 50, -1, 5, "", 1.0, 1.0, "test-model", "test-token")
 				return true
 			},
 			shouldFail:  true,
@@ -83,7 +83,7 @@ func TestInputValidation(t *testing.T) {
 			name: "PostConsumeQuota - Empty ModelName",
 			testFunc: func() bool {
 				defer func() { recover() }()
-				PostConsumeQuota(ctx, 123, 10, 50, 1, 5, 1.0, 1.0, "", "test-token")
+				PostConsumeQuota(ctx, 123, 10, 50, 1, 5, "", 1.0, 1.0, "", "test-token")
 				return true
 			},
 			shouldFail:  true,
@@ -93,8 +93,8 @@ func TestInputValidation(t *testing.T) {
 			name: "PostConsumeQuotaDetailed - Negative Tokens",
 			testFunc: func() bool {
 				defer func() { recover() }()
-				PostConsumeQuotaDetailed(ctx, 123, 10, 50, 1, 5, -10, 20, 1.0, 1.0, "test-model", "test-token",
-					false, validTime, false, 1.0, 0)
+				PostConsumeQuotaDetailed(ctx, 123, 10, 50, 1, 5, "", -10, 20, 1.0, 1.0, "test-model", "test-token",
+					false, validTime, false, 1.0, 0, 0, 0)
 				return true
 			},
 			shouldFail:  true,
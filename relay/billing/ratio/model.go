@@ -33,13 +33,17 @@ const (
 
 // AudioRatio represents the price ratio between audio tokens and text tokens
 var AudioRatio = map[string]float64{
-	"gpt-4o-audio-preview":                 16,
-	"gpt-4o-audio-preview-2024-12-17":      16,
-	"gpt-4o-audio-preview-2024-10-01":      40,
-	"gpt-4o-mini-audio-preview":            10 / 0.15,
-	"gpt-4o-mini-audio-preview-2024-12-17": 10 / 0.15,
-	"gpt-4o-transcribe":                    6 / 2.5,
-	"gpt-4o-mini-transcribe":               3 / 1.25,
+	"gpt-4o-audio-preview":                    16,
+	"gpt-4o-audio-preview-2024-12-17":         16,
+	"gpt-4o-audio-preview-2024-10-01":         40,
+	"gpt-4o-mini-audio-preview":               10 / 0.15,
+	"gpt-4o-mini-audio-preview-2024-12-17":    10 / 0.15,
+	"gpt-4o-transcribe":                       6 / 2.5,
+	"gpt-4o-mini-transcribe":                  3 / 1.25,
+	"gpt-4o-realtime-preview":                 40 / 5,
+	"gpt-4o-realtime-preview-2025-06-03":      40 / 5,
+	"gpt-4o-mini-realtime-preview":            10 / 0.6,
+	"gpt-4o-mini-realtime-preview-2024-12-17": 10 / 0.6,
 }
 
 // GetAudioPromptRatio returns the audio prompt ratio for the given model.
@@ -56,12 +60,16 @@ func GetAudioPromptRatio(actualModelName string) float64 {
 
 // AudioCompletionRatio is the completion ratio for audio models.
 var AudioCompletionRatio = map[string]float64{
-	"whisper-1":                            0,
-	"gpt-4o-audio-preview":                 2,
-	"gpt-4o-audio-preview-2024-12-17":      2,
-	"gpt-4o-audio-preview-2024-10-01":      2,
-	"gpt-4o-mini-audio-preview":            2,
-	"gpt-4o-mini-audio-preview-2024-12-17": 2,
+	"whisper-1":                               0,
+	"gpt-4o-audio-preview":                    2,
+	"gpt-4o-audio-preview-2024-12-17":         2,
+	"gpt-4o-audio-preview-2024-10-01":         2,
+	"gpt-4o-mini-audio-preview":               2,
+	"gpt-4o-mini-audio-preview-2024-12-17":    2,
+	"gpt-4o-realtime-preview":                 2,
+	"gpt-4o-realtime-preview-2025-06-03":      2,
+	"gpt-4o-mini-realtime-preview":            2,
+	"gpt-4o-mini-realtime-preview-2024-12-17": 2,
 }
 
 // GetAudioCompletionRatio returns the completion ratio for audio models.
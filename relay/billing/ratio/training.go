@@ -0,0 +1,69 @@
+package ratio
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+// DefaultTrainingRatio is used for fine-tuning models without a configured training ratio,
+// which is the highest price of OpenAI to be on the safe side.
+const DefaultTrainingRatio = 25 * MilliTokensUsd
+
+var trainingRatioLock sync.RWMutex
+
+// TrainingRatio is the quota charged for each trained token of fine-tuning jobs, by base model.
+//
+// https://openai.com/api/pricing/
+var TrainingRatio = map[string]float64{
+	"gpt-4.1-2025-04-14":      25 * MilliTokensUsd,
+	"gpt-4.1-mini-2025-04-14": 5 * MilliTokensUsd,
+	"gpt-4.1-nano-2025-04-14": 1.5 * MilliTokensUsd,
+	"gpt-4o-2024-08-06":       25 * MilliTokensUsd,
+	"gpt-4o-mini-2024-07-18":  3 * MilliTokensUsd,
+	"gpt-3.5-turbo":           8 * MilliTokensUsd,
+	"davinci-002":             6 * MilliTokensUsd,
+	"babbage-002":             0.4 * MilliTokensUsd,
+}
+
+func TrainingRatio2JSONString() string {
+	trainingRatioLock.RLock()
+	defer trainingRatioLock.RUnlock()
+	jsonBytes, err := json.Marshal(TrainingRatio)
+	if err != nil {
+		logger.SysError("error marshalling training ratio: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateTrainingRatioByJSONString(jsonStr string) error {
+	trainingRatioLock.Lock()
+	defer trainingRatioLock.Unlock()
+	TrainingRatio = make(map[string]float64)
+	return json.Unmarshal([]byte(jsonStr), &TrainingRatio)
+}
+
+// GetTrainingRatio returns the training ratio of the base model of a fine-tuning job.
+// Models like gpt-3.5-turbo-0125 fall back to the longest configured prefix.
+func GetTrainingRatio(modelName string) float64 {
+	trainingRatioLock.RLock()
+	defer trainingRatioLock.RUnlock()
+	if ratio, ok := TrainingRatio[modelName]; ok {
+		return ratio
+	}
+
+	matched := ""
+	for name := range TrainingRatio {
+		if strings.HasPrefix(modelName, name) && len(name) > len(matched) {
+			matched = name
+		}
+	}
+	if matched != "" {
+		return TrainingRatio[matched]
+	}
+
+	logger.SysError("training ratio not found: " + modelName)
+	return DefaultTrainingRatio
+}
@@ -27,7 +27,7 @@ func TestZeroQuotaFix(t *testing.T) {
 
 		// Before the fix: this would skip logging entirely when totalQuota == 0
 		// After the fix: this will attempt to log (and may panic on DB operations, which is fine)
-		PostConsumeQuota(ctx, 123, 10, 0, 1, 5, 1.0, 1.0, "test-model", "test-token")
+		PostConsumeQuota(ctx, 123, 10, 0, 1, 5, "", 1.0, 1.0, "test-model", "test-token")
 
 		// If we reach here, the function completed without database operations
 		// This is also acceptable behavior
@@ -44,8 +44,8 @@ func TestZeroQuotaFix(t *testing.T) {
 
 		// Before the fix: this would skip logging entirely when totalQuota == 0
 		// After the fix: this will attempt to log (and may panic on DB operations, which is fine)
-		PostConsumeQuotaDetailed(ctx, 123, 10, 0, 1, 5, 10, 20, 1.0, 1.0, "test-model", "test-token",
-			false, validTime, false, 1.0, 0)
+		PostConsumeQuotaDetailed(ctx, 123, 10, 0, 1, 5, "", 10, 20, 1.0, 1.0, "test-model", "test-token",
+			false, validTime, false, 1.0, 0, 0, 0)
 
 		t.Log("Function completed without database panic")
 	})
@@ -58,7 +58,7 @@ func TestZeroQuotaFix(t *testing.T) {
 			}
 		}()
 
-		PostConsumeQuota(ctx, 123, 10, 50, 1, 5, 1.0, 1.0, "test-model", "test-token")
+		PostConsumeQuota(ctx, 123, 10, 50, 1, 5, "", 1.0, 1.0, "test-model", "test-token")
 		t.Log("Function completed")
 	})
 
@@ -70,8 +70,8 @@ func TestZeroQuotaFix(t *testing.T) {
 			}
 		}()
 
-		PostConsumeQuotaDetailed(ctx, 123, 10, 100, 1, 5, 10, 20, 1.0, 1.0, "test-model", "test-token",
-			false, validTime, false, 1.0, 0)
+		PostConsumeQuotaDetailed(ctx, 123, 10, 100, 1, 5, "", 10, 20, 1.0, 1.0, "test-model", "test-token",
+			false, validTime, false, 1.0, 0, 0, 0)
 		t.Log("Function completed")
 	})
 }
@@ -0,0 +1,187 @@
+// Package circuitbreaker stops sending requests of a model to a channel which keeps failing on it.
+//
+// Each (channel, model) pair has a circuit. The circuit is closed while requests succeed,
+// and opens after config.CircuitBreakerFailureThreshold consecutive failures.
+// Requests are not sent through an open circuit until config.CircuitBreakerOpenSeconds have passed,
+// then the circuit is half-open and lets one probe request through at a time.
+// A successful probe closes the circuit, a failed one opens it again.
+package circuitbreaker
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/metrics"
+)
+
+const (
+	StateClosed   = "closed"
+	StateOpen     = "open"
+	StateHalfOpen = "half_open"
+)
+
+// failureWindow is how long the failures of a closed circuit are remembered
+const failureWindow = 10 * time.Minute
+
+// Circuit is the state of the circuit of a channel serving a model
+type Circuit struct {
+	ChannelId int    `json:"channel_id"`
+	Model     string `json:"model"`
+	State     string `json:"state"`
+	Failures  int    `json:"failures"`
+	// OpenUntil is the unix timestamp when an open circuit turns half-open, 0 for closed circuits
+	OpenUntil int64 `json:"open_until"`
+}
+
+// Store keeps the circuits
+type Store interface {
+	// Allow reports whether a request could be sent through the circuit,
+	// and whether it is the probe request of a half-open circuit
+	Allow(ctx context.Context, channelId int, model string, now time.Time, probeTimeout time.Duration) (allowed bool, probe bool, err error)
+	// RecordSuccess closes the circuit, it reports whether the circuit was tracked before
+	RecordSuccess(ctx context.Context, channelId int, model string) (bool, error)
+	// RecordFailure counts a failure, it reports whether the circuit is opened by the failure
+	RecordFailure(ctx context.Context, channelId int, model string, now time.Time, threshold int, openDuration time.Duration) (bool, error)
+	// Trip opens the circuit until the given time regardless of its failures
+	Trip(ctx context.Context, channelId int, model string, now time.Time, until time.Time) error
+	// List returns the circuits which are not closed or have failures
+	List(ctx context.Context, now time.Time) ([]*Circuit, error)
+}
+
+var (
+	defaultStore     Store
+	defaultStoreOnce sync.Once
+	// now is replaced by tests
+	now = time.Now
+)
+
+// getStore returns the shared store, which is Redis if enabled so that all nodes see the same circuits
+func getStore() Store {
+	defaultStoreOnce.Do(func() {
+		if defaultStore != nil {
+			return
+		}
+		if common.RedisEnabled {
+			defaultStore = newRedisStore(common.RDB)
+			return
+		}
+		defaultStore = newMemoryStore()
+	})
+	return defaultStore
+}
+
+func openDuration() time.Duration {
+	return time.Duration(config.CircuitBreakerOpenSeconds) * time.Second
+}
+
+// Allow reports whether a request of model could be sent to channelId.
+// Errors of the store are logged and the request is allowed, so that an outage of Redis does not stop relaying.
+func Allow(ctx context.Context, channelId int, model string) bool {
+	if !config.CircuitBreakerEnabled {
+		return true
+	}
+
+	allowed, probe, err := getStore().Allow(ctx, channelId, model, now(), openDuration())
+	if err != nil {
+		logger.Errorf(ctx, "failed to check circuit of channel %d, model %s: %+v", channelId, model, err)
+		return true
+	}
+	if probe {
+		logger.Infof(ctx, "circuit of channel %d, model %s is half-open, sending a probe request", channelId, model)
+		metrics.GlobalRecorder.UpdateCircuitBreakerState(channelId, model, StateHalfOpen)
+	}
+
+	return allowed
+}
+
+// RecordSuccess closes the circuit of channelId serving model
+func RecordSuccess(ctx context.Context, channelId int, model string) {
+	if !config.CircuitBreakerEnabled {
+		return
+	}
+
+	tracked, err := getStore().RecordSuccess(ctx, channelId, model)
+	if err != nil {
+		logger.Errorf(ctx, "failed to close circuit of channel %d, model %s: %+v", channelId, model, err)
+		return
+	}
+	if tracked {
+		metrics.GlobalRecorder.UpdateCircuitBreakerState(channelId, model, StateClosed)
+	}
+}
+
+// RecordFailure counts a failure of channelId serving model, which may open the circuit
+func RecordFailure(ctx context.Context, channelId int, model string) {
+	if !config.CircuitBreakerEnabled {
+		return
+	}
+
+	opened, err := getStore().RecordFailure(ctx, channelId, model, now(), config.CircuitBreakerFailureThreshold, openDuration())
+	if err != nil {
+		logger.Errorf(ctx, "failed to record failure on circuit of channel %d, model %s: %+v", channelId, model, err)
+		return
+	}
+	if opened {
+		logger.Warnf(ctx, "circuit of channel %d, model %s is open for %s", channelId, model, openDuration())
+		metrics.GlobalRecorder.UpdateCircuitBreakerState(channelId, model, StateOpen)
+		metrics.GlobalRecorder.RecordCircuitBreakerTrip(channelId, model)
+	}
+}
+
+// Trip opens the circuit of channelId serving model for duration
+func Trip(ctx context.Context, channelId int, model string, duration time.Duration) {
+	if !config.CircuitBreakerEnabled {
+		return
+	}
+
+	current := now()
+	if err := getStore().Trip(ctx, channelId, model, current, current.Add(duration)); err != nil {
+		logger.Errorf(ctx, "failed to open circuit of channel %d, model %s: %+v", channelId, model, err)
+		return
+	}
+	logger.Warnf(ctx, "circuit of channel %d, model %s is open for %s", channelId, model, duration)
+	metrics.GlobalRecorder.UpdateCircuitBreakerState(channelId, model, StateOpen)
+	metrics.GlobalRecorder.RecordCircuitBreakerTrip(channelId, model)
+}
+
+// Reset closes the circuit of channelId serving model
+func Reset(ctx context.Context, channelId int, model string) error {
+	if _, err := getStore().RecordSuccess(ctx, channelId, model); err != nil {
+		return err
+	}
+	metrics.GlobalRecorder.UpdateCircuitBreakerState(channelId, model, StateClosed)
+	return nil
+}
+
+// List returns the circuits which are not closed or have failures, ordered by channel and model
+func List(ctx context.Context) ([]*Circuit, error) {
+	circuits, err := getStore().List(ctx, now())
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(circuits, func(i, j int) bool {
+		if circuits[i].ChannelId != circuits[j].ChannelId {
+			return circuits[i].ChannelId < circuits[j].ChannelId
+		}
+		return circuits[i].Model < circuits[j].Model
+	})
+	return circuits, nil
+}
+
+// stateOf returns the state of a circuit which opens until openUntil
+func stateOf(openUntil time.Time, now time.Time) string {
+	switch {
+	case openUntil.IsZero():
+		return StateClosed
+	case now.Before(openUntil):
+		return StateOpen
+	default:
+		return StateHalfOpen
+	}
+}
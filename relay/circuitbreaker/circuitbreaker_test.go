@@ -0,0 +1,152 @@
+package circuitbreaker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/songquanpeng/one-api/common/config"
+)
+
+// setupTest enables the circuit breaker on a memory store and a fake clock, and returns the function advancing the clock
+func setupTest(t *testing.T) func(time.Duration) {
+	originalEnabled := config.CircuitBreakerEnabled
+	originalThreshold := config.CircuitBreakerFailureThreshold
+	originalOpenSeconds := config.CircuitBreakerOpenSeconds
+	originalStore, originalNow := defaultStore, now
+	t.Cleanup(func() {
+		config.CircuitBreakerEnabled = originalEnabled
+		config.CircuitBreakerFailureThreshold = originalThreshold
+		config.CircuitBreakerOpenSeconds = originalOpenSeconds
+		defaultStore, now = originalStore, originalNow
+	})
+
+	config.CircuitBreakerEnabled = true
+	config.CircuitBreakerFailureThreshold = 3
+	config.CircuitBreakerOpenSeconds = 60
+	defaultStore = newMemoryStore()
+	current := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	now = func() time.Time { return current }
+	return func(d time.Duration) { current = current.Add(d) }
+}
+
+func getState(t *testing.T, channelId int, model string) string {
+	circuits, err := List(context.Background())
+	require.NoError(t, err)
+	for _, circuit := range circuits {
+		if circuit.ChannelId == channelId && circuit.Model == model {
+			return circuit.State
+		}
+	}
+	return StateClosed
+}
+
+func TestCircuitLifecycle(t *testing.T) {
+	advance := setupTest(t)
+	ctx := context.Background()
+
+	// failures below the threshold keep the circuit closed, a success forgets them
+	RecordFailure(ctx, 1, "gpt-4o")
+	RecordFailure(ctx, 1, "gpt-4o")
+	RecordSuccess(ctx, 1, "gpt-4o")
+	RecordFailure(ctx, 1, "gpt-4o")
+	RecordFailure(ctx, 1, "gpt-4o")
+	assert.True(t, Allow(ctx, 1, "gpt-4o"))
+	assert.Equal(t, StateClosed, getState(t, 1, "gpt-4o"))
+
+	RecordFailure(ctx, 1, "gpt-4o")
+	assert.Equal(t, StateOpen, getState(t, 1, "gpt-4o"))
+	assert.False(t, Allow(ctx, 1, "gpt-4o"))
+	// circuits are per model
+	assert.True(t, Allow(ctx, 1, "gpt-4o-mini"))
+	assert.True(t, Allow(ctx, 2, "gpt-4o"))
+
+	// only one probe goes through a half-open circuit
+	advance(time.Minute)
+	assert.Equal(t, StateHalfOpen, getState(t, 1, "gpt-4o"))
+	assert.True(t, Allow(ctx, 1, "gpt-4o"))
+	assert.False(t, Allow(ctx, 1, "gpt-4o"))
+
+	// a failed probe opens the circuit again
+	RecordFailure(ctx, 1, "gpt-4o")
+	assert.Equal(t, StateOpen, getState(t, 1, "gpt-4o"))
+	assert.False(t, Allow(ctx, 1, "gpt-4o"))
+
+	// a successful probe closes it
+	advance(time.Minute)
+	assert.True(t, Allow(ctx, 1, "gpt-4o"))
+	RecordSuccess(ctx, 1, "gpt-4o")
+	assert.Equal(t, StateClosed, getState(t, 1, "gpt-4o"))
+	assert.True(t, Allow(ctx, 1, "gpt-4o"))
+	assert.True(t, Allow(ctx, 1, "gpt-4o"))
+}
+
+func TestProbeTimeout(t *testing.T) {
+	advance := setupTest(t)
+	ctx := context.Background()
+
+	Trip(ctx, 1, "gpt-4o", 10*time.Second)
+	assert.False(t, Allow(ctx, 1, "gpt-4o"))
+	advance(10 * time.Second)
+	assert.True(t, Allow(ctx, 1, "gpt-4o"))
+
+	// the probe never reported back, another one is let through after the open duration
+	advance(30 * time.Second)
+	assert.False(t, Allow(ctx, 1, "gpt-4o"))
+	advance(30 * time.Second)
+	assert.True(t, Allow(ctx, 1, "gpt-4o"))
+}
+
+func TestFailureWindow(t *testing.T) {
+	advance := setupTest(t)
+	ctx := context.Background()
+
+	RecordFailure(ctx, 1, "gpt-4o")
+	RecordFailure(ctx, 1, "gpt-4o")
+	advance(failureWindow + time.Second)
+	RecordFailure(ctx, 1, "gpt-4o")
+	assert.True(t, Allow(ctx, 1, "gpt-4o"))
+
+	circuits, err := List(ctx)
+	require.NoError(t, err)
+	require.Len(t, circuits, 1)
+	assert.Equal(t, 1, circuits[0].Failures)
+}
+
+func TestListAndReset(t *testing.T) {
+	setupTest(t)
+	ctx := context.Background()
+
+	Trip(ctx, 2, "gpt-4o", time.Minute)
+	RecordFailure(ctx, 1, "gpt-4o-mini")
+	Trip(ctx, 1, "gpt-4o", time.Minute)
+
+	circuits, err := List(ctx)
+	require.NoError(t, err)
+	require.Len(t, circuits, 3)
+	assert.Equal(t, &Circuit{ChannelId: 1, Model: "gpt-4o", State: StateOpen, OpenUntil: now().Add(time.Minute).Unix()}, circuits[0])
+	assert.Equal(t, &Circuit{ChannelId: 1, Model: "gpt-4o-mini", State: StateClosed, Failures: 1}, circuits[1])
+	assert.Equal(t, 2, circuits[2].ChannelId)
+
+	require.NoError(t, Reset(ctx, 2, "gpt-4o"))
+	assert.True(t, Allow(ctx, 2, "gpt-4o"))
+	circuits, err = List(ctx)
+	require.NoError(t, err)
+	assert.Len(t, circuits, 2)
+}
+
+func TestDisabled(t *testing.T) {
+	setupTest(t)
+	config.CircuitBreakerEnabled = false
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		RecordFailure(ctx, 1, "gpt-4o")
+	}
+	Trip(ctx, 2, "gpt-4o", time.Minute)
+	assert.True(t, Allow(ctx, 1, "gpt-4o"))
+	assert.True(t, Allow(ctx, 2, "gpt-4o"))
+}
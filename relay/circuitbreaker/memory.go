@@ -0,0 +1,137 @@
+package circuitbreaker
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type circuitKey struct {
+	channelId int
+	model     string
+}
+
+type memoryCircuit struct {
+	failures      int
+	lastFailureAt time.Time
+	openUntil     time.Time
+	probeUntil    time.Time
+}
+
+// memoryStore keeps circuits in process, it is used when Redis is disabled
+type memoryStore struct {
+	sync.Mutex
+	circuits map[circuitKey]*memoryCircuit
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{circuits: make(map[circuitKey]*memoryCircuit)}
+}
+
+// get returns the circuit of key, forgetting the failures of a closed circuit after failureWindow
+func (s *memoryStore) get(key circuitKey, now time.Time) *memoryCircuit {
+	circuit, ok := s.circuits[key]
+	if !ok {
+		return nil
+	}
+	if circuit.openUntil.IsZero() && now.Sub(circuit.lastFailureAt) > failureWindow {
+		delete(s.circuits, key)
+		return nil
+	}
+	return circuit
+}
+
+func (s *memoryStore) Allow(_ context.Context, channelId int, model string, now time.Time, probeTimeout time.Duration) (bool, bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	circuit := s.get(circuitKey{channelId: channelId, model: model}, now)
+	if circuit == nil {
+		return true, false, nil
+	}
+
+	switch stateOf(circuit.openUntil, now) {
+	case StateClosed:
+		return true, false, nil
+	case StateOpen:
+		return false, false, nil
+	default:
+		// only one probe at a time, a probe which never reports back is given up after probeTimeout
+		if now.Before(circuit.probeUntil) {
+			return false, false, nil
+		}
+		circuit.probeUntil = now.Add(probeTimeout)
+		return true, true, nil
+	}
+}
+
+func (s *memoryStore) RecordSuccess(_ context.Context, channelId int, model string) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	key := circuitKey{channelId: channelId, model: model}
+	_, ok := s.circuits[key]
+	delete(s.circuits, key)
+	return ok, nil
+}
+
+func (s *memoryStore) RecordFailure(_ context.Context, channelId int, model string, now time.Time, threshold int, openDuration time.Duration) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	key := circuitKey{channelId: channelId, model: model}
+	circuit := s.get(key, now)
+	if circuit == nil {
+		circuit = &memoryCircuit{}
+		s.circuits[key] = circuit
+	}
+
+	circuit.failures++
+	circuit.lastFailureAt = now
+	circuit.probeUntil = time.Time{}
+	if circuit.failures < threshold {
+		return false, nil
+	}
+	circuit.openUntil = now.Add(openDuration)
+	return true, nil
+}
+
+func (s *memoryStore) Trip(_ context.Context, channelId int, model string, now time.Time, until time.Time) error {
+	s.Lock()
+	defer s.Unlock()
+	key := circuitKey{channelId: channelId, model: model}
+	circuit := s.get(key, now)
+	if circuit == nil {
+		circuit = &memoryCircuit{}
+		s.circuits[key] = circuit
+	}
+
+	circuit.lastFailureAt = now
+	circuit.openUntil = until
+	circuit.probeUntil = time.Time{}
+	return nil
+}
+
+func (s *memoryStore) List(_ context.Context, now time.Time) ([]*Circuit, error) {
+	s.Lock()
+	defer s.Unlock()
+	var circuits []*Circuit
+	for key := range s.circuits {
+		circuit := s.get(key, now)
+		if circuit == nil {
+			continue
+		}
+		circuits = append(circuits, newCircuit(key.channelId, key.model, circuit.failures, circuit.openUntil, now))
+	}
+	return circuits, nil
+}
+
+func newCircuit(channelId int, model string, failures int, openUntil time.Time, now time.Time) *Circuit {
+	circuit := &Circuit{
+		ChannelId: channelId,
+		Model:     model,
+		State:     stateOf(openUntil, now),
+		Failures:  failures,
+	}
+	if !openUntil.IsZero() {
+		circuit.OpenUntil = openUntil.Unix()
+	}
+	return circuit
+}
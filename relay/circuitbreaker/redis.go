@@ -0,0 +1,133 @@
+package circuitbreaker
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+const redisKeyPrefix = "circuit_breaker:"
+
+// allowScript returns 0 if the request is rejected, 1 if the circuit is closed,
+// and 2 if the request is the probe of a half-open circuit
+var allowScript = redis.NewScript(`
+local openUntil = tonumber(redis.call('HGET', KEYS[1], 'open_until') or '0')
+if openUntil == 0 then
+	return 1
+end
+local now = tonumber(ARGV[1])
+if now < openUntil then
+	return 0
+end
+local probeUntil = tonumber(redis.call('HGET', KEYS[1], 'probe_until') or '0')
+if now < probeUntil then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'probe_until', ARGV[2])
+return 2
+`)
+
+// failureScript returns 1 if the failure opens the circuit
+var failureScript = redis.NewScript(`
+local failures = redis.call('HINCRBY', KEYS[1], 'failures', 1)
+redis.call('HDEL', KEYS[1], 'probe_until')
+local opened = 0
+if failures >= tonumber(ARGV[1]) then
+	redis.call('HSET', KEYS[1], 'open_until', ARGV[2])
+	opened = 1
+end
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+return opened
+`)
+
+// redisStore keeps circuits in Redis hashes, which expire after failureWindow without failures
+type redisStore struct {
+	rdb redis.Cmdable
+}
+
+func newRedisStore(rdb redis.Cmdable) *redisStore {
+	return &redisStore{rdb: rdb}
+}
+
+func redisKey(channelId int, model string) string {
+	return fmt.Sprintf("%s%d:%s", redisKeyPrefix, channelId, model)
+}
+
+func (s *redisStore) Allow(ctx context.Context, channelId int, model string, now time.Time, probeTimeout time.Duration) (bool, bool, error) {
+	result, err := allowScript.Run(ctx, s.rdb, []string{redisKey(channelId, model)},
+		now.UnixMilli(), now.Add(probeTimeout).UnixMilli()).Int()
+	if err != nil {
+		return false, false, errors.Wrap(err, "failed to check circuit")
+	}
+	return result != 0, result == 2, nil
+}
+
+func (s *redisStore) RecordSuccess(ctx context.Context, channelId int, model string) (bool, error) {
+	deleted, err := s.rdb.Del(ctx, redisKey(channelId, model)).Result()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to close circuit")
+	}
+	return deleted > 0, nil
+}
+
+func (s *redisStore) RecordFailure(ctx context.Context, channelId int, model string, now time.Time, threshold int, openDuration time.Duration) (bool, error) {
+	result, err := failureScript.Run(ctx, s.rdb, []string{redisKey(channelId, model)},
+		threshold, now.Add(openDuration).UnixMilli(), (openDuration + failureWindow).Milliseconds()).Int()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to record failure on circuit")
+	}
+	return result == 1, nil
+}
+
+func (s *redisStore) Trip(ctx context.Context, channelId int, model string, now time.Time, until time.Time) error {
+	key := redisKey(channelId, model)
+	pipe := s.rdb.TxPipeline()
+	pipe.HSet(ctx, key, "open_until", until.UnixMilli())
+	pipe.HDel(ctx, key, "probe_until")
+	pipe.PExpire(ctx, key, until.Sub(now)+failureWindow)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return errors.Wrap(err, "failed to open circuit")
+	}
+	return nil
+}
+
+func (s *redisStore) List(ctx context.Context, now time.Time) ([]*Circuit, error) {
+	var circuits []*Circuit
+	iter := s.rdb.Scan(ctx, 0, redisKeyPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		channelIdStr, model, ok := strings.Cut(strings.TrimPrefix(key, redisKeyPrefix), ":")
+		if !ok {
+			continue
+		}
+		channelId, err := strconv.Atoi(channelIdStr)
+		if err != nil {
+			continue
+		}
+
+		fields, err := s.rdb.HGetAll(ctx, key).Result()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get circuit")
+		}
+		if len(fields) == 0 {
+			// expired after being scanned
+			continue
+		}
+		failures, _ := strconv.Atoi(fields["failures"])
+		var openUntil time.Time
+		if openUntilMs, _ := strconv.ParseInt(fields["open_until"], 10, 64); openUntilMs > 0 {
+			openUntil = time.UnixMilli(openUntilMs)
+		}
+		circuits = append(circuits, newCircuit(channelId, model, failures, openUntil, now))
+	}
+	if err := iter.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to scan circuits")
+	}
+
+	return circuits, nil
+}
@@ -0,0 +1,195 @@
+// Package concurrency limits the requests in flight on each channel.
+//
+// A request takes a slot of its channel before being sent, and gives it back when it is done.
+// Channels at their limit are skipped during channel selection, and a request sent to such a channel anyway
+// waits in a bounded queue until a slot is free, or fails after config.ChannelQueueTimeoutSeconds.
+// Slots are shared by all nodes through Redis if enabled, the wait queue is bounded on each node.
+package concurrency
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/random"
+)
+
+// ErrorCode is the code of the error returned to requests which could not get a slot
+const ErrorCode = "channel_busy"
+
+var (
+	// ErrBusy is returned when no slot of the channel is freed within the queue timeout
+	ErrBusy = errors.New("channel is at its concurrency limit")
+	// ErrQueueFull is returned when too many requests are waiting for a slot of the channel already
+	ErrQueueFull = errors.New("the queue of the channel is full")
+)
+
+const (
+	// slotTTL is how long a slot is kept without being refreshed, so that the slots of a crashed node are freed
+	slotTTL = time.Minute
+	// pollInterval is how often a queued request checks whether a slot is free
+	pollInterval = 50 * time.Millisecond
+)
+
+// Store keeps the slots in use of each channel
+type Store interface {
+	// TryAcquire takes the slot slotId of the channel if fewer than limit slots are in use,
+	// the slot expires at expireAt unless refreshed
+	TryAcquire(ctx context.Context, channelId int, limit int, slotId string, now time.Time, expireAt time.Time) (bool, error)
+	// Refresh extends the slot until expireAt
+	Refresh(ctx context.Context, channelId int, slotId string, expireAt time.Time) error
+	// Release frees the slot
+	Release(ctx context.Context, channelId int, slotId string) error
+	// InUse returns the number of slots of the channel in use
+	InUse(ctx context.Context, channelId int, now time.Time) (int, error)
+}
+
+var (
+	defaultStore     Store
+	defaultStoreOnce sync.Once
+	// now is replaced by tests
+	now = time.Now
+)
+
+// getStore returns the shared store, which is Redis if enabled so that all nodes see the same slots
+func getStore() Store {
+	defaultStoreOnce.Do(func() {
+		if defaultStore != nil {
+			return
+		}
+		if common.RedisEnabled {
+			defaultStore = newRedisStore(common.RDB)
+			return
+		}
+		defaultStore = newMemoryStore()
+	})
+	return defaultStore
+}
+
+var (
+	queueLock sync.Mutex
+	// queued is the number of requests of this node waiting for a slot of each channel
+	queued = make(map[int]int)
+)
+
+// Slot is a slot of a channel held by a request
+type Slot struct {
+	channelId int
+	id        string
+	stop      chan struct{}
+	once      sync.Once
+}
+
+// Release gives the slot back, it could be called more than once
+func (s *Slot) Release() {
+	if s == nil {
+		return
+	}
+	s.once.Do(func() {
+		close(s.stop)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := getStore().Release(ctx, s.channelId, s.id); err != nil {
+			logger.Errorf(ctx, "failed to release slot of channel %d: %+v", s.channelId, err)
+		}
+	})
+}
+
+// keepAlive refreshes the slot until it is released
+func (s *Slot) keepAlive() {
+	ticker := time.NewTicker(slotTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			if err := getStore().Refresh(ctx, s.channelId, s.id, now().Add(slotTTL)); err != nil {
+				logger.Errorf(ctx, "failed to refresh slot of channel %d: %+v", s.channelId, err)
+			}
+			cancel()
+		}
+	}
+}
+
+// Available reports whether the channel limited to limit requests in flight has a free slot.
+// Errors of the store are logged and the channel is deemed available, so that an outage of Redis does not stop relaying.
+func Available(ctx context.Context, channelId int, limit int) bool {
+	if limit <= 0 {
+		return true
+	}
+
+	inUse, err := getStore().InUse(ctx, channelId, now())
+	if err != nil {
+		logger.Errorf(ctx, "failed to count slots of channel %d: %+v", channelId, err)
+		return true
+	}
+	return inUse < limit
+}
+
+// Acquire takes a slot of the channel limited to limit requests in flight, waiting in the queue of the channel
+// while it is at its limit. It returns a nil slot if the channel is not limited.
+func Acquire(ctx context.Context, channelId int, limit int) (*Slot, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+
+	slot := &Slot{channelId: channelId, id: random.GetUUID(), stop: make(chan struct{})}
+	if tryAcquire(ctx, slot, limit) {
+		return slot, nil
+	}
+
+	queueLock.Lock()
+	if queued[channelId] >= config.ChannelQueueSize {
+		queueLock.Unlock()
+		return nil, ErrQueueFull
+	}
+	queued[channelId]++
+	queueLock.Unlock()
+	defer func() {
+		queueLock.Lock()
+		if queued[channelId]--; queued[channelId] <= 0 {
+			delete(queued, channelId)
+		}
+		queueLock.Unlock()
+	}()
+
+	logger.Debugf(ctx, "channel %d is at its concurrency limit %d, waiting for a slot", channelId, limit)
+	timeout := time.NewTimer(time.Duration(config.ChannelQueueTimeoutSeconds) * time.Second)
+	defer timeout.Stop()
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, errors.Wrap(ctx.Err(), "waiting for a slot of the channel")
+		case <-timeout.C:
+			return nil, ErrBusy
+		case <-ticker.C:
+			if tryAcquire(ctx, slot, limit) {
+				return slot, nil
+			}
+		}
+	}
+}
+
+// tryAcquire takes the slot if one is free. Errors of the store are logged and the slot is deemed taken,
+// so that an outage of Redis does not stop relaying.
+func tryAcquire(ctx context.Context, slot *Slot, limit int) bool {
+	current := now()
+	acquired, err := getStore().TryAcquire(ctx, slot.channelId, limit, slot.id, current, current.Add(slotTTL))
+	if err != nil {
+		logger.Errorf(ctx, "failed to acquire slot of channel %d: %+v", slot.channelId, err)
+		acquired = true
+	}
+	if acquired {
+		go slot.keepAlive()
+	}
+	return acquired
+}
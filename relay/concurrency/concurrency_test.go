@@ -0,0 +1,105 @@
+package concurrency
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/songquanpeng/one-api/common/config"
+)
+
+// setupTest uses a memory store and a short queue timeout
+func setupTest(t *testing.T) {
+	originalQueueSize, originalQueueTimeout := config.ChannelQueueSize, config.ChannelQueueTimeoutSeconds
+	originalStore, originalNow := defaultStore, now
+	t.Cleanup(func() {
+		config.ChannelQueueSize, config.ChannelQueueTimeoutSeconds = originalQueueSize, originalQueueTimeout
+		defaultStore, now = originalStore, originalNow
+	})
+
+	config.ChannelQueueSize = 1
+	config.ChannelQueueTimeoutSeconds = 1
+	defaultStore = newMemoryStore()
+}
+
+func TestAcquire(t *testing.T) {
+	setupTest(t)
+	ctx := context.Background()
+
+	// channels without a limit have no slots
+	slot, err := Acquire(ctx, 1, 0)
+	require.NoError(t, err)
+	assert.Nil(t, slot)
+	slot.Release()
+	assert.True(t, Available(ctx, 1, 0))
+
+	first, err := Acquire(ctx, 1, 2)
+	require.NoError(t, err)
+	assert.True(t, Available(ctx, 1, 2))
+	second, err := Acquire(ctx, 1, 2)
+	require.NoError(t, err)
+	assert.False(t, Available(ctx, 1, 2))
+	assert.True(t, Available(ctx, 2, 2))
+
+	// a queued request gets the slot released
+	var wg sync.WaitGroup
+	wg.Add(1)
+	var queuedSlot *Slot
+	var queuedErr error
+	go func() {
+		defer wg.Done()
+		queuedSlot, queuedErr = Acquire(ctx, 1, 2)
+	}()
+	assert.Eventually(t, func() bool {
+		queueLock.Lock()
+		defer queueLock.Unlock()
+		return queued[1] == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// the queue is bounded
+	_, err = Acquire(ctx, 1, 2)
+	assert.ErrorIs(t, err, ErrQueueFull)
+
+	first.Release()
+	first.Release()
+	wg.Wait()
+	require.NoError(t, queuedErr)
+	require.NotNil(t, queuedSlot)
+	assert.False(t, Available(ctx, 1, 2))
+
+	// a queued request gives up after the timeout
+	start := time.Now()
+	_, err = Acquire(ctx, 1, 2)
+	assert.ErrorIs(t, err, ErrBusy)
+	assert.GreaterOrEqual(t, time.Since(start), time.Second)
+
+	// or when the request is cancelled
+	cancelledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = Acquire(cancelledCtx, 1, 2)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	second.Release()
+	queuedSlot.Release()
+	assert.True(t, Available(ctx, 1, 1))
+}
+
+func TestSlotExpiry(t *testing.T) {
+	setupTest(t)
+	ctx := context.Background()
+	current := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	now = func() time.Time { return current }
+
+	slot, err := Acquire(ctx, 1, 1)
+	require.NoError(t, err)
+	defer slot.Release()
+	assert.False(t, Available(ctx, 1, 1))
+
+	// the slot of a node which stopped refreshing it is freed
+	current = current.Add(slotTTL)
+	assert.True(t, Available(ctx, 1, 1))
+}
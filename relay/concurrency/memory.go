@@ -0,0 +1,69 @@
+package concurrency
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// memoryStore keeps slots in process, it is used when Redis is disabled
+type memoryStore struct {
+	sync.Mutex
+	// slots is the expiry of each slot in use of each channel
+	slots map[int]map[string]time.Time
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{slots: make(map[int]map[string]time.Time)}
+}
+
+// inUse returns the slots in use of the channel, dropping the expired ones
+func (s *memoryStore) inUse(channelId int, now time.Time) map[string]time.Time {
+	slots := s.slots[channelId]
+	for slotId, expireAt := range slots {
+		if !now.Before(expireAt) {
+			delete(slots, slotId)
+		}
+	}
+	return slots
+}
+
+func (s *memoryStore) TryAcquire(_ context.Context, channelId int, limit int, slotId string, now time.Time, expireAt time.Time) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	slots := s.inUse(channelId, now)
+	if len(slots) >= limit {
+		return false, nil
+	}
+	if slots == nil {
+		slots = make(map[string]time.Time)
+		s.slots[channelId] = slots
+	}
+	slots[slotId] = expireAt
+	return true, nil
+}
+
+func (s *memoryStore) Refresh(_ context.Context, channelId int, slotId string, expireAt time.Time) error {
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.slots[channelId][slotId]; ok {
+		s.slots[channelId][slotId] = expireAt
+	}
+	return nil
+}
+
+func (s *memoryStore) Release(_ context.Context, channelId int, slotId string) error {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.slots[channelId], slotId)
+	if len(s.slots[channelId]) == 0 {
+		delete(s.slots, channelId)
+	}
+	return nil
+}
+
+func (s *memoryStore) InUse(_ context.Context, channelId int, now time.Time) (int, error) {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.inUse(channelId, now)), nil
+}
@@ -0,0 +1,78 @@
+package concurrency
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+const redisKeyPrefix = "channel_concurrency:"
+
+// acquireScript returns 1 if the slot is taken. Slots are members of a sorted set scored by their expiry.
+var acquireScript = redis.NewScript(`
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+if redis.call('ZCARD', KEYS[1]) >= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('ZADD', KEYS[1], ARGV[3], ARGV[4])
+redis.call('PEXPIRE', KEYS[1], ARGV[5])
+return 1
+`)
+
+// refreshScript extends a slot which has not been released or expired
+var refreshScript = redis.NewScript(`
+if redis.call('ZSCORE', KEYS[1], ARGV[2]) then
+	redis.call('ZADD', KEYS[1], ARGV[1], ARGV[2])
+	redis.call('PEXPIRE', KEYS[1], ARGV[3])
+end
+return 1
+`)
+
+// redisStore keeps the slots of each channel in a Redis sorted set, so that all nodes share the limit
+type redisStore struct {
+	rdb redis.Cmdable
+}
+
+func newRedisStore(rdb redis.Cmdable) *redisStore {
+	return &redisStore{rdb: rdb}
+}
+
+func redisKey(channelId int) string {
+	return fmt.Sprintf("%s%d", redisKeyPrefix, channelId)
+}
+
+func (s *redisStore) TryAcquire(ctx context.Context, channelId int, limit int, slotId string, now time.Time, expireAt time.Time) (bool, error) {
+	result, err := acquireScript.Run(ctx, s.rdb, []string{redisKey(channelId)},
+		now.UnixMilli(), limit, expireAt.UnixMilli(), slotId, expireAt.Sub(now).Milliseconds()).Int()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to acquire slot")
+	}
+	return result == 1, nil
+}
+
+func (s *redisStore) Refresh(ctx context.Context, channelId int, slotId string, expireAt time.Time) error {
+	if err := refreshScript.Run(ctx, s.rdb, []string{redisKey(channelId)},
+		expireAt.UnixMilli(), slotId, slotTTL.Milliseconds()).Err(); err != nil {
+		return errors.Wrap(err, "failed to refresh slot")
+	}
+	return nil
+}
+
+func (s *redisStore) Release(ctx context.Context, channelId int, slotId string) error {
+	if err := s.rdb.ZRem(ctx, redisKey(channelId), slotId).Err(); err != nil {
+		return errors.Wrap(err, "failed to release slot")
+	}
+	return nil
+}
+
+func (s *redisStore) InUse(ctx context.Context, channelId int, now time.Time) (int, error) {
+	count, err := s.rdb.ZCount(ctx, redisKey(channelId), "("+strconv.FormatInt(now.UnixMilli(), 10), "+inf").Result()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to count slots")
+	}
+	return int(count), nil
+}
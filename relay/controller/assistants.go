@@ -0,0 +1,309 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay"
+	"github.com/songquanpeng/one-api/relay/adaptor"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/billing"
+	"github.com/songquanpeng/one-api/relay/channeltype"
+	metalib "github.com/songquanpeng/one-api/relay/meta"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/pricing"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+// https://platform.openai.com/docs/api-reference/assistants
+
+// assistantsObject holds the fields of Assistants API objects that one-api cares about
+type assistantsObject struct {
+	Id       string              `json:"id"`
+	Object   string              `json:"object"`
+	Deleted  bool                `json:"deleted"`
+	ThreadId string              `json:"thread_id"`
+	Model    string              `json:"model"`
+	Usage    *relaymodel.Usage   `json:"usage"`
+	Data     []*assistantsObject `json:"data"`
+}
+
+// RelayAssistantsHelper proxies Assistants API requests to OpenAI or Azure channels.
+//
+// The distributor has selected the channel owning the referred assistant or thread,
+// this helper records the objects created by upstream for later calls,
+// and bills runs by the usage reported by upstream once they finish.
+func RelayAssistantsHelper(c *gin.Context) *relaymodel.ErrorWithStatusCode {
+	ctx := c.Request.Context()
+	meta := metalib.GetByContext(c)
+	if meta.ChannelType != channeltype.OpenAI && meta.ChannelType != channeltype.Azure {
+		return openai.ErrorWrapper(errors.Errorf("channel type %d does not support the Assistants API", meta.ChannelType),
+			"unsupported_channel_type", http.StatusBadRequest)
+	}
+	meta.Mode = relaymode.Assistants
+	meta.RequestURLPath = c.Request.URL.String()
+
+	requestBody, err := getAssistantsRequestBody(c, meta)
+	if err != nil {
+		return openai.ErrorWrapper(err, "invalid_assistants_request", http.StatusBadRequest)
+	}
+	if isAssistantsRunRequest(c) {
+		if bizErr := checkRemainingQuota(c, meta); bizErr != nil {
+			return bizErr
+		}
+	}
+
+	adaptor := relay.GetAdaptor(meta.APIType)
+	if adaptor == nil {
+		return openai.ErrorWrapper(errors.Errorf("invalid api type: %d", meta.APIType), "invalid_api_type", http.StatusBadRequest)
+	}
+	adaptor.Init(meta)
+
+	resp, err := adaptor.DoRequest(c, meta, bytes.NewReader(requestBody))
+	if err != nil {
+		logger.Errorf(ctx, "DoRequest failed: %s", err.Error())
+		return openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return RelayErrorHandler(resp)
+	}
+	defer resp.Body.Close()
+
+	tracker := newAssistantsTracker(c, meta)
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		return tracker.doStreamResponse(c, resp)
+	}
+	return tracker.doResponse(c, resp)
+}
+
+// getAssistantsRequestBody returns the body to send upstream, the model in body is mapped by the channel
+func getAssistantsRequestBody(c *gin.Context, meta *metalib.Meta) ([]byte, error) {
+	if c.Request.Method != http.MethodPost {
+		return nil, nil
+	}
+
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read request body")
+	}
+	var request struct {
+		Stream *bool `json:"stream"`
+	}
+	if len(requestBody) > 0 {
+		if err = json.Unmarshal(requestBody, &request); err != nil {
+			return nil, errors.Wrap(err, "failed to parse request body")
+		}
+	}
+	meta.IsStream = request.Stream != nil && *request.Stream
+
+	if meta.OriginModelName == "" || meta.ActualModelName == meta.OriginModelName {
+		return requestBody, nil
+	}
+	fields := map[string]json.RawMessage{}
+	if err = json.Unmarshal(requestBody, &fields); err != nil {
+		return nil, errors.Wrap(err, "failed to parse request body")
+	}
+	fields["model"], _ = json.Marshal(meta.ActualModelName)
+	return json.Marshal(fields)
+}
+
+// isAssistantsRunRequest returns whether the request starts or continues a run, which consumes tokens
+func isAssistantsRunRequest(c *gin.Context) bool {
+	path := c.Request.URL.Path
+	return c.Request.Method == http.MethodPost &&
+		(strings.HasSuffix(path, "/runs") || strings.HasSuffix(path, "/submit_tool_outputs"))
+}
+
+// assistantsTracker records and bills the objects returned by upstream
+type assistantsTracker struct {
+	ctx               context.Context
+	meta              *metalib.Meta
+	requestId         string
+	groupRatio        float64
+	channelModelRatio map[string]float64
+	channel           *model.Channel
+	pricingAdaptor    adaptor.Adaptor
+}
+
+func newAssistantsTracker(c *gin.Context, meta *metalib.Meta) *assistantsTracker {
+	channelModelRatio, _ := getChannelRatios(c, meta.ChannelId)
+	var channel *model.Channel
+	if channelModel, ok := c.Get(ctxkey.ChannelModel); ok {
+		channel, _ = channelModel.(*model.Channel)
+	}
+	return &assistantsTracker{
+		ctx:               c.Request.Context(),
+		meta:              meta,
+		requestId:         c.GetString(ctxkey.RequestId),
+		groupRatio:        c.GetFloat64(ctxkey.ChannelRatio),
+		channelModelRatio: channelModelRatio,
+		channel:           channel,
+		pricingAdaptor:    relay.GetAdaptor(meta.ChannelType),
+	}
+}
+
+// doResponse tracks the object in a JSON response, listed assistants of other users are hidden
+func (t *assistantsTracker) doResponse(c *gin.Context, resp *http.Response) *relaymodel.ErrorWithStatusCode {
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return openai.ErrorWrapper(err, "read_response_body_failed", http.StatusInternalServerError)
+	}
+
+	object := &assistantsObject{}
+	if err = json.Unmarshal(responseBody, object); err != nil {
+		return openai.ErrorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError)
+	}
+	if object.Object == "list" && c.Request.URL.Path == "/v1/assistants" {
+		// one upstream key is shared by users, who should only see their own assistants
+		responseBody, err = filterListByOwner(responseBody, func(ids []string) (map[string]bool, error) {
+			return model.GetUserAssistantObjectIds(t.meta.UserId, ids)
+		})
+		if err != nil {
+			return openai.ErrorWrapper(err, "filter_assistants_failed", http.StatusInternalServerError)
+		}
+	} else {
+		t.track(object)
+	}
+
+	c.Data(resp.StatusCode, resp.Header.Get("Content-Type"), responseBody)
+	return nil
+}
+
+// doStreamResponse forwards the events of a run as is, and tracks the objects in them
+func (t *assistantsTracker) doStreamResponse(c *gin.Context, resp *http.Response) *relaymodel.ErrorWithStatusCode {
+	scanner := bufio.NewScanner(resp.Body)
+	buffer := make([]byte, 1024*1024) // 1MB buffer for large messages
+	scanner.Buffer(buffer, len(buffer))
+	scanner.Split(bufio.ScanLines)
+
+	common.SetEventStreamHeaders(c)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if data, ok := strings.CutPrefix(line, "data:"); ok {
+			object := &assistantsObject{}
+			if err := json.Unmarshal([]byte(strings.TrimSpace(data)), object); err == nil {
+				// objects are recorded before the client could see them
+				t.track(object)
+			}
+		}
+
+		if _, err := c.Writer.WriteString(line + "\n"); err != nil {
+			return openai.ErrorWrapper(err, "write_response_failed", http.StatusInternalServerError)
+		}
+		if line == "" {
+			c.Writer.Flush()
+		}
+	}
+	c.Writer.Flush()
+
+	if err := scanner.Err(); err != nil {
+		logger.Errorf(t.ctx, "error reading assistants stream: %+v", err)
+	}
+	return nil
+}
+
+// track records assistants, threads and runs created upstream, and bills finished runs
+func (t *assistantsTracker) track(object *assistantsObject) {
+	switch object.Object {
+	case "list":
+		for _, item := range object.Data {
+			t.track(item)
+		}
+	case model.AssistantObjectAssistant, model.AssistantObjectThread:
+		t.record(object.Id, object.Object)
+	case "assistant.deleted", "thread.deleted":
+		if object.Deleted {
+			if err := model.DeleteAssistantObject(object.Id); err != nil {
+				logger.Errorf(t.ctx, "delete assistant object record failed: %+v", err)
+			}
+		}
+	case model.AssistantObjectRun:
+		t.record(object.ThreadId, model.AssistantObjectThread)
+		t.record(object.Id, model.AssistantObjectRun)
+		// usage is only reported once the run is in a terminal state
+		if object.Usage != nil {
+			t.billRun(object)
+		}
+	}
+}
+
+func (t *assistantsTracker) record(objectId, object string) {
+	if objectId == "" {
+		return
+	}
+
+	record := model.NewAssistantObject(objectId, object, t.meta.UserId, t.meta.TokenId, t.meta.ChannelId)
+	if err := record.Insert(); err != nil {
+		logger.Errorf(t.ctx, "insert assistant object record failed: %+v", err)
+	}
+}
+
+// billRun charges the token that created the run, each run is billed only once
+// no matter how many times its final state is retrieved.
+func (t *assistantsTracker) billRun(run *assistantsObject) {
+	billed, err := model.MarkAssistantRunBilled(run.Id)
+	if err != nil {
+		logger.Errorf(t.ctx, "mark run billed failed: %+v", err)
+		return
+	}
+	if !billed {
+		return
+	}
+	record, err := model.GetAssistantObject(run.Id)
+	if err != nil {
+		logger.Errorf(t.ctx, "get run record failed: %+v", err)
+		return
+	}
+
+	modelName := run.Model
+	if modelName == "" {
+		modelName = t.meta.ActualModelName
+	}
+	modelRatio := pricing.GetModelRatioWithThreeLayers(modelName, t.channelModelRatio, t.pricingAdaptor)
+	modelRatio, tokenRatios := getChannelTokenRatios(t.channel, t.pricingAdaptor, modelName).
+		ForPromptTokens(modelRatio, run.Usage.PromptTokens)
+	ratio := modelRatio * t.groupRatio
+	quota := int64(math.Ceil(tokenRatios.WeightedTokens(run.Usage) * ratio))
+	if ratio != 0 && quota <= 0 {
+		quota = 1
+	}
+	if run.Usage.PromptTokens+run.Usage.CompletionTokens == 0 {
+		quota = 0
+	}
+
+	tokenName := t.meta.TokenName
+	if record.TokenId != t.meta.TokenId {
+		if token, err := model.GetTokenById(record.TokenId); err == nil {
+			tokenName = token.Name
+		}
+	}
+	meta, requestId := t.meta, t.requestId
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		billing.PostConsumeQuotaDetailed(ctx, record.TokenId, quota, quota, record.UserId, meta.ChannelId, meta.ChannelKey,
+			run.Usage.PromptTokens, run.Usage.CompletionTokens, modelRatio, t.groupRatio, modelName, tokenName,
+			meta.IsStream, meta.StartTime, false, tokenRatios.Completion, 0, cachedTokens(run.Usage), cacheWriteTokens(run.Usage))
+		if quota != 0 {
+			docu := model.NewUserRequestCost(record.UserId, requestId, quota)
+			if err := docu.Insert(); err != nil {
+				logger.Errorf(ctx, "insert user request cost failed: %+v", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,90 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/songquanpeng/one-api/model"
+	metalib "github.com/songquanpeng/one-api/relay/meta"
+)
+
+func setupAssistantsTest(t *testing.T) {
+	testDB, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, testDB.AutoMigrate(&model.AssistantObject{}))
+	originalDB := model.DB
+	model.DB = testDB
+	t.Cleanup(func() { model.DB = originalDB })
+}
+
+func TestAssistantsTracker(t *testing.T) {
+	setupAssistantsTest(t)
+	tracker := &assistantsTracker{
+		ctx:  context.Background(),
+		meta: &metalib.Meta{UserId: 1, TokenId: 2, ChannelId: 3},
+	}
+	track := func(raw string) {
+		object := &assistantsObject{}
+		require.NoError(t, json.Unmarshal([]byte(raw), object))
+		tracker.track(object)
+	}
+
+	track(`{"id":"asst_1","object":"assistant","model":"gpt-4o"}`)
+	// creating a thread and run at once returns the run only
+	track(`{"id":"run_1","object":"thread.run","thread_id":"thread_1","status":"queued","usage":null}`)
+	track(`{"object":"list","data":[{"id":"run_2","object":"thread.run","thread_id":"thread_1","status":"queued"}]}`)
+
+	for objectId, object := range map[string]string{
+		"asst_1":   model.AssistantObjectAssistant,
+		"thread_1": model.AssistantObjectThread,
+		"run_1":    model.AssistantObjectRun,
+		"run_2":    model.AssistantObjectRun,
+	} {
+		record, err := model.GetAssistantObject(objectId)
+		require.NoError(t, err, objectId)
+		assert.Equal(t, object, record.Object)
+		assert.Equal(t, 1, record.UserId)
+		assert.Equal(t, 3, record.ChannelId)
+		assert.False(t, record.Billed)
+	}
+
+	track(`{"id":"thread_1","object":"thread.deleted","deleted":true}`)
+	_, err := model.GetAssistantObject("thread_1")
+	require.Error(t, err)
+	track(`{"id":"asst_1","object":"assistant.deleted","deleted":false}`)
+	_, err = model.GetAssistantObject("asst_1")
+	require.NoError(t, err)
+}
+
+func TestFilterListByOwner(t *testing.T) {
+	setupAssistantsTest(t)
+	require.NoError(t, model.NewAssistantObject("asst_1", model.AssistantObjectAssistant, 1, 1, 1).Insert())
+	require.NoError(t, model.NewAssistantObject("asst_3", model.AssistantObjectAssistant, 1, 1, 1).Insert())
+	require.NoError(t, model.NewAssistantObject("asst_2", model.AssistantObjectAssistant, 2, 2, 1).Insert())
+
+	owned := func(ids []string) (map[string]bool, error) {
+		return model.GetUserAssistantObjectIds(1, ids)
+	}
+	body := `{"object":"list","data":[{"id":"asst_1","object":"assistant"},{"id":"asst_2","object":"assistant"},{"id":"asst_3","object":"assistant"},{"id":"asst_4","object":"assistant"}],"first_id":"asst_1","last_id":"asst_4","has_more":false}`
+	filtered, err := filterListByOwner([]byte(body), owned)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"object":"list","data":[{"id":"asst_1","object":"assistant"},{"id":"asst_3","object":"assistant"}],"first_id":"asst_1","last_id":"asst_3","has_more":false}`, string(filtered))
+
+	// the upstream cursor is kept for the next page
+	body = `{"object":"list","data":[{"id":"asst_2","object":"assistant"}],"first_id":"asst_2","last_id":"asst_2","has_more":true}`
+	filtered, err = filterListByOwner([]byte(body), owned)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"object":"list","data":[],"first_id":null,"last_id":"asst_2","has_more":true}`, string(filtered))
+
+	// lists without cursors, like fine-tuning jobs, are kept so
+	body = `{"object":"list","data":[{"id":"asst_1"},{"id":"asst_2"}],"has_more":false}`
+	filtered, err = filterListByOwner([]byte(body), owned)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"object":"list","data":[{"id":"asst_1"}],"has_more":false}`, string(filtered))
+}
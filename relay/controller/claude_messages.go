@@ -0,0 +1,284 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay"
+	"github.com/songquanpeng/one-api/relay/adaptor"
+	"github.com/songquanpeng/one-api/relay/adaptor/anthropic"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/billing"
+	"github.com/songquanpeng/one-api/relay/channeltype"
+	"github.com/songquanpeng/one-api/relay/hedge"
+	metalib "github.com/songquanpeng/one-api/relay/meta"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/pricing"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+// RelayClaudeMessagesHelper handles native Anthropic Messages API requests (/v1/messages).
+//
+// Anthropic channels receive the request body as is,
+// other channels receive the request converted to GeneralOpenAIRequest,
+// and their OpenAI-format output is converted back to the Messages API format.
+func RelayClaudeMessagesHelper(c *gin.Context) *relaymodel.ErrorWithStatusCode {
+	ctx := c.Request.Context()
+	meta := metalib.GetByContext(c)
+
+	claudeRequest, err := getAndValidateClaudeMessagesRequest(c)
+	if err != nil {
+		logger.Errorf(ctx, "getAndValidateClaudeMessagesRequest failed: %s", err.Error())
+		return openai.ErrorWrapper(err, "invalid_messages_request", http.StatusBadRequest)
+	}
+	meta.IsStream = claudeRequest.Stream
+
+	// the converted request is also used for token estimation and billing of native requests
+	textRequest, err := anthropic.ConvertMessagesRequest(claudeRequest)
+	if err != nil {
+		return openai.ErrorWrapper(err, "convert_messages_request_failed", http.StatusBadRequest)
+	}
+
+	// map model name
+	meta.OriginModelName = claudeRequest.Model
+	textRequest.Model = meta.ActualModelName
+	systemPromptReset := setSystemPrompt(ctx, textRequest, meta.ForcedSystemPrompt)
+
+	// meta is reused across retries, the channel decides which protocol is spoken upstream
+	isNative := meta.ChannelType == channeltype.Anthropic && !systemPromptReset
+	if isNative {
+		meta.Mode = relaymode.ClaudeMessages
+		meta.RequestURLPath = c.Request.URL.String()
+	} else {
+		meta.Mode = relaymode.ChatCompletions
+		meta.RequestURLPath = "/v1/chat/completions"
+	}
+
+	// get channel-specific pricing if available
+	channelModelRatio, _ := getChannelRatios(c, meta.ChannelId)
+	tokenRatios := getTokenRatios(c, meta, textRequest.Model)
+
+	pricingAdaptor := relay.GetAdaptor(meta.ChannelType)
+	modelRatio := pricing.GetModelRatioWithThreeLayers(textRequest.Model, channelModelRatio, pricingAdaptor)
+	groupRatio := c.GetFloat64(ctxkey.ChannelRatio)
+	ratio := modelRatio * groupRatio
+
+	// pre-consume quota
+	promptTokens := getPromptTokens(ctx, textRequest, relaymode.ChatCompletions)
+	meta.PromptTokens = promptTokens
+	preConsumedQuota, bizErr := preConsumeQuota(c, textRequest, promptTokens, ratio, meta)
+	if bizErr != nil {
+		logger.Warnf(ctx, "preConsumeQuota failed: %+v", *bizErr)
+		return bizErr
+	}
+
+	// the pre-consumed quota is returned unless the request is billed, e.g. when it fails
+	// or when it loses to the other attempt of a hedged request, which is cancelled at any step
+	billed := false
+	defer func() {
+		if !billed {
+			billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta.TokenId)
+		}
+	}()
+
+	adaptor := relay.GetAdaptor(meta.APIType)
+	if adaptor == nil {
+		return openai.ErrorWrapper(errors.Errorf("invalid api type: %d", meta.APIType), "invalid_api_type", http.StatusBadRequest)
+	}
+	adaptor.Init(meta)
+
+	var requestBody io.Reader
+	if isNative {
+		requestBody, err = getNativeClaudeMessagesRequestBody(c, meta.ActualModelName)
+	} else {
+		requestBody, err = getConvertedRequestBody(c, meta, textRequest, adaptor)
+	}
+	if err != nil {
+		return openai.ErrorWrapper(err, "convert_request_failed", http.StatusInternalServerError)
+	}
+
+	// do request
+	resp, err := adaptor.DoRequest(c, meta, requestBody)
+	if err != nil {
+		logger.Errorf(ctx, "DoRequest failed: %s", err.Error())
+		return openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
+	}
+	if isErrorHappened(meta, resp) {
+		return RelayErrorHandler(resp)
+	}
+
+	// do response
+	var (
+		usage   *relaymodel.Usage
+		respErr *relaymodel.ErrorWithStatusCode
+	)
+	if isNative {
+		usage, respErr = adaptor.DoResponse(c, resp, meta)
+	} else {
+		usage, respErr = doConvertedClaudeMessagesResponse(c, resp, meta, adaptor)
+	}
+	if respErr != nil {
+		logger.Errorf(ctx, "respErr is not nil: %+v", respErr)
+		return respErr
+	}
+
+	// only the attempt of a hedged request which answered first is billed
+	if !hedge.Claim(c) {
+		return openai.ErrorWrapper(hedge.ErrLost, "hedge_lost", http.StatusServiceUnavailable)
+	}
+	billed = true
+	recordUsageTokens(c, usage)
+
+	// post-consume quota
+	quotaId := c.GetInt(ctxkey.Id)
+	requestId := c.GetString(ctxkey.RequestId)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		quota := postConsumeQuota(ctx, usage, meta, textRequest, ratio, preConsumedQuota, modelRatio, groupRatio, systemPromptReset, tokenRatios)
+		if quota != 0 {
+			docu := model.NewUserRequestCost(
+				quotaId,
+				requestId,
+				quota,
+			)
+			if err = docu.Insert(); err != nil {
+				logger.Errorf(ctx, "insert user request cost failed: %+v", err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// getAndValidateClaudeMessagesRequest gets and validates a native Messages API request
+func getAndValidateClaudeMessagesRequest(c *gin.Context) (*anthropic.MessagesRequest, error) {
+	claudeRequest := &anthropic.MessagesRequest{}
+	if err := common.UnmarshalBodyReusable(c, claudeRequest); err != nil {
+		return nil, errors.Wrap(err, "unmarshal messages request")
+	}
+
+	if claudeRequest.Model == "" {
+		return nil, errors.New("model is required")
+	}
+	if len(claudeRequest.Messages) == 0 {
+		return nil, errors.New("messages is required")
+	}
+	if claudeRequest.MaxTokens <= 0 {
+		return nil, errors.New("max_tokens must be greater than 0")
+	}
+
+	return claudeRequest, nil
+}
+
+// getNativeClaudeMessagesRequestBody returns the raw request body with only the model replaced,
+// so that fields unknown to one-api (cache_control, citations, ...) reach Anthropic untouched.
+func getNativeClaudeMessagesRequestBody(c *gin.Context, actualModel string) (io.Reader, error) {
+	rawBody, err := common.GetRequestBody(c)
+	if err != nil {
+		return nil, errors.Wrap(err, "get request body")
+	}
+
+	body := make(map[string]json.RawMessage)
+	if err = json.Unmarshal(rawBody, &body); err != nil {
+		return nil, errors.Wrap(err, "unmarshal request body")
+	}
+	if body["model"], err = json.Marshal(actualModel); err != nil {
+		return nil, errors.Wrap(err, "marshal model")
+	}
+
+	jsonData, err := json.Marshal(body)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal request body")
+	}
+
+	logger.Debugf(c.Request.Context(), "native messages request: \n%s", string(jsonData))
+	return bytes.NewReader(jsonData), nil
+}
+
+// getConvertedRequestBody converts the request by the channel adaptor for native API handlers,
+// the shortcut in getRequestBody is skipped since the raw body is not in OpenAI format.
+func getConvertedRequestBody(c *gin.Context, meta *metalib.Meta,
+	textRequest *relaymodel.GeneralOpenAIRequest, adaptor adaptor.Adaptor) (io.Reader, error) {
+	convertedRequest, err := adaptor.ConvertRequest(c, meta.Mode, textRequest)
+	if err != nil {
+		return nil, errors.Wrap(err, "convert request")
+	}
+	c.Set(ctxkey.ConvertedRequest, convertedRequest)
+
+	jsonData, err := json.Marshal(convertedRequest)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal converted request")
+	}
+
+	logger.Debugf(c.Request.Context(), "converted request: \n%s", string(jsonData))
+	return bytes.NewReader(jsonData), nil
+}
+
+// doConvertedClaudeMessagesResponse lets the adaptor render its OpenAI-format response,
+// and converts the output to the Messages API format on the fly.
+func doConvertedClaudeMessagesResponse(c *gin.Context, resp *http.Response,
+	meta *metalib.Meta, adaptor adaptor.Adaptor) (*relaymodel.Usage, *relaymodel.ErrorWithStatusCode) {
+	ctx := c.Request.Context()
+	client := c.Writer
+
+	if !meta.IsStream {
+		capture, restore := captureOpenAIOutput(c, false, nil)
+		usage, respErr := adaptor.DoResponse(c, resp, meta)
+		restore()
+		if respErr != nil {
+			return nil, respErr
+		}
+
+		textResponse := new(openai.TextResponse)
+		if err := json.Unmarshal(capture.Body(), textResponse); err != nil {
+			return nil, openai.ErrorWrapper(err, "unmarshal_converted_response_failed", http.StatusInternalServerError)
+		}
+		textResponse.Model = meta.OriginModelName
+		if usage != nil {
+			textResponse.Usage = *usage
+		}
+
+		c.JSON(capture.Status(), anthropic.ConvertOpenAIResponse(textResponse))
+		return usage, nil
+	}
+
+	converter := anthropic.NewOpenAIStreamConverter(meta.OriginModelName, meta.PromptTokens)
+	render := func(events []anthropic.MessagesStreamEvent) {
+		for _, event := range events {
+			if err := anthropic.RenderMessagesStreamEvent(client, event); err != nil {
+				logger.Errorf(ctx, "render messages stream event failed: %+v", err)
+			}
+		}
+	}
+
+	_, restore := captureOpenAIOutput(c, true, func(data string) {
+		chunk := new(openai.ChatCompletionsStreamResponse)
+		if err := json.Unmarshal([]byte(data), chunk); err != nil {
+			logger.Warnf(ctx, "unmarshal converted stream chunk %q failed: %+v", data, err)
+			return
+		}
+		render(converter.Convert(chunk))
+	})
+	usage, respErr := adaptor.DoResponse(c, resp, meta)
+	restore()
+	if respErr != nil {
+		return nil, respErr
+	}
+
+	render(converter.Finish(usage))
+
+	return usage, nil
+}
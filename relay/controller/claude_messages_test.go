@@ -0,0 +1,119 @@
+package controller
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/songquanpeng/one-api/relay/adaptor/anthropic"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/channeltype"
+	metalib "github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+func newClaudeMessagesTestContext(t *testing.T) (*gin.Context, *httptest.ResponseRecorder) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	return c, w
+}
+
+func TestDoConvertedClaudeMessagesResponse_Stream(t *testing.T) {
+	c, w := newClaudeMessagesTestContext(t)
+	meta := &metalib.Meta{
+		Mode:            relaymode.ChatCompletions,
+		ChannelType:     channeltype.DeepSeek,
+		IsStream:        true,
+		OriginModelName: "claude-sonnet-4-20250514",
+		ActualModelName: "deepseek-chat",
+		PromptTokens:    12,
+	}
+	upstream := strings.Join([]string{
+		`data: {"id":"chatcmpl-9","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"role":"assistant","content":"Hel"}}]}`,
+		``,
+		`data: {"id":"chatcmpl-9","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"content":"lo"},"finish_reason":"stop"}]}`,
+		``,
+		`data: {"id":"chatcmpl-9","object":"chat.completion.chunk","choices":[],"usage":{"prompt_tokens":11,"completion_tokens":2,"total_tokens":13}}`,
+		``,
+		`data: [DONE]`,
+		``,
+	}, "\n")
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+		Body:       io.NopCloser(strings.NewReader(upstream)),
+	}
+
+	usage, respErr := doConvertedClaudeMessagesResponse(c, resp, meta, &openai.Adaptor{ChannelType: meta.ChannelType})
+	require.Nil(t, respErr)
+	require.NotNil(t, usage)
+	assert.Equal(t, 11, usage.PromptTokens)
+	assert.Equal(t, 2, usage.CompletionTokens)
+
+	body := w.Body.String()
+	assert.NotContains(t, body, "chat.completion.chunk")
+	assert.NotContains(t, body, "[DONE]")
+
+	var events []string
+	var text string
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(line, "event: ") {
+			events = append(events, strings.TrimPrefix(line, "event: "))
+		}
+		if strings.HasPrefix(line, "data: ") {
+			payload := map[string]any{}
+			require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &payload))
+			if delta, ok := payload["delta"].(map[string]any); ok && delta["type"] == "text_delta" {
+				text += delta["text"].(string)
+			}
+		}
+	}
+	assert.Equal(t, []string{
+		"message_start", "content_block_start", "content_block_delta", "content_block_delta",
+		"content_block_stop", "message_delta", "message_stop",
+	}, events)
+	assert.Equal(t, "Hello", text)
+}
+
+func TestDoConvertedClaudeMessagesResponse_NonStream(t *testing.T) {
+	c, w := newClaudeMessagesTestContext(t)
+	meta := &metalib.Meta{
+		Mode:            relaymode.ChatCompletions,
+		ChannelType:     channeltype.DeepSeek,
+		OriginModelName: "claude-sonnet-4-20250514",
+		ActualModelName: "deepseek-chat",
+	}
+	upstream := `{"id":"chatcmpl-7","object":"chat.completion","model":"deepseek-chat","choices":[{"index":0,"message":{"role":"assistant","content":"pong"},"finish_reason":"length"}],"usage":{"prompt_tokens":3,"completion_tokens":1,"total_tokens":4}}`
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header: http.Header{
+			"Content-Type":   []string{"application/json"},
+			"Content-Length": []string{"999"},
+		},
+		Body: io.NopCloser(strings.NewReader(upstream)),
+	}
+
+	usage, respErr := doConvertedClaudeMessagesResponse(c, resp, meta, &openai.Adaptor{ChannelType: meta.ChannelType})
+	require.Nil(t, respErr)
+	assert.Equal(t, 4, usage.TotalTokens)
+	assert.Empty(t, w.Header().Get("Content-Length"))
+
+	claudeResponse := new(anthropic.MessagesResponse)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), claudeResponse))
+	assert.Equal(t, "msg_7", claudeResponse.Id)
+	assert.Equal(t, "claude-sonnet-4-20250514", claudeResponse.Model)
+	assert.Equal(t, "max_tokens", *claudeResponse.StopReason)
+	require.Len(t, claudeResponse.Content, 1)
+	assert.Equal(t, "pong", claudeResponse.Content[0].Text)
+	assert.Equal(t, 3, claudeResponse.Usage.InputTokens)
+}
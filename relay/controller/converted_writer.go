@@ -0,0 +1,112 @@
+package controller
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// openaiOutputCapture replaces c.Writer while an adaptor renders its OpenAI-format output,
+// so that handlers serving other API formats can translate the output for their clients.
+//
+// In stream mode, every SSE data payload is handed to onData as soon as its line is complete.
+// Otherwise the whole body is buffered and can be read by Body after the adaptor returns.
+type openaiOutputCapture struct {
+	gin.ResponseWriter
+	isStream bool
+	onData   func(data string)
+
+	buf    bytes.Buffer
+	status int
+}
+
+// captureOpenAIOutput installs the capture on c, the returned function restores the original writer
+func captureOpenAIOutput(c *gin.Context, isStream bool, onData func(data string)) (*openaiOutputCapture, func()) {
+	original := c.Writer
+	capture := &openaiOutputCapture{
+		ResponseWriter: original,
+		isStream:       isStream,
+		onData:         onData,
+		status:         http.StatusOK,
+	}
+	c.Writer = capture
+
+	return capture, func() {
+		capture.flushPendingLine()
+		c.Writer = original
+		// upstream headers describe the body before conversion
+		original.Header().Del("Content-Length")
+		original.Header().Del("Content-Encoding")
+	}
+}
+
+func (w *openaiOutputCapture) WriteHeader(code int) {
+	if code > 0 {
+		w.status = code
+	}
+}
+
+func (w *openaiOutputCapture) WriteHeaderNow() {}
+
+func (w *openaiOutputCapture) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *openaiOutputCapture) Write(data []byte) (int, error) {
+	w.buf.Write(data)
+	if !w.isStream {
+		return len(data), nil
+	}
+
+	for {
+		idx := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if idx < 0 {
+			break
+		}
+		line := string(w.buf.Next(idx + 1))
+		w.handleLine(line)
+	}
+
+	return len(data), nil
+}
+
+// Flush only reaches the client in stream mode, when onData has written converted events
+func (w *openaiOutputCapture) Flush() {
+	if w.isStream {
+		w.ResponseWriter.Flush()
+	}
+}
+
+func (w *openaiOutputCapture) flushPendingLine() {
+	if w.isStream && w.buf.Len() > 0 {
+		w.handleLine(w.buf.String())
+		w.buf.Reset()
+	}
+}
+
+func (w *openaiOutputCapture) handleLine(line string) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "data:") {
+		return
+	}
+
+	data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+	if data == "" || data == "[DONE]" {
+		return
+	}
+	if w.onData != nil {
+		w.onData(data)
+	}
+}
+
+// Status returns the status code the adaptor tried to write
+func (w *openaiOutputCapture) Status() int {
+	return w.status
+}
+
+// Body returns the captured body in non-stream mode
+func (w *openaiOutputCapture) Body() []byte {
+	return w.buf.Bytes()
+}
@@ -0,0 +1,168 @@
+package controller
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+
+	"github.com/Laisky/errors/v2"
+	"gorm.io/gorm"
+
+	"github.com/songquanpeng/one-api/common/storage"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+)
+
+// fileResolver replaces ids of files uploaded through the Files API with their content,
+// since upstream channels know nothing about these files.
+//
+// Ids that are not found are left as is, they may refer to files uploaded to the upstream directly.
+type fileResolver struct {
+	ctx    context.Context
+	userId int
+	// files caches the resolved files by id, a file could be referred several times
+	files map[string]*resolvedFile
+}
+
+type resolvedFile struct {
+	filename string
+	// dataURL is the content in the form of data:<mime type>;base64,<data>
+	dataURL string
+}
+
+func newFileResolver(ctx context.Context, userId int) *fileResolver {
+	return &fileResolver{
+		ctx:    ctx,
+		userId: userId,
+		files:  make(map[string]*resolvedFile),
+	}
+}
+
+// resolve loads the file of id, nil is returned if the file is not uploaded to us
+func (r *fileResolver) resolve(fileId string) (*resolvedFile, error) {
+	if resolved, ok := r.files[fileId]; ok {
+		return resolved, nil
+	}
+
+	file, err := model.GetFileById(fileId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	// do not reveal files of other users
+	if file.UserId != r.userId {
+		return nil, errors.Errorf("file %s not found", fileId)
+	}
+
+	content, err := storage.Default.Get(r.ctx, file.StorageKey)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read file %s", fileId)
+	}
+	defer content.Close()
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read file %s", fileId)
+	}
+
+	resolved := &resolvedFile{
+		filename: file.Filename,
+		dataURL:  fmt.Sprintf("data:%s;base64,%s", file.ContentType, base64.StdEncoding.EncodeToString(data)),
+	}
+	r.files[fileId] = resolved
+	return resolved, nil
+}
+
+// resolveChatFileReferences inlines files referred by {"type": "file", "file": {"file_id": ...}} parts,
+// returns whether any message is modified.
+func resolveChatFileReferences(ctx context.Context, userId int, request *relaymodel.GeneralOpenAIRequest) (bool, error) {
+	resolver := newFileResolver(ctx, userId)
+	modified := false
+	for i := range request.Messages {
+		parts, ok := request.Messages[i].Content.([]any)
+		if !ok {
+			continue
+		}
+
+		for _, part := range parts {
+			partMap, ok := part.(map[string]any)
+			if !ok || partMap["type"] != relaymodel.ContentTypeFile {
+				continue
+			}
+			fileMap, ok := partMap["file"].(map[string]any)
+			if !ok {
+				continue
+			}
+			fileId, _ := fileMap["file_id"].(string)
+			if fileId == "" {
+				continue
+			}
+
+			resolved, err := resolver.resolve(fileId)
+			if err != nil {
+				return false, errors.WithStack(err)
+			}
+			if resolved == nil {
+				continue
+			}
+			delete(fileMap, "file_id")
+			fileMap["file_data"] = resolved.dataURL
+			if _, ok := fileMap["filename"]; !ok {
+				fileMap["filename"] = resolved.filename
+			}
+			modified = true
+		}
+	}
+
+	return modified, nil
+}
+
+// resolveResponseAPIFileReferences inlines files referred by input_file and input_image parts
+func resolveResponseAPIFileReferences(ctx context.Context, userId int, request *openai.ResponseAPIRequest) error {
+	resolver := newFileResolver(ctx, userId)
+	for _, item := range request.Input {
+		itemMap, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		parts, ok := itemMap["content"].([]any)
+		if !ok {
+			continue
+		}
+
+		for _, part := range parts {
+			partMap, ok := part.(map[string]any)
+			if !ok {
+				continue
+			}
+			fileId, _ := partMap["file_id"].(string)
+			if fileId == "" {
+				continue
+			}
+
+			resolved, err := resolver.resolve(fileId)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			if resolved == nil {
+				continue
+			}
+			switch partMap["type"] {
+			case "input_file":
+				delete(partMap, "file_id")
+				partMap["file_data"] = resolved.dataURL
+				if _, ok := partMap["filename"]; !ok {
+					partMap["filename"] = resolved.filename
+				}
+			case "input_image":
+				delete(partMap, "file_id")
+				partMap["image_url"] = resolved.dataURL
+			}
+		}
+	}
+
+	return nil
+}
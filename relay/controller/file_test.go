@@ -0,0 +1,113 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/songquanpeng/one-api/common/storage"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+)
+
+// setupFileTest stores a file of user 1 and a file of user 2
+func setupFileTest(t *testing.T) (own, others *model.File) {
+	testDB, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, testDB.AutoMigrate(&model.File{}))
+	originalDB, originalStorage := model.DB, storage.Default
+	model.DB, storage.Default = testDB, storage.NewLocal(t.TempDir())
+	t.Cleanup(func() { model.DB, storage.Default = originalDB, originalStorage })
+
+	ctx := context.Background()
+	own = model.NewFile(1, 1, "report.pdf", "user_data", 3, "application/pdf")
+	require.NoError(t, storage.Default.Put(ctx, own.StorageKey, strings.NewReader("pdf"), own.Bytes, own.ContentType))
+	require.NoError(t, own.Insert())
+	others = model.NewFile(2, 2, "secret.pdf", "user_data", 3, "application/pdf")
+	require.NoError(t, storage.Default.Put(ctx, others.StorageKey, strings.NewReader("top"), others.Bytes, others.ContentType))
+	require.NoError(t, others.Insert())
+
+	return own, others
+}
+
+func TestResolveChatFileReferences(t *testing.T) {
+	own, others := setupFileTest(t)
+	ctx := context.Background()
+
+	parse := func(raw string) *relaymodel.GeneralOpenAIRequest {
+		request := new(relaymodel.GeneralOpenAIRequest)
+		require.NoError(t, json.Unmarshal([]byte(raw), request))
+		return request
+	}
+
+	request := parse(`{"model":"m","messages":[
+		{"role":"system","content":"be brief"},
+		{"role":"user","content":[
+			{"type":"text","text":"summarize"},
+			{"type":"file","file":{"file_id":"` + own.Id + `"}},
+			{"type":"file","file":{"file_id":"file-upstream"}}
+		]}
+	]}`)
+	modified, err := resolveChatFileReferences(ctx, 1, request)
+	require.NoError(t, err)
+	assert.True(t, modified)
+
+	parts := request.Messages[1].ParseContent()
+	require.Len(t, parts, 3)
+	assert.Equal(t, "data:application/pdf;base64,cGRm", parts[1].File.FileData)
+	assert.Equal(t, "report.pdf", parts[1].File.Filename)
+	assert.Empty(t, parts[1].File.FileId)
+	// unknown ids may refer to files of the upstream
+	assert.Equal(t, "file-upstream", parts[2].File.FileId)
+
+	modified, err = resolveChatFileReferences(ctx, 1, parse(`{"model":"m","messages":[{"role":"user","content":"hi"}]}`))
+	require.NoError(t, err)
+	assert.False(t, modified)
+
+	// files of other users could not be referred
+	_, err = resolveChatFileReferences(ctx, 1, parse(`{"model":"m","messages":[{"role":"user","content":[
+		{"type":"file","file":{"file_id":"`+others.Id+`"}}
+	]}]}`))
+	require.Error(t, err)
+}
+
+func TestResolveResponseAPIFileReferences(t *testing.T) {
+	own, others := setupFileTest(t)
+	ctx := context.Background()
+
+	request := new(openai.ResponseAPIRequest)
+	require.NoError(t, json.Unmarshal([]byte(`{"model":"m","input":[{"role":"user","content":[
+		{"type":"input_text","text":"summarize"},
+		{"type":"input_file","file_id":"`+own.Id+`"},
+		{"type":"input_image","file_id":"`+own.Id+`"}
+	]}]}`), request))
+	require.NoError(t, resolveResponseAPIFileReferences(ctx, 1, request))
+
+	data, err := json.Marshal(request.Input)
+	require.NoError(t, err)
+	assert.JSONEq(t, `[{"role":"user","content":[
+		{"type":"input_text","text":"summarize"},
+		{"type":"input_file","file_data":"data:application/pdf;base64,cGRm","filename":"report.pdf"},
+		{"type":"input_image","image_url":"data:application/pdf;base64,cGRm"}
+	]}]`, string(data))
+
+	// the resolved file could be served by chat completions
+	textRequest, err := openai.ConvertResponseAPIRequest(request)
+	require.NoError(t, err)
+	parts := textRequest.Messages[0].ParseContent()
+	require.Len(t, parts, 3)
+	assert.Equal(t, relaymodel.ContentTypeFile, parts[1].Type)
+	assert.Equal(t, "report.pdf", parts[1].File.Filename)
+
+	require.NoError(t, json.Unmarshal([]byte(`{"model":"m","input":[{"role":"user","content":[
+		{"type":"input_file","file_id":"`+others.Id+`"}
+	]}]}`), request))
+	require.Error(t, resolveResponseAPIFileReferences(ctx, 1, request))
+}
@@ -0,0 +1,363 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/client"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/storage"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay"
+	"github.com/songquanpeng/one-api/relay/adaptor"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/billing/ratio"
+	"github.com/songquanpeng/one-api/relay/channeltype"
+	metalib "github.com/songquanpeng/one-api/relay/meta"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+// https://platform.openai.com/docs/api-reference/fine-tuning
+
+// fineTuningFileProcessTimeout is how long to wait for upstream to process an uploaded training file
+const fineTuningFileProcessTimeout = 2 * time.Minute
+
+// fineTuningJob holds the fields of fine-tuning jobs that one-api cares about
+type fineTuningJob struct {
+	Id            string           `json:"id"`
+	Object        string           `json:"object"`
+	Model         string           `json:"model"`
+	Status        string           `json:"status"`
+	TrainedTokens *int             `json:"trained_tokens"`
+	Data          []*fineTuningJob `json:"data"`
+}
+
+// RelayFineTuningHelper proxies fine-tuning job requests to OpenAI or Azure channels.
+//
+// The distributor has selected the channel owning the referred job. Training files uploaded
+// through the Files API are uploaded to the channel when the job is created,
+// and the trained tokens of succeeded jobs are billed by the training ratio of the base model.
+func RelayFineTuningHelper(c *gin.Context) *relaymodel.ErrorWithStatusCode {
+	ctx := c.Request.Context()
+	meta := metalib.GetByContext(c)
+	if meta.ChannelType != channeltype.OpenAI && meta.ChannelType != channeltype.Azure {
+		return openai.ErrorWrapper(errors.Errorf("channel type %d does not support fine-tuning", meta.ChannelType),
+			"unsupported_channel_type", http.StatusBadRequest)
+	}
+	meta.Mode = relaymode.FineTuning
+	meta.RequestURLPath = c.Request.URL.String()
+	meta.IsStream = c.Query("stream") == "true"
+
+	adaptor := relay.GetAdaptor(meta.APIType)
+	if adaptor == nil {
+		return openai.ErrorWrapper(errors.Errorf("invalid api type: %d", meta.APIType), "invalid_api_type", http.StatusBadRequest)
+	}
+	adaptor.Init(meta)
+
+	var requestBody []byte
+	if c.Request.Method == http.MethodPost && c.Param("id") == "" {
+		if bizErr := checkRemainingQuota(c, meta); bizErr != nil {
+			return bizErr
+		}
+		var bizErr *relaymodel.ErrorWithStatusCode
+		if requestBody, bizErr = getFineTuningRequestBody(c, meta, adaptor); bizErr != nil {
+			return bizErr
+		}
+	}
+
+	resp, err := adaptor.DoRequest(c, meta, bytes.NewReader(requestBody))
+	if err != nil {
+		logger.Errorf(ctx, "DoRequest failed: %s", err.Error())
+		return openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return RelayErrorHandler(resp)
+	}
+	defer resp.Body.Close()
+
+	if strings.HasSuffix(c.Request.URL.Path, "/events") {
+		return doFineTuningEventsResponse(c, resp)
+	}
+	return doFineTuningResponse(c, resp, meta)
+}
+
+// getFineTuningRequestBody returns the body to create a job,
+// in which the model is mapped by the channel and local files are replaced by the ones uploaded to the channel.
+func getFineTuningRequestBody(c *gin.Context, meta *metalib.Meta, adaptor adaptor.Adaptor) ([]byte, *relaymodel.ErrorWithStatusCode) {
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil {
+		return nil, openai.ErrorWrapper(err, "read_request_body_failed", http.StatusBadRequest)
+	}
+	fields := map[string]json.RawMessage{}
+	if err = json.Unmarshal(requestBody, &fields); err != nil {
+		return nil, openai.ErrorWrapper(err, "invalid_fine_tuning_request", http.StatusBadRequest)
+	}
+
+	if meta.ActualModelName != "" && meta.ActualModelName != meta.OriginModelName {
+		fields["model"], _ = json.Marshal(meta.ActualModelName)
+	}
+	for _, key := range []string{"training_file", "validation_file"} {
+		var fileId string
+		if err = json.Unmarshal(fields[key], &fileId); err != nil || fileId == "" {
+			continue
+		}
+		file, err := model.GetUserFileById(fileId, meta.UserId)
+		if err != nil {
+			// unknown ids may refer to files of the upstream
+			continue
+		}
+		upstreamFileId, err := uploadFineTuningFile(c, meta, adaptor, file)
+		if err != nil {
+			logger.Errorf(c.Request.Context(), "upload %s %s to channel %d failed: %+v", key, fileId, meta.ChannelId, err)
+			return nil, openai.ErrorWrapper(errors.Wrapf(err, "failed to upload %s", key), "upload_file_failed", http.StatusBadGateway)
+		}
+		fields[key], _ = json.Marshal(upstreamFileId)
+	}
+
+	if requestBody, err = json.Marshal(fields); err != nil {
+		return nil, openai.ErrorWrapper(err, "marshal_request_body_failed", http.StatusInternalServerError)
+	}
+	return requestBody, nil
+}
+
+// uploadFineTuningFile uploads a file of the Files API to the channel,
+// and waits until the channel has processed it, since jobs could not be created on unprocessed files.
+func uploadFineTuningFile(c *gin.Context, meta *metalib.Meta, adaptor adaptor.Adaptor, file *model.File) (string, error) {
+	ctx := c.Request.Context()
+	content, err := storage.Default.Get(ctx, file.StorageKey)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read file %s", file.Id)
+	}
+	defer content.Close()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	if err = writer.WriteField("purpose", "fine-tune"); err != nil {
+		return "", errors.WithStack(err)
+	}
+	part, err := writer.CreateFormFile("file", file.Filename)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	if _, err = io.Copy(part, content); err != nil {
+		return "", errors.Wrapf(err, "failed to read file %s", file.Id)
+	}
+	if err = writer.Close(); err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	uploaded := struct {
+		Id     string `json:"id"`
+		Status string `json:"status"`
+	}{}
+	if err = doFineTuningFileRequest(c, meta, adaptor, http.MethodPost, "/v1/files",
+		writer.FormDataContentType(), body, &uploaded); err != nil {
+		return "", errors.Wrap(err, "failed to upload file")
+	}
+
+	deadline := time.Now().Add(fineTuningFileProcessTimeout)
+	for uploaded.Status != "processed" {
+		switch {
+		case uploaded.Status == "error":
+			return "", errors.Errorf("upstream failed to process file %s", uploaded.Id)
+		case time.Now().After(deadline):
+			// leave it to upstream to decide
+			return uploaded.Id, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", errors.WithStack(ctx.Err())
+		case <-time.After(2 * time.Second):
+		}
+		if err = doFineTuningFileRequest(c, meta, adaptor, http.MethodGet, "/v1/files/"+uploaded.Id,
+			"", nil, &uploaded); err != nil {
+			return "", errors.Wrap(err, "failed to retrieve file")
+		}
+	}
+
+	return uploaded.Id, nil
+}
+
+// doFineTuningFileRequest sends a request of the Files API to the channel, and decodes the JSON response to v
+func doFineTuningFileRequest(c *gin.Context, meta *metalib.Meta, adaptor adaptor.Adaptor,
+	method, path, contentType string, body io.Reader, v any) error {
+	requestURLPath := meta.RequestURLPath
+	meta.RequestURLPath = path
+	fullRequestURL, err := adaptor.GetRequestURL(meta)
+	meta.RequestURLPath = requestURLPath
+	if err != nil {
+		return errors.Wrap(err, "get request url failed")
+	}
+
+	req, err := http.NewRequestWithContext(c.Request.Context(), method, fullRequestURL, body)
+	if err != nil {
+		return errors.Wrap(err, "new request failed")
+	}
+	if err = adaptor.SetupRequestHeader(c, req, meta); err != nil {
+		return errors.Wrap(err, "setup request header failed")
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.HTTPClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "do request failed")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return errors.Errorf("upstream returned status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	return errors.Wrap(json.NewDecoder(resp.Body).Decode(v), "failed to decode response")
+}
+
+// doFineTuningResponse records created jobs, bills succeeded jobs, and hides jobs of other users in listings
+func doFineTuningResponse(c *gin.Context, resp *http.Response, meta *metalib.Meta) *relaymodel.ErrorWithStatusCode {
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return openai.ErrorWrapper(err, "read_response_body_failed", http.StatusInternalServerError)
+	}
+	job := &fineTuningJob{}
+	if err = json.Unmarshal(responseBody, job); err != nil {
+		return openai.ErrorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError)
+	}
+
+	jobs := []*fineTuningJob{job}
+	if job.Object == "list" {
+		// one upstream key is shared by users, who should only see their own jobs
+		responseBody, err = filterListByOwner(responseBody, func(ids []string) (map[string]bool, error) {
+			return model.GetUserFineTuningJobIds(meta.UserId, ids)
+		})
+		if err != nil {
+			return openai.ErrorWrapper(err, "filter_fine_tuning_jobs_failed", http.StatusInternalServerError)
+		}
+		jobs = job.Data
+	} else if c.Request.Method == http.MethodPost && c.Param("id") == "" && job.Id != "" {
+		// the job is recorded before the client could see it
+		record := model.NewFineTuningJob(job.Id, meta.UserId, meta.TokenId, meta.ChannelId, job.Model)
+		if err = record.Insert(); err != nil {
+			return openai.ErrorWrapper(err, "insert_fine_tuning_job_failed", http.StatusInternalServerError)
+		}
+	}
+
+	groupRatio := c.GetFloat64(ctxkey.ChannelRatio)
+	for _, job := range jobs {
+		if job.Object == "fine_tuning.job" && job.Status == "succeeded" && job.TrainedTokens != nil {
+			billFineTuningJob(c.Request.Context(), meta, job, groupRatio)
+		}
+	}
+
+	c.Data(resp.StatusCode, resp.Header.Get("Content-Type"), responseBody)
+	return nil
+}
+
+// doFineTuningEventsResponse streams the events of a job to the client as they come
+func doFineTuningEventsResponse(c *gin.Context, resp *http.Response) *relaymodel.ErrorWithStatusCode {
+	for k, v := range resp.Header {
+		if k == "Content-Type" || k == "Cache-Control" {
+			c.Writer.Header()[k] = v
+		}
+	}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		common.SetEventStreamHeaders(c)
+	}
+	c.Writer.WriteHeader(resp.StatusCode)
+
+	reader := bufio.NewReader(resp.Body)
+	buffer := make([]byte, 32*1024)
+	for {
+		n, err := reader.Read(buffer)
+		if n > 0 {
+			if _, err := c.Writer.Write(buffer[:n]); err != nil {
+				return openai.ErrorWrapper(err, "write_response_failed", http.StatusInternalServerError)
+			}
+			c.Writer.Flush()
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			logger.Errorf(c.Request.Context(), "error reading fine-tuning events: %+v", err)
+			return nil
+		}
+	}
+}
+
+// billFineTuningJob charges the token that created the job for the trained tokens,
+// each job is billed only once no matter how many times its final state is retrieved.
+func billFineTuningJob(ctx context.Context, meta *metalib.Meta, job *fineTuningJob, groupRatio float64) {
+	billed, err := model.MarkFineTuningJobBilled(job.Id)
+	if err != nil {
+		logger.Errorf(ctx, "mark fine-tuning job billed failed: %+v", err)
+		return
+	}
+	if !billed {
+		return
+	}
+	record, err := model.GetUserFineTuningJob(job.Id, meta.UserId)
+	if err != nil {
+		logger.Errorf(ctx, "get fine-tuning job record failed: %+v", err)
+		return
+	}
+
+	modelName := job.Model
+	if modelName == "" {
+		modelName = record.Model
+	}
+	trainingRatio := ratio.GetTrainingRatio(modelName)
+	trainedTokens := *job.TrainedTokens
+	quota := int64(math.Ceil(float64(trainedTokens) * trainingRatio * groupRatio))
+	if trainingRatio*groupRatio != 0 && trainedTokens > 0 && quota <= 0 {
+		quota = 1
+	}
+	tokenName := meta.TokenName
+	if record.TokenId != meta.TokenId {
+		if token, err := model.GetTokenById(record.TokenId); err == nil {
+			tokenName = token.Name
+		}
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if err := model.PostConsumeTokenQuota(ctx, record.TokenId, quota); err != nil {
+			logger.Errorf(ctx, "error consuming token remain quota: %+v", err)
+		}
+		if err := model.CacheUpdateUserQuota(ctx, record.UserId); err != nil {
+			logger.Errorf(ctx, "error update user quota cache: %+v", err)
+		}
+		model.RecordConsumeLog(ctx, &model.Log{
+			UserId:       record.UserId,
+			ChannelId:    record.ChannelId,
+			PromptTokens: trainedTokens,
+			ModelName:    modelName,
+			TokenName:    tokenName,
+			Quota:        int(quota),
+			Content: fmt.Sprintf("fine-tuning job %s, trained tokens %d, training rate %.2f, group rate %.2f",
+				job.Id, trainedTokens, trainingRatio, groupRatio),
+		})
+		if quota > 0 {
+			model.UpdateUserUsedQuotaAndRequestCount(record.UserId, quota)
+			model.UpdateChannelUsedQuota(record.ChannelId, quota)
+			model.RecordSpend(ctx, record.UserId, record.TokenId, quota)
+		}
+	}()
+}
@@ -0,0 +1,84 @@
+package controller
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/songquanpeng/one-api/model"
+	metalib "github.com/songquanpeng/one-api/relay/meta"
+)
+
+func setupFineTuningTest(t *testing.T) {
+	testDB, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, testDB.AutoMigrate(&model.FineTuningJob{}))
+	originalDB := model.DB
+	model.DB = testDB
+	t.Cleanup(func() { model.DB = originalDB })
+}
+
+func doFineTuningTestResponse(t *testing.T, method, path, id, body string, meta *metalib.Meta) string {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(method, path, nil)
+	if id != "" {
+		c.Params = gin.Params{{Key: "id", Value: id}}
+	}
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+	require.Nil(t, doFineTuningResponse(c, resp, meta))
+	return w.Body.String()
+}
+
+func TestDoFineTuningResponse(t *testing.T) {
+	setupFineTuningTest(t)
+	meta := &metalib.Meta{UserId: 1, TokenId: 2, ChannelId: 3}
+
+	created := `{"id":"ftjob-1","object":"fine_tuning.job","model":"gpt-4o-mini-2024-07-18","status":"validating_files","trained_tokens":null}`
+	assert.JSONEq(t, created, doFineTuningTestResponse(t, http.MethodPost, "/v1/fine_tuning/jobs", "", created, meta))
+	record, err := model.GetUserFineTuningJob("ftjob-1", 1)
+	require.NoError(t, err)
+	assert.Equal(t, 2, record.TokenId)
+	assert.Equal(t, 3, record.ChannelId)
+	assert.Equal(t, "gpt-4o-mini-2024-07-18", record.Model)
+	assert.False(t, record.Billed)
+
+	// retrieving or cancelling a job does not record it
+	cancelled := `{"id":"ftjob-2","object":"fine_tuning.job","model":"gpt-4o-mini-2024-07-18","status":"cancelled"}`
+	doFineTuningTestResponse(t, http.MethodPost, "/v1/fine_tuning/jobs/ftjob-2/cancel", "ftjob-2", cancelled, meta)
+	_, err = model.GetUserFineTuningJob("ftjob-2", 1)
+	require.Error(t, err)
+
+	require.NoError(t, model.NewFineTuningJob("ftjob-3", 2, 4, 3, "gpt-4o-mini-2024-07-18").Insert())
+	list := `{"object":"list","data":[{"id":"ftjob-3","object":"fine_tuning.job","status":"running"},{"id":"ftjob-1","object":"fine_tuning.job","status":"running"}],"has_more":false}`
+	assert.JSONEq(t, `{"object":"list","data":[{"id":"ftjob-1","object":"fine_tuning.job","status":"running"}],"has_more":false}`,
+		doFineTuningTestResponse(t, http.MethodGet, "/v1/fine_tuning/jobs", "", list, meta))
+}
+
+func TestDoFineTuningEventsResponse(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/v1/fine_tuning/jobs/ftjob-1/events?stream=true", nil)
+	events := "data: {\"object\":\"fine_tuning.job.event\",\"message\":\"Step 1/10\"}\n\ndata: [DONE]\n\n"
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+		Body:       io.NopCloser(strings.NewReader(events)),
+	}
+
+	require.Nil(t, doFineTuningEventsResponse(c, resp))
+	assert.Equal(t, events, w.Body.String())
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+}
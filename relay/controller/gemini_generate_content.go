@@ -0,0 +1,257 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay"
+	"github.com/songquanpeng/one-api/relay/adaptor"
+	"github.com/songquanpeng/one-api/relay/adaptor/gemini"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/billing"
+	"github.com/songquanpeng/one-api/relay/channeltype"
+	metalib "github.com/songquanpeng/one-api/relay/meta"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/pricing"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+// RelayGeminiGenerateContentHelper handles native Gemini API requests
+// (/v1beta/models/{model}:generateContent and :streamGenerateContent).
+//
+// Gemini channels receive the request body as is,
+// other channels receive the request converted to GeneralOpenAIRequest,
+// and their OpenAI-format output is converted back to the Gemini API format.
+// Streams are always answered with server-sent events, as requested by alt=sse.
+func RelayGeminiGenerateContentHelper(c *gin.Context) *relaymodel.ErrorWithStatusCode {
+	ctx := c.Request.Context()
+	meta := metalib.GetByContext(c)
+
+	geminiRequest, err := getAndValidateGeminiGenerateContentRequest(c)
+	if err != nil {
+		logger.Errorf(ctx, "getAndValidateGeminiGenerateContentRequest failed: %s", err.Error())
+		return openai.ErrorWrapper(err, "invalid_generate_content_request", http.StatusBadRequest)
+	}
+	meta.IsStream = strings.HasSuffix(c.Request.URL.Path, ":streamGenerateContent")
+
+	// the converted request is also used for token estimation and billing of native requests
+	textRequest, err := gemini.ConvertGenerateContentRequest(meta.OriginModelName, geminiRequest, meta.IsStream)
+	if err != nil {
+		if meta.ChannelType != channeltype.Gemini || meta.ForcedSystemPrompt != "" {
+			return openai.ErrorWrapper(err, "convert_generate_content_request_failed", http.StatusBadRequest)
+		}
+		// Gemini channels understand every part, only the prompt estimation is affected
+		logger.Warnf(ctx, "convert generateContent request failed, the prompt will not be estimated: %+v", err)
+		textRequest = &relaymodel.GeneralOpenAIRequest{
+			Model:  meta.OriginModelName,
+			Stream: meta.IsStream,
+		}
+		if geminiRequest.GenerationConfig != nil {
+			textRequest.MaxTokens = geminiRequest.GenerationConfig.MaxOutputTokens
+		}
+	}
+
+	// map model name
+	textRequest.Model = meta.ActualModelName
+	systemPromptReset := setSystemPrompt(ctx, textRequest, meta.ForcedSystemPrompt)
+
+	// meta is reused across retries, the channel decides which protocol is spoken upstream
+	isNative := meta.ChannelType == channeltype.Gemini && !systemPromptReset
+	if isNative {
+		meta.Mode = relaymode.GeminiGenerateContent
+		meta.RequestURLPath = c.Request.URL.String()
+	} else {
+		meta.Mode = relaymode.ChatCompletions
+		meta.RequestURLPath = "/v1/chat/completions"
+	}
+
+	// get channel-specific pricing if available
+	channelModelRatio, _ := getChannelRatios(c, meta.ChannelId)
+	tokenRatios := getTokenRatios(c, meta, textRequest.Model)
+
+	pricingAdaptor := relay.GetAdaptor(meta.ChannelType)
+	modelRatio := pricing.GetModelRatioWithThreeLayers(textRequest.Model, channelModelRatio, pricingAdaptor)
+	groupRatio := c.GetFloat64(ctxkey.ChannelRatio)
+	ratio := modelRatio * groupRatio
+
+	// pre-consume quota
+	promptTokens := getPromptTokens(ctx, textRequest, relaymode.ChatCompletions)
+	meta.PromptTokens = promptTokens
+	preConsumedQuota, bizErr := preConsumeQuota(c, textRequest, promptTokens, ratio, meta)
+	if bizErr != nil {
+		logger.Warnf(ctx, "preConsumeQuota failed: %+v", *bizErr)
+		return bizErr
+	}
+
+	// the pre-consumed quota is returned unless the request is billed, e.g. when it fails
+	billed := false
+	defer func() {
+		if !billed {
+			billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta.TokenId)
+		}
+	}()
+
+	adaptor := relay.GetAdaptor(meta.APIType)
+	if adaptor == nil {
+		return openai.ErrorWrapper(errors.Errorf("invalid api type: %d", meta.APIType), "invalid_api_type", http.StatusBadRequest)
+	}
+	adaptor.Init(meta)
+
+	var requestBody io.Reader
+	if isNative {
+		requestBody, err = getNativeGeminiGenerateContentRequestBody(c)
+	} else {
+		requestBody, err = getConvertedRequestBody(c, meta, textRequest, adaptor)
+	}
+	if err != nil {
+		return openai.ErrorWrapper(err, "convert_request_failed", http.StatusInternalServerError)
+	}
+
+	// do request
+	resp, err := adaptor.DoRequest(c, meta, requestBody)
+	if err != nil {
+		logger.Errorf(ctx, "DoRequest failed: %s", err.Error())
+		return openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
+	}
+	if isErrorHappened(meta, resp) {
+		return RelayErrorHandler(resp)
+	}
+
+	// do response
+	var (
+		usage   *relaymodel.Usage
+		respErr *relaymodel.ErrorWithStatusCode
+	)
+	if isNative {
+		usage, respErr = adaptor.DoResponse(c, resp, meta)
+	} else {
+		usage, respErr = doConvertedGeminiGenerateContentResponse(c, resp, meta, adaptor)
+	}
+	if respErr != nil {
+		logger.Errorf(ctx, "respErr is not nil: %+v", respErr)
+		return respErr
+	}
+	billed = true
+	recordUsageTokens(c, usage)
+
+	// post-consume quota
+	quotaId := c.GetInt(ctxkey.Id)
+	requestId := c.GetString(ctxkey.RequestId)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		quota := postConsumeQuota(ctx, usage, meta, textRequest, ratio, preConsumedQuota, modelRatio, groupRatio, systemPromptReset, tokenRatios)
+		if quota != 0 {
+			docu := model.NewUserRequestCost(
+				quotaId,
+				requestId,
+				quota,
+			)
+			if err = docu.Insert(); err != nil {
+				logger.Errorf(ctx, "insert user request cost failed: %+v", err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// getAndValidateGeminiGenerateContentRequest gets and validates a native generateContent request
+func getAndValidateGeminiGenerateContentRequest(c *gin.Context) (*gemini.GenerateContentRequest, error) {
+	action := c.Request.URL.Path[strings.LastIndex(c.Request.URL.Path, ":")+1:]
+	if action != "generateContent" && action != "streamGenerateContent" {
+		return nil, errors.Errorf("unsupported method %q", action)
+	}
+
+	geminiRequest := &gemini.GenerateContentRequest{}
+	if err := common.UnmarshalBodyReusable(c, geminiRequest); err != nil {
+		return nil, errors.Wrap(err, "unmarshal generateContent request")
+	}
+	if len(geminiRequest.Contents) == 0 {
+		return nil, errors.New("contents is required")
+	}
+
+	return geminiRequest, nil
+}
+
+// getNativeGeminiGenerateContentRequestBody returns the raw request body,
+// the model is part of the upstream url built by the adaptor.
+func getNativeGeminiGenerateContentRequestBody(c *gin.Context) (io.Reader, error) {
+	rawBody, err := common.GetRequestBody(c)
+	if err != nil {
+		return nil, errors.Wrap(err, "get request body")
+	}
+
+	logger.Debugf(c.Request.Context(), "native generateContent request: \n%s", string(rawBody))
+	return bytes.NewReader(rawBody), nil
+}
+
+// doConvertedGeminiGenerateContentResponse lets the adaptor render its OpenAI-format response,
+// and converts the output to the Gemini API format on the fly.
+func doConvertedGeminiGenerateContentResponse(c *gin.Context, resp *http.Response,
+	meta *metalib.Meta, adaptor adaptor.Adaptor) (*relaymodel.Usage, *relaymodel.ErrorWithStatusCode) {
+	ctx := c.Request.Context()
+	client := c.Writer
+
+	if !meta.IsStream {
+		capture, restore := captureOpenAIOutput(c, false, nil)
+		usage, respErr := adaptor.DoResponse(c, resp, meta)
+		restore()
+		if respErr != nil {
+			return nil, respErr
+		}
+
+		textResponse := new(openai.TextResponse)
+		if err := json.Unmarshal(capture.Body(), textResponse); err != nil {
+			return nil, openai.ErrorWrapper(err, "unmarshal_converted_response_failed", http.StatusInternalServerError)
+		}
+		textResponse.Model = meta.OriginModelName
+		if usage != nil {
+			textResponse.Usage = *usage
+		}
+
+		c.JSON(capture.Status(), gemini.ConvertOpenAIResponse(textResponse))
+		return usage, nil
+	}
+
+	converter := gemini.NewOpenAIStreamConverter(meta.OriginModelName)
+	render := func(event *gemini.GenerateContentResponse) {
+		if event == nil {
+			return
+		}
+		if err := gemini.RenderGenerateContentStreamEvent(client, event); err != nil {
+			logger.Errorf(ctx, "render generateContent stream event failed: %+v", err)
+		}
+	}
+
+	_, restore := captureOpenAIOutput(c, true, func(data string) {
+		chunk := new(openai.ChatCompletionsStreamResponse)
+		if err := json.Unmarshal([]byte(data), chunk); err != nil {
+			logger.Warnf(ctx, "unmarshal converted stream chunk %q failed: %+v", data, err)
+			return
+		}
+		render(converter.Convert(chunk))
+	})
+	usage, respErr := adaptor.DoResponse(c, resp, meta)
+	restore()
+	if respErr != nil {
+		return nil, respErr
+	}
+
+	render(converter.Finish(usage))
+
+	return usage, nil
+}
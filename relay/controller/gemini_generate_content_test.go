@@ -0,0 +1,118 @@
+package controller
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/songquanpeng/one-api/relay/adaptor/gemini"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/channeltype"
+	metalib "github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+func newGeminiGenerateContentTestContext(t *testing.T, path string) (*gin.Context, *httptest.ResponseRecorder) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, path, nil)
+	return c, w
+}
+
+func TestDoConvertedGeminiGenerateContentResponse_Stream(t *testing.T) {
+	c, w := newGeminiGenerateContentTestContext(t, "/v1beta/models/gemini-2.0-flash:streamGenerateContent?alt=sse")
+	meta := &metalib.Meta{
+		Mode:            relaymode.ChatCompletions,
+		ChannelType:     channeltype.DeepSeek,
+		IsStream:        true,
+		OriginModelName: "gemini-2.0-flash",
+		ActualModelName: "deepseek-chat",
+		PromptTokens:    12,
+	}
+	upstream := strings.Join([]string{
+		`data: {"id":"chatcmpl-9","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"role":"assistant","content":"Hel"}}]}`,
+		``,
+		`data: {"id":"chatcmpl-9","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"content":"lo"},"finish_reason":"stop"}]}`,
+		``,
+		`data: {"id":"chatcmpl-9","object":"chat.completion.chunk","choices":[],"usage":{"prompt_tokens":11,"completion_tokens":2,"total_tokens":13}}`,
+		``,
+		`data: [DONE]`,
+		``,
+	}, "\n")
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+		Body:       io.NopCloser(strings.NewReader(upstream)),
+	}
+
+	usage, respErr := doConvertedGeminiGenerateContentResponse(c, resp, meta, &openai.Adaptor{ChannelType: meta.ChannelType})
+	require.Nil(t, respErr)
+	require.NotNil(t, usage)
+	assert.Equal(t, 13, usage.TotalTokens)
+
+	body := w.Body.String()
+	assert.NotContains(t, body, "chat.completion.chunk")
+	assert.NotContains(t, body, "[DONE]")
+
+	var events []*gemini.GenerateContentResponse
+	for _, line := range strings.Split(body, "\n") {
+		if data, ok := strings.CutPrefix(line, "data: "); ok {
+			event := new(gemini.GenerateContentResponse)
+			require.NoError(t, json.Unmarshal([]byte(data), event))
+			events = append(events, event)
+		}
+	}
+	require.Len(t, events, 3)
+	assert.Equal(t, "Hel", events[0].Candidates[0].Content.Parts[0].Text)
+	assert.Equal(t, "lo", events[1].Candidates[0].Content.Parts[0].Text)
+	assert.Equal(t, "STOP", events[2].Candidates[0].FinishReason)
+	assert.Equal(t, 13, events[2].UsageMetadata.TotalTokenCount)
+	assert.Equal(t, "gemini-2.0-flash", events[2].ModelVersion)
+}
+
+func TestDoConvertedGeminiGenerateContentResponse_NonStream(t *testing.T) {
+	c, w := newGeminiGenerateContentTestContext(t, "/v1beta/models/gemini-2.0-flash:generateContent")
+	meta := &metalib.Meta{
+		Mode:            relaymode.ChatCompletions,
+		ChannelType:     channeltype.DeepSeek,
+		OriginModelName: "gemini-2.0-flash",
+		ActualModelName: "deepseek-chat",
+	}
+	upstream := `{"id":"chatcmpl-7","object":"chat.completion","model":"deepseek-chat","choices":[{"index":0,"message":{"role":"assistant","content":"pong"},"finish_reason":"stop"}],"usage":{"prompt_tokens":3,"completion_tokens":1,"total_tokens":4}}`
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header: http.Header{
+			"Content-Type":   []string{"application/json"},
+			"Content-Length": []string{"999"},
+		},
+		Body: io.NopCloser(strings.NewReader(upstream)),
+	}
+
+	usage, respErr := doConvertedGeminiGenerateContentResponse(c, resp, meta, &openai.Adaptor{ChannelType: meta.ChannelType})
+	require.Nil(t, respErr)
+	assert.Equal(t, 4, usage.TotalTokens)
+	assert.Empty(t, w.Header().Get("Content-Length"))
+
+	geminiResponse := new(gemini.GenerateContentResponse)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), geminiResponse))
+	assert.Equal(t, "gemini-2.0-flash", geminiResponse.ModelVersion)
+	require.Len(t, geminiResponse.Candidates, 1)
+	assert.Equal(t, "pong", geminiResponse.Candidates[0].Content.Parts[0].Text)
+	assert.Equal(t, "STOP", geminiResponse.Candidates[0].FinishReason)
+	assert.Equal(t, 3, geminiResponse.UsageMetadata.PromptTokenCount)
+}
+
+func TestGetAndValidateGeminiGenerateContentRequest(t *testing.T) {
+	c, _ := newGeminiGenerateContentTestContext(t, "/v1beta/models/gemini-2.0-flash:countTokens")
+	_, err := getAndValidateGeminiGenerateContentRequest(c)
+	require.Error(t, err)
+}
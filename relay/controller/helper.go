@@ -2,6 +2,7 @@ package controller
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"math"
 	"net/http"
@@ -16,6 +17,7 @@ import (
 	"github.com/songquanpeng/one-api/common/logger"
 	"github.com/songquanpeng/one-api/model"
 	"github.com/songquanpeng/one-api/relay"
+	"github.com/songquanpeng/one-api/relay/adaptor"
 	"github.com/songquanpeng/one-api/relay/adaptor/openai"
 	"github.com/songquanpeng/one-api/relay/billing"
 	"github.com/songquanpeng/one-api/relay/billing/ratio"
@@ -97,8 +99,36 @@ func getPreConsumedQuota(textRequest *relaymodel.GeneralOpenAIRequest, promptTok
 	return baseQuota
 }
 
+// recordUsageTokens reports the tokens used by the request to the rate limits of its token, user and group
+func recordUsageTokens(c *gin.Context, usage *relaymodel.Usage) {
+	if usage != nil {
+		c.Set(ctxkey.UsageTokens, int64(usage.PromptTokens+usage.CompletionTokens))
+	}
+}
+
+// checkSpendLimits rejects requests estimated to spend quota over the daily, weekly or monthly
+// spend limits of the token or of the user
+func checkSpendLimits(c *gin.Context, userId int, tokenId int, quota int64) *relaymodel.ErrorWithStatusCode {
+	tokenLimits, _ := c.Value(ctxkey.TokenSpendLimits).(model.SpendLimits)
+	userLimits, err := model.CacheGetUserSpendLimits(userId)
+	if err != nil {
+		return openai.ErrorWrapper(err, "get_user_spend_limits_failed", http.StatusInternalServerError)
+	}
+	err = model.CheckSpendLimits(tokenId, tokenLimits, userId, userLimits, quota)
+	switch {
+	case errors.Is(err, model.ErrSpendLimitExceeded):
+		return openai.ErrorWrapper(err, "spend_limit_exceeded", http.StatusForbidden)
+	case err != nil:
+		return openai.ErrorWrapper(err, "check_spend_limits_failed", http.StatusInternalServerError)
+	}
+	return nil
+}
+
 func preConsumeQuota(c *gin.Context, textRequest *relaymodel.GeneralOpenAIRequest, promptTokens int, ratio float64, meta *meta.Meta) (int64, *relaymodel.ErrorWithStatusCode) {
 	preConsumedQuota := getPreConsumedQuota(textRequest, promptTokens, ratio)
+	if bizErr := checkSpendLimits(c, meta.UserId, meta.TokenId, preConsumedQuota); bizErr != nil {
+		return preConsumedQuota, bizErr
+	}
 
 	tokenQuota := c.GetInt64(ctxkey.TokenQuota)
 	tokenQuotaUnlimited := c.GetBool(ctxkey.TokenQuotaUnlimited)
@@ -121,7 +151,7 @@ func preConsumeQuota(c *gin.Context, textRequest *relaymodel.GeneralOpenAIReques
 		logger.Info(c.Request.Context(), fmt.Sprintf("user %d has enough quota %d, trusted and no need to pre-consume", meta.UserId, userQuota))
 	}
 	if preConsumedQuota > 0 {
-		err := model.PreConsumeTokenQuota(meta.TokenId, preConsumedQuota)
+		err := model.PreConsumeTokenQuota(c.Request.Context(), meta.TokenId, preConsumedQuota)
 		if err != nil {
 			return preConsumedQuota, openai.ErrorWrapper(err, "pre_consume_token_quota_failed", http.StatusForbidden)
 		}
@@ -138,21 +168,22 @@ func postConsumeQuota(ctx context.Context,
 	modelRatio float64,
 	groupRatio float64,
 	systemPromptReset bool,
-	channelCompletionRatio map[string]float64) (quota int64) {
+	tokenRatios pricing.TokenRatios) (quota int64) {
 	if usage == nil {
 		logger.Error(ctx, "usage is nil, which is unexpected")
 		return
 	}
 
-	// Use three-layer pricing system for completion ratio
-	pricingAdaptor := relay.GetAdaptor(meta.ChannelType)
-	completionRatio := pricing.GetCompletionRatioWithThreeLayers(textRequest.Model, channelCompletionRatio, pricingAdaptor)
+	// requests with long prompts may be billed at a price tier of the model
+	modelRatio, tokenRatios = tokenRatios.ForPromptTokens(modelRatio, usage.PromptTokens)
+	ratio = modelRatio * groupRatio
+
 	promptTokens := usage.PromptTokens
 	// It appears that DeepSeek's official service automatically merges ReasoningTokens into CompletionTokens,
 	// but the behavior of third-party providers may differ, so for now we do not add them manually.
 	// completionTokens := usage.CompletionTokens + usage.CompletionTokensDetails.ReasoningTokens
 	completionTokens := usage.CompletionTokens
-	quota = int64(math.Ceil((float64(promptTokens)+float64(completionTokens)*completionRatio)*ratio)) + usage.ToolsCost
+	quota = int64(math.Ceil(tokenRatios.WeightedTokens(usage)*ratio)) + usage.ToolsCost
 	if ratio != 0 && quota <= 0 {
 		quota = 1
 	}
@@ -165,13 +196,66 @@ func postConsumeQuota(ctx context.Context,
 	}
 	// Use centralized detailed billing function to follow DRY principle
 	quotaDelta := quota - preConsumedQuota
-	billing.PostConsumeQuotaDetailed(ctx, meta.TokenId, quotaDelta, quota, meta.UserId, meta.ChannelId,
+	billing.PostConsumeQuotaDetailed(ctx, meta.TokenId, quotaDelta, quota, meta.UserId, meta.ChannelId, meta.ChannelKey,
 		promptTokens, completionTokens, modelRatio, groupRatio, textRequest.Model, meta.TokenName,
-		meta.IsStream, meta.StartTime, systemPromptReset, completionRatio, usage.ToolsCost)
+		meta.IsStream, meta.StartTime, systemPromptReset, tokenRatios.Completion, usage.ToolsCost,
+		cachedTokens(usage), cacheWriteTokens(usage))
 
 	return quota
 }
 
+// getTokenRatios returns the token ratios of modelName on the channel of the request,
+// the channel-specific ModelConfigs override the default pricing of the adaptor
+func getTokenRatios(c *gin.Context, meta *meta.Meta, modelName string) pricing.TokenRatios {
+	var channel *model.Channel
+	if channelModel, ok := c.Get(ctxkey.ChannelModel); ok {
+		channel, _ = channelModel.(*model.Channel)
+	}
+	return getChannelTokenRatios(channel, relay.GetAdaptor(meta.ChannelType), modelName)
+}
+
+// getChannelTokenRatios returns the token ratios of modelName on the channel, which may be nil
+func getChannelTokenRatios(channel *model.Channel, pricingAdaptor adaptor.Adaptor, modelName string) pricing.TokenRatios {
+	var channelOverride *adaptor.ModelConfig
+	if channel != nil {
+		if cfg := channel.GetModelPriceConfig(modelName); cfg != nil {
+			channelOverride = &adaptor.ModelConfig{
+				CompletionRatio:  cfg.CompletionRatio,
+				CachedInputRatio: cfg.CachedInputRatio,
+				CacheWriteRatio:  cfg.CacheWriteRatio,
+				AudioInputRatio:  cfg.AudioInputRatio,
+				AudioOutputRatio: cfg.AudioOutputRatio,
+				ImageInputRatio:  cfg.ImageInputRatio,
+			}
+			for _, tier := range cfg.Tiers {
+				channelOverride.Tiers = append(channelOverride.Tiers, adaptor.PriceTier{
+					InputTokenThreshold: tier.InputTokenThreshold,
+					Ratio:               tier.Ratio,
+					CompletionRatio:     tier.CompletionRatio,
+				})
+			}
+		}
+	}
+
+	return pricing.GetTokenRatiosWithThreeLayers(modelName, channelOverride, pricingAdaptor)
+}
+
+// cachedTokens returns the prompt tokens of usage read from the prompt cache
+func cachedTokens(usage *relaymodel.Usage) int {
+	if usage.PromptTokensDetails == nil {
+		return 0
+	}
+	return usage.PromptTokensDetails.CachedTokens
+}
+
+// cacheWriteTokens returns the prompt tokens of usage written to the prompt cache
+func cacheWriteTokens(usage *relaymodel.Usage) int {
+	if usage.PromptTokensDetails == nil {
+		return 0
+	}
+	return usage.PromptTokensDetails.CacheWriteTokens
+}
+
 func isErrorHappened(meta *meta.Meta, resp *http.Response) bool {
 	if resp == nil {
 		if meta.ChannelType == channeltype.AwsClaude {
@@ -217,3 +301,71 @@ func setSystemPrompt(ctx context.Context, request *relaymodel.GeneralOpenAIReque
 	logger.Infof(ctx, "add system prompt")
 	return true
 }
+
+// checkRemainingQuota refuses requests if the user or the token has no quota left or has spent a spend limit,
+// it is for requests whose usage is only known long after they are accepted, so nothing is pre-consumed.
+func checkRemainingQuota(c *gin.Context, meta *meta.Meta) *relaymodel.ErrorWithStatusCode {
+	userQuota, err := model.CacheGetUserQuota(c.Request.Context(), meta.UserId)
+	if err != nil {
+		return openai.ErrorWrapper(err, "get_user_quota_failed", http.StatusInternalServerError)
+	}
+	if userQuota <= 0 {
+		return openai.ErrorWrapper(errors.New("user quota is not enough"), "insufficient_user_quota", http.StatusForbidden)
+	}
+	if !c.GetBool(ctxkey.TokenQuotaUnlimited) && c.GetInt64(ctxkey.TokenQuota) <= 0 {
+		return openai.ErrorWrapper(errors.New("token quota is not enough"), "insufficient_token_quota", http.StatusForbidden)
+	}
+
+	return checkSpendLimits(c, meta.UserId, meta.TokenId, 0)
+}
+
+// filterListByOwner removes objects of other users from a list response of a shared upstream key,
+// owned reports which of the listed ids belong to the user.
+func filterListByOwner(responseBody []byte, owned func(ids []string) (map[string]bool, error)) ([]byte, error) {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(responseBody, &fields); err != nil {
+		return nil, errors.Wrap(err, "failed to parse list")
+	}
+	var items []struct {
+		Id string `json:"id"`
+	}
+	if err := json.Unmarshal(fields["data"], &items); err != nil {
+		return nil, errors.Wrap(err, "failed to parse data of list")
+	}
+	var data []json.RawMessage
+	_ = json.Unmarshal(fields["data"], &data)
+
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = item.Id
+	}
+	ownedIds, err := owned(ids)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	filtered := make([]json.RawMessage, 0, len(data))
+	var firstId, lastId *string
+	for i, item := range data {
+		if !ownedIds[ids[i]] {
+			continue
+		}
+		if firstId == nil {
+			firstId = &ids[i]
+		}
+		lastId = &ids[i]
+		filtered = append(filtered, item)
+	}
+	fields["data"], _ = json.Marshal(filtered)
+	if _, ok := fields["first_id"]; ok {
+		fields["first_id"], _ = json.Marshal(firstId)
+	}
+	var hasMore bool
+	_ = json.Unmarshal(fields["has_more"], &hasMore)
+	// keep the cursor of the page, so that clients could still paginate through the upstream
+	if _, ok := fields["last_id"]; ok && !hasMore {
+		fields["last_id"], _ = json.Marshal(lastId)
+	}
+
+	return json.Marshal(fields)
+}
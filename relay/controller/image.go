@@ -300,6 +300,9 @@ func RelayImageHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 	if userQuota < usedQuota {
 		return openai.ErrorWrapper(errors.New("user quota is not enough"), "insufficient_user_quota", http.StatusForbidden)
 	}
+	if bizErr := checkSpendLimits(c, meta.UserId, meta.TokenId, usedQuota); bizErr != nil {
+		return bizErr
+	}
 
 	// do request
 	resp, err := adaptor.DoRequest(c, meta, requestBody)
@@ -316,7 +319,7 @@ func RelayImageHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 			return
 		}
 
-		err := model.PostConsumeTokenQuota(meta.TokenId, usedQuota)
+		err := model.PostConsumeTokenQuota(ctx, meta.TokenId, usedQuota)
 		if err != nil {
 			logger.SysError("error consuming token remain quota: " + err.Error())
 		}
@@ -331,6 +334,7 @@ func RelayImageHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 			model.RecordConsumeLog(ctx, &model.Log{
 				UserId:           meta.UserId,
 				ChannelId:        meta.ChannelId,
+				ChannelKey:       meta.ChannelKey,
 				PromptTokens:     promptTokens,
 				CompletionTokens: completionTokens,
 				ModelName:        imageRequest.Model,
@@ -340,6 +344,7 @@ func RelayImageHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 				ElapsedTime:      helper.CalcElapsedTime(meta.StartTime),
 			})
 			model.UpdateUserUsedQuotaAndRequestCount(meta.UserId, usedQuota)
+			model.RecordSpend(ctx, meta.UserId, meta.TokenId, usedQuota)
 			channelId := c.GetInt(ctxkey.ChannelId)
 			model.UpdateChannelUsedQuota(channelId, usedQuota)
 
@@ -0,0 +1,409 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay"
+	"github.com/songquanpeng/one-api/relay/adaptor"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/channeltype"
+	metalib "github.com/songquanpeng/one-api/relay/meta"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/pricing"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+// https://platform.openai.com/docs/guides/realtime-websocket
+
+var realtimeUpgrader = websocket.Upgrader{
+	// the API is authenticated by tokens, browsers are allowed as CORS does
+	CheckOrigin:  func(*http.Request) bool { return true },
+	Subprotocols: []string{"realtime"},
+}
+
+// realtimeUsage is the usage of a response in the response.done event
+type realtimeUsage struct {
+	TotalTokens       int `json:"total_tokens"`
+	InputTokens       int `json:"input_tokens"`
+	OutputTokens      int `json:"output_tokens"`
+	InputTokenDetails struct {
+		CachedTokens        int `json:"cached_tokens"`
+		TextTokens          int `json:"text_tokens"`
+		AudioTokens         int `json:"audio_tokens"`
+		CachedTokensDetails struct {
+			TextTokens  int `json:"text_tokens"`
+			AudioTokens int `json:"audio_tokens"`
+		} `json:"cached_tokens_details"`
+	} `json:"input_token_details"`
+	OutputTokenDetails struct {
+		TextTokens  int `json:"text_tokens"`
+		AudioTokens int `json:"audio_tokens"`
+	} `json:"output_token_details"`
+}
+
+// toUsage converts the usage to the one of chat completions, which is billed by TokenRatios.WeightedTokens.
+// Cached tokens are reported among the text and audio tokens, so the cached audio tokens are taken out
+// of the audio tokens, they are billed as cached tokens.
+func (u *realtimeUsage) toUsage() *relaymodel.Usage {
+	input, output := u.InputTokenDetails, u.OutputTokenDetails
+	usage := &relaymodel.Usage{
+		PromptTokens:     u.InputTokens,
+		CompletionTokens: u.OutputTokens,
+	}
+	if usage.PromptTokens == 0 {
+		usage.PromptTokens = input.TextTokens + input.AudioTokens
+	}
+	if usage.CompletionTokens == 0 {
+		usage.CompletionTokens = output.TextTokens + output.AudioTokens
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+
+	if input.CachedTokens+input.TextTokens+input.AudioTokens > 0 {
+		usage.PromptTokensDetails = &relaymodel.UsagePromptTokensDetails{
+			CachedTokens: input.CachedTokens,
+			AudioTokens:  max(input.AudioTokens-input.CachedTokensDetails.AudioTokens, 0),
+			TextTokens:   max(input.TextTokens-input.CachedTokensDetails.TextTokens, 0),
+		}
+	}
+	if output.TextTokens+output.AudioTokens > 0 {
+		usage.CompletionTokensDetails = &relaymodel.UsageCompletionTokensDetails{
+			AudioTokens: output.AudioTokens,
+			TextTokens:  output.TextTokens,
+		}
+	}
+	return usage
+}
+
+// realtimeServerEvent holds the fields of server events that one-api cares about
+type realtimeServerEvent struct {
+	Type     string `json:"type"`
+	Response *struct {
+		Id     string         `json:"id"`
+		Status string         `json:"status"`
+		Usage  *realtimeUsage `json:"usage"`
+	} `json:"response"`
+}
+
+// realtimeRatios are the prices of a realtime model, tokenRatios prices the cached, audio and output tokens
+type realtimeRatios struct {
+	modelRatio  float64
+	groupRatio  float64
+	tokenRatios pricing.TokenRatios
+}
+
+// getRealtimeQuota returns the quota of a response by its usage, and the ratios of the price tier it is billed at
+func getRealtimeQuota(usage *relaymodel.Usage, ratios *realtimeRatios) (int64, realtimeRatios) {
+	tierRatios := *ratios
+	tierRatios.modelRatio, tierRatios.tokenRatios = ratios.tokenRatios.ForPromptTokens(ratios.modelRatio, usage.PromptTokens)
+
+	tokens := tierRatios.tokenRatios.WeightedTokens(usage)
+	ratio := tierRatios.modelRatio * tierRatios.groupRatio
+	quota := int64(math.Ceil(tokens * ratio))
+	if ratio != 0 && tokens > 0 && quota <= 0 {
+		quota = 1
+	}
+
+	return quota, tierRatios
+}
+
+// RelayRealtimeHelper relays a WebSocket session of the Realtime API to OpenAI or Azure channels.
+//
+// Frames are pumped in both directions as they are, and every response is billed
+// by the usage in its response.done event. The session is closed once the quota runs out.
+func RelayRealtimeHelper(c *gin.Context) *relaymodel.ErrorWithStatusCode {
+	ctx := c.Request.Context()
+	meta := metalib.GetByContext(c)
+	if !websocket.IsWebSocketUpgrade(c.Request) {
+		return openai.ErrorWrapper(errors.New("the realtime API only accepts WebSocket connections"),
+			"websocket_upgrade_required", http.StatusBadRequest)
+	}
+	if meta.ChannelType != channeltype.OpenAI && meta.ChannelType != channeltype.Azure {
+		return openai.ErrorWrapper(errors.Errorf("channel type %d does not support the realtime API", meta.ChannelType),
+			"unsupported_channel_type", http.StatusBadRequest)
+	}
+	if meta.ActualModelName == "" {
+		return openai.ErrorWrapper(errors.New("model is required"), "invalid_realtime_request", http.StatusBadRequest)
+	}
+	meta.Mode = relaymode.Realtime
+	meta.IsStream = true
+	meta.RequestURLPath = "/v1/realtime?model=" + url.QueryEscape(meta.ActualModelName)
+	if bizErr := checkRemainingQuota(c, meta); bizErr != nil {
+		return bizErr
+	}
+
+	adaptor := relay.GetAdaptor(meta.APIType)
+	if adaptor == nil {
+		return openai.ErrorWrapper(errors.Errorf("invalid api type: %d", meta.APIType), "invalid_api_type", http.StatusBadRequest)
+	}
+	adaptor.Init(meta)
+
+	upstream, bizErr := dialRealtimeUpstream(c, meta, adaptor)
+	if bizErr != nil {
+		return bizErr
+	}
+	client, err := realtimeUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// the upgrader has responded to the client
+		logger.Warnf(ctx, "upgrade realtime connection failed: %+v", err)
+		_ = upstream.Close()
+		return nil
+	}
+
+	channelModelRatio, _ := getChannelRatios(c, meta.ChannelId)
+	var channel *model.Channel
+	if channelModel, ok := c.Get(ctxkey.ChannelModel); ok {
+		channel, _ = channelModel.(*model.Channel)
+	}
+	session := &realtimeSession{
+		ctx:      ctx,
+		meta:     meta,
+		client:   client,
+		upstream: upstream,
+		ratios: &realtimeRatios{
+			modelRatio:  pricing.GetModelRatioWithThreeLayers(meta.ActualModelName, channelModelRatio, adaptor),
+			groupRatio:  c.GetFloat64(ctxkey.ChannelRatio),
+			tokenRatios: getChannelTokenRatios(channel, adaptor, meta.ActualModelName),
+		},
+		tokenQuota:          c.GetInt64(ctxkey.TokenQuota),
+		tokenQuotaUnlimited: c.GetBool(ctxkey.TokenQuotaUnlimited),
+	}
+	session.tokenSpendLimits, _ = c.Value(ctxkey.TokenSpendLimits).(model.SpendLimits)
+	session.run()
+
+	return nil
+}
+
+// dialRealtimeUpstream connects to the realtime endpoint of the channel
+func dialRealtimeUpstream(c *gin.Context, meta *metalib.Meta, adaptor adaptor.Adaptor) (*websocket.Conn, *relaymodel.ErrorWithStatusCode) {
+	fullRequestURL, err := adaptor.GetRequestURL(meta)
+	if err != nil {
+		return nil, openai.ErrorWrapper(err, "get_request_url_failed", http.StatusInternalServerError)
+	}
+	req, err := http.NewRequestWithContext(c.Request.Context(), http.MethodGet, fullRequestURL, nil)
+	if err != nil {
+		return nil, openai.ErrorWrapper(err, "new_request_failed", http.StatusInternalServerError)
+	}
+	if err = adaptor.SetupRequestHeader(c, req, meta); err != nil {
+		return nil, openai.ErrorWrapper(err, "setup_request_header_failed", http.StatusInternalServerError)
+	}
+	// the handshake headers are set by the dialer
+	req.Header.Del("Content-Type")
+	req.Header.Del("Accept")
+
+	dialer := &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: 30 * time.Second,
+	}
+	if config.RelayProxy != "" {
+		proxyURL, err := url.Parse(config.RelayProxy)
+		if err != nil {
+			return nil, openai.ErrorWrapper(err, "invalid_relay_proxy", http.StatusInternalServerError)
+		}
+		dialer.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	wsURL := fullRequestURL
+	if rest, ok := strings.CutPrefix(wsURL, "https://"); ok {
+		wsURL = "wss://" + rest
+	} else if rest, ok := strings.CutPrefix(wsURL, "http://"); ok {
+		wsURL = "ws://" + rest
+	}
+	upstream, resp, err := dialer.DialContext(c.Request.Context(), wsURL, req.Header)
+	if err != nil {
+		if resp != nil && resp.StatusCode != http.StatusSwitchingProtocols {
+			return nil, RelayErrorHandler(resp)
+		}
+		logger.Errorf(c.Request.Context(), "dial realtime upstream failed: %+v", err)
+		return nil, openai.ErrorWrapper(err, "do_request_failed", http.StatusBadGateway)
+	}
+
+	return upstream, nil
+}
+
+// realtimeSession pumps frames between the client and the channel, and bills the responses
+type realtimeSession struct {
+	ctx      context.Context
+	meta     *metalib.Meta
+	client   *websocket.Conn
+	upstream *websocket.Conn
+	ratios   *realtimeRatios
+
+	// tokenQuota is the remaining quota of the token, tracked locally during the session
+	tokenQuota          int64
+	tokenQuotaUnlimited bool
+	tokenSpendLimits    model.SpendLimits
+
+	closeOnce sync.Once
+}
+
+// run blocks until either side closes the session
+func (s *realtimeSession) run() {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		s.pump(s.client, s.upstream, nil)
+	}()
+	go func() {
+		defer wg.Done()
+		s.pump(s.upstream, s.client, s.onServerEvent)
+	}()
+	wg.Wait()
+}
+
+// pump forwards frames from src to dst, onText inspects text frames after they are forwarded
+// and returns false to end the session.
+func (s *realtimeSession) pump(src, dst *websocket.Conn, onText func(data []byte) bool) {
+	defer s.close(websocket.CloseNormalClosure, "")
+	for {
+		messageType, data, err := src.ReadMessage()
+		if err != nil {
+			var closeErr *websocket.CloseError
+			if errors.As(err, &closeErr) {
+				s.close(closeErr.Code, closeErr.Text)
+			} else if !errors.Is(err, net.ErrClosed) {
+				logger.Debugf(s.ctx, "realtime session ended: %s", err.Error())
+			}
+			return
+		}
+		if err = dst.WriteMessage(messageType, data); err != nil {
+			logger.Debugf(s.ctx, "forward realtime frame failed: %s", err.Error())
+			return
+		}
+		if messageType == websocket.TextMessage && onText != nil && !onText(data) {
+			return
+		}
+	}
+}
+
+// close closes both connections, the peers are told the reason first
+func (s *realtimeSession) close(code int, text string) {
+	s.closeOnce.Do(func() {
+		if code == websocket.CloseNoStatusReceived || code == websocket.CloseAbnormalClosure {
+			// these codes must not be sent
+			code = websocket.CloseNormalClosure
+		}
+		message := websocket.FormatCloseMessage(code, text)
+		deadline := time.Now().Add(time.Second)
+		_ = s.client.WriteControl(websocket.CloseMessage, message, deadline)
+		_ = s.upstream.WriteControl(websocket.CloseMessage, message, deadline)
+		_ = s.client.Close()
+		_ = s.upstream.Close()
+	})
+}
+
+// onServerEvent bills finished responses, and ends the session when the quota runs out
+func (s *realtimeSession) onServerEvent(data []byte) bool {
+	// only response.done events are decoded, the others are mostly audio deltas
+	if !strings.Contains(string(data), `"response.done"`) {
+		return true
+	}
+	event := &realtimeServerEvent{}
+	if err := json.Unmarshal(data, event); err != nil {
+		logger.Warnf(s.ctx, "parse realtime server event failed: %+v", err)
+		return true
+	}
+	if event.Type != "response.done" || event.Response == nil || event.Response.Usage == nil {
+		return true
+	}
+
+	code, message := s.bill(event.Response.Id, event.Response.Usage)
+	if code == "" {
+		return true
+	}
+
+	// the client is written by this goroutine only
+	_ = s.client.WriteJSON(gin.H{
+		"type": "error",
+		"error": gin.H{
+			"type":    code,
+			"code":    code,
+			"message": message + ", the session is closed",
+		},
+	})
+	s.close(websocket.ClosePolicyViolation, strings.ReplaceAll(code, "_", " "))
+	return false
+}
+
+// bill charges a response, it returns the code and the message of the error ending the session
+// if the user or the token has no quota left or has spent a spend limit
+func (s *realtimeSession) bill(responseId string, realtimeUsage *realtimeUsage) (code string, message string) {
+	meta := s.meta
+	usage := realtimeUsage.toUsage()
+	quota, ratios := getRealtimeQuota(usage, s.ratios)
+	if err := model.PostConsumeTokenQuota(s.ctx, meta.TokenId, quota); err != nil {
+		logger.Errorf(s.ctx, "error consuming token remain quota: %+v", err)
+	}
+	if err := model.CacheUpdateUserQuota(s.ctx, meta.UserId); err != nil {
+		logger.Errorf(s.ctx, "error update user quota cache: %+v", err)
+	}
+	model.RecordConsumeLog(s.ctx, &model.Log{
+		UserId:           meta.UserId,
+		ChannelId:        meta.ChannelId,
+		ChannelKey:       meta.ChannelKey,
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		ModelName:        meta.ActualModelName,
+		TokenName:        meta.TokenName,
+		Quota:            int(quota),
+		Content: fmt.Sprintf("realtime response %s, text input %d, audio input %d, cached input %d, text output %d, audio output %d, "+
+			"model rate %.2f, group rate %.2f, completion rate %.2f, cached input rate %.2f, audio input rate %.2f, audio output rate %.2f",
+			responseId, realtimeUsage.InputTokenDetails.TextTokens, realtimeUsage.InputTokenDetails.AudioTokens,
+			realtimeUsage.InputTokenDetails.CachedTokens,
+			realtimeUsage.OutputTokenDetails.TextTokens, realtimeUsage.OutputTokenDetails.AudioTokens,
+			ratios.modelRatio, ratios.groupRatio, ratios.tokenRatios.Completion, ratios.tokenRatios.CachedInput,
+			ratios.tokenRatios.AudioInput, ratios.tokenRatios.AudioOutput),
+		IsStream:    true,
+		ElapsedTime: helper.CalcElapsedTime(meta.StartTime),
+	})
+	if quota > 0 {
+		model.UpdateUserUsedQuotaAndRequestCount(meta.UserId, quota)
+		model.UpdateChannelUsedQuota(meta.ChannelId, quota)
+		model.RecordSpend(s.ctx, meta.UserId, meta.TokenId, quota)
+	}
+
+	if !s.tokenQuotaUnlimited {
+		s.tokenQuota -= quota
+		if s.tokenQuota <= 0 {
+			return "insufficient_quota", "quota is not enough"
+		}
+	}
+	userQuota, err := model.CacheGetUserQuota(s.ctx, meta.UserId)
+	if err != nil {
+		logger.Errorf(s.ctx, "get user quota failed: %+v", err)
+	} else if userQuota <= 0 {
+		return "insufficient_quota", "quota is not enough"
+	}
+
+	userSpendLimits, err := model.CacheGetUserSpendLimits(meta.UserId)
+	if err != nil {
+		logger.Errorf(s.ctx, "get user spend limits failed: %+v", err)
+		return "", ""
+	}
+	err = model.CheckSpendLimits(meta.TokenId, s.tokenSpendLimits, meta.UserId, userSpendLimits, 0)
+	switch {
+	case errors.Is(err, model.ErrSpendLimitExceeded):
+		return "spend_limit_exceeded", err.Error()
+	case err != nil:
+		logger.Errorf(s.ctx, "check spend limits failed: %+v", err)
+	}
+	return "", ""
+}
@@ -0,0 +1,171 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/adaptor"
+	"github.com/songquanpeng/one-api/relay/channeltype"
+	"github.com/songquanpeng/one-api/relay/pricing"
+)
+
+func TestGetRealtimeQuota(t *testing.T) {
+	ratios := &realtimeRatios{
+		modelRatio:  2.5,
+		groupRatio:  1,
+		tokenRatios: pricing.TokenRatios{Completion: 4, CachedInput: 0.5, AudioInput: 8, AudioOutput: 16},
+	}
+
+	usage := &realtimeUsage{InputTokens: 110, OutputTokens: 60}
+	usage.InputTokenDetails.TextTokens = 100
+	usage.InputTokenDetails.AudioTokens = 10
+	usage.OutputTokenDetails.TextTokens = 10
+	usage.OutputTokenDetails.AudioTokens = 50
+	// (100 + 10*8 + 10*4 + 50*16) * 2.5
+	quota, _ := getRealtimeQuota(usage.toUsage(), ratios)
+	assert.Equal(t, int64(2550), quota)
+
+	// cached tokens are taken out of the text and audio tokens
+	usage.InputTokenDetails.CachedTokens = 60
+	usage.InputTokenDetails.CachedTokensDetails.TextTokens = 50
+	usage.InputTokenDetails.CachedTokensDetails.AudioTokens = 10
+	// (50 + 60*0.5 + 10*4 + 50*16) * 2.5
+	quota, _ = getRealtimeQuota(usage.toUsage(), ratios)
+	assert.Equal(t, int64(2300), quota)
+
+	// tokens without details are text tokens
+	quota, _ = getRealtimeQuota((&realtimeUsage{InputTokens: 100, OutputTokens: 10}).toUsage(), ratios)
+	assert.Equal(t, int64((100+10*4)*2.5), quota)
+
+	// long prompts are billed at their price tier
+	ratios.tokenRatios.Tiers = []adaptor.PriceTier{{InputTokenThreshold: 50, Ratio: 5}}
+	quota, tierRatios := getRealtimeQuota((&realtimeUsage{InputTokens: 100, OutputTokens: 10}).toUsage(), ratios)
+	assert.Equal(t, int64((100+10*4)*5), quota)
+	assert.Equal(t, 5.0, tierRatios.modelRatio)
+
+	ratios.groupRatio = 0
+	quota, _ = getRealtimeQuota(usage.toUsage(), ratios)
+	assert.Equal(t, int64(0), quota)
+}
+
+// fakeRealtimeUpstream answers every response.create with a response.done of usage
+func fakeRealtimeUpstream(t *testing.T) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/realtime", r.URL.Path)
+		assert.Equal(t, "gpt-4o-realtime-preview", r.URL.Query().Get("model"))
+		assert.Equal(t, "Bearer sk-channel", r.Header.Get("Authorization"))
+		assert.Equal(t, "realtime=v1", r.Header.Get("OpenAI-Beta"))
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		require.NoError(t, err)
+		defer conn.Close()
+		require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"session.created"}`)))
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if !strings.Contains(string(data), "response.create") {
+				continue
+			}
+			done := `{"type":"response.done","response":{"id":"resp_1","status":"completed","usage":{` +
+				`"total_tokens":160,"input_tokens":100,"output_tokens":60,` +
+				`"input_token_details":{"text_tokens":100,"audio_tokens":0},` +
+				`"output_token_details":{"text_tokens":10,"audio_tokens":50}}}}`
+			if err = conn.WriteMessage(websocket.TextMessage, []byte(done)); err != nil {
+				return
+			}
+		}
+	}))
+}
+
+func TestRelayRealtimeHelper(t *testing.T) {
+	testDB, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, testDB.AutoMigrate(&model.User{}, &model.Token{}, &model.Channel{}, &model.Log{}, &model.LedgerEntry{}))
+	originalDB, originalLogDB, originalRedisEnabled := model.DB, model.LOG_DB, common.RedisEnabled
+	model.DB, model.LOG_DB, common.RedisEnabled = testDB, testDB, false
+	t.Cleanup(func() { model.DB, model.LOG_DB, common.RedisEnabled = originalDB, originalLogDB, originalRedisEnabled })
+
+	require.NoError(t, testDB.Create(&model.User{Id: 1, Username: "realtime", Quota: 1000000, Status: model.UserStatusEnabled}).Error)
+	// a response costs (100 + 10*4 + 50*8*2) * 2.5 = 2350, so the token runs out after the second one
+	token := &model.Token{Id: 1, UserId: 1, Key: "realtime", Name: "realtime", RemainQuota: 4000}
+	require.NoError(t, testDB.Create(token).Error)
+	channel := &model.Channel{Id: 1, Type: channeltype.OpenAI, Key: "sk-channel"}
+	require.NoError(t, testDB.Create(channel).Error)
+
+	upstream := fakeRealtimeUpstream(t)
+	defer upstream.Close()
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET("/v1/realtime", func(c *gin.Context) {
+		c.Set(ctxkey.Id, 1)
+		c.Set(ctxkey.TokenId, token.Id)
+		c.Set(ctxkey.TokenName, token.Name)
+		c.Set(ctxkey.TokenQuota, token.RemainQuota)
+		c.Set(ctxkey.Channel, channeltype.OpenAI)
+		c.Set(ctxkey.ChannelId, channel.Id)
+		c.Set(ctxkey.ChannelModel, channel)
+		c.Set(ctxkey.ChannelRatio, 1.0)
+		c.Set(ctxkey.BaseURL, upstream.URL)
+		c.Set(ctxkey.RequestModel, c.Query("model"))
+		c.Request.Header.Set("Authorization", "Bearer sk-channel")
+		if bizErr := RelayRealtimeHelper(c); bizErr != nil {
+			c.JSON(bizErr.StatusCode, bizErr.Error)
+		}
+	})
+	server := httptest.NewServer(engine)
+	defer server.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/v1/realtime?model=gpt-4o-realtime-preview"
+	client, resp, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Sec-WebSocket-Protocol": {"realtime"}})
+	require.NoError(t, err)
+	defer client.Close()
+	assert.Equal(t, "realtime", resp.Header.Get("Sec-WebSocket-Protocol"))
+	require.NoError(t, client.SetReadDeadline(time.Now().Add(10*time.Second)))
+
+	readEvent := func() string {
+		_, data, err := client.ReadMessage()
+		require.NoError(t, err)
+		return string(data)
+	}
+	assert.Contains(t, readEvent(), "session.created")
+
+	require.NoError(t, client.WriteMessage(websocket.TextMessage, []byte(`{"type":"response.create"}`)))
+	assert.Contains(t, readEvent(), "response.done")
+	require.NoError(t, client.WriteMessage(websocket.TextMessage, []byte(`{"type":"response.create"}`)))
+	assert.Contains(t, readEvent(), "response.done")
+	assert.Contains(t, readEvent(), "insufficient_quota")
+
+	_, _, err = client.ReadMessage()
+	var closeErr *websocket.CloseError
+	require.ErrorAs(t, err, &closeErr)
+	assert.Equal(t, websocket.ClosePolicyViolation, closeErr.Code)
+
+	var logs []*model.Log
+	require.NoError(t, testDB.Order("id").Find(&logs).Error)
+	require.Len(t, logs, 2)
+	for _, log := range logs {
+		assert.Equal(t, 2350, log.Quota)
+		assert.Equal(t, 100, log.PromptTokens)
+		assert.Equal(t, 60, log.CompletionTokens)
+		assert.Equal(t, "gpt-4o-realtime-preview", log.ModelName)
+	}
+	require.NoError(t, testDB.First(token, token.Id).Error)
+	assert.Equal(t, int64(4000-2*2350), token.RemainQuota)
+}
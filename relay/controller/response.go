@@ -19,13 +19,24 @@ import (
 	"github.com/songquanpeng/one-api/relay/adaptor"
 	"github.com/songquanpeng/one-api/relay/adaptor/openai"
 	"github.com/songquanpeng/one-api/relay/billing"
+	"github.com/songquanpeng/one-api/relay/channeltype"
+	"github.com/songquanpeng/one-api/relay/hedge"
 	metalib "github.com/songquanpeng/one-api/relay/meta"
 	relaymodel "github.com/songquanpeng/one-api/relay/model"
 	"github.com/songquanpeng/one-api/relay/pricing"
+	"github.com/songquanpeng/one-api/relay/relaymode"
 )
 
-// RelayResponseAPIHelper handles Response API requests with direct pass-through
+// RelayResponseAPIHelper handles Response API requests.
+//
+// OpenAI channels receive the request as is, other channels receive the request
+// converted to GeneralOpenAIRequest, and their chat completion output
+// is converted back to the Response API format.
 func RelayResponseAPIHelper(c *gin.Context) *relaymodel.ErrorWithStatusCode {
+	if c.Param("response_id") != "" {
+		return relayResponseAPIObjectHelper(c)
+	}
+
 	ctx := c.Request.Context()
 	meta := metalib.GetByContext(c)
 
@@ -37,17 +48,34 @@ func RelayResponseAPIHelper(c *gin.Context) *relaymodel.ErrorWithStatusCode {
 	}
 	meta.IsStream = responseAPIRequest.Stream != nil && *responseAPIRequest.Stream
 
+	// inline files uploaded through the Files API
+	if err = resolveResponseAPIFileReferences(ctx, meta.UserId, responseAPIRequest); err != nil {
+		return openai.ErrorWrapper(err, "invalid_file_reference", http.StatusBadRequest)
+	}
+
 	if reqBody, ok := c.Get(ctxkey.KeyRequestBody); ok {
 		logger.Debugf(c.Request.Context(), "get response api request: %s\n", string(reqBody.([]byte)))
 	}
 
-	// Check if channel supports Response API
-	if meta.ChannelType != 1 { // Only OpenAI channels support Response API for now
-		return openai.ErrorWrapper(errors.New("Response API is only supported for OpenAI channels"), "unsupported_channel", http.StatusBadRequest)
+	// meta is reused across retries, the channel decides which protocol is spoken upstream.
+	// Other channels are served by chat completions, and their output is converted back.
+	isNative := meta.ChannelType == channeltype.OpenAI
+	var textRequest *relaymodel.GeneralOpenAIRequest
+	if isNative {
+		meta.Mode = relaymode.ResponseAPI
+		meta.RequestURLPath = c.Request.URL.String()
+	} else {
+		if textRequest, err = openai.ConvertResponseAPIRequest(responseAPIRequest); err != nil {
+			return openai.ErrorWrapper(err, "convert_response_api_request_failed", http.StatusBadRequest)
+		}
+		textRequest.Model = meta.ActualModelName
+		meta.Mode = relaymode.ChatCompletions
+		meta.RequestURLPath = "/v1/chat/completions"
 	}
 
 	// get channel model ratio
-	channelModelRatio, channelCompletionRatio := getChannelRatios(c, meta.ChannelId)
+	channelModelRatio, _ := getChannelRatios(c, meta.ChannelId)
+	tokenRatios := getTokenRatios(c, meta, responseAPIRequest.Model)
 
 	// get model ratio using three-layer pricing system
 	pricingAdaptor := relay.GetAdaptor(meta.ChannelType)
@@ -65,14 +93,28 @@ func RelayResponseAPIHelper(c *gin.Context) *relaymodel.ErrorWithStatusCode {
 		return bizErr
 	}
 
+	// the pre-consumed quota is returned unless the request is billed, e.g. when it fails
+	// or when it loses to the other attempt of a hedged request, which is cancelled at any step
+	billed := false
+	defer func() {
+		if !billed {
+			billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta.TokenId)
+		}
+	}()
+
 	adaptor := relay.GetAdaptor(meta.APIType)
 	if adaptor == nil {
 		return openai.ErrorWrapper(errors.New("invalid api type"), "invalid_api_type", http.StatusBadRequest)
 	}
 	adaptor.Init(meta)
 
-	// get request body - for Response API, we pass through directly without conversion
-	requestBody, err := getResponseAPIRequestBody(c, meta, responseAPIRequest, adaptor)
+	// get request body - native requests are passed through without conversion
+	var requestBody io.Reader
+	if isNative {
+		requestBody, err = getResponseAPIRequestBody(c, meta, responseAPIRequest, adaptor)
+	} else {
+		requestBody, err = getConvertedRequestBody(c, meta, textRequest, adaptor)
+	}
 	if err != nil {
 		return openai.ErrorWrapper(err, "convert_request_failed", http.StatusInternalServerError)
 	}
@@ -90,27 +132,51 @@ func RelayResponseAPIHelper(c *gin.Context) *relaymodel.ErrorWithStatusCode {
 
 	// Check for HTTP errors
 	if resp.StatusCode != http.StatusOK {
-		billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, c.GetInt(ctxkey.TokenId))
 		return RelayErrorHandler(resp)
 	}
 
 	// do response
-	usage, respErr := adaptor.DoResponse(c, resp, meta)
+	var (
+		usage   *relaymodel.Usage
+		respErr *relaymodel.ErrorWithStatusCode
+	)
+	if isNative {
+		usage, respErr = adaptor.DoResponse(c, resp, meta)
+	} else {
+		usage, respErr = doConvertedResponseAPIResponse(c, resp, meta, adaptor, responseAPIRequest)
+	}
 	if respErr != nil {
 		logger.Errorf(ctx, "DoResponse failed: %+v", *respErr)
-		billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, c.GetInt(ctxkey.TokenId))
 		return respErr
 	}
 
+	// only the attempt of a hedged request which answered first is billed
+	if !hedge.Claim(c) {
+		return openai.ErrorWrapper(hedge.ErrLost, "hedge_lost", http.StatusServiceUnavailable)
+	}
+	billed = true
+	recordUsageTokens(c, usage)
+
 	// post-consume quota
 	quotaId := c.GetInt(ctxkey.Id)
 	requestId := c.GetString(ctxkey.RequestId)
+	responseId := c.GetString(ctxkey.ResponseAPIId)
+	isStored := responseAPIRequest.Store == nil || *responseAPIRequest.Store
 
 	go func() {
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 
-		quota := postConsumeResponseAPIQuota(ctx, usage, meta, responseAPIRequest, ratio, preConsumedQuota, modelRatio, groupRatio, channelCompletionRatio)
+		// remember the channel of stored responses for follow-up calls,
+		// converted responses are not stored upstream
+		if isNative && responseId != "" && isStored {
+			record := model.NewResponseRecord(responseId, meta.UserId, meta.TokenId, meta.ChannelId, meta.OriginModelName)
+			if err := record.Insert(); err != nil {
+				logger.Errorf(ctx, "insert response record failed: %+v", err)
+			}
+		}
+
+		quota := postConsumeResponseAPIQuota(ctx, usage, meta, responseAPIRequest, ratio, preConsumedQuota, modelRatio, groupRatio, tokenRatios)
 
 		// also update user request cost
 		if quota != 0 {
@@ -128,6 +194,48 @@ func RelayResponseAPIHelper(c *gin.Context) *relaymodel.ErrorWithStatusCode {
 	return nil
 }
 
+// relayResponseAPIObjectHelper proxies retrieve, delete and cancel calls of a stored response
+// to the channel that produced it, which has been selected by the distributor.
+// These calls generate nothing, so they are not billed.
+func relayResponseAPIObjectHelper(c *gin.Context) *relaymodel.ErrorWithStatusCode {
+	ctx := c.Request.Context()
+	meta := metalib.GetByContext(c)
+	meta.Mode = relaymode.ResponseAPI
+	meta.RequestURLPath = c.Request.URL.String()
+	meta.IsStream = c.Query("stream") == "true"
+
+	adaptor := relay.GetAdaptor(meta.APIType)
+	if adaptor == nil {
+		return openai.ErrorWrapper(errors.Errorf("invalid api type: %d", meta.APIType), "invalid_api_type", http.StatusBadRequest)
+	}
+	adaptor.Init(meta)
+
+	resp, err := adaptor.DoRequest(c, meta, c.Request.Body)
+	if err != nil {
+		logger.Errorf(ctx, "DoRequest failed: %s", err.Error())
+		return openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
+	}
+	if isErrorHappened(meta, resp) {
+		return RelayErrorHandler(resp)
+	}
+
+	if _, respErr := adaptor.DoResponse(c, resp, meta); respErr != nil {
+		logger.Errorf(ctx, "DoResponse failed: %+v", *respErr)
+		return respErr
+	}
+
+	if c.Request.Method == http.MethodDelete {
+		responseId := c.Param("response_id")
+		go func() {
+			if err := model.DeleteResponseRecord(responseId); err != nil {
+				logger.Errorf(context.Background(), "delete response record failed: %+v", err)
+			}
+		}()
+	}
+
+	return nil
+}
+
 // getChannelRatios gets channel model and completion ratios from unified ModelConfigs
 func getChannelRatios(c *gin.Context, channelId int) (map[string]float64, map[string]float64) {
 	channel := c.MustGet(ctxkey.ChannelModel).(*model.Channel)
@@ -228,6 +336,10 @@ func preConsumeResponseAPIQuota(c *gin.Context, responseAPIRequest *openai.Respo
 		baseQuota = 1
 	}
 
+	if bizErr := checkSpendLimits(c, meta.UserId, meta.TokenId, baseQuota); bizErr != nil {
+		return baseQuota, bizErr
+	}
+
 	tokenQuota := c.GetInt64(ctxkey.TokenQuota)
 	tokenQuotaUnlimited := c.GetBool(ctxkey.TokenQuotaUnlimited)
 	userQuota, err := model.CacheGetUserQuota(c.Request.Context(), meta.UserId)
@@ -242,7 +354,7 @@ func preConsumeResponseAPIQuota(c *gin.Context, responseAPIRequest *openai.Respo
 		return baseQuota, openai.ErrorWrapper(errors.New("token quota is not enough"), "insufficient_token_quota", http.StatusForbidden)
 	}
 
-	err = model.PreConsumeTokenQuota(c.GetInt(ctxkey.TokenId), baseQuota)
+	err = model.PreConsumeTokenQuota(c.Request.Context(), c.GetInt(ctxkey.TokenId), baseQuota)
 	if err != nil {
 		return baseQuota, openai.ErrorWrapper(err, "pre_consume_token_quota_failed", http.StatusForbidden)
 	}
@@ -260,31 +372,31 @@ func postConsumeResponseAPIQuota(ctx context.Context,
 	preConsumedQuota int64,
 	modelRatio float64,
 	groupRatio float64,
-	channelCompletionRatio map[string]float64) (quota int64) {
+	tokenRatios pricing.TokenRatios) (quota int64) {
 
 	if usage == nil {
 		logger.Error(ctx, "usage is nil, which is unexpected")
 		return
 	}
 
-	// Use three-layer pricing system for completion ratio
-	pricingAdaptor := relay.GetAdaptor(meta.ChannelType)
-	completionRatio := pricing.GetCompletionRatioWithThreeLayers(responseAPIRequest.Model, channelCompletionRatio, pricingAdaptor)
+	// requests with long prompts may be billed at a price tier of the model
+	modelRatio, tokenRatios = tokenRatios.ForPromptTokens(modelRatio, usage.PromptTokens)
+	ratio = modelRatio * groupRatio
 
 	// Calculate quota using the same formula as ChatCompletion
 	promptTokens := usage.PromptTokens
 	completionTokens := usage.CompletionTokens
-	quota = int64((float64(promptTokens)+float64(completionTokens)*completionRatio)*ratio) + usage.ToolsCost
+	quota = int64(tokenRatios.WeightedTokens(usage)*ratio) + usage.ToolsCost
 	if ratio != 0 && quota <= 0 {
 		quota = 1
 	}
 
 	// Use centralized detailed billing function to follow DRY principle
 	quotaDelta := quota - preConsumedQuota
-	billing.PostConsumeQuotaDetailed(ctx, meta.TokenId, quotaDelta, quota, meta.UserId, meta.ChannelId,
+	billing.PostConsumeQuotaDetailed(ctx, meta.TokenId, quotaDelta, quota, meta.UserId, meta.ChannelId, meta.ChannelKey,
 		promptTokens, completionTokens, modelRatio, groupRatio, responseAPIRequest.Model, meta.TokenName,
 		meta.IsStream, meta.StartTime, false, // Response API doesn't have system prompt reset concept
-		completionRatio, usage.ToolsCost)
+		tokenRatios.Completion, usage.ToolsCost, cachedTokens(usage), cacheWriteTokens(usage))
 
 	return quota
 }
@@ -299,3 +411,59 @@ func getResponseAPIRequestBody(c *gin.Context, meta *metalib.Meta, responseAPIRe
 	}
 	return bytes.NewReader(jsonData), nil
 }
+
+// doConvertedResponseAPIResponse lets the adaptor render its OpenAI-format response,
+// and converts the output to the Response API format on the fly.
+func doConvertedResponseAPIResponse(c *gin.Context, resp *http.Response, meta *metalib.Meta,
+	adaptor adaptor.Adaptor, responseAPIRequest *openai.ResponseAPIRequest) (*relaymodel.Usage, *relaymodel.ErrorWithStatusCode) {
+	ctx := c.Request.Context()
+	client := c.Writer
+
+	if !meta.IsStream {
+		capture, restore := captureOpenAIOutput(c, false, nil)
+		usage, respErr := adaptor.DoResponse(c, resp, meta)
+		restore()
+		if respErr != nil {
+			return nil, respErr
+		}
+
+		textResponse := new(openai.TextResponse)
+		if err := json.Unmarshal(capture.Body(), textResponse); err != nil {
+			return nil, openai.ErrorWrapper(err, "unmarshal_converted_response_failed", http.StatusInternalServerError)
+		}
+		textResponse.Model = meta.OriginModelName
+		if usage != nil {
+			textResponse.Usage = *usage
+		}
+
+		c.JSON(capture.Status(), openai.ConvertTextResponseToResponseAPI(textResponse, responseAPIRequest))
+		return usage, nil
+	}
+
+	converter := openai.NewResponseAPIStreamConverter(meta.OriginModelName, responseAPIRequest)
+	render := func(events []map[string]any) {
+		for _, event := range events {
+			if err := openai.RenderResponseAPIStreamEvent(client, event); err != nil {
+				logger.Errorf(ctx, "render response api stream event failed: %+v", err)
+			}
+		}
+	}
+
+	_, restore := captureOpenAIOutput(c, true, func(data string) {
+		chunk := new(openai.ChatCompletionsStreamResponse)
+		if err := json.Unmarshal([]byte(data), chunk); err != nil {
+			logger.Warnf(ctx, "unmarshal converted stream chunk %q failed: %+v", data, err)
+			return
+		}
+		render(converter.Convert(chunk))
+	})
+	usage, respErr := adaptor.DoResponse(c, resp, meta)
+	restore()
+	if respErr != nil {
+		return nil, respErr
+	}
+
+	render(converter.Finish(usage))
+
+	return usage, nil
+}
@@ -0,0 +1,381 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/render"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/hedge"
+	metalib "github.com/songquanpeng/one-api/relay/meta"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/pricing"
+	"github.com/songquanpeng/one-api/relay/responsecache"
+)
+
+// responseCacheHeader tells clients whether the response is served from the response cache
+const responseCacheHeader = "X-Oneapi-Cache"
+
+// getResponseCacheKey returns the response cache key of textRequest,
+// or empty if the cache is not enabled for the token or the request is not cacheable.
+// It must be called before textRequest is modified for the channel.
+func getResponseCacheKey(c *gin.Context, meta *metalib.Meta, textRequest *relaymodel.GeneralOpenAIRequest) string {
+	mode := responsecache.GetMode(c.GetString(ctxkey.ResponseCacheMode), meta.Group)
+	if !responsecache.IsCacheable(mode, meta.Mode, textRequest) {
+		return ""
+	}
+
+	key, err := responsecache.Key(meta.UserId, meta.Mode, mode, textRequest)
+	if err != nil {
+		logger.Warnf(c.Request.Context(), "get response cache key failed: %+v", err)
+		return ""
+	}
+	return key
+}
+
+// serveCachedResponse answers the request from the response cache,
+// and charges the discounted quota of the cached usage. It returns false on cache misses.
+func serveCachedResponse(c *gin.Context, meta *metalib.Meta, cacheKey string,
+	modelRatio, groupRatio float64, tokenRatios pricing.TokenRatios) (bool, *relaymodel.ErrorWithStatusCode) {
+	ctx := c.Request.Context()
+	entry, err := responsecache.Get(ctx, cacheKey)
+	if err != nil {
+		logger.Warnf(ctx, "get cached response failed: %+v", err)
+		return false, nil
+	}
+	if entry == nil {
+		c.Header(responseCacheHeader, "miss")
+		return false, nil
+	}
+	if bizErr := checkRemainingQuota(c, meta); bizErr != nil {
+		return false, bizErr
+	}
+
+	logger.Infof(ctx, "serve cached response of channel #%d created at %d", entry.ChannelId, entry.CreatedAt)
+	c.Set(ctxkey.ResponseCacheHit, true)
+	c.Header(responseCacheHeader, "hit")
+	if meta.IsStream {
+		if err = replayCachedStream(c, entry.Body); err != nil {
+			logger.Errorf(ctx, "replay cached response failed: %+v", err)
+		}
+	} else {
+		c.Data(http.StatusOK, "application/json", entry.Body)
+	}
+	if !hedge.Claim(c) {
+		return true, openai.ErrorWrapper(hedge.ErrLost, "hedge_lost", http.StatusServiceUnavailable)
+	}
+
+	quotaId := c.GetInt(ctxkey.Id)
+	requestId := c.GetString(ctxkey.RequestId)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		quota := postConsumeCachedResponseQuota(ctx, meta, &entry.Usage, modelRatio, groupRatio, tokenRatios)
+		if quota != 0 {
+			docu := model.NewUserRequestCost(quotaId, requestId, quota)
+			if err := docu.Insert(); err != nil {
+				logger.Errorf(ctx, "insert user request cost failed: %+v", err)
+			}
+		}
+	}()
+
+	return true, nil
+}
+
+// postConsumeCachedResponseQuota charges ResponseCacheQuotaRatio of the quota of the cached usage.
+// No channel is used, so the channel is neither charged nor recorded in the log.
+func postConsumeCachedResponseQuota(ctx context.Context, meta *metalib.Meta, usage *relaymodel.Usage,
+	modelRatio, groupRatio float64, tokenRatios pricing.TokenRatios) (quota int64) {
+	modelRatio, tokenRatios = tokenRatios.ForPromptTokens(modelRatio, usage.PromptTokens)
+	ratio := modelRatio * groupRatio * config.ResponseCacheQuotaRatio
+	quota = int64(math.Ceil(tokenRatios.WeightedTokens(usage) * ratio))
+	if ratio != 0 && quota <= 0 {
+		quota = 1
+	}
+
+	if err := model.PostConsumeTokenQuota(ctx, meta.TokenId, quota); err != nil {
+		logger.Errorf(ctx, "error consuming token remain quota: %+v", err)
+	}
+	if err := model.CacheUpdateUserQuota(ctx, meta.UserId); err != nil {
+		logger.Errorf(ctx, "error update user quota cache: %+v", err)
+	}
+	model.RecordConsumeLog(ctx, &model.Log{
+		UserId:           meta.UserId,
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		ModelName:        meta.ActualModelName,
+		TokenName:        meta.TokenName,
+		Quota:            int(quota),
+		Content: fmt.Sprintf("model rate %.2f, group rate %.2f, completion rate %.2f, cache rate %.2f",
+			modelRatio, groupRatio, tokenRatios.Completion, config.ResponseCacheQuotaRatio),
+		IsStream:    meta.IsStream,
+		ElapsedTime: helper.CalcElapsedTime(meta.StartTime),
+		CacheHit:    true,
+	})
+	if quota > 0 {
+		model.UpdateUserUsedQuotaAndRequestCount(meta.UserId, quota)
+		model.RecordSpend(ctx, meta.UserId, meta.TokenId, quota)
+	}
+
+	return quota
+}
+
+// responseCacheRecorder copies the output of adaptors to the client while keeping it for the response cache
+type responseCacheRecorder struct {
+	gin.ResponseWriter
+	buf      bytes.Buffer
+	limit    int
+	overflow bool
+}
+
+// recordResponse installs the recorder on c, the returned function restores the original writer
+func recordResponse(c *gin.Context, isStream bool) (*responseCacheRecorder, func()) {
+	limit := config.ResponseCacheMaxEntrySizeKB * 1024
+	if isStream {
+		// every delta is wrapped in a chunk, the assembled response is much smaller
+		limit *= 8
+	}
+	original := c.Writer
+	recorder := &responseCacheRecorder{ResponseWriter: original, limit: limit}
+	c.Writer = recorder
+
+	return recorder, func() { c.Writer = original }
+}
+
+// Body returns the recorded output, or nil if it is not a complete successful response
+func (w *responseCacheRecorder) Body() []byte {
+	if w.overflow || w.Status() != http.StatusOK {
+		return nil
+	}
+	return w.buf.Bytes()
+}
+
+func (w *responseCacheRecorder) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *responseCacheRecorder) Write(data []byte) (int, error) {
+	if !w.overflow {
+		if w.buf.Len()+len(data) > w.limit {
+			w.overflow = true
+			w.buf.Reset()
+		} else {
+			w.buf.Write(data)
+		}
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// cacheResponse saves the output of a succeeded request into the response cache
+func cacheResponse(ctx context.Context, meta *metalib.Meta, cacheKey string, body []byte, usage *relaymodel.Usage) {
+	if body == nil || usage == nil {
+		return
+	}
+
+	if meta.IsStream {
+		var err error
+		if body, err = assembleStreamResponse(body, usage); err != nil {
+			logger.Debugf(ctx, "skip caching stream response: %s", err.Error())
+			return
+		}
+	} else if !json.Valid(body) {
+		return
+	}
+
+	entry := &responsecache.Entry{
+		Body:      body,
+		Usage:     *usage,
+		ChannelId: meta.ChannelId,
+		CreatedAt: helper.GetTimestamp(),
+	}
+	if err := responsecache.Set(ctx, cacheKey, entry); err != nil {
+		logger.Warnf(ctx, "cache response failed: %+v", err)
+	}
+}
+
+// assembleStreamResponse converts the SSE of chat completions to the non-stream response
+func assembleStreamResponse(sse []byte, usage *relaymodel.Usage) ([]byte, error) {
+	type assembled struct {
+		role             string
+		content          strings.Builder
+		reasoningContent strings.Builder
+		toolCalls        map[int]*relaymodel.Tool
+		toolArguments    map[int]*strings.Builder
+		finishReason     string
+	}
+	response := &openai.TextResponse{Object: "chat.completion"}
+	choices := map[int]*assembled{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(sse))
+	scanner.Buffer(make([]byte, 64*1024), len(sse)+1)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		data = strings.TrimSpace(data)
+		if !ok || data == "" || data == "[DONE]" {
+			continue
+		}
+		chunk := &openai.ChatCompletionsStreamResponse{}
+		if err := json.Unmarshal([]byte(data), chunk); err != nil {
+			return nil, errors.Wrap(err, "failed to parse stream chunk")
+		}
+		response.Id, response.Model, response.Created = chunk.Id, chunk.Model, chunk.Created
+
+		for _, delta := range chunk.Choices {
+			choice, ok := choices[delta.Index]
+			if !ok {
+				choice = &assembled{
+					toolCalls:     map[int]*relaymodel.Tool{},
+					toolArguments: map[int]*strings.Builder{},
+				}
+				choices[delta.Index] = choice
+			}
+			if delta.Delta.Role != "" {
+				choice.role = delta.Delta.Role
+			}
+			switch content := delta.Delta.Content.(type) {
+			case nil:
+			case string:
+				choice.content.WriteString(content)
+			default:
+				return nil, errors.Errorf("unsupported content of type %T", content)
+			}
+			if delta.Delta.ReasoningContent != nil {
+				choice.reasoningContent.WriteString(*delta.Delta.ReasoningContent)
+			}
+			for i, toolDelta := range delta.Delta.ToolCalls {
+				index := i
+				if toolDelta.Index != nil {
+					index = *toolDelta.Index
+				}
+				tool, ok := choice.toolCalls[index]
+				if !ok {
+					tool = &relaymodel.Tool{Type: "function"}
+					choice.toolCalls[index] = tool
+					choice.toolArguments[index] = &strings.Builder{}
+				}
+				if toolDelta.Id != "" {
+					tool.Id = toolDelta.Id
+				}
+				if toolDelta.Function.Name != "" {
+					tool.Function.Name = toolDelta.Function.Name
+				}
+				if arguments, ok := toolDelta.Function.Arguments.(string); ok {
+					choice.toolArguments[index].WriteString(arguments)
+				}
+			}
+			if delta.FinishReason != nil {
+				choice.finishReason = *delta.FinishReason
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read stream")
+	}
+	if len(choices) == 0 {
+		return nil, errors.New("no choice in stream")
+	}
+
+	for index, choice := range choices {
+		message := relaymodel.Message{Role: choice.role, Content: choice.content.String()}
+		if message.Role == "" {
+			message.Role = "assistant"
+		}
+		if choice.reasoningContent.Len() > 0 {
+			reasoningContent := choice.reasoningContent.String()
+			message.ReasoningContent = &reasoningContent
+		}
+		toolIndexes := make([]int, 0, len(choice.toolCalls))
+		for toolIndex := range choice.toolCalls {
+			toolIndexes = append(toolIndexes, toolIndex)
+		}
+		sort.Ints(toolIndexes)
+		for _, toolIndex := range toolIndexes {
+			tool := *choice.toolCalls[toolIndex]
+			tool.Function.Arguments = choice.toolArguments[toolIndex].String()
+			message.ToolCalls = append(message.ToolCalls, tool)
+		}
+
+		response.Choices = append(response.Choices, openai.TextResponseChoice{
+			Index:        index,
+			Message:      message,
+			FinishReason: choice.finishReason,
+		})
+	}
+	sort.Slice(response.Choices, func(i, j int) bool {
+		return response.Choices[i].Index < response.Choices[j].Index
+	})
+	response.Usage = *usage
+
+	return json.Marshal(response)
+}
+
+// replayCachedStream sends a cached chat completion as SSE,
+// the whole message of each choice is sent in one chunk followed by the usage.
+func replayCachedStream(c *gin.Context, body []byte) error {
+	response := &openai.TextResponse{}
+	if err := json.Unmarshal(body, response); err != nil {
+		return errors.Wrap(err, "failed to parse cached response")
+	}
+
+	common.SetEventStreamHeaders(c)
+	newChunk := func() *openai.ChatCompletionsStreamResponse {
+		return &openai.ChatCompletionsStreamResponse{
+			Id:      response.Id,
+			Object:  "chat.completion.chunk",
+			Created: response.Created,
+			Model:   response.Model,
+			Choices: []openai.ChatCompletionsStreamResponseChoice{},
+		}
+	}
+	for _, choice := range response.Choices {
+		delta := choice.Message
+		for i := range delta.ToolCalls {
+			index := i
+			delta.ToolCalls[i].Index = &index
+		}
+		finishReason := choice.FinishReason
+
+		chunk := newChunk()
+		chunk.Choices = append(chunk.Choices, openai.ChatCompletionsStreamResponseChoice{
+			Index: choice.Index,
+			Delta: delta,
+		})
+		if err := render.ObjectData(c, chunk); err != nil {
+			return errors.WithStack(err)
+		}
+		chunk = newChunk()
+		chunk.Choices = append(chunk.Choices, openai.ChatCompletionsStreamResponseChoice{
+			Index:        choice.Index,
+			FinishReason: &finishReason,
+		})
+		if err := render.ObjectData(c, chunk); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	chunk := newChunk()
+	chunk.Usage = &response.Usage
+	if err := render.ObjectData(c, chunk); err != nil {
+		return errors.WithStack(err)
+	}
+	render.Done(c)
+	return nil
+}
@@ -0,0 +1,85 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/songquanpeng/one-api/common/config"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+)
+
+const testStreamResponse = `data: {"id":"chatcmpl-1","object":"chat.completion.chunk","created":1700000000,"model":"gpt-4o-mini","choices":[{"index":0,"delta":{"role":"assistant","content":"Hel"}}]}
+
+data: {"id":"chatcmpl-1","object":"chat.completion.chunk","created":1700000000,"model":"gpt-4o-mini","choices":[{"index":0,"delta":{"content":"lo"}}]}
+
+data: {"id":"chatcmpl-1","object":"chat.completion.chunk","created":1700000000,"model":"gpt-4o-mini","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{\"city\":"}}]}}]}
+
+data: {"id":"chatcmpl-1","object":"chat.completion.chunk","created":1700000000,"model":"gpt-4o-mini","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"Paris\"}"}}]},"finish_reason":"tool_calls"}]}
+
+data: {"id":"chatcmpl-1","object":"chat.completion.chunk","created":1700000000,"model":"gpt-4o-mini","choices":[],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}
+
+data: [DONE]
+
+`
+
+func TestAssembleStreamResponse(t *testing.T) {
+	usage := &relaymodel.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15}
+	body, err := assembleStreamResponse([]byte(testStreamResponse), usage)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"id":"chatcmpl-1","object":"chat.completion","created":1700000000,"model":"gpt-4o-mini",
+		"choices":[{"index":0,"finish_reason":"tool_calls","message":{"role":"assistant","content":"Hello",
+			"tool_calls":[{"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Paris\"}"}}]}}],
+		"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}
+	}`, string(body))
+
+	_, err = assembleStreamResponse([]byte("data: [DONE]\n\n"), usage)
+	require.Error(t, err)
+}
+
+func TestReplayCachedStream(t *testing.T) {
+	usage := &relaymodel.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15}
+	body, err := assembleStreamResponse([]byte(testStreamResponse), usage)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	require.NoError(t, replayCachedStream(c, body))
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+
+	// the replayed stream is assembled to the same response
+	replayed, err := assembleStreamResponse(w.Body.Bytes(), usage)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(body), string(replayed))
+	assert.True(t, strings.HasSuffix(w.Body.String(), "data: [DONE]\n\n"))
+}
+
+func TestResponseCacheRecorder(t *testing.T) {
+	originalSize := config.ResponseCacheMaxEntrySizeKB
+	config.ResponseCacheMaxEntrySizeKB = 1
+	t.Cleanup(func() { config.ResponseCacheMaxEntrySizeKB = originalSize })
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	recorder, restore := recordResponse(c, false)
+	c.JSON(http.StatusOK, gin.H{"object": "list"})
+	restore()
+	assert.JSONEq(t, `{"object":"list"}`, string(recorder.Body()))
+	assert.JSONEq(t, `{"object":"list"}`, w.Body.String())
+
+	// large responses are passed through but not recorded
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	recorder, restore = recordResponse(c, false)
+	c.String(http.StatusOK, strings.Repeat("a", 2048))
+	restore()
+	assert.Nil(t, recorder.Body())
+	assert.Equal(t, 2048, w.Body.Len())
+}
@@ -0,0 +1,105 @@
+package controller
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/channeltype"
+	metalib "github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+func TestDoConvertedResponseAPIResponse_NonStream(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/responses", nil)
+
+	meta := &metalib.Meta{
+		Mode:            relaymode.ChatCompletions,
+		ChannelType:     channeltype.DeepSeek,
+		OriginModelName: "deepseek-chat",
+		ActualModelName: "deepseek-chat",
+	}
+	upstream := `{"id":"chatcmpl-7","object":"chat.completion","model":"deepseek-chat","choices":[{"index":0,"message":{"role":"assistant","content":"pong"},"finish_reason":"stop"}],"usage":{"prompt_tokens":3,"completion_tokens":1,"total_tokens":4}}`
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(upstream)),
+	}
+
+	instructions := "be brief"
+	usage, respErr := doConvertedResponseAPIResponse(c, resp, meta,
+		&openai.Adaptor{ChannelType: meta.ChannelType}, &openai.ResponseAPIRequest{Instructions: &instructions})
+	require.Nil(t, respErr)
+	assert.Equal(t, 4, usage.TotalTokens)
+
+	responseAPIResp := new(openai.ResponseAPIResponse)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), responseAPIResp))
+	assert.Equal(t, "resp_7", responseAPIResp.Id)
+	assert.Equal(t, "completed", responseAPIResp.Status)
+	assert.Equal(t, instructions, *responseAPIResp.Instructions)
+	require.Len(t, responseAPIResp.Output, 1)
+	assert.Equal(t, "pong", responseAPIResp.Output[0].Content[0].Text)
+	assert.Equal(t, 3, responseAPIResp.Usage.InputTokens)
+}
+
+func TestDoConvertedResponseAPIResponse_Stream(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/responses", nil)
+
+	meta := &metalib.Meta{
+		Mode:            relaymode.ChatCompletions,
+		ChannelType:     channeltype.DeepSeek,
+		IsStream:        true,
+		OriginModelName: "deepseek-chat",
+		ActualModelName: "deepseek-chat",
+	}
+	upstream := strings.Join([]string{
+		`data: {"id":"chatcmpl-9","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"role":"assistant","content":"Hel"}}]}`,
+		``,
+		`data: {"id":"chatcmpl-9","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"content":"lo"},"finish_reason":"stop"}]}`,
+		``,
+		`data: {"id":"chatcmpl-9","object":"chat.completion.chunk","choices":[],"usage":{"prompt_tokens":11,"completion_tokens":2,"total_tokens":13}}`,
+		``,
+		`data: [DONE]`,
+		``,
+	}, "\n")
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+		Body:       io.NopCloser(strings.NewReader(upstream)),
+	}
+
+	usage, respErr := doConvertedResponseAPIResponse(c, resp, meta, &openai.Adaptor{ChannelType: meta.ChannelType}, nil)
+	require.Nil(t, respErr)
+	assert.Equal(t, 13, usage.TotalTokens)
+
+	body := w.Body.String()
+	assert.NotContains(t, body, "chat.completion.chunk")
+	assert.NotContains(t, body, "[DONE]")
+	assert.Contains(t, body, "event: response.created\n")
+	assert.Contains(t, body, `"text":"Hello"`)
+
+	var last map[string]any
+	for _, line := range strings.Split(body, "\n") {
+		if data, ok := strings.CutPrefix(line, "data: "); ok {
+			last = nil
+			require.NoError(t, json.Unmarshal([]byte(data), &last))
+		}
+	}
+	require.NotNil(t, last)
+	assert.Equal(t, "response.completed", last["type"])
+	assert.Equal(t, float64(13), last["response"].(map[string]any)["usage"].(map[string]any)["total_tokens"])
+}
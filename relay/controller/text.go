@@ -23,6 +23,7 @@ import (
 	"github.com/songquanpeng/one-api/relay/apitype"
 	"github.com/songquanpeng/one-api/relay/billing"
 	"github.com/songquanpeng/one-api/relay/channeltype"
+	"github.com/songquanpeng/one-api/relay/hedge"
 	metalib "github.com/songquanpeng/one-api/relay/meta"
 	relaymodel "github.com/songquanpeng/one-api/relay/model"
 	"github.com/songquanpeng/one-api/relay/pricing"
@@ -43,6 +44,13 @@ func RelayTextHelper(c *gin.Context) *relaymodel.ErrorWithStatusCode {
 		return openai.ErrorWrapper(err, "invalid_text_request", http.StatusBadRequest)
 	}
 	meta.IsStream = textRequest.Stream
+	cacheKey := getResponseCacheKey(c, meta, textRequest)
+
+	// inline files uploaded through the Files API
+	fileResolved, err := resolveChatFileReferences(ctx, meta.UserId, textRequest)
+	if err != nil {
+		return openai.ErrorWrapper(err, "invalid_file_reference", http.StatusBadRequest)
+	}
 
 	if reqBody, ok := c.Get(ctxkey.KeyRequestBody); ok {
 		logger.Debugf(c.Request.Context(), "get text request: %s\n", string(reqBody.([]byte)))
@@ -57,14 +65,13 @@ func RelayTextHelper(c *gin.Context) *relaymodel.ErrorWithStatusCode {
 
 	// get channel-specific pricing if available
 	var channelModelRatio map[string]float64
-	var channelCompletionRatio map[string]float64
 	if channelModel, ok := c.Get(ctxkey.ChannelModel); ok {
 		if channel, ok := channelModel.(*model.Channel); ok {
 			// Get from unified ModelConfigs only (after migration)
 			channelModelRatio = channel.GetModelRatioFromConfigs()
-			channelCompletionRatio = channel.GetCompletionRatioFromConfigs()
 		}
 	}
+	tokenRatios := getTokenRatios(c, meta, textRequest.Model)
 
 	// get model ratio using three-layer pricing system
 	pricingAdaptor := relay.GetAdaptor(meta.ChannelType)
@@ -73,6 +80,13 @@ func RelayTextHelper(c *gin.Context) *relaymodel.ErrorWithStatusCode {
 	groupRatio := c.GetFloat64(ctxkey.ChannelRatio)
 
 	ratio := modelRatio * groupRatio
+	if cacheKey != "" {
+		served, bizErr := serveCachedResponse(c, meta, cacheKey, modelRatio, groupRatio, tokenRatios)
+		if bizErr != nil || served {
+			return bizErr
+		}
+	}
+
 	// pre-consume quota
 	promptTokens := getPromptTokens(c.Request.Context(), textRequest, meta.Mode)
 	meta.PromptTokens = promptTokens
@@ -82,6 +96,15 @@ func RelayTextHelper(c *gin.Context) *relaymodel.ErrorWithStatusCode {
 		return bizErr
 	}
 
+	// the pre-consumed quota is returned unless the request is billed, e.g. when it fails
+	// or when it loses to the other attempt of a hedged request, which is cancelled at any step
+	billed := false
+	defer func() {
+		if !billed {
+			billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta.TokenId)
+		}
+	}()
+
 	adaptor := relay.GetAdaptor(meta.APIType)
 	if adaptor == nil {
 		return openai.ErrorWrapper(errors.Errorf("invalid api type: %d", meta.APIType), "invalid_api_type", http.StatusBadRequest)
@@ -89,7 +112,7 @@ func RelayTextHelper(c *gin.Context) *relaymodel.ErrorWithStatusCode {
 	adaptor.Init(meta)
 
 	// get request body
-	requestBody, err := getRequestBody(c, meta, textRequest, adaptor)
+	requestBody, err := getRequestBody(c, meta, textRequest, adaptor, fileResolved)
 	if err != nil {
 		return openai.ErrorWrapper(err, "convert_request_failed", http.StatusInternalServerError)
 	}
@@ -105,18 +128,34 @@ func RelayTextHelper(c *gin.Context) *relaymodel.ErrorWithStatusCode {
 		return openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
 	}
 	if isErrorHappened(meta, resp) {
-		billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta.TokenId)
 		return RelayErrorHandler(resp)
 	}
 
 	// do response
+	var recorder *responseCacheRecorder
+	restoreWriter := func() {}
+	if cacheKey != "" {
+		recorder, restoreWriter = recordResponse(c, meta.IsStream)
+	}
 	usage, respErr := adaptor.DoResponse(c, resp, meta)
+	restoreWriter()
 	if respErr != nil {
 		logger.Errorf(ctx, "respErr is not nil: %+v", respErr)
-		billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta.TokenId)
 		return respErr
 	}
 
+	// only the attempt of a hedged request which answered first is billed
+	if !hedge.Claim(c) {
+		return openai.ErrorWrapper(hedge.ErrLost, "hedge_lost", http.StatusServiceUnavailable)
+	}
+	billed = true
+	recordUsageTokens(c, usage)
+
+	var responseBody []byte
+	if recorder != nil {
+		responseBody = recorder.Body()
+	}
+
 	// post-consume quota
 	quotaId := c.GetInt(ctxkey.Id)
 	requestId := c.GetString(ctxkey.RequestId)
@@ -167,7 +206,10 @@ func RelayTextHelper(c *gin.Context) *relaymodel.ErrorWithStatusCode {
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 
-		quota := postConsumeQuota(ctx, usage, meta, textRequest, ratio, preConsumedQuota, modelRatio, groupRatio, systemPromptReset, channelCompletionRatio)
+		quota := postConsumeQuota(ctx, usage, meta, textRequest, ratio, preConsumedQuota, modelRatio, groupRatio, systemPromptReset, tokenRatios)
+		if cacheKey != "" {
+			cacheResponse(ctx, meta, cacheKey, responseBody, usage)
+		}
 
 		// also update user request cost
 		if quota != 0 {
@@ -185,8 +227,11 @@ func RelayTextHelper(c *gin.Context) *relaymodel.ErrorWithStatusCode {
 	return nil
 }
 
-func getRequestBody(c *gin.Context, meta *metalib.Meta, textRequest *relaymodel.GeneralOpenAIRequest, adaptor adaptor.Adaptor) (io.Reader, error) {
-	if !config.EnforceIncludeUsage &&
+// getRequestBody converts textRequest for the channel, the original body is passed through
+// to OpenAI-compatible channels when textRequest is not modified.
+func getRequestBody(c *gin.Context, meta *metalib.Meta, textRequest *relaymodel.GeneralOpenAIRequest, adaptor adaptor.Adaptor, modified bool) (io.Reader, error) {
+	if !modified &&
+		!config.EnforceIncludeUsage &&
 		meta.APIType == apitype.OpenAI &&
 		meta.OriginModelName == meta.ActualModelName &&
 		meta.ChannelType != channeltype.OpenAI && // openai also need to convert request
@@ -0,0 +1,126 @@
+// Package fallback holds the model fallback chains, which name the models to try in order
+// when every channel of the requested model has failed.
+package fallback
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/Laisky/errors/v2"
+
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+// Chains maps a model to the models to fall back to, in order,
+// e.g. {"gpt-4o": ["claude-sonnet-4-0", "gemini-2.5-pro"]}
+type Chains map[string][]string
+
+var groupChainsLock sync.RWMutex
+
+// GroupChains is the fallback chains of each group
+var GroupChains = map[string]Chains{}
+
+func GroupChains2JSONString() string {
+	groupChainsLock.RLock()
+	defer groupChainsLock.RUnlock()
+	jsonBytes, err := json.Marshal(GroupChains)
+	if err != nil {
+		logger.SysError("error marshalling model fallback chains: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateGroupChainsByJSONString(jsonStr string) error {
+	groupChains := make(map[string]Chains)
+	if err := json.Unmarshal([]byte(jsonStr), &groupChains); err != nil {
+		return errors.Wrap(err, "failed to parse model fallback chains")
+	}
+	for group, chains := range groupChains {
+		if err := chains.validate(); err != nil {
+			return errors.Wrapf(err, "invalid model fallback chains of group %s", group)
+		}
+	}
+
+	groupChainsLock.Lock()
+	defer groupChainsLock.Unlock()
+	GroupChains = groupChains
+	return nil
+}
+
+// ParseChains parses the fallback chains set on a token, empty means none
+func ParseChains(jsonStr string) (Chains, error) {
+	if strings.TrimSpace(jsonStr) == "" {
+		return nil, nil
+	}
+
+	chains := make(Chains)
+	if err := json.Unmarshal([]byte(jsonStr), &chains); err != nil {
+		return nil, errors.Wrap(err, "failed to parse model fallback chains")
+	}
+	if err := chains.validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid model fallback chains")
+	}
+	return chains, nil
+}
+
+func (chains Chains) validate() error {
+	for model, fallbacks := range chains {
+		for _, fallback := range fallbacks {
+			if fallback == "" || fallback == model {
+				return errors.Errorf("model %s could not fall back to %q", model, fallback)
+			}
+		}
+	}
+	return nil
+}
+
+// GetChain returns the models to fall back to when model fails for a request by group,
+// the chain of the token given by tokenChains overrides the one of the group
+func GetChain(tokenChains string, group string, model string) []string {
+	if chains, err := ParseChains(tokenChains); err != nil {
+		logger.SysError("error parsing model fallback chains of token: " + err.Error())
+	} else if chain, ok := chains[model]; ok {
+		return chain
+	}
+
+	groupChainsLock.RLock()
+	defer groupChainsLock.RUnlock()
+	return GroupChains[group][model]
+}
+
+// IsSupported reports whether requests of relayMode could fall back to another model,
+// which requires the model to be given in the JSON body
+func IsSupported(relayMode int) bool {
+	switch relayMode {
+	case relaymode.ChatCompletions,
+		relaymode.Completions,
+		relaymode.Embeddings,
+		relaymode.ResponseAPI,
+		relaymode.ClaudeMessages:
+		return true
+	default:
+		return false
+	}
+}
+
+// SetRequestModel returns body, a JSON object, with its model replaced by model
+func SetRequestModel(body []byte, model string) ([]byte, error) {
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal request body")
+	}
+
+	modelValue, err := json.Marshal(model)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal model")
+	}
+	fields["model"] = modelValue
+
+	newBody, err := json.Marshal(fields)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal request body")
+	}
+	return newBody, nil
+}
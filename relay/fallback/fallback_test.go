@@ -0,0 +1,70 @@
+package fallback
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+func TestParseChains(t *testing.T) {
+	chains, err := ParseChains("")
+	require.NoError(t, err)
+	assert.Nil(t, chains)
+
+	chains, err = ParseChains(`{"gpt-4o": ["claude-sonnet-4-0", "gemini-2.5-pro"]}`)
+	require.NoError(t, err)
+	assert.Equal(t, Chains{"gpt-4o": {"claude-sonnet-4-0", "gemini-2.5-pro"}}, chains)
+
+	_, err = ParseChains(`{"gpt-4o": ["gpt-4o"]}`)
+	assert.Error(t, err)
+	_, err = ParseChains(`{"gpt-4o": [""]}`)
+	assert.Error(t, err)
+	_, err = ParseChains(`["gpt-4o"]`)
+	assert.Error(t, err)
+}
+
+func TestGetChain(t *testing.T) {
+	original := GroupChains2JSONString()
+	t.Cleanup(func() {
+		require.NoError(t, UpdateGroupChainsByJSONString(original))
+	})
+
+	require.NoError(t, UpdateGroupChainsByJSONString(`{"default": {"gpt-4o": ["gpt-4o-mini"], "o3": ["o4-mini"]}}`))
+	assert.Error(t, UpdateGroupChainsByJSONString(`{"default": {"gpt-4o": ["gpt-4o"]}}`))
+
+	assert.Equal(t, []string{"gpt-4o-mini"}, GetChain("", "default", "gpt-4o"))
+	assert.Empty(t, GetChain("", "vip", "gpt-4o"))
+	assert.Empty(t, GetChain("", "default", "gpt-4o-mini"))
+
+	// the chain of the token overrides the one of the group for the same model only
+	tokenChains := `{"gpt-4o": ["claude-sonnet-4-0"]}`
+	assert.Equal(t, []string{"claude-sonnet-4-0"}, GetChain(tokenChains, "default", "gpt-4o"))
+	assert.Equal(t, []string{"o4-mini"}, GetChain(tokenChains, "default", "o3"))
+	// an invalid chain of the token is ignored
+	assert.Equal(t, []string{"gpt-4o-mini"}, GetChain(`{`, "default", "gpt-4o"))
+}
+
+func TestIsSupported(t *testing.T) {
+	assert.True(t, IsSupported(relaymode.ChatCompletions))
+	assert.True(t, IsSupported(relaymode.ClaudeMessages))
+	assert.False(t, IsSupported(relaymode.AudioTranscription))
+	assert.False(t, IsSupported(relaymode.ImagesGenerations))
+}
+
+func TestSetRequestModel(t *testing.T) {
+	body, err := SetRequestModel([]byte(`{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"hi"}]}`), "gpt-4o-mini")
+	require.NoError(t, err)
+
+	var request map[string]any
+	require.NoError(t, json.Unmarshal(body, &request))
+	assert.Equal(t, "gpt-4o-mini", request["model"])
+	assert.Equal(t, true, request["stream"])
+	assert.Len(t, request["messages"], 1)
+
+	_, err = SetRequestModel([]byte(`not json`), "gpt-4o-mini")
+	assert.Error(t, err)
+}
@@ -0,0 +1,214 @@
+// Package hedge races the attempts of a hedged request, which is sent to a second channel
+// when the first one has not answered within the hedge delay.
+//
+// Every attempt writes through its own Writer. Headers are buffered until the attempt writes its first byte,
+// the first attempt to do so wins the race: its response goes to the client and the other attempts are cancelled.
+// Only the winner may be billed, see Claim.
+package hedge
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/Laisky/errors/v2"
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+// ErrLost is returned by the writer of an attempt which has lost the race
+var ErrLost = errors.New("another attempt of the hedged request has answered first")
+
+// Race is the race between the attempts of a hedged request
+type Race struct {
+	sync.Mutex
+	writer   gin.ResponseWriter
+	winner   *Attempt
+	attempts []*Attempt
+	decided  chan struct{}
+}
+
+// NewRace returns a race whose winner writes to writer, the writer of the client
+func NewRace(writer gin.ResponseWriter) *Race {
+	return &Race{
+		writer:  writer,
+		decided: make(chan struct{}),
+	}
+}
+
+// Decided is closed once an attempt has won
+func (r *Race) Decided() <-chan struct{} {
+	return r.decided
+}
+
+// Winner returns the attempt which has won, nil if none has yet
+func (r *Race) Winner() *Attempt {
+	r.Lock()
+	defer r.Unlock()
+	return r.winner
+}
+
+// NewAttempt adds an attempt to the race, cancel is called when another attempt wins
+func (r *Race) NewAttempt(cancel context.CancelFunc) *Attempt {
+	r.Lock()
+	defer r.Unlock()
+	attempt := &Attempt{
+		race:   r,
+		cancel: cancel,
+		header: r.writer.Header().Clone(),
+	}
+	r.attempts = append(r.attempts, attempt)
+	return attempt
+}
+
+// Attempt is one of the requests of a hedged request
+type Attempt struct {
+	race   *Race
+	cancel context.CancelFunc
+	// header and status are buffered until the attempt wins
+	header http.Header
+	status int
+}
+
+// Writer returns the writer the attempt writes its response to
+func (a *Attempt) Writer() gin.ResponseWriter {
+	return &writer{ResponseWriter: a.race.writer, attempt: a}
+}
+
+// Won reports whether the attempt has won the race
+func (a *Attempt) Won() bool {
+	a.race.Lock()
+	defer a.race.Unlock()
+	return a.race.winner == a
+}
+
+// claim makes the attempt the winner unless another one has won, and reports whether it is the winner
+func (a *Attempt) claim() bool {
+	r := a.race
+	r.Lock()
+	defer r.Unlock()
+	if r.winner != nil {
+		return r.winner == a
+	}
+
+	r.winner = a
+	header := r.writer.Header()
+	for key := range header {
+		delete(header, key)
+	}
+	for key, values := range a.header {
+		header[key] = values
+	}
+	if a.status != 0 {
+		r.writer.WriteHeader(a.status)
+	}
+	for _, attempt := range r.attempts {
+		if attempt != a {
+			attempt.cancel()
+		}
+	}
+	close(r.decided)
+	return true
+}
+
+// Claim reports whether the response of the request of c may be sent and billed,
+// it makes the attempt of c the winner if none has won yet.
+// Requests which are not hedged always may.
+func Claim(c *gin.Context) bool {
+	v, ok := c.Get(ctxkey.HedgeAttempt)
+	if !ok {
+		return true
+	}
+	attempt, _ := v.(*Attempt)
+	if attempt == nil {
+		return true
+	}
+	return attempt.claim()
+}
+
+// IsSupported reports whether requests of relayMode could be hedged,
+// which are the ones generating text for interactive clients
+func IsSupported(relayMode int) bool {
+	switch relayMode {
+	case relaymode.ChatCompletions,
+		relaymode.Completions,
+		relaymode.ResponseAPI,
+		relaymode.ClaudeMessages:
+		return true
+	default:
+		return false
+	}
+}
+
+// writer sends the response of an attempt to the client once the attempt has won,
+// writes of the attempts which have lost fail with ErrLost
+type writer struct {
+	gin.ResponseWriter
+	attempt *Attempt
+}
+
+func (w *writer) Header() http.Header {
+	if w.attempt.Won() {
+		return w.ResponseWriter.Header()
+	}
+	return w.attempt.header
+}
+
+func (w *writer) WriteHeader(code int) {
+	if w.attempt.Won() {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if code > 0 {
+		w.attempt.status = code
+	}
+}
+
+func (w *writer) WriteHeaderNow() {
+	if w.attempt.Won() {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *writer) Write(data []byte) (int, error) {
+	if !w.attempt.claim() {
+		return 0, ErrLost
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *writer) WriteString(s string) (int, error) {
+	if !w.attempt.claim() {
+		return 0, ErrLost
+	}
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *writer) Flush() {
+	if w.attempt.Won() {
+		w.ResponseWriter.Flush()
+	}
+}
+
+func (w *writer) Status() int {
+	if w.attempt.Won() {
+		return w.ResponseWriter.Status()
+	}
+	if w.attempt.status != 0 {
+		return w.attempt.status
+	}
+	return http.StatusOK
+}
+
+func (w *writer) Size() int {
+	if w.attempt.Won() {
+		return w.ResponseWriter.Size()
+	}
+	return -1
+}
+
+func (w *writer) Written() bool {
+	return w.attempt.Won() && w.ResponseWriter.Written()
+}
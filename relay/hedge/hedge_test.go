@@ -0,0 +1,83 @@
+package hedge
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/songquanpeng/one-api/common/ctxkey"
+)
+
+func newTestRace(t *testing.T) (*httptest.ResponseRecorder, *Race, *gin.Context) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Header("X-Oneapi-Request-Id", "request-id")
+	return recorder, NewRace(c.Writer), c
+}
+
+func TestRace_FirstWriteWins(t *testing.T) {
+	recorder, race, _ := newTestRace(t)
+	primaryCtx, cancelPrimary := context.WithCancel(context.Background())
+	hedgeCtx, cancelHedge := context.WithCancel(context.Background())
+	primary := race.NewAttempt(cancelPrimary)
+	hedged := race.NewAttempt(cancelHedge)
+	primaryWriter, hedgeWriter := primary.Writer(), hedged.Writer()
+
+	// headers are buffered per attempt until one writes
+	primaryWriter.Header().Set("Content-Type", "text/plain")
+	hedgeWriter.Header().Set("Content-Type", "text/event-stream")
+	hedgeWriter.WriteHeader(http.StatusCreated)
+	hedgeWriter.Flush()
+	assert.Nil(t, race.Winner())
+	assert.False(t, hedgeWriter.Written())
+
+	_, err := hedgeWriter.WriteString("data: hello\n\n")
+	require.NoError(t, err)
+	assert.True(t, hedged.Won())
+	assert.Same(t, hedged, race.Winner())
+	assert.Error(t, primaryCtx.Err())
+	assert.NoError(t, hedgeCtx.Err())
+	select {
+	case <-race.Decided():
+	default:
+		t.Fatal("race should be decided")
+	}
+
+	_, err = primaryWriter.Write([]byte("too late"))
+	assert.ErrorIs(t, err, ErrLost)
+	primaryWriter.Header().Set("X-Lost", "true")
+
+	_, err = hedgeWriter.Write([]byte("data: [DONE]\n\n"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, recorder.Code)
+	assert.Equal(t, "data: hello\n\ndata: [DONE]\n\n", recorder.Body.String())
+	assert.Equal(t, "text/event-stream", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, "request-id", recorder.Header().Get("X-Oneapi-Request-Id"))
+	assert.Empty(t, recorder.Header().Get("X-Lost"))
+}
+
+func TestClaim(t *testing.T) {
+	_, race, c := newTestRace(t)
+
+	// requests which are not hedged are always billed
+	assert.True(t, Claim(c))
+	c.Set(ctxkey.HedgeAttempt, (*Attempt)(nil))
+	assert.True(t, Claim(c))
+
+	primary := race.NewAttempt(func() {})
+	hedged := race.NewAttempt(func() {})
+	primaryCtx := c.Copy()
+	primaryCtx.Set(ctxkey.HedgeAttempt, primary)
+	hedgeCtx := c.Copy()
+	hedgeCtx.Set(ctxkey.HedgeAttempt, hedged)
+
+	assert.True(t, Claim(primaryCtx))
+	assert.True(t, Claim(primaryCtx))
+	assert.False(t, Claim(hedgeCtx))
+}
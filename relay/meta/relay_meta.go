@@ -37,6 +37,8 @@ type Meta struct {
 	ChannelRatio       float64
 	ForcedSystemPrompt string
 	StartTime          time.Time
+	// ChannelKey is the fingerprint of APIKey when the channel holds multiple keys
+	ChannelKey string
 }
 
 // GetMappedModelName returns the mapped model name and a bool indicating if the model name is mapped
@@ -65,6 +67,7 @@ func GetByContext(c *gin.Context) *Meta {
 			existingMeta.ChannelId = currentChannelId
 			existingMeta.BaseURL = c.GetString(ctxkey.BaseURL)
 			existingMeta.APIKey = strings.TrimPrefix(c.Request.Header.Get("Authorization"), "Bearer ")
+			existingMeta.ChannelKey = c.GetString(ctxkey.ChannelKey)
 			existingMeta.ChannelRatio = c.GetFloat64(ctxkey.ChannelRatio)
 			existingMeta.ModelMapping = c.GetStringMapString(ctxkey.ModelMapping)
 			existingMeta.ForcedSystemPrompt = c.GetString(ctxkey.SystemPrompt)
@@ -93,6 +96,7 @@ func GetByContext(c *gin.Context) *Meta {
 		Mode:               relaymode.GetByPath(c.Request.URL.Path),
 		ChannelType:        c.GetInt(ctxkey.Channel),
 		ChannelId:          c.GetInt(ctxkey.ChannelId),
+		ChannelKey:         c.GetString(ctxkey.ChannelKey),
 		TokenId:            c.GetInt(ctxkey.TokenId),
 		TokenName:          c.GetString(ctxkey.TokenName),
 		UserId:             c.GetInt(ctxkey.Id),
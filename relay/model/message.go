@@ -4,6 +4,8 @@ import (
 	"context"
 	"strings"
 
+	"github.com/Laisky/errors/v2"
+
 	"github.com/songquanpeng/one-api/common/logger"
 )
 
@@ -185,6 +187,17 @@ func (m Message) ParseContent() []MessageContent {
 						},
 					})
 				}
+			case ContentTypeFile:
+				if subObj, ok := contentMap["file"].(map[string]any); ok {
+					file := &MessageFile{}
+					file.FileId, _ = subObj["file_id"].(string)
+					file.FileData, _ = subObj["file_data"].(string)
+					file.Filename, _ = subObj["filename"].(string)
+					contentList = append(contentList, MessageContent{
+						Type: ContentTypeFile,
+						File: file,
+					})
+				}
 			default:
 				logger.Warnf(context.TODO(), "unknown content type: %s", contentMap["type"])
 			}
@@ -202,10 +215,11 @@ type ImageURL struct {
 
 type MessageContent struct {
 	// Type should be one of the following: text/input_audio
-	Type       string      `json:"type,omitempty"`
-	Text       *string     `json:"text,omitempty"`
-	ImageURL   *ImageURL   `json:"image_url,omitempty"`
-	InputAudio *InputAudio `json:"input_audio,omitempty"`
+	Type       string       `json:"type,omitempty"`
+	Text       *string      `json:"text,omitempty"`
+	ImageURL   *ImageURL    `json:"image_url,omitempty"`
+	InputAudio *InputAudio  `json:"input_audio,omitempty"`
+	File       *MessageFile `json:"file,omitempty"`
 	// -------------------------------------
 	// Anthropic
 	// -------------------------------------
@@ -213,6 +227,24 @@ type MessageContent struct {
 	Signature *string `json:"signature,omitempty"`
 }
 
+// MessageFile is a file attached to a message, either by id or by content
+type MessageFile struct {
+	FileId string `json:"file_id,omitempty"`
+	// FileData is the content of the file as a data URL, such as data:application/pdf;base64,...
+	FileData string `json:"file_data,omitempty"`
+	Filename string `json:"filename,omitempty"`
+}
+
+// ParseFileData returns the mime type and the base64 encoded content of FileData
+func (f *MessageFile) ParseFileData() (mimeType string, data string, err error) {
+	header, data, ok := strings.Cut(f.FileData, ",")
+	if !ok || !strings.HasPrefix(header, "data:") || !strings.HasSuffix(header, ";base64") {
+		return "", "", errors.New("file_data should be a base64 data URL")
+	}
+
+	return strings.TrimSuffix(strings.TrimPrefix(header, "data:"), ";base64"), data, nil
+}
+
 type InputAudio struct {
 	// Data is the base64 encoded audio data
 	Data string `json:"data" binding:"required"`
@@ -17,6 +17,22 @@ type Usage struct {
 	// -------------------------------------
 	// ToolsCost is the cost of using tools, in quota.
 	ToolsCost int64 `json:"tools_cost,omitempty"`
+	// PromptCacheHitTokens is the prompt tokens read from the prompt cache, reported by DeepSeek
+	// instead of PromptTokensDetails.CachedTokens.
+	PromptCacheHitTokens int `json:"prompt_cache_hit_tokens,omitempty"`
+}
+
+// FillCachedTokens copies the prompt cache hits reported out of PromptTokensDetails into it
+func (u *Usage) FillCachedTokens() {
+	if u == nil || u.PromptCacheHitTokens == 0 {
+		return
+	}
+	if u.PromptTokensDetails == nil {
+		u.PromptTokensDetails = &UsagePromptTokensDetails{}
+	}
+	if u.PromptTokensDetails.CachedTokens == 0 {
+		u.PromptTokensDetails.CachedTokens = u.PromptCacheHitTokens
+	}
 }
 
 type Error struct {
@@ -38,6 +54,9 @@ type UsagePromptTokensDetails struct {
 	// TextTokens could be zero for pure text chats
 	TextTokens  int `json:"text_tokens"`
 	ImageTokens int `json:"image_tokens"`
+	// CacheWriteTokens is the input tokens written to the prompt cache, reported by Anthropic.
+	// They are part of the prompt tokens like CachedTokens.
+	CacheWriteTokens int `json:"cache_write_tokens,omitempty"`
 }
 
 // UsageCompletionTokensDetails contains details about the completion tokens used in a request.
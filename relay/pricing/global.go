@@ -211,6 +211,18 @@ func GetGlobalCompletionRatio(modelName string) float64 {
 	return 0 // Not found in global pricing
 }
 
+// GetGlobalModelConfig returns the global pricing of a given model
+// Returns false if the model is not found in global pricing
+func GetGlobalModelConfig(modelName string) (adaptor.ModelConfig, bool) {
+	globalPricingManager.mu.RLock()
+	defer globalPricingManager.mu.RUnlock()
+
+	globalPricingManager.ensureInitialized()
+
+	price, exists := globalPricingManager.globalModelPricing[modelName]
+	return price, exists
+}
+
 // GetGlobalModelPricing returns a copy of the entire global pricing map
 func GetGlobalModelPricing() map[string]adaptor.ModelConfig {
 	globalPricingManager.mu.RLock()
@@ -259,6 +271,8 @@ func IsGlobalPricingInitialized() bool {
 // 2. Adapter default pricing (second priority)
 // 3. Global pricing fallback (third priority)
 // 4. Final default (lowest priority)
+// It is the ratio below all price tiers of the model, billing picks the tier of the request
+// from its usage by TokenRatios.ForPromptTokens.
 func GetModelRatioWithThreeLayers(modelName string, channelOverrides map[string]float64, adaptor adaptor.Adaptor) float64 {
 	// Layer 1: User custom ratio (channel-specific overrides)
 	if channelOverrides != nil {
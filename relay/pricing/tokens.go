@@ -0,0 +1,135 @@
+package pricing
+
+import (
+	"cmp"
+	"slices"
+
+	"github.com/songquanpeng/one-api/relay/adaptor"
+	"github.com/songquanpeng/one-api/relay/billing/ratio"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+)
+
+// TokenRatios is the price of each kind of tokens relative to the price of text input tokens
+type TokenRatios struct {
+	// Completion is the ratio of text output tokens
+	Completion float64 `json:"completion_ratio"`
+	// CachedInput is the ratio of input tokens read from the prompt cache
+	CachedInput float64 `json:"cached_input_ratio"`
+	// CacheWrite is the ratio of input tokens written to the prompt cache
+	CacheWrite float64 `json:"cache_write_ratio"`
+	// AudioInput is the ratio of audio input tokens
+	AudioInput float64 `json:"audio_input_ratio"`
+	// AudioOutput is the ratio of audio output tokens
+	AudioOutput float64 `json:"audio_output_ratio"`
+	// ImageInput is the ratio of image input tokens
+	ImageInput float64 `json:"image_input_ratio"`
+	// Tiers is the price tiers of requests with long prompts, see ForPromptTokens
+	Tiers []adaptor.PriceTier `json:"tiers,omitempty"`
+}
+
+// GetTokenRatiosWithThreeLayers returns the token ratios of a model, each of them falls back through the layers
+// of GetModelRatioWithThreeLayers on its own: the channel override, the adapter default pricing and the global pricing.
+// Ratios set nowhere bill the tokens like text input tokens, except audio tokens of the models listed in the
+// legacy audio ratio tables, and audio output tokens which are billed like text output tokens.
+// Price tiers are taken as a whole from the first layer defining any.
+func GetTokenRatiosWithThreeLayers(modelName string, channelOverride *adaptor.ModelConfig, pricingAdaptor adaptor.Adaptor) TokenRatios {
+	var channelCompletionRatios map[string]float64
+	if channelOverride != nil && channelOverride.CompletionRatio != 0 {
+		channelCompletionRatios = map[string]float64{modelName: channelOverride.CompletionRatio}
+	}
+	ratios := TokenRatios{
+		Completion: GetCompletionRatioWithThreeLayers(modelName, channelCompletionRatios, pricingAdaptor),
+	}
+
+	layers := make([]adaptor.ModelConfig, 0, 3)
+	if channelOverride != nil {
+		layers = append(layers, *channelOverride)
+	}
+	if pricingAdaptor != nil {
+		if price, exists := pricingAdaptor.GetDefaultModelPricing()[modelName]; exists {
+			layers = append(layers, price)
+		}
+	}
+	if price, exists := GetGlobalModelConfig(modelName); exists {
+		layers = append(layers, price)
+	}
+	pick := func(field func(adaptor.ModelConfig) float64, fallback float64) float64 {
+		for _, layer := range layers {
+			if v := field(layer); v > 0 {
+				return v
+			}
+		}
+		return fallback
+	}
+
+	audioInput, audioOutput := 1.0, ratios.Completion
+	if _, ok := ratio.AudioRatio[modelName]; ok {
+		audioInput = ratio.GetAudioPromptRatio(modelName)
+		audioOutput = audioInput * ratio.GetAudioCompletionRatio(modelName)
+	}
+
+	ratios.CachedInput = pick(func(c adaptor.ModelConfig) float64 { return c.CachedInputRatio }, 1)
+	ratios.CacheWrite = pick(func(c adaptor.ModelConfig) float64 { return c.CacheWriteRatio }, 1)
+	ratios.AudioInput = pick(func(c adaptor.ModelConfig) float64 { return c.AudioInputRatio }, audioInput)
+	ratios.AudioOutput = pick(func(c adaptor.ModelConfig) float64 { return c.AudioOutputRatio }, audioOutput)
+	ratios.ImageInput = pick(func(c adaptor.ModelConfig) float64 { return c.ImageInputRatio }, 1)
+	for _, layer := range layers {
+		if len(layer.Tiers) > 0 {
+			ratios.Tiers = layer.Tiers
+			break
+		}
+	}
+	return ratios
+}
+
+// ForPromptTokens returns the model ratio and the token ratios of the price tier serving requests of promptTokens,
+// modelRatio is the ratio of the model below all thresholds, e.g. from GetModelRatioWithThreeLayers.
+// The tier is the one of the highest threshold below promptTokens, ratios it leaves unset are the ones of the tier below.
+func (r TokenRatios) ForPromptTokens(modelRatio float64, promptTokens int) (float64, TokenRatios) {
+	tiers := slices.Clone(r.Tiers)
+	slices.SortFunc(tiers, func(a, b adaptor.PriceTier) int {
+		return cmp.Compare(a.InputTokenThreshold, b.InputTokenThreshold)
+	})
+	for _, tier := range tiers {
+		if promptTokens <= tier.InputTokenThreshold {
+			break
+		}
+		if tier.Ratio > 0 {
+			modelRatio = tier.Ratio
+		}
+		if tier.CompletionRatio > 0 {
+			r.Completion = tier.CompletionRatio
+		}
+	}
+	return modelRatio, r
+}
+
+// WeightedTokens returns the usage in text input tokens, which is multiplied by the model ratio to get the quota.
+// Prompt tokens are split into cached, cache-write, audio, image and the remaining text tokens by the usage details,
+// and completion tokens into audio and text tokens. Details adding up to more than their total are ignored,
+// the total is then billed as text tokens.
+func (r TokenRatios) WeightedTokens(usage *relaymodel.Usage) float64 {
+	if usage == nil {
+		return 0
+	}
+
+	input := float64(usage.PromptTokens)
+	if d := usage.PromptTokensDetails; d != nil {
+		if text := usage.PromptTokens - d.CachedTokens - d.CacheWriteTokens - d.AudioTokens - d.ImageTokens; text >= 0 {
+			input = float64(text) +
+				float64(d.CachedTokens)*r.CachedInput +
+				float64(d.CacheWriteTokens)*r.CacheWrite +
+				float64(d.AudioTokens)*r.AudioInput +
+				float64(d.ImageTokens)*r.ImageInput
+		}
+	}
+
+	output := float64(usage.CompletionTokens) * r.Completion
+	if d := usage.CompletionTokensDetails; d != nil {
+		if text := usage.CompletionTokens - d.AudioTokens; text >= 0 {
+			output = float64(text)*r.Completion + float64(d.AudioTokens)*r.AudioOutput
+		}
+	}
+
+	return input + output
+}
@@ -0,0 +1,119 @@
+package pricing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/songquanpeng/one-api/relay/adaptor"
+	"github.com/songquanpeng/one-api/relay/apitype"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+)
+
+func TestGetTokenRatiosWithThreeLayers(t *testing.T) {
+	globalPricingManager = &GlobalPricingManager{
+		contributingAdapters: []int{apitype.OpenAI, apitype.Anthropic},
+	}
+	InitializeGlobalPricingManager(mockGetAdaptor)
+
+	cachingAdaptor := &MockAdaptor{
+		name: "caching",
+		pricing: map[string]adaptor.ModelConfig{
+			"cached-model": {Ratio: 1 * 0.000001, CompletionRatio: 4, CachedInputRatio: 0.5, CacheWriteRatio: 1.25},
+		},
+	}
+
+	// Layer 2: adapter pricing, ratios set nowhere bill like text tokens
+	ratios := GetTokenRatiosWithThreeLayers("cached-model", nil, cachingAdaptor)
+	assert.Equal(t, TokenRatios{
+		Completion:  4,
+		CachedInput: 0.5,
+		CacheWrite:  1.25,
+		AudioInput:  1,
+		AudioOutput: 4,
+		ImageInput:  1,
+	}, ratios)
+
+	// Layer 1: each ratio of the channel overrides the one of the adapter on its own
+	ratios = GetTokenRatiosWithThreeLayers("cached-model", &adaptor.ModelConfig{CachedInputRatio: 0.1}, cachingAdaptor)
+	assert.Equal(t, 0.1, ratios.CachedInput)
+	assert.Equal(t, 1.25, ratios.CacheWrite)
+	assert.Equal(t, 4.0, ratios.Completion)
+
+	// Layer 3: global pricing
+	ratios = GetTokenRatiosWithThreeLayers("claude-3-opus", nil, cachingAdaptor)
+	assert.Equal(t, 5.0, ratios.Completion)
+	assert.Equal(t, 1.0, ratios.CachedInput)
+
+	// audio models listed in the legacy audio tables keep their audio ratios
+	ratios = GetTokenRatiosWithThreeLayers("gpt-4o-audio-preview", nil, cachingAdaptor)
+	assert.Equal(t, 1.0, ratios.Completion)
+	assert.Equal(t, 16.0, ratios.AudioInput)
+	assert.Equal(t, 32.0, ratios.AudioOutput)
+}
+
+func TestTokenRatios_WeightedTokens(t *testing.T) {
+	ratios := TokenRatios{Completion: 4, CachedInput: 0.1, CacheWrite: 1.25, AudioInput: 8, AudioOutput: 16, ImageInput: 2}
+
+	assert.Equal(t, 0.0, ratios.WeightedTokens(nil))
+	assert.Equal(t, 140.0, ratios.WeightedTokens(&relaymodel.Usage{PromptTokens: 100, CompletionTokens: 10}))
+
+	// 20 text, 60 cached and 20 cache-write prompt tokens
+	usage := &relaymodel.Usage{
+		PromptTokens:        100,
+		CompletionTokens:    10,
+		PromptTokensDetails: &relaymodel.UsagePromptTokensDetails{CachedTokens: 60, CacheWriteTokens: 20},
+	}
+	assert.InDelta(t, 20+6+25+40, ratios.WeightedTokens(usage), 1e-9)
+
+	// 50 text, 30 audio and 20 image prompt tokens, 4 text and 6 audio completion tokens
+	usage = &relaymodel.Usage{
+		PromptTokens:            100,
+		CompletionTokens:        10,
+		PromptTokensDetails:     &relaymodel.UsagePromptTokensDetails{AudioTokens: 30, ImageTokens: 20},
+		CompletionTokensDetails: &relaymodel.UsageCompletionTokensDetails{AudioTokens: 6},
+	}
+	assert.InDelta(t, 50+240+40+16+96, ratios.WeightedTokens(usage), 1e-9)
+
+	// details adding up to more than their total are ignored
+	usage = &relaymodel.Usage{
+		PromptTokens:            100,
+		CompletionTokens:        10,
+		PromptTokensDetails:     &relaymodel.UsagePromptTokensDetails{CachedTokens: 80, AudioTokens: 30},
+		CompletionTokensDetails: &relaymodel.UsageCompletionTokensDetails{AudioTokens: 20},
+	}
+	assert.Equal(t, 140.0, ratios.WeightedTokens(usage))
+}
+
+func TestTokenRatios_ForPromptTokens(t *testing.T) {
+	ratios := TokenRatios{
+		Completion: 4,
+		Tiers: []adaptor.PriceTier{
+			{InputTokenThreshold: 128000, Ratio: 2, CompletionRatio: 6},
+			{InputTokenThreshold: 200000, Ratio: 3},
+			{InputTokenThreshold: 500000, CompletionRatio: 8},
+		},
+	}
+
+	modelRatio, tiered := ratios.ForPromptTokens(1, 128000)
+	assert.Equal(t, 1.0, modelRatio)
+	assert.Equal(t, 4.0, tiered.Completion)
+
+	modelRatio, tiered = ratios.ForPromptTokens(1, 128001)
+	assert.Equal(t, 2.0, modelRatio)
+	assert.Equal(t, 6.0, tiered.Completion)
+
+	// a tier leaving the completion ratio unset keeps the one below it
+	modelRatio, tiered = ratios.ForPromptTokens(1, 300000)
+	assert.Equal(t, 3.0, modelRatio)
+	assert.Equal(t, 6.0, tiered.Completion)
+
+	// and so does a tier leaving the model ratio unset
+	modelRatio, tiered = ratios.ForPromptTokens(1, 600000)
+	assert.Equal(t, 3.0, modelRatio)
+	assert.Equal(t, 8.0, tiered.Completion)
+
+	modelRatio, tiered = TokenRatios{Completion: 4}.ForPromptTokens(1, 1000000)
+	assert.Equal(t, 1.0, modelRatio)
+	assert.Equal(t, 4.0, tiered.Completion)
+}
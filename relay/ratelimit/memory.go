@@ -0,0 +1,67 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type windowKey struct {
+	key   string
+	start int64
+}
+
+// memoryStore keeps the windows in process, it is used when Redis is disabled
+type memoryStore struct {
+	sync.Mutex
+	windows map[windowKey]*Usage
+	// latest is the start of the latest window seen, windows before the previous one are dropped when it moves
+	latest int64
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{windows: make(map[windowKey]*Usage)}
+}
+
+// window returns the usage of the window, creating it if needed
+func (s *memoryStore) window(key string, windowStart time.Time) *Usage {
+	start := windowStart.Unix()
+	if start > s.latest {
+		s.latest = start
+		for k := range s.windows {
+			if k.start < start-int64(Window/time.Second) {
+				delete(s.windows, k)
+			}
+		}
+	}
+
+	k := windowKey{key: key, start: start}
+	usage, ok := s.windows[k]
+	if !ok {
+		usage = &Usage{}
+		s.windows[k] = usage
+	}
+	return usage
+}
+
+func (s *memoryStore) Reserve(_ context.Context, key string, windowStart time.Time, limits Limits, tokens int64) (Usage, bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	usage := s.window(key, windowStart)
+	before := *usage
+	if exceeds(limits, before, tokens) {
+		return before, false, nil
+	}
+	usage.Requests++
+	usage.Tokens += tokens
+	return before, true, nil
+}
+
+func (s *memoryStore) Add(_ context.Context, key string, windowStart time.Time, requests int64, tokens int64) error {
+	s.Lock()
+	defer s.Unlock()
+	usage := s.window(key, windowStart)
+	usage.Requests = max(usage.Requests+requests, 0)
+	usage.Tokens = max(usage.Tokens+tokens, 0)
+	return nil
+}
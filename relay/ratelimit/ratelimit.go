@@ -0,0 +1,267 @@
+// Package ratelimit limits the requests and tokens per minute of tokens, users and groups.
+//
+// Each scope counts requests and tokens in fixed one-minute windows. A request reserves the tokens it is
+// estimated to use in every scope before being relayed, and the reservation is settled with the tokens
+// actually used once the response is done. Counters are shared by all nodes through Redis if enabled.
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+// Window is the length of the windows in which requests and tokens are counted
+const Window = time.Minute
+
+// ErrLimited is returned when a request is over the limits of one of its scopes
+var ErrLimited = errors.New("rate limit exceeded")
+
+// Limits is the requests and tokens allowed per minute, 0 means unlimited
+type Limits struct {
+	RPM int64 `json:"rpm"`
+	TPM int64 `json:"tpm"`
+}
+
+// Enabled reports whether any limit is set
+func (l Limits) Enabled() bool {
+	return l.RPM > 0 || l.TPM > 0
+}
+
+// Validate checks that the limits are not negative
+func (l Limits) Validate() error {
+	if l.RPM < 0 || l.TPM < 0 {
+		return errors.Errorf("invalid rate limits: rpm %d, tpm %d", l.RPM, l.TPM)
+	}
+	return nil
+}
+
+// Usage is the requests and tokens counted in a window
+type Usage struct {
+	Requests int64
+	Tokens   int64
+}
+
+// exceeds reports whether one more request reserving tokens is over limits given the usage of the window.
+// A request estimated to use more tokens than the limit is let through when the window is empty,
+// otherwise it could never be sent.
+func exceeds(limits Limits, usage Usage, tokens int64) bool {
+	if limits.RPM > 0 && usage.Requests >= limits.RPM {
+		return true
+	}
+	if limits.TPM > 0 && (usage.Tokens >= limits.TPM || (usage.Tokens > 0 && usage.Tokens+tokens > limits.TPM)) {
+		return true
+	}
+	return false
+}
+
+// Store keeps the usage of each scope in each window
+type Store interface {
+	// Reserve counts a request and tokens in the window of key starting at windowStart unless it exceeds limits,
+	// it returns the usage of the window before the request
+	Reserve(ctx context.Context, key string, windowStart time.Time, limits Limits, tokens int64) (Usage, bool, error)
+	// Add adds requests and tokens, which could be negative, to the window of key starting at windowStart
+	Add(ctx context.Context, key string, windowStart time.Time, requests int64, tokens int64) error
+}
+
+var (
+	defaultStore     Store
+	defaultStoreOnce sync.Once
+	// now is replaced by tests
+	now = time.Now
+)
+
+// getStore returns the shared store, which is Redis if enabled so that all nodes count the same windows
+func getStore() Store {
+	defaultStoreOnce.Do(func() {
+		if defaultStore != nil {
+			return
+		}
+		if common.RedisEnabled {
+			defaultStore = newRedisStore(common.RDB)
+			return
+		}
+		defaultStore = newMemoryStore()
+	})
+	return defaultStore
+}
+
+// Scope is a subject of limits, e.g. a token
+type Scope struct {
+	Key    string
+	Limits Limits
+}
+
+// TokenScope returns the scope of the token
+func TokenScope(tokenId int, limits Limits) Scope {
+	return Scope{Key: fmt.Sprintf("token:%d", tokenId), Limits: limits}
+}
+
+// UserScope returns the scope of the user
+func UserScope(userId int, limits Limits) Scope {
+	return Scope{Key: fmt.Sprintf("user:%d", userId), Limits: limits}
+}
+
+// GroupScope returns the scope of the group, which is shared by all its users
+func GroupScope(group string, limits Limits) Scope {
+	return Scope{Key: "group:" + group, Limits: limits}
+}
+
+// Status is the state of the most restrictive limits of a request, reported in the x-ratelimit-* headers.
+// Limits of 0 are not set.
+type Status struct {
+	LimitRequests     int64
+	RemainingRequests int64
+	LimitTokens       int64
+	RemainingTokens   int64
+	// Reset is how long until the counters are reset
+	Reset time.Duration
+}
+
+// update takes the limits of scope into account if they are more restrictive than the ones seen so far
+func (s *Status) update(limits Limits, usage Usage) {
+	if limits.RPM > 0 {
+		remaining := max(limits.RPM-usage.Requests, 0)
+		if s.LimitRequests == 0 || remaining < s.RemainingRequests {
+			s.LimitRequests, s.RemainingRequests = limits.RPM, remaining
+		}
+	}
+	if limits.TPM > 0 {
+		remaining := max(limits.TPM-usage.Tokens, 0)
+		if s.LimitTokens == 0 || remaining < s.RemainingTokens {
+			s.LimitTokens, s.RemainingTokens = limits.TPM, remaining
+		}
+	}
+}
+
+// Reservation is the requests and tokens counted for a request, see Settle
+type Reservation struct {
+	scopes      []Scope
+	windowStart time.Time
+	tokens      int64
+}
+
+// Reserve counts a request estimated to use tokens in every scope with limits. It returns an error wrapping
+// ErrLimited if the request is over the limits of a scope, in which case nothing is counted.
+// Errors of the store are logged and the scope is skipped, so that an outage of Redis does not stop relaying.
+func Reserve(ctx context.Context, scopes []Scope, tokens int64) (*Reservation, Status, error) {
+	current := now()
+	windowStart := current.Truncate(Window)
+	status := Status{Reset: windowStart.Add(Window).Sub(current)}
+	reservation := &Reservation{windowStart: windowStart, tokens: tokens}
+
+	store := getStore()
+	for _, scope := range scopes {
+		if !scope.Limits.Enabled() {
+			continue
+		}
+
+		usage, ok, err := store.Reserve(ctx, scope.Key, windowStart, scope.Limits, tokens)
+		if err != nil {
+			logger.Errorf(ctx, "failed to reserve rate limit of %s: %+v", scope.Key, err)
+			continue
+		}
+		if !ok {
+			status.update(scope.Limits, usage)
+			reservation.Cancel(ctx)
+			return nil, status, errors.Wrapf(ErrLimited, "%s is over its limits, rpm %d, tpm %d",
+				scope.Key, scope.Limits.RPM, scope.Limits.TPM)
+		}
+
+		status.update(scope.Limits, Usage{Requests: usage.Requests + 1, Tokens: usage.Tokens + tokens})
+		reservation.scopes = append(reservation.scopes, scope)
+	}
+
+	return reservation, status, nil
+}
+
+// Cancel gives back the request and tokens reserved, e.g. when a later scope is over its limits
+func (r *Reservation) Cancel(ctx context.Context) {
+	if r == nil {
+		return
+	}
+	store := getStore()
+	for _, scope := range r.scopes {
+		if err := store.Add(ctx, scope.Key, r.windowStart, -1, -r.tokens); err != nil {
+			logger.Errorf(ctx, "failed to cancel rate limit reservation of %s: %+v", scope.Key, err)
+		}
+	}
+	r.scopes = nil
+}
+
+// Settle replaces the tokens reserved by the tokens the request used.
+// Once the window of the reservation is over, only tokens used beyond the reservation are counted,
+// in the current window.
+func (r *Reservation) Settle(ctx context.Context, tokens int64) {
+	if r == nil {
+		return
+	}
+	delta := tokens - r.tokens
+	windowStart := r.windowStart
+	if current := now().Truncate(Window); !current.Equal(windowStart) {
+		if delta <= 0 {
+			return
+		}
+		windowStart = current
+	}
+	if delta == 0 {
+		return
+	}
+
+	store := getStore()
+	for _, scope := range r.scopes {
+		if scope.Limits.TPM <= 0 {
+			continue
+		}
+		if err := store.Add(ctx, scope.Key, windowStart, 0, delta); err != nil {
+			logger.Errorf(ctx, "failed to settle rate limit of %s: %+v", scope.Key, err)
+		}
+	}
+}
+
+var groupLimitsLock sync.RWMutex
+
+// GroupLimits is the limits of each group, shared by all users of the group.
+// Groups not listed are not limited.
+var GroupLimits = map[string]Limits{}
+
+func GroupLimits2JSONString() string {
+	groupLimitsLock.RLock()
+	defer groupLimitsLock.RUnlock()
+	jsonBytes, err := json.Marshal(GroupLimits)
+	if err != nil {
+		logger.SysError("error marshalling group rate limits: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateGroupLimitsByJSONString(jsonStr string) error {
+	groupLimits := make(map[string]Limits)
+	if err := json.Unmarshal([]byte(jsonStr), &groupLimits); err != nil {
+		return errors.Wrap(err, "failed to parse group rate limits")
+	}
+	for group, limits := range groupLimits {
+		if err := limits.Validate(); err != nil {
+			return errors.Wrapf(err, "group %s", group)
+		}
+	}
+
+	groupLimitsLock.Lock()
+	defer groupLimitsLock.Unlock()
+	GroupLimits = groupLimits
+	return nil
+}
+
+// GetGroupLimits returns the limits of the group
+func GetGroupLimits(group string) Limits {
+	groupLimitsLock.RLock()
+	defer groupLimitsLock.RUnlock()
+	return GroupLimits[group]
+}
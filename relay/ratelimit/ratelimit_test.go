@@ -0,0 +1,114 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupTest uses a memory store and a clock under control of the test
+func setupTest(t *testing.T) *time.Time {
+	originalStore, originalNow := defaultStore, now
+	t.Cleanup(func() {
+		defaultStore, now = originalStore, originalNow
+	})
+
+	current := time.Date(2025, 1, 1, 0, 0, 10, 0, time.UTC)
+	defaultStore = newMemoryStore()
+	now = func() time.Time { return current }
+	return &current
+}
+
+func TestReserve(t *testing.T) {
+	current := setupTest(t)
+	ctx := context.Background()
+	scopes := []Scope{
+		TokenScope(1, Limits{RPM: 3}),
+		UserScope(1, Limits{TPM: 100}),
+		GroupScope("default", Limits{}),
+	}
+
+	reservation, status, err := Reserve(ctx, scopes, 40)
+	require.NoError(t, err)
+	assert.Equal(t, Status{
+		LimitRequests:     3,
+		RemainingRequests: 2,
+		LimitTokens:       100,
+		RemainingTokens:   60,
+		Reset:             50 * time.Second,
+	}, status)
+	// the request used fewer tokens than estimated
+	reservation.Settle(ctx, 10)
+
+	_, status, err = Reserve(ctx, scopes, 80)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), status.RemainingRequests)
+	assert.Equal(t, int64(10), status.RemainingTokens)
+
+	// over the tokens of the user, the reservation of the token is given back
+	_, status, err = Reserve(ctx, scopes, 20)
+	assert.ErrorIs(t, err, ErrLimited)
+	assert.Equal(t, int64(10), status.RemainingTokens)
+	_, status, err = Reserve(ctx, []Scope{scopes[0]}, 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), status.RemainingRequests)
+
+	// over the requests of the token
+	_, _, err = Reserve(ctx, scopes, 0)
+	assert.ErrorIs(t, err, ErrLimited)
+
+	// limits are reset with the window
+	*current = current.Add(Window)
+	_, status, err = Reserve(ctx, scopes, 500)
+	require.NoError(t, err, "a request larger than the limit is let through an empty window")
+	assert.Equal(t, int64(0), status.RemainingTokens)
+	_, _, err = Reserve(ctx, scopes, 1)
+	assert.ErrorIs(t, err, ErrLimited)
+}
+
+func TestReservation_Settle(t *testing.T) {
+	current := setupTest(t)
+	ctx := context.Background()
+	scopes := []Scope{TokenScope(1, Limits{TPM: 1000})}
+
+	reservation, _, err := Reserve(ctx, scopes, 100)
+	require.NoError(t, err)
+	reservation.Settle(ctx, 300)
+	_, status, err := Reserve(ctx, scopes, 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(700), status.RemainingTokens)
+
+	// once the window is over, only tokens used beyond the reservation are counted in the current one
+	reservation, _, err = Reserve(ctx, scopes, 100)
+	require.NoError(t, err)
+	*current = current.Add(Window)
+	reservation.Settle(ctx, 50)
+	_, status, err = Reserve(ctx, scopes, 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1000), status.RemainingTokens)
+
+	reservation, _, err = Reserve(ctx, scopes, 100)
+	require.NoError(t, err)
+	*current = current.Add(Window)
+	reservation.Settle(ctx, 400)
+	_, status, err = Reserve(ctx, scopes, 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(700), status.RemainingTokens)
+}
+
+func TestUpdateGroupLimitsByJSONString(t *testing.T) {
+	original := GroupLimits
+	defer func() { GroupLimits = original }()
+
+	require.NoError(t, UpdateGroupLimitsByJSONString(`{"default":{"rpm":60,"tpm":100000}}`))
+	assert.Equal(t, Limits{RPM: 60, TPM: 100000}, GetGroupLimits("default"))
+	assert.False(t, GetGroupLimits("vip").Enabled())
+	assert.JSONEq(t, `{"default":{"rpm":60,"tpm":100000}}`, GroupLimits2JSONString())
+
+	assert.Error(t, UpdateGroupLimitsByJSONString(`{"default":{"rpm":-1}}`))
+	assert.Error(t, UpdateGroupLimitsByJSONString(`[]`))
+	assert.Equal(t, int64(60), GetGroupLimits("default").RPM)
+}
@@ -0,0 +1,73 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+const redisKeyPrefix = "rate_limit:"
+
+// reserveScript counts a request and ARGV[3] tokens in the window unless it exceeds the limits, see exceeds.
+// It returns whether the request is counted and the usage of the window before it.
+var reserveScript = redis.NewScript(`
+local requests = tonumber(redis.call('HGET', KEYS[1], 'requests') or '0')
+local tokens = tonumber(redis.call('HGET', KEYS[1], 'tokens') or '0')
+local rpm, tpm, reserve = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3])
+if (rpm > 0 and requests >= rpm) or (tpm > 0 and (tokens >= tpm or (tokens > 0 and tokens + reserve > tpm))) then
+	return {0, requests, tokens}
+end
+redis.call('HINCRBY', KEYS[1], 'requests', 1)
+redis.call('HINCRBY', KEYS[1], 'tokens', reserve)
+redis.call('PEXPIRE', KEYS[1], ARGV[4])
+return {1, requests, tokens}
+`)
+
+// redisStore keeps each window in a Redis hash, so that all nodes share the limits
+type redisStore struct {
+	rdb redis.Cmdable
+}
+
+func newRedisStore(rdb redis.Cmdable) *redisStore {
+	return &redisStore{rdb: rdb}
+}
+
+func redisKey(key string, windowStart time.Time) string {
+	return fmt.Sprintf("%s%s:%d", redisKeyPrefix, key, windowStart.Unix())
+}
+
+// ttl keeps a window until the next one is over, so that late settlements find it
+const ttl = 2 * Window
+
+func (s *redisStore) Reserve(ctx context.Context, key string, windowStart time.Time, limits Limits, tokens int64) (Usage, bool, error) {
+	result, err := reserveScript.Run(ctx, s.rdb, []string{redisKey(key, windowStart)},
+		limits.RPM, limits.TPM, tokens, ttl.Milliseconds()).Int64Slice()
+	if err != nil {
+		return Usage{}, false, errors.Wrap(err, "failed to reserve rate limit")
+	}
+	if len(result) != 3 {
+		return Usage{}, false, errors.Errorf("unexpected result of rate limit script: %v", result)
+	}
+	return Usage{Requests: result[1], Tokens: result[2]}, result[0] == 1, nil
+}
+
+func (s *redisStore) Add(ctx context.Context, key string, windowStart time.Time, requests int64, tokens int64) error {
+	redisKey := redisKey(key, windowStart)
+	_, err := s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if requests != 0 {
+			pipe.HIncrBy(ctx, redisKey, "requests", requests)
+		}
+		if tokens != 0 {
+			pipe.HIncrBy(ctx, redisKey, "tokens", tokens)
+		}
+		pipe.PExpire(ctx, redisKey, ttl)
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to update rate limit")
+	}
+	return nil
+}
@@ -17,4 +17,14 @@ const (
 	ImagesEdits
 	// ResponseAPI is for OpenAI Response API direct requests
 	ResponseAPI
+	// ClaudeMessages is for native Anthropic Messages API requests
+	ClaudeMessages
+	// GeminiGenerateContent is for native Gemini generateContent/streamGenerateContent requests
+	GeminiGenerateContent
+	// Assistants is for the OpenAI Assistants API, which is proxied to the channel owning the objects
+	Assistants
+	// FineTuning is for the OpenAI fine-tuning jobs API, which is proxied to the channel owning the jobs
+	FineTuning
+	// Realtime is for the OpenAI Realtime API over WebSocket
+	Realtime
 )
@@ -8,6 +8,16 @@ func GetByPath(path string) int {
 		relayMode = Proxy
 	} else if strings.HasPrefix(path, "/v1/responses") {
 		relayMode = ResponseAPI
+	} else if strings.HasPrefix(path, "/v1/messages") {
+		relayMode = ClaudeMessages
+	} else if strings.HasPrefix(path, "/v1beta/models/") {
+		relayMode = GeminiGenerateContent
+	} else if strings.HasPrefix(path, "/v1/assistants") || strings.HasPrefix(path, "/v1/threads") {
+		relayMode = Assistants
+	} else if strings.HasPrefix(path, "/v1/fine_tuning") {
+		relayMode = FineTuning
+	} else if strings.HasPrefix(path, "/v1/realtime") {
+		relayMode = Realtime
 	} else if strings.HasPrefix(path, "/v1/chat/completions") {
 		relayMode = ChatCompletions
 	} else if strings.HasPrefix(path, "/v1/completions") {
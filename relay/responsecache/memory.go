@@ -0,0 +1,73 @@
+package responsecache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// memoryStore keeps entries in process, the least recently used ones are evicted beyond maxEntries
+type memoryStore struct {
+	sync.Mutex
+	maxEntries int
+	ll         *list.List
+	items      map[string]*list.Element
+	now        func() time.Time
+}
+
+type memoryItem struct {
+	key       string
+	entry     *Entry
+	expiresAt time.Time
+}
+
+func newMemoryStore(maxEntries int) *memoryStore {
+	return &memoryStore{
+		maxEntries: maxEntries,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+		now:        time.Now,
+	}
+}
+
+func (s *memoryStore) Get(_ context.Context, key string) (*Entry, error) {
+	s.Lock()
+	defer s.Unlock()
+	elem, ok := s.items[key]
+	if !ok {
+		return nil, nil
+	}
+	item := elem.Value.(*memoryItem)
+	if !s.now().Before(item.expiresAt) {
+		s.ll.Remove(elem)
+		delete(s.items, key)
+		return nil, nil
+	}
+
+	s.ll.MoveToFront(elem)
+	return item.entry, nil
+}
+
+func (s *memoryStore) Set(_ context.Context, key string, entry *Entry, ttl time.Duration) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.maxEntries <= 0 {
+		return nil
+	}
+
+	item := &memoryItem{key: key, entry: entry, expiresAt: s.now().Add(ttl)}
+	if elem, ok := s.items[key]; ok {
+		elem.Value = item
+		s.ll.MoveToFront(elem)
+		return nil
+	}
+	s.items[key] = s.ll.PushFront(item)
+	for s.ll.Len() > s.maxEntries {
+		oldest := s.ll.Back()
+		s.ll.Remove(oldest)
+		delete(s.items, oldest.Value.(*memoryItem).key)
+	}
+
+	return nil
+}
@@ -0,0 +1,46 @@
+package responsecache
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+const redisKeyPrefix = "response_cache:"
+
+// redisStore keeps entries in Redis, which expires them by ttl
+type redisStore struct {
+	rdb redis.Cmdable
+}
+
+func newRedisStore(rdb redis.Cmdable) *redisStore {
+	return &redisStore{rdb: rdb}
+}
+
+func (s *redisStore) Get(ctx context.Context, key string) (*Entry, error) {
+	value, err := s.rdb.Get(ctx, redisKeyPrefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get cached response")
+	}
+
+	entry := &Entry{}
+	if err = json.Unmarshal(value, entry); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal cached response")
+	}
+	return entry, nil
+}
+
+func (s *redisStore) Set(ctx context.Context, key string, entry *Entry, ttl time.Duration) error {
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal cached response")
+	}
+
+	return errors.Wrap(s.rdb.Set(ctx, redisKeyPrefix+key, value, ttl).Err(), "failed to cache response")
+}
@@ -0,0 +1,186 @@
+// Package responsecache caches responses of chat completions and embeddings,
+// so that identical requests resent by the same user are answered without calling upstream.
+package responsecache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Laisky/errors/v2"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+const (
+	// ModeDisabled turns the cache off, it is used by tokens to opt out of the cache of their group
+	ModeDisabled = "disabled"
+	// ModeExact caches every request, hits require the same request except for fields not affecting the output
+	ModeExact = "exact"
+	// ModeDeterministic only caches requests whose output is reproducible,
+	// which are embeddings and chat completions with temperature 0.
+	ModeDeterministic = "deterministic"
+)
+
+// IsValidMode reports whether mode could be set on tokens and groups, empty means following the group
+func IsValidMode(mode string) bool {
+	switch mode {
+	case "", ModeDisabled, ModeExact, ModeDeterministic:
+		return true
+	default:
+		return false
+	}
+}
+
+var groupModesLock sync.RWMutex
+
+// GroupModes is the cache mode of each group, groups not listed do not use the cache
+var GroupModes = map[string]string{}
+
+func GroupModes2JSONString() string {
+	groupModesLock.RLock()
+	defer groupModesLock.RUnlock()
+	jsonBytes, err := json.Marshal(GroupModes)
+	if err != nil {
+		logger.SysError("error marshalling response cache group modes: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateGroupModesByJSONString(jsonStr string) error {
+	modes := make(map[string]string)
+	if err := json.Unmarshal([]byte(jsonStr), &modes); err != nil {
+		return errors.Wrap(err, "failed to parse response cache group modes")
+	}
+	for group, mode := range modes {
+		if !IsValidMode(mode) {
+			return errors.Errorf("invalid response cache mode %q of group %s", mode, group)
+		}
+	}
+
+	groupModesLock.Lock()
+	defer groupModesLock.Unlock()
+	GroupModes = modes
+	return nil
+}
+
+// GetMode returns the cache mode of a request, the mode of the token overrides the one of the group
+func GetMode(tokenMode, group string) string {
+	mode := tokenMode
+	if mode == "" {
+		groupModesLock.RLock()
+		mode = GroupModes[group]
+		groupModesLock.RUnlock()
+	}
+	if mode == ModeExact || mode == ModeDeterministic {
+		return mode
+	}
+
+	return ModeDisabled
+}
+
+// IsCacheable reports whether the response of request could be cached in mode
+func IsCacheable(mode string, relayMode int, request *relaymodel.GeneralOpenAIRequest) bool {
+	switch relayMode {
+	case relaymode.ChatCompletions, relaymode.Embeddings:
+	default:
+		return false
+	}
+
+	switch mode {
+	case ModeExact:
+		return true
+	case ModeDeterministic:
+		if relayMode == relaymode.Embeddings {
+			return true
+		}
+		// web search results change over time
+		return request.Temperature != nil && *request.Temperature == 0 &&
+			request.WebSearchOptions == nil
+	default:
+		return false
+	}
+}
+
+// Key returns the cache key of request sent by user.
+//
+// The request is normalized by dropping the fields which do not affect the output,
+// so a request is also answered by the cached response of its stream or non-stream counterpart.
+// Sampling fields are ignored in ModeDeterministic since the output is decided by temperature 0.
+func Key(userId int, relayMode int, mode string, request *relaymodel.GeneralOpenAIRequest) (string, error) {
+	normalized := *request
+	normalized.Stream = false
+	normalized.StreamOptions = nil
+	normalized.User = ""
+	normalized.Metadata = nil
+	normalized.ServiceTier = nil
+	if mode == ModeDeterministic {
+		normalized.TopP = nil
+		normalized.TopK = 0
+		normalized.Seed = 0
+	}
+
+	// struct fields are marshalled in order and map keys are sorted, so the result is canonical
+	body, err := json.Marshal(&normalized)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to marshal request")
+	}
+	hash := sha256.Sum256(body)
+
+	return fmt.Sprintf("%d:%d:%s", userId, relayMode, hex.EncodeToString(hash[:])), nil
+}
+
+// Entry is a cached response
+type Entry struct {
+	// Body is the response in the non-stream format of OpenAI
+	Body      json.RawMessage  `json:"body"`
+	Usage     relaymodel.Usage `json:"usage"`
+	ChannelId int              `json:"channel_id"`
+	CreatedAt int64            `json:"created_at"`
+}
+
+// Store saves entries until they expire
+type Store interface {
+	Get(ctx context.Context, key string) (*Entry, error)
+	Set(ctx context.Context, key string, entry *Entry, ttl time.Duration) error
+}
+
+var (
+	defaultStore     Store
+	defaultStoreOnce sync.Once
+)
+
+// getStore returns the shared store, which is Redis if enabled so that all nodes share the cache
+func getStore() Store {
+	defaultStoreOnce.Do(func() {
+		if common.RedisEnabled {
+			defaultStore = newRedisStore(common.RDB)
+			return
+		}
+		defaultStore = newMemoryStore(config.ResponseCacheMaxEntries)
+	})
+	return defaultStore
+}
+
+// Get returns the cached response of key, or nil if there is none
+func Get(ctx context.Context, key string) (*Entry, error) {
+	return getStore().Get(ctx, key)
+}
+
+// Set caches the response of key, responses larger than the size limit are skipped
+func Set(ctx context.Context, key string, entry *Entry) error {
+	if len(entry.Body) > config.ResponseCacheMaxEntrySizeKB*1024 {
+		logger.Debugf(ctx, "response of %d bytes is too large to cache", len(entry.Body))
+		return nil
+	}
+
+	return getStore().Set(ctx, key, entry, time.Duration(config.ResponseCacheTTL)*time.Second)
+}
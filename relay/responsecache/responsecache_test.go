@@ -0,0 +1,134 @@
+package responsecache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+func float64Ptr(f float64) *float64 {
+	return &f
+}
+
+func newChatRequest(content string) *relaymodel.GeneralOpenAIRequest {
+	return &relaymodel.GeneralOpenAIRequest{
+		Model:       "gpt-4o-mini",
+		Messages:    []relaymodel.Message{{Role: "user", Content: content}},
+		Temperature: float64Ptr(0),
+	}
+}
+
+func TestKey(t *testing.T) {
+	base, err := Key(1, relaymode.ChatCompletions, ModeExact, newChatRequest("hi"))
+	require.NoError(t, err)
+
+	// fields not affecting the output are ignored
+	request := newChatRequest("hi")
+	request.Stream = true
+	request.StreamOptions = &relaymodel.StreamOptions{IncludeUsage: true}
+	request.User = "end-user"
+	key, err := Key(1, relaymode.ChatCompletions, ModeExact, request)
+	require.NoError(t, err)
+	assert.Equal(t, base, key)
+
+	key, err = Key(1, relaymode.ChatCompletions, ModeExact, newChatRequest("hello"))
+	require.NoError(t, err)
+	assert.NotEqual(t, base, key)
+
+	// responses are not shared between users
+	key, err = Key(2, relaymode.ChatCompletions, ModeExact, newChatRequest("hi"))
+	require.NoError(t, err)
+	assert.NotEqual(t, base, key)
+
+	request = newChatRequest("hi")
+	request.TopP = float64Ptr(0.5)
+	key, err = Key(1, relaymode.ChatCompletions, ModeExact, request)
+	require.NoError(t, err)
+	assert.NotEqual(t, base, key)
+
+	// sampling fields make no difference with temperature 0
+	deterministic, err := Key(1, relaymode.ChatCompletions, ModeDeterministic, newChatRequest("hi"))
+	require.NoError(t, err)
+	key, err = Key(1, relaymode.ChatCompletions, ModeDeterministic, request)
+	require.NoError(t, err)
+	assert.Equal(t, deterministic, key)
+}
+
+func TestIsCacheable(t *testing.T) {
+	randomRequest := newChatRequest("hi")
+	randomRequest.Temperature = float64Ptr(0.7)
+	searchRequest := newChatRequest("hi")
+	searchRequest.WebSearchOptions = &relaymodel.WebSearchOptions{}
+	embeddingRequest := &relaymodel.GeneralOpenAIRequest{Model: "text-embedding-3-small", Input: "hi"}
+
+	tests := []struct {
+		name      string
+		mode      string
+		relayMode int
+		request   *relaymodel.GeneralOpenAIRequest
+		expected  bool
+	}{
+		{"exact_chat", ModeExact, relaymode.ChatCompletions, randomRequest, true},
+		{"exact_embeddings", ModeExact, relaymode.Embeddings, embeddingRequest, true},
+		{"deterministic_temperature_0", ModeDeterministic, relaymode.ChatCompletions, newChatRequest("hi"), true},
+		{"deterministic_temperature_0.7", ModeDeterministic, relaymode.ChatCompletions, randomRequest, false},
+		{"deterministic_default_temperature", ModeDeterministic, relaymode.ChatCompletions, &relaymodel.GeneralOpenAIRequest{Model: "gpt-4o"}, false},
+		{"deterministic_web_search", ModeDeterministic, relaymode.ChatCompletions, searchRequest, false},
+		{"deterministic_embeddings", ModeDeterministic, relaymode.Embeddings, embeddingRequest, true},
+		{"disabled", ModeDisabled, relaymode.ChatCompletions, newChatRequest("hi"), false},
+		{"unsupported_relay_mode", ModeExact, relaymode.Completions, newChatRequest("hi"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, IsCacheable(tt.mode, tt.relayMode, tt.request))
+		})
+	}
+}
+
+func TestGetMode(t *testing.T) {
+	original := GroupModes2JSONString()
+	t.Cleanup(func() { require.NoError(t, UpdateGroupModesByJSONString(original)) })
+	require.NoError(t, UpdateGroupModesByJSONString(`{"agents":"exact","default":"deterministic"}`))
+	require.Error(t, UpdateGroupModesByJSONString(`{"agents":"semantic"}`))
+
+	assert.Equal(t, ModeExact, GetMode("", "agents"))
+	assert.Equal(t, ModeDeterministic, GetMode("", "default"))
+	assert.Equal(t, ModeDisabled, GetMode("", "vip"))
+	assert.Equal(t, ModeExact, GetMode(ModeExact, "vip"))
+	assert.Equal(t, ModeDisabled, GetMode(ModeDisabled, "agents"))
+}
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+	store := newMemoryStore(2)
+	store.now = func() time.Time { return now }
+
+	require.NoError(t, store.Set(ctx, "a", &Entry{ChannelId: 1}, time.Minute))
+	require.NoError(t, store.Set(ctx, "b", &Entry{ChannelId: 2}, time.Hour))
+	entry, err := store.Get(ctx, "a")
+	require.NoError(t, err)
+	require.NotNil(t, entry)
+	assert.Equal(t, 1, entry.ChannelId)
+
+	// b is the least recently used one
+	require.NoError(t, store.Set(ctx, "c", &Entry{ChannelId: 3}, time.Hour))
+	entry, err = store.Get(ctx, "b")
+	require.NoError(t, err)
+	assert.Nil(t, entry)
+
+	now = now.Add(2 * time.Minute)
+	entry, err = store.Get(ctx, "a")
+	require.NoError(t, err)
+	assert.Nil(t, entry)
+	entry, err = store.Get(ctx, "c")
+	require.NoError(t, err)
+	require.NotNil(t, entry)
+	assert.Equal(t, 3, entry.ChannelId)
+}
@@ -0,0 +1,111 @@
+// Package routing chooses a channel among the candidates of the same priority tier
+// by the latency, load or success rate that the channel has shown recently.
+package routing
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/Laisky/errors/v2"
+
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+const (
+	// StrategyRandom picks a random channel by weight, it is the default strategy
+	StrategyRandom = "random"
+	// StrategyLeastLatency picks the channel with the lowest EWMA latency
+	StrategyLeastLatency = "least_latency"
+	// StrategyLeastInFlight picks the channel with the fewest requests in flight per unit of weight
+	StrategyLeastInFlight = "least_in_flight"
+	// StrategySuccessRate picks by weight among the channels with the highest EWMA success rate
+	StrategySuccessRate = "ewma_success_rate"
+)
+
+// IsValidStrategy reports whether strategy could be set on groups and models
+func IsValidStrategy(strategy string) bool {
+	switch strategy {
+	case StrategyRandom, StrategyLeastLatency, StrategyLeastInFlight, StrategySuccessRate:
+		return true
+	default:
+		return false
+	}
+}
+
+var strategiesLock sync.RWMutex
+
+// GroupStrategies is the routing strategy of each group, groups not listed use StrategyRandom
+var GroupStrategies = map[string]string{}
+
+// ModelStrategies is the routing strategy of each model, it overrides the strategy of the group
+var ModelStrategies = map[string]string{}
+
+func GroupStrategies2JSONString() string {
+	return strategies2JSONString(GroupStrategies)
+}
+
+func ModelStrategies2JSONString() string {
+	return strategies2JSONString(ModelStrategies)
+}
+
+func UpdateGroupStrategiesByJSONString(jsonStr string) error {
+	strategies, err := parseStrategies(jsonStr)
+	if err != nil {
+		return errors.Wrap(err, "failed to update group routing strategies")
+	}
+
+	strategiesLock.Lock()
+	defer strategiesLock.Unlock()
+	GroupStrategies = strategies
+	return nil
+}
+
+func UpdateModelStrategiesByJSONString(jsonStr string) error {
+	strategies, err := parseStrategies(jsonStr)
+	if err != nil {
+		return errors.Wrap(err, "failed to update model routing strategies")
+	}
+
+	strategiesLock.Lock()
+	defer strategiesLock.Unlock()
+	ModelStrategies = strategies
+	return nil
+}
+
+// GetStrategy returns the routing strategy of model requested by group
+func GetStrategy(group, model string) string {
+	strategiesLock.RLock()
+	defer strategiesLock.RUnlock()
+	if strategy, ok := ModelStrategies[model]; ok {
+		return strategy
+	}
+	if strategy, ok := GroupStrategies[group]; ok {
+		return strategy
+	}
+
+	return StrategyRandom
+}
+
+func strategies2JSONString(strategies map[string]string) string {
+	strategiesLock.RLock()
+	defer strategiesLock.RUnlock()
+	jsonBytes, err := json.Marshal(strategies)
+	if err != nil {
+		logger.SysError("error marshalling routing strategies: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func parseStrategies(jsonStr string) (map[string]string, error) {
+	strategies := make(map[string]string)
+	if err := json.Unmarshal([]byte(jsonStr), &strategies); err != nil {
+		return nil, errors.Wrap(err, "failed to parse routing strategies")
+	}
+	for name, strategy := range strategies {
+		if !IsValidStrategy(strategy) {
+			return nil, errors.Errorf("invalid routing strategy %q of %s", strategy, name)
+		}
+	}
+
+	return strategies, nil
+}
@@ -0,0 +1,180 @@
+package routing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock is advanced by the test instead of by time passing
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+// outcome is a fake result of a request served by a channel
+type outcome struct {
+	channelId int
+	success   bool
+	latency   time.Duration
+}
+
+// newTestTracker returns a tracker on a fake clock, which breaks ties by taking the first tied candidate
+func newTestTracker(halfLife time.Duration) (*Tracker, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	tracker := NewTracker(halfLife)
+	tracker.now = clock.Now
+	tracker.random = func(n int64) int64 { return 0 }
+	return tracker, clock
+}
+
+// feed reports outcomes of model to tracker, one second apart
+func feed(tracker *Tracker, clock *fakeClock, model string, outcomes ...outcome) {
+	for _, o := range outcomes {
+		tracker.Observe(o.channelId, model, o.success, o.latency)
+		clock.Advance(time.Second)
+	}
+}
+
+func candidatesOf(channelIds ...int) []Candidate {
+	candidates := make([]Candidate, len(channelIds))
+	for i, channelId := range channelIds {
+		candidates[i] = Candidate{ChannelId: channelId, Weight: 1}
+	}
+	return candidates
+}
+
+func TestSelectLeastLatency(t *testing.T) {
+	tracker, clock := newTestTracker(5 * time.Minute)
+	candidates := candidatesOf(1, 2, 3)
+	feed(tracker, clock, "gpt-4o",
+		outcome{channelId: 1, success: true, latency: 900 * time.Millisecond},
+		outcome{channelId: 2, success: true, latency: 300 * time.Millisecond},
+		outcome{channelId: 3, success: true, latency: 600 * time.Millisecond},
+	)
+	assert.Equal(t, 1, tracker.Select(StrategyLeastLatency, "gpt-4o", candidates))
+
+	// channel 2 slows down, the moving average follows it
+	for i := 0; i < 10; i++ {
+		feed(tracker, clock, "gpt-4o", outcome{channelId: 2, success: true, latency: 2 * time.Second})
+	}
+	assert.Equal(t, 2, tracker.Select(StrategyLeastLatency, "gpt-4o", candidates))
+
+	// stats are kept per model
+	assert.Equal(t, 0, tracker.Select(StrategyLeastLatency, "gpt-4o-mini", candidates))
+}
+
+func TestSelectLeastLatencyAvoidsFailingChannels(t *testing.T) {
+	tracker, clock := newTestTracker(5 * time.Minute)
+	candidates := candidatesOf(1, 2)
+	feed(tracker, clock, "gpt-4o",
+		outcome{channelId: 1, success: true, latency: 800 * time.Millisecond},
+		outcome{channelId: 2, success: true, latency: 200 * time.Millisecond},
+		outcome{channelId: 2, success: false, latency: 10 * time.Millisecond},
+		outcome{channelId: 2, success: false, latency: 10 * time.Millisecond},
+	)
+	assert.Equal(t, 0, tracker.Select(StrategyLeastLatency, "gpt-4o", candidates))
+
+	// the failures fade, so channel 2 is probed again
+	clock.Advance(30 * time.Minute)
+	assert.Equal(t, 1, tracker.Select(StrategyLeastLatency, "gpt-4o", candidates))
+}
+
+func TestSelectLeastInFlight(t *testing.T) {
+	tracker, _ := newTestTracker(5 * time.Minute)
+	candidates := []Candidate{
+		{ChannelId: 1, Weight: 1},
+		{ChannelId: 2, Weight: 4},
+	}
+
+	tracker.Start(1)
+	tracker.Start(2)
+	tracker.Start(2)
+	// 1 per unit of weight against 0.5
+	assert.Equal(t, 1, tracker.Select(StrategyLeastInFlight, "gpt-4o", candidates))
+
+	tracker.Start(2)
+	tracker.Start(2)
+	tracker.Start(2)
+	// 1 against 1.25
+	assert.Equal(t, 0, tracker.Select(StrategyLeastInFlight, "gpt-4o", candidates))
+
+	for i := 0; i < 5; i++ {
+		tracker.Done(2)
+	}
+	assert.Equal(t, 1, tracker.Select(StrategyLeastInFlight, "gpt-4o", candidates))
+
+	// extra Done calls do not go below zero
+	tracker.Done(2)
+	tracker.Done(2)
+	assert.Empty(t, tracker.inFlight[2])
+}
+
+func TestSelectSuccessRate(t *testing.T) {
+	tracker, clock := newTestTracker(5 * time.Minute)
+	candidates := candidatesOf(1, 2, 3)
+	feed(tracker, clock, "gpt-4o",
+		outcome{channelId: 1, success: false},
+		outcome{channelId: 1, success: false},
+		outcome{channelId: 2, success: true, latency: time.Second},
+		outcome{channelId: 3, success: false},
+	)
+	// channel 2 is the only one without failures
+	assert.Equal(t, 1, tracker.Select(StrategySuccessRate, "gpt-4o", candidates))
+
+	// channel 3 recovers first, since it failed less
+	clock.Advance(10 * time.Minute)
+	tracker.random = func(n int64) int64 { return n - 1 }
+	assert.Equal(t, 2, tracker.Select(StrategySuccessRate, "gpt-4o", candidates))
+
+	// healthy channels share traffic by weight
+	clock.Advance(time.Hour)
+	weighted := []Candidate{
+		{ChannelId: 1, Weight: 1},
+		{ChannelId: 2, Weight: 2},
+		{ChannelId: 3, Weight: 1},
+	}
+	picks := make(map[int]int)
+	for n := int64(0); n < 4; n++ {
+		tracker.random = func(int64) int64 { return n }
+		picks[tracker.Select(StrategySuccessRate, "gpt-4o", weighted)]++
+	}
+	assert.Equal(t, map[int]int{0: 1, 1: 2, 2: 1}, picks)
+}
+
+func TestDecay(t *testing.T) {
+	tracker, clock := newTestTracker(time.Minute)
+	feed(tracker, clock, "gpt-4o", outcome{channelId: 1, success: false})
+	s := tracker.stats[statsKey{channelId: 1, model: "gpt-4o"}]
+	require.NotNil(t, s)
+
+	_, successRate := tracker.decay(s, s.updatedAt)
+	assert.InDelta(t, 0.8, successRate, 1e-9)
+	_, successRate = tracker.decay(s, s.updatedAt.Add(time.Minute))
+	assert.InDelta(t, 0.9, successRate, 1e-9)
+	_, successRate = tracker.decay(s, s.updatedAt.Add(2*time.Minute))
+	assert.InDelta(t, 0.95, successRate, 1e-9)
+}
+
+func TestGetStrategy(t *testing.T) {
+	originalGroups, originalModels := GroupStrategies, ModelStrategies
+	defer func() { GroupStrategies, ModelStrategies = originalGroups, originalModels }()
+
+	require.NoError(t, UpdateGroupStrategiesByJSONString(`{"vip":"least_latency"}`))
+	require.NoError(t, UpdateModelStrategiesByJSONString(`{"gpt-4o":"least_in_flight"}`))
+	assert.Error(t, UpdateModelStrategiesByJSONString(`{"gpt-4o":"fastest"}`))
+
+	assert.Equal(t, StrategyLeastInFlight, GetStrategy("vip", "gpt-4o"))
+	assert.Equal(t, StrategyLeastLatency, GetStrategy("vip", "gpt-4o-mini"))
+	assert.Equal(t, StrategyRandom, GetStrategy("default", "gpt-4o-mini"))
+	assert.JSONEq(t, `{"vip":"least_latency"}`, GroupStrategies2JSONString())
+}
@@ -0,0 +1,188 @@
+package routing
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"sync"
+
+	"github.com/Laisky/errors/v2"
+
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+const (
+	// SessionSourceHeader takes the session key from the SessionHeader header
+	SessionSourceHeader = "header"
+	// SessionSourceUser takes the session key from the user field of the request,
+	// or metadata.user_id of Claude Messages requests
+	SessionSourceUser = "user"
+	// SessionSourceMessages takes the session key from the hash of the leading messages of the request
+	SessionSourceMessages = "messages"
+)
+
+// SessionHeader is the header carrying the session key chosen by the caller
+const SessionHeader = "X-Session-Id"
+
+// IsValidSessionSource reports whether source could be set on groups
+func IsValidSessionSource(source string) bool {
+	switch source {
+	case SessionSourceHeader, SessionSourceUser, SessionSourceMessages:
+		return true
+	default:
+		return false
+	}
+}
+
+var sessionSourcesLock sync.RWMutex
+
+// GroupSessionSources is the sources of the sticky session key of each group, tried in order.
+// Requests of groups not listed are not routed by session.
+var GroupSessionSources = map[string][]string{}
+
+func GroupSessionSources2JSONString() string {
+	sessionSourcesLock.RLock()
+	defer sessionSourcesLock.RUnlock()
+	jsonBytes, err := json.Marshal(GroupSessionSources)
+	if err != nil {
+		logger.SysError("error marshalling sticky session sources: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateGroupSessionSourcesByJSONString(jsonStr string) error {
+	groupSources := make(map[string][]string)
+	if err := json.Unmarshal([]byte(jsonStr), &groupSources); err != nil {
+		return errors.Wrap(err, "failed to parse sticky session sources")
+	}
+	for group, sources := range groupSources {
+		for _, source := range sources {
+			if !IsValidSessionSource(source) {
+				return errors.Errorf("invalid sticky session source %q of group %s", source, group)
+			}
+		}
+	}
+
+	sessionSourcesLock.Lock()
+	defer sessionSourcesLock.Unlock()
+	GroupSessionSources = groupSources
+	return nil
+}
+
+// sessionRequest is the part of the request bodies of the supported APIs identifying a session
+type sessionRequest struct {
+	User     string `json:"user"`
+	Metadata struct {
+		UserId string `json:"user_id"`
+	} `json:"metadata"`
+	System   json.RawMessage   `json:"system"`
+	Messages []json.RawMessage `json:"messages"`
+	Input    json.RawMessage   `json:"input"`
+}
+
+// SessionKey returns the sticky session key of a request of group with header and its JSON body,
+// it is empty if the group is not routed by session or the request carries none of the sources of the group
+func SessionKey(group string, header http.Header, body []byte) string {
+	sessionSourcesLock.RLock()
+	sources := GroupSessionSources[group]
+	sessionSourcesLock.RUnlock()
+	if len(sources) == 0 {
+		return ""
+	}
+
+	var request *sessionRequest
+	for _, source := range sources {
+		if source == SessionSourceHeader {
+			if value := header.Get(SessionHeader); value != "" {
+				return source + ":" + value
+			}
+			continue
+		}
+
+		if request == nil {
+			// bodies which are not JSON carry no session
+			request = &sessionRequest{}
+			_ = json.Unmarshal(body, request)
+		}
+		switch source {
+		case SessionSourceUser:
+			if request.User != "" {
+				return source + ":" + request.User
+			}
+			if request.Metadata.UserId != "" {
+				return source + ":" + request.Metadata.UserId
+			}
+		case SessionSourceMessages:
+			if hash := request.leadingMessagesHash(); hash != "" {
+				return source + ":" + hash
+			}
+		}
+	}
+
+	return ""
+}
+
+// leadingMessagesHash hashes the system prompt and the messages up to the first user message,
+// which are the same in every turn of a conversation. Response API requests are identified by their input.
+func (r *sessionRequest) leadingMessagesHash() string {
+	messages := r.Messages
+	if len(messages) == 0 && len(r.Input) > 0 {
+		if err := json.Unmarshal(r.Input, &messages); err != nil {
+			// a plain text input
+			messages = []json.RawMessage{r.Input}
+		}
+	}
+	if len(messages) == 0 {
+		return ""
+	}
+
+	hash := sha256.New()
+	hash.Write(r.System)
+	for _, message := range messages {
+		hash.Write([]byte{0})
+		hash.Write(message)
+
+		var role struct {
+			Role string `json:"role"`
+		}
+		if err := json.Unmarshal(message, &role); err == nil && role.Role == "user" {
+			break
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// PickBySession returns the index of the candidate chosen for the session key by weighted rendezvous hashing,
+// candidates must not be empty.
+//
+// The same candidate is chosen for a key as long as it is among the candidates,
+// and a key moves to another candidate only when its candidate is gone or a new one outranks it.
+func PickBySession(key string, candidates []Candidate) int {
+	keyHash := fnv.New64a()
+	keyHash.Write([]byte(key))
+	seed := keyHash.Sum64()
+
+	best, bestScore := 0, math.Inf(-1)
+	for i, candidate := range candidates {
+		// map the hash of the key and the channel to (0, 1)
+		u := (float64(mix64(seed^mix64(uint64(candidate.ChannelId)))>>11) + 0.5) / (1 << 53)
+		score := float64(candidate.Weight) / -math.Log(u)
+		if score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return best
+}
+
+// mix64 is the finalizer of splitmix64, which spreads similar inputs over the whole range
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,110 @@
+package routing
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setSessionSources(t *testing.T, jsonStr string) {
+	original := GroupSessionSources2JSONString()
+	t.Cleanup(func() {
+		require.NoError(t, UpdateGroupSessionSourcesByJSONString(original))
+	})
+	require.NoError(t, UpdateGroupSessionSourcesByJSONString(jsonStr))
+}
+
+func TestUpdateGroupSessionSourcesByJSONString(t *testing.T) {
+	setSessionSources(t, `{"default": ["header", "user", "messages"]}`)
+	assert.Equal(t, []string{"header", "user", "messages"}, GroupSessionSources["default"])
+
+	assert.Error(t, UpdateGroupSessionSourcesByJSONString(`{"default": ["cookie"]}`))
+	assert.Error(t, UpdateGroupSessionSourcesByJSONString(`{"default": "user"}`))
+	assert.Equal(t, []string{"header", "user", "messages"}, GroupSessionSources["default"])
+}
+
+func TestSessionKey(t *testing.T) {
+	setSessionSources(t, `{"default": ["header", "user"], "vip": ["messages"]}`)
+	header := http.Header{}
+	body := []byte(`{"model":"gpt-4o","user":"alice","messages":[{"role":"user","content":"hi"}]}`)
+
+	// sources are tried in order
+	assert.Equal(t, "user:alice", SessionKey("default", header, body))
+	header.Set(SessionHeader, "session-1")
+	assert.Equal(t, "header:session-1", SessionKey("default", header, body))
+	assert.Equal(t, "header:session-1", SessionKey("default", header, []byte("not json")))
+
+	// groups without sources are not routed by session
+	assert.Empty(t, SessionKey("free", header, body))
+	// nor are requests without any of the sources
+	assert.Empty(t, SessionKey("default", http.Header{}, []byte(`{"model":"gpt-4o"}`)))
+
+	// the user of Claude Messages requests
+	claudeBody := []byte(`{"model":"claude-sonnet-4-0","metadata":{"user_id":"bob"},"messages":[]}`)
+	assert.Equal(t, "user:bob", SessionKey("default", http.Header{}, claudeBody))
+
+	assert.Contains(t, SessionKey("vip", header, body), "messages:")
+}
+
+func TestSessionKey_Messages(t *testing.T) {
+	setSessionSources(t, `{"default": ["messages"]}`)
+	key := func(body string) string {
+		return SessionKey("default", http.Header{}, []byte(body))
+	}
+
+	// every turn of a conversation has the same key
+	firstTurn := key(`{"messages":[{"role":"system","content":"be brief"},{"role":"user","content":"hi"}]}`)
+	secondTurn := key(`{"messages":[{"role":"system","content":"be brief"},{"role":"user","content":"hi"},` +
+		`{"role":"assistant","content":"hello"},{"role":"user","content":"how are you"}]}`)
+	require.NotEmpty(t, firstTurn)
+	assert.Equal(t, firstTurn, secondTurn)
+	assert.NotEqual(t, firstTurn, key(`{"messages":[{"role":"system","content":"be brief"},{"role":"user","content":"bye"}]}`))
+
+	// Claude Messages requests with their system prompt
+	claude := key(`{"system":"be brief","messages":[{"role":"user","content":"hi"}]}`)
+	assert.Equal(t, claude, key(`{"system":"be brief","messages":[{"role":"user","content":"hi"},{"role":"assistant","content":"hello"}]}`))
+	assert.NotEqual(t, claude, key(`{"system":"be verbose","messages":[{"role":"user","content":"hi"}]}`))
+
+	// Response API requests
+	input := key(`{"input":[{"role":"user","content":"hi"}]}`)
+	assert.Equal(t, input, key(`{"input":[{"role":"user","content":"hi"},{"role":"assistant","content":"hello"}]}`))
+	assert.NotEmpty(t, key(`{"input":"hi"}`))
+
+	assert.Empty(t, key(`{"prompt":"hi"}`))
+}
+
+func TestPickBySession(t *testing.T) {
+	candidates := []Candidate{{ChannelId: 1, Weight: 1}, {ChannelId: 2, Weight: 1}, {ChannelId: 3, Weight: 1}}
+	picked := make(map[string]int)
+	counts := make(map[int]int)
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("user:%d", i)
+		picked[key] = candidates[PickBySession(key, candidates)].ChannelId
+		counts[picked[key]]++
+		// the choice is stable
+		assert.Equal(t, picked[key], candidates[PickBySession(key, candidates)].ChannelId)
+	}
+	for _, channelId := range []int{1, 2, 3} {
+		assert.InDelta(t, 1000, counts[channelId], 150, "channel %d", channelId)
+	}
+
+	// removing a channel only moves the sessions which were on it
+	remaining := []Candidate{{ChannelId: 3, Weight: 1}, {ChannelId: 1, Weight: 1}}
+	for key, channelId := range picked {
+		moved := remaining[PickBySession(key, remaining)].ChannelId
+		if channelId != 2 {
+			assert.Equal(t, channelId, moved, key)
+		}
+	}
+
+	// sessions are spread by weight
+	weighted := []Candidate{{ChannelId: 1, Weight: 3}, {ChannelId: 2, Weight: 1}}
+	counts = make(map[int]int)
+	for i := 0; i < 4000; i++ {
+		counts[weighted[PickBySession(fmt.Sprintf("user:%d", i), weighted)].ChannelId]++
+	}
+	assert.InDelta(t, 3000, counts[1], 200)
+}
@@ -0,0 +1,180 @@
+package routing
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/config"
+)
+
+const (
+	// ewmaAlpha is the weight of the latest outcome in the moving averages
+	ewmaAlpha = 0.2
+	// successRateTolerance is how far below the best success rate a channel is still treated as healthy,
+	// so that traffic is spread over healthy channels instead of sticking to one of them
+	successRateTolerance = 0.05
+)
+
+// Candidate is a channel to choose from
+type Candidate struct {
+	ChannelId int
+	Weight    int64
+}
+
+type statsKey struct {
+	channelId int
+	model     string
+}
+
+// stats are the moving averages of the outcomes of a channel serving a model
+type stats struct {
+	latencyMs   float64
+	successRate float64
+	updatedAt   time.Time
+}
+
+// Tracker keeps the recent outcomes of channels and chooses channels by them.
+//
+// Stats fade toward the optimistic prior, which is no latency and full success, as they age,
+// so that channels which were slow or failing are probed again after a while.
+type Tracker struct {
+	sync.Mutex
+	halfLife time.Duration
+	now      func() time.Time
+	random   func(n int64) int64
+	inFlight map[int]int64
+	stats    map[statsKey]*stats
+}
+
+func NewTracker(halfLife time.Duration) *Tracker {
+	return &Tracker{
+		halfLife: halfLife,
+		now:      time.Now,
+		random:   rand.Int63n,
+		inFlight: make(map[int]int64),
+		stats:    make(map[statsKey]*stats),
+	}
+}
+
+// DefaultTracker is fed by the relay and used to choose channels
+var DefaultTracker = NewTracker(time.Duration(config.RoutingStatsHalfLife) * time.Second)
+
+// Start records that a request is sent to channelId
+func (t *Tracker) Start(channelId int) {
+	t.Lock()
+	defer t.Unlock()
+	t.inFlight[channelId]++
+}
+
+// Done records that a request started by Start has finished
+func (t *Tracker) Done(channelId int) {
+	t.Lock()
+	defer t.Unlock()
+	if t.inFlight[channelId] <= 1 {
+		delete(t.inFlight, channelId)
+		return
+	}
+	t.inFlight[channelId]--
+}
+
+// Observe records the outcome of a request of model served by channelId.
+// The latency of failed requests is ignored, since errors are often returned faster than responses.
+func (t *Tracker) Observe(channelId int, model string, success bool, latency time.Duration) {
+	t.Lock()
+	defer t.Unlock()
+	now := t.now()
+	key := statsKey{channelId: channelId, model: model}
+	s, ok := t.stats[key]
+	if !ok {
+		s = &stats{successRate: 1}
+		t.stats[key] = s
+	} else {
+		s.latencyMs, s.successRate = t.decay(s, now)
+	}
+	s.updatedAt = now
+
+	outcome := 0.0
+	if success {
+		outcome = 1
+		latencyMs := float64(latency) / float64(time.Millisecond)
+		if s.latencyMs == 0 {
+			s.latencyMs = latencyMs
+		} else {
+			s.latencyMs += ewmaAlpha * (latencyMs - s.latencyMs)
+		}
+	}
+	s.successRate += ewmaAlpha * (outcome - s.successRate)
+}
+
+// Select returns the index of the candidate to use for model by strategy.
+//
+// Every strategy first keeps the candidates whose success rate is close to the best one,
+// so that failing channels, which often answer fast and have few requests in flight, are avoided.
+// Candidates tied by the strategy are chosen at random by weight. candidates must not be empty.
+func (t *Tracker) Select(strategy string, model string, candidates []Candidate) int {
+	t.Lock()
+	defer t.Unlock()
+	now := t.now()
+
+	latencies := make([]float64, len(candidates))
+	successRates := make([]float64, len(candidates))
+	bestSuccessRate := 0.0
+	for i, candidate := range candidates {
+		latencies[i], successRates[i] = 0, 1
+		if s, ok := t.stats[statsKey{channelId: candidate.ChannelId, model: model}]; ok {
+			latencies[i], successRates[i] = t.decay(s, now)
+		}
+		bestSuccessRate = math.Max(bestSuccessRate, successRates[i])
+	}
+
+	// lower scores are better, the healthy candidates are tied under StrategySuccessRate
+	scores := make(map[int]float64)
+	for i, candidate := range candidates {
+		if successRates[i] < bestSuccessRate-successRateTolerance {
+			continue
+		}
+		switch strategy {
+		case StrategyLeastLatency:
+			scores[i] = latencies[i]
+		case StrategyLeastInFlight:
+			scores[i] = float64(t.inFlight[candidate.ChannelId]) / float64(max(candidate.Weight, 1))
+		default:
+			scores[i] = 0
+		}
+	}
+
+	best := math.Inf(1)
+	for _, score := range scores {
+		best = math.Min(best, score)
+	}
+	var tied []int
+	var totalWeight int64
+	for i := range candidates {
+		if score, ok := scores[i]; ok && score == best {
+			tied = append(tied, i)
+			totalWeight += max(candidates[i].Weight, 1)
+		}
+	}
+
+	n := t.random(totalWeight)
+	for _, i := range tied {
+		n -= max(candidates[i].Weight, 1)
+		if n < 0 {
+			return i
+		}
+	}
+
+	return tied[len(tied)-1]
+}
+
+// decay returns the stats faded by their age
+func (t *Tracker) decay(s *stats, now time.Time) (latencyMs float64, successRate float64) {
+	factor := 1.0
+	if age := now.Sub(s.updatedAt); age > 0 && t.halfLife > 0 {
+		factor = math.Exp2(-float64(age) / float64(t.halfLife))
+	}
+
+	return s.latencyMs * factor, 1 - (1-s.successRate)*factor
+}
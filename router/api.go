@@ -50,6 +50,9 @@ func SetApiRouter(router *gin.Engine) {
 				selfRoute.GET("/aff", controller.GetAffCode)
 				selfRoute.POST("/topup", controller.TopUp)
 				selfRoute.GET("/available_models", controller.GetUserAvailableModels)
+				selfRoute.GET("/spend", controller.GetSelfSpend)
+				selfRoute.GET("/plan", controller.GetSelfPlan)
+				selfRoute.GET("/plan/history", controller.GetSelfPlanHistory)
 				selfRoute.GET("/totp/status", controller.GetTotpStatus)
 				selfRoute.GET("/totp/setup", controller.SetupTotp)
 				selfRoute.POST("/totp/confirm", controller.ConfirmTotp)
@@ -87,10 +90,14 @@ func SetApiRouter(router *gin.Engine) {
 			channelRoute.GET("/update_balance", controller.UpdateAllChannelsBalance)
 			channelRoute.GET("/update_balance/:id", controller.UpdateChannelBalance)
 			channelRoute.GET("/pricing/:id", controller.GetChannelPricing)
+			channelRoute.GET("/keys/:id", controller.GetChannelKeys)
 			channelRoute.GET("/default-pricing", controller.GetChannelDefaultPricing)
+			channelRoute.GET("/circuits", controller.GetCircuits)
+			channelRoute.DELETE("/circuits", controller.ResetCircuit)
 			channelRoute.POST("/", controller.AddChannel)
 			channelRoute.PUT("/", controller.UpdateChannel)
 			channelRoute.PUT("/pricing/:id", controller.UpdateChannelPricing)
+			channelRoute.PUT("/keys/:id", controller.UpdateChannelKeyStatus)
 			channelRoute.DELETE("/disabled", controller.DeleteDisabledChannel)
 			channelRoute.DELETE("/:id", controller.DeleteChannel)
 		}
@@ -111,6 +118,7 @@ func SetApiRouter(router *gin.Engine) {
 			tokenRoute.GET("/", controller.GetAllTokens)
 			tokenRoute.GET("/search", controller.SearchTokens)
 			tokenRoute.GET("/:id", controller.GetToken)
+			tokenRoute.GET("/:id/spend", controller.GetTokenSpend)
 			tokenRoute.POST("/", controller.AddToken)
 			tokenRoute.PUT("/", controller.UpdateToken)
 			tokenRoute.DELETE("/:id", controller.DeleteToken)
@@ -130,6 +138,24 @@ func SetApiRouter(router *gin.Engine) {
 			redemptionRoute.PUT("/", controller.UpdateRedemption)
 			redemptionRoute.DELETE("/:id", controller.DeleteRedemption)
 		}
+		planRoute := apiRouter.Group("/plan")
+		planRoute.Use(middleware.AdminAuth())
+		{
+			planRoute.GET("/", controller.GetAllPlans)
+			planRoute.GET("/:id", controller.GetPlan)
+			planRoute.POST("/", controller.AddPlan)
+			planRoute.PUT("/", controller.UpdatePlan)
+			planRoute.DELETE("/:id", controller.DeletePlan)
+			planRoute.POST("/assign", controller.AssignPlan)
+			planRoute.GET("/user/:user_id", controller.GetUserPlan)
+			planRoute.GET("/user/:user_id/history", controller.GetUserPlanHistory)
+			planRoute.DELETE("/user/:user_id", controller.CancelUserPlan)
+		}
+		ledgerRoute := apiRouter.Group("/ledger")
+		{
+			ledgerRoute.GET("/", middleware.AdminAuth(), controller.GetLedgerEntries)
+			ledgerRoute.POST("/reconcile", middleware.RootAuth(), controller.ReconcileLedger)
+		}
 		logRoute := apiRouter.Group("/log")
 		logRoute.GET("/", middleware.AdminAuth(), controller.GetAllLogs)
 		logRoute.DELETE("/", middleware.AdminAuth(), controller.DeleteHistoryLogs)
@@ -17,18 +17,38 @@ func SetRelayRouter(router *gin.Engine) {
 		modelsRouter.GET("", controller.ListModels)
 		modelsRouter.GET("/:model", controller.RetrieveModel)
 	}
+	filesRouter := router.Group("/v1/files")
+	filesRouter.Use(middleware.RelayPanicRecover(), middleware.TokenAuth())
+	{
+		filesRouter.POST("", controller.UploadFile)
+		filesRouter.GET("", controller.ListFiles)
+		filesRouter.GET("/:id", controller.RetrieveFile)
+		filesRouter.DELETE("/:id", controller.DeleteFile)
+		filesRouter.GET("/:id/content", controller.RetrieveFileContent)
+	}
+	batchesRouter := router.Group("/v1/batches")
+	batchesRouter.Use(middleware.RelayPanicRecover(), middleware.TokenAuth())
+	{
+		batchesRouter.POST("", controller.CreateBatch)
+		batchesRouter.GET("", controller.ListBatches)
+		batchesRouter.GET("/:id", controller.RetrieveBatch)
+		batchesRouter.POST("/:id/cancel", controller.CancelBatch)
+	}
 	relayV1Router := router.Group("/v1")
 	relayV1Router.Use(middleware.RelayPanicRecover(), middleware.TokenAuth(), middleware.Distribute())
 	relayV1Router.Use(middleware.GlobalRelayRateLimit())
 	relayV1Router.Use(middleware.ChannelRateLimit())
+	relayV1Router.Use(middleware.UsageRateLimit())
 	{
 		relayV1Router.Any("/oneapi/proxy/:channelid/*target", controller.Relay)
 		relayV1Router.POST("/completions", controller.Relay)
 		relayV1Router.POST("/chat/completions", controller.Relay)
+		relayV1Router.POST("/messages", controller.Relay)
 		relayV1Router.POST("/responses", controller.Relay)
-		relayV1Router.GET("/responses/:response_id", controller.RelayNotImplemented)
-		relayV1Router.DELETE("/responses/:response_id", controller.RelayNotImplemented)
-		relayV1Router.POST("/responses/:response_id/cancel", controller.RelayNotImplemented)
+		relayV1Router.GET("/realtime", controller.Relay)
+		relayV1Router.GET("/responses/:response_id", controller.Relay)
+		relayV1Router.DELETE("/responses/:response_id", controller.Relay)
+		relayV1Router.POST("/responses/:response_id/cancel", controller.Relay)
 		relayV1Router.POST("/edits", controller.Relay)
 		relayV1Router.POST("/images/generations", controller.Relay)
 		relayV1Router.POST("/images/edits", controller.Relay)
@@ -39,43 +59,49 @@ func SetRelayRouter(router *gin.Engine) {
 		relayV1Router.POST("/audio/transcriptions", controller.Relay)
 		relayV1Router.POST("/audio/translations", controller.Relay)
 		relayV1Router.POST("/audio/speech", controller.Relay)
-		relayV1Router.GET("/files", controller.RelayNotImplemented)
-		relayV1Router.POST("/files", controller.RelayNotImplemented)
-		relayV1Router.DELETE("/files/:id", controller.RelayNotImplemented)
-		relayV1Router.GET("/files/:id", controller.RelayNotImplemented)
-		relayV1Router.GET("/files/:id/content", controller.RelayNotImplemented)
-		relayV1Router.POST("/fine_tuning/jobs", controller.RelayNotImplemented)
-		relayV1Router.GET("/fine_tuning/jobs", controller.RelayNotImplemented)
-		relayV1Router.GET("/fine_tuning/jobs/:id", controller.RelayNotImplemented)
-		relayV1Router.POST("/fine_tuning/jobs/:id/cancel", controller.RelayNotImplemented)
-		relayV1Router.GET("/fine_tuning/jobs/:id/events", controller.RelayNotImplemented)
+		relayV1Router.POST("/fine_tuning/jobs", controller.Relay)
+		relayV1Router.GET("/fine_tuning/jobs", controller.Relay)
+		relayV1Router.GET("/fine_tuning/jobs/:id", controller.Relay)
+		relayV1Router.POST("/fine_tuning/jobs/:id/cancel", controller.Relay)
+		relayV1Router.GET("/fine_tuning/jobs/:id/events", controller.Relay)
 		relayV1Router.DELETE("/models/:model", controller.RelayNotImplemented)
 		relayV1Router.POST("/moderations", controller.Relay)
-		relayV1Router.POST("/assistants", controller.RelayNotImplemented)
-		relayV1Router.GET("/assistants/:id", controller.RelayNotImplemented)
-		relayV1Router.POST("/assistants/:id", controller.RelayNotImplemented)
-		relayV1Router.DELETE("/assistants/:id", controller.RelayNotImplemented)
-		relayV1Router.GET("/assistants", controller.RelayNotImplemented)
-		relayV1Router.POST("/assistants/:id/files", controller.RelayNotImplemented)
-		relayV1Router.GET("/assistants/:id/files/:fileId", controller.RelayNotImplemented)
-		relayV1Router.DELETE("/assistants/:id/files/:fileId", controller.RelayNotImplemented)
-		relayV1Router.GET("/assistants/:id/files", controller.RelayNotImplemented)
-		relayV1Router.POST("/threads", controller.RelayNotImplemented)
-		relayV1Router.GET("/threads/:id", controller.RelayNotImplemented)
-		relayV1Router.POST("/threads/:id", controller.RelayNotImplemented)
-		relayV1Router.DELETE("/threads/:id", controller.RelayNotImplemented)
-		relayV1Router.POST("/threads/:id/messages", controller.RelayNotImplemented)
-		relayV1Router.GET("/threads/:id/messages/:messageId", controller.RelayNotImplemented)
-		relayV1Router.POST("/threads/:id/messages/:messageId", controller.RelayNotImplemented)
-		relayV1Router.GET("/threads/:id/messages/:messageId/files/:filesId", controller.RelayNotImplemented)
-		relayV1Router.GET("/threads/:id/messages/:messageId/files", controller.RelayNotImplemented)
-		relayV1Router.POST("/threads/:id/runs", controller.RelayNotImplemented)
-		relayV1Router.GET("/threads/:id/runs/:runsId", controller.RelayNotImplemented)
-		relayV1Router.POST("/threads/:id/runs/:runsId", controller.RelayNotImplemented)
-		relayV1Router.GET("/threads/:id/runs", controller.RelayNotImplemented)
-		relayV1Router.POST("/threads/:id/runs/:runsId/submit_tool_outputs", controller.RelayNotImplemented)
-		relayV1Router.POST("/threads/:id/runs/:runsId/cancel", controller.RelayNotImplemented)
-		relayV1Router.GET("/threads/:id/runs/:runsId/steps/:stepId", controller.RelayNotImplemented)
-		relayV1Router.GET("/threads/:id/runs/:runsId/steps", controller.RelayNotImplemented)
+		relayV1Router.POST("/assistants", controller.Relay)
+		relayV1Router.GET("/assistants/:id", controller.Relay)
+		relayV1Router.POST("/assistants/:id", controller.Relay)
+		relayV1Router.DELETE("/assistants/:id", controller.Relay)
+		relayV1Router.GET("/assistants", controller.Relay)
+		relayV1Router.POST("/assistants/:id/files", controller.Relay)
+		relayV1Router.GET("/assistants/:id/files/:fileId", controller.Relay)
+		relayV1Router.DELETE("/assistants/:id/files/:fileId", controller.Relay)
+		relayV1Router.GET("/assistants/:id/files", controller.Relay)
+		relayV1Router.POST("/threads", controller.Relay)
+		relayV1Router.POST("/threads/runs", controller.Relay)
+		relayV1Router.GET("/threads/:id", controller.Relay)
+		relayV1Router.POST("/threads/:id", controller.Relay)
+		relayV1Router.DELETE("/threads/:id", controller.Relay)
+		relayV1Router.POST("/threads/:id/messages", controller.Relay)
+		relayV1Router.GET("/threads/:id/messages/:messageId", controller.Relay)
+		relayV1Router.POST("/threads/:id/messages/:messageId", controller.Relay)
+		relayV1Router.GET("/threads/:id/messages/:messageId/files/:filesId", controller.Relay)
+		relayV1Router.GET("/threads/:id/messages/:messageId/files", controller.Relay)
+		relayV1Router.POST("/threads/:id/runs", controller.Relay)
+		relayV1Router.GET("/threads/:id/runs/:runsId", controller.Relay)
+		relayV1Router.POST("/threads/:id/runs/:runsId", controller.Relay)
+		relayV1Router.GET("/threads/:id/runs", controller.Relay)
+		relayV1Router.POST("/threads/:id/runs/:runsId/submit_tool_outputs", controller.Relay)
+		relayV1Router.POST("/threads/:id/runs/:runsId/cancel", controller.Relay)
+		relayV1Router.GET("/threads/:id/runs/:runsId/steps/:stepId", controller.Relay)
+		relayV1Router.GET("/threads/:id/runs/:runsId/steps", controller.Relay)
+	}
+	// https://ai.google.dev/api/generate-content
+	relayV1BetaRouter := router.Group("/v1beta")
+	relayV1BetaRouter.Use(middleware.RelayPanicRecover(), middleware.TokenAuth(), middleware.Distribute())
+	relayV1BetaRouter.Use(middleware.GlobalRelayRateLimit())
+	relayV1BetaRouter.Use(middleware.ChannelRateLimit())
+	relayV1BetaRouter.Use(middleware.UsageRateLimit())
+	{
+		// :model is in the form of {model}:generateContent or {model}:streamGenerateContent
+		relayV1BetaRouter.POST("/models/:model", controller.Relay)
 	}
 }